@@ -16,6 +16,7 @@
 package demo_test
 
 import (
+	"context"
 	"fmt"
 	"runtime"
 	"testing"
@@ -41,9 +42,26 @@ func TestNoMallocs(t *testing.T) {
 		t.Run(fmt.Sprintf("%+v", tc), func(t *testing.T) {
 			a := assert.New(t)
 			x, _ := demo.NewContainer(tc.valuePtrs)
-			testNoMallocs(a, x, tc.topLevel)
+			testNoMallocs(a, func(fn demo.TargetWalkerFn) (err error) {
+				if tc.topLevel {
+					_, _, err = demo.WalkTarget(x, fn)
+				} else {
+					_, _, err = x.WalkTarget(fn)
+				}
+				return
+			})
 		})
 	}
+
+	// A context which can never be cancelled should not add any cost.
+	t.Run("context", func(t *testing.T) {
+		a := assert.New(t)
+		x, _ := demo.NewContainer(false)
+		testNoMallocs(a, func(fn demo.TargetWalkerFn) (err error) {
+			_, _, err = demo.WalkTargetCtx(context.Background(), x, fn)
+			return
+		})
+	})
 }
 
 // BenchmarkNoop should demonstrate that visitations are allocation-free.
@@ -66,6 +84,172 @@ func BenchmarkNoop(b *testing.B) {
 	}
 }
 
+// BenchmarkParallel compares serial and parallel visitation of a
+// function call with many arguments.
+func BenchmarkParallel(b *testing.B) {
+	args := make([]demo.Expr, 64)
+	for i := range args {
+		inner := make([]demo.Expr, 64)
+		for j := range inner {
+			inner[j] = &demo.Scalar{}
+		}
+		args[i] = &demo.Func{Fn: "Inner", Args: inner}
+	}
+	c := &demo.Calculation{Expr: &demo.Func{Fn: "Outer", Args: args}}
+
+	for _, parallel := range []bool{false, true} {
+		b.Run(fmt.Sprintf("parallel=%t", parallel), func(b *testing.B) {
+			// Only the outermost function's arguments are visited in
+			// parallel, so that each goroutine has a useful amount of work.
+			fn := func(ctx demo.CalcContext, x demo.Calc) demo.CalcDecision {
+				if parallel && ctx.Depth() < 2 {
+					return ctx.Parallel()
+				}
+				return ctx.Continue()
+			}
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, _, err := demo.WalkCalc(c, fn); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkAbstract compares a read-only walk of a wide abstract tree
+// using TargetAt with one using TargetEach.
+func BenchmarkAbstract(b *testing.B) {
+	x, _ := demo.NewContainer(true)
+	x.ByRefSlice = make([]demo.ByRefType, 1024)
+
+	b.Run("ChildAt", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			abstractWalk(x)
+		}
+	})
+
+	var eachWalk func(idx int, x demo.TargetAbstract) bool
+	eachWalk = func(_ int, x demo.TargetAbstract) bool {
+		if x != nil {
+			x.TargetEach(eachWalk)
+		}
+		return true
+	}
+	b.Run("EachChild", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			eachWalk(0, x)
+		}
+	})
+}
+
+// BenchmarkAlloc compares a rewrite which allocates new structs with
+// one which reuses the structs from a previous, discarded result.
+func BenchmarkAlloc(b *testing.B) {
+	const depth = 32
+	var x *demo.ContainerType
+	for i := 0; i < depth; i++ {
+		x = &demo.ContainerType{Container: x}
+	}
+	replacement := &demo.ByRefType{Val: "replaced"}
+	fn := func(ctx demo.TargetContext, x demo.Target) (d demo.TargetDecision) {
+		if _, ok := x.(*demo.ByRefType); ok {
+			d = d.Replace(replacement)
+		}
+		return
+	}
+
+	b.Run("new", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if _, _, err := demo.WalkTarget(x, fn); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+
+	b.Run("reused", func(b *testing.B) {
+		// Each result is discarded, so its structs may be reused by the
+		// next iteration.
+		free := make(map[demo.TargetTypeID][]demo.Target)
+		used := make(map[demo.TargetTypeID][]demo.Target)
+		alloc := func(id demo.TargetTypeID) demo.Target {
+			list := free[id]
+			if len(list) == 0 {
+				return nil
+			}
+			ret := list[len(list)-1]
+			free[id] = list[:len(list)-1]
+			used[id] = append(used[id], ret)
+			return ret
+		}
+		// Prime the free lists with the structs from one result.
+		for i := 0; i < depth; i++ {
+			free[demo.TargetTypeContainerType] = append(free[demo.TargetTypeContainerType], &demo.ContainerType{})
+		}
+
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if _, _, err := demo.WalkTargetAlloc(x, alloc, fn); err != nil {
+				b.Fatal(err)
+			}
+			for id, list := range used {
+				free[id] = append(free[id], list...)
+				used[id] = list[:0]
+			}
+		}
+	})
+}
+
+// BenchmarkDeepRebuild measures the allocations made while rebuilding
+// a deep tree, along with the peak growth of the live heap. The heap is
+// sampled from post-visit functions, so it reflects any intermediate
+// values which are retained during the unwind.
+func BenchmarkDeepRebuild(b *testing.B) {
+	const depth = 1000
+	var x *demo.ContainerType
+	for i := 0; i < depth; i++ {
+		x = &demo.ContainerType{Container: x, ByRefSlice: make([]demo.ByRefType, 64)}
+	}
+
+	var stats runtime.MemStats
+	var peak uint64
+	posts := 0
+	post := func(ctx demo.TargetContext, x demo.Target) (d demo.TargetDecision) {
+		// Reading the stats stops the world, so we only sample them.
+		if posts++; posts%100 == 0 {
+			runtime.ReadMemStats(&stats)
+			if stats.HeapAlloc > peak {
+				peak = stats.HeapAlloc
+			}
+		}
+		return
+	}
+	fn := func(ctx demo.TargetContext, x demo.Target) demo.TargetDecision {
+		if _, ok := x.(*demo.ContainerType); ok {
+			return ctx.Continue().Post(post)
+		}
+		return ctx.Continue()
+	}
+
+	runtime.GC()
+	runtime.ReadMemStats(&stats)
+	base := stats.HeapAlloc
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := demo.WalkTargetRebuild(x, fn); err != nil {
+			b.Fatal(err)
+		}
+	}
+	if peak > base {
+		b.ReportMetric(float64(peak-base), "peak-B")
+	}
+}
+
 func bench(b *testing.B, x *demo.ContainerType, topLevel bool) {
 	b.Helper()
 	b.ReportAllocs()
@@ -89,7 +273,7 @@ func bench(b *testing.B, x *demo.ContainerType, topLevel bool) {
 // This runs in a loop until we have demonstrated that no mallocs
 // occur, or a timeout occurs. This allows us to account for any
 // other threads that may be running.
-func testNoMallocs(a *assert.Assertions, x *demo.ContainerType, topLevel bool) {
+func testNoMallocs(a *assert.Assertions, walk func(fn demo.TargetWalkerFn) error) {
 	stats := runtime.MemStats{}
 	timer := time.NewTimer(1 * time.Second)
 	fn := func(ctx demo.TargetContext, x demo.Target) (ret demo.TargetDecision) { return }
@@ -100,15 +284,10 @@ func testNoMallocs(a *assert.Assertions, x *demo.ContainerType, topLevel bool) {
 			a.Fail("timeout")
 			return
 		default:
-			var err error
 			runtime.ReadMemStats(&stats)
 			memBefore := stats.Mallocs
 
-			if topLevel {
-				_, _, err = demo.WalkTarget(x, fn)
-			} else {
-				_, _, err = x.WalkTarget(fn)
-			}
+			err := walk(fn)
 			runtime.ReadMemStats(&stats)
 
 			a.NoError(err)
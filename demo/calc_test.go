@@ -17,6 +17,7 @@ package demo
 
 import (
 	"fmt"
+	"reflect"
 	"strconv"
 	"strings"
 )
@@ -25,7 +26,7 @@ import (
 // are reachable from the Calculation struct and create a
 // Calc interface to unify them.
 //go:generate -command walkabout go run ..
-//go:generate walkabout --union Calc --reachable Calculation
+//go:generate walkabout --union Calc --reachable --verify-layout --visitor CalcVisitor Calculation
 
 // This example shows a toy calculator AST and how custom actions can be
 // introduced into the visitation flow. We've decided to use a visitor
@@ -91,6 +92,175 @@ func Example_actions() {
 	//Avg(1+3, Sum(10, Random(1, 10), 99), 5*3)
 }
 
+// This example renders the same calculation as above by registering a
+// formatter for each type. The walk takes care of the traversal, so
+// each formatter only needs to combine the output of its children.
+func Example_format() {
+	c := &Calculation{
+		Expr: &Func{"Avg", []Expr{
+			&BinaryOp{"+", &Scalar{1}, &Scalar{3}},
+			&Func{"Sum", []Expr{&Scalar{10}, &Scalar{99}}},
+			&BinaryOp{"*", &Scalar{5}, &Scalar{3}},
+		}},
+	}
+
+	formatters := map[CalcTypeID]CalcFormatter{
+		CalcTypeBinaryOp: func(x Calc, children []string) string {
+			return children[0] + x.(*BinaryOp).Operator + children[1]
+		},
+		CalcTypeFunc: func(x Calc, children []string) string {
+			return x.(*Func).Fn + "(" + strings.Join(children, ", ") + ")"
+		},
+		CalcTypeScalar: func(x Calc, _ []string) string {
+			return strconv.Itoa(x.(*Scalar).val)
+		},
+	}
+	fmt.Println(FormatCalc(c, formatters))
+
+	// Types without a formatter fall back to a default representation.
+	fmt.Println(FormatCalc(c, nil))
+
+	//Output:
+	//Calculation(Avg(1+3, Sum(10, 99), 5*3))
+	//Calculation(Func(BinaryOp(Scalar, Scalar), Func(Scalar, Scalar), BinaryOp(Scalar, Scalar)))
+}
+
+// This example drives a go/ast-style visitor, which returns the visitor
+// to use for the children of each value, or nil to skip them. Once
+// the children have been visited, their visitor receives a nil value.
+func Example_visitor() {
+	c := &Calculation{
+		Expr: &Func{"Avg", []Expr{
+			&BinaryOp{"+", &Scalar{1}, &Scalar{3}},
+			&Func{"Random", []Expr{&Scalar{1}, &Scalar{10}}},
+		}},
+	}
+
+	if err := WalkCalcCalcVisitor(c, indenter("")); err != nil {
+		panic(err)
+	}
+
+	//Output:
+	//*demo.Calculation {
+	//. *demo.Func {
+	//. . *demo.BinaryOp {
+	//. . . *demo.Scalar {
+	//. . . }
+	//. . . *demo.Scalar {
+	//. . . }
+	//. . }
+	//. . *demo.Func
+	//. }
+	//}
+}
+
+// This example reuses a single callback to sum the scalars in several
+// calculations. The running total is passed through each walk, so the
+// callback doesn't need to capture any variables.
+func Example_accumulator() {
+	calcs := []*Calculation{
+		{Expr: &BinaryOp{"+", &Scalar{1}, &Scalar{3}}},
+		{Expr: &Func{"Sum", []Expr{&Scalar{10}, &Scalar{99}}}},
+	}
+
+	for _, c := range calcs {
+		var sum int
+		if _, _, err := WalkCalcWith(c, &sum, sumScalars); err != nil {
+			panic(err)
+		}
+		fmt.Println(sum)
+	}
+
+	//Output:
+	//4
+	//109
+}
+
+// sumScalars adds the value of each Scalar to the *int provided as
+// the walk's user data.
+func sumScalars(ctx CalcContext, x Calc) CalcDecision {
+	if s, ok := x.(*Scalar); ok {
+		*ctx.Value().(*int) += s.val
+	}
+	return ctx.Continue()
+}
+
+// This example folds constant expressions from the bottom up. Each
+// BinaryOp is visited after its operands, which have already been
+// folded into Scalars where possible.
+func Example_postOrder() {
+	c := &Calculation{
+		Expr: &Func{"Avg", []Expr{
+			&BinaryOp{"*", &BinaryOp{"+", &Scalar{1}, &Scalar{3}}, &Scalar{5}},
+			&Func{"Random", []Expr{&Scalar{1}, &Scalar{10}}},
+		}},
+	}
+
+	ret, _, err := WalkCalcPostOrder(c, func(ctx CalcContext, x Calc) CalcDecision {
+		if op, ok := x.(*BinaryOp); ok {
+			left, leftOk := op.Left.(*Scalar)
+			right, rightOk := op.Right.(*Scalar)
+			if leftOk && rightOk {
+				switch op.Operator {
+				case "+":
+					return ctx.Continue().Replace(&Scalar{left.val + right.val})
+				case "*":
+					return ctx.Continue().Replace(&Scalar{left.val * right.val})
+				}
+			}
+		}
+		return ctx.Continue()
+	})
+	if err != nil {
+		panic(err)
+	}
+
+	args := ret.(*Calculation).Expr.(*Func).Args
+	fmt.Println(args[0].(*Scalar).val)
+	fmt.Println(len(args[1].(*Func).Args))
+	fmt.Println(c.Expr.(*Func).Args[0].(*BinaryOp).Operator)
+
+	//Output:
+	//20
+	//2
+	//*
+}
+
+// The code for Calc is generated with --verify-layout, so the layout of
+// each struct is checked when the package is initialized. This example
+// shows how a field whose type has changed would be reported.
+func Example_verifyLayout() {
+	defer func() {
+		fmt.Println(recover())
+	}()
+	calcVerifyLayout(Func{}, "Func", "Args", reflect.TypeOf((*Expr)(nil)).Elem())
+
+	//Output:
+	//Func.Args has type []demo.Expr, not demo.Expr; the generated code is out of date
+}
+
+// CalcVisitor follows the protocol of go/ast.Visitor.
+type CalcVisitor interface {
+	Visit(x Calc) CalcVisitor
+}
+
+// indenter prints the type of each value, indented by its depth. It
+// doesn't descend into calls to Random.
+type indenter string
+
+func (i indenter) Visit(x Calc) CalcVisitor {
+	if x == nil {
+		fmt.Printf("%s}\n", i[2:])
+		return nil
+	}
+	if fn, ok := x.(*Func); ok && fn.Fn == "Random" {
+		fmt.Printf("%s%T\n", i, x)
+		return nil
+	}
+	fmt.Printf("%s%T {\n", i, x)
+	return i + ". "
+}
+
 type Calculation struct{ Expr Expr }
 
 type Expr interface {
@@ -4,7 +4,13 @@
 package demo
 
 import (
+	"context"
 	"fmt"
+	"io"
+	"reflect"
+	"runtime"
+	"strings"
+	"sync"
 	"unsafe"
 
 	e "github.com/cockroachdb/walkabout/engine"
@@ -15,6 +21,18 @@ import (
 // CalcTypeID is a lightweight type token.
 type CalcTypeID e.TypeID
 
+// CalcImplementors returns the type tokens of all struct types
+// which implement Calc. The returned slice may be modified by
+// the caller.
+func CalcImplementors() []CalcTypeID {
+	return []CalcTypeID{
+		CalcTypeBinaryOp,
+		CalcTypeCalculation,
+		CalcTypeFunc,
+		CalcTypeScalar,
+	}
+}
+
 // CalcAbstract allows users to treat a Calc as an abstract
 // tree of nodes. All visitable struct types will have generated methods
 // which implement this interface.
@@ -26,11 +44,30 @@ type CalcAbstract interface {
 	// is non-nil. If the child is a slice type, a CalcAbstract wrapper
 	// around the slice will be returned.
 	CalcAt(index int) CalcAbstract
+	// CalcNamed returns the named field of a struct, following
+	// the same rules as CalcAt. It returns nil if the value is
+	// not a struct or has no visitable field of that name. Since the
+	// fields are scanned linearly, it should not be used in hot loops.
+	CalcNamed(name string) CalcAbstract
+	// CalcEach invokes fn with each child, following the same
+	// rules as CalcAt, until fn returns false. The children of
+	// slices and arrays are presented through a reused wrapper, so
+	// the child must not be retained after fn returns.
+	CalcEach(fn func(index int, child CalcAbstract) bool)
+	// CalcFieldNameAt returns a label for the child at the given
+	// index: the name of a struct field, the name of a getter followed
+	// by "()", the bracketed index of a slice element, or the bracketed
+	// key of a map entry. It panics if the index is out of range.
+	CalcFieldNameAt(index int) string
 	// CalcCount returns the number of visitable fields in a struct,
 	// or the length of a slice.
 	CalcCount() int
 	// CalcTypeID returns a type token.
 	CalcTypeID() CalcTypeID
+	// CalcWalk visits the value with the provided callback and
+	// returns a CalcAbstract around the updated value. The updated
+	// value will always be of the same type as the original value.
+	CalcWalk(fn CalcWalkerFn) (_ CalcAbstract, changed bool, err error)
 }
 
 var (
@@ -81,6 +118,13 @@ func (c *CalcContext) Continue() CalcDecision {
 	return CalcDecision(c.impl.Continue())
 }
 
+// Depth returns the number of visitable structs which enclose the
+// value being visited. The value passed to a Walk function has a
+// depth of zero.
+func (c *CalcContext) Depth() int {
+	return c.impl.Depth()
+}
+
 // Error returns a CalcDecision which will cause the given error
 // to be returned from the Walk() function. Post-visit functions
 // will not be called.
@@ -94,268 +138,2527 @@ func (c *CalcContext) Halt() CalcDecision {
 	return CalcDecision(c.impl.Halt())
 }
 
-// Skip will not traverse the fields of the current object.
-func (c *CalcContext) Skip() CalcDecision {
-	return CalcDecision(c.impl.Skip())
+// Parallel will visit the fields of the current object concurrently,
+// each on its own goroutine. A field which is a slice or array will
+// have its elements visited concurrently as well. The visitor may
+// therefore be called from multiple goroutines at once, and the order
+// of any side-effects is not guaranteed. No more than GOMAXPROCS
+// goroutines are forked by a single walk; once they are all busy, the
+// remaining values are visited without forking. Halting or restarting while
+// visiting one field will not affect the visitation of the others.
+// Replacements are applied once all of the fields have been visited.
+// Parallel is ignored by WalkCalcMemo and WalkCalcOnce.
+func (c *CalcContext) Parallel() CalcDecision {
+	return CalcDecision(c.impl.Parallel())
+}
+
+// Parent returns the nearest visitable struct which encloses the value
+// being visited. It returns false for the value passed to a Walk
+// function. Since the enclosing values are only rebuilt once all of
+// their children have been visited, this is always the original
+// parent, without any replacements made by the current visitation.
+func (c *CalcContext) Parent() (Calc, bool) {
+	id, ptr := c.impl.Parent()
+	if id == 0 {
+		return nil, false
+	}
+	return calcWrap(id, ptr), true
+}
+
+// Path returns the location of the value being visited, relative to
+// the value passed to the function which started the visitation. The
+// path is constructed on demand.
+func (c *CalcContext) Path() CalcPath {
+	return c.impl.Path()
+}
+
+// ReplaceChildren will perform the given actions in place of visiting
+// the elements of a slice and will then replace the elements with the
+// values visited by the actions. This allows elements to be inserted
+// or removed. It may only be returned for a struct whose only
+// visitable field is a slice; any other struct will cause the walk
+// to return an error. Actions which invoke a callback do not
+// contribute an element, and an empty list of actions will leave the
+// slice empty.
+func (c *CalcContext) ReplaceChildren(actions ...CalcAction) CalcDecision {
+	ret := make([]e.Action, len(actions))
+	for i, a := range actions {
+		ret[i] = e.Action(a)
+	}
+
+	return CalcDecision(c.impl.ReplaceChildren(ret))
+}
+
+// Skip will not traverse the fields of the current object.
+func (c *CalcContext) Skip() CalcDecision {
+	return CalcDecision(c.impl.Skip())
+}
+
+// State returns the value passed to WalkCalcState or
+// WalkCalcWith, or nil if the visitation was started by another
+// function.
+func (c *CalcContext) State() interface{} {
+	return c.impl.State()
+}
+
+// Value returns the value passed to WalkCalcWith. It is an
+// alias for State.
+func (c *CalcContext) Value() interface{} {
+	return c.impl.State()
+}
+
+// CalcPath describes the location of a value, relative to the root
+// of a visitation. Pointers and interfaces do not contribute segments.
+type CalcPath = e.Path
+
+// CalcPathSegment describes a step from a value to a struct field,
+// a slice or array element, or a map entry.
+type CalcPathSegment = e.PathSegment
+
+// CalcDecision is used by CalcWalkerFn to control visitation.
+// The CalcContext provided to a CalcWalkerFn acts as a factory
+// for CalcDecision instances. In general, the factory methods
+// choose a traversal strategy and additional methods on the
+// CalcDecision can achieve a variety of side-effects.
+type CalcDecision e.Decision
+
+// Intercept registers a function to be called immediately before
+// visiting each field or element of the current value.
+func (d CalcDecision) Intercept(fn CalcWalkerFn) CalcDecision {
+	return CalcDecision((e.Decision)(d).Intercept(fn))
+}
+
+// InterceptNamed is like Intercept, except that the function also
+// receives the location of each value relative to the current value,
+// e.g. "Args[1]" for an element of a slice-valued field.
+func (d CalcDecision) InterceptNamed(fn func(ctx CalcContext, name string, x Calc) CalcDecision) CalcDecision {
+	return CalcDecision((e.Decision)(d).InterceptNamed(CalcWalkerFn(func(ctx CalcContext, x Calc) CalcDecision {
+		return fn(ctx, ctx.impl.Intercepted().String(), x)
+	})))
+}
+
+// Post registers a post-visit function, which will be called after the
+// fields of the current object. The function will see any replacements
+// made to the fields, and it can make another decision about the
+// current value.
+func (d CalcDecision) Post(fn CalcWalkerFn) CalcDecision {
+	return CalcDecision((e.Decision)(d).Post(fn))
+}
+
+// Replace allows the currently-visited value to be replaced. All
+// parent nodes will be cloned.
+func (d CalcDecision) Replace(x Calc) CalcDecision {
+	return CalcDecision((e.Decision)(d).Replace(calcIdentify(x)))
+}
+
+// ReplaceInPlace overwrites the currently-visited value with x, which
+// must be of the same type. Unlike Replace, parent nodes are not cloned,
+// so the change will be visible to every holder of the value. An error
+// will be returned if the value is not stored in mutable memory, such
+// as when it is held by value in an interface or returned by a getter.
+func (d CalcDecision) ReplaceInPlace(x Calc) CalcDecision {
+	return CalcDecision((e.Decision)(d).ReplaceInPlace(calcIdentify(x)))
+}
+
+// ReplaceWith replaces the slice element which holds the current value
+// with any number of values, which must not be nil. Providing no values
+// removes the element. The fields of the current value, and of the
+// replacements, will not be visited. The walk will return an error
+// unless the current value is an element of a slice, or is referred to
+// by one through pointers or interfaces.
+func (d CalcDecision) ReplaceWith(xs ...Calc) CalcDecision {
+	ids := make([]e.TypeID, len(xs))
+	ptrs := make([]e.Ptr, len(xs))
+	for i, x := range xs {
+		ids[i], ptrs[i] = calcIdentify(x)
+	}
+	return CalcDecision((e.Decision)(d).ReplaceWith(ids, ptrs))
+}
+
+// Restart may be combined with Replace to abandon the visitation once
+// the value has been replaced and to visit the updated top-level value
+// again from the beginning. This is useful when a replacement
+// invalidates decisions which were made about enclosing values. Pending
+// post-visit functions will not be called. Restart has no effect unless
+// the value is replaced, or if the visitation is halted. An error will
+// be returned if a visitation is restarted more than 1000 times.
+func (d CalcDecision) Restart() CalcDecision {
+	return CalcDecision((e.Decision)(d).Restart())
+}
+
+// calcIdentify is a utility function to map a Calc into
+// its generated type id and a pointer to the data. The type is found
+// in a table, rather than by a type switch, so the cost does not grow
+// with the number of implementations.
+func calcIdentify(x Calc) (typeId e.TypeID, data e.Ptr) {
+	fn, ok := calcIdentifiers[reflect.TypeOf(x)]
+	if !ok {
+		// The most probable reason for this is that the generated code
+		// is out of date, or that an implementation of the Calc
+		// interface from another package is being passed in.
+		panic(fmt.Sprintf("unhandled value of type: %T", x))
+	}
+	return fn(x)
+}
+
+// calcIdentifiers maps the dynamic type of a Calc to a
+// function which returns its type token and a pointer to its data.
+var calcIdentifiers = map[reflect.Type]func(x Calc) (e.TypeID, e.Ptr){
+	reflect.TypeOf((*BinaryOp)(nil)): func(x Calc) (e.TypeID, e.Ptr) {
+		return e.TypeID(CalcTypeBinaryOp), e.Ptr(x.(*BinaryOp))
+	},
+	reflect.TypeOf((*Calculation)(nil)): func(x Calc) (e.TypeID, e.Ptr) {
+		return e.TypeID(CalcTypeCalculation), e.Ptr(x.(*Calculation))
+	},
+	reflect.TypeOf((*Func)(nil)): func(x Calc) (e.TypeID, e.Ptr) {
+		return e.TypeID(CalcTypeFunc), e.Ptr(x.(*Func))
+	},
+	reflect.TypeOf((*Scalar)(nil)): func(x Calc) (e.TypeID, e.Ptr) {
+		return e.TypeID(CalcTypeScalar), e.Ptr(x.(*Scalar))
+	},
+}
+
+// calcWrap is a utility function to reconstitute a Calc
+// from an internal type token and a pointer to the value.
+func calcWrap(typeId e.TypeID, x e.Ptr) Calc {
+	if typeId >= 0 && int(typeId) < len(calcWrappers) {
+		if fn := calcWrappers[typeId]; fn != nil {
+			return fn(x)
+		}
+	}
+	// This is likely a code-generation problem.
+	panic(fmt.Sprintf("unhandled TypeID %d", typeId))
+}
+
+// calcWrappers holds a function for each type token which
+// reconstitutes a Calc from a pointer to the value.
+var calcWrappers = []func(x e.Ptr) Calc{
+	CalcTypeBinaryOp:       func(x e.Ptr) Calc { return (*BinaryOp)(x) },
+	CalcTypeBinaryOpPtr:    func(x e.Ptr) Calc { return *(**BinaryOp)(x) },
+	CalcTypeCalculation:    func(x e.Ptr) Calc { return (*Calculation)(x) },
+	CalcTypeCalculationPtr: func(x e.Ptr) Calc { return *(**Calculation)(x) },
+	CalcTypeFunc:           func(x e.Ptr) Calc { return (*Func)(x) },
+	CalcTypeFuncPtr:        func(x e.Ptr) Calc { return *(**Func)(x) },
+	CalcTypeScalar:         func(x e.Ptr) Calc { return (*Scalar)(x) },
+	CalcTypeScalarPtr:      func(x e.Ptr) Calc { return *(**Scalar)(x) },
+}
+
+// CalcAction is used by CalcContext.Actions() and allows users
+// to have fine-grained control over traversal.
+type CalcAction e.Action
+
+// ActionVisit constructs a CalcAction that will visit the given value.
+func (c *CalcContext) ActionVisit(x Calc) CalcAction {
+	return CalcAction(c.impl.ActionVisitTypeID(calcIdentify(x)))
+}
+
+// ActionCall constructs a CalcAction that will invoke the given callback.
+func (c *CalcContext) ActionCall(fn func() error) CalcAction {
+	return CalcAction(c.impl.ActionCall(fn))
+}
+
+// ------ Cloning ------
+
+// CloneCalc returns a deep copy of the receiver, which will not
+// share any visitable memory with it, except for back-references which
+// form cycles and values registered with SetCalcInterned.
+// Non-visitable fields are copied shallowly. A nil receiver returns nil.
+func (x *BinaryOp) CloneCalc() *BinaryOp {
+	if x == nil {
+		return nil
+	}
+	fn := CalcWalkerFn(func(ctx CalcContext, _ Calc) CalcDecision {
+		return ctx.Continue()
+	})
+	_, y, _, err := calcEngine.Execute(fn, e.TypeID(CalcTypeBinaryOp), e.Ptr(x), e.TypeID(CalcTypeBinaryOp), e.WithRebuild())
+	if err != nil {
+		// The callback makes no changes, so this indicates a problem
+		// with code-generation.
+		panic(err)
+	}
+	return (*BinaryOp)(y)
+}
+
+// CloneCalc returns a deep copy of the receiver, which will not
+// share any visitable memory with it, except for back-references which
+// form cycles and values registered with SetCalcInterned.
+// Non-visitable fields are copied shallowly. A nil receiver returns nil.
+func (x *Calculation) CloneCalc() *Calculation {
+	if x == nil {
+		return nil
+	}
+	fn := CalcWalkerFn(func(ctx CalcContext, _ Calc) CalcDecision {
+		return ctx.Continue()
+	})
+	_, y, _, err := calcEngine.Execute(fn, e.TypeID(CalcTypeCalculation), e.Ptr(x), e.TypeID(CalcTypeCalculation), e.WithRebuild())
+	if err != nil {
+		// The callback makes no changes, so this indicates a problem
+		// with code-generation.
+		panic(err)
+	}
+	return (*Calculation)(y)
+}
+
+// CloneCalc returns a deep copy of the receiver, which will not
+// share any visitable memory with it, except for back-references which
+// form cycles and values registered with SetCalcInterned.
+// Non-visitable fields are copied shallowly. A nil receiver returns nil.
+func (x *Func) CloneCalc() *Func {
+	if x == nil {
+		return nil
+	}
+	fn := CalcWalkerFn(func(ctx CalcContext, _ Calc) CalcDecision {
+		return ctx.Continue()
+	})
+	_, y, _, err := calcEngine.Execute(fn, e.TypeID(CalcTypeFunc), e.Ptr(x), e.TypeID(CalcTypeFunc), e.WithRebuild())
+	if err != nil {
+		// The callback makes no changes, so this indicates a problem
+		// with code-generation.
+		panic(err)
+	}
+	return (*Func)(y)
+}
+
+// CloneCalc returns a deep copy of the receiver, which will not
+// share any visitable memory with it, except for back-references which
+// form cycles and values registered with SetCalcInterned.
+// Non-visitable fields are copied shallowly. A nil receiver returns nil.
+func (x *Scalar) CloneCalc() *Scalar {
+	if x == nil {
+		return nil
+	}
+	fn := CalcWalkerFn(func(ctx CalcContext, _ Calc) CalcDecision {
+		return ctx.Continue()
+	})
+	_, y, _, err := calcEngine.Execute(fn, e.TypeID(CalcTypeScalar), e.Ptr(x), e.TypeID(CalcTypeScalar), e.WithRebuild())
+	if err != nil {
+		// The callback makes no changes, so this indicates a problem
+		// with code-generation.
+		panic(err)
+	}
+	return (*Scalar)(y)
+}
+
+// ------ Type Enhancements ------
+
+// calcAbstract is a type-safe facade around e.Abstract.
+type calcAbstract struct {
+	delegate *e.Abstract
+}
+
+var _ CalcAbstract = &calcAbstract{}
+
+// CalcAt implements CalcAbstract.
+func (a *calcAbstract) CalcAt(index int) CalcAbstract {
+	return calcAbstractOf(a.delegate.ChildAt(index))
+}
+
+// CalcNamed implements CalcAbstract.
+func (a *calcAbstract) CalcNamed(name string) CalcAbstract {
+	impl, _ := a.delegate.ChildNamed(name)
+	return calcAbstractOf(impl)
+}
+
+// CalcEach implements CalcAbstract.
+func (a *calcAbstract) CalcEach(fn func(index int, child CalcAbstract) bool) {
+	if a.delegate.NumChildren() == 0 {
+		return
+	}
+	var scratch calcAbstract
+	a.delegate.Children(func(index int, child *e.Abstract) bool {
+		return fn(index, calcAbstractOfReusing(child, &scratch))
+	})
+}
+
+// CalcFieldNameAt implements CalcAbstract.
+func (a *calcAbstract) CalcFieldNameAt(index int) string {
+	return a.delegate.FieldNameAt(index)
+}
+
+// CalcCount implements CalcAbstract.
+func (a *calcAbstract) CalcCount() int {
+	return a.delegate.NumChildren()
+}
+
+// CalcTypeID implements CalcAbstract.
+func (a *calcAbstract) CalcTypeID() CalcTypeID {
+	return CalcTypeID(a.delegate.TypeID())
+}
+
+// CalcWalk implements CalcAbstract.
+func (a *calcAbstract) CalcWalk(fn CalcWalkerFn) (_ CalcAbstract, changed bool, err error) {
+	id := a.delegate.TypeID()
+	id, ptr, changed, err := calcEngine.Execute(fn, id, a.delegate.Ptr(), id)
+	if err != nil {
+		return nil, false, err
+	}
+	if changed {
+		return calcAbstractOf(calcEngine.Abstract(id, ptr)), true, nil
+	}
+	return a, false, nil
+}
+
+// calcAbstractOf returns the most specific CalcAbstract around
+// the given value. Structs are returned as-is, while slices and arrays
+// are wrapped in a type-safe facade.
+func calcAbstractOf(impl *e.Abstract) (ret CalcAbstract) {
+	return calcAbstractOfReusing(impl, nil)
+}
+
+// calcAbstractOfReusing is like calcAbstractOf, but will store
+// slices and arrays in the scratch facade if it is non-nil, instead of
+// allocating a new one.
+func calcAbstractOfReusing(impl *e.Abstract, scratch *calcAbstract) (ret CalcAbstract) {
+	if impl == nil {
+		return nil
+	}
+	switch CalcTypeID(impl.TypeID()) {
+	case CalcTypeBinaryOp:
+		ret = (*BinaryOp)(impl.Ptr())
+	case CalcTypeBinaryOpPtr:
+		ret = *(**BinaryOp)(impl.Ptr())
+	case CalcTypeCalculation:
+		ret = (*Calculation)(impl.Ptr())
+	case CalcTypeCalculationPtr:
+		ret = *(**Calculation)(impl.Ptr())
+	case CalcTypeFunc:
+		ret = (*Func)(impl.Ptr())
+	case CalcTypeFuncPtr:
+		ret = *(**Func)(impl.Ptr())
+	case CalcTypeScalar:
+		ret = (*Scalar)(impl.Ptr())
+	case CalcTypeScalarPtr:
+		ret = *(**Scalar)(impl.Ptr())
+	default:
+		if scratch == nil {
+			scratch = &calcAbstract{}
+		}
+		scratch.delegate = impl
+		ret = scratch
+	}
+	return
+}
+
+// CalcNode is a position within the tree of values exposed by
+// CalcAbstract. Unlike the values returned by CalcAt, a
+// CalcNode retains its parent, so that the tree may be navigated
+// in either direction.
+type CalcNode struct {
+	delegate *e.Abstract
+}
+
+// NewCalcNode returns a CalcNode around x, which will have no
+// parent. It returns nil if x is nil.
+func NewCalcNode(x Calc) *CalcNode {
+	if x == nil {
+		return nil
+	}
+	id, ptr := calcIdentify(x)
+	if impl := calcEngine.Abstract(id, ptr); impl != nil {
+		return &CalcNode{impl}
+	}
+	return nil
+}
+
+// Abstract returns the value at the node's position.
+func (n *CalcNode) Abstract() CalcAbstract {
+	return calcAbstractOf(n.delegate)
+}
+
+// CalcAt returns the node of the nth child, following the same
+// rules as CalcAbstract.CalcAt.
+func (n *CalcNode) CalcAt(index int) *CalcNode {
+	if impl := n.delegate.ChildAt(index); impl != nil {
+		return &CalcNode{impl}
+	}
+	return nil
+}
+
+// CalcNamed returns the node of the named field, following
+// the same rules as CalcAbstract.CalcNamed.
+func (n *CalcNode) CalcNamed(name string) *CalcNode {
+	if impl, _ := n.delegate.ChildNamed(name); impl != nil {
+		return &CalcNode{impl}
+	}
+	return nil
+}
+
+// CalcCount returns the number of children.
+func (n *CalcNode) CalcCount() int {
+	return n.delegate.NumChildren()
+}
+
+// Parent returns the node whose CalcAt method returned this
+// one, or nil if the node was returned by NewCalcNode.
+func (n *CalcNode) Parent() *CalcNode {
+	if impl := n.delegate.Parent(); impl != nil {
+		return &CalcNode{impl}
+	}
+	return nil
+}
+
+// ParentAt returns the index of the node within its parent, or -1 if
+// the node has no parent.
+func (n *CalcNode) ParentAt() int {
+	return n.delegate.ParentAt()
+}
+
+// NextSibling returns the node which follows this one within its
+// parent, following the same rules as CalcAt. It returns nil if
+// this is the last child, or if the node has no parent.
+func (n *CalcNode) NextSibling() *CalcNode {
+	if impl := n.delegate.Sibling(1); impl != nil {
+		return &CalcNode{impl}
+	}
+	return nil
+}
+
+// PrevSibling returns the node which precedes this one within its
+// parent, following the same rules as CalcAt. It returns nil if
+// this is the first child, or if the node has no parent.
+func (n *CalcNode) PrevSibling() *CalcNode {
+	if impl := n.delegate.Sibling(-1); impl != nil {
+		return &CalcNode{impl}
+	}
+	return nil
+}
+
+// CalcTypeID returns the type token of the node's value.
+func (n *CalcNode) CalcTypeID() CalcTypeID {
+	return CalcTypeID(n.delegate.TypeID())
+}
+
+// CalcAt implements CalcAbstract.
+func (x *BinaryOp) CalcAt(index int) CalcAbstract {
+	self := calcAbstract{calcEngine.Abstract(e.TypeID(CalcTypeBinaryOp), e.Ptr(x))}
+	return self.CalcAt(index)
+}
+
+// CalcNamed implements CalcAbstract.
+func (x *BinaryOp) CalcNamed(name string) CalcAbstract {
+	self := calcAbstract{calcEngine.Abstract(e.TypeID(CalcTypeBinaryOp), e.Ptr(x))}
+	return self.CalcNamed(name)
+}
+
+// CalcEach implements CalcAbstract.
+func (x *BinaryOp) CalcEach(fn func(index int, child CalcAbstract) bool) {
+	if x.CalcCount() == 0 {
+		return
+	}
+	self := calcAbstract{calcEngine.Abstract(e.TypeID(CalcTypeBinaryOp), e.Ptr(x))}
+	self.CalcEach(fn)
+}
+
+// CalcFieldNameAt implements CalcAbstract.
+func (x *BinaryOp) CalcFieldNameAt(index int) string {
+	self := calcAbstract{calcEngine.Abstract(e.TypeID(CalcTypeBinaryOp), e.Ptr(x))}
+	return self.CalcFieldNameAt(index)
+}
+
+// CalcCount returns 2.
+func (x *BinaryOp) CalcCount() int { return 2 }
+
+// CalcTypeID returns CalcTypeBinaryOp.
+func (*BinaryOp) CalcTypeID() CalcTypeID { return CalcTypeBinaryOp }
+
+// CalcWalk implements CalcAbstract by delegating to
+// WalkCalc. A nil receiver is a no-op.
+func (x *BinaryOp) CalcWalk(fn CalcWalkerFn) (_ CalcAbstract, changed bool, err error) {
+	if x == nil {
+		return nil, false, nil
+	}
+	y, changed, err := x.WalkCalc(fn)
+	if err != nil {
+		return nil, false, err
+	}
+	return y, changed, nil
+}
+
+// WalkCalc visits the receiver with the provided callback.
+// A nil receiver is a no-op.
+func (x *BinaryOp) WalkCalc(fn CalcWalkerFn) (_ *BinaryOp, changed bool, err error) {
+	if x == nil {
+		return nil, false, nil
+	}
+	var y e.Ptr
+	_, y, changed, err = calcEngine.Execute(fn, e.TypeID(CalcTypeBinaryOp), e.Ptr(x), e.TypeID(CalcTypeBinaryOp))
+	if err != nil {
+		return nil, false, err
+	}
+	return (*BinaryOp)(y), changed, nil
+}
+
+// WalkCalcMorph visits the receiver with the provided callback.
+// Unlike WalkCalc, the receiver may be replaced by a value of any
+// type which implements Calc. A nil receiver is a no-op.
+func (x *BinaryOp) WalkCalcMorph(fn CalcWalkerFn) (_ Calc, changed bool, err error) {
+	if x == nil {
+		return nil, false, nil
+	}
+	id, y, changed, err := calcEngine.Execute(fn, e.TypeID(CalcTypeBinaryOp), e.Ptr(x), e.TypeID(CalcTypeCalc))
+	if err != nil {
+		return nil, false, err
+	}
+	if changed {
+		return calcWrap(id, y), true, nil
+	}
+	return x, false, nil
+}
+
+// InspectCalc visits the receiver with a callback which cannot
+// influence the visitation. A nil receiver is a no-op.
+func (x *BinaryOp) InspectCalc(fn func(x Calc)) {
+	if x == nil {
+		return
+	}
+	_, _, _, _ = calcEngine.Execute(inspectCalc(fn), e.TypeID(CalcTypeBinaryOp), e.Ptr(x), e.TypeID(CalcTypeBinaryOp))
+}
+
+// LeftField returns the Left field.
+func (x *BinaryOp) LeftField() Expr { return x.Left }
+
+// WithLeft returns a shallow copy of the receiver, in which
+// the Left field has been replaced with v. The receiver is
+// not modified.
+func (x *BinaryOp) WithLeft(v Expr) *BinaryOp {
+	ret := *x
+	ret.Left = v
+	return &ret
+}
+
+// RightField returns the Right field.
+func (x *BinaryOp) RightField() Expr { return x.Right }
+
+// WithRight returns a shallow copy of the receiver, in which
+// the Right field has been replaced with v. The receiver is
+// not modified.
+func (x *BinaryOp) WithRight(v Expr) *BinaryOp {
+	ret := *x
+	ret.Right = v
+	return &ret
+}
+
+// CalcAt implements CalcAbstract.
+func (x *Calculation) CalcAt(index int) CalcAbstract {
+	self := calcAbstract{calcEngine.Abstract(e.TypeID(CalcTypeCalculation), e.Ptr(x))}
+	return self.CalcAt(index)
+}
+
+// CalcNamed implements CalcAbstract.
+func (x *Calculation) CalcNamed(name string) CalcAbstract {
+	self := calcAbstract{calcEngine.Abstract(e.TypeID(CalcTypeCalculation), e.Ptr(x))}
+	return self.CalcNamed(name)
+}
+
+// CalcEach implements CalcAbstract.
+func (x *Calculation) CalcEach(fn func(index int, child CalcAbstract) bool) {
+	if x.CalcCount() == 0 {
+		return
+	}
+	self := calcAbstract{calcEngine.Abstract(e.TypeID(CalcTypeCalculation), e.Ptr(x))}
+	self.CalcEach(fn)
+}
+
+// CalcFieldNameAt implements CalcAbstract.
+func (x *Calculation) CalcFieldNameAt(index int) string {
+	self := calcAbstract{calcEngine.Abstract(e.TypeID(CalcTypeCalculation), e.Ptr(x))}
+	return self.CalcFieldNameAt(index)
+}
+
+// CalcCount returns 1.
+func (x *Calculation) CalcCount() int { return 1 }
+
+// CalcTypeID returns CalcTypeCalculation.
+func (*Calculation) CalcTypeID() CalcTypeID { return CalcTypeCalculation }
+
+// CalcWalk implements CalcAbstract by delegating to
+// WalkCalc. A nil receiver is a no-op.
+func (x *Calculation) CalcWalk(fn CalcWalkerFn) (_ CalcAbstract, changed bool, err error) {
+	if x == nil {
+		return nil, false, nil
+	}
+	y, changed, err := x.WalkCalc(fn)
+	if err != nil {
+		return nil, false, err
+	}
+	return y, changed, nil
+}
+
+// WalkCalc visits the receiver with the provided callback.
+// A nil receiver is a no-op.
+func (x *Calculation) WalkCalc(fn CalcWalkerFn) (_ *Calculation, changed bool, err error) {
+	if x == nil {
+		return nil, false, nil
+	}
+	var y e.Ptr
+	_, y, changed, err = calcEngine.Execute(fn, e.TypeID(CalcTypeCalculation), e.Ptr(x), e.TypeID(CalcTypeCalculation))
+	if err != nil {
+		return nil, false, err
+	}
+	return (*Calculation)(y), changed, nil
+}
+
+// WalkCalcMorph visits the receiver with the provided callback.
+// Unlike WalkCalc, the receiver may be replaced by a value of any
+// type which implements Calc. A nil receiver is a no-op.
+func (x *Calculation) WalkCalcMorph(fn CalcWalkerFn) (_ Calc, changed bool, err error) {
+	if x == nil {
+		return nil, false, nil
+	}
+	id, y, changed, err := calcEngine.Execute(fn, e.TypeID(CalcTypeCalculation), e.Ptr(x), e.TypeID(CalcTypeCalc))
+	if err != nil {
+		return nil, false, err
+	}
+	if changed {
+		return calcWrap(id, y), true, nil
+	}
+	return x, false, nil
+}
+
+// InspectCalc visits the receiver with a callback which cannot
+// influence the visitation. A nil receiver is a no-op.
+func (x *Calculation) InspectCalc(fn func(x Calc)) {
+	if x == nil {
+		return
+	}
+	_, _, _, _ = calcEngine.Execute(inspectCalc(fn), e.TypeID(CalcTypeCalculation), e.Ptr(x), e.TypeID(CalcTypeCalculation))
+}
+
+// ExprField returns the Expr field.
+func (x *Calculation) ExprField() Expr { return x.Expr }
+
+// WithExpr returns a shallow copy of the receiver, in which
+// the Expr field has been replaced with v. The receiver is
+// not modified.
+func (x *Calculation) WithExpr(v Expr) *Calculation {
+	ret := *x
+	ret.Expr = v
+	return &ret
+}
+
+// CalcAt implements CalcAbstract.
+func (x *Func) CalcAt(index int) CalcAbstract {
+	self := calcAbstract{calcEngine.Abstract(e.TypeID(CalcTypeFunc), e.Ptr(x))}
+	return self.CalcAt(index)
+}
+
+// CalcNamed implements CalcAbstract.
+func (x *Func) CalcNamed(name string) CalcAbstract {
+	self := calcAbstract{calcEngine.Abstract(e.TypeID(CalcTypeFunc), e.Ptr(x))}
+	return self.CalcNamed(name)
+}
+
+// CalcEach implements CalcAbstract.
+func (x *Func) CalcEach(fn func(index int, child CalcAbstract) bool) {
+	if x.CalcCount() == 0 {
+		return
+	}
+	self := calcAbstract{calcEngine.Abstract(e.TypeID(CalcTypeFunc), e.Ptr(x))}
+	self.CalcEach(fn)
+}
+
+// CalcFieldNameAt implements CalcAbstract.
+func (x *Func) CalcFieldNameAt(index int) string {
+	self := calcAbstract{calcEngine.Abstract(e.TypeID(CalcTypeFunc), e.Ptr(x))}
+	return self.CalcFieldNameAt(index)
+}
+
+// CalcCount returns 1.
+func (x *Func) CalcCount() int { return 1 }
+
+// CalcTypeID returns CalcTypeFunc.
+func (*Func) CalcTypeID() CalcTypeID { return CalcTypeFunc }
+
+// CalcWalk implements CalcAbstract by delegating to
+// WalkCalc. A nil receiver is a no-op.
+func (x *Func) CalcWalk(fn CalcWalkerFn) (_ CalcAbstract, changed bool, err error) {
+	if x == nil {
+		return nil, false, nil
+	}
+	y, changed, err := x.WalkCalc(fn)
+	if err != nil {
+		return nil, false, err
+	}
+	return y, changed, nil
+}
+
+// WalkCalc visits the receiver with the provided callback.
+// A nil receiver is a no-op.
+func (x *Func) WalkCalc(fn CalcWalkerFn) (_ *Func, changed bool, err error) {
+	if x == nil {
+		return nil, false, nil
+	}
+	var y e.Ptr
+	_, y, changed, err = calcEngine.Execute(fn, e.TypeID(CalcTypeFunc), e.Ptr(x), e.TypeID(CalcTypeFunc))
+	if err != nil {
+		return nil, false, err
+	}
+	return (*Func)(y), changed, nil
+}
+
+// WalkCalcMorph visits the receiver with the provided callback.
+// Unlike WalkCalc, the receiver may be replaced by a value of any
+// type which implements Calc. A nil receiver is a no-op.
+func (x *Func) WalkCalcMorph(fn CalcWalkerFn) (_ Calc, changed bool, err error) {
+	if x == nil {
+		return nil, false, nil
+	}
+	id, y, changed, err := calcEngine.Execute(fn, e.TypeID(CalcTypeFunc), e.Ptr(x), e.TypeID(CalcTypeCalc))
+	if err != nil {
+		return nil, false, err
+	}
+	if changed {
+		return calcWrap(id, y), true, nil
+	}
+	return x, false, nil
+}
+
+// InspectCalc visits the receiver with a callback which cannot
+// influence the visitation. A nil receiver is a no-op.
+func (x *Func) InspectCalc(fn func(x Calc)) {
+	if x == nil {
+		return
+	}
+	_, _, _, _ = calcEngine.Execute(inspectCalc(fn), e.TypeID(CalcTypeFunc), e.Ptr(x), e.TypeID(CalcTypeFunc))
+}
+
+// ArgsField returns the Args field.
+func (x *Func) ArgsField() []Expr { return x.Args }
+
+// WithArgs returns a shallow copy of the receiver, in which
+// the Args field has been replaced with v. The receiver is
+// not modified.
+func (x *Func) WithArgs(v []Expr) *Func {
+	ret := *x
+	ret.Args = v
+	return &ret
+}
+
+// CalcAt implements CalcAbstract.
+func (x *Scalar) CalcAt(index int) CalcAbstract {
+	self := calcAbstract{calcEngine.Abstract(e.TypeID(CalcTypeScalar), e.Ptr(x))}
+	return self.CalcAt(index)
+}
+
+// CalcNamed implements CalcAbstract.
+func (x *Scalar) CalcNamed(name string) CalcAbstract {
+	self := calcAbstract{calcEngine.Abstract(e.TypeID(CalcTypeScalar), e.Ptr(x))}
+	return self.CalcNamed(name)
+}
+
+// CalcEach implements CalcAbstract.
+func (x *Scalar) CalcEach(fn func(index int, child CalcAbstract) bool) {
+	if x.CalcCount() == 0 {
+		return
+	}
+	self := calcAbstract{calcEngine.Abstract(e.TypeID(CalcTypeScalar), e.Ptr(x))}
+	self.CalcEach(fn)
+}
+
+// CalcFieldNameAt implements CalcAbstract.
+func (x *Scalar) CalcFieldNameAt(index int) string {
+	self := calcAbstract{calcEngine.Abstract(e.TypeID(CalcTypeScalar), e.Ptr(x))}
+	return self.CalcFieldNameAt(index)
+}
+
+// CalcCount returns 0.
+func (x *Scalar) CalcCount() int { return 0 }
+
+// CalcTypeID returns CalcTypeScalar.
+func (*Scalar) CalcTypeID() CalcTypeID { return CalcTypeScalar }
+
+// CalcWalk implements CalcAbstract by delegating to
+// WalkCalc. A nil receiver is a no-op.
+func (x *Scalar) CalcWalk(fn CalcWalkerFn) (_ CalcAbstract, changed bool, err error) {
+	if x == nil {
+		return nil, false, nil
+	}
+	y, changed, err := x.WalkCalc(fn)
+	if err != nil {
+		return nil, false, err
+	}
+	return y, changed, nil
+}
+
+// WalkCalc visits the receiver with the provided callback.
+// A nil receiver is a no-op.
+func (x *Scalar) WalkCalc(fn CalcWalkerFn) (_ *Scalar, changed bool, err error) {
+	if x == nil {
+		return nil, false, nil
+	}
+	var y e.Ptr
+	_, y, changed, err = calcEngine.Execute(fn, e.TypeID(CalcTypeScalar), e.Ptr(x), e.TypeID(CalcTypeScalar))
+	if err != nil {
+		return nil, false, err
+	}
+	return (*Scalar)(y), changed, nil
+}
+
+// WalkCalcMorph visits the receiver with the provided callback.
+// Unlike WalkCalc, the receiver may be replaced by a value of any
+// type which implements Calc. A nil receiver is a no-op.
+func (x *Scalar) WalkCalcMorph(fn CalcWalkerFn) (_ Calc, changed bool, err error) {
+	if x == nil {
+		return nil, false, nil
+	}
+	id, y, changed, err := calcEngine.Execute(fn, e.TypeID(CalcTypeScalar), e.Ptr(x), e.TypeID(CalcTypeCalc))
+	if err != nil {
+		return nil, false, err
+	}
+	if changed {
+		return calcWrap(id, y), true, nil
+	}
+	return x, false, nil
+}
+
+// InspectCalc visits the receiver with a callback which cannot
+// influence the visitation. A nil receiver is a no-op.
+func (x *Scalar) InspectCalc(fn func(x Calc)) {
+	if x == nil {
+		return
+	}
+	_, _, _, _ = calcEngine.Execute(inspectCalc(fn), e.TypeID(CalcTypeScalar), e.Ptr(x), e.TypeID(CalcTypeScalar))
+}
+
+// WalkCalc visits the receiver with the provided callback.
+// A nil value, or a typed-nil pointer, is returned as-is without
+// invoking the callback.
+func WalkCalc(x Calc, fn CalcWalkerFn) (_ Calc, changed bool, err error) {
+	return walkCalc(x, fn)
+}
+
+// walkCalc implements WalkCalc and those of its variations
+// which differ only in the options that they pass to the engine.
+func walkCalc(x Calc, fn CalcWalkerFn, opts ...e.Option) (_ Calc, changed bool, err error) {
+	if x == nil {
+		return nil, false, nil
+	}
+	id, ptr := calcIdentify(x)
+	if ptr == nil {
+		return x, false, nil
+	}
+	id, ptr, changed, err = calcEngine.Execute(fn, id, ptr, e.TypeID(CalcTypeCalc), opts...)
+	if err != nil {
+		return nil, false, err
+	}
+	if changed {
+		return calcWrap(id, ptr), true, nil
+	}
+	return x, false, nil
+}
+
+// InspectCalc visits x with a callback which cannot influence
+// the visitation, so that every value will be visited. A nil value,
+// or a typed-nil pointer, is ignored.
+func InspectCalc(x Calc, fn func(x Calc)) {
+	if x == nil {
+		return
+	}
+	id, ptr := calcIdentify(x)
+	if ptr == nil {
+		return
+	}
+	_, _, _, _ = calcEngine.Execute(inspectCalc(fn), id, ptr, id)
+}
+
+// inspectCalc adapts a callback for InspectCalc into a
+// CalcWalkerFn which always continues.
+func inspectCalc(fn func(x Calc)) CalcWalkerFn {
+	return func(_ CalcContext, x Calc) (d CalcDecision) {
+		fn(x)
+		return
+	}
+}
+
+// WalkCalcCtx visits x with the provided callback, stopping with
+// the context's error once the context has been cancelled. Replacements
+// made before the cancellation are discarded, although values which were
+// replaced in place will remain changed.
+func WalkCalcCtx(ctx context.Context, x Calc, fn CalcWalkerFn) (_ Calc, changed bool, err error) {
+	if err := ctx.Err(); err != nil {
+		return nil, false, err
+	}
+	// A context which can never be cancelled need not be checked, which
+	// keeps the walk allocation-free.
+	if ctx.Done() == nil {
+		return walkCalc(x, fn)
+	}
+	return walkCalc(x, fn, e.WithContext(ctx))
+}
+
+// WalkCalcLifecycle visits x with the provided callback, in the
+// same manner as WalkCalc. The onStart function is invoked before
+// the callback is first invoked, and onEnd is invoked once the walk
+// has completed, with the error which will be returned. Either
+// function may be nil. Both functions are invoked even if x is nil.
+func WalkCalcLifecycle(x Calc, onStart func(), onEnd func(err error), fn CalcWalkerFn) (_ Calc, changed bool, err error) {
+	if onStart != nil {
+		onStart()
+	}
+	x, changed, err = WalkCalc(x, fn)
+	if onEnd != nil {
+		onEnd(err)
+	}
+	return x, changed, err
+}
+
+// ------ Union Support -----
+type Calc interface {
+	CalcAbstract
+	isCalcType()
+}
+
+var (
+	_ Calc = &BinaryOp{}
+	_ Calc = &Calculation{}
+	_ Calc = &Func{}
+	_ Calc = &Scalar{}
+)
+
+func (*BinaryOp) isCalcType()    {}
+func (*Calculation) isCalcType() {}
+func (*Func) isCalcType()        {}
+func (*Scalar) isCalcType()      {} // ------ Finding ------
+
+// FindAllBinaryOpInCalc returns every BinaryOp within root,
+// including root itself, in the order that they would be visited.
+func FindAllBinaryOpInCalc(root Calc) []*BinaryOp {
+	if root == nil {
+		return nil
+	}
+	id, ptr := calcIdentify(root)
+	if ptr == nil {
+		return nil
+	}
+	var ret []*BinaryOp
+	fn := CalcWalkerFn(func(ctx CalcContext, x Calc) CalcDecision {
+		ret = append(ret, x.(*BinaryOp))
+		return ctx.Continue()
+	})
+	if _, _, _, err := calcEngine.Execute(fn, id, ptr, id, e.WithTypes(e.TypeID(CalcTypeBinaryOp))); err != nil {
+		// The callback makes no changes, so this indicates a problem
+		// with code-generation.
+		panic(err)
+	}
+	return ret
+}
+
+// FindAllCalculationInCalc returns every Calculation within root,
+// including root itself, in the order that they would be visited.
+func FindAllCalculationInCalc(root Calc) []*Calculation {
+	if root == nil {
+		return nil
+	}
+	id, ptr := calcIdentify(root)
+	if ptr == nil {
+		return nil
+	}
+	var ret []*Calculation
+	fn := CalcWalkerFn(func(ctx CalcContext, x Calc) CalcDecision {
+		ret = append(ret, x.(*Calculation))
+		return ctx.Continue()
+	})
+	if _, _, _, err := calcEngine.Execute(fn, id, ptr, id, e.WithTypes(e.TypeID(CalcTypeCalculation))); err != nil {
+		// The callback makes no changes, so this indicates a problem
+		// with code-generation.
+		panic(err)
+	}
+	return ret
+}
+
+// FindAllFuncInCalc returns every Func within root,
+// including root itself, in the order that they would be visited.
+func FindAllFuncInCalc(root Calc) []*Func {
+	if root == nil {
+		return nil
+	}
+	id, ptr := calcIdentify(root)
+	if ptr == nil {
+		return nil
+	}
+	var ret []*Func
+	fn := CalcWalkerFn(func(ctx CalcContext, x Calc) CalcDecision {
+		ret = append(ret, x.(*Func))
+		return ctx.Continue()
+	})
+	if _, _, _, err := calcEngine.Execute(fn, id, ptr, id, e.WithTypes(e.TypeID(CalcTypeFunc))); err != nil {
+		// The callback makes no changes, so this indicates a problem
+		// with code-generation.
+		panic(err)
+	}
+	return ret
+}
+
+// FindAllScalarInCalc returns every Scalar within root,
+// including root itself, in the order that they would be visited.
+func FindAllScalarInCalc(root Calc) []*Scalar {
+	if root == nil {
+		return nil
+	}
+	id, ptr := calcIdentify(root)
+	if ptr == nil {
+		return nil
+	}
+	var ret []*Scalar
+	fn := CalcWalkerFn(func(ctx CalcContext, x Calc) CalcDecision {
+		ret = append(ret, x.(*Scalar))
+		return ctx.Continue()
+	})
+	if _, _, _, err := calcEngine.Execute(fn, id, ptr, id, e.WithTypes(e.TypeID(CalcTypeScalar))); err != nil {
+		// The callback makes no changes, so this indicates a problem
+		// with code-generation.
+		panic(err)
+	}
+	return ret
+}
+
+// ------ Allocation ------
+
+// CalcAllocator returns a value of the given struct type, whose
+// contents will be overwritten. It may return nil to allocate a new
+// struct as usual.
+type CalcAllocator func(id CalcTypeID) Calc
+
+// WalkCalcAlloc visits x with the provided callback. Whenever a
+// struct must be copied in order to replace one of its children, the
+// memory for the copy is obtained from alloc. This allows callers
+// which perform many rewrites to reuse structs which they know to be
+// unreferenced, e.g. from a previous result which has been discarded.
+// The allocator will be called with a lock held if the callback
+// requests parallel visitation, and it will panic if alloc returns a
+// value of a different type.
+func WalkCalcAlloc(x Calc, alloc CalcAllocator, fn CalcWalkerFn) (_ Calc, changed bool, err error) {
+	return walkCalc(x, fn, e.WithAllocator(func(want e.TypeID) e.Ptr {
+		y := alloc(CalcTypeID(want))
+		if y == nil {
+			return nil
+		}
+		got, ptr := calcIdentify(y)
+		if got != want {
+			panic(fmt.Sprintf("allocator returned %T for %s", y, CalcTypeID(want)))
+		}
+		return ptr
+	}))
+}
+
+// ------ Binary Encoding ------
+
+// calcEncoder writes visitable values by delegating to the engine.
+type calcEncoder struct {
+	*e.Encoder
+}
+
+// calcDecoder reads visitable values by delegating to the engine.
+type calcDecoder struct {
+	*e.Decoder
+}
+
+// EncodeCalc writes root, and all of the visitable values which
+// are reachable from it, to w in a compact binary format. The exported
+// boolean, numeric, and string fields of each struct are also written;
+// any other non-visitable fields are not. Values which are referred to
+// by multiple pointers, including cyclical references, are only written
+// once. The encoding depends upon the generated type tokens, so it
+// should only be decoded by the same generated code.
+func EncodeCalc(w io.Writer, root Calc) error {
+	enc := calcEncoder{e.NewEncoder(w)}
+	enc.encodeCalcTypeCalc(e.Ptr(&root))
+	return enc.Flush()
+}
+
+// DecodeCalc reads a value written by EncodeCalc.
+func DecodeCalc(r io.Reader) (Calc, error) {
+	dec := calcDecoder{e.NewDecoder(r)}
+	var ret Calc
+	dec.decodeCalcTypeCalc(e.Ptr(&ret))
+	if err := dec.Err(); err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+func (enc calcEncoder) encodeCalcTypeBinaryOp(x e.Ptr) {
+	s := (*BinaryOp)(x)
+	enc.WriteString(string(s.Operator))
+	enc.encodeCalcTypeExpr(e.Ptr(&s.Left))
+	enc.encodeCalcTypeExpr(e.Ptr(&s.Right))
+}
+
+func (dec calcDecoder) decodeCalcTypeBinaryOp(x e.Ptr) {
+	s := (*BinaryOp)(x)
+	s.Operator = string(dec.ReadString())
+	dec.decodeCalcTypeExpr(e.Ptr(&s.Left))
+	dec.decodeCalcTypeExpr(e.Ptr(&s.Right))
+}
+
+func (enc calcEncoder) encodeCalcTypeCalculation(x e.Ptr) {
+	s := (*Calculation)(x)
+	enc.encodeCalcTypeExpr(e.Ptr(&s.Expr))
+}
+
+func (dec calcDecoder) decodeCalcTypeCalculation(x e.Ptr) {
+	s := (*Calculation)(x)
+	dec.decodeCalcTypeExpr(e.Ptr(&s.Expr))
+}
+
+func (enc calcEncoder) encodeCalcTypeFunc(x e.Ptr) {
+	s := (*Func)(x)
+	enc.WriteString(string(s.Fn))
+	enc.encodeCalcTypeExprSlice(e.Ptr(&s.Args))
+}
+
+func (dec calcDecoder) decodeCalcTypeFunc(x e.Ptr) {
+	s := (*Func)(x)
+	s.Fn = string(dec.ReadString())
+	dec.decodeCalcTypeExprSlice(e.Ptr(&s.Args))
+}
+
+func (enc calcEncoder) encodeCalcTypeScalar(x e.Ptr) {
+}
+
+func (dec calcDecoder) decodeCalcTypeScalar(x e.Ptr) {
+}
+
+func (enc calcEncoder) encodeCalcTypeCalc(x e.Ptr) {
+	switch t := (*(*Calc)(x)).(type) {
+	case nil:
+		enc.WriteUint(0)
+	case *BinaryOp:
+		enc.WriteUint(uint64(CalcTypeBinaryOpPtr))
+		enc.encodeCalcTypeBinaryOpPtr(e.Ptr(&t))
+	case *Calculation:
+		enc.WriteUint(uint64(CalcTypeCalculationPtr))
+		enc.encodeCalcTypeCalculationPtr(e.Ptr(&t))
+	case *Func:
+		enc.WriteUint(uint64(CalcTypeFuncPtr))
+		enc.encodeCalcTypeFuncPtr(e.Ptr(&t))
+	case *Scalar:
+		enc.WriteUint(uint64(CalcTypeScalarPtr))
+		enc.encodeCalcTypeScalarPtr(e.Ptr(&t))
+	default:
+		panic(fmt.Sprintf("unhandled value of type: %T", t))
+	}
+}
+
+func (dec calcDecoder) decodeCalcTypeCalc(x e.Ptr) {
+	switch id := CalcTypeID(dec.ReadUint()); id {
+	case 0:
+		*(*Calc)(x) = nil
+	case CalcTypeBinaryOpPtr:
+		var t *BinaryOp
+		dec.decodeCalcTypeBinaryOpPtr(e.Ptr(&t))
+		*(*Calc)(x) = t
+	case CalcTypeCalculationPtr:
+		var t *Calculation
+		dec.decodeCalcTypeCalculationPtr(e.Ptr(&t))
+		*(*Calc)(x) = t
+	case CalcTypeFuncPtr:
+		var t *Func
+		dec.decodeCalcTypeFuncPtr(e.Ptr(&t))
+		*(*Calc)(x) = t
+	case CalcTypeScalarPtr:
+		var t *Scalar
+		dec.decodeCalcTypeScalarPtr(e.Ptr(&t))
+		*(*Calc)(x) = t
+	default:
+		dec.Fail(fmt.Errorf("unexpected type token %d for Calc", id))
+	}
+}
+
+func (enc calcEncoder) encodeCalcTypeExpr(x e.Ptr) {
+	switch t := (*(*Expr)(x)).(type) {
+	case nil:
+		enc.WriteUint(0)
+	case *BinaryOp:
+		enc.WriteUint(uint64(CalcTypeBinaryOpPtr))
+		enc.encodeCalcTypeBinaryOpPtr(e.Ptr(&t))
+	case *Func:
+		enc.WriteUint(uint64(CalcTypeFuncPtr))
+		enc.encodeCalcTypeFuncPtr(e.Ptr(&t))
+	case *Scalar:
+		enc.WriteUint(uint64(CalcTypeScalarPtr))
+		enc.encodeCalcTypeScalarPtr(e.Ptr(&t))
+	default:
+		panic(fmt.Sprintf("unhandled value of type: %T", t))
+	}
+}
+
+func (dec calcDecoder) decodeCalcTypeExpr(x e.Ptr) {
+	switch id := CalcTypeID(dec.ReadUint()); id {
+	case 0:
+		*(*Expr)(x) = nil
+	case CalcTypeBinaryOpPtr:
+		var t *BinaryOp
+		dec.decodeCalcTypeBinaryOpPtr(e.Ptr(&t))
+		*(*Expr)(x) = t
+	case CalcTypeFuncPtr:
+		var t *Func
+		dec.decodeCalcTypeFuncPtr(e.Ptr(&t))
+		*(*Expr)(x) = t
+	case CalcTypeScalarPtr:
+		var t *Scalar
+		dec.decodeCalcTypeScalarPtr(e.Ptr(&t))
+		*(*Expr)(x) = t
+	default:
+		dec.Fail(fmt.Errorf("unexpected type token %d for Expr", id))
+	}
+}
+
+func (enc calcEncoder) encodeCalcTypeBinaryOpPtr(x e.Ptr) {
+	p := *(**BinaryOp)(x)
+	if enc.WriteRef(e.TypeID(CalcTypeBinaryOpPtr), e.Ptr(p)) {
+		enc.encodeCalcTypeBinaryOp(e.Ptr(p))
+	}
+}
+
+func (dec calcDecoder) decodeCalcTypeBinaryOpPtr(x e.Ptr) {
+	p, isNew := dec.ReadRef()
+	if isNew {
+		p = e.Ptr(new(BinaryOp))
+		dec.AddRef(p)
+		dec.decodeCalcTypeBinaryOp(p)
+	}
+	*(**BinaryOp)(x) = (*BinaryOp)(p)
+}
+
+func (enc calcEncoder) encodeCalcTypeCalculationPtr(x e.Ptr) {
+	p := *(**Calculation)(x)
+	if enc.WriteRef(e.TypeID(CalcTypeCalculationPtr), e.Ptr(p)) {
+		enc.encodeCalcTypeCalculation(e.Ptr(p))
+	}
+}
+
+func (dec calcDecoder) decodeCalcTypeCalculationPtr(x e.Ptr) {
+	p, isNew := dec.ReadRef()
+	if isNew {
+		p = e.Ptr(new(Calculation))
+		dec.AddRef(p)
+		dec.decodeCalcTypeCalculation(p)
+	}
+	*(**Calculation)(x) = (*Calculation)(p)
+}
+
+func (enc calcEncoder) encodeCalcTypeFuncPtr(x e.Ptr) {
+	p := *(**Func)(x)
+	if enc.WriteRef(e.TypeID(CalcTypeFuncPtr), e.Ptr(p)) {
+		enc.encodeCalcTypeFunc(e.Ptr(p))
+	}
+}
+
+func (dec calcDecoder) decodeCalcTypeFuncPtr(x e.Ptr) {
+	p, isNew := dec.ReadRef()
+	if isNew {
+		p = e.Ptr(new(Func))
+		dec.AddRef(p)
+		dec.decodeCalcTypeFunc(p)
+	}
+	*(**Func)(x) = (*Func)(p)
+}
+
+func (enc calcEncoder) encodeCalcTypeScalarPtr(x e.Ptr) {
+	p := *(**Scalar)(x)
+	if enc.WriteRef(e.TypeID(CalcTypeScalarPtr), e.Ptr(p)) {
+		enc.encodeCalcTypeScalar(e.Ptr(p))
+	}
+}
+
+func (dec calcDecoder) decodeCalcTypeScalarPtr(x e.Ptr) {
+	p, isNew := dec.ReadRef()
+	if isNew {
+		p = e.Ptr(new(Scalar))
+		dec.AddRef(p)
+		dec.decodeCalcTypeScalar(p)
+	}
+	*(**Scalar)(x) = (*Scalar)(p)
+}
+
+func (enc calcEncoder) encodeCalcTypeExprSlice(x e.Ptr) {
+	s := *(*[]Expr)(x)
+	// A nil slice is written as zero, to distinguish it from an empty one.
+	if s == nil {
+		enc.WriteUint(0)
+		return
+	}
+	enc.WriteUint(uint64(len(s)) + 1)
+	for i := range s {
+		enc.encodeCalcTypeExpr(e.Ptr(&s[i]))
+	}
+}
+
+func (dec calcDecoder) decodeCalcTypeExprSlice(x e.Ptr) {
+	n := dec.ReadLen()
+	if n == 0 {
+		*(*[]Expr)(x) = nil
+		return
+	}
+	s := make([]Expr, 0, dec.Prealloc(n-1))
+	for i := 0; i < n-1 && dec.Err() == nil; i++ {
+		var elt Expr
+		s = append(s, elt)
+		dec.decodeCalcTypeExpr(e.Ptr(&s[i]))
+	}
+	*(*[]Expr)(x) = s
+}
+
+// ------ Counting ------
+
+// WalkCalcCounted visits x with the provided callback and also
+// returns the number of times that the callback was invoked, i.e. the
+// number of values which were visited. Post-visit functions and
+// interceptors are not counted, and values which are visited again
+// after a restart are counted again.
+func WalkCalcCounted(x Calc, fn CalcWalkerFn) (_ Calc, changed bool, count int, err error) {
+	var visits int64
+	x, changed, err = walkCalc(x, fn, e.WithCount(&visits))
+	return x, changed, int(visits), err
+}
+
+// ------ Cycle Detection ------
+
+// CalcCycle describes a value which was not visited because it was
+// already being visited, i.e. a back-reference which would otherwise
+// form a cycle.
+type CalcCycle struct {
+	// Path is the location of the back-reference which closes the
+	// cycle, relative to the value passed to WalkCalcDetectCycles.
+	Path CalcPath
+	// TypeID is the type of the value.
+	TypeID CalcTypeID
+	// Value is populated if the value is a struct.
+	Value Calc
+}
+
+// WalkCalcDetectCycles visits x with the provided callback and
+// returns the cycles which were broken during the visitation. Any
+// replacements made by the callback are discarded.
+func WalkCalcDetectCycles(x Calc, fn CalcWalkerFn) ([]CalcCycle, error) {
+	if x == nil {
+		return nil, nil
+	}
+	id, ptr := calcIdentify(x)
+	if ptr == nil {
+		return nil, nil
+	}
+	var ret []CalcCycle
+	_, _, _, err := calcEngine.Execute(fn, id, ptr, e.TypeID(CalcTypeCalc),
+		e.WithCycleHook(func(path e.Path, id e.TypeID, x e.Ptr) {
+			c := CalcCycle{Path: path, TypeID: CalcTypeID(id)}
+			switch c.TypeID {
+			case CalcTypeBinaryOp:
+				c.Value = (*BinaryOp)(x)
+			case CalcTypeCalculation:
+				c.Value = (*Calculation)(x)
+			case CalcTypeFunc:
+				c.Value = (*Func)(x)
+			case CalcTypeScalar:
+				c.Value = (*Scalar)(x)
+			}
+			ret = append(ret, c)
+		}))
+	if err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+// ------ Depth Helpers ------
+
+// DepthOfCalc returns the deepest nesting level of the visitable
+// structs within x, as reported by CalcContext.Depth(). The value
+// passed in has a depth of zero, as does a nil value. Branches which
+// are not visited because they would form a cycle do not contribute
+// to the result.
+func DepthOfCalc(x Calc) int {
+	max := 0
+	_, _, _ = WalkCalc(x, func(ctx CalcContext, _ Calc) (d CalcDecision) {
+		if depth := ctx.Depth(); depth > max {
+			max = depth
+		}
+		return
+	})
+	return max
+}
+
+// CalcByDepth returns the visitable structs within root, grouped
+// by the depth reported by CalcContext.Depth(). Within each group,
+// values appear in the order in which they were visited. The root is
+// the only value at depth zero. Values which are not visited because
+// they would form a cycle are omitted.
+func CalcByDepth(root Calc) [][]Calc {
+	var ret [][]Calc
+	_, _, _ = WalkCalc(root, func(ctx CalcContext, x Calc) (d CalcDecision) {
+		depth := ctx.Depth()
+		for len(ret) <= depth {
+			ret = append(ret, nil)
+		}
+		ret[depth] = append(ret[depth], x)
+		return
+	})
+	return ret
+}
+
+// ------ Change Tracking ------
+
+// CalcChange describes a value which was replaced during a call to
+// DiffWalkCalc.
+type CalcChange struct {
+	// Path is the location of the value, relative to the root of the
+	// visitation, e.g. "TargetSlice[2]". The root itself has an empty
+	// path.
+	Path string
+	// Before is the value that was replaced.
+	Before Calc
+	// After is the replacement value.
+	After Calc
+}
+
+// DiffWalkCalc visits x with the provided callback and returns a
+// log of the replacements that the callback made, in the order that
+// they were made.
+func DiffWalkCalc(x Calc, fn CalcWalkerFn) (_ Calc, changes []CalcChange, err error) {
+	x, _, err = walkCalc(x, fn,
+		e.WithChangeHook(func(path e.Path, beforeType e.TypeID, before e.Ptr, afterType e.TypeID, after e.Ptr) {
+			changes = append(changes, CalcChange{
+				Path:   path.String(),
+				Before: calcWrap(beforeType, before),
+				After:  calcWrap(afterType, after),
+			})
+		}))
+	if err != nil {
+		return nil, nil, err
+	}
+	return x, changes, nil
+}
+
+// WalkCalcChanged visits the values within after which differ
+// from the values at the same location within before, such as the
+// input and output of a previous call to WalkCalc. Since
+// replaced values only cause their ancestors to be copied, any subtree
+// which is shared between before and after will be skipped without
+// invoking the callback. A nil before value will cause all of after to
+// be visited.
+func WalkCalcChanged(before, after Calc, fn CalcWalkerFn) (_ Calc, changed bool, err error) {
+	if after == nil {
+		return nil, false, nil
+	}
+	id, ptr := calcIdentify(after)
+	if ptr == nil {
+		return after, false, nil
+	}
+	var beforePtr e.Ptr
+	if before != nil {
+		if beforeID, p := calcIdentify(before); beforeID == id {
+			beforePtr = p
+		}
+	}
+	return walkCalc(after, fn, e.WithChanges(calcEngine.Changes(id, beforePtr, ptr)))
+}
+
+// CalcEditOp describes the kind of change made by a CalcEdit.
+type CalcEditOp e.EditOp
+
+// The kinds of edits that may appear in a script.
+const (
+	CalcEditOpDelete  = CalcEditOp(e.EditDelete)
+	CalcEditOpInsert  = CalcEditOp(e.EditInsert)
+	CalcEditOpReplace = CalcEditOp(e.EditReplace)
+)
+
+// String is for debugging use only.
+func (o CalcEditOp) String() string {
+	return e.EditOp(o).String()
+}
+
+// CalcEdit is one step of a script produced by
+// DiffCalcScript.
+type CalcEdit struct {
+	Op CalcEditOp
+	// Path is the location of the value, e.g. "TargetSlice[2]". The
+	// paths of deleted values are relative to the original tree, while
+	// the paths of inserted and replaced values are relative to the new
+	// tree.
+	Path string
+	// Before is the value which was deleted or replaced. It will be nil
+	// for insertions.
+	Before CalcAbstract
+	// After is the value which was inserted or which replaced Before.
+	// It will be nil for deletions.
+	After CalcAbstract
+}
+
+// DiffCalcScript returns a minimal sequence of edits which
+// transforms a into b. Struct fields and array elements are compared
+// by position, while slice elements may be inserted or deleted. A
+// value is replaced if its type or its scalar fields differ; if only
+// its scalar fields differ, its children are compared in turn. Subtrees
+// which are shared between a and b are not examined.
+func DiffCalcScript(a, b Calc) []CalcEdit {
+	var aID, bID e.TypeID
+	var aPtr, bPtr e.Ptr
+	if a != nil {
+		aID, aPtr = calcIdentify(a)
+	}
+	if b != nil {
+		bID, bPtr = calcIdentify(b)
+	}
+	edits := calcEngine.Script(aID, aPtr, bID, bPtr, calcSameLabel)
+	if len(edits) == 0 {
+		return nil
+	}
+	ret := make([]CalcEdit, len(edits))
+	for i, edit := range edits {
+		ret[i] = CalcEdit{
+			Op:     CalcEditOp(edit.Op),
+			Path:   edit.Path.String(),
+			Before: calcAbstractOf(edit.Before),
+			After:  calcAbstractOf(edit.After),
+		}
+	}
+	return ret
+}
+
+// calcSameLabel compares the scalar fields of two structs of the
+// same type.
+func calcSameLabel(id e.TypeID, a, b e.Ptr) bool {
+	switch CalcTypeID(id) {
+	case CalcTypeBinaryOp:
+		x, y := (*BinaryOp)(a), (*BinaryOp)(b)
+		return x.Operator == y.Operator
+	case CalcTypeFunc:
+		x, y := (*Func)(a), (*Func)(b)
+		return x.Fn == y.Fn
+	default:
+		return true
+	}
+}
+
+// ------ Dumping ------
+
+// DumpCalc returns an indented representation of x, for use
+// when debugging. Each line describes one value, giving its field name
+// or index within the enclosing value and its type. Pointers and
+// interfaces are followed transparently, and a value which encloses
+// itself is marked as a back-reference, rather than being followed.
+func DumpCalc(x Calc) string {
+	if x == nil {
+		return "<nil>\n"
+	}
+	id, ptr := calcIdentify(x)
+	return calcEngine.Dump(id, ptr)
+}
+
+// ------ Equality ------
+
+// EqualCalc reports whether a and b are structurally equal.
+// Visitable fields and elements are compared recursively, while the
+// exported boolean, numeric, and string fields of each struct are
+// compared with ==; all other fields are ignored. Pointers and
+// interfaces are compared by the values they refer to. Nested nil
+// values, typed-nil interfaces, and empty slices or maps are considered
+// to be equal to one another. Slices of differing lengths are never
+// equal.
+func EqualCalc(a, b Calc) bool {
+	var aID, bID e.TypeID
+	var aPtr, bPtr e.Ptr
+	if a != nil {
+		aID, aPtr = calcIdentify(a)
+	}
+	if b != nil {
+		bID, bPtr = calcIdentify(b)
+	}
+	return calcEngine.Equal(aID, aPtr, bID, bPtr, calcSameLabel)
+}
+
+// EqualCalc reports whether the receiver and other are
+// structurally equal, as defined by EqualCalc.
+func (x *BinaryOp) EqualCalc(other *BinaryOp) bool {
+	return calcEngine.Equal(e.TypeID(CalcTypeBinaryOp), e.Ptr(x), e.TypeID(CalcTypeBinaryOp), e.Ptr(other), calcSameLabel)
+}
+
+// EqualCalc reports whether the receiver and other are
+// structurally equal, as defined by EqualCalc.
+func (x *Calculation) EqualCalc(other *Calculation) bool {
+	return calcEngine.Equal(e.TypeID(CalcTypeCalculation), e.Ptr(x), e.TypeID(CalcTypeCalculation), e.Ptr(other), calcSameLabel)
+}
+
+// EqualCalc reports whether the receiver and other are
+// structurally equal, as defined by EqualCalc.
+func (x *Func) EqualCalc(other *Func) bool {
+	return calcEngine.Equal(e.TypeID(CalcTypeFunc), e.Ptr(x), e.TypeID(CalcTypeFunc), e.Ptr(other), calcSameLabel)
+}
+
+// EqualCalc reports whether the receiver and other are
+// structurally equal, as defined by EqualCalc.
+func (x *Scalar) EqualCalc(other *Scalar) bool {
+	return calcEngine.Equal(e.TypeID(CalcTypeScalar), e.Ptr(x), e.TypeID(CalcTypeScalar), e.Ptr(other), calcSameLabel)
+}
+
+// ------ Event Streaming ------
+
+// CalcEvent is sent by WalkCalcChan when a value is visited.
+type CalcEvent struct {
+	// Value is the visited value. It will be nil for an event which
+	// carries an error.
+	Value Calc
+	// TypeID is the type token of Value.
+	TypeID CalcTypeID
+	// Post is false for the event which is sent before the children of
+	// Value are visited, and true for the event which is sent after.
+	Post bool
+	// Err is only set on the final event, if the walk failed.
+	Err error
+}
+
+// WalkCalcChan visits x on a new goroutine and returns a channel
+// which receives a pre-visit and a post-visit event for each value.
+// Values cannot be replaced. The goroutine blocks whenever the channel's
+// buffer is full, and the channel is closed once the walk has finished.
+// If the walk fails, a final event carrying the error will be sent. A
+// consumer which stops reading before the channel has been closed must
+// cancel ctx, or else the goroutine will leak; once ctx has been
+// cancelled, any remaining events, including the final error, may be
+// dropped.
+func WalkCalcChan(ctx context.Context, x Calc) <-chan CalcEvent {
+	ch := make(chan CalcEvent, 16)
+	send := func(ev CalcEvent) bool {
+		select {
+		case ch <- ev:
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	}
+	event := func(x Calc, post bool) CalcEvent {
+		id, _ := calcIdentify(x)
+		return CalcEvent{Value: x, TypeID: CalcTypeID(id), Post: post}
+	}
+	post := CalcWalkerFn(func(c CalcContext, x Calc) CalcDecision {
+		if !send(event(x, true)) {
+			return c.Error(ctx.Err())
+		}
+		return c.Continue()
+	})
+
+	go func() {
+		defer close(ch)
+		_, _, err := WalkCalcCtx(ctx, x, func(c CalcContext, x Calc) CalcDecision {
+			if !send(event(x, false)) {
+				return c.Error(ctx.Err())
+			}
+			return c.Continue().Post(post)
+		})
+		if err != nil {
+			send(CalcEvent{Err: err})
+		}
+	}()
+	return ch
+}
+
+// ------ Fixed-Point Application ------
+
+// ApplyCalcToFixedPoint repeatedly visits root with the provided
+// callback until a visitation makes no changes. The callback will not
+// be applied more than maxIters times; an error will be returned if the
+// value is still changing once the limit has been reached. The number
+// of visitations which were performed is returned.
+func ApplyCalcToFixedPoint(root Calc, fn CalcWalkerFn, maxIters int) (Calc, int, error) {
+	for i := 1; i <= maxIters; i++ {
+		next, changed, err := WalkCalc(root, fn)
+		if err != nil {
+			return nil, i, err
+		}
+		if !changed {
+			return root, i, nil
+		}
+		root = next
+	}
+	return root, maxIters, fmt.Errorf("no fixed point reached after %d iterations", maxIters)
+}
+
+// ------ Focusing ------
+
+// FocusCalc returns the values within root for which pred
+// returns true, in the order that they are visited, along with a
+// function which puts replacements for them back into a copy of root.
+// The values which enclose a replaced value are cloned, while all other
+// values are shared with root. The values within a focused value are
+// not examined. The put function must be given exactly one non-nil
+// replacement for each focused value, and it will return an error if
+// a replacement cannot be stored, e.g. because the value was returned
+// by a getter. The put function may be called any number of times.
+func FocusCalc(root Calc, pred func(Calc) bool) (focused []Calc, put func([]Calc) (Calc, error)) {
+	// Traversal is deterministic, so we record the ordinals of the
+	// focused values in order to find them again.
+	var ordinals []int
+	count := 0
+	_, _, _ = WalkCalc(root, func(ctx CalcContext, x Calc) CalcDecision {
+		count++
+		if pred(x) {
+			focused = append(focused, x)
+			ordinals = append(ordinals, count)
+			return ctx.Skip()
+		}
+		return ctx.Continue()
+	})
+
+	put = func(replacements []Calc) (Calc, error) {
+		if len(replacements) != len(ordinals) {
+			return nil, fmt.Errorf("expecting %d replacements, got %d", len(ordinals), len(replacements))
+		}
+		count, next := 0, 0
+		ret, _, err := WalkCalc(root, func(ctx CalcContext, x Calc) CalcDecision {
+			count++
+			if next == len(ordinals) {
+				return ctx.Halt()
+			}
+			if count != ordinals[next] {
+				return ctx.Continue()
+			}
+			replacement := replacements[next]
+			if replacement == nil {
+				return ctx.Error(fmt.Errorf("replacement %d is nil", next))
+			}
+			next++
+			return ctx.Skip().Replace(replacement)
+		})
+		return ret, err
+	}
+	return focused, put
+}
+
+// ------ Forests ------
+
+// WalkCalcForest visits each of the roots with the provided
+// callback, distributing the roots across the given number of
+// goroutines. If workers is not positive, GOMAXPROCS goroutines will be
+// used. The callback must be safe for concurrent use. Once any
+// visitation returns an error, visitations which are in progress will
+// be halted and the remaining roots will not be visited. The first
+// error will be returned. Any replacements made by the callback are
+// discarded.
+func WalkCalcForest(roots []Calc, workers int, fn CalcWalkerFn) error {
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+	if workers > len(roots) {
+		workers = len(roots)
+	}
+	// The cause of the cancellation is the first error.
+	ctx, cancel := context.WithCancelCause(context.Background())
+	defer cancel(nil)
+	// Halt any visitations in progress once an error has occurred.
+	var guarded CalcWalkerFn = func(c CalcContext, x Calc) CalcDecision {
+		if ctx.Err() != nil {
+			return c.Halt()
+		}
+		return fn(c, x)
+	}
+
+	work := make(chan Calc)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Go(func() {
+			for root := range work {
+				if _, _, err := WalkCalc(root, guarded); err != nil {
+					cancel(err)
+				}
+			}
+		})
+	}
+
+feed:
+	for _, root := range roots {
+		select {
+		case work <- root:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(work)
+	wg.Wait()
+	return context.Cause(ctx)
+}
+
+// ------ Formatting ------
+
+// CalcFormatter renders a value, given the rendered output of the
+// visitable structs that it encloses.
+type CalcFormatter func(x Calc, children []string) string
+
+// FormatCalc renders root by visiting it and composing the
+// output of the formatter registered for the type of each struct. A
+// struct without a formatter is rendered as the name of its type,
+// followed by its children in parentheses, if it has any. The children
+// of a struct are rendered in the order in which they are visited. Nil
+// values, and values which would form a cycle, are omitted.
+func FormatCalc(root Calc, formatters map[CalcTypeID]CalcFormatter) string {
+	// Each struct being visited has an entry on the stack, which
+	// accumulates the output of its children. The bottom entry collects
+	// the output of the root.
+	stack := [][]string{nil}
+	var post CalcWalkerFn = func(ctx CalcContext, x Calc) (d CalcDecision) {
+		children := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		id, _ := calcIdentify(x)
+		var out string
+		if fn, ok := formatters[CalcTypeID(id)]; ok {
+			out = fn(x, children)
+		} else if len(children) == 0 {
+			out = CalcTypeID(id).String()
+		} else {
+			out = fmt.Sprintf("%s(%s)", CalcTypeID(id), strings.Join(children, ", "))
+		}
+		stack[len(stack)-1] = append(stack[len(stack)-1], out)
+		return
+	}
+	_, _, _ = WalkCalc(root, func(ctx CalcContext, x Calc) CalcDecision {
+		stack = append(stack, nil)
+		return ctx.Continue().Post(post)
+	})
+	return strings.Join(stack[0], "")
+}
+
+// ------ Histograms ------
+
+// CalcHistogram returns the number of values of each struct type
+// which are visited by InspectCalc, including x itself. A nil
+// value returns an empty map.
+func CalcHistogram(x Calc) map[CalcTypeID]int {
+	ret := make(map[CalcTypeID]int)
+	InspectCalc(x, func(x Calc) {
+		id, _ := calcIdentify(x)
+		ret[CalcTypeID(id)]++
+	})
+	return ret
+}
+
+// ------ Invariants ------
+
+// CalcViolation describes a field whose value does not satisfy an
+// invariant declared by its walkabout struct tag.
+type CalcViolation struct {
+	// Path is the location of the field, relative to the root of the
+	// visitation, e.g. "TargetSlice[2].ByRefPtr".
+	Path string
+	// Invariant is the name of the invariant, e.g. "nonnil".
+	Invariant string
+}
+
+// CalcViolations is the error returned by CheckCalcInvariants.
+type CalcViolations []CalcViolation
+
+// Error implements error.
+func (v CalcViolations) Error() string {
+	msgs := make([]string, len(v))
+	for i, x := range v {
+		msgs[i] = fmt.Sprintf("%s (%s)", x.Path, x.Invariant)
+	}
+	return "invariants violated: " + strings.Join(msgs, ", ")
+}
+
+// CheckCalcInvariants visits root and returns CalcViolations
+// if any field does not satisfy the invariants declared by its walkabout
+// struct tag. The supported invariants are "nonnil", for pointer and
+// interface fields, and "nonempty", for slice and map fields. Multiple
+// invariants may be separated by commas.
+func CheckCalcInvariants(root Calc) error {
+	if root == nil {
+		return nil
+	}
+	id, ptr := calcIdentify(root)
+	if ptr == nil {
+		return nil
+	}
+	var ret CalcViolations
+	fn := func(ctx CalcContext, x Calc) CalcDecision {
+		return ctx.Continue()
+	}
+	if _, _, _, err := calcEngine.Execute(CalcWalkerFn(fn), id, ptr, e.TypeID(CalcTypeCalc)); err != nil {
+		return err
+	}
+	if len(ret) > 0 {
+		return ret
+	}
+	return nil
+}
+
+// ------ Marshaling ------
+
+// MarshalCalc returns a JSON representation of x, for use in
+// logging. Each struct is encoded as an object whose "__type" key holds
+// the name of its concrete type, followed by its exported,
+// non-visitable fields and then its visitable fields. Slices and
+// arrays are encoded as lists and maps as objects, while nil values
+// and empty slices or maps are encoded as null. The output is stable,
+// although it cannot currently be unmarshaled. An error will be
+// returned if a value encloses itself, or if a non-visitable field
+// cannot be encoded by encoding/json.
+func MarshalCalc(x Calc) ([]byte, error) {
+	if x == nil {
+		return []byte("null"), nil
+	}
+	id, ptr := calcIdentify(x)
+	return calcEngine.Marshal(id, ptr, calcMarshalLeaves)
+}
+
+// calcMarshalLeaves implements e.LeafFn.
+func calcMarshalLeaves(id e.TypeID, x e.Ptr, fn func(name string, value interface{}) error) error {
+	switch CalcTypeID(id) {
+	case CalcTypeBinaryOp:
+		s := (*BinaryOp)(x)
+		if err := fn("Operator", s.Operator); err != nil {
+			return err
+		}
+	case CalcTypeFunc:
+		s := (*Func)(x)
+		if err := fn("Fn", s.Fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ------ Depth Limits ------
+
+// WalkCalcMaxDepth visits x with the provided callback, but
+// returns an error instead of descending into a value which would
+// require more than maxDepth levels of the engine's stack. The value x
+// occupies one level, and every struct, pointer, interface, slice,
+// array, or map which encloses a value adds another, so that deeply
+// nested slices are limited as well as deeply nested structs. This
+// guards against malformed or adversarial inputs. A maxDepth of zero
+// or less is unlimited.
+func WalkCalcMaxDepth(x Calc, maxDepth int, fn CalcWalkerFn) (_ Calc, changed bool, err error) {
+	return walkCalc(x, fn, e.WithMaxDepth(maxDepth))
 }
 
-// CalcDecision is used by CalcWalkerFn to control visitation.
-// The CalcContext provided to a CalcWalkerFn acts as a factory
-// for CalcDecision instances. In general, the factory methods
-// choose a traversal strategy and additional methods on the
-// CalcDecision can achieve a variety of side-effects.
-type CalcDecision e.Decision
+// ------ Memoization ------
 
-// Intercept registers a function to be called immediately before
-// visiting each field or element of the current value.
-func (d CalcDecision) Intercept(fn CalcWalkerFn) CalcDecision {
-	return CalcDecision((e.Decision)(d).Intercept(fn))
-}
+// CalcMemo records the outcome of visiting struct values in
+// WalkCalcMemo. Values are identified by their structure, so that
+// identical subtrees will only be visited once, whether or not they are
+// shared by reference. Values are first bucketed by a hash of their
+// visitable children and scalar fields, and are then compared with
+// EqualCalc. A CalcMemo is not safe for concurrent use.
+type CalcMemo e.Memo
 
-// Post registers a post-visit function, which will be called after the
-// fields of the current object. The function can make another decision
-// about the current value.
-func (d CalcDecision) Post(fn CalcWalkerFn) CalcDecision {
-	return CalcDecision((e.Decision)(d).Post(fn))
+// NewCalcMemo constructs an empty CalcMemo.
+func NewCalcMemo() *CalcMemo {
+	return (*CalcMemo)(e.NewMemo(calcHashLabel, calcSameLabel))
 }
 
-// Replace allows the currently-visited value to be replaced. All
-// parent nodes will be cloned.
-func (d CalcDecision) Replace(x Calc) CalcDecision {
-	return CalcDecision((e.Decision)(d).Replace(calcIdentify(x)))
+// Len returns the number of outcomes that have been recorded.
+func (m *CalcMemo) Len() int {
+	return (*e.Memo)(m).Len()
 }
 
-// calcIdentify is a utility function to map a Calc into
-// its generated type id and a pointer to the data.
-func calcIdentify(x Calc) (typeId e.TypeID, data e.Ptr) {
-	switch t := x.(type) {
-	case *BinaryOp:
-		typeId = e.TypeID(CalcTypeBinaryOp)
-		data = e.Ptr(t)
-	case *Calculation:
-		typeId = e.TypeID(CalcTypeCalculation)
-		data = e.Ptr(t)
-	case *Func:
-		typeId = e.TypeID(CalcTypeFunc)
-		data = e.Ptr(t)
-	case *Scalar:
-		typeId = e.TypeID(CalcTypeScalar)
-		data = e.Ptr(t)
-	default:
-		// The most probable reason for this is that the generated code
-		// is out of date, or that an implementation of the Calc
-		// interface from another package is being passed in.
-		panic(fmt.Sprintf("unhandled value of type: %T", x))
+// WalkCalcMemo visits x with the provided callback, which must
+// behave as a pure function of the value being visited and its children.
+// Once a struct value has been visited, the outcome is recorded and
+// will be reused whenever an equal value is encountered again. Reused
+// values are neither passed to the callback, nor are their children
+// visited.
+//
+// If memo is nil, the outcomes will only be retained for the duration
+// of the call. Otherwise, the caller-provided memo will be consulted
+// and updated, allowing outcomes to be reused across calls.
+func WalkCalcMemo(x Calc, memo *CalcMemo, fn CalcWalkerFn) (_ Calc, changed bool, err error) {
+	if memo == nil {
+		memo = NewCalcMemo()
 	}
-	return
+	return walkCalc(x, fn, e.WithMemo((*e.Memo)(memo)))
 }
 
-// calcWrap is a utility function to reconstitute a Calc
-// from an internal type token and a pointer to the value.
-func calcWrap(typeId e.TypeID, x e.Ptr) Calc {
-	switch CalcTypeID(typeId) {
+// calcHashLabel hashes the scalar fields of a struct, consistently
+// with calcSameLabel.
+func calcHashLabel(id e.TypeID, x e.Ptr, h *e.Hasher) {
+	switch CalcTypeID(id) {
 	case CalcTypeBinaryOp:
-		return (*BinaryOp)(x)
-	case CalcTypeBinaryOpPtr:
-		return *(**BinaryOp)(x)
-	case CalcTypeCalculation:
-		return (*Calculation)(x)
-	case CalcTypeCalculationPtr:
-		return *(**Calculation)(x)
+		s := (*BinaryOp)(x)
+		h.WriteString(string(s.Operator))
 	case CalcTypeFunc:
-		return (*Func)(x)
-	case CalcTypeFuncPtr:
-		return *(**Func)(x)
-	case CalcTypeScalar:
-		return (*Scalar)(x)
-	case CalcTypeScalarPtr:
-		return *(**Scalar)(x)
-	default:
-		// This is likely a code-generation problem.
-		panic(fmt.Sprintf("unhandled TypeID %d", typeId))
+		s := (*Func)(x)
+		h.WriteString(string(s.Fn))
 	}
 }
 
-// CalcAction is used by CalcContext.Actions() and allows users
-// to have fine-grained control over traversal.
-type CalcAction e.Action
+// ------ Nil Values ------
 
-// ActionVisit constructs a CalcAction that will visit the given value.
-func (c *CalcContext) ActionVisit(x Calc) CalcAction {
-	return CalcAction(c.impl.ActionVisitTypeID(calcIdentify(x)))
+// WalkCalcVisitNils visits x with the provided callback, which
+// will also be invoked with a nil Calc for each nil interface
+// value that an ordinary walk would skip, such as a nil element of a
+// slice or a field which holds a typed-nil pointer. This allows nil
+// values to be reported or replaced. Since there is nothing to descend
+// into, returning a replacement is the only meaningful action for a
+// nil value, and any post-visit function will not be called.
+func WalkCalcVisitNils(x Calc, fn CalcWalkerFn) (_ Calc, changed bool, err error) {
+	return walkCalc(x, fn, e.WithNils(func(impl e.Context, fn e.FacadeFn) e.Decision {
+		return e.Decision(fn.(CalcWalkerFn)(CalcContext{impl}, nil))
+	}))
 }
 
-// ActionCall constructs a CalcAction that will invoke the given callback.
-func (c *CalcContext) ActionCall(fn func() error) CalcAction {
-	return CalcAction(c.impl.ActionCall(fn))
+// ------ Type Filtering ------
+
+// WalkCalcOfTypes visits x with the provided callback, which
+// will only be invoked for struct values whose type token is one of
+// the given types. All other values are descended into as though the
+// callback had returned a zero CalcDecision.
+func WalkCalcOfTypes(x Calc, fn CalcWalkerFn, types ...CalcTypeID) (_ Calc, changed bool, err error) {
+	ids := make([]e.TypeID, len(types))
+	for i, t := range types {
+		ids[i] = e.TypeID(t)
+	}
+	return walkCalc(x, fn, e.WithTypes(ids...))
 }
 
-// ------ Type Enhancements ------
+// WalkCalcExcept visits x with the provided callback, skipping
+// any value whose type token is one of skipTypes, along with all of the
+// values that it encloses, as though the callback had returned
+// CalcContext.Skip for it. Since skipped values are never
+// passed to the callback, they cannot be replaced.
+func WalkCalcExcept(x Calc, skipTypes []CalcTypeID, fn CalcWalkerFn) (_ Calc, changed bool, err error) {
+	ids := make([]e.TypeID, len(skipTypes))
+	for i, t := range skipTypes {
+		ids[i] = e.TypeID(t)
+	}
+	return walkCalc(x, fn, e.WithSkipTypes(ids...))
+}
 
-// calcAbstract is a type-safe facade around e.Abstract.
-type calcAbstract struct {
-	delegate *e.Abstract
+// ------ Exactly-once Visitation ------
+
+// WalkCalcOnce visits x with the provided callback, but will
+// visit each value at most once, even if it is reachable through
+// multiple pointers. A value which has already been visited is skipped,
+// along with all of the values that it encloses. A skipped value is
+// left as-is, even if it was replaced where it was first visited.
+func WalkCalcOnce(x Calc, fn CalcWalkerFn) (_ Calc, changed bool, err error) {
+	return walkCalc(x, fn, e.WithOnce())
 }
 
-var _ CalcAbstract = &calcAbstract{}
+// ------ Post-Order Visitation ------
 
-// CalcAt implements CalcAbstract.
-func (a *calcAbstract) CalcAt(index int) (ret CalcAbstract) {
-	impl := a.delegate.ChildAt(index)
-	if impl == nil {
-		return nil
+// WalkCalcPostOrder visits x with the provided callback, which
+// is only invoked once all of the children of a value have been
+// visited, as though it had been registered as a post-visit function
+// for every value. The callback will therefore see any replacements
+// made to the children. Skip has no effect, since the children have
+// already been visited. Halt prevents any further children from being
+// visited, but the callback will still be invoked for the values which
+// enclose the halting value.
+func WalkCalcPostOrder(x Calc, fn CalcWalkerFn) (_ Calc, changed bool, err error) {
+	return walkCalc(x, func(ctx CalcContext, _ Calc) CalcDecision {
+		return ctx.Continue().Post(fn)
+	})
+}
+
+// ------ Rebuilding ------
+
+// WalkCalcRebuild visits x with the provided callback. Unlike
+// WalkCalc, every visitable value will be copied, even if the
+// callback makes no changes. The result will not share any visitable
+// memory with x, except for back-references which form cycles and
+// values registered with SetCalcInterned.
+func WalkCalcRebuild(x Calc, fn CalcWalkerFn) (Calc, error) {
+	if x == nil {
+		return nil, nil
 	}
-	switch CalcTypeID(impl.TypeID()) {
-	case CalcTypeBinaryOp:
-		ret = (*BinaryOp)(impl.Ptr())
-	case CalcTypeBinaryOpPtr:
-		ret = *(**BinaryOp)(impl.Ptr())
-	case CalcTypeCalculation:
-		ret = (*Calculation)(impl.Ptr())
-	case CalcTypeCalculationPtr:
-		ret = *(**Calculation)(impl.Ptr())
-	case CalcTypeFunc:
-		ret = (*Func)(impl.Ptr())
-	case CalcTypeFuncPtr:
-		ret = *(**Func)(impl.Ptr())
-	case CalcTypeScalar:
-		ret = (*Scalar)(impl.Ptr())
-	case CalcTypeScalarPtr:
-		ret = *(**Scalar)(impl.Ptr())
-	default:
-		ret = &calcAbstract{impl}
+	id, ptr := calcIdentify(x)
+	if ptr == nil {
+		return x, nil
 	}
-	return
+	id, ptr, _, err := calcEngine.Execute(fn, id, ptr, e.TypeID(CalcTypeCalc), e.WithRebuild())
+	if err != nil {
+		return nil, err
+	}
+	return calcWrap(id, ptr), nil
 }
 
-// CalcCount implements CalcAbstract.
-func (a *calcAbstract) CalcCount() int {
-	return a.delegate.NumChildren()
+// SetCalcInterned registers a predicate which identifies
+// interned values of the given struct type, such as shared constants.
+// WalkCalcRebuild will retain an interned value as-is, instead
+// of copying it, unless the callback replaces a value that it
+// encloses. A nil predicate removes any existing registration. This
+// function must not be called concurrently with any visitation, so it
+// is best called from an init function.
+func SetCalcInterned(id CalcTypeID, fn func(x Calc) bool) {
+	if fn == nil {
+		calcEngine.Intern(e.TypeID(id), nil)
+		return
+	}
+	calcEngine.Intern(e.TypeID(id), func(x e.Ptr) bool {
+		return fn(calcWrap(e.TypeID(id), x))
+	})
 }
 
-// CalcTypeID implements CalcAbstract.
-func (a *calcAbstract) CalcTypeID() CalcTypeID {
-	return CalcTypeID(a.delegate.TypeID())
+// ------ String Redaction ------
+
+// RedactCalcStrings applies fn to every exported string field of
+// the structs within root, whether or not the fields are visitable.
+// Structs whose strings are changed will be replaced by updated copies,
+// so root itself is not modified.
+func RedactCalcStrings(root Calc, fn func(string) string) (Calc, bool, error) {
+	return WalkCalc(root, func(ctx CalcContext, x Calc) CalcDecision {
+		switch t := x.(type) {
+		case *BinaryOp:
+			cp := *t
+			changed := false
+			if next := fn(t.Operator); next != t.Operator {
+				cp.Operator = next
+				changed = true
+			}
+			if changed {
+				return ctx.Continue().Replace(&cp)
+			}
+		case *Func:
+			cp := *t
+			changed := false
+			if next := fn(t.Fn); next != t.Fn {
+				cp.Fn = next
+				changed = true
+			}
+			if changed {
+				return ctx.Continue().Replace(&cp)
+			}
+		}
+		return ctx.Continue()
+	})
 }
 
-// CalcAt implements CalcAbstract.
-func (x *BinaryOp) CalcAt(index int) CalcAbstract {
-	self := calcAbstract{calcEngine.Abstract(e.TypeID(CalcTypeBinaryOp), e.Ptr(x))}
-	return self.CalcAt(index)
+// ------ Shape Fingerprints ------
+
+// ShapeOfCalc returns the type tokens of the visitable structs
+// within root, in the order in which they are visited. The values of
+// any scalar fields are ignored, so trees which differ only in those
+// values will have the same shape. A back-reference which is not
+// visited because it would form a cycle is represented by a zero
+// token. A nil value has an empty shape.
+func ShapeOfCalc(root Calc) []CalcTypeID {
+	if root == nil {
+		return nil
+	}
+	id, ptr := calcIdentify(root)
+	if ptr == nil {
+		return nil
+	}
+	var ret []CalcTypeID
+	var fn CalcWalkerFn = func(ctx CalcContext, x Calc) (d CalcDecision) {
+		id, _ := calcIdentify(x)
+		ret = append(ret, CalcTypeID(id))
+		return
+	}
+	_, _, _, _ = calcEngine.Execute(fn, id, ptr, e.TypeID(CalcTypeCalc),
+		e.WithCycleHook(func(e.Path, e.TypeID, e.Ptr) {
+			ret = append(ret, 0)
+		}))
+	return ret
 }
 
-// CalcCount returns 2.
-func (x *BinaryOp) CalcCount() int { return 2 }
+// ------ Per-Walk State ------
 
-// CalcTypeID returns CalcTypeBinaryOp.
-func (*BinaryOp) CalcTypeID() CalcTypeID { return CalcTypeBinaryOp }
+// CalcStateFn is a variation on CalcWalkerFn which also receives
+// the state value passed to WalkCalcState.
+type CalcStateFn func(ctx CalcContext, state interface{}, x Calc) CalcDecision
 
-// WalkCalc visits the receiver with the provided callback.
-func (x *BinaryOp) WalkCalc(fn CalcWalkerFn) (_ *BinaryOp, changed bool, err error) {
-	var y e.Ptr
-	_, y, changed, err = calcEngine.Execute(fn, e.TypeID(CalcTypeBinaryOp), e.Ptr(x), e.TypeID(CalcTypeBinaryOp))
-	if err != nil {
-		return nil, false, err
-	}
-	return (*BinaryOp)(y), changed, nil
+// WalkCalcState visits x with the provided callback. The state
+// will be passed to each invocation of the callback and is also
+// available to post-visit functions via CalcContext.State().
+func WalkCalcState(x Calc, state interface{}, fn CalcStateFn) (_ Calc, changed bool, err error) {
+	walker := CalcWalkerFn(func(ctx CalcContext, x Calc) CalcDecision {
+		return fn(ctx, ctx.State(), x)
+	})
+	return walkCalc(x, walker, e.WithState(state))
 }
 
-// CalcAt implements CalcAbstract.
-func (x *Calculation) CalcAt(index int) CalcAbstract {
-	self := calcAbstract{calcEngine.Abstract(e.TypeID(CalcTypeCalculation), e.Ptr(x))}
-	return self.CalcAt(index)
+// WalkCalcWith visits x with the provided callback. The user
+// data is available to the callback, and to post-visit functions, via
+// CalcContext.Value(). This allows a single CalcWalkerFn to be
+// reused across walks without capturing any variables.
+func WalkCalcWith(x Calc, userData interface{}, fn CalcWalkerFn) (_ Calc, changed bool, err error) {
+	return walkCalc(x, fn, e.WithState(userData))
 }
 
-// CalcCount returns 1.
-func (x *Calculation) CalcCount() int { return 1 }
+// ------ Topological Visitation ------
 
-// CalcTypeID returns CalcTypeCalculation.
-func (*Calculation) CalcTypeID() CalcTypeID { return CalcTypeCalculation }
+// CalcTopoFn is used by WalkCalcTopo.
+type CalcTopoFn func(x Calc) error
 
-// WalkCalc visits the receiver with the provided callback.
-func (x *Calculation) WalkCalc(fn CalcWalkerFn) (_ *Calculation, changed bool, err error) {
-	var y e.Ptr
-	_, y, changed, err = calcEngine.Execute(fn, e.TypeID(CalcTypeCalculation), e.Ptr(x), e.TypeID(CalcTypeCalculation))
-	if err != nil {
-		return nil, false, err
+// WalkCalcTopo treats the named field as the dependencies of a
+// value and invokes the callback on root and its transitive
+// dependencies, such that each value is visited only once and only
+// after all of its dependencies have been visited. The field may be
+// of any visitable type; a struct, or the structs contained within a
+// slice or array, will be treated as dependencies. Values which do not
+// have a field of the given name have no dependencies. An error will
+// be returned if the dependencies form a cycle.
+func WalkCalcTopo(root Calc, depField string, fn CalcTopoFn) error {
+	type key struct {
+		id  e.TypeID
+		ptr e.Ptr
 	}
-	return (*Calculation)(y), changed, nil
+	// Values that are present, but false, are being visited.
+	done := make(map[key]bool)
+	var stack []key
+
+	var visit func(x Calc) error
+	var visitDeps func(a CalcAbstract) error
+
+	visit = func(x Calc) error {
+		id, ptr := calcIdentify(x)
+		if ptr == nil {
+			return nil
+		}
+		k := key{id, ptr}
+		if finished, seen := done[k]; seen {
+			if finished {
+				return nil
+			}
+			msg := ""
+			for i := len(stack) - 1; i >= 0; i-- {
+				if stack[i] == k {
+					for _, s := range stack[i:] {
+						msg += fmt.Sprintf("%s -> ", CalcTypeID(s.id))
+					}
+					break
+				}
+			}
+			return fmt.Errorf("dependency cycle: %s%s", msg, CalcTypeID(id))
+		}
+		done[k] = false
+		stack = append(stack, k)
+
+		self := &calcAbstract{calcEngine.Abstract(id, ptr)}
+		if idx := self.delegate.FieldIndex(depField); idx >= 0 {
+			if err := visitDeps(self.CalcAt(idx)); err != nil {
+				return err
+			}
+		}
+
+		stack = stack[:len(stack)-1]
+		done[k] = true
+		return fn(x)
+	}
+
+	visitDeps = func(a CalcAbstract) error {
+		if a == nil {
+			return nil
+		}
+		if x, ok := a.(Calc); ok {
+			return visit(x)
+		}
+		for i, j := 0, a.CalcCount(); i < j; i++ {
+			if err := visitDeps(a.CalcAt(i)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	if root == nil {
+		return nil
+	}
+	return visit(root)
 }
 
-// CalcAt implements CalcAbstract.
-func (x *Func) CalcAt(index int) CalcAbstract {
-	self := calcAbstract{calcEngine.Abstract(e.TypeID(CalcTypeFunc), e.Ptr(x))}
-	return self.CalcAt(index)
+// ------ Transformers ------
+
+// CalcTransformer applies type-specific rewrites to a Calc,
+// without the need to write a type switch in a CalcWalkerFn.
+// A CalcTransformer should be constructed with
+// NewCalcTransformer and configured by registering a function for
+// each type of interest.
+type CalcTransformer struct {
+	onBinaryOp    func(*BinaryOp) *BinaryOp
+	onCalculation func(*Calculation) *Calculation
+	onFunc        func(*Func) *Func
+	onScalar      func(*Scalar) *Scalar
 }
 
-// CalcCount returns 1.
-func (x *Func) CalcCount() int { return 1 }
+// NewCalcTransformer returns a CalcTransformer which has no
+// registered functions.
+func NewCalcTransformer() *CalcTransformer {
+	return &CalcTransformer{}
+}
 
-// CalcTypeID returns CalcTypeFunc.
-func (*Func) CalcTypeID() CalcTypeID { return CalcTypeFunc }
+// OnBinaryOp registers a function which will be invoked with
+// each BinaryOp, replacing any previously-registered function. If fn
+// returns the pointer that it was given, the value is unchanged.
+// Otherwise, the value is replaced by the returned value.
+func (t *CalcTransformer) OnBinaryOp(fn func(*BinaryOp) *BinaryOp) *CalcTransformer {
+	t.onBinaryOp = fn
+	return t
+}
 
-// WalkCalc visits the receiver with the provided callback.
-func (x *Func) WalkCalc(fn CalcWalkerFn) (_ *Func, changed bool, err error) {
-	var y e.Ptr
-	_, y, changed, err = calcEngine.Execute(fn, e.TypeID(CalcTypeFunc), e.Ptr(x), e.TypeID(CalcTypeFunc))
-	if err != nil {
-		return nil, false, err
-	}
-	return (*Func)(y), changed, nil
+// OnCalculation registers a function which will be invoked with
+// each Calculation, replacing any previously-registered function. If fn
+// returns the pointer that it was given, the value is unchanged.
+// Otherwise, the value is replaced by the returned value.
+func (t *CalcTransformer) OnCalculation(fn func(*Calculation) *Calculation) *CalcTransformer {
+	t.onCalculation = fn
+	return t
 }
 
-// CalcAt implements CalcAbstract.
-func (x *Scalar) CalcAt(index int) CalcAbstract {
-	self := calcAbstract{calcEngine.Abstract(e.TypeID(CalcTypeScalar), e.Ptr(x))}
-	return self.CalcAt(index)
+// OnFunc registers a function which will be invoked with
+// each Func, replacing any previously-registered function. If fn
+// returns the pointer that it was given, the value is unchanged.
+// Otherwise, the value is replaced by the returned value.
+func (t *CalcTransformer) OnFunc(fn func(*Func) *Func) *CalcTransformer {
+	t.onFunc = fn
+	return t
 }
 
-// CalcCount returns 0.
-func (x *Scalar) CalcCount() int { return 0 }
+// OnScalar registers a function which will be invoked with
+// each Scalar, replacing any previously-registered function. If fn
+// returns the pointer that it was given, the value is unchanged.
+// Otherwise, the value is replaced by the returned value.
+func (t *CalcTransformer) OnScalar(fn func(*Scalar) *Scalar) *CalcTransformer {
+	t.onScalar = fn
+	return t
+}
 
-// CalcTypeID returns CalcTypeScalar.
-func (*Scalar) CalcTypeID() CalcTypeID { return CalcTypeScalar }
+// Walk applies the registered functions to x and to the values within
+// it, returning an updated copy of x if any value was replaced. The
+// values within a replacement are visited in turn. An error will be
+// returned if a registered function returns nil.
+func (t *CalcTransformer) Walk(x Calc) (_ Calc, changed bool, err error) {
+	var types []CalcTypeID
+	if t.onBinaryOp != nil {
+		types = append(types, CalcTypeBinaryOp)
+	}
+	if t.onCalculation != nil {
+		types = append(types, CalcTypeCalculation)
+	}
+	if t.onFunc != nil {
+		types = append(types, CalcTypeFunc)
+	}
+	if t.onScalar != nil {
+		types = append(types, CalcTypeScalar)
+	}
+	if len(types) == 0 {
+		return x, false, nil
+	}
+	return WalkCalcOfTypes(x, func(ctx CalcContext, x Calc) CalcDecision {
+		switch x := x.(type) {
+		case *BinaryOp:
+			next := t.onBinaryOp(x)
+			return calcTransformed(ctx, next, next == nil, next == x, "BinaryOp")
+		case *Calculation:
+			next := t.onCalculation(x)
+			return calcTransformed(ctx, next, next == nil, next == x, "Calculation")
+		case *Func:
+			next := t.onFunc(x)
+			return calcTransformed(ctx, next, next == nil, next == x, "Func")
+		case *Scalar:
+			next := t.onScalar(x)
+			return calcTransformed(ctx, next, next == nil, next == x, "Scalar")
+		}
+		return ctx.Continue()
+	}, types...)
+}
 
-// WalkCalc visits the receiver with the provided callback.
-func (x *Scalar) WalkCalc(fn CalcWalkerFn) (_ *Scalar, changed bool, err error) {
-	var y e.Ptr
-	_, y, changed, err = calcEngine.Execute(fn, e.TypeID(CalcTypeScalar), e.Ptr(x), e.TypeID(CalcTypeScalar))
-	if err != nil {
-		return nil, false, err
+// calcTransformed returns the decision for the value returned by a
+// function registered with a CalcTransformer, which is shared by
+// values visited by reference and by value.
+func calcTransformed(ctx CalcContext, next Calc, isNil, unchanged bool, name string) CalcDecision {
+	switch {
+	case isNil:
+		return ctx.Error(fmt.Errorf("the function for %s returned nil", name))
+	case unchanged:
+		return ctx.Continue()
+	default:
+		return ctx.Continue().Replace(next)
 	}
-	return (*Scalar)(y), changed, nil
 }
 
-// WalkCalc visits the receiver with the provided callback.
-func WalkCalc(x Calc, fn CalcWalkerFn) (_ Calc, changed bool, err error) {
-	id, ptr := calcIdentify(x)
-	id, ptr, changed, err = calcEngine.Execute(fn, id, ptr, e.TypeID(CalcTypeCalc))
-	if err != nil {
-		return nil, false, err
+// ------ Tree Assertions ------
+
+// AssertCalcIsTree visits root and returns an error if any
+// struct value is reachable from more than one location, i.e. if the
+// values form a DAG instead of a tree. The error describes the paths
+// to both locations. Back-references which form cycles are not visited
+// and so are not reported; use WalkCalcDetectCycles to find
+// them. Structs which are passed to callbacks by value cannot be
+// identified, so they are not checked.
+func AssertCalcIsTree(root Calc) error {
+	if root == nil {
+		return nil
 	}
-	if changed {
-		return calcWrap(id, ptr), true, nil
+	id, ptr := calcIdentify(root)
+	if ptr == nil {
+		return nil
 	}
-	return x, false, nil
+	type key struct {
+		id  e.TypeID
+		ptr e.Ptr
+	}
+	seen := make(map[key]e.Path)
+	fn := func(ctx CalcContext, x Calc) CalcDecision {
+		id, ptr := calcIdentify(x)
+		k := key{id, ptr}
+		path := ctx.impl.Path()
+		if prior, found := seen[k]; found {
+			return ctx.Error(fmt.Errorf("%s is reachable from both %q and %q",
+				CalcTypeID(id), prior, path))
+		}
+		seen[k] = path
+		return ctx.Continue()
+	}
+	_, _, _, err := calcEngine.Execute(CalcWalkerFn(fn), id, ptr, e.TypeID(CalcTypeCalc))
+	return err
 }
 
-// ------ Union Support -----
-type Calc interface {
-	CalcAbstract
-	isCalcType()
+// ------ Visitor Adapter ------
+
+// WalkCalcCalcVisitor drives a CalcVisitor over x, following the protocol
+// of go/ast.Walk. The Visit method is called for each visitable struct.
+// If it returns a nil visitor, the children of the struct are skipped.
+// Otherwise, the children are visited with the returned visitor w,
+// followed by a call to w.Visit(nil).
+func WalkCalcCalcVisitor(x Calc, v CalcVisitor) error {
+	if v == nil {
+		return nil
+	}
+	stack := []CalcVisitor{v}
+	var fn CalcWalkerFn = func(ctx CalcContext, x Calc) CalcDecision {
+		w := stack[len(stack)-1].Visit(x)
+		if w == nil {
+			return ctx.Skip()
+		}
+		stack = append(stack, w)
+		return ctx.Continue().Post(func(ctx CalcContext, _ Calc) CalcDecision {
+			stack = stack[:len(stack)-1]
+			w.Visit(nil)
+			return ctx.Continue()
+		})
+	}
+	_, _, err := WalkCalc(x, fn)
+	return err
 }
 
-var (
-	_ Calc = &BinaryOp{}
-	_ Calc = &Calculation{}
-	_ Calc = &Func{}
-	_ Calc = &Scalar{}
-)
+// ------ Fan-out ------
 
-func (*BinaryOp) isCalcType()    {}
-func (*Calculation) isCalcType() {}
-func (*Func) isCalcType()        {}
-func (*Scalar) isCalcType()      {} // ------ Type Mapping ------
+// WidestSliceCalc returns the location and length of the longest
+// visitable slice within root, e.g. "TargetSlice[2].NamedTargets". If
+// several slices share the longest length, the first one to be visited
+// is returned. An empty path and a zero length are returned if root
+// does not contain any non-empty slices.
+func WidestSliceCalc(root Calc) (path string, length int) {
+	if root == nil {
+		return "", 0
+	}
+	id, ptr := calcIdentify(root)
+	if ptr == nil {
+		return "", 0
+	}
+	var fn CalcWalkerFn = func(ctx CalcContext, _ Calc) (d CalcDecision) { return }
+	_, _, _, _ = calcEngine.Execute(fn, id, ptr, e.TypeID(CalcTypeCalc),
+		e.WithSliceHook(func(p e.Path, _ e.TypeID, n int) {
+			if n > length {
+				path, length = p.String(), n
+			}
+		}))
+	return path, length
+}
+
+// ------ Type Mapping ------
 var calcEngine = e.New(e.TypeMap{
 	// ------ Structs ------
 	CalcTypeBinaryOp: {
@@ -544,6 +2847,10 @@ var calcEngine = e.New(e.TypeMap{
 		TypeID: e.TypeID(CalcTypeScalarPtr),
 	},
 
+	// ------ Arrays ------
+
+	// ------ Maps ------
+
 	// ------ Slices ------
 	CalcTypeExprSlice: {
 		Copy: func(dest, from e.Ptr) {
@@ -560,23 +2867,82 @@ var calcEngine = e.New(e.TypeMap{
 	},
 })
 
-// These are lightweight type tokens.
+// These are lightweight type tokens. A token retains its value when
+// the code is regenerated, so that tokens may be persisted.
 const (
-	_ CalcTypeID = iota
-	CalcTypeBinaryOp
-	CalcTypeBinaryOpPtr
-	CalcTypeCalc
-	CalcTypeCalculation
-	CalcTypeCalculationPtr
-	CalcTypeExpr
-	CalcTypeExprSlice
-	CalcTypeFunc
-	CalcTypeFuncPtr
-	CalcTypeScalar
-	CalcTypeScalarPtr
+	CalcTypeBinaryOp       CalcTypeID = 1
+	CalcTypeBinaryOpPtr    CalcTypeID = 2
+	CalcTypeCalc           CalcTypeID = 3
+	CalcTypeCalculation    CalcTypeID = 4
+	CalcTypeCalculationPtr CalcTypeID = 5
+	CalcTypeExpr           CalcTypeID = 6
+	CalcTypeExprSlice      CalcTypeID = 7
+	CalcTypeFunc           CalcTypeID = 8
+	CalcTypeFuncPtr        CalcTypeID = 9
+	CalcTypeScalar         CalcTypeID = 10
+	CalcTypeScalarPtr      CalcTypeID = 11
 )
 
+// calcTypeIDLimit is one greater than the largest type token
+// that has ever been assigned. It is used by the code generator to
+// ensure that the tokens of removed types are not reused.
+const calcTypeIDLimit = 12
+
 // String is for debugging use only.
 func (t CalcTypeID) String() string {
 	return calcEngine.Stringify(e.TypeID(t))
 }
+
+// Implements returns true if the struct type denoted by the token
+// implements the interface type denoted by intf.
+func (t CalcTypeID) Implements(intf CalcTypeID) bool {
+	_, ok := calcImplements[t][intf]
+	return ok
+}
+
+// calcImplements maps struct type tokens onto the interface
+// type tokens that they implement.
+var calcImplements = map[CalcTypeID]map[CalcTypeID]struct{}{
+	CalcTypeBinaryOp: {
+		CalcTypeCalc: {},
+		CalcTypeExpr: {},
+	},
+	CalcTypeCalculation: {
+		CalcTypeCalc: {},
+	},
+	CalcTypeFunc: {
+		CalcTypeCalc: {},
+		CalcTypeExpr: {},
+	},
+	CalcTypeScalar: {
+		CalcTypeCalc: {},
+		CalcTypeExpr: {},
+	},
+}
+
+// ------ Layout Verification ------
+
+// init checks that each visitable field still exists and has the type
+// which was recorded in the type map. A field whose type has been
+// changed without re-running the generator would otherwise cause the
+// engine to misinterpret memory. The offset of each field is computed
+// by the compiler, so it needn't be checked.
+func init() {
+	calcVerifyLayout(BinaryOp{}, "BinaryOp", "Left", reflect.TypeOf((*Expr)(nil)).Elem())
+	calcVerifyLayout(BinaryOp{}, "BinaryOp", "Right", reflect.TypeOf((*Expr)(nil)).Elem())
+	calcVerifyLayout(Calculation{}, "Calculation", "Expr", reflect.TypeOf((*Expr)(nil)).Elem())
+	calcVerifyLayout(Func{}, "Func", "Args", reflect.TypeOf((*[]Expr)(nil)).Elem())
+}
+
+// calcVerifyLayout panics if the named field of x does not have the
+// given type. The field may be promoted from an embedded struct.
+func calcVerifyLayout(x interface{}, structName, field string, typ reflect.Type) {
+	f, ok := reflect.TypeOf(x).FieldByName(field)
+	switch {
+	case !ok:
+		panic(fmt.Sprintf("%s.%s no longer exists; the generated code is out of date", structName, field))
+	case f.Type != typ:
+		panic(fmt.Sprintf("%s.%s has type %s, not %s; the generated code is out of date",
+			structName, field, f.Type, typ))
+	}
+}
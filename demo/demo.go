@@ -20,7 +20,7 @@ import "github.com/cockroachdb/walkabout/demo/other"
 
 //lint:file-ignore U1000 Ignore code for demos.
 //go:generate -command walkabout go run ..
-//go:generate walkabout Target
+//go:generate walkabout --verify-layout Target
 
 // Target is a base interface that we run the code-generator against.
 // There's nothing special about this interface.
@@ -34,6 +34,12 @@ var (
 	_ Target = &ByRefType{}
 	_ Target = ByValType{}
 	_ Target = &ContainerType{}
+	_ Target = &WrapperType{}
+	_ Target = &EncapsulatedType{}
+	_ Target = &LooseType{}
+	_ Target = &PairType{}
+	_ Target = &ScopeType{}
+	_ Target = &EmbeddingType{}
 	_ Target = &ignoredType{}
 )
 
@@ -47,9 +53,27 @@ var (
 	_ EmbedsTarget = ByValType{}
 )
 
+// Annotated extends the visitable interface with a method that has
+// nothing to do with visitation. Its implementors are a subset of the
+// implementors of Target, so it is visitable as well.
+type Annotated interface {
+	Target
+	Annotation() string
+}
+
+var (
+	_ Annotated = &ByRefType{}
+)
+
 // Targets is a named slice of a visitable interface.
 type Targets []Target
 
+// Quad is a named array of a visitable interface.
+type Quad [4]Target
+
+// OptTarget is a named pointer to a visitable struct.
+type OptTarget *ByRefType
+
 // ByRefType implements Target with a pointer receiver.
 type ByRefType struct {
 	Val string
@@ -58,6 +82,9 @@ type ByRefType struct {
 // Value implements the Target interface.
 func (x *ByRefType) Value() string { return x.Val }
 
+// Annotation implements the Annotated interface.
+func (x *ByRefType) Annotation() string { return "ByRef: " + x.Val }
+
 // ByValType implements the Target interface with a value receiver.
 type ByValType struct {
 	Val string
@@ -68,6 +95,86 @@ func (ByValType) embedsTarget() {}
 // Value implements the Target interface.
 func (x ByValType) Value() string { return x.Val }
 
+// WrapperType embeds the visitable interface. The promoted field is
+// visited like any other field of an interface type.
+type WrapperType struct {
+	Target
+	Name string
+}
+
+// Value implements the Target interface.
+func (x *WrapperType) Value() string { return "Wrapper: " + x.Name }
+
+// EncapsulatedType hides its children behind an accessor method. The
+// walkabout tag on the unexported field names the method, so that the
+// children will be visited. They cannot be replaced, however.
+type EncapsulatedType struct {
+	children []Target `walkabout:"getter=Children"`
+}
+
+// NewEncapsulated constructs an EncapsulatedType.
+func NewEncapsulated(children ...Target) *EncapsulatedType {
+	return &EncapsulatedType{children: children}
+}
+
+// Children returns the enclosed values.
+func (x *EncapsulatedType) Children() []Target { return x.children }
+
+// Value implements the Target interface.
+func (*EncapsulatedType) Value() string { return "Encapsulated" }
+
+// LooseType stores its child in a field of a broader type. The
+// walkabout tag declares the visitable types which the field may hold,
+// so that it is visited like a field of a visitable interface.
+type LooseType struct {
+	Child interface{} `walkabout:"concrete=ByRefType,ByValType"`
+}
+
+// Value implements the Target interface.
+func (*LooseType) Value() string { return "Loose" }
+
+// PairType holds its children in an array of struct values, which is
+// copied when any element is replaced.
+type PairType struct {
+	Pair [2]ByRefType
+}
+
+// Value implements the Target interface.
+func (*PairType) Value() string { return "Pair" }
+
+// ScopeType holds named children in a map. The entries are visited in
+// key order.
+type ScopeType struct {
+	Env map[string]Target `walkabout:"nonempty"`
+}
+
+// Value implements the Target interface.
+func (*ScopeType) Value() string { return "Scope" }
+
+// EmbeddingType embeds structs which are not themselves visitable. The
+// visitable fields of the embedded structs are promoted, so that they
+// are visited as though they had been declared by EmbeddingType.
+type EmbeddingType struct {
+	EmbeddedFields
+	embeddedScope
+	Own Target
+}
+
+// Value implements the Target interface.
+func (*EmbeddingType) Value() string { return "Embedding" }
+
+// EmbeddedFields does not implement Target, so it is only visited
+// through the structs which embed it.
+type EmbeddedFields struct {
+	Promoted  Target
+	Promoteds []Target
+}
+
+// embeddedScope is not exported, but its fields may still be promoted.
+type embeddedScope struct {
+	Scoped Target
+}
+
 // ignoredType is not exported, so it won't appear in the API.
 type ignoredType struct{}
 
@@ -97,7 +204,7 @@ func (UnionableType) isUnionable() {}
 // whose types implement or contain Target.
 type ContainerType struct {
 	ByRef         ByRefType
-	ByRefPtr      *ByRefType
+	ByRefPtr      *ByRefType `walkabout:"nonnil"`
 	ByRefSlice    []ByRefType
 	ByRefPtrSlice []*ByRefType
 
@@ -116,8 +223,9 @@ type ContainerType struct {
 	EmbedsTarget    EmbedsTarget
 	EmbedsTargetPtr *EmbedsTarget
 
-	// Slices of interfaces are supported.
-	TargetSlice []Target
+	// Slices of interfaces are supported. Invariants may be declared
+	// on visitable fields and checked with CheckTargetInvariants.
+	TargetSlice []Target `walkabout:"nonempty"`
 
 	// We can support slices of interface pointers.
 	InterfacePtrSlice []*Target
@@ -125,11 +233,25 @@ type ContainerType struct {
 	// Demonstrate use of named visitable type.
 	NamedTargets Targets
 
+	// Arrays are visited like slices and replaced like structs.
+	Quad Quad
+
+	// Named pointer types are visited like regular pointers.
+	OptTarget OptTarget
+
+	// Interfaces which extend the visitable interface with unrelated
+	// methods are visited like any other interface.
+	Annotated Annotated
+
 	// Unexported fields aren't generated.
 	ignored ByRefType
 	// Unexported types aren't generated.
 	Ignored *ignoredType
 
+	// Visitable fields may opt out of visitation, e.g. to hold a copy
+	// of another field.
+	Cached Target `walkabout:"-"`
+
 	// This field will be generated only when in --union mode.
 	UnionableType *UnionableType
 
@@ -137,9 +259,8 @@ type ContainerType struct {
 	ReachableType ReachableType
 
 	// This type is declared in another package. It shouldn't be present
-	// in any configuration, unless we allow the code generator to
-	// start writing to multiple directories, in which case we can make
-	// --union --reachable work.
+	// in any configuration, since it can't be made to implement the
+	// --union interface; see the comment on other.Reachable.
 	OtherReachable other.Reachable
 
 	// This field is in --reachable mode, since it does implement
@@ -199,6 +320,9 @@ func NewContainer(useValuePtrs bool) (*ContainerType, int) {
 
 		TargetSlice:  []Target{target(), target()},
 		NamedTargets: []Target{target(), target()},
+		Quad:         Quad{target(), nil, target(), target()},
+		OptTarget:    &ByRefType{olleh()},
+		Annotated:    &ByRefType{olleh()},
 
 		InterfacePtrSlice: []*Target{&p1, nil, &nilTarget, &typedNil, &p2, &p3},
 	}
@@ -54,6 +54,9 @@ func Example_abstract() {
 	//13: []Target *demo.targetAbstract
 	//14: []*Target *demo.targetAbstract
 	//15: []Target *demo.targetAbstract
+	//16: [4]Target *demo.targetAbstract
+	//17: ByRefType *demo.ByRefType
+	//18: ByRefType *demo.ByRefType
 }
 
 // This example shows how an error can be returned from a visitor function.
@@ -93,7 +96,7 @@ func Example_walk() {
 	fmt.Printf("Saw %d Container, %d ByValType, and %d ByRefType",
 		container, byVal, byRef)
 	//Output:
-	//Saw 1 Container, 17 ByValType, and 6 ByRefType
+	//Saw 1 Container, 20 ByValType, and 8 ByRefType
 }
 
 // This example demonstrates how pre- and post-visitation works. It
@@ -0,0 +1,128 @@
+// Copyright 2019 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+package demo
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// The code for Shape is generated with --value-facades, so that
+// callbacks receive a Square by value and a Group by reference.
+//go:generate -command walkabout go run ..
+//go:generate walkabout --value-facades Shape
+
+// Shape is implemented by value and by reference.
+type Shape interface {
+	Sides() int
+}
+
+// Square implements Shape with a value receiver.
+type Square struct {
+	Side int
+}
+
+// Sides implements Shape.
+func (Square) Sides() int { return 4 }
+
+// Group implements Shape with a pointer receiver.
+type Group struct {
+	Label  string
+	Shapes []Shape
+}
+
+// Sides implements Shape.
+func (g *Group) Sides() int {
+	ret := 0
+	for _, s := range g.Shapes {
+		ret += s.Sides()
+	}
+	return ret
+}
+
+// TestValueFacades ensures that replacements are handled in the same
+// way, whether a value is passed to a callback by value or by reference.
+func TestValueFacades(t *testing.T) {
+	newGroup := func() *Group {
+		return &Group{Label: "outer", Shapes: []Shape{
+			Square{Side: 1},
+			&Group{Label: "inner", Shapes: []Shape{Square{Side: 2}}},
+		}}
+	}
+
+	t.Run("callback", func(t *testing.T) {
+		a := assert.New(t)
+		x := newGroup()
+		ret, changed, err := WalkShape(x, func(ctx ShapeContext, x Shape) ShapeDecision {
+			switch t := x.(type) {
+			case Square:
+				return ctx.Continue().Replace(Square{Side: t.Side * 10})
+			case *Group:
+				if t.Label == "inner" {
+					return ctx.Continue().Replace(&Group{Label: "INNER"})
+				}
+			}
+			return ctx.Continue()
+		})
+		if !a.NoError(err) || !a.True(changed) {
+			return
+		}
+		y := ret.(*Group)
+		a.Equal(Square{Side: 10}, y.Shapes[0])
+		inner := y.Shapes[1].(*Group)
+		a.Equal(&Group{Label: "INNER"}, inner)
+		a.Equal(newGroup(), x)
+	})
+
+	t.Run("transformer", func(t *testing.T) {
+		a := assert.New(t)
+		x := newGroup()
+		// Both functions modify the value that they are given and return
+		// it. A Group is modified in place, while a Square is replaced.
+		ret, changed, err := NewShapeTransformer().
+			OnSquare(func(x *Square) *Square {
+				x.Side *= 10
+				return x
+			}).
+			OnGroup(func(x *Group) *Group {
+				x.Label += "!"
+				return x
+			}).
+			Walk(x)
+		if !a.NoError(err) || !a.True(changed) {
+			return
+		}
+		y := ret.(*Group)
+		a.Equal("outer!", y.Label)
+		a.Equal(Square{Side: 10}, y.Shapes[0])
+		inner := y.Shapes[1].(*Group)
+		a.Equal("inner!", inner.Label)
+		a.Equal(Square{Side: 20}, inner.Shapes[0])
+
+		// A copy which is returned unmodified makes no change.
+		_, changed, err = NewShapeTransformer().
+			OnSquare(func(x *Square) *Square { return x }).
+			Walk(newGroup())
+		a.NoError(err)
+		a.False(changed)
+
+		_, _, err = NewShapeTransformer().
+			OnSquare(func(*Square) *Square { return nil }).
+			Walk(newGroup())
+		a.EqualError(err, "the function for Square returned nil")
+	})
+}
@@ -0,0 +1,1836 @@
+// Copyright 2019 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+package demo_test
+
+// This file contains tests for the generated helper functions which
+// are built on top of the core visitation API.
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	l "github.com/cockroachdb/walkabout/demo"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDepth(t *testing.T) {
+	t.Run("nil", func(t *testing.T) {
+		a := assert.New(t)
+		a.Equal(0, l.DepthOfTarget(nil))
+	})
+	t.Run("single", func(t *testing.T) {
+		a := assert.New(t)
+		a.Equal(0, l.DepthOfTarget(&l.ByRefType{}))
+	})
+	t.Run("nested", func(t *testing.T) {
+		a := assert.New(t)
+		x, _ := l.NewContainer(true)
+		a.Equal(1, l.DepthOfTarget(x))
+
+		x.Container, _ = l.NewContainer(false)
+		a.Equal(2, l.DepthOfTarget(x))
+	})
+	t.Run("cycle", func(t *testing.T) {
+		a := assert.New(t)
+		x, _ := l.NewContainer(true)
+		x.Container = x
+		a.Equal(1, l.DepthOfTarget(x))
+	})
+	t.Run("context", func(t *testing.T) {
+		a := assert.New(t)
+		x, _ := l.NewContainer(true)
+		x.Container = &l.ContainerType{}
+		depths := make(map[string][]int)
+		_, _, err := x.WalkTarget(func(ctx l.TargetContext, x l.Target) (d l.TargetDecision) {
+			key := x.Value()
+			depths[key] = append(depths[key], ctx.Depth())
+			return
+		})
+		a.NoError(err)
+		a.Equal([]int{0, 1}, depths["Container"])
+	})
+}
+
+func TestByDepth(t *testing.T) {
+	t.Run("nil", func(t *testing.T) {
+		a := assert.New(t)
+		a.Empty(l.TargetByDepth(nil))
+	})
+	t.Run("nested", func(t *testing.T) {
+		a := assert.New(t)
+		leaf := &l.ByRefType{Val: "Leaf"}
+		inner := &l.ContainerType{ByRefPtr: leaf}
+		x := &l.ContainerType{Container: inner}
+		// Create a cycle, which should be ignored.
+		inner.Container = x
+
+		layers := l.TargetByDepth(x)
+		if a.Len(layers, 3) {
+			a.Equal([]l.Target{x}, layers[0])
+			a.Equal(l.Target(inner), layers[1][len(layers[1])-1])
+			a.Contains(layers[2], l.Target(leaf))
+			for _, node := range layers[2] {
+				a.False(node == l.Target(x), "cycle-broken value should not be present")
+			}
+		}
+	})
+}
+
+func TestWidestSlice(t *testing.T) {
+	a := assert.New(t)
+	path, length := l.WidestSliceTarget(nil)
+	a.Equal("", path)
+	a.Equal(0, length)
+
+	x := &l.ContainerType{
+		TargetSlice: []l.Target{
+			&l.ByRefType{},
+			&l.ContainerType{NamedTargets: l.Targets{nil, nil, nil}},
+		},
+		InterfacePtrSlice: []*l.Target{nil, nil, nil},
+	}
+	path, length = l.WidestSliceTarget(x)
+	a.Equal("TargetSlice[1].NamedTargets", path)
+	a.Equal(3, length)
+
+	path, length = l.WidestSliceTarget(&l.ByRefType{})
+	a.Equal("", path)
+	a.Equal(0, length)
+}
+
+func TestMemo(t *testing.T) {
+	// Create a container that shares a pointer between two fields.
+	newShared := func() *l.ContainerType {
+		shared := &l.ByRefType{Val: "Shared"}
+		return &l.ContainerType{
+			ByRefPtr:      shared,
+			ByRefPtrSlice: []*l.ByRefType{shared, {Val: "Unshared"}},
+		}
+	}
+
+	// Upper-case the value in every ByRefType, counting the calls. Note
+	// that the by-value ContainerType.ByRef field is always visited.
+	calls := 0
+	fn := func(ctx l.TargetContext, x l.Target) l.TargetDecision {
+		if t, ok := x.(*l.ByRefType); ok {
+			calls++
+			return ctx.Continue().Replace(&l.ByRefType{Val: strings.ToUpper(t.Val)})
+		}
+		return ctx.Continue()
+	}
+
+	t.Run("unmemoized", func(t *testing.T) {
+		a := assert.New(t)
+		calls = 0
+		_, changed, err := l.WalkTarget(newShared(), fn)
+		a.NoError(err)
+		a.True(changed)
+		a.Equal(4, calls)
+	})
+
+	t.Run("per-call", func(t *testing.T) {
+		a := assert.New(t)
+		calls = 0
+		x := newShared()
+		ret, changed, err := l.WalkTargetMemo(x, nil, fn)
+		if !a.NoError(err) {
+			return
+		}
+		a.True(changed)
+		a.Equal(3, calls)
+
+		y := ret.(*l.ContainerType)
+		a.Equal("SHARED", y.ByRefPtr.Val)
+		a.True(y.ByRefPtr == y.ByRefPtrSlice[0], "sharing should be preserved")
+		a.Equal("UNSHARED", y.ByRefPtrSlice[1].Val)
+		a.Equal("Shared", x.ByRefPtr.Val, "input should not be modified")
+	})
+
+	t.Run("caller-provided", func(t *testing.T) {
+		a := assert.New(t)
+		calls = 0
+		x := newShared()
+		memo := l.NewTargetMemo()
+
+		first, changed, err := l.WalkTargetMemo(x, memo, fn)
+		a.NoError(err)
+		a.True(changed)
+		a.Equal(3, calls)
+		a.NotZero(memo.Len())
+
+		// The second walk should consult the memo and not call fn at all,
+		// since the root value has already been visited.
+		second, changed, err := l.WalkTargetMemo(x, memo, fn)
+		a.NoError(err)
+		a.True(changed)
+		a.Equal(3, calls)
+		a.True(first == second)
+	})
+
+	t.Run("structural", func(t *testing.T) {
+		a := assert.New(t)
+		calls = 0
+		// The two values are equal, but are not shared by reference.
+		x := &l.ContainerType{
+			ByRefPtr:      &l.ByRefType{Val: "Same"},
+			ByRefPtrSlice: []*l.ByRefType{{Val: "Same"}, {Val: "Different"}},
+		}
+		ret, changed, err := l.WalkTargetMemo(x, nil, fn)
+		if !a.NoError(err) {
+			return
+		}
+		a.True(changed)
+		a.Equal(3, calls)
+
+		y := ret.(*l.ContainerType)
+		a.Equal("SAME", y.ByRefPtr.Val)
+		a.True(y.ByRefPtr == y.ByRefPtrSlice[0], "outcome should be reused")
+		a.Equal("DIFFERENT", y.ByRefPtrSlice[1].Val)
+	})
+
+	t.Run("unchanged", func(t *testing.T) {
+		a := assert.New(t)
+		// Reusing the outcome of an unchanged value should not replace
+		// an equal value with it.
+		x := &l.ContainerType{
+			ByRefPtr:      &l.ByRefType{Val: "Same"},
+			ByRefPtrSlice: []*l.ByRefType{{Val: "Same"}},
+		}
+		ret, changed, err := l.WalkTargetMemo(x, nil, func(ctx l.TargetContext, x l.Target) l.TargetDecision {
+			return ctx.Continue()
+		})
+		a.NoError(err)
+		a.False(changed)
+		a.True(ret == x)
+	})
+}
+
+func TestWalkOnce(t *testing.T) {
+	// Create a DAG in which two fields refer to the same value.
+	shared := &l.ByRefType{Val: "Shared"}
+	x := &l.ContainerType{
+		ByRefPtr:  shared,
+		OptTarget: shared,
+	}
+
+	var visited []string
+	fn := func(ctx l.TargetContext, x l.Target) l.TargetDecision {
+		if t, ok := x.(*l.ByRefType); ok {
+			visited = append(visited, t.Val)
+		}
+		return ctx.Continue()
+	}
+
+	t.Run("default", func(t *testing.T) {
+		a := assert.New(t)
+		visited = nil
+		_, changed, err := l.WalkTarget(x, fn)
+		a.NoError(err)
+		a.False(changed)
+		// The by-value ContainerType.ByRef field is always visited.
+		a.Equal([]string{"", "Shared", "Shared"}, visited)
+	})
+
+	t.Run("once", func(t *testing.T) {
+		a := assert.New(t)
+		visited = nil
+		_, changed, err := l.WalkTargetOnce(x, fn)
+		a.NoError(err)
+		a.False(changed)
+		a.Equal([]string{"", "Shared"}, visited)
+	})
+
+	t.Run("cycle", func(t *testing.T) {
+		a := assert.New(t)
+		y, _ := l.NewContainer(false)
+		y.Container = y
+		ret, changed, err := l.WalkTargetOnce(y, func(ctx l.TargetContext, x l.Target) l.TargetDecision {
+			return ctx.Continue()
+		})
+		a.NoError(err)
+		a.False(changed)
+		a.True(ret == l.Target(y))
+	})
+}
+
+func TestRebuild(t *testing.T) {
+	a := assert.New(t)
+	x, _ := l.NewContainer(true)
+
+	y, err := l.WalkTargetRebuild(x, func(ctx l.TargetContext, x l.Target) l.TargetDecision {
+		return ctx.Continue()
+	})
+	if !a.NoError(err) {
+		return
+	}
+	ret := y.(*l.ContainerType)
+	a.Equal(x, ret)
+	a.True(x != ret)
+	a.True(x.ByRefPtr != ret.ByRefPtr)
+	a.True(x.ByValPtrSlice[0] != ret.ByValPtrSlice[0])
+	a.True(x.AnotherTargetPtr != ret.AnotherTargetPtr)
+	a.True(x.AnotherTarget.(*l.ByValType) != ret.AnotherTarget.(*l.ByValType))
+
+	// Mutating the copy should not affect the input.
+	ret.ByRefPtr.Val = "Changed"
+	ret.ByRefSlice[0].Val = "Changed"
+	a.Equal("olleH", x.ByRefPtr.Val)
+	a.Equal("olleH", x.ByRefSlice[0].Val)
+
+	t.Run("empty", func(t *testing.T) {
+		a := assert.New(t)
+		scope := &l.ScopeType{Env: map[string]l.Target{}}
+		x := &l.ContainerType{
+			ByRefSlice:  make([]l.ByRefType, 0, 4),
+			TargetSlice: []l.Target{scope},
+		}
+		y, err := l.WalkTargetRebuild(x, func(ctx l.TargetContext, x l.Target) l.TargetDecision {
+			return ctx.Continue()
+		})
+		if !a.NoError(err) {
+			return
+		}
+		ret := y.(*l.ContainerType)
+		a.Equal(x, ret)
+
+		// Growing the empty copies should not affect the input.
+		ret.ByRefSlice = append(ret.ByRefSlice, l.ByRefType{Val: "Changed"})
+		ret.TargetSlice[0].(*l.ScopeType).Env["a"] = &l.ByRefType{}
+		a.Equal("", x.ByRefSlice[:1][0].Val)
+		a.Empty(scope.Env)
+	})
+}
+
+func TestClone(t *testing.T) {
+	a := assert.New(t)
+	x, _ := l.NewContainer(true)
+
+	y := x.CloneTarget()
+	a.Equal(x, y)
+	a.True(x != y)
+	a.True(x.ByRefPtr != y.ByRefPtr)
+	a.True(x.AnotherTarget.(*l.ByValType) != y.AnotherTarget.(*l.ByValType))
+
+	// Mutating the copy should not affect the input.
+	y.ByRefPtr.Val = "Changed"
+	y.ByValSlice[0].Val = "Changed"
+	a.Equal("olleH", x.ByRefPtr.Val)
+	a.Equal("olleH", x.ByValSlice[0].Val)
+
+	// A nil receiver should be cloned as nil.
+	a.Nil((*l.ContainerType)(nil).CloneTarget())
+}
+
+func TestEqual(t *testing.T) {
+	a := assert.New(t)
+	x, _ := l.NewContainer(true)
+	y := x.CloneTarget()
+
+	a.True(l.EqualTarget(x, y))
+	a.True(x.EqualTarget(y))
+	a.True(l.EqualTarget(nil, nil))
+	a.False(l.EqualTarget(x, nil))
+	a.True((*l.ContainerType)(nil).EqualTarget(nil))
+	a.False(x.EqualTarget(nil))
+
+	// Scalar fields are compared.
+	y.ByRefPtr.Val = "Changed"
+	a.False(x.EqualTarget(y))
+	y.ByRefPtr.Val = x.ByRefPtr.Val
+	a.True(x.EqualTarget(y))
+
+	// Differing types are never equal.
+	a.False(l.EqualTarget(&l.ByRefType{Val: "A"}, &l.ByValType{Val: "A"}))
+
+	// Differing slice lengths are not equal.
+	y.ByRefSlice = append(y.ByRefSlice, l.ByRefType{})
+	a.False(x.EqualTarget(y))
+	y.ByRefSlice = x.ByRefSlice
+
+	// Nil and empty slices, as well as nil pointers and typed-nil
+	// interfaces are equal.
+	a.True((&l.ContainerType{}).EqualTarget(&l.ContainerType{ByRefSlice: []l.ByRefType{}}))
+	a.True((&l.ContainerType{}).EqualTarget(&l.ContainerType{AnotherTarget: (*l.ByValType)(nil)}))
+	a.False((&l.ContainerType{}).EqualTarget(&l.ContainerType{ByRefPtr: &l.ByRefType{}}))
+
+	// Map keys are compared.
+	s1 := &l.ScopeType{Env: map[string]l.Target{"a": &l.ByRefType{Val: "A"}}}
+	s2 := &l.ScopeType{Env: map[string]l.Target{"b": &l.ByRefType{Val: "A"}}}
+	a.False(s1.EqualTarget(s2))
+	s2 = &l.ScopeType{Env: map[string]l.Target{"a": &l.ByRefType{Val: "A"}}}
+	a.True(s1.EqualTarget(s2))
+
+	// Map entries are matched by key, rather than by position.
+	s1.Env["b"] = &l.ByRefType{Val: "B"}
+	s2.Env["c"] = &l.ByRefType{Val: "B"}
+	a.False(s1.EqualTarget(s2))
+	delete(s2.Env, "c")
+	s2.Env["b"] = &l.ByRefType{Val: "B"}
+	a.True(s1.EqualTarget(s2))
+	s2.Env["b"] = &l.ByRefType{Val: "b"}
+	a.False(s1.EqualTarget(s2))
+
+	// Cycles should terminate.
+	c1 := &l.ContainerType{}
+	c1.AnotherTarget = c1
+	c2 := &l.ContainerType{}
+	c2.AnotherTarget = c2
+	a.True(c1.EqualTarget(c2))
+}
+
+func TestFieldAccessors(t *testing.T) {
+	a := assert.New(t)
+	x, _ := l.NewContainer(true)
+
+	// Struct and array fields are returned by reference.
+	a.True(x.ByRefField() == &x.ByRef)
+	a.True(x.QuadField() == &x.Quad)
+	a.True(x.ByRefPtrField() == x.ByRefPtr)
+	a.Equal(x.TargetSlice, x.TargetSliceField())
+	a.Equal(x.AnotherTarget, x.AnotherTargetField())
+
+	x.ByValField().Val = "Changed"
+	a.Equal("Changed", x.ByVal.Val)
+}
+
+func TestFieldBuilders(t *testing.T) {
+	a := assert.New(t)
+	x, _ := l.NewContainer(true)
+	original := x.CloneTarget()
+
+	ptr := &l.ByRefType{Val: "replaced"}
+	y := x.WithByRefPtr(ptr)
+	a.True(y != x)
+	a.True(y.ByRefPtr == ptr)
+	a.True(y.ByValPtr == x.ByValPtr)
+	a.True(x.EqualTarget(original))
+
+	// The replaced field does not share storage with the original.
+	y = x.WithByRef(l.ByRefType{Val: "replaced"})
+	a.True(y.ByRefField() != x.ByRefField())
+	y.ByRef.Val = "changed"
+	a.Equal(original.ByRef.Val, x.ByRef.Val)
+
+	y = x.WithQuad(l.Quad{l.ByValType{Val: "quad"}})
+	y.Quad[1] = l.ByValType{Val: "changed"}
+	a.Equal(original.Quad, x.Quad)
+	a.True(x.EqualTarget(original))
+
+	// Builders may be chained.
+	y = x.WithByVal(l.ByValType{Val: "a"}).WithContainer(nil)
+	a.Equal("a", y.ByVal.Val)
+	a.Nil(y.Container)
+	a.True(x.EqualTarget(original))
+}
+
+func TestFindAll(t *testing.T) {
+	a := assert.New(t)
+	one, two, three := &l.Scalar{}, &l.Scalar{}, &l.Scalar{}
+	c := &l.Calculation{Expr: &l.Func{Fn: "Avg", Args: []l.Expr{
+		&l.BinaryOp{Operator: "+", Left: one, Right: two},
+		three,
+	}}}
+
+	found := l.FindAllScalarInCalc(c)
+	a.Len(found, 3)
+	a.True(found[0] == one)
+	a.True(found[1] == two)
+	a.True(found[2] == three)
+	a.Len(l.FindAllFuncInCalc(c), 1)
+	a.Nil(l.FindAllCalculationInCalc(one))
+	a.Nil(l.FindAllScalarInCalc(nil))
+
+	// The root value should also be found.
+	x, _ := l.NewContainer(true)
+	containers := l.FindAllContainerTypeInTarget(x)
+	if a.Len(containers, 1) {
+		a.True(containers[0] == x)
+	}
+	a.True(l.FindAllByRefTypeInTarget(x)[1] == x.ByRefPtr)
+}
+
+func TestInspect(t *testing.T) {
+	a := assert.New(t)
+	x, _ := l.NewContainer(true)
+	// Cycles must still be broken.
+	x.Container = x
+
+	var walked, inspected, methods []l.Target
+	_, _, err := l.WalkTarget(x, func(ctx l.TargetContext, x l.Target) (d l.TargetDecision) {
+		walked = append(walked, x)
+		return
+	})
+	a.NoError(err)
+	l.InspectTarget(x, func(x l.Target) { inspected = append(inspected, x) })
+	x.InspectTarget(func(x l.Target) { methods = append(methods, x) })
+	a.NotEmpty(walked)
+	a.Equal(walked, inspected)
+	a.Equal(walked, methods)
+
+	// Nil values are ignored.
+	l.InspectTarget(nil, func(l.Target) { a.Fail("should not be called") })
+	(*l.ContainerType)(nil).InspectTarget(func(l.Target) { a.Fail("should not be called") })
+}
+
+// TestHistogram checks the counts reported by Example_walk.
+func TestHistogram(t *testing.T) {
+	a := assert.New(t)
+	x, _ := l.NewContainer(true)
+	a.Equal(map[l.TargetTypeID]int{
+		l.TargetTypeContainerType: 1,
+		l.TargetTypeByValType:     20,
+		l.TargetTypeByRefType:     8,
+	}, l.TargetHistogram(x))
+
+	a.Empty(l.TargetHistogram(nil))
+}
+
+func TestDump(t *testing.T) {
+	a := assert.New(t)
+	c := &l.Calculation{Expr: &l.Func{Fn: "Avg", Args: []l.Expr{
+		&l.BinaryOp{Operator: "+", Left: &l.Scalar{}, Right: &l.Scalar{}},
+		&l.Scalar{},
+	}}}
+	a.Equal(`Calculation
+  Expr: Func
+    Args: []Expr
+      [0]: BinaryOp
+        Left: Scalar
+        Right: Scalar
+      [1]: Scalar
+`, l.DumpCalc(c))
+
+	// Cycles should be marked, rather than followed.
+	x := &l.ContainerType{TargetSlice: []l.Target{&l.ByValType{}, nil}}
+	x.Container = x
+	dump := l.DumpTarget(x)
+	a.Contains(dump, "\n  Container: ContainerType <back-reference>\n")
+	a.Contains(dump, "\n  TargetSlice: []Target\n    [0]: ByValType\n    [1]: <nil>\n")
+	a.Equal("<nil>\n", l.DumpTarget(nil))
+}
+
+func TestMarshal(t *testing.T) {
+	a := assert.New(t)
+	c := &l.Calculation{Expr: &l.Func{Fn: "Avg", Args: []l.Expr{
+		&l.BinaryOp{Operator: "+", Left: &l.Scalar{}, Right: nil},
+		&l.Scalar{},
+	}}}
+	data, err := l.MarshalCalc(c)
+	if a.NoError(err) {
+		a.Equal(`{"__type":"Calculation","Expr":{"__type":"Func","Fn":"Avg","Args":[`+
+			`{"__type":"BinaryOp","Operator":"+","Left":{"__type":"Scalar"},"Right":null},`+
+			`{"__type":"Scalar"}]}}`, string(data))
+	}
+
+	// The output must be valid and stable.
+	x, _ := l.NewContainer(true)
+	data, err = l.MarshalTarget(x)
+	if a.NoError(err) {
+		a.True(json.Valid(data), string(data))
+		for i := 0; i < 10; i++ {
+			again, err := l.MarshalTarget(x)
+			a.NoError(err)
+			a.Equal(string(data), string(again))
+		}
+	}
+
+	// Cycles cannot be represented.
+	x.Container = x
+	_, err = l.MarshalTarget(x)
+	a.EqualError(err, "ContainerType encloses itself, so it cannot be marshaled")
+
+	data, err = l.MarshalTarget(nil)
+	a.NoError(err)
+	a.Equal("null", string(data))
+}
+
+func TestParentContext(t *testing.T) {
+	a := assert.New(t)
+	c := &l.Calculation{Expr: &l.Func{Fn: "Avg", Args: []l.Expr{
+		&l.BinaryOp{Operator: "+", Left: &l.Scalar{}, Right: &l.Scalar{}},
+		&l.Scalar{},
+		&l.BinaryOp{Operator: "-", Left: &l.Scalar{}, Right: &l.Func{Args: []l.Expr{&l.Scalar{}}}},
+	}}}
+
+	for _, parallel := range []bool{false, true} {
+		var mu sync.Mutex
+		count := 0
+		_, _, err := l.WalkCalc(c, func(ctx l.CalcContext, x l.Calc) l.CalcDecision {
+			parent, ok := ctx.Parent()
+			if _, isCalc := x.(*l.Calculation); isCalc {
+				a.False(ok)
+				a.Nil(parent)
+			} else {
+				a.True(ok)
+			}
+			if _, isScalar := x.(*l.Scalar); isScalar {
+				if _, isBinary := parent.(*l.BinaryOp); isBinary {
+					mu.Lock()
+					count++
+					mu.Unlock()
+				}
+			}
+			if parallel {
+				return ctx.Parallel()
+			}
+			return ctx.Continue()
+		})
+		a.NoError(err)
+		a.Equal(3, count, "parallel=%t", parallel)
+	}
+
+	// The parent is the original value, even once a sibling has been
+	// replaced.
+	op := c.Expr.(*l.Func).Args[0].(*l.BinaryOp)
+	_, changed, err := l.WalkCalc(c, func(ctx l.CalcContext, x l.Calc) l.CalcDecision {
+		if x == op.Left {
+			return ctx.Continue().Replace(&l.Scalar{})
+		}
+		if x == op.Right {
+			parent, _ := ctx.Parent()
+			a.True(parent == l.Calc(op))
+		}
+		return ctx.Continue()
+	})
+	a.NoError(err)
+	a.True(changed)
+}
+
+func TestRebuildInterned(t *testing.T) {
+	zero := &l.ByRefType{Val: "0"}
+	l.SetTargetInterned(l.TargetTypeByRefType, func(x l.Target) bool {
+		return x.(*l.ByRefType).Val == "0"
+	})
+	defer l.SetTargetInterned(l.TargetTypeByRefType, nil)
+
+	t.Run("unmodified", func(t *testing.T) {
+		a := assert.New(t)
+		x := &l.ContainerType{
+			ByRefPtr:      zero,
+			ByRefPtrSlice: []*l.ByRefType{zero, {Val: "1"}},
+			AnotherTarget: zero,
+		}
+		y, err := l.WalkTargetRebuild(x, func(ctx l.TargetContext, x l.Target) l.TargetDecision {
+			return ctx.Continue()
+		})
+		if !a.NoError(err) {
+			return
+		}
+		ret := y.(*l.ContainerType)
+		a.Equal(x, ret)
+		a.True(x != ret)
+		a.True(ret.ByRefPtr == zero)
+		a.True(ret.ByRefPtrSlice[0] == zero)
+		a.True(ret.AnotherTarget.(*l.ByRefType) == zero)
+		a.True(x.ByRefPtrSlice[1] != ret.ByRefPtrSlice[1])
+	})
+
+	t.Run("modified", func(t *testing.T) {
+		a := assert.New(t)
+		l.SetTargetInterned(l.TargetTypeContainerType, func(l.Target) bool { return true })
+		defer l.SetTargetInterned(l.TargetTypeContainerType, nil)
+
+		inner := &l.ContainerType{ByRefPtr: &l.ByRefType{Val: "1"}}
+		x := &l.ContainerType{ByRefPtr: zero, Container: inner}
+		y, err := l.WalkTargetRebuild(x, func(ctx l.TargetContext, x l.Target) (d l.TargetDecision) {
+			if t, ok := x.(*l.ByRefType); ok && t.Val == "1" {
+				d = d.Replace(&l.ByRefType{Val: "2"})
+			}
+			return
+		})
+		if !a.NoError(err) {
+			return
+		}
+		// The containers enclose a replacement, so they must be copied.
+		ret := y.(*l.ContainerType)
+		a.True(x != ret)
+		a.True(inner != ret.Container)
+		a.True(ret.ByRefPtr == zero)
+		a.Equal("2", ret.Container.ByRefPtr.Val)
+		a.Equal("1", inner.ByRefPtr.Val)
+	})
+
+	t.Run("not a struct", func(t *testing.T) {
+		a := assert.New(t)
+		a.Panics(func() {
+			l.SetTargetInterned(l.TargetTypeTargetSlice, nil)
+		})
+	})
+}
+
+func TestImplementors(t *testing.T) {
+	a := assert.New(t)
+	a.Equal([]l.TargetTypeID{
+		l.TargetTypeByRefType,
+		l.TargetTypeByValType,
+		l.TargetTypeContainerType,
+		l.TargetTypeEmbeddingType,
+		l.TargetTypeEncapsulatedType,
+		l.TargetTypeLooseType,
+		l.TargetTypePairType,
+		l.TargetTypeScopeType,
+		l.TargetTypeWrapperType,
+	}, l.TargetImplementors())
+
+	// Ensure that callers can't affect the next caller.
+	l.TargetImplementors()[0] = 0
+	a.Equal(l.TargetTypeByRefType, l.TargetImplementors()[0])
+}
+
+func TestImplements(t *testing.T) {
+	a := assert.New(t)
+	for _, id := range l.TargetImplementors() {
+		a.True(id.Implements(l.TargetTypeTarget), id.String())
+	}
+
+	// Implemented by value and by reference, respectively.
+	a.True(l.TargetTypeByValType.Implements(l.TargetTypeEmbedsTarget))
+	a.True(l.TargetTypeByRefType.Implements(l.TargetTypeAnnotated))
+
+	a.False(l.TargetTypeByRefType.Implements(l.TargetTypeEmbedsTarget))
+	a.False(l.TargetTypeContainerType.Implements(l.TargetTypeAnnotated))
+	// Only struct types implement interfaces.
+	a.False(l.TargetTypeTarget.Implements(l.TargetTypeTarget))
+	a.False(l.TargetTypeByRefTypePtr.Implements(l.TargetTypeTarget))
+	// The argument must be an interface.
+	a.False(l.TargetTypeByRefType.Implements(l.TargetTypeByRefType))
+}
+
+func TestState(t *testing.T) {
+	a := assert.New(t)
+	x, count := l.NewContainer(true)
+
+	// Collect the string values into the state and verify that the
+	// state is also available to post-visit functions.
+	var vals []string
+	posts := 0
+	_, changed, err := l.WalkTargetState(x, &vals, func(ctx l.TargetContext, state interface{}, x l.Target) l.TargetDecision {
+		if t, ok := x.(*l.ByValType); ok {
+			*state.(*[]string) = append(*state.(*[]string), t.Val)
+		}
+		return ctx.Continue().Post(func(ctx l.TargetContext, x l.Target) l.TargetDecision {
+			if ctx.State() == &vals {
+				posts++
+			}
+			return ctx.Continue()
+		})
+	})
+	a.NoError(err)
+	a.False(changed)
+	a.NotEmpty(vals)
+	a.True(len(vals) < count)
+	a.True(posts > len(vals))
+
+	// WalkTargetWith makes the same value available to a plain
+	// TargetWalkerFn via Value.
+	_, _, err = l.WalkTargetWith(x, &vals, func(ctx l.TargetContext, x l.Target) l.TargetDecision {
+		a.True(ctx.Value() == &vals)
+		a.True(ctx.State() == &vals)
+		return ctx.Continue()
+	})
+	a.NoError(err)
+
+	// The state should not leak into regular walks.
+	_, _, err = l.WalkTarget(x, func(ctx l.TargetContext, x l.Target) l.TargetDecision {
+		a.Nil(ctx.State())
+		a.Nil(ctx.Value())
+		return ctx.Continue()
+	})
+	a.NoError(err)
+}
+
+func TestWalkPostOrder(t *testing.T) {
+	a := assert.New(t)
+	x := &l.ContainerType{ByRefPtr: &l.ByRefType{Val: "olleH"}}
+
+	// The callback sees each value after its children, including any
+	// replacements. Skip has no effect.
+	var visited []string
+	ret, changed, err := l.WalkTargetPostOrder(x, func(ctx l.TargetContext, x l.Target) l.TargetDecision {
+		switch t := x.(type) {
+		case *l.ByRefType:
+			visited = append(visited, "ByRef:"+t.Val)
+			if t.Val != "" {
+				return ctx.Skip().Replace(&l.ByRefType{Val: reverse(t.Val)})
+			}
+		case *l.ContainerType:
+			visited = append(visited, "Container:"+t.ByRefPtr.Val)
+		}
+		return ctx.Skip()
+	})
+	a.NoError(err)
+	a.True(changed)
+	a.Equal([]string{"ByRef:", "ByRef:olleH", "Container:Hello"}, visited)
+	a.Equal("Hello", ret.(*l.ContainerType).ByRefPtr.Val)
+	a.Equal("olleH", x.ByRefPtr.Val, "input should not have changed")
+
+	// Halting prevents any further children from being visited, but the
+	// enclosing values are still passed to the callback.
+	visited = nil
+	_, _, err = l.WalkTargetPostOrder(x, func(ctx l.TargetContext, x l.Target) l.TargetDecision {
+		visited = append(visited, x.Value())
+		return ctx.Halt()
+	})
+	a.NoError(err)
+	a.Equal([]string{"", "Container"}, visited)
+}
+
+func TestPaths(t *testing.T) {
+	a := assert.New(t)
+	x := &l.ContainerType{
+		ByRefPtr:   &l.ByRefType{Val: "ptr"},
+		ByRefSlice: []l.ByRefType{{Val: "zero"}, {Val: "one"}},
+		TargetSlice: []l.Target{&l.ScopeType{Env: map[string]l.Target{
+			"key": &l.ByRefType{Val: "entry"},
+		}}},
+	}
+
+	paths := make(map[string]l.TargetPath)
+	_, changed, err := l.WalkTarget(x, func(ctx l.TargetContext, x l.Target) l.TargetDecision {
+		if t, ok := x.(*l.ByRefType); ok && t.Val != "" {
+			paths[t.Val] = ctx.Path()
+		}
+		return ctx.Continue()
+	})
+	a.NoError(err)
+	a.False(changed)
+	a.Equal("ByRefPtr", paths["ptr"].String())
+	a.Equal("ByRefSlice[1]", paths["one"].String())
+	a.Equal(`TargetSlice[0].Env["key"]`, paths["entry"].String())
+
+	// Struct fields are distinguished from slice indices and map keys.
+	if entry := paths["entry"]; a.Len(entry, 4) {
+		a.Equal("TargetSlice", entry[0].Field)
+		a.Equal(l.TargetPathSegment{Index: 0}, entry[1])
+		a.Equal("Env", entry[2].Field)
+		a.Equal(l.TargetPathSegment{Index: 0, Key: "key"}, entry[3])
+	}
+
+	// Paths are also available to post-visit functions and to the
+	// forked visitations of a Parallel decision.
+	var post, parallel l.TargetPath
+	_, _, err = l.WalkTarget(x, func(ctx l.TargetContext, x l.Target) l.TargetDecision {
+		if t, ok := x.(*l.ByRefType); ok && t.Val == "entry" {
+			parallel = ctx.Path()
+			return ctx.Continue().Post(func(ctx l.TargetContext, x l.Target) l.TargetDecision {
+				post = ctx.Path()
+				return ctx.Continue()
+			})
+		}
+		return ctx.Parallel()
+	})
+	a.NoError(err)
+	a.Equal(`TargetSlice[0].Env["key"]`, parallel.String())
+	a.Equal(`TargetSlice[0].Env["key"]`, post.String())
+}
+
+func TestShape(t *testing.T) {
+	t.Run("nil", func(t *testing.T) {
+		a := assert.New(t)
+		a.Empty(l.ShapeOfTarget(nil))
+		a.Empty(l.ShapeOfTarget((*l.ByRefType)(nil)))
+	})
+
+	t.Run("values", func(t *testing.T) {
+		a := assert.New(t)
+		x, _ := l.NewContainer(true)
+		y, _ := l.NewContainer(true)
+		y.ByRefPtr.Val = "Different"
+		a.Equal(l.ShapeOfTarget(x), l.ShapeOfTarget(y))
+
+		y.AnotherTarget = &l.ByRefType{Val: "olleH"}
+		a.NotEqual(l.ShapeOfTarget(x), l.ShapeOfTarget(y))
+	})
+
+	t.Run("order", func(t *testing.T) {
+		a := assert.New(t)
+		x := &l.ContainerType{
+			ByRefPtr:    &l.ByRefType{},
+			Container:   &l.ContainerType{ByValPtr: &l.ByValType{}},
+			TargetSlice: []l.Target{l.ByValType{}},
+		}
+		x.ByRef.Val = "Ignored"
+		a.Equal([]l.TargetTypeID{
+			l.TargetTypeContainerType,
+			l.TargetTypeByRefType,
+			l.TargetTypeByRefType,
+			l.TargetTypeByValType,
+			l.TargetTypeContainerType,
+			l.TargetTypeByRefType,
+			l.TargetTypeByValType,
+			l.TargetTypeByValType,
+			l.TargetTypeByValType,
+		}, l.ShapeOfTarget(x))
+	})
+
+	t.Run("cycle", func(t *testing.T) {
+		a := assert.New(t)
+		x := &l.ContainerType{}
+		x.Container = x
+		a.Equal([]l.TargetTypeID{
+			l.TargetTypeContainerType,
+			l.TargetTypeByRefType,
+			l.TargetTypeByValType,
+			0,
+		}, l.ShapeOfTarget(x))
+	})
+}
+
+func TestFormat(t *testing.T) {
+	a := assert.New(t)
+	a.Equal("", l.FormatTarget(nil, nil))
+
+	formatters := map[l.TargetTypeID]l.TargetFormatter{
+		l.TargetTypeByRefType: func(x l.Target, _ []string) string { return x.Value() },
+		l.TargetTypeWrapperType: func(x l.Target, children []string) string {
+			return "[" + strings.Join(children, " ") + "]"
+		},
+	}
+	a.Equal("ByRefType", l.FormatTarget(&l.ByRefType{Val: "Hello"}, nil))
+	a.Equal("Hello", l.FormatTarget(&l.ByRefType{Val: "Hello"}, formatters))
+
+	// Cycles and nil values are omitted.
+	x := &l.ContainerType{
+		ByRefPtr:    &l.ByRefType{Val: "Hello"},
+		TargetSlice: []l.Target{&l.WrapperType{Target: &l.ByRefType{Val: "World"}}, nil},
+	}
+	x.ByRef.Val = "Hi"
+	x.Container = x
+	a.Equal("ContainerType(Hi, Hello, ByValType, [World])", l.FormatTarget(x, formatters))
+}
+
+func TestApplyToFixedPoint(t *testing.T) {
+	// Shorten each string by one character per visitation.
+	fn := func(ctx l.TargetContext, x l.Target) l.TargetDecision {
+		if t, ok := x.(*l.ByRefType); ok && len(t.Val) > 0 {
+			return ctx.Continue().Replace(&l.ByRefType{Val: t.Val[1:]})
+		}
+		return ctx.Continue()
+	}
+
+	t.Run("converges", func(t *testing.T) {
+		a := assert.New(t)
+		ret, iters, err := l.ApplyTargetToFixedPoint(&l.ByRefType{Val: "abc"}, fn, 10)
+		a.NoError(err)
+		a.Equal(4, iters)
+		a.Equal(&l.ByRefType{}, ret)
+	})
+
+	t.Run("limited", func(t *testing.T) {
+		a := assert.New(t)
+		ret, iters, err := l.ApplyTargetToFixedPoint(&l.ByRefType{Val: "abc"}, fn, 2)
+		a.Error(err)
+		a.Equal(2, iters)
+		a.Equal(&l.ByRefType{Val: "c"}, ret)
+	})
+}
+
+func TestDiffWalk(t *testing.T) {
+	a := assert.New(t)
+	x := &l.ContainerType{
+		ByRefPtr:    &l.ByRefType{Val: "Ptr"},
+		TargetSlice: []l.Target{l.ByValType{Val: "Zero"}, &l.ByRefType{Val: "One"}},
+	}
+
+	ret, changes, err := l.DiffWalkTarget(x, func(ctx l.TargetContext, x l.Target) l.TargetDecision {
+		switch t := x.(type) {
+		case *l.ByRefType:
+			return ctx.Continue().Replace(&l.ByRefType{Val: strings.ToUpper(t.Val)})
+		case *l.ByValType:
+			return ctx.Continue().Post(func(ctx l.TargetContext, x l.Target) l.TargetDecision {
+				return ctx.Continue().Replace(&l.ByValType{Val: t.Val + "!"})
+			})
+		}
+		return ctx.Continue()
+	})
+	if !a.NoError(err) {
+		return
+	}
+	a.NotEqual(x, ret)
+	a.Equal([]l.TargetChange{
+		{Path: "ByRef", Before: &l.ByRefType{}, After: &l.ByRefType{}},
+		{Path: "ByRefPtr", Before: &l.ByRefType{Val: "Ptr"}, After: &l.ByRefType{Val: "PTR"}},
+		{Path: "ByVal", Before: &l.ByValType{}, After: &l.ByValType{Val: "!"}},
+		{Path: "TargetSlice[0]", Before: &l.ByValType{Val: "Zero"}, After: &l.ByValType{Val: "Zero!"}},
+		{Path: "TargetSlice[1]", Before: &l.ByRefType{Val: "One"}, After: &l.ByRefType{Val: "ONE"}},
+	}, changes)
+}
+
+func TestWalkChanged(t *testing.T) {
+	a := assert.New(t)
+	x, _ := l.NewContainer(true)
+
+	// Replace a single value within the tree.
+	ret, changed, err := l.WalkTarget(x, func(ctx l.TargetContext, y l.Target) (d l.TargetDecision) {
+		if y == l.Target(x.ByRefPtr) {
+			d = d.Replace(&l.ByRefType{Val: "Changed"})
+		}
+		return
+	})
+	if !a.NoError(err) || !a.True(changed) {
+		return
+	}
+
+	visit := func(before, after l.Target) []string {
+		var visited []string
+		_, _, err := l.WalkTargetChanged(before, after, func(ctx l.TargetContext, x l.Target) l.TargetDecision {
+			visited = append(visited, x.Value())
+			return ctx.Continue()
+		})
+		a.NoError(err)
+		return visited
+	}
+
+	a.Equal([]string{"Container", "Changed"}, visit(x, ret))
+	a.Empty(visit(x, x))
+	a.Empty(visit(ret, ret))
+
+	// Every value should be visited if there's nothing to compare to.
+	all := 0
+	_, _, err = l.WalkTarget(ret, func(ctx l.TargetContext, x l.Target) l.TargetDecision {
+		all++
+		return ctx.Continue()
+	})
+	a.NoError(err)
+	a.Len(visit(nil, ret), all)
+	a.Len(visit(&l.ByRefType{}, ret), all)
+
+	// Replacements should be applied to the new tree, but not the old.
+	ret2, changed, err := l.WalkTargetChanged(x, ret, func(ctx l.TargetContext, x l.Target) (d l.TargetDecision) {
+		if t, ok := x.(*l.ByRefType); ok {
+			d = d.Replace(&l.ByRefType{Val: t.Val + "!"})
+		}
+		return
+	})
+	if a.NoError(err) && a.True(changed) {
+		a.Equal("Changed!", ret2.(*l.ContainerType).ByRefPtr.Val)
+		a.Equal("Changed", ret.(*l.ContainerType).ByRefPtr.Val)
+		a.Equal("olleH", ret2.(*l.ContainerType).ByRef.Val)
+	}
+}
+
+func TestDiffScript(t *testing.T) {
+	// Summarize the edits as "Op Path Before -> After".
+	script := func(before, after l.Target) []string {
+		var ret []string
+		for _, edit := range l.DiffTargetScript(before, after) {
+			desc := func(x l.TargetAbstract) string {
+				if t, ok := x.(l.Target); ok {
+					return t.Value()
+				}
+				if x == nil {
+					return "nil"
+				}
+				return x.TargetTypeID().String()
+			}
+			ret = append(ret, fmt.Sprintf("%s %s %s -> %s", edit.Op, edit.Path, desc(edit.Before), desc(edit.After)))
+		}
+		return ret
+	}
+
+	t.Run("identical", func(t *testing.T) {
+		a := assert.New(t)
+		x, _ := l.NewContainer(true)
+		y, _ := l.NewContainer(true)
+		a.Empty(script(x, x))
+		a.Empty(script(x, y))
+		a.Empty(script(nil, nil))
+	})
+
+	t.Run("fields", func(t *testing.T) {
+		a := assert.New(t)
+		x := &l.ContainerType{
+			ByRefPtr:      &l.ByRefType{Val: "Before"},
+			AnotherTarget: &l.ByRefType{Val: "Ref"},
+			OptTarget:     &l.ByRefType{Val: "Deleted"},
+		}
+		y := &l.ContainerType{
+			ByRefPtr:      &l.ByRefType{Val: "After"},
+			AnotherTarget: &l.ByValType{Val: "Ref"},
+			Annotated:     &l.ByRefType{Val: "Inserted"},
+		}
+		a.Equal([]string{
+			"Replace ByRefPtr Before -> After",
+			"Replace AnotherTarget Ref -> Ref",
+			"Delete OptTarget Deleted -> nil",
+			"Insert Annotated nil -> Inserted",
+		}, script(x, y))
+	})
+
+	t.Run("slices", func(t *testing.T) {
+		a := assert.New(t)
+		x := &l.ContainerType{TargetSlice: []l.Target{
+			&l.ByRefType{Val: "A"},
+			&l.ByRefType{Val: "B"},
+			&l.ContainerType{TargetSlice: []l.Target{&l.ByRefType{Val: "C"}}},
+			&l.ByRefType{Val: "D"},
+		}}
+		y := &l.ContainerType{TargetSlice: []l.Target{
+			&l.ByRefType{Val: "Z"},
+			&l.ByRefType{Val: "A"},
+			&l.ByRefType{Val: "B"},
+			&l.ContainerType{TargetSlice: []l.Target{&l.ByRefType{Val: "C!"}}},
+		}}
+		a.Equal([]string{
+			"Insert TargetSlice[0] nil -> Z",
+			"Replace TargetSlice[3].TargetSlice[0] C -> C!",
+			"Delete TargetSlice[3] D -> nil",
+		}, script(x, y))
+
+		// Emptying a slice deletes the slice itself.
+		a.Equal([]string{
+			"Delete TargetSlice []Target -> nil",
+		}, script(x, &l.ContainerType{}))
+	})
+
+	t.Run("cycle", func(t *testing.T) {
+		a := assert.New(t)
+		x := &l.ContainerType{ByRefPtr: &l.ByRefType{Val: "Before"}}
+		x.Container = x
+		y := &l.ContainerType{ByRefPtr: &l.ByRefType{Val: "After"}}
+		y.Container = y
+		a.Equal([]string{"Replace ByRefPtr Before -> After"}, script(x, y))
+	})
+
+	t.Run("roots", func(t *testing.T) {
+		a := assert.New(t)
+		a.Equal([]string{"Insert  nil -> Root"}, script(nil, &l.ByRefType{Val: "Root"}))
+		a.Equal([]string{"Delete  Root -> nil"}, script(&l.ByRefType{Val: "Root"}, nil))
+		a.Equal([]string{"Replace  Root -> Root"}, script(&l.ByRefType{Val: "Root"}, l.ByValType{Val: "Root"}))
+	})
+}
+
+func TestWalkForest(t *testing.T) {
+	roots := make([]l.Target, 100)
+	for i := range roots {
+		roots[i], _ = l.NewContainer(i%2 == 0)
+	}
+	// Count the visited values in a single tree.
+	perRoot := 0
+	_, _, err := l.WalkTarget(roots[0], func(ctx l.TargetContext, x l.Target) l.TargetDecision {
+		perRoot++
+		return ctx.Continue()
+	})
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	for _, workers := range []int{0, 1, 4, 1000} {
+		t.Run(fmt.Sprintf("workers=%d", workers), func(t *testing.T) {
+			a := assert.New(t)
+			var count int64
+			err := l.WalkTargetForest(roots, workers, func(ctx l.TargetContext, x l.Target) l.TargetDecision {
+				atomic.AddInt64(&count, 1)
+				return ctx.Continue()
+			})
+			a.NoError(err)
+			a.Equal(int64(perRoot*len(roots)), count)
+		})
+	}
+
+	t.Run("empty", func(t *testing.T) {
+		a := assert.New(t)
+		a.NoError(l.WalkTargetForest(nil, 4, nil))
+	})
+
+	t.Run("error", func(t *testing.T) {
+		a := assert.New(t)
+		var count int64
+		err := l.WalkTargetForest(roots, 4, func(ctx l.TargetContext, x l.Target) l.TargetDecision {
+			if atomic.AddInt64(&count, 1) == 10 {
+				return ctx.Error(errors.New("boom"))
+			}
+			return ctx.Continue()
+		})
+		if a.Error(err) {
+			a.Equal("boom", err.Error())
+		}
+		a.True(atomic.LoadInt64(&count) < int64(perRoot*len(roots)))
+	})
+}
+
+func TestWalkOfTypes(t *testing.T) {
+	a := assert.New(t)
+	x := &l.ContainerType{
+		ByRefPtr:      &l.ByRefType{Val: "outer"},
+		AnotherTarget: l.ByValType{Val: "value"},
+		Container: &l.ContainerType{
+			ByRefPtr: &l.ByRefType{Val: "inner"},
+		},
+	}
+
+	var seen []string
+	_, changed, err := l.WalkTargetOfTypes(x, func(ctx l.TargetContext, x l.Target) (d l.TargetDecision) {
+		if _, ok := x.(*l.ByRefType); !a.True(ok, "unexpected %T", x) {
+			return
+		}
+		if val := x.Value(); val != "" {
+			seen = append(seen, val)
+		}
+		return
+	}, l.TargetTypeByRefType)
+	a.NoError(err)
+	a.False(changed)
+	// The nested container is descended into, but not passed to the callback.
+	a.Equal([]string{"outer", "inner"}, seen)
+
+	// Replacements should be applied as usual.
+	y, changed, err := l.WalkTargetOfTypes(x, func(ctx l.TargetContext, x l.Target) (d l.TargetDecision) {
+		if x.Value() == "value" {
+			d = d.Replace(&l.ByValType{Val: "replaced"})
+		}
+		return
+	}, l.TargetTypeByValType, l.TargetTypeByRefType)
+	a.NoError(err)
+	a.True(changed)
+	a.Equal("replaced", y.(*l.ContainerType).AnotherTarget.Value())
+	a.Equal("value", x.AnotherTarget.Value())
+
+	// With no types, the callback should never be invoked.
+	_, _, err = l.WalkTargetOfTypes(x, func(ctx l.TargetContext, x l.Target) l.TargetDecision {
+		a.Fail("should not be called")
+		return ctx.Continue()
+	})
+	a.NoError(err)
+
+	y, changed, err = l.WalkTargetOfTypes(nil, nil, l.TargetTypeByRefType)
+	a.Nil(y)
+	a.False(changed)
+	a.NoError(err)
+}
+
+func TestWalkExcept(t *testing.T) {
+	a := assert.New(t)
+	x := &l.ContainerType{
+		ByRefPtr:      &l.ByRefType{Val: "outer"},
+		AnotherTarget: l.ByValType{Val: "value"},
+		Container: &l.ContainerType{
+			ByRefPtr: &l.ByRefType{Val: "inner"},
+		},
+	}
+
+	// The nested container, and everything within it, is skipped.
+	var seen []string
+	_, changed, err := l.WalkTargetExcept(x, []l.TargetTypeID{l.TargetTypeContainerTypePtr},
+		func(ctx l.TargetContext, x l.Target) l.TargetDecision {
+			if val := x.Value(); val != "" {
+				seen = append(seen, val)
+			}
+			return ctx.Continue()
+		})
+	a.NoError(err)
+	a.False(changed)
+	a.Equal([]string{"Container", "outer", "value"}, seen)
+
+	// Replacements should be applied to the values which are visited.
+	y, changed, err := l.WalkTargetExcept(x, []l.TargetTypeID{l.TargetTypeByValType},
+		func(ctx l.TargetContext, x l.Target) (d l.TargetDecision) {
+			if x.Value() == "outer" || x.Value() == "inner" {
+				d = d.Replace(&l.ByRefType{Val: "replaced"})
+			}
+			return
+		})
+	a.NoError(err)
+	a.True(changed)
+	a.Equal("replaced", y.(*l.ContainerType).ByRefPtr.Val)
+	a.Equal("replaced", y.(*l.ContainerType).Container.ByRefPtr.Val)
+	a.Equal("value", y.(*l.ContainerType).AnotherTarget.Value())
+
+	// Skipping the top-level value skips everything.
+	_, changed, err = l.WalkTargetExcept(x, []l.TargetTypeID{l.TargetTypeContainerType},
+		func(ctx l.TargetContext, x l.Target) l.TargetDecision {
+			a.Fail("should not be called")
+			return ctx.Continue()
+		})
+	a.NoError(err)
+	a.False(changed)
+
+	y, changed, err = l.WalkTargetExcept(nil, nil, nil)
+	a.Nil(y)
+	a.False(changed)
+	a.NoError(err)
+}
+
+func TestWalkAlloc(t *testing.T) {
+	a := assert.New(t)
+	x := &l.ContainerType{
+		ByRef:    l.ByRefType{Val: "value"},
+		ByRefPtr: &l.ByRefType{Val: "pointer"},
+	}
+	replace := func(ctx l.TargetContext, x l.Target) (d l.TargetDecision) {
+		if x.Value() == "value" {
+			d = d.Replace(&l.ByRefType{Val: "replaced"})
+		}
+		return
+	}
+
+	var requested []l.TargetTypeID
+	reused := &l.ContainerType{ByRef: l.ByRefType{Val: "garbage"}}
+	y, changed, err := l.WalkTargetAlloc(x, func(id l.TargetTypeID) l.Target {
+		requested = append(requested, id)
+		return reused
+	}, replace)
+	a.NoError(err)
+	a.True(changed)
+	a.Equal([]l.TargetTypeID{l.TargetTypeContainerType}, requested)
+	if a.True(y == l.Target(reused)) {
+		a.Equal("replaced", reused.ByRef.Val)
+		a.True(reused.ByRefPtr == x.ByRefPtr)
+	}
+	a.Equal("value", x.ByRef.Val)
+
+	// A nil allocation falls back to the usual behavior.
+	y, changed, err = l.WalkTargetAlloc(x, func(l.TargetTypeID) l.Target { return nil }, replace)
+	a.NoError(err)
+	a.True(changed)
+	a.False(y == l.Target(reused))
+	a.Equal("replaced", y.(*l.ContainerType).ByRef.Val)
+
+	a.Panics(func() {
+		_, _, _ = l.WalkTargetAlloc(x, func(l.TargetTypeID) l.Target { return &l.ByRefType{} }, replace)
+	})
+
+	y, changed, err = l.WalkTargetAlloc(nil, nil, nil)
+	a.Nil(y)
+	a.False(changed)
+	a.NoError(err)
+}
+
+func TestWalkCounted(t *testing.T) {
+	a := assert.New(t)
+	x, _ := l.NewContainer(true)
+
+	var expected int
+	_, _, err := l.WalkTarget(x, func(ctx l.TargetContext, x l.Target) l.TargetDecision {
+		expected++
+		return ctx.Continue()
+	})
+	a.NoError(err)
+
+	// Post-visits are not counted.
+	_, changed, count, err := l.WalkTargetCounted(x, func(ctx l.TargetContext, x l.Target) l.TargetDecision {
+		return ctx.Continue().Post(func(ctx l.TargetContext, x l.Target) l.TargetDecision {
+			return ctx.Continue()
+		})
+	})
+	a.NoError(err)
+	a.False(changed)
+	a.Equal(expected, count)
+
+	// Parallel visitation should arrive at the same count.
+	_, _, count, err = l.WalkTargetCounted(x, func(ctx l.TargetContext, x l.Target) l.TargetDecision {
+		return ctx.Parallel()
+	})
+	a.NoError(err)
+	a.Equal(expected, count)
+
+	// An error reports the values visited so far.
+	_, _, count, err = l.WalkTargetCounted(x, func(ctx l.TargetContext, x l.Target) l.TargetDecision {
+		return ctx.Error(errors.New("boom"))
+	})
+	a.EqualError(err, "boom")
+	a.Equal(1, count)
+
+	y, changed, count, err := l.WalkTargetCounted(nil, nil)
+	a.Nil(y)
+	a.False(changed)
+	a.Zero(count)
+	a.NoError(err)
+}
+
+func TestWalkMaxDepth(t *testing.T) {
+	a := assert.New(t)
+	const depth = 100
+	var x *l.ContainerType
+	for i := 0; i < depth; i++ {
+		x = &l.ContainerType{Container: x}
+	}
+	fn := func(ctx l.TargetContext, x l.Target) l.TargetDecision { return ctx.Continue() }
+
+	// The top-level value occupies one level of the stack. Each container
+	// adds a level for its fields, and each pointer to the next container
+	// adds another.
+	_, _, err := l.WalkTargetMaxDepth(x, 2*depth+1, fn)
+	a.NoError(err)
+	_, _, err = l.WalkTargetMaxDepth(x, 2*depth, fn)
+	a.EqualError(err, "exceeded the maximum depth of 200 within [4]Target")
+	_, _, err = l.WalkTargetMaxDepth(x, 0, fn)
+	a.NoError(err)
+
+	// The limit should also apply to forked visitations.
+	_, _, err = l.WalkTargetMaxDepth(x, depth, func(ctx l.TargetContext, x l.Target) l.TargetDecision {
+		return ctx.Parallel()
+	})
+	a.EqualError(err, "exceeded the maximum depth of 100 within *ContainerType")
+
+	// Slices, maps, and interfaces occupy levels of the stack, even
+	// though they are not structs.
+	nested := &l.ContainerType{TargetSlice: []l.Target{&l.ScopeType{Env: map[string]l.Target{
+		"a": &l.ContainerType{TargetSlice: []l.Target{&l.ByRefType{}}},
+	}}}}
+	_, _, err = l.WalkTargetMaxDepth(nested, 10, fn)
+	a.NoError(err)
+	_, _, err = l.WalkTargetMaxDepth(nested, 9, fn)
+	a.EqualError(err, "exceeded the maximum depth of 9 within Target")
+
+	y, changed, err := l.WalkTargetMaxDepth(nil, 1, nil)
+	a.Nil(y)
+	a.False(changed)
+	a.NoError(err)
+}
+
+func TestTransformer(t *testing.T) {
+	a := assert.New(t)
+	x := &l.ContainerType{
+		ByRef:    l.ByRefType{Val: "value"},
+		ByRefPtr: &l.ByRefType{Val: "pointer"},
+		ByVal:    l.ByValType{Val: "unchanged"},
+	}
+
+	var seen []string
+	tr := l.NewTargetTransformer().
+		OnByRefType(func(x *l.ByRefType) *l.ByRefType {
+			seen = append(seen, x.Val)
+			if x.Val == "value" {
+				return &l.ByRefType{Val: "replaced"}
+			}
+			// Returning the same pointer makes no change.
+			return x
+		}).
+		OnByValType(func(x *l.ByValType) *l.ByValType {
+			seen = append(seen, x.Val)
+			return x
+		})
+	y, changed, err := tr.Walk(x)
+	a.NoError(err)
+	a.True(changed)
+	a.Equal([]string{"value", "pointer", "unchanged"}, seen)
+	a.Equal("replaced", y.(*l.ContainerType).ByRef.Val)
+	a.True(y.(*l.ContainerType).ByRefPtr == x.ByRefPtr)
+	a.Equal("value", x.ByRef.Val)
+
+	// Nothing changes if no values are replaced.
+	_, changed, err = l.NewTargetTransformer().
+		OnByRefType(func(x *l.ByRefType) *l.ByRefType { return x }).
+		Walk(x)
+	a.NoError(err)
+	a.False(changed)
+
+	_, _, err = l.NewTargetTransformer().
+		OnByRefType(func(*l.ByRefType) *l.ByRefType { return nil }).
+		Walk(x)
+	a.EqualError(err, "the function for ByRefType returned nil")
+
+	y, changed, err = l.NewTargetTransformer().Walk(x)
+	a.True(y == l.Target(x))
+	a.False(changed)
+	a.NoError(err)
+}
+
+func TestWalkLifecycle(t *testing.T) {
+	a := assert.New(t)
+	x := &l.ContainerType{ByRefPtr: &l.ByRefType{Val: "ptr"}}
+
+	var events []string
+	onStart := func() { events = append(events, "start") }
+	onEnd := func(err error) {
+		if err != nil {
+			events = append(events, "end: "+err.Error())
+		} else {
+			events = append(events, "end")
+		}
+	}
+
+	// Replacements are returned as they are by WalkTarget.
+	y, changed, err := l.WalkTargetLifecycle(x, onStart, onEnd, func(ctx l.TargetContext, x l.Target) l.TargetDecision {
+		switch t := x.(type) {
+		case *l.ContainerType:
+			events = append(events, "container")
+		case *l.ByRefType:
+			if t.Val != "" {
+				events = append(events, t.Val)
+				return ctx.Continue().Replace(&l.ByRefType{Val: "replaced"})
+			}
+		}
+		return ctx.Continue()
+	})
+	a.NoError(err)
+	a.True(changed)
+	a.Equal("replaced", y.(*l.ContainerType).ByRefPtr.Val)
+	a.Equal("ptr", x.ByRefPtr.Val)
+	a.Equal([]string{"start", "container", "ptr", "end"}, events)
+
+	// The final error is passed to onEnd.
+	events = nil
+	_, _, err = l.WalkTargetLifecycle(x, onStart, onEnd, func(ctx l.TargetContext, x l.Target) l.TargetDecision {
+		return ctx.Error(errors.New("failed"))
+	})
+	a.EqualError(err, "failed")
+	a.Equal([]string{"start", "end: failed"}, events)
+
+	// Both functions are optional, and are invoked for a nil value.
+	_, _, err = l.WalkTargetLifecycle(x, nil, nil, func(ctx l.TargetContext, x l.Target) l.TargetDecision {
+		return ctx.Continue()
+	})
+	a.NoError(err)
+	events = nil
+	_, _, err = l.WalkTargetLifecycle(nil, onStart, onEnd, func(ctx l.TargetContext, x l.Target) l.TargetDecision {
+		return ctx.Continue()
+	})
+	a.NoError(err)
+	a.Equal([]string{"start", "end"}, events)
+}
+
+func TestWalkVisitNils(t *testing.T) {
+	a := assert.New(t)
+	x := &l.ContainerType{
+		EmbedsTarget: l.ByValType{Val: "embeds"},
+		Annotated:    &l.ByRefType{Val: "annotated"},
+		TargetSlice:  []l.Target{nil, (*l.ByRefType)(nil), &l.ByRefType{Val: "slice"}},
+	}
+
+	// An ordinary walk skips the nil values.
+	_, _, err := l.WalkTarget(x, func(ctx l.TargetContext, x l.Target) l.TargetDecision {
+		a.NotNil(x)
+		return ctx.Continue()
+	})
+	a.NoError(err)
+
+	// AnotherTarget, the elements of Quad, and two elements of
+	// TargetSlice are nil.
+	nils := 0
+	y, changed, err := l.WalkTargetVisitNils(x, func(ctx l.TargetContext, x l.Target) l.TargetDecision {
+		if x == nil {
+			nils++
+			return ctx.Continue().Replace(&l.ByRefType{Val: "filled"})
+		}
+		return ctx.Continue()
+	})
+	a.NoError(err)
+	a.True(changed)
+	a.Equal(7, nils)
+	z := y.(*l.ContainerType)
+	a.Equal("filled", z.AnotherTarget.(*l.ByRefType).Val)
+	a.Equal("filled", z.TargetSlice[0].(*l.ByRefType).Val)
+	a.Equal("filled", z.TargetSlice[1].(*l.ByRefType).Val)
+	a.Equal("slice", z.TargetSlice[2].(*l.ByRefType).Val)
+	for _, elt := range z.Quad {
+		a.Equal("filled", elt.(*l.ByRefType).Val)
+	}
+	a.Nil(x.AnotherTarget)
+	a.Nil(x.TargetSlice[0])
+
+	// Nothing changes unless a nil value is replaced.
+	nils = 0
+	y, changed, err = l.WalkTargetVisitNils(x, func(ctx l.TargetContext, x l.Target) l.TargetDecision {
+		if x == nil {
+			nils++
+			return ctx.Halt()
+		}
+		return ctx.Continue()
+	})
+	a.NoError(err)
+	a.False(changed)
+	a.True(y == l.Target(x))
+	a.Equal(1, nils)
+
+	// The replacement must be assignable to the interface.
+	x.EmbedsTarget = nil
+	_, _, err = l.WalkTargetVisitNils(x, func(ctx l.TargetContext, x l.Target) l.TargetDecision {
+		if x == nil {
+			return ctx.Continue().Replace(&l.ByRefType{})
+		}
+		return ctx.Continue()
+	})
+	a.EqualError(err, "type ByRefType is unknown or not assignable to EmbedsTarget")
+}
+
+func TestAssertIsTree(t *testing.T) {
+	a := assert.New(t)
+	a.NoError(l.AssertTargetIsTree(nil))
+
+	x, _ := l.NewContainer(true)
+	a.NoError(l.AssertTargetIsTree(x))
+
+	// Back-references are cycles, rather than shared values.
+	x.Container = x
+	a.NoError(l.AssertTargetIsTree(x))
+
+	shared := &l.ByRefType{Val: "Shared"}
+	x.Container = &l.ContainerType{ByRefPtr: shared}
+	x.ByRefPtrSlice = []*l.ByRefType{nil, shared}
+	if err := l.AssertTargetIsTree(x); a.Error(err) {
+		a.Equal(`ByRefType is reachable from both "ByRefPtrSlice[1]" and "Container.ByRefPtr"`, err.Error())
+	}
+}
+
+func TestFocus(t *testing.T) {
+	a := assert.New(t)
+
+	unchanged := &l.ByValType{Val: "Unchanged"}
+	x := &l.ContainerType{
+		ByRefPtr: &l.ByRefType{Val: "a"},
+		ByValPtr: unchanged,
+		TargetSlice: []l.Target{
+			&l.ByRefType{Val: "b"},
+			&l.ContainerType{ByRefPtr: &l.ByRefType{Val: "c"}},
+		},
+	}
+	focused, put := l.FocusTarget(x, func(x l.Target) bool {
+		_, ok := x.(*l.ByRefType)
+		return ok
+	})
+
+	// The by-value ByRef fields are focused, too.
+	var vals []string
+	for _, f := range focused {
+		vals = append(vals, f.Value())
+	}
+	a.Equal([]string{"", "a", "b", "", "c"}, vals)
+
+	replacements := make([]l.Target, len(focused))
+	for i, f := range focused {
+		replacements[i] = &l.ByRefType{Val: strings.ToUpper(f.Value()) + "!"}
+	}
+	y, err := put(replacements)
+	if a.NoError(err) {
+		z := y.(*l.ContainerType)
+		a.Equal("!", z.ByRef.Val)
+		a.Equal("A!", z.ByRefPtr.Val)
+		a.Equal("B!", z.TargetSlice[0].Value())
+		a.Equal("C!", z.TargetSlice[1].(*l.ContainerType).ByRefPtr.Val)
+		a.True(unchanged == z.ByValPtr)
+	}
+
+	// The original is not modified.
+	a.Equal("a", x.ByRefPtr.Val)
+	a.Equal("b", x.TargetSlice[0].Value())
+	a.Equal("c", x.TargetSlice[1].(*l.ContainerType).ByRefPtr.Val)
+
+	_, err = put(replacements[1:])
+	a.EqualError(err, "expecting 5 replacements, got 4")
+
+	replacements[2] = nil
+	_, err = put(replacements)
+	a.EqualError(err, "replacement 2 is nil")
+
+	focused, put = l.FocusTarget(nil, func(l.Target) bool { return true })
+	a.Empty(focused)
+	y, err = put(nil)
+	a.NoError(err)
+	a.Nil(y)
+}
+
+func TestWalkTopo(t *testing.T) {
+	// Collect the values in the order that they're visited.
+	var order []string
+	fn := func(x l.Target) error {
+		order = append(order, x.Value())
+		return nil
+	}
+
+	t.Run("dag", func(t *testing.T) {
+		a := assert.New(t)
+		order = nil
+		shared := &l.ByRefType{Val: "Shared"}
+		dep := &l.ContainerType{TargetSlice: []l.Target{shared}}
+		root := &l.ContainerType{
+			TargetSlice: []l.Target{dep, shared, &l.ByRefType{Val: "Leaf"}},
+		}
+		a.NoError(l.WalkTargetTopo(root, "TargetSlice", fn))
+		a.Equal([]string{"Shared", "Container", "Leaf", "Container"}, order)
+	})
+
+	t.Run("cycle", func(t *testing.T) {
+		a := assert.New(t)
+		order = nil
+		root := &l.ContainerType{}
+		dep := &l.ContainerType{Container: root}
+		root.Container = dep
+		err := l.WalkTargetTopo(root, "Container", fn)
+		if a.Error(err) {
+			a.Equal("dependency cycle: ContainerType -> ContainerType -> ContainerType", err.Error())
+		}
+		a.Empty(order)
+	})
+}
+
+func TestRedactStrings(t *testing.T) {
+	a := assert.New(t)
+	x, count := l.NewContainer(true)
+
+	redactions := 0
+	ret, changed, err := l.RedactTargetStrings(x, func(s string) string {
+		redactions++
+		return strings.Repeat("*", len(s))
+	})
+	if !a.NoError(err) {
+		return
+	}
+	a.True(changed)
+	a.Equal(count, redactions)
+
+	// Verify that every string in the result has been redacted, while
+	// the original is unchanged.
+	_, _, err = l.WalkTarget(ret, func(ctx l.TargetContext, x l.Target) l.TargetDecision {
+		if _, ok := x.(*l.ContainerType); !ok {
+			a.Equal("*****", x.Value())
+		}
+		return ctx.Continue()
+	})
+	a.NoError(err)
+	a.Equal("olleH", x.ByRefPtr.Val)
+
+	// No changes should be reported if the strings are unchanged.
+	_, changed, err = l.RedactTargetStrings(x, func(s string) string { return s })
+	a.NoError(err)
+	a.False(changed)
+}
+
+func TestEncodeDecode(t *testing.T) {
+	for _, useValuePtrs := range []bool{true, false} {
+		t.Run(fmt.Sprintf("useValuePtrs=%t", useValuePtrs), func(t *testing.T) {
+			a := assert.New(t)
+			x, _ := l.NewContainer(useValuePtrs)
+
+			var buf bytes.Buffer
+			if !a.NoError(l.EncodeTarget(&buf, x)) {
+				return
+			}
+			decoded, err := l.DecodeTarget(&buf)
+			if !a.NoError(err) {
+				return
+			}
+			a.Equal(x, decoded)
+			a.Zero(buf.Len())
+		})
+	}
+
+	t.Run("nil", func(t *testing.T) {
+		a := assert.New(t)
+		var buf bytes.Buffer
+		a.NoError(l.EncodeTarget(&buf, nil))
+		decoded, err := l.DecodeTarget(&buf)
+		a.NoError(err)
+		a.Nil(decoded)
+	})
+
+	t.Run("nil vs empty", func(t *testing.T) {
+		a := assert.New(t)
+		x := &l.ContainerType{ByRefSlice: []l.ByRefType{}}
+
+		var buf bytes.Buffer
+		a.NoError(l.EncodeTarget(&buf, x))
+		decoded, err := l.DecodeTarget(&buf)
+		if !a.NoError(err) {
+			return
+		}
+		y := decoded.(*l.ContainerType)
+		a.NotNil(y.ByRefSlice)
+		a.Len(y.ByRefSlice, 0)
+		a.Nil(y.ByValSlice)
+	})
+
+	t.Run("shared and cyclic", func(t *testing.T) {
+		a := assert.New(t)
+		shared := &l.ByRefType{Val: "shared"}
+		x := &l.ContainerType{ByRefPtr: shared, OptTarget: shared}
+		x.Container = x
+
+		var buf bytes.Buffer
+		a.NoError(l.EncodeTarget(&buf, x))
+		decoded, err := l.DecodeTarget(&buf)
+		if !a.NoError(err) {
+			return
+		}
+		y := decoded.(*l.ContainerType)
+		a.True(y == y.Container)
+		a.True(y.ByRefPtr == (*l.ByRefType)(y.OptTarget))
+		a.Equal("shared", y.ByRefPtr.Val)
+	})
+
+	t.Run("corrupt", func(t *testing.T) {
+		a := assert.New(t)
+		x, _ := l.NewContainer(true)
+
+		var buf bytes.Buffer
+		a.NoError(l.EncodeTarget(&buf, x))
+		data := buf.Bytes()
+
+		_, err := l.DecodeTarget(bytes.NewReader(data[:len(data)/2]))
+		a.Error(err)
+
+		_, err = l.DecodeTarget(bytes.NewReader([]byte{0xff}))
+		a.Error(err)
+	})
+
+	t.Run("implausible lengths", func(t *testing.T) {
+		// Each input is a new pointer to a value which claims to hold a
+		// large string, map, or slice, but is truncated. The decoder should fail without allocating memory in
+		// proportion to the claimed length.
+		huge := func(prefix ...byte) []byte {
+			return append(append([]byte{1}, prefix...), 0xff, 0xff, 0xff, 0x7f)
+		}
+		inputs := map[string][]byte{
+			"string": huge(byte(l.TargetTypeByRefTypePtr), 1),
+			"map":    huge(byte(l.TargetTypeScopeTypePtr), 1),
+			"slice":  huge(byte(l.TargetTypeContainerTypePtr), 1, 0, 0),
+		}
+		for name, input := range inputs {
+			t.Run(name, func(t *testing.T) {
+				a := assert.New(t)
+				var before, after runtime.MemStats
+				runtime.ReadMemStats(&before)
+				_, err := l.DecodeTarget(bytes.NewReader(input))
+				runtime.ReadMemStats(&after)
+				a.Error(err)
+				a.True(after.TotalAlloc-before.TotalAlloc < 1<<20,
+					"allocated %d bytes", after.TotalAlloc-before.TotalAlloc)
+			})
+		}
+	})
+}
+
+func TestCheckInvariants(t *testing.T) {
+	a := assert.New(t)
+
+	x, _ := l.NewContainer(true)
+	a.NoError(l.CheckTargetInvariants(x))
+	a.NoError(l.CheckTargetInvariants(nil))
+
+	x.Container = &l.ContainerType{
+		ByRefPtr:    &l.ByRefType{},
+		TargetSlice: []l.Target{},
+	}
+	x.TargetSlice[1] = &l.ContainerType{}
+	err := l.CheckTargetInvariants(x)
+	if a.Error(err) {
+		a.Equal(l.TargetViolations{
+			{Path: "Container.TargetSlice", Invariant: "nonempty"},
+			{Path: "TargetSlice[1].ByRefPtr", Invariant: "nonnil"},
+			{Path: "TargetSlice[1].TargetSlice", Invariant: "nonempty"},
+		}, err)
+		a.Equal("invariants violated: Container.TargetSlice (nonempty), "+
+			"TargetSlice[1].ByRefPtr (nonnil), TargetSlice[1].TargetSlice (nonempty)", err.Error())
+	}
+}
@@ -21,8 +21,16 @@ package demo_test
 // but must replace values of ByValType.
 
 import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"runtime"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	l "github.com/cockroachdb/walkabout/demo"
 	"github.com/cockroachdb/walkabout/demo/other"
@@ -50,7 +58,8 @@ func TestChildAt(t *testing.T) {
 		for i, j := 0, c.TargetCount(); i < j; i++ {
 			child := c.TargetAt(i)
 			switch i {
-			case 0, 4:
+			case 0, 4, 16:
+				// By-value structs and arrays are never nil.
 				a.NotNilf(child, "at index %d", i)
 			default:
 				a.Nilf(child, "at index %d", i)
@@ -91,6 +100,329 @@ func TestChildAt(t *testing.T) {
 	})
 }
 
+func TestChildNamed(t *testing.T) {
+	a := assert.New(t)
+	x, _ := l.NewContainer(false)
+
+	a.True(x.TargetNamed("ByRefPtr") == l.TargetAbstract(x.ByRefPtr))
+	a.True(x.TargetNamed("ByRef") == l.TargetAbstract(&x.ByRef))
+
+	// Nil fields, unknown or unexported fields, and slices return nil.
+	a.Nil(x.TargetNamed("Container"))
+	a.Nil(x.TargetNamed("Missing"))
+	a.Nil(x.TargetNamed("ignored"))
+	slice := x.TargetNamed("ByRefSlice")
+	if a.NotNil(slice) {
+		a.Nil(slice.TargetNamed("Val"))
+	}
+
+	// The node should retain its parent.
+	node := l.NewTargetNode(x).TargetNamed("ByValPtr")
+	if a.NotNil(node) {
+		a.Equal(5, node.ParentAt())
+		a.Nil(node.TargetNamed("Missing"))
+	}
+}
+
+// TestEachChild verifies that the iterator form presents the same
+// children as TargetAt.
+func TestEachChild(t *testing.T) {
+	a := assert.New(t)
+	x, _ := l.NewContainer(false)
+
+	var visited int
+	var check func(x l.TargetAbstract)
+	check = func(x l.TargetAbstract) {
+		visited++
+		x.TargetEach(func(idx int, child l.TargetAbstract) bool {
+			expected := x.TargetAt(idx)
+			if expected == nil {
+				a.Nilf(child, "at index %d", idx)
+				return true
+			}
+			if a.NotNilf(child, "at index %d", idx) {
+				a.Equal(expected.TargetTypeID(), child.TargetTypeID())
+				a.Equal(expected.TargetCount(), child.TargetCount())
+				check(child)
+			}
+			return true
+		})
+	}
+	check(x)
+	a.Equal(countAbstract(x), visited)
+
+	// Returning false should stop the iteration.
+	var seen []int
+	x.TargetEach(func(idx int, _ l.TargetAbstract) bool {
+		seen = append(seen, idx)
+		return idx < 2
+	})
+	a.Equal([]int{0, 1, 2}, seen)
+}
+
+func TestFieldNameAt(t *testing.T) {
+	a := assert.New(t)
+	x, _ := l.NewContainer(false)
+	a.Equal("ByRef", x.TargetFieldNameAt(0))
+	a.Equal("ByRefPtr", x.TargetFieldNameAt(1))
+	a.Equal("Container", x.TargetFieldNameAt(8))
+
+	slice := x.TargetNamed("ByRefSlice")
+	if a.NotNil(slice) {
+		a.Equal("[1]", slice.TargetFieldNameAt(1))
+		a.Equal("index out of range: 100", panicMessage(func() { slice.TargetFieldNameAt(100) }))
+	}
+
+	a.Equal("Children()", l.NewEncapsulated(&l.ByRefType{}).TargetFieldNameAt(0))
+	env := (&l.ScopeType{Env: map[string]l.Target{"b": &l.ByRefType{}, "a": &l.ByRefType{}}}).TargetAt(0)
+	if a.NotNil(env) {
+		a.Equal(`["a"]`, env.TargetFieldNameAt(0))
+		a.Equal(`["b"]`, env.TargetFieldNameAt(1))
+	}
+
+	// Out-of-range indices panic, as with TargetAt.
+	a.Equal("index out of range: -1", panicMessage(func() { x.TargetFieldNameAt(-1) }))
+	a.Equal("index out of range: 100", panicMessage(func() { x.TargetFieldNameAt(100) }))
+}
+
+// TestAbstractWalk verifies that a typed visitation can be started
+// from a value located via the abstract API.
+func TestAbstractWalk(t *testing.T) {
+	a := assert.New(t)
+	x, _ := l.NewContainer(false)
+
+	replace := func(ctx l.TargetContext, x l.Target) (d l.TargetDecision) {
+		if _, ok := x.(*l.ByValType); ok {
+			d = d.Replace(&l.ByValType{Val: "Replaced"})
+		}
+		return
+	}
+
+	t.Run("slice", func(t *testing.T) {
+		a := assert.New(t)
+		slice := x.TargetAt(6)
+		if !a.NotNil(slice) {
+			return
+		}
+		ret, changed, err := slice.TargetWalk(replace)
+		if !a.NoError(err) {
+			return
+		}
+		a.True(changed)
+		a.Equal(slice.TargetTypeID(), ret.TargetTypeID())
+		a.Equal(2, ret.TargetCount())
+		a.Equal(&l.ByValType{Val: "Replaced"}, ret.TargetAt(0))
+		a.Equal("olleH", x.ByValSlice[0].Val, "original should not have changed")
+	})
+
+	t.Run("struct", func(t *testing.T) {
+		a := assert.New(t)
+		child := x.TargetAt(1)
+		ret, changed, err := child.TargetWalk(func(ctx l.TargetContext, x l.Target) l.TargetDecision {
+			x.(*l.ByRefType).Val = "Mutated"
+			return ctx.Continue()
+		})
+		if !a.NoError(err) {
+			return
+		}
+		a.False(changed)
+		a.True(ret == child)
+		a.Equal("Mutated", x.ByRefPtr.Val)
+	})
+
+	t.Run("unchanged", func(t *testing.T) {
+		a := assert.New(t)
+		slice := x.TargetAt(3)
+		ret, changed, err := slice.TargetWalk(replace)
+		a.NoError(err)
+		a.False(changed)
+		a.True(ret == slice)
+	})
+
+	var nilRef *l.ByRefType
+	ret, changed, err := nilRef.TargetWalk(replace)
+	a.NoError(err)
+	a.False(changed)
+	a.Nil(ret)
+}
+
+// TestNamedArray verifies that the elements of a named array type are
+// visited and that the array is rebuilt when an element is replaced.
+func TestNamedArray(t *testing.T) {
+	a := assert.New(t)
+	c := &l.ContainerType{
+		Quad: l.Quad{l.ByValType{Val: "0"}, nil, &l.ByRefType{Val: "2"}, l.ByValType{Val: "3"}},
+	}
+
+	quad := c.TargetAt(16)
+	if a.NotNil(quad) {
+		a.Equal("[4]Target", quad.TargetTypeID().String())
+		a.Equal(4, quad.TargetCount())
+		a.Nil(quad.TargetAt(1))
+		a.Equal(&l.ByRefType{Val: "2"}, quad.TargetAt(2))
+	}
+
+	c2, changed, err := c.WalkTarget(func(ctx l.TargetContext, x l.Target) (d l.TargetDecision) {
+		if t, ok := x.(*l.ByValType); ok && t.Val == "3" {
+			d = d.Replace(&l.ByRefType{Val: "Three"})
+		}
+		return
+	})
+	if !a.NoError(err) {
+		return
+	}
+	a.True(changed)
+	a.Equal(l.Quad{l.ByValType{Val: "0"}, nil, &l.ByRefType{Val: "2"}, &l.ByRefType{Val: "Three"}}, c2.Quad)
+	a.Equal(l.ByValType{Val: "3"}, c.Quad[3], "original should not have changed")
+}
+
+// TestParent descends into a container with the navigation API and
+// then walks back up to the container.
+func TestParent(t *testing.T) {
+	a := assert.New(t)
+	x, _ := l.NewContainer(false)
+
+	root := l.NewTargetNode(x)
+	a.Nil(root.Parent())
+	a.Equal(-1, root.ParentAt())
+
+	// ContainerType.ByRefSlice[0]
+	slice := root.TargetAt(2)
+	a.Equal(l.TargetTypeByRefTypeSlice, slice.TargetTypeID())
+	elt := slice.TargetAt(0)
+	if !a.NotNil(elt) {
+		return
+	}
+	a.True(elt.Abstract() == l.TargetAbstract(&x.ByRefSlice[0]))
+	a.Equal(0, elt.ParentAt())
+
+	// Navigation does not change the other accessors.
+	a.Equal(slice.TargetCount(), elt.Parent().TargetCount())
+	a.Equal(slice.TargetTypeID(), elt.Parent().TargetTypeID())
+
+	up := elt.Parent().Parent()
+	a.Equal(2, elt.Parent().ParentAt())
+	a.True(up.Abstract() == l.TargetAbstract(x))
+	a.Nil(up.Parent())
+
+	a.Nil(l.NewTargetNode(nil))
+}
+
+// TestSiblings navigates between the children of a struct and of an
+// array with the navigation API.
+func TestSiblings(t *testing.T) {
+	a := assert.New(t)
+	x, _ := l.NewContainer(false)
+
+	root := l.NewTargetNode(x)
+	a.Nil(root.NextSibling())
+	a.Nil(root.PrevSibling())
+
+	// The fields of a struct.
+	slice := root.TargetNamed("ByRefSlice")
+	if next := slice.NextSibling(); a.NotNil(next) {
+		a.Equal(l.TargetTypeByRefTypePtrSlice, next.TargetTypeID())
+		a.Equal(slice.ParentAt()+1, next.ParentAt())
+		a.True(next.Parent().Abstract() == l.TargetAbstract(x))
+		a.Equal(slice.TargetTypeID(), next.PrevSibling().TargetTypeID())
+	}
+	if prev := slice.PrevSibling(); a.NotNil(prev) {
+		a.True(prev.Abstract() == l.TargetAbstract(x.ByRefPtr))
+	}
+
+	// The elements of a slice.
+	first := slice.TargetAt(0)
+	a.Nil(first.PrevSibling())
+	if second := first.NextSibling(); a.NotNil(second) {
+		a.True(second.Abstract() == l.TargetAbstract(&x.ByRefSlice[1]))
+		a.Nil(second.NextSibling())
+	}
+
+	// Quad holds a nil element, which is reported as nil.
+	quad := root.TargetNamed("Quad")
+	a.Nil(quad.TargetAt(0).NextSibling())
+	if last := quad.TargetAt(2).NextSibling(); a.NotNil(last) {
+		a.Equal(3, last.ParentAt())
+		a.Nil(last.NextSibling())
+	}
+}
+
+// TestStructArray ensures that an array of struct values is copied,
+// rather than modified, when its elements are replaced.
+func TestStructArray(t *testing.T) {
+	a := assert.New(t)
+	x := &l.PairType{Pair: [2]l.ByRefType{{Val: "olleH"}, {Val: "dlroW"}}}
+
+	ret, changed, err := l.WalkTarget(x, func(ctx l.TargetContext, x l.Target) (d l.TargetDecision) {
+		if t, ok := x.(*l.ByRefType); ok {
+			runes := []rune(t.Val)
+			for i, j := 0, len(runes)-1; i < j; i, j = i+1, j-1 {
+				runes[i], runes[j] = runes[j], runes[i]
+			}
+			d = d.Replace(&l.ByRefType{Val: string(runes)})
+		}
+		return
+	})
+	if !a.NoError(err) {
+		return
+	}
+	a.True(changed)
+	a.Equal([2]l.ByRefType{{Val: "Hello"}, {Val: "World"}}, ret.(*l.PairType).Pair)
+	a.Equal([2]l.ByRefType{{Val: "olleH"}, {Val: "dlroW"}}, x.Pair, "original should not have changed")
+}
+
+func TestNamedPointer(t *testing.T) {
+	a := assert.New(t)
+	orig := &l.ByRefType{Val: "Opt"}
+	c := &l.ContainerType{OptTarget: orig}
+
+	a.Equal(orig, c.TargetAt(17))
+
+	c2, changed, err := c.WalkTarget(func(ctx l.TargetContext, x l.Target) (d l.TargetDecision) {
+		if t, ok := x.(*l.ByRefType); ok && t.Val == "Opt" {
+			d = d.Replace(&l.ByRefType{Val: "Replaced"})
+		}
+		return
+	})
+	if !a.NoError(err) {
+		return
+	}
+	a.True(changed)
+	a.Equal(l.OptTarget(&l.ByRefType{Val: "Replaced"}), c2.OptTarget)
+	a.True(orig == (*l.ByRefType)(c.OptTarget), "original should not have changed")
+	a.Equal("Opt", orig.Val)
+}
+
+func TestExtendedInterface(t *testing.T) {
+	a := assert.New(t)
+	orig := &l.ByRefType{Val: "Annotated"}
+	c := &l.ContainerType{Annotated: orig}
+
+	a.Equal(orig, c.TargetAt(18))
+
+	c2, changed, err := c.WalkTarget(func(ctx l.TargetContext, x l.Target) (d l.TargetDecision) {
+		if t, ok := x.(*l.ByRefType); ok && t.Val == "Annotated" {
+			d = d.Replace(&l.ByRefType{Val: "Replaced"})
+		}
+		return
+	})
+	if !a.NoError(err) {
+		return
+	}
+	a.True(changed)
+	a.Equal("ByRef: Replaced", c2.Annotated.Annotation())
+	a.Equal("ByRef: Annotated", c.Annotated.Annotation(), "original should not have changed")
+
+	// Replacements must also implement the extended interface.
+	_, _, err = c.WalkTarget(func(ctx l.TargetContext, x l.Target) (d l.TargetDecision) {
+		if _, ok := x.(*l.ByRefType); ok {
+			d = d.Replace(l.ByValType{})
+		}
+		return
+	})
+	a.Error(err)
+}
+
 // TestCycleBreak creates a cyclical datastructure.
 func TestCycleBreak(t *testing.T) {
 	d, _ := l.NewContainer(false)
@@ -100,37 +432,866 @@ func TestCycleBreak(t *testing.T) {
 	})
 }
 
-// Regression check to ensure that Halt().Replace() works.
-func TestHaltReplaceInner(t *testing.T) {
+// TestDetectCycles verifies that broken cycles are reported.
+func TestDetectCycles(t *testing.T) {
+	fn := func(ctx l.TargetContext, x l.Target) (d l.TargetDecision) { return }
+
+	t.Run("acyclic", func(t *testing.T) {
+		a := assert.New(t)
+		d, _ := l.NewContainer(false)
+		cycles, err := l.WalkTargetDetectCycles(d, fn)
+		a.NoError(err)
+		a.Empty(cycles)
+	})
+	t.Run("cyclic", func(t *testing.T) {
+		a := assert.New(t)
+		d, _ := l.NewContainer(false)
+		d.Container = d
+		cycles, err := l.WalkTargetDetectCycles(d, fn)
+		a.NoError(err)
+		if a.Len(cycles, 1) {
+			a.Equal("Container", cycles[0].Path.String())
+			a.Equal(l.TargetTypeContainerType, cycles[0].TypeID)
+			a.True(cycles[0].Value == l.Target(d))
+		}
+	})
+	t.Run("nested", func(t *testing.T) {
+		a := assert.New(t)
+		d, _ := l.NewContainer(false)
+		inner := &l.ContainerType{}
+		inner.TargetSlice = []l.Target{&l.ScopeType{Env: map[string]l.Target{"back": inner}}}
+		d.Container = inner
+		cycles, err := l.WalkTargetDetectCycles(d, fn)
+		a.NoError(err)
+		if a.Len(cycles, 1) {
+			a.Equal(`Container.TargetSlice[0].Env["back"]`, cycles[0].Path.String())
+			a.True(cycles[0].Value == l.Target(inner))
+		}
+	})
+}
+
+// Regression check to ensure that Halt().Replace() works.
+func TestHaltReplaceInner(t *testing.T) {
+	a := assert.New(t)
+	d, _ := l.NewContainer(false)
+	d2, changed, err := d.WalkTarget(func(ctx l.TargetContext, x l.Target) l.TargetDecision {
+		if _, ok := x.(*l.ByRefType); ok {
+			return ctx.Halt().Replace(&l.ByRefType{Val: "HaltReplace"})
+		}
+		return ctx.Continue()
+	})
+	if !a.NoError(err) {
+		return
+	}
+	a.True(changed)
+	a.NotEqual(d, d2)
+	a.Equal("HaltReplace", d2.ByRef.Val)
+}
+
+// Regression check to ensure that Halt().Replace() works at the top level.
+func TestHaltReplaceTop(t *testing.T) {
+	a := assert.New(t)
+	d, _ := l.NewContainer(false)
+	d2, changed, err := d.WalkTarget(func(ctx l.TargetContext, x l.Target) l.TargetDecision {
+		return ctx.Halt().Replace(&l.ContainerType{ByRef: l.ByRefType{Val: "HaltReplace"}})
+	})
+	if !a.NoError(err) {
+		return
+	}
+	a.True(changed)
+	a.NotEqual(d, d2)
+	a.Equal("HaltReplace", d2.ByRef.Val)
+}
+
+// TestWalkCtx cancels a walk part-way through a deep chain of
+// containers and verifies that the partial changes are discarded.
+func TestWalkCtx(t *testing.T) {
+	a := assert.New(t)
+	const depth = 100
+	var root *l.ContainerType
+	for i := 0; i < depth; i++ {
+		root = &l.ContainerType{ByRefPtr: &l.ByRefType{Val: "olleH"}, Container: root}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	visited := 0
+	fn := func(_ l.TargetContext, x l.Target) (d l.TargetDecision) {
+		// Ignore the by-value ContainerType.ByRef fields.
+		if t, ok := x.(*l.ByRefType); ok && t.Val != "" {
+			visited++
+			if visited == depth/2 {
+				cancel()
+			}
+			d = d.Replace(&l.ByRefType{Val: reverse(t.Val)})
+		}
+		return
+	}
+
+	ret, changed, err := l.WalkTargetCtx(ctx, root, fn)
+	a.Equal(context.Canceled, err)
+	a.Nil(ret)
+	a.False(changed)
+	a.Equal(depth/2, visited)
+	for x := root; x != nil; x = x.Container {
+		a.Equal("olleH", x.ByRefPtr.Val, "input should not have changed")
+	}
+
+	// A context which has already been cancelled prevents the walk.
+	visited = 0
+	_, _, err = l.WalkTargetCtx(ctx, root, fn)
+	a.Equal(context.Canceled, err)
+	a.Zero(visited)
+}
+
+func TestWalkChan(t *testing.T) {
+	t.Run("events", func(t *testing.T) {
+		a := assert.New(t)
+		inner := &l.ByValType{Val: "inner"}
+		x := &l.WrapperType{Target: inner}
+
+		var events []l.TargetEvent
+		for ev := range l.WalkTargetChan(context.Background(), x) {
+			events = append(events, ev)
+		}
+		a.Equal([]l.TargetEvent{
+			{Value: x, TypeID: l.TargetTypeWrapperType},
+			{Value: inner, TypeID: l.TargetTypeByValType},
+			{Value: inner, TypeID: l.TargetTypeByValType, Post: true},
+			{Value: x, TypeID: l.TargetTypeWrapperType, Post: true},
+		}, events)
+
+		_, open := <-l.WalkTargetChan(context.Background(), nil)
+		a.False(open)
+	})
+
+	// A consumer which stops reading early cancels the context, which
+	// allows the goroutine to exit and close the channel.
+	t.Run("cancel", func(t *testing.T) {
+		a := assert.New(t)
+		var root *l.ContainerType
+		for i := 0; i < 100; i++ {
+			root = &l.ContainerType{Container: root}
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		ch := l.WalkTargetChan(ctx, root)
+		ev := <-ch
+		a.True(ev.Value == l.Target(root))
+		cancel()
+
+		for ev := range ch {
+			if ev.Err != nil {
+				a.Equal(context.Canceled, ev.Err)
+				a.Nil(ev.Value)
+			}
+		}
+	})
+}
+
+func TestInterceptNamed(t *testing.T) {
+	t.Run("calc", func(t *testing.T) {
+		a := assert.New(t)
+		c := &l.Func{Fn: "Avg", Args: []l.Expr{
+			&l.BinaryOp{Operator: "+", Left: &l.Scalar{}, Right: &l.Scalar{}},
+			&l.Scalar{},
+		}}
+
+		var names []string
+		_, _, err := l.WalkCalc(c, func(ctx l.CalcContext, x l.Calc) l.CalcDecision {
+			switch x.(type) {
+			case *l.Func, *l.BinaryOp:
+				return ctx.Continue().InterceptNamed(func(_ l.CalcContext, name string, _ l.Calc) (d l.CalcDecision) {
+					names = append(names, name)
+					return
+				})
+			}
+			return ctx.Continue()
+		})
+		a.NoError(err)
+		a.Equal([]string{"Args[0]", "Left", "Right", "Args[1]"}, names)
+	})
+
+	// Forked children must report the same names.
+	t.Run("parallel", func(t *testing.T) {
+		a := assert.New(t)
+		x, _ := l.NewContainer(true)
+
+		collect := func(parallel bool) map[string]bool {
+			var mu sync.Mutex
+			names := make(map[string]bool)
+			_, _, err := l.WalkTarget(x, func(ctx l.TargetContext, x l.Target) l.TargetDecision {
+				if ctx.Depth() > 0 {
+					return ctx.Continue()
+				}
+				d := ctx.Continue()
+				if parallel {
+					d = ctx.Parallel()
+				}
+				return d.InterceptNamed(func(_ l.TargetContext, name string, _ l.Target) (d l.TargetDecision) {
+					mu.Lock()
+					names[name] = true
+					mu.Unlock()
+					return
+				})
+			})
+			a.NoError(err)
+			return names
+		}
+
+		serial := collect(false)
+		a.True(serial["ByRefSlice[1]"])
+		a.True(serial["Quad[0]"])
+		a.True(serial["AnotherTargetPtr"])
+		a.Equal(serial, collect(true))
+	})
+}
+
+func TestRestart(t *testing.T) {
+	t.Run("restart", func(t *testing.T) {
+		a := assert.New(t)
+		d, _ := l.NewContainer(false)
+		var visited []string
+		d2, changed, err := d.WalkTarget(func(ctx l.TargetContext, x l.Target) (ret l.TargetDecision) {
+			visited = append(visited, x.Value())
+			ret = ret.Post(func(ctx l.TargetContext, x l.Target) l.TargetDecision {
+				if _, ok := x.(*l.ContainerType); ok {
+					visited = append(visited, "Post")
+				}
+				return ctx.Continue()
+			})
+			if y, ok := x.(*l.ByRefType); ok && y.Val == "olleH" {
+				return ret.Replace(&l.ByRefType{Val: "Hello"}).Restart()
+			}
+			return ret
+		})
+		if !a.NoError(err) {
+			return
+		}
+		a.True(changed)
+		a.Equal("Hello", d2.ByRef.Val)
+		a.Equal("Hello", d2.ByRefPtr.Val)
+		a.Equal("olleH", d.ByRef.Val)
+
+		// Each replacement restarts from the top, without calling the
+		// pending post-visit function, until nothing is replaced.
+		a.Equal([]string{"Container", "olleH", "Container", "Hello", "olleH"}, visited[:5])
+		a.Equal("Post", visited[len(visited)-1])
+		a.Equal(1, strings.Count(strings.Join(visited, ","), "Post"))
+	})
+
+	t.Run("no replacement", func(t *testing.T) {
+		a := assert.New(t)
+		d, _ := l.NewContainer(false)
+		count := func(restart bool) (count int) {
+			_, changed, err := d.WalkTarget(func(ctx l.TargetContext, x l.Target) (ret l.TargetDecision) {
+				count++
+				if restart {
+					ret = ret.Restart()
+				}
+				return
+			})
+			a.NoError(err)
+			a.False(changed)
+			return
+		}
+		a.Equal(count(false), count(true))
+	})
+
+	t.Run("too many", func(t *testing.T) {
+		a := assert.New(t)
+		d, _ := l.NewContainer(false)
+		_, _, err := d.WalkTarget(func(ctx l.TargetContext, x l.Target) l.TargetDecision {
+			if y, ok := x.(*l.ByRefType); ok {
+				return ctx.Continue().Replace(&l.ByRefType{Val: y.Val + "!"}).Restart()
+			}
+			return ctx.Continue()
+		})
+		if a.Error(err) {
+			a.Contains(err.Error(), "restarted more than 1000 times")
+		}
+	})
+}
+
+func TestReplaceInPlace(t *testing.T) {
+	upper := func(ctx l.TargetContext, x l.Target) (d l.TargetDecision) {
+		switch t := x.(type) {
+		case *l.ByRefType:
+			d = d.ReplaceInPlace(&l.ByRefType{Val: strings.ToUpper(t.Val)})
+		case *l.ByValType:
+			d = d.ReplaceInPlace(&l.ByValType{Val: strings.ToUpper(t.Val)})
+		}
+		return
+	}
+
+	t.Run("pointers", func(t *testing.T) {
+		a := assert.New(t)
+		inner := &l.ContainerType{ByRefSlice: []l.ByRefType{{Val: "b"}}}
+		x := &l.ContainerType{
+			ByRefPtr:      &l.ByRefType{Val: "a"},
+			AnotherTarget: &l.ByValType{Val: "c"},
+			Container:     inner,
+		}
+		y, changed, err := x.WalkTarget(upper)
+		if !a.NoError(err) {
+			return
+		}
+		a.True(changed)
+		// No values should have been cloned.
+		a.True(x == y)
+		a.True(inner == x.Container)
+		a.Equal("A", x.ByRefPtr.Val)
+		a.Equal("B", inner.ByRefSlice[0].Val)
+		a.Equal("C", x.AnotherTarget.Value())
+	})
+
+	t.Run("getter", func(t *testing.T) {
+		a := assert.New(t)
+		// The elements of a slice returned by a getter share memory with
+		// the slice in the struct, so they may be replaced in place.
+		x := l.NewEncapsulated(&l.ByRefType{Val: "a"})
+		_, changed, err := x.WalkTarget(upper)
+		a.NoError(err)
+		a.True(changed)
+		a.Equal("A", x.Children()[0].Value())
+	})
+
+	t.Run("interface value", func(t *testing.T) {
+		a := assert.New(t)
+		// The interface holds a copy of the value.
+		x := &l.ContainerType{AnotherTarget: l.ByValType{Val: "c"}}
+		_, _, err := x.WalkTarget(upper)
+		if a.Error(err) {
+			a.Contains(err.Error(), "cannot replace ByValType in place, since it is not stored in mutable memory")
+		}
+		a.Equal("c", x.AnotherTarget.Value())
+	})
+
+	t.Run("type change", func(t *testing.T) {
+		a := assert.New(t)
+		x := &l.ContainerType{AnotherTarget: &l.ByValType{Val: "c"}}
+		_, _, err := x.WalkTarget(func(ctx l.TargetContext, x l.Target) (d l.TargetDecision) {
+			if _, ok := x.(*l.ByValType); ok {
+				d = d.ReplaceInPlace(&l.ByRefType{})
+			}
+			return
+		})
+		if a.Error(err) {
+			a.Contains(err.Error(), "cannot replace ByValType in place with ByRefType")
+		}
+	})
+}
+
+// TestReplaceChildren ensures that the elements of a slice may be
+// inserted or removed by returning ReplaceChildren.
+func TestReplaceChildren(t *testing.T) {
+	t.Run("remove", func(t *testing.T) {
+		a := assert.New(t)
+		first, second, third := &l.Scalar{}, &l.Scalar{}, &l.Scalar{}
+		inner := &l.Func{Fn: "Inner", Args: []l.Expr{first, second, third}}
+		x := &l.Func{Fn: "Outer", Args: []l.Expr{inner}}
+
+		y, changed, err := x.WalkCalc(func(ctx l.CalcContext, x l.Calc) l.CalcDecision {
+			if f, ok := x.(*l.Func); ok && f.Fn == "Inner" {
+				return ctx.ReplaceChildren(ctx.ActionVisit(f.Args[0]), ctx.ActionVisit(f.Args[2]))
+			}
+			return ctx.Continue()
+		})
+		if !a.NoError(err) {
+			return
+		}
+		a.True(changed)
+		// The original values should be untouched.
+		a.Len(inner.Args, 3)
+		a.True(x.Args[0] == inner)
+
+		got := y.Args[0].(*l.Func)
+		a.True(got != inner)
+		a.Equal("Inner", got.Fn)
+		if a.Len(got.Args, 2) {
+			a.True(got.Args[0] == first)
+			a.True(got.Args[1] == third)
+		}
+	})
+
+	t.Run("insert", func(t *testing.T) {
+		a := assert.New(t)
+		first, fresh := &l.Scalar{}, &l.Scalar{}
+		x := &l.Func{Fn: "Avg", Args: []l.Expr{first}}
+
+		calls := 0
+		y, changed, err := x.WalkCalc(func(ctx l.CalcContext, x l.Calc) l.CalcDecision {
+			switch t := x.(type) {
+			case *l.Func:
+				return ctx.ReplaceChildren(
+					ctx.ActionVisit(t.Args[0]),
+					ctx.ActionCall(func() error { calls++; return nil }),
+					ctx.ActionVisit(&l.BinaryOp{Operator: "+", Left: first, Right: first}),
+				)
+			case *l.Scalar:
+				// The values visited by the actions may contain
+				// replacements.
+				if t == first && ctx.Depth() > 1 {
+					return ctx.Continue().Replace(fresh)
+				}
+			}
+			return ctx.Continue()
+		})
+		if !a.NoError(err) {
+			return
+		}
+		a.True(changed)
+		a.Equal(1, calls)
+		a.Len(x.Args, 1)
+		if a.Len(y.Args, 2) {
+			a.True(y.Args[0] == first)
+			op := y.Args[1].(*l.BinaryOp)
+			a.True(op.Left == fresh)
+			a.True(op.Right == fresh)
+		}
+	})
+
+	t.Run("empty", func(t *testing.T) {
+		a := assert.New(t)
+		x := &l.Func{Fn: "Avg", Args: []l.Expr{&l.Scalar{}}}
+		y, changed, err := x.WalkCalc(func(ctx l.CalcContext, x l.Calc) l.CalcDecision {
+			return ctx.ReplaceChildren()
+		})
+		a.NoError(err)
+		a.True(changed)
+		a.Len(x.Args, 1)
+		a.Len(y.Args, 0)
+	})
+
+	t.Run("not a slice", func(t *testing.T) {
+		a := assert.New(t)
+		x := &l.BinaryOp{Operator: "+", Left: &l.Scalar{}, Right: &l.Scalar{}}
+		_, _, err := x.WalkCalc(func(ctx l.CalcContext, x l.Calc) l.CalcDecision {
+			return ctx.ReplaceChildren()
+		})
+		if a.Error(err) {
+			a.Contains(err.Error(), "cannot replace the children of BinaryOp")
+		}
+	})
+
+	t.Run("not assignable", func(t *testing.T) {
+		a := assert.New(t)
+		x := &l.Func{Fn: "Avg"}
+		_, _, err := x.WalkCalc(func(ctx l.CalcContext, x l.Calc) l.CalcDecision {
+			if _, ok := x.(*l.Func); ok {
+				return ctx.ReplaceChildren(ctx.ActionVisit(&l.Calculation{}))
+			}
+			return ctx.Continue()
+		})
+		if a.Error(err) {
+			a.Contains(err.Error(), "type Calculation is not assignable to Func.Args")
+		}
+	})
+}
+
+// TestReplaceWith ensures that a slice element may be replaced with
+// any number of values.
+func TestReplaceWith(t *testing.T) {
+	t.Run("expand", func(t *testing.T) {
+		a := assert.New(t)
+		first := &l.Scalar{}
+		op := &l.BinaryOp{Operator: "+", Left: &l.Scalar{}, Right: &l.Scalar{}}
+		x := &l.Func{Fn: "Avg", Args: []l.Expr{op, first}}
+		expanded := []l.Calc{&l.Scalar{}, &l.Scalar{}}
+
+		y, changed, err := x.WalkCalc(func(ctx l.CalcContext, x l.Calc) l.CalcDecision {
+			if x == first {
+				return ctx.Continue().ReplaceWith(expanded...)
+			}
+			return ctx.Continue()
+		})
+		if !a.NoError(err) {
+			return
+		}
+		a.True(changed)
+		a.Len(x.Args, 2)
+		if a.Len(y.Args, 3) {
+			a.True(y.Args[0] == op)
+			a.True(y.Args[1] == expanded[0])
+			a.True(y.Args[2] == expanded[1])
+		}
+	})
+
+	t.Run("remove", func(t *testing.T) {
+		a := assert.New(t)
+		first, second := &l.Scalar{}, &l.Scalar{}
+		x := &l.Func{Fn: "Avg", Args: []l.Expr{first, second}}
+		y, _, err := x.WalkCalc(func(ctx l.CalcContext, x l.Calc) l.CalcDecision {
+			if x == second {
+				return ctx.Continue().ReplaceWith()
+			}
+			return ctx.Continue()
+		})
+		if a.NoError(err) && a.Len(y.Args, 1) {
+			a.True(y.Args[0] == first)
+		}
+	})
+
+	t.Run("values", func(t *testing.T) {
+		a := assert.New(t)
+		x := &l.ContainerType{
+			ByRefSlice:    []l.ByRefType{{Val: "a"}, {Val: "b"}},
+			ByRefPtrSlice: []*l.ByRefType{{Val: "c"}},
+		}
+		y, _, err := x.WalkTarget(func(ctx l.TargetContext, x l.Target) (d l.TargetDecision) {
+			if t, ok := x.(*l.ByRefType); ok && t.Val != "" {
+				d = d.ReplaceWith(&l.ByRefType{Val: t.Val + "1"}, &l.ByRefType{Val: t.Val + "2"})
+			}
+			return
+		})
+		if !a.NoError(err) {
+			return
+		}
+		a.Len(x.ByRefSlice, 2)
+		a.Equal([]l.ByRefType{{Val: "a1"}, {Val: "a2"}, {Val: "b1"}, {Val: "b2"}}, y.ByRefSlice)
+		a.Equal([]*l.ByRefType{{Val: "c1"}, {Val: "c2"}}, y.ByRefPtrSlice)
+	})
+
+	// Forked slice elements must also be expanded.
+	t.Run("parallel", func(t *testing.T) {
+		a := assert.New(t)
+		x := &l.ContainerType{TargetSlice: []l.Target{&l.ByRefType{Val: "a"}, &l.ByValType{Val: "b"}}}
+		y, _, err := x.WalkTarget(func(ctx l.TargetContext, x l.Target) l.TargetDecision {
+			switch t := x.(type) {
+			case *l.ContainerType:
+				return ctx.Parallel()
+			case *l.ByRefType:
+				if t.Val == "a" {
+					return ctx.Continue().ReplaceWith(t, t)
+				}
+			}
+			return ctx.Continue()
+		})
+		if a.NoError(err) && a.Len(y.TargetSlice, 3) {
+			a.Equal("a", y.TargetSlice[0].Value())
+			a.Equal("a", y.TargetSlice[1].Value())
+			a.Equal("b", y.TargetSlice[2].Value())
+		}
+	})
+
+	t.Run("not a slice", func(t *testing.T) {
+		a := assert.New(t)
+		x := &l.ContainerType{ByRefPtr: &l.ByRefType{Val: "a"}}
+		_, _, err := x.WalkTarget(func(ctx l.TargetContext, x l.Target) (d l.TargetDecision) {
+			if t, ok := x.(*l.ByRefType); ok && t.Val == "a" {
+				d = d.ReplaceWith(x, x)
+			}
+			return
+		})
+		if a.Error(err) {
+			a.Contains(err.Error(), "ByRefType is not an element of a slice")
+		}
+	})
+
+	t.Run("not assignable", func(t *testing.T) {
+		a := assert.New(t)
+		x := &l.ContainerType{ByRefSlice: []l.ByRefType{{Val: "a"}}}
+		_, _, err := x.WalkTarget(func(ctx l.TargetContext, x l.Target) (d l.TargetDecision) {
+			if t, ok := x.(*l.ByRefType); ok && t.Val == "a" {
+				d = d.ReplaceWith(&l.ByValType{})
+			}
+			return
+		})
+		if a.Error(err) {
+			a.Contains(err.Error(), "type ByValType is not assignable to ByRefType")
+		}
+	})
+}
+
+// TestEmbeddedInterface ensures that an embedded interface field is
+// visited and may be replaced.
+func TestEmbeddedInterface(t *testing.T) {
 	a := assert.New(t)
-	d, _ := l.NewContainer(false)
-	d2, changed, err := d.WalkTarget(func(ctx l.TargetContext, x l.Target) l.TargetDecision {
-		if _, ok := x.(*l.ByRefType); ok {
-			return ctx.Halt().Replace(&l.ByRefType{Val: "HaltReplace"})
+	w := &l.WrapperType{Target: &l.ByRefType{Val: "Embedded"}, Name: "Wrapper"}
+
+	var visited []string
+	w2, changed, err := w.WalkTarget(func(ctx l.TargetContext, x l.Target) (d l.TargetDecision) {
+		visited = append(visited, x.Value())
+		if x.Value() == "Embedded" {
+			d = d.Replace(&l.ByRefType{Val: "Replaced"})
 		}
-		return ctx.Continue()
+		return
 	})
 	if !a.NoError(err) {
 		return
 	}
+	a.Equal([]string{"Wrapper: Wrapper", "Embedded"}, visited)
 	a.True(changed)
-	a.NotEqual(d, d2)
-	a.Equal("HaltReplace", d2.ByRef.Val)
+	a.Equal(&l.ByRefType{Val: "Replaced"}, w2.Target)
+	a.Equal("Wrapper", w2.Name)
+	a.Equal("Embedded", w.Target.Value())
+	a.Equal(1, w.TargetCount())
+	a.Equal(w.Target, w.TargetAt(0))
 }
 
-// Regression check to ensure that Halt().Replace() works at the top level.
-func TestHaltReplaceTop(t *testing.T) {
+// TestPromotedFields ensures that the visitable fields of embedded
+// structs are visited and may be replaced.
+func TestPromotedFields(t *testing.T) {
 	a := assert.New(t)
-	d, _ := l.NewContainer(false)
-	d2, changed, err := d.WalkTarget(func(ctx l.TargetContext, x l.Target) l.TargetDecision {
-		return ctx.Halt().Replace(&l.ContainerType{ByRef: l.ByRefType{Val: "HaltReplace"}})
+	x := &l.EmbeddingType{
+		EmbeddedFields: l.EmbeddedFields{
+			Promoted:  &l.ByRefType{Val: "a"},
+			Promoteds: []l.Target{&l.ByRefType{Val: "b"}},
+		},
+		Own: &l.ByRefType{Val: "d"},
+	}
+	x.Scoped = &l.ByRefType{Val: "c"}
+
+	var visited []string
+	y, changed, err := x.WalkTarget(func(ctx l.TargetContext, x l.Target) (d l.TargetDecision) {
+		visited = append(visited, x.Value())
+		if t, ok := x.(*l.ByRefType); ok {
+			d = d.Replace(&l.ByRefType{Val: strings.ToUpper(t.Val)})
+		}
+		return
 	})
 	if !a.NoError(err) {
 		return
 	}
+	a.Equal([]string{"Embedding", "a", "b", "c", "d"}, visited)
 	a.True(changed)
-	a.NotEqual(d, d2)
-	a.Equal("HaltReplace", d2.ByRef.Val)
+	a.Equal("A", y.Promoted.Value())
+	a.Equal("B", y.Promoteds[0].Value())
+	a.Equal("C", y.Scoped.Value())
+	a.Equal("D", y.Own.Value())
+	a.Equal("a", x.Promoted.Value())
+	a.Equal("c", x.Scoped.Value())
+	a.Equal(4, x.TargetCount())
+	a.Equal(x.Scoped, x.TargetNamed("Scoped").(l.Target))
+}
+
+// TestGetters ensures that the values returned by getter methods are
+// visited, but that they cannot be replaced.
+func TestGetters(t *testing.T) {
+	a := assert.New(t)
+	x := &l.ContainerType{
+		Container: &l.ContainerType{
+			AnotherTarget: l.NewEncapsulated(&l.ByRefType{Val: "One"}, nil, l.ByValType{Val: "Two"}),
+		},
+	}
+	enc := x.Container.AnotherTarget.(*l.EncapsulatedType)
+
+	var visited []string
+	_, changed, err := l.WalkTarget(x, func(ctx l.TargetContext, x l.Target) l.TargetDecision {
+		switch x.(type) {
+		case *l.ByRefType, *l.ByValType:
+			if x.Value() != "" {
+				visited = append(visited, x.Value())
+			}
+		}
+		return ctx.Continue()
+	})
+	a.NoError(err)
+	a.False(changed)
+	a.Equal([]string{"One", "Two"}, visited)
+
+	// Paths should include the name of the getter.
+	enc.Children()[1] = &l.ContainerType{}
+	if err := l.CheckTargetInvariants(x); a.Error(err) {
+		a.Contains(err.Error(), "Container.AnotherTarget.Children()[1].ByRefPtr (nonnil)")
+	}
+	enc.Children()[1] = nil
+
+	// The abstract accessors should also expose the children.
+	a.Equal(1, enc.TargetCount())
+	if children, ok := enc.TargetAt(0).(l.TargetAbstract); a.True(ok) {
+		a.Equal(l.TargetTypeTargetSlice, children.TargetTypeID())
+		a.Equal(3, children.TargetCount())
+		a.Equal(enc.Children()[0], children.TargetAt(0))
+		a.Nil(children.TargetAt(1))
+	}
+
+	// Replacing a value within a getter's result must fail.
+	_, _, err = l.WalkTarget(x, func(ctx l.TargetContext, x l.Target) (d l.TargetDecision) {
+		if x.Value() == "One" {
+			d = d.Replace(&l.ByRefType{Val: "Replaced"})
+		}
+		return
+	})
+	if a.Error(err) {
+		a.Contains(err.Error(), "cannot replace values within EncapsulatedType.Children()")
+	}
+	a.Equal("One", enc.Children()[0].Value())
+}
+
+// TestConcrete ensures that a field of a broader type is visited when
+// it holds one of the concrete types declared by its walkabout tag.
+func TestConcrete(t *testing.T) {
+	a := assert.New(t)
+	var visited []string
+	collect := func(ctx l.TargetContext, x l.Target) l.TargetDecision {
+		visited = append(visited, x.Value())
+		return ctx.Continue()
+	}
+
+	for _, child := range []interface{}{&l.ByRefType{Val: "Ref"}, l.ByValType{Val: "Val"}, &l.ByValType{Val: "ValPtr"}} {
+		visited = nil
+		_, changed, err := l.WalkTarget(&l.LooseType{Child: child}, collect)
+		a.NoError(err)
+		a.False(changed)
+		a.Equal([]string{"Loose", child.(l.Target).Value()}, visited)
+	}
+
+	// Other values are ignored.
+	visited = nil
+	_, _, err := l.WalkTarget(&l.LooseType{Child: "Hello"}, collect)
+	a.NoError(err)
+	a.Equal([]string{"Loose"}, visited)
+
+	// The child may be replaced by any of the concrete types.
+	x := &l.LooseType{Child: &l.ByRefType{Val: "Ref"}}
+	y, changed, err := l.WalkTarget(x, func(ctx l.TargetContext, x l.Target) l.TargetDecision {
+		if _, ok := x.(*l.ByRefType); ok {
+			return ctx.Continue().Replace(l.ByValType{Val: "Replaced"})
+		}
+		return ctx.Continue()
+	})
+	a.NoError(err)
+	a.True(changed)
+	a.Equal("Replaced", y.(*l.LooseType).Child.(*l.ByValType).Val)
+	a.Equal("Ref", x.Child.(*l.ByRefType).Val)
+
+	_, _, err = l.WalkTarget(x, func(ctx l.TargetContext, x l.Target) l.TargetDecision {
+		if _, ok := x.(*l.ByRefType); ok {
+			return ctx.Continue().Replace(&l.ContainerType{})
+		}
+		return ctx.Continue()
+	})
+	a.EqualError(err, "type ContainerType is unknown or not assignable to interface{}")
+}
+
+func TestMaps(t *testing.T) {
+	a := assert.New(t)
+	inner := &l.ByRefType{Val: "e"}
+	x := &l.ScopeType{Env: map[string]l.Target{
+		"c": &l.ByRefType{Val: "c"},
+		"a": l.ByValType{Val: "a"},
+		"b": nil,
+		"d": &l.ScopeType{Env: map[string]l.Target{"e": inner}},
+	}}
+	env := x.Env
+
+	// Entries are visited in key order.
+	var visited []string
+	_, changed, err := l.WalkTarget(x, func(ctx l.TargetContext, x l.Target) l.TargetDecision {
+		visited = append(visited, x.Value())
+		return ctx.Continue()
+	})
+	a.NoError(err)
+	a.False(changed)
+	a.Equal([]string{"Scope", "a", "c", "Scope", "e"}, visited)
+
+	// Replacing an entry constructs a new map.
+	ret, changed, err := l.WalkTarget(x, func(ctx l.TargetContext, x l.Target) (d l.TargetDecision) {
+		if t, ok := x.(*l.ByRefType); ok {
+			d = d.Replace(&l.ByRefType{Val: strings.ToUpper(t.Val)})
+		}
+		return
+	})
+	if a.NoError(err) && a.True(changed) {
+		y := ret.(*l.ScopeType)
+		a.Len(y.Env, 4)
+		a.Equal("C", y.Env["c"].Value())
+		a.Equal("E", y.Env["d"].(*l.ScopeType).Env["e"].Value())
+		a.Equal(l.ByValType{Val: "a"}, y.Env["a"])
+		a.Nil(y.Env["b"])
+		a.Contains(y.Env, "b")
+	}
+	a.Equal("c", env["c"].Value())
+	a.True(inner == env["d"].(*l.ScopeType).Env["e"])
+
+	// Every value within a changed map is visited.
+	visited = nil
+	_, _, err = l.WalkTargetChanged(x, ret, func(ctx l.TargetContext, x l.Target) l.TargetDecision {
+		visited = append(visited, x.Value())
+		return ctx.Continue()
+	})
+	a.NoError(err)
+	a.Equal([]string{"Scope", "a", "C", "Scope", "E"}, visited)
+
+	// Values which are pointed to by entries may be replaced in place,
+	// but the entries themselves are copies.
+	_, _, err = l.WalkTarget(x, func(ctx l.TargetContext, x l.Target) (d l.TargetDecision) {
+		if x.Value() == "a" {
+			d = d.ReplaceInPlace(l.ByValType{Val: "A"})
+		}
+		return
+	})
+	if a.Error(err) {
+		a.Contains(err.Error(), "not stored in mutable memory")
+	}
+	_, _, err = l.WalkTarget(x, func(ctx l.TargetContext, x l.Target) (d l.TargetDecision) {
+		if x == l.Target(inner) {
+			d = d.ReplaceInPlace(&l.ByRefType{Val: "E"})
+		}
+		return
+	})
+	a.NoError(err)
+	a.Equal("E", inner.Val)
+
+	// Paths refer to entries by their key.
+	x.Env["d"].(*l.ScopeType).Env = nil
+	if err := l.CheckTargetInvariants(x); a.Error(err) {
+		a.Equal(`invariants violated: Env["d"].Env (nonempty)`, err.Error())
+	}
+
+	// The abstract accessors expose the entries in the same order.
+	if entries, ok := x.TargetAt(0).(l.TargetAbstract); a.True(ok) {
+		a.Equal(l.TargetTypeTargetMapByString, entries.TargetTypeID())
+		a.Equal(4, entries.TargetCount())
+		a.Nil(entries.TargetAt(1))
+		a.Equal(x.Env["c"], entries.TargetAt(2))
+	}
+
+	// Maps survive a round-trip through the codec.
+	var buf bytes.Buffer
+	if a.NoError(l.EncodeTarget(&buf, x)) {
+		decoded, err := l.DecodeTarget(&buf)
+		a.NoError(err)
+		a.Equal(l.Target(x), decoded)
+	}
+}
+
+// TestMapValues exercises the keyed accessors which are generated for
+// map-valued fields.
+func TestMapValues(t *testing.T) {
+	a := assert.New(t)
+	x := &l.ContainerType{TargetSlice: []l.Target{&l.ScopeType{Env: map[string]l.Target{
+		"a": &l.ByRefType{Val: "a"},
+		"b": &l.ByRefType{Val: "b"},
+	}}}}
+	scope := x.TargetSlice[0].(*l.ScopeType)
+
+	v, ok := scope.EnvValue("a")
+	a.True(ok)
+	a.Equal("a", v.Value())
+	_, ok = scope.EnvValue("c")
+	a.False(ok)
+
+	// Replacing a single entry copies the map.
+	y := scope.WithEnvValue("c", &l.ByRefType{Val: "c"})
+	a.Len(y.Env, 3)
+	a.True(y.Env["a"] == scope.Env["a"])
+	a.NotContains(scope.Env, "c")
+
+	// The builder may be used to replace a single entry during a walk.
+	ret, changed, err := l.WalkTarget(x, func(ctx l.TargetContext, x l.Target) l.TargetDecision {
+		if s, ok := x.(*l.ScopeType); ok {
+			return ctx.Skip().Replace(s.WithEnvValue("b", &l.ByRefType{Val: "B"}))
+		}
+		return ctx.Continue()
+	})
+	if a.NoError(err) && a.True(changed) {
+		env := ret.(*l.ContainerType).TargetSlice[0].(*l.ScopeType).Env
+		a.Equal("B", env["b"].Value())
+		a.True(env["a"] == scope.Env["a"])
+	}
+	a.Equal("b", scope.Env["b"].Value())
 }
 
 // TestInterfaceChange ensures that an interface context allows the
@@ -187,6 +1348,68 @@ func TestInterfaceChange(t *testing.T) {
 		})
 		a.EqualError(err, "type ByRefType is unknown or not assignable to EmbedsTarget")
 	})
+	t.Run("interface slice", func(t *testing.T) {
+		a := assert.New(t)
+
+		c := &l.ContainerType{
+			TargetSlice: []l.Target{l.ByValType{Val: "ChangeMe"}, &l.ByRefType{Val: "Keep"}},
+		}
+		c2, changed, err := c.WalkTarget(func(ctx l.TargetContext, x l.Target) (d l.TargetDecision) {
+			if x.Value() == "ChangeMe" {
+				d = d.Replace(&l.ByRefType{Val: "Changed"})
+			}
+			return
+		})
+		if !a.NoError(err) {
+			return
+		}
+		a.True(changed)
+		a.Equal([]l.Target{&l.ByRefType{Val: "Changed"}, &l.ByRefType{Val: "Keep"}}, c2.TargetSlice)
+		a.IsType(l.ByValType{}, c.TargetSlice[0], "original should not have changed")
+	})
+	t.Run("concrete slice", func(t *testing.T) {
+		a := assert.New(t)
+
+		// Both types implement Target, but a []*ByRefType can only hold
+		// a *ByRefType.
+		c := &l.ContainerType{
+			ByRefPtrSlice: []*l.ByRefType{{Val: "ChangeMe"}},
+		}
+		_, _, err := c.WalkTarget(func(ctx l.TargetContext, x l.Target) (d l.TargetDecision) {
+			if x.Value() == "ChangeMe" {
+				d = d.Replace(&l.ByValType{Val: "Not a ByRefType"})
+			}
+			return
+		})
+		a.EqualError(err, "cannot change type of ByRefType to ByValType")
+		a.Equal("ChangeMe", c.ByRefPtrSlice[0].Val)
+	})
+	t.Run("test morph", func(t *testing.T) {
+		a := assert.New(t)
+
+		d := &l.ByValType{}
+		ret, changed, err := d.WalkTargetMorph(func(ctx l.TargetContext, x l.Target) l.TargetDecision {
+			return ctx.Continue().Replace(&l.ByRefType{Val: "Morphed"})
+		})
+		if !a.NoError(err) {
+			return
+		}
+		a.True(changed, "should have changed")
+		a.Equal(&l.ByRefType{Val: "Morphed"}, ret)
+	})
+	t.Run("test morph unchanged", func(t *testing.T) {
+		a := assert.New(t)
+
+		d, _ := l.NewContainer(true)
+		ret, changed, err := d.WalkTargetMorph(func(ctx l.TargetContext, x l.Target) (d l.TargetDecision) {
+			return
+		})
+		if !a.NoError(err) {
+			return
+		}
+		a.False(changed)
+		a.True(ret == l.Target(d))
+	})
 	t.Run("unknown type", func(t *testing.T) {
 		a := assert.New(t)
 		a.PanicsWithValue("unhandled value of type: *other.Implementor", func() {
@@ -195,6 +1418,43 @@ func TestInterfaceChange(t *testing.T) {
 				return ctx.Continue().Replace(&other.Implementor{})
 			})
 		})
+		a.PanicsWithValue("unhandled value of type: *other.Implementor", func() {
+			_, _, _ = l.WalkTarget(&other.Implementor{}, func(ctx l.TargetContext, x l.Target) l.TargetDecision {
+				return ctx.Continue()
+			})
+		})
+	})
+}
+
+// TestNilRoots ensures that nil values passed to the generated entry
+// points are ignored instead of panicking.
+func TestNilRoots(t *testing.T) {
+	fn := func(ctx l.TargetContext, x l.Target) l.TargetDecision {
+		panic("should not be called")
+	}
+
+	t.Run("nil interface", func(t *testing.T) {
+		a := assert.New(t)
+		ret, changed, err := l.WalkTarget(nil, fn)
+		a.NoError(err)
+		a.False(changed)
+		a.Nil(ret)
+	})
+	t.Run("typed nil", func(t *testing.T) {
+		a := assert.New(t)
+		var c *l.ContainerType
+		ret, changed, err := l.WalkTarget(c, fn)
+		a.NoError(err)
+		a.False(changed)
+		a.Equal(l.Target(c), ret)
+	})
+	t.Run("nil receiver", func(t *testing.T) {
+		a := assert.New(t)
+		var c *l.ContainerType
+		ret, changed, err := c.WalkTarget(fn)
+		a.NoError(err)
+		a.False(changed)
+		a.Nil(ret)
 	})
 }
 
@@ -214,6 +1474,117 @@ func TestMutations(t *testing.T) {
 	})
 }
 
+// TestParallel verifies that visiting the children of each struct
+// concurrently yields the same result as visiting them serially.
+func TestParallel(t *testing.T) {
+	visitor := func(parallel bool, visits *int32) l.TargetWalkerFn {
+		return func(ctx l.TargetContext, x l.Target) (d l.TargetDecision) {
+			atomic.AddInt32(visits, 1)
+			if parallel {
+				d = ctx.Parallel()
+			}
+			switch t := x.(type) {
+			case *l.ByRefType:
+				cp := *t
+				cp.Val = reverse(cp.Val)
+				d = d.Replace(&cp)
+			case *l.ByValType:
+				cp := *t
+				cp.Val = reverse(cp.Val)
+				d = d.Replace(&cp)
+			}
+			return
+		}
+	}
+
+	for _, useValuePtrs := range []bool{false, true} {
+		t.Run(fmt.Sprintf("useValuePtrs=%t", useValuePtrs), func(t *testing.T) {
+			a := assert.New(t)
+			x, _ := l.NewContainer(useValuePtrs)
+			x.TargetSlice = append(x.TargetSlice, &l.ScopeType{Env: map[string]l.Target{
+				"a": &l.ByRefType{Val: "a"},
+				"b": l.ByValType{Val: "b"},
+			}})
+
+			var serialVisits, parallelVisits int32
+			expected, changed, err := x.WalkTarget(visitor(false, &serialVisits))
+			a.NoError(err)
+			a.True(changed)
+
+			actual, changed, err := x.WalkTarget(visitor(true, &parallelVisits))
+			a.NoError(err)
+			a.True(changed)
+			a.Equal(expected, actual)
+			a.Equal(serialVisits, parallelVisits)
+		})
+	}
+
+	t.Run("bounded", func(t *testing.T) {
+		a := assert.New(t)
+		defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(2))
+		x := &l.ContainerType{}
+		for i := 0; i < 64; i++ {
+			x.ByRefSlice = append(x.ByRefSlice, l.ByRefType{Val: fmt.Sprint(i)})
+		}
+
+		// The goroutine which starts the walk may also visit values.
+		var active, peak int32
+		_, _, err := x.WalkTarget(func(ctx l.TargetContext, x l.Target) l.TargetDecision {
+			n := atomic.AddInt32(&active, 1)
+			defer atomic.AddInt32(&active, -1)
+			for {
+				p := atomic.LoadInt32(&peak)
+				if n <= p || atomic.CompareAndSwapInt32(&peak, p, n) {
+					break
+				}
+			}
+			time.Sleep(time.Millisecond)
+			return ctx.Parallel()
+		})
+		a.NoError(err)
+		a.True(peak > 1)
+		a.True(peak <= 3, "peak concurrency %d", peak)
+	})
+
+	t.Run("cycle", func(t *testing.T) {
+		a := assert.New(t)
+		x, _ := l.NewContainer(false)
+		x.Container = x
+		var visits int32
+		cycles, err := l.WalkTargetDetectCycles(x, visitor(true, &visits))
+		a.NoError(err)
+		if a.Len(cycles, 1) {
+			a.True(cycles[0].Value == l.Target(x))
+		}
+	})
+
+	t.Run("halt", func(t *testing.T) {
+		a := assert.New(t)
+		x, _ := l.NewContainer(false)
+		ret, changed, err := x.WalkTarget(func(ctx l.TargetContext, x l.Target) l.TargetDecision {
+			if t, ok := x.(*l.ByRefType); ok {
+				return ctx.Halt().Replace(&l.ByRefType{Val: reverse(t.Val)})
+			}
+			return ctx.Parallel()
+		})
+		a.NoError(err)
+		a.True(changed)
+		a.Equal("Hello", ret.ByRef.Val)
+	})
+
+	t.Run("error", func(t *testing.T) {
+		a := assert.New(t)
+		x, _ := l.NewContainer(false)
+		_, _, err := x.WalkTarget(func(ctx l.TargetContext, x l.Target) l.TargetDecision {
+			if _, ok := x.(*l.ByValType); ok {
+				return ctx.Error(errors.New("expected"))
+			}
+			return ctx.Parallel()
+		})
+		a.EqualError(err, "expected")
+	})
+}
+
 // Ensure that if Replace() is called from a Post() callback, we discard
 // any previously-existing field values.
 func TestPostReplaceIgnoresOldValues(t *testing.T) {
@@ -236,6 +1607,54 @@ func TestPostReplaceIgnoresOldValues(t *testing.T) {
 	a.Nil(d2.ByRefPtr)
 }
 
+// TestPostSeesReplacedChildren verifies that a post-visit function
+// receives the rebuilt value once its children have been replaced, and
+// the original value otherwise.
+func TestPostSeesReplacedChildren(t *testing.T) {
+	a := assert.New(t)
+	x := &l.ContainerType{
+		ByRef:    l.ByRefType{Val: "abc"},
+		ByRefPtr: &l.ByRefType{Val: "def"},
+	}
+
+	var seen *l.ContainerType
+	y, changed, err := l.WalkTarget(x, func(ctx l.TargetContext, x l.Target) l.TargetDecision {
+		switch t := x.(type) {
+		case *l.ByRefType:
+			return ctx.Continue().Replace(&l.ByRefType{Val: reverse(t.Val)})
+		case *l.ContainerType:
+			return ctx.Continue().Post(func(ctx l.TargetContext, x l.Target) l.TargetDecision {
+				seen = x.(*l.ContainerType)
+				return ctx.Continue()
+			})
+		}
+		return ctx.Continue()
+	})
+	a.NoError(err)
+	a.True(changed)
+	if a.NotNil(seen) {
+		a.True(seen == y)
+		a.False(seen == x)
+		a.Equal("cba", seen.ByRef.Val)
+		a.Equal("fed", seen.ByRefPtr.Val)
+	}
+	a.Equal("abc", x.ByRef.Val)
+
+	// A clean value is passed through as-is.
+	seen = nil
+	_, changed, err = l.WalkTarget(x, func(ctx l.TargetContext, x l.Target) l.TargetDecision {
+		return ctx.Continue().Post(func(ctx l.TargetContext, x l.Target) l.TargetDecision {
+			if c, ok := x.(*l.ContainerType); ok {
+				seen = c
+			}
+			return ctx.Continue()
+		})
+	})
+	a.NoError(err)
+	a.False(changed)
+	a.True(seen == x)
+}
+
 func abstractWalk(x l.TargetAbstract) {
 	if x == nil {
 		return
@@ -245,6 +1664,26 @@ func abstractWalk(x l.TargetAbstract) {
 	}
 }
 
+// panicMessage returns the message of the value with which fn panics.
+func panicMessage(fn func()) (msg string) {
+	defer func() { msg = fmt.Sprint(recover()) }()
+	fn()
+	return
+}
+
+// countAbstract returns the number of non-nil values reachable from
+// x, including x itself.
+func countAbstract(x l.TargetAbstract) int {
+	if x == nil {
+		return 0
+	}
+	ret := 1
+	for i, j := 0, x.TargetCount(); i < j; i++ {
+		ret += countAbstract(x.TargetAt(i))
+	}
+	return ret
+}
+
 func checkMutations(t *testing.T, x *l.ContainerType, count int) {
 	t.Helper()
 	a := assert.New(t)
@@ -309,3 +1748,32 @@ func reverse(s string) string {
 
 	return string(runes)
 }
+
+// Stacks are reused between walks, so a walk which is abandoned
+// part-way through must not affect the walks which follow it.
+func TestStackReuse(t *testing.T) {
+	a := assert.New(t)
+	x, _ := l.NewContainer(false)
+
+	walk := func() []string {
+		var paths []string
+		_, _, err := l.WalkTarget(x, func(ctx l.TargetContext, x l.Target) l.TargetDecision {
+			paths = append(paths, ctx.Path().String())
+			return ctx.Continue()
+		})
+		a.NoError(err)
+		return paths
+	}
+	expected := walk()
+
+	for i := 0; i < 10; i++ {
+		_, _, err := l.WalkTarget(x, func(ctx l.TargetContext, x l.Target) l.TargetDecision {
+			if _, ok := x.(*l.ByRefType); ok {
+				return ctx.Error(errors.New("abandoned"))
+			}
+			return ctx.Continue()
+		})
+		a.EqualError(err, "abandoned")
+		a.Equal(expected, walk())
+	}
+}
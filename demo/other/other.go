@@ -18,9 +18,11 @@
 package other
 
 // Reachable is reachable from our Container type, but we can't
-// do anything to make it implement a common interface (unless
-// we want the generator to start writing into multiple output
-// directories in a single pass, which seems fraught).
+// do anything to make it implement a common interface. Writing the
+// marker methods into this package wouldn't help: the union interface
+// requires an unexported is...Type() method, which only types in the
+// generated package can implement, and its abstract accessors return
+// types from that package, which already imports this one.
 type Reachable struct{}
 
 // Implementor implements demo.Target, but since it's in another
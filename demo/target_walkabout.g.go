@@ -4,7 +4,14 @@
 package demo
 
 import (
+	"context"
 	"fmt"
+	"io"
+	"reflect"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
 	"unsafe"
 
 	e "github.com/cockroachdb/walkabout/engine"
@@ -15,6 +22,23 @@ import (
 // TargetTypeID is a lightweight type token.
 type TargetTypeID e.TypeID
 
+// TargetImplementors returns the type tokens of all struct types
+// which implement Target. The returned slice may be modified by
+// the caller.
+func TargetImplementors() []TargetTypeID {
+	return []TargetTypeID{
+		TargetTypeByRefType,
+		TargetTypeByValType,
+		TargetTypeContainerType,
+		TargetTypeEmbeddingType,
+		TargetTypeEncapsulatedType,
+		TargetTypeLooseType,
+		TargetTypePairType,
+		TargetTypeScopeType,
+		TargetTypeWrapperType,
+	}
+}
+
 // TargetAbstract allows users to treat a Target as an abstract
 // tree of nodes. All visitable struct types will have generated methods
 // which implement this interface.
@@ -26,17 +50,42 @@ type TargetAbstract interface {
 	// is non-nil. If the child is a slice type, a TargetAbstract wrapper
 	// around the slice will be returned.
 	TargetAt(index int) TargetAbstract
+	// TargetNamed returns the named field of a struct, following
+	// the same rules as TargetAt. It returns nil if the value is
+	// not a struct or has no visitable field of that name. Since the
+	// fields are scanned linearly, it should not be used in hot loops.
+	TargetNamed(name string) TargetAbstract
+	// TargetEach invokes fn with each child, following the same
+	// rules as TargetAt, until fn returns false. The children of
+	// slices and arrays are presented through a reused wrapper, so
+	// the child must not be retained after fn returns.
+	TargetEach(fn func(index int, child TargetAbstract) bool)
+	// TargetFieldNameAt returns a label for the child at the given
+	// index: the name of a struct field, the name of a getter followed
+	// by "()", the bracketed index of a slice element, or the bracketed
+	// key of a map entry. It panics if the index is out of range.
+	TargetFieldNameAt(index int) string
 	// TargetCount returns the number of visitable fields in a struct,
 	// or the length of a slice.
 	TargetCount() int
 	// TargetTypeID returns a type token.
 	TargetTypeID() TargetTypeID
+	// TargetWalk visits the value with the provided callback and
+	// returns a TargetAbstract around the updated value. The updated
+	// value will always be of the same type as the original value.
+	TargetWalk(fn TargetWalkerFn) (_ TargetAbstract, changed bool, err error)
 }
 
 var (
 	_ TargetAbstract = &ByRefType{}
 	_ TargetAbstract = &ByValType{}
 	_ TargetAbstract = &ContainerType{}
+	_ TargetAbstract = &EmbeddingType{}
+	_ TargetAbstract = &EncapsulatedType{}
+	_ TargetAbstract = &LooseType{}
+	_ TargetAbstract = &PairType{}
+	_ TargetAbstract = &ScopeType{}
+	_ TargetAbstract = &WrapperType{}
 )
 
 // TargetWalkerFn is used to implement a visitor pattern over
@@ -80,6 +129,13 @@ func (c *TargetContext) Continue() TargetDecision {
 	return TargetDecision(c.impl.Continue())
 }
 
+// Depth returns the number of visitable structs which enclose the
+// value being visited. The value passed to a Walk function has a
+// depth of zero.
+func (c *TargetContext) Depth() int {
+	return c.impl.Depth()
+}
+
 // Error returns a TargetDecision which will cause the given error
 // to be returned from the Walk() function. Post-visit functions
 // will not be called.
@@ -93,11 +149,83 @@ func (c *TargetContext) Halt() TargetDecision {
 	return TargetDecision(c.impl.Halt())
 }
 
+// Parallel will visit the fields of the current object concurrently,
+// each on its own goroutine. A field which is a slice or array will
+// have its elements visited concurrently as well. The visitor may
+// therefore be called from multiple goroutines at once, and the order
+// of any side-effects is not guaranteed. No more than GOMAXPROCS
+// goroutines are forked by a single walk; once they are all busy, the
+// remaining values are visited without forking. Halting or restarting while
+// visiting one field will not affect the visitation of the others.
+// Replacements are applied once all of the fields have been visited.
+// Parallel is ignored by WalkTargetMemo and WalkTargetOnce.
+func (c *TargetContext) Parallel() TargetDecision {
+	return TargetDecision(c.impl.Parallel())
+}
+
+// Parent returns the nearest visitable struct which encloses the value
+// being visited. It returns false for the value passed to a Walk
+// function. Since the enclosing values are only rebuilt once all of
+// their children have been visited, this is always the original
+// parent, without any replacements made by the current visitation.
+func (c *TargetContext) Parent() (Target, bool) {
+	id, ptr := c.impl.Parent()
+	if id == 0 {
+		return nil, false
+	}
+	return targetWrap(id, ptr), true
+}
+
+// Path returns the location of the value being visited, relative to
+// the value passed to the function which started the visitation. The
+// path is constructed on demand.
+func (c *TargetContext) Path() TargetPath {
+	return c.impl.Path()
+}
+
+// ReplaceChildren will perform the given actions in place of visiting
+// the elements of a slice and will then replace the elements with the
+// values visited by the actions. This allows elements to be inserted
+// or removed. It may only be returned for a struct whose only
+// visitable field is a slice; any other struct will cause the walk
+// to return an error. Actions which invoke a callback do not
+// contribute an element, and an empty list of actions will leave the
+// slice empty.
+func (c *TargetContext) ReplaceChildren(actions ...TargetAction) TargetDecision {
+	ret := make([]e.Action, len(actions))
+	for i, a := range actions {
+		ret[i] = e.Action(a)
+	}
+
+	return TargetDecision(c.impl.ReplaceChildren(ret))
+}
+
 // Skip will not traverse the fields of the current object.
 func (c *TargetContext) Skip() TargetDecision {
 	return TargetDecision(c.impl.Skip())
 }
 
+// State returns the value passed to WalkTargetState or
+// WalkTargetWith, or nil if the visitation was started by another
+// function.
+func (c *TargetContext) State() interface{} {
+	return c.impl.State()
+}
+
+// Value returns the value passed to WalkTargetWith. It is an
+// alias for State.
+func (c *TargetContext) Value() interface{} {
+	return c.impl.State()
+}
+
+// TargetPath describes the location of a value, relative to the root
+// of a visitation. Pointers and interfaces do not contribute segments.
+type TargetPath = e.Path
+
+// TargetPathSegment describes a step from a value to a struct field,
+// a slice or array element, or a map entry.
+type TargetPathSegment = e.PathSegment
+
 // TargetDecision is used by TargetWalkerFn to control visitation.
 // The TargetContext provided to a TargetWalkerFn acts as a factory
 // for TargetDecision instances. In general, the factory methods
@@ -111,9 +239,19 @@ func (d TargetDecision) Intercept(fn TargetWalkerFn) TargetDecision {
 	return TargetDecision((e.Decision)(d).Intercept(fn))
 }
 
+// InterceptNamed is like Intercept, except that the function also
+// receives the location of each value relative to the current value,
+// e.g. "Args[1]" for an element of a slice-valued field.
+func (d TargetDecision) InterceptNamed(fn func(ctx TargetContext, name string, x Target) TargetDecision) TargetDecision {
+	return TargetDecision((e.Decision)(d).InterceptNamed(TargetWalkerFn(func(ctx TargetContext, x Target) TargetDecision {
+		return fn(ctx, ctx.impl.Intercepted().String(), x)
+	})))
+}
+
 // Post registers a post-visit function, which will be called after the
-// fields of the current object. The function can make another decision
-// about the current value.
+// fields of the current object. The function will see any replacements
+// made to the fields, and it can make another decision about the
+// current value.
 func (d TargetDecision) Post(fn TargetWalkerFn) TargetDecision {
 	return TargetDecision((e.Decision)(d).Post(fn))
 }
@@ -124,51 +262,125 @@ func (d TargetDecision) Replace(x Target) TargetDecision {
 	return TargetDecision((e.Decision)(d).Replace(targetIdentify(x)))
 }
 
+// ReplaceInPlace overwrites the currently-visited value with x, which
+// must be of the same type. Unlike Replace, parent nodes are not cloned,
+// so the change will be visible to every holder of the value. An error
+// will be returned if the value is not stored in mutable memory, such
+// as when it is held by value in an interface or returned by a getter.
+func (d TargetDecision) ReplaceInPlace(x Target) TargetDecision {
+	return TargetDecision((e.Decision)(d).ReplaceInPlace(targetIdentify(x)))
+}
+
+// ReplaceWith replaces the slice element which holds the current value
+// with any number of values, which must not be nil. Providing no values
+// removes the element. The fields of the current value, and of the
+// replacements, will not be visited. The walk will return an error
+// unless the current value is an element of a slice, or is referred to
+// by one through pointers or interfaces.
+func (d TargetDecision) ReplaceWith(xs ...Target) TargetDecision {
+	ids := make([]e.TypeID, len(xs))
+	ptrs := make([]e.Ptr, len(xs))
+	for i, x := range xs {
+		ids[i], ptrs[i] = targetIdentify(x)
+	}
+	return TargetDecision((e.Decision)(d).ReplaceWith(ids, ptrs))
+}
+
+// Restart may be combined with Replace to abandon the visitation once
+// the value has been replaced and to visit the updated top-level value
+// again from the beginning. This is useful when a replacement
+// invalidates decisions which were made about enclosing values. Pending
+// post-visit functions will not be called. Restart has no effect unless
+// the value is replaced, or if the visitation is halted. An error will
+// be returned if a visitation is restarted more than 1000 times.
+func (d TargetDecision) Restart() TargetDecision {
+	return TargetDecision((e.Decision)(d).Restart())
+}
+
 // targetIdentify is a utility function to map a Target into
-// its generated type id and a pointer to the data.
+// its generated type id and a pointer to the data. The type is found
+// in a table, rather than by a type switch, so the cost does not grow
+// with the number of implementations.
 func targetIdentify(x Target) (typeId e.TypeID, data e.Ptr) {
-	switch t := x.(type) {
-	case *ByRefType:
-		typeId = e.TypeID(TargetTypeByRefType)
-		data = e.Ptr(t)
-	case ByValType:
-		typeId = e.TypeID(TargetTypeByValType)
-		data = e.Ptr(&t)
-	case *ByValType:
-		typeId = e.TypeID(TargetTypeByValType)
-		data = e.Ptr(t)
-	case *ContainerType:
-		typeId = e.TypeID(TargetTypeContainerType)
-		data = e.Ptr(t)
-	default:
+	fn, ok := targetIdentifiers[reflect.TypeOf(x)]
+	if !ok {
 		// The most probable reason for this is that the generated code
 		// is out of date, or that an implementation of the Target
 		// interface from another package is being passed in.
 		panic(fmt.Sprintf("unhandled value of type: %T", x))
 	}
-	return
+	return fn(x)
+}
+
+// targetIdentifiers maps the dynamic type of a Target to a
+// function which returns its type token and a pointer to its data.
+var targetIdentifiers = map[reflect.Type]func(x Target) (e.TypeID, e.Ptr){
+	reflect.TypeOf((*ByRefType)(nil)): func(x Target) (e.TypeID, e.Ptr) {
+		return e.TypeID(TargetTypeByRefType), e.Ptr(x.(*ByRefType))
+	},
+	reflect.TypeOf((*ByValType)(nil)).Elem(): func(x Target) (e.TypeID, e.Ptr) {
+		t := x.(ByValType)
+		return e.TypeID(TargetTypeByValType), e.Ptr(&t)
+	},
+	reflect.TypeOf((*ByValType)(nil)): func(x Target) (e.TypeID, e.Ptr) {
+		return e.TypeID(TargetTypeByValType), e.Ptr(x.(*ByValType))
+	},
+	reflect.TypeOf((*ContainerType)(nil)): func(x Target) (e.TypeID, e.Ptr) {
+		return e.TypeID(TargetTypeContainerType), e.Ptr(x.(*ContainerType))
+	},
+	reflect.TypeOf((*EmbeddingType)(nil)): func(x Target) (e.TypeID, e.Ptr) {
+		return e.TypeID(TargetTypeEmbeddingType), e.Ptr(x.(*EmbeddingType))
+	},
+	reflect.TypeOf((*EncapsulatedType)(nil)): func(x Target) (e.TypeID, e.Ptr) {
+		return e.TypeID(TargetTypeEncapsulatedType), e.Ptr(x.(*EncapsulatedType))
+	},
+	reflect.TypeOf((*LooseType)(nil)): func(x Target) (e.TypeID, e.Ptr) {
+		return e.TypeID(TargetTypeLooseType), e.Ptr(x.(*LooseType))
+	},
+	reflect.TypeOf((*PairType)(nil)): func(x Target) (e.TypeID, e.Ptr) {
+		return e.TypeID(TargetTypePairType), e.Ptr(x.(*PairType))
+	},
+	reflect.TypeOf((*ScopeType)(nil)): func(x Target) (e.TypeID, e.Ptr) {
+		return e.TypeID(TargetTypeScopeType), e.Ptr(x.(*ScopeType))
+	},
+	reflect.TypeOf((*WrapperType)(nil)): func(x Target) (e.TypeID, e.Ptr) {
+		return e.TypeID(TargetTypeWrapperType), e.Ptr(x.(*WrapperType))
+	},
 }
 
 // targetWrap is a utility function to reconstitute a Target
 // from an internal type token and a pointer to the value.
 func targetWrap(typeId e.TypeID, x e.Ptr) Target {
-	switch TargetTypeID(typeId) {
-	case TargetTypeByRefType:
-		return (*ByRefType)(x)
-	case TargetTypeByRefTypePtr:
-		return *(**ByRefType)(x)
-	case TargetTypeByValType:
-		return (*ByValType)(x)
-	case TargetTypeByValTypePtr:
-		return *(**ByValType)(x)
-	case TargetTypeContainerType:
-		return (*ContainerType)(x)
-	case TargetTypeContainerTypePtr:
-		return *(**ContainerType)(x)
-	default:
-		// This is likely a code-generation problem.
-		panic(fmt.Sprintf("unhandled TypeID %d", typeId))
+	if typeId >= 0 && int(typeId) < len(targetWrappers) {
+		if fn := targetWrappers[typeId]; fn != nil {
+			return fn(x)
+		}
 	}
+	// This is likely a code-generation problem.
+	panic(fmt.Sprintf("unhandled TypeID %d", typeId))
+}
+
+// targetWrappers holds a function for each type token which
+// reconstitutes a Target from a pointer to the value.
+var targetWrappers = []func(x e.Ptr) Target{
+	TargetTypeByRefType:           func(x e.Ptr) Target { return (*ByRefType)(x) },
+	TargetTypeByRefTypePtr:        func(x e.Ptr) Target { return *(**ByRefType)(x) },
+	TargetTypeByValType:           func(x e.Ptr) Target { return (*ByValType)(x) },
+	TargetTypeByValTypePtr:        func(x e.Ptr) Target { return *(**ByValType)(x) },
+	TargetTypeContainerType:       func(x e.Ptr) Target { return (*ContainerType)(x) },
+	TargetTypeContainerTypePtr:    func(x e.Ptr) Target { return *(**ContainerType)(x) },
+	TargetTypeEmbeddingType:       func(x e.Ptr) Target { return (*EmbeddingType)(x) },
+	TargetTypeEmbeddingTypePtr:    func(x e.Ptr) Target { return *(**EmbeddingType)(x) },
+	TargetTypeEncapsulatedType:    func(x e.Ptr) Target { return (*EncapsulatedType)(x) },
+	TargetTypeEncapsulatedTypePtr: func(x e.Ptr) Target { return *(**EncapsulatedType)(x) },
+	TargetTypeLooseType:           func(x e.Ptr) Target { return (*LooseType)(x) },
+	TargetTypeLooseTypePtr:        func(x e.Ptr) Target { return *(**LooseType)(x) },
+	TargetTypePairType:            func(x e.Ptr) Target { return (*PairType)(x) },
+	TargetTypePairTypePtr:         func(x e.Ptr) Target { return *(**PairType)(x) },
+	TargetTypeScopeType:           func(x e.Ptr) Target { return (*ScopeType)(x) },
+	TargetTypeScopeTypePtr:        func(x e.Ptr) Target { return *(**ScopeType)(x) },
+	TargetTypeWrapperType:         func(x e.Ptr) Target { return (*WrapperType)(x) },
+	TargetTypeWrapperTypePtr:      func(x e.Ptr) Target { return *(**WrapperType)(x) },
 }
 
 // TargetAction is used by TargetContext.Actions() and allows users
@@ -185,6 +397,188 @@ func (c *TargetContext) ActionCall(fn func() error) TargetAction {
 	return TargetAction(c.impl.ActionCall(fn))
 }
 
+// ------ Cloning ------
+
+// CloneTarget returns a deep copy of the receiver, which will not
+// share any visitable memory with it, except for back-references which
+// form cycles and values registered with SetTargetInterned.
+// Non-visitable fields are copied shallowly. A nil receiver returns nil.
+func (x *ByRefType) CloneTarget() *ByRefType {
+	if x == nil {
+		return nil
+	}
+	fn := TargetWalkerFn(func(ctx TargetContext, _ Target) TargetDecision {
+		return ctx.Continue()
+	})
+	_, y, _, err := targetEngine.Execute(fn, e.TypeID(TargetTypeByRefType), e.Ptr(x), e.TypeID(TargetTypeByRefType), e.WithRebuild())
+	if err != nil {
+		// The callback makes no changes, so this indicates a problem
+		// with code-generation.
+		panic(err)
+	}
+	return (*ByRefType)(y)
+}
+
+// CloneTarget returns a deep copy of the receiver, which will not
+// share any visitable memory with it, except for back-references which
+// form cycles and values registered with SetTargetInterned.
+// Non-visitable fields are copied shallowly. A nil receiver returns nil.
+func (x *ByValType) CloneTarget() *ByValType {
+	if x == nil {
+		return nil
+	}
+	fn := TargetWalkerFn(func(ctx TargetContext, _ Target) TargetDecision {
+		return ctx.Continue()
+	})
+	_, y, _, err := targetEngine.Execute(fn, e.TypeID(TargetTypeByValType), e.Ptr(x), e.TypeID(TargetTypeByValType), e.WithRebuild())
+	if err != nil {
+		// The callback makes no changes, so this indicates a problem
+		// with code-generation.
+		panic(err)
+	}
+	return (*ByValType)(y)
+}
+
+// CloneTarget returns a deep copy of the receiver, which will not
+// share any visitable memory with it, except for back-references which
+// form cycles and values registered with SetTargetInterned.
+// Non-visitable fields are copied shallowly. A nil receiver returns nil.
+func (x *ContainerType) CloneTarget() *ContainerType {
+	if x == nil {
+		return nil
+	}
+	fn := TargetWalkerFn(func(ctx TargetContext, _ Target) TargetDecision {
+		return ctx.Continue()
+	})
+	_, y, _, err := targetEngine.Execute(fn, e.TypeID(TargetTypeContainerType), e.Ptr(x), e.TypeID(TargetTypeContainerType), e.WithRebuild())
+	if err != nil {
+		// The callback makes no changes, so this indicates a problem
+		// with code-generation.
+		panic(err)
+	}
+	return (*ContainerType)(y)
+}
+
+// CloneTarget returns a deep copy of the receiver, which will not
+// share any visitable memory with it, except for back-references which
+// form cycles and values registered with SetTargetInterned.
+// Non-visitable fields are copied shallowly. A nil receiver returns nil.
+func (x *EmbeddingType) CloneTarget() *EmbeddingType {
+	if x == nil {
+		return nil
+	}
+	fn := TargetWalkerFn(func(ctx TargetContext, _ Target) TargetDecision {
+		return ctx.Continue()
+	})
+	_, y, _, err := targetEngine.Execute(fn, e.TypeID(TargetTypeEmbeddingType), e.Ptr(x), e.TypeID(TargetTypeEmbeddingType), e.WithRebuild())
+	if err != nil {
+		// The callback makes no changes, so this indicates a problem
+		// with code-generation.
+		panic(err)
+	}
+	return (*EmbeddingType)(y)
+}
+
+// CloneTarget returns a deep copy of the receiver, which will not
+// share any visitable memory with it, except for back-references which
+// form cycles and values registered with SetTargetInterned.
+// Non-visitable fields are copied shallowly. A nil receiver returns nil.
+func (x *EncapsulatedType) CloneTarget() *EncapsulatedType {
+	if x == nil {
+		return nil
+	}
+	fn := TargetWalkerFn(func(ctx TargetContext, _ Target) TargetDecision {
+		return ctx.Continue()
+	})
+	_, y, _, err := targetEngine.Execute(fn, e.TypeID(TargetTypeEncapsulatedType), e.Ptr(x), e.TypeID(TargetTypeEncapsulatedType), e.WithRebuild())
+	if err != nil {
+		// The callback makes no changes, so this indicates a problem
+		// with code-generation.
+		panic(err)
+	}
+	return (*EncapsulatedType)(y)
+}
+
+// CloneTarget returns a deep copy of the receiver, which will not
+// share any visitable memory with it, except for back-references which
+// form cycles and values registered with SetTargetInterned.
+// Non-visitable fields are copied shallowly. A nil receiver returns nil.
+func (x *LooseType) CloneTarget() *LooseType {
+	if x == nil {
+		return nil
+	}
+	fn := TargetWalkerFn(func(ctx TargetContext, _ Target) TargetDecision {
+		return ctx.Continue()
+	})
+	_, y, _, err := targetEngine.Execute(fn, e.TypeID(TargetTypeLooseType), e.Ptr(x), e.TypeID(TargetTypeLooseType), e.WithRebuild())
+	if err != nil {
+		// The callback makes no changes, so this indicates a problem
+		// with code-generation.
+		panic(err)
+	}
+	return (*LooseType)(y)
+}
+
+// CloneTarget returns a deep copy of the receiver, which will not
+// share any visitable memory with it, except for back-references which
+// form cycles and values registered with SetTargetInterned.
+// Non-visitable fields are copied shallowly. A nil receiver returns nil.
+func (x *PairType) CloneTarget() *PairType {
+	if x == nil {
+		return nil
+	}
+	fn := TargetWalkerFn(func(ctx TargetContext, _ Target) TargetDecision {
+		return ctx.Continue()
+	})
+	_, y, _, err := targetEngine.Execute(fn, e.TypeID(TargetTypePairType), e.Ptr(x), e.TypeID(TargetTypePairType), e.WithRebuild())
+	if err != nil {
+		// The callback makes no changes, so this indicates a problem
+		// with code-generation.
+		panic(err)
+	}
+	return (*PairType)(y)
+}
+
+// CloneTarget returns a deep copy of the receiver, which will not
+// share any visitable memory with it, except for back-references which
+// form cycles and values registered with SetTargetInterned.
+// Non-visitable fields are copied shallowly. A nil receiver returns nil.
+func (x *ScopeType) CloneTarget() *ScopeType {
+	if x == nil {
+		return nil
+	}
+	fn := TargetWalkerFn(func(ctx TargetContext, _ Target) TargetDecision {
+		return ctx.Continue()
+	})
+	_, y, _, err := targetEngine.Execute(fn, e.TypeID(TargetTypeScopeType), e.Ptr(x), e.TypeID(TargetTypeScopeType), e.WithRebuild())
+	if err != nil {
+		// The callback makes no changes, so this indicates a problem
+		// with code-generation.
+		panic(err)
+	}
+	return (*ScopeType)(y)
+}
+
+// CloneTarget returns a deep copy of the receiver, which will not
+// share any visitable memory with it, except for back-references which
+// form cycles and values registered with SetTargetInterned.
+// Non-visitable fields are copied shallowly. A nil receiver returns nil.
+func (x *WrapperType) CloneTarget() *WrapperType {
+	if x == nil {
+		return nil
+	}
+	fn := TargetWalkerFn(func(ctx TargetContext, _ Target) TargetDecision {
+		return ctx.Continue()
+	})
+	_, y, _, err := targetEngine.Execute(fn, e.TypeID(TargetTypeWrapperType), e.Ptr(x), e.TypeID(TargetTypeWrapperType), e.WithRebuild())
+	if err != nil {
+		// The callback makes no changes, so this indicates a problem
+		// with code-generation.
+		panic(err)
+	}
+	return (*WrapperType)(y)
+}
+
 // ------ Type Enhancements ------
 
 // targetAbstract is a type-safe facade around e.Abstract.
@@ -195,8 +589,66 @@ type targetAbstract struct {
 var _ TargetAbstract = &targetAbstract{}
 
 // TargetAt implements TargetAbstract.
-func (a *targetAbstract) TargetAt(index int) (ret TargetAbstract) {
-	impl := a.delegate.ChildAt(index)
+func (a *targetAbstract) TargetAt(index int) TargetAbstract {
+	return targetAbstractOf(a.delegate.ChildAt(index))
+}
+
+// TargetNamed implements TargetAbstract.
+func (a *targetAbstract) TargetNamed(name string) TargetAbstract {
+	impl, _ := a.delegate.ChildNamed(name)
+	return targetAbstractOf(impl)
+}
+
+// TargetEach implements TargetAbstract.
+func (a *targetAbstract) TargetEach(fn func(index int, child TargetAbstract) bool) {
+	if a.delegate.NumChildren() == 0 {
+		return
+	}
+	var scratch targetAbstract
+	a.delegate.Children(func(index int, child *e.Abstract) bool {
+		return fn(index, targetAbstractOfReusing(child, &scratch))
+	})
+}
+
+// TargetFieldNameAt implements TargetAbstract.
+func (a *targetAbstract) TargetFieldNameAt(index int) string {
+	return a.delegate.FieldNameAt(index)
+}
+
+// TargetCount implements TargetAbstract.
+func (a *targetAbstract) TargetCount() int {
+	return a.delegate.NumChildren()
+}
+
+// TargetTypeID implements TargetAbstract.
+func (a *targetAbstract) TargetTypeID() TargetTypeID {
+	return TargetTypeID(a.delegate.TypeID())
+}
+
+// TargetWalk implements TargetAbstract.
+func (a *targetAbstract) TargetWalk(fn TargetWalkerFn) (_ TargetAbstract, changed bool, err error) {
+	id := a.delegate.TypeID()
+	id, ptr, changed, err := targetEngine.Execute(fn, id, a.delegate.Ptr(), id)
+	if err != nil {
+		return nil, false, err
+	}
+	if changed {
+		return targetAbstractOf(targetEngine.Abstract(id, ptr)), true, nil
+	}
+	return a, false, nil
+}
+
+// targetAbstractOf returns the most specific TargetAbstract around
+// the given value. Structs are returned as-is, while slices and arrays
+// are wrapped in a type-safe facade.
+func targetAbstractOf(impl *e.Abstract) (ret TargetAbstract) {
+	return targetAbstractOfReusing(impl, nil)
+}
+
+// targetAbstractOfReusing is like targetAbstractOf, but will store
+// slices and arrays in the scratch facade if it is non-nil, instead of
+// allocating a new one.
+func targetAbstractOfReusing(impl *e.Abstract, scratch *targetAbstract) (ret TargetAbstract) {
 	if impl == nil {
 		return nil
 	}
@@ -213,99 +665,3651 @@ func (a *targetAbstract) TargetAt(index int) (ret TargetAbstract) {
 		ret = (*ContainerType)(impl.Ptr())
 	case TargetTypeContainerTypePtr:
 		ret = *(**ContainerType)(impl.Ptr())
+	case TargetTypeEmbeddingType:
+		ret = (*EmbeddingType)(impl.Ptr())
+	case TargetTypeEmbeddingTypePtr:
+		ret = *(**EmbeddingType)(impl.Ptr())
+	case TargetTypeEncapsulatedType:
+		ret = (*EncapsulatedType)(impl.Ptr())
+	case TargetTypeEncapsulatedTypePtr:
+		ret = *(**EncapsulatedType)(impl.Ptr())
+	case TargetTypeLooseType:
+		ret = (*LooseType)(impl.Ptr())
+	case TargetTypeLooseTypePtr:
+		ret = *(**LooseType)(impl.Ptr())
+	case TargetTypePairType:
+		ret = (*PairType)(impl.Ptr())
+	case TargetTypePairTypePtr:
+		ret = *(**PairType)(impl.Ptr())
+	case TargetTypeScopeType:
+		ret = (*ScopeType)(impl.Ptr())
+	case TargetTypeScopeTypePtr:
+		ret = *(**ScopeType)(impl.Ptr())
+	case TargetTypeWrapperType:
+		ret = (*WrapperType)(impl.Ptr())
+	case TargetTypeWrapperTypePtr:
+		ret = *(**WrapperType)(impl.Ptr())
 	default:
-		ret = &targetAbstract{impl}
+		if scratch == nil {
+			scratch = &targetAbstract{}
+		}
+		scratch.delegate = impl
+		ret = scratch
+	}
+	return
+}
+
+// TargetNode is a position within the tree of values exposed by
+// TargetAbstract. Unlike the values returned by TargetAt, a
+// TargetNode retains its parent, so that the tree may be navigated
+// in either direction.
+type TargetNode struct {
+	delegate *e.Abstract
+}
+
+// NewTargetNode returns a TargetNode around x, which will have no
+// parent. It returns nil if x is nil.
+func NewTargetNode(x Target) *TargetNode {
+	if x == nil {
+		return nil
+	}
+	id, ptr := targetIdentify(x)
+	if impl := targetEngine.Abstract(id, ptr); impl != nil {
+		return &TargetNode{impl}
+	}
+	return nil
+}
+
+// Abstract returns the value at the node's position.
+func (n *TargetNode) Abstract() TargetAbstract {
+	return targetAbstractOf(n.delegate)
+}
+
+// TargetAt returns the node of the nth child, following the same
+// rules as TargetAbstract.TargetAt.
+func (n *TargetNode) TargetAt(index int) *TargetNode {
+	if impl := n.delegate.ChildAt(index); impl != nil {
+		return &TargetNode{impl}
+	}
+	return nil
+}
+
+// TargetNamed returns the node of the named field, following
+// the same rules as TargetAbstract.TargetNamed.
+func (n *TargetNode) TargetNamed(name string) *TargetNode {
+	if impl, _ := n.delegate.ChildNamed(name); impl != nil {
+		return &TargetNode{impl}
+	}
+	return nil
+}
+
+// TargetCount returns the number of children.
+func (n *TargetNode) TargetCount() int {
+	return n.delegate.NumChildren()
+}
+
+// Parent returns the node whose TargetAt method returned this
+// one, or nil if the node was returned by NewTargetNode.
+func (n *TargetNode) Parent() *TargetNode {
+	if impl := n.delegate.Parent(); impl != nil {
+		return &TargetNode{impl}
+	}
+	return nil
+}
+
+// ParentAt returns the index of the node within its parent, or -1 if
+// the node has no parent.
+func (n *TargetNode) ParentAt() int {
+	return n.delegate.ParentAt()
+}
+
+// NextSibling returns the node which follows this one within its
+// parent, following the same rules as TargetAt. It returns nil if
+// this is the last child, or if the node has no parent.
+func (n *TargetNode) NextSibling() *TargetNode {
+	if impl := n.delegate.Sibling(1); impl != nil {
+		return &TargetNode{impl}
+	}
+	return nil
+}
+
+// PrevSibling returns the node which precedes this one within its
+// parent, following the same rules as TargetAt. It returns nil if
+// this is the first child, or if the node has no parent.
+func (n *TargetNode) PrevSibling() *TargetNode {
+	if impl := n.delegate.Sibling(-1); impl != nil {
+		return &TargetNode{impl}
+	}
+	return nil
+}
+
+// TargetTypeID returns the type token of the node's value.
+func (n *TargetNode) TargetTypeID() TargetTypeID {
+	return TargetTypeID(n.delegate.TypeID())
+}
+
+// TargetAt implements TargetAbstract.
+func (x *ByRefType) TargetAt(index int) TargetAbstract {
+	self := targetAbstract{targetEngine.Abstract(e.TypeID(TargetTypeByRefType), e.Ptr(x))}
+	return self.TargetAt(index)
+}
+
+// TargetNamed implements TargetAbstract.
+func (x *ByRefType) TargetNamed(name string) TargetAbstract {
+	self := targetAbstract{targetEngine.Abstract(e.TypeID(TargetTypeByRefType), e.Ptr(x))}
+	return self.TargetNamed(name)
+}
+
+// TargetEach implements TargetAbstract.
+func (x *ByRefType) TargetEach(fn func(index int, child TargetAbstract) bool) {
+	if x.TargetCount() == 0 {
+		return
+	}
+	self := targetAbstract{targetEngine.Abstract(e.TypeID(TargetTypeByRefType), e.Ptr(x))}
+	self.TargetEach(fn)
+}
+
+// TargetFieldNameAt implements TargetAbstract.
+func (x *ByRefType) TargetFieldNameAt(index int) string {
+	self := targetAbstract{targetEngine.Abstract(e.TypeID(TargetTypeByRefType), e.Ptr(x))}
+	return self.TargetFieldNameAt(index)
+}
+
+// TargetCount returns 0.
+func (x *ByRefType) TargetCount() int { return 0 }
+
+// TargetTypeID returns TargetTypeByRefType.
+func (*ByRefType) TargetTypeID() TargetTypeID { return TargetTypeByRefType }
+
+// TargetWalk implements TargetAbstract by delegating to
+// WalkTarget. A nil receiver is a no-op.
+func (x *ByRefType) TargetWalk(fn TargetWalkerFn) (_ TargetAbstract, changed bool, err error) {
+	if x == nil {
+		return nil, false, nil
+	}
+	y, changed, err := x.WalkTarget(fn)
+	if err != nil {
+		return nil, false, err
+	}
+	return y, changed, nil
+}
+
+// WalkTarget visits the receiver with the provided callback.
+// A nil receiver is a no-op.
+func (x *ByRefType) WalkTarget(fn TargetWalkerFn) (_ *ByRefType, changed bool, err error) {
+	if x == nil {
+		return nil, false, nil
+	}
+	var y e.Ptr
+	_, y, changed, err = targetEngine.Execute(fn, e.TypeID(TargetTypeByRefType), e.Ptr(x), e.TypeID(TargetTypeByRefType))
+	if err != nil {
+		return nil, false, err
+	}
+	return (*ByRefType)(y), changed, nil
+}
+
+// WalkTargetMorph visits the receiver with the provided callback.
+// Unlike WalkTarget, the receiver may be replaced by a value of any
+// type which implements Target. A nil receiver is a no-op.
+func (x *ByRefType) WalkTargetMorph(fn TargetWalkerFn) (_ Target, changed bool, err error) {
+	if x == nil {
+		return nil, false, nil
+	}
+	id, y, changed, err := targetEngine.Execute(fn, e.TypeID(TargetTypeByRefType), e.Ptr(x), e.TypeID(TargetTypeTarget))
+	if err != nil {
+		return nil, false, err
+	}
+	if changed {
+		return targetWrap(id, y), true, nil
+	}
+	return x, false, nil
+}
+
+// InspectTarget visits the receiver with a callback which cannot
+// influence the visitation. A nil receiver is a no-op.
+func (x *ByRefType) InspectTarget(fn func(x Target)) {
+	if x == nil {
+		return
+	}
+	_, _, _, _ = targetEngine.Execute(inspectTarget(fn), e.TypeID(TargetTypeByRefType), e.Ptr(x), e.TypeID(TargetTypeByRefType))
+}
+
+// TargetAt implements TargetAbstract.
+func (x *ByValType) TargetAt(index int) TargetAbstract {
+	self := targetAbstract{targetEngine.Abstract(e.TypeID(TargetTypeByValType), e.Ptr(x))}
+	return self.TargetAt(index)
+}
+
+// TargetNamed implements TargetAbstract.
+func (x *ByValType) TargetNamed(name string) TargetAbstract {
+	self := targetAbstract{targetEngine.Abstract(e.TypeID(TargetTypeByValType), e.Ptr(x))}
+	return self.TargetNamed(name)
+}
+
+// TargetEach implements TargetAbstract.
+func (x *ByValType) TargetEach(fn func(index int, child TargetAbstract) bool) {
+	if x.TargetCount() == 0 {
+		return
+	}
+	self := targetAbstract{targetEngine.Abstract(e.TypeID(TargetTypeByValType), e.Ptr(x))}
+	self.TargetEach(fn)
+}
+
+// TargetFieldNameAt implements TargetAbstract.
+func (x *ByValType) TargetFieldNameAt(index int) string {
+	self := targetAbstract{targetEngine.Abstract(e.TypeID(TargetTypeByValType), e.Ptr(x))}
+	return self.TargetFieldNameAt(index)
+}
+
+// TargetCount returns 0.
+func (x *ByValType) TargetCount() int { return 0 }
+
+// TargetTypeID returns TargetTypeByValType.
+func (*ByValType) TargetTypeID() TargetTypeID { return TargetTypeByValType }
+
+// TargetWalk implements TargetAbstract by delegating to
+// WalkTarget. A nil receiver is a no-op.
+func (x *ByValType) TargetWalk(fn TargetWalkerFn) (_ TargetAbstract, changed bool, err error) {
+	if x == nil {
+		return nil, false, nil
+	}
+	y, changed, err := x.WalkTarget(fn)
+	if err != nil {
+		return nil, false, err
+	}
+	return y, changed, nil
+}
+
+// WalkTarget visits the receiver with the provided callback.
+// A nil receiver is a no-op.
+func (x *ByValType) WalkTarget(fn TargetWalkerFn) (_ *ByValType, changed bool, err error) {
+	if x == nil {
+		return nil, false, nil
+	}
+	var y e.Ptr
+	_, y, changed, err = targetEngine.Execute(fn, e.TypeID(TargetTypeByValType), e.Ptr(x), e.TypeID(TargetTypeByValType))
+	if err != nil {
+		return nil, false, err
+	}
+	return (*ByValType)(y), changed, nil
+}
+
+// WalkTargetMorph visits the receiver with the provided callback.
+// Unlike WalkTarget, the receiver may be replaced by a value of any
+// type which implements Target. A nil receiver is a no-op.
+func (x *ByValType) WalkTargetMorph(fn TargetWalkerFn) (_ Target, changed bool, err error) {
+	if x == nil {
+		return nil, false, nil
+	}
+	id, y, changed, err := targetEngine.Execute(fn, e.TypeID(TargetTypeByValType), e.Ptr(x), e.TypeID(TargetTypeTarget))
+	if err != nil {
+		return nil, false, err
+	}
+	if changed {
+		return targetWrap(id, y), true, nil
+	}
+	return x, false, nil
+}
+
+// InspectTarget visits the receiver with a callback which cannot
+// influence the visitation. A nil receiver is a no-op.
+func (x *ByValType) InspectTarget(fn func(x Target)) {
+	if x == nil {
+		return
+	}
+	_, _, _, _ = targetEngine.Execute(inspectTarget(fn), e.TypeID(TargetTypeByValType), e.Ptr(x), e.TypeID(TargetTypeByValType))
+}
+
+// TargetAt implements TargetAbstract.
+func (x *ContainerType) TargetAt(index int) TargetAbstract {
+	self := targetAbstract{targetEngine.Abstract(e.TypeID(TargetTypeContainerType), e.Ptr(x))}
+	return self.TargetAt(index)
+}
+
+// TargetNamed implements TargetAbstract.
+func (x *ContainerType) TargetNamed(name string) TargetAbstract {
+	self := targetAbstract{targetEngine.Abstract(e.TypeID(TargetTypeContainerType), e.Ptr(x))}
+	return self.TargetNamed(name)
+}
+
+// TargetEach implements TargetAbstract.
+func (x *ContainerType) TargetEach(fn func(index int, child TargetAbstract) bool) {
+	if x.TargetCount() == 0 {
+		return
+	}
+	self := targetAbstract{targetEngine.Abstract(e.TypeID(TargetTypeContainerType), e.Ptr(x))}
+	self.TargetEach(fn)
+}
+
+// TargetFieldNameAt implements TargetAbstract.
+func (x *ContainerType) TargetFieldNameAt(index int) string {
+	self := targetAbstract{targetEngine.Abstract(e.TypeID(TargetTypeContainerType), e.Ptr(x))}
+	return self.TargetFieldNameAt(index)
+}
+
+// TargetCount returns 19.
+func (x *ContainerType) TargetCount() int { return 19 }
+
+// TargetTypeID returns TargetTypeContainerType.
+func (*ContainerType) TargetTypeID() TargetTypeID { return TargetTypeContainerType }
+
+// TargetWalk implements TargetAbstract by delegating to
+// WalkTarget. A nil receiver is a no-op.
+func (x *ContainerType) TargetWalk(fn TargetWalkerFn) (_ TargetAbstract, changed bool, err error) {
+	if x == nil {
+		return nil, false, nil
+	}
+	y, changed, err := x.WalkTarget(fn)
+	if err != nil {
+		return nil, false, err
+	}
+	return y, changed, nil
+}
+
+// WalkTarget visits the receiver with the provided callback.
+// A nil receiver is a no-op.
+func (x *ContainerType) WalkTarget(fn TargetWalkerFn) (_ *ContainerType, changed bool, err error) {
+	if x == nil {
+		return nil, false, nil
+	}
+	var y e.Ptr
+	_, y, changed, err = targetEngine.Execute(fn, e.TypeID(TargetTypeContainerType), e.Ptr(x), e.TypeID(TargetTypeContainerType))
+	if err != nil {
+		return nil, false, err
+	}
+	return (*ContainerType)(y), changed, nil
+}
+
+// WalkTargetMorph visits the receiver with the provided callback.
+// Unlike WalkTarget, the receiver may be replaced by a value of any
+// type which implements Target. A nil receiver is a no-op.
+func (x *ContainerType) WalkTargetMorph(fn TargetWalkerFn) (_ Target, changed bool, err error) {
+	if x == nil {
+		return nil, false, nil
+	}
+	id, y, changed, err := targetEngine.Execute(fn, e.TypeID(TargetTypeContainerType), e.Ptr(x), e.TypeID(TargetTypeTarget))
+	if err != nil {
+		return nil, false, err
+	}
+	if changed {
+		return targetWrap(id, y), true, nil
+	}
+	return x, false, nil
+}
+
+// InspectTarget visits the receiver with a callback which cannot
+// influence the visitation. A nil receiver is a no-op.
+func (x *ContainerType) InspectTarget(fn func(x Target)) {
+	if x == nil {
+		return
+	}
+	_, _, _, _ = targetEngine.Execute(inspectTarget(fn), e.TypeID(TargetTypeContainerType), e.Ptr(x), e.TypeID(TargetTypeContainerType))
+}
+
+// ByRefField returns a pointer to the ByRef field.
+func (x *ContainerType) ByRefField() *ByRefType { return &x.ByRef }
+
+// WithByRef returns a shallow copy of the receiver, in which
+// the ByRef field has been replaced with v. The receiver is
+// not modified.
+func (x *ContainerType) WithByRef(v ByRefType) *ContainerType {
+	ret := *x
+	ret.ByRef = v
+	return &ret
+}
+
+// ByRefPtrField returns the ByRefPtr field.
+func (x *ContainerType) ByRefPtrField() *ByRefType { return x.ByRefPtr }
+
+// WithByRefPtr returns a shallow copy of the receiver, in which
+// the ByRefPtr field has been replaced with v. The receiver is
+// not modified.
+func (x *ContainerType) WithByRefPtr(v *ByRefType) *ContainerType {
+	ret := *x
+	ret.ByRefPtr = v
+	return &ret
+}
+
+// ByRefSliceField returns the ByRefSlice field.
+func (x *ContainerType) ByRefSliceField() []ByRefType { return x.ByRefSlice }
+
+// WithByRefSlice returns a shallow copy of the receiver, in which
+// the ByRefSlice field has been replaced with v. The receiver is
+// not modified.
+func (x *ContainerType) WithByRefSlice(v []ByRefType) *ContainerType {
+	ret := *x
+	ret.ByRefSlice = v
+	return &ret
+}
+
+// ByRefPtrSliceField returns the ByRefPtrSlice field.
+func (x *ContainerType) ByRefPtrSliceField() []*ByRefType { return x.ByRefPtrSlice }
+
+// WithByRefPtrSlice returns a shallow copy of the receiver, in which
+// the ByRefPtrSlice field has been replaced with v. The receiver is
+// not modified.
+func (x *ContainerType) WithByRefPtrSlice(v []*ByRefType) *ContainerType {
+	ret := *x
+	ret.ByRefPtrSlice = v
+	return &ret
+}
+
+// ByValField returns a pointer to the ByVal field.
+func (x *ContainerType) ByValField() *ByValType { return &x.ByVal }
+
+// WithByVal returns a shallow copy of the receiver, in which
+// the ByVal field has been replaced with v. The receiver is
+// not modified.
+func (x *ContainerType) WithByVal(v ByValType) *ContainerType {
+	ret := *x
+	ret.ByVal = v
+	return &ret
+}
+
+// ByValPtrField returns the ByValPtr field.
+func (x *ContainerType) ByValPtrField() *ByValType { return x.ByValPtr }
+
+// WithByValPtr returns a shallow copy of the receiver, in which
+// the ByValPtr field has been replaced with v. The receiver is
+// not modified.
+func (x *ContainerType) WithByValPtr(v *ByValType) *ContainerType {
+	ret := *x
+	ret.ByValPtr = v
+	return &ret
+}
+
+// ByValSliceField returns the ByValSlice field.
+func (x *ContainerType) ByValSliceField() []ByValType { return x.ByValSlice }
+
+// WithByValSlice returns a shallow copy of the receiver, in which
+// the ByValSlice field has been replaced with v. The receiver is
+// not modified.
+func (x *ContainerType) WithByValSlice(v []ByValType) *ContainerType {
+	ret := *x
+	ret.ByValSlice = v
+	return &ret
+}
+
+// ByValPtrSliceField returns the ByValPtrSlice field.
+func (x *ContainerType) ByValPtrSliceField() []*ByValType { return x.ByValPtrSlice }
+
+// WithByValPtrSlice returns a shallow copy of the receiver, in which
+// the ByValPtrSlice field has been replaced with v. The receiver is
+// not modified.
+func (x *ContainerType) WithByValPtrSlice(v []*ByValType) *ContainerType {
+	ret := *x
+	ret.ByValPtrSlice = v
+	return &ret
+}
+
+// ContainerField returns the Container field.
+func (x *ContainerType) ContainerField() *ContainerType { return x.Container }
+
+// WithContainer returns a shallow copy of the receiver, in which
+// the Container field has been replaced with v. The receiver is
+// not modified.
+func (x *ContainerType) WithContainer(v *ContainerType) *ContainerType {
+	ret := *x
+	ret.Container = v
+	return &ret
+}
+
+// AnotherTargetField returns the AnotherTarget field.
+func (x *ContainerType) AnotherTargetField() Target { return x.AnotherTarget }
+
+// WithAnotherTarget returns a shallow copy of the receiver, in which
+// the AnotherTarget field has been replaced with v. The receiver is
+// not modified.
+func (x *ContainerType) WithAnotherTarget(v Target) *ContainerType {
+	ret := *x
+	ret.AnotherTarget = v
+	return &ret
+}
+
+// AnotherTargetPtrField returns the AnotherTargetPtr field.
+func (x *ContainerType) AnotherTargetPtrField() *Target { return x.AnotherTargetPtr }
+
+// WithAnotherTargetPtr returns a shallow copy of the receiver, in which
+// the AnotherTargetPtr field has been replaced with v. The receiver is
+// not modified.
+func (x *ContainerType) WithAnotherTargetPtr(v *Target) *ContainerType {
+	ret := *x
+	ret.AnotherTargetPtr = v
+	return &ret
+}
+
+// EmbedsTargetField returns the EmbedsTarget field.
+func (x *ContainerType) EmbedsTargetField() EmbedsTarget { return x.EmbedsTarget }
+
+// WithEmbedsTarget returns a shallow copy of the receiver, in which
+// the EmbedsTarget field has been replaced with v. The receiver is
+// not modified.
+func (x *ContainerType) WithEmbedsTarget(v EmbedsTarget) *ContainerType {
+	ret := *x
+	ret.EmbedsTarget = v
+	return &ret
+}
+
+// EmbedsTargetPtrField returns the EmbedsTargetPtr field.
+func (x *ContainerType) EmbedsTargetPtrField() *EmbedsTarget { return x.EmbedsTargetPtr }
+
+// WithEmbedsTargetPtr returns a shallow copy of the receiver, in which
+// the EmbedsTargetPtr field has been replaced with v. The receiver is
+// not modified.
+func (x *ContainerType) WithEmbedsTargetPtr(v *EmbedsTarget) *ContainerType {
+	ret := *x
+	ret.EmbedsTargetPtr = v
+	return &ret
+}
+
+// TargetSliceField returns the TargetSlice field.
+func (x *ContainerType) TargetSliceField() []Target { return x.TargetSlice }
+
+// WithTargetSlice returns a shallow copy of the receiver, in which
+// the TargetSlice field has been replaced with v. The receiver is
+// not modified.
+func (x *ContainerType) WithTargetSlice(v []Target) *ContainerType {
+	ret := *x
+	ret.TargetSlice = v
+	return &ret
+}
+
+// InterfacePtrSliceField returns the InterfacePtrSlice field.
+func (x *ContainerType) InterfacePtrSliceField() []*Target { return x.InterfacePtrSlice }
+
+// WithInterfacePtrSlice returns a shallow copy of the receiver, in which
+// the InterfacePtrSlice field has been replaced with v. The receiver is
+// not modified.
+func (x *ContainerType) WithInterfacePtrSlice(v []*Target) *ContainerType {
+	ret := *x
+	ret.InterfacePtrSlice = v
+	return &ret
+}
+
+// NamedTargetsField returns the NamedTargets field.
+func (x *ContainerType) NamedTargetsField() Targets { return x.NamedTargets }
+
+// WithNamedTargets returns a shallow copy of the receiver, in which
+// the NamedTargets field has been replaced with v. The receiver is
+// not modified.
+func (x *ContainerType) WithNamedTargets(v Targets) *ContainerType {
+	ret := *x
+	ret.NamedTargets = v
+	return &ret
+}
+
+// QuadField returns a pointer to the Quad field.
+func (x *ContainerType) QuadField() *Quad { return &x.Quad }
+
+// WithQuad returns a shallow copy of the receiver, in which
+// the Quad field has been replaced with v. The receiver is
+// not modified.
+func (x *ContainerType) WithQuad(v Quad) *ContainerType {
+	ret := *x
+	ret.Quad = v
+	return &ret
+}
+
+// OptTargetField returns the OptTarget field.
+func (x *ContainerType) OptTargetField() OptTarget { return x.OptTarget }
+
+// WithOptTarget returns a shallow copy of the receiver, in which
+// the OptTarget field has been replaced with v. The receiver is
+// not modified.
+func (x *ContainerType) WithOptTarget(v OptTarget) *ContainerType {
+	ret := *x
+	ret.OptTarget = v
+	return &ret
+}
+
+// AnnotatedField returns the Annotated field.
+func (x *ContainerType) AnnotatedField() Annotated { return x.Annotated }
+
+// WithAnnotated returns a shallow copy of the receiver, in which
+// the Annotated field has been replaced with v. The receiver is
+// not modified.
+func (x *ContainerType) WithAnnotated(v Annotated) *ContainerType {
+	ret := *x
+	ret.Annotated = v
+	return &ret
+}
+
+// TargetAt implements TargetAbstract.
+func (x *EmbeddingType) TargetAt(index int) TargetAbstract {
+	self := targetAbstract{targetEngine.Abstract(e.TypeID(TargetTypeEmbeddingType), e.Ptr(x))}
+	return self.TargetAt(index)
+}
+
+// TargetNamed implements TargetAbstract.
+func (x *EmbeddingType) TargetNamed(name string) TargetAbstract {
+	self := targetAbstract{targetEngine.Abstract(e.TypeID(TargetTypeEmbeddingType), e.Ptr(x))}
+	return self.TargetNamed(name)
+}
+
+// TargetEach implements TargetAbstract.
+func (x *EmbeddingType) TargetEach(fn func(index int, child TargetAbstract) bool) {
+	if x.TargetCount() == 0 {
+		return
+	}
+	self := targetAbstract{targetEngine.Abstract(e.TypeID(TargetTypeEmbeddingType), e.Ptr(x))}
+	self.TargetEach(fn)
+}
+
+// TargetFieldNameAt implements TargetAbstract.
+func (x *EmbeddingType) TargetFieldNameAt(index int) string {
+	self := targetAbstract{targetEngine.Abstract(e.TypeID(TargetTypeEmbeddingType), e.Ptr(x))}
+	return self.TargetFieldNameAt(index)
+}
+
+// TargetCount returns 4.
+func (x *EmbeddingType) TargetCount() int { return 4 }
+
+// TargetTypeID returns TargetTypeEmbeddingType.
+func (*EmbeddingType) TargetTypeID() TargetTypeID { return TargetTypeEmbeddingType }
+
+// TargetWalk implements TargetAbstract by delegating to
+// WalkTarget. A nil receiver is a no-op.
+func (x *EmbeddingType) TargetWalk(fn TargetWalkerFn) (_ TargetAbstract, changed bool, err error) {
+	if x == nil {
+		return nil, false, nil
+	}
+	y, changed, err := x.WalkTarget(fn)
+	if err != nil {
+		return nil, false, err
+	}
+	return y, changed, nil
+}
+
+// WalkTarget visits the receiver with the provided callback.
+// A nil receiver is a no-op.
+func (x *EmbeddingType) WalkTarget(fn TargetWalkerFn) (_ *EmbeddingType, changed bool, err error) {
+	if x == nil {
+		return nil, false, nil
+	}
+	var y e.Ptr
+	_, y, changed, err = targetEngine.Execute(fn, e.TypeID(TargetTypeEmbeddingType), e.Ptr(x), e.TypeID(TargetTypeEmbeddingType))
+	if err != nil {
+		return nil, false, err
+	}
+	return (*EmbeddingType)(y), changed, nil
+}
+
+// WalkTargetMorph visits the receiver with the provided callback.
+// Unlike WalkTarget, the receiver may be replaced by a value of any
+// type which implements Target. A nil receiver is a no-op.
+func (x *EmbeddingType) WalkTargetMorph(fn TargetWalkerFn) (_ Target, changed bool, err error) {
+	if x == nil {
+		return nil, false, nil
+	}
+	id, y, changed, err := targetEngine.Execute(fn, e.TypeID(TargetTypeEmbeddingType), e.Ptr(x), e.TypeID(TargetTypeTarget))
+	if err != nil {
+		return nil, false, err
+	}
+	if changed {
+		return targetWrap(id, y), true, nil
+	}
+	return x, false, nil
+}
+
+// InspectTarget visits the receiver with a callback which cannot
+// influence the visitation. A nil receiver is a no-op.
+func (x *EmbeddingType) InspectTarget(fn func(x Target)) {
+	if x == nil {
+		return
+	}
+	_, _, _, _ = targetEngine.Execute(inspectTarget(fn), e.TypeID(TargetTypeEmbeddingType), e.Ptr(x), e.TypeID(TargetTypeEmbeddingType))
+}
+
+// PromotedField returns the Promoted field.
+func (x *EmbeddingType) PromotedField() Target { return x.Promoted }
+
+// WithPromoted returns a shallow copy of the receiver, in which
+// the Promoted field has been replaced with v. The receiver is
+// not modified.
+func (x *EmbeddingType) WithPromoted(v Target) *EmbeddingType {
+	ret := *x
+	ret.Promoted = v
+	return &ret
+}
+
+// PromotedsField returns the Promoteds field.
+func (x *EmbeddingType) PromotedsField() []Target { return x.Promoteds }
+
+// WithPromoteds returns a shallow copy of the receiver, in which
+// the Promoteds field has been replaced with v. The receiver is
+// not modified.
+func (x *EmbeddingType) WithPromoteds(v []Target) *EmbeddingType {
+	ret := *x
+	ret.Promoteds = v
+	return &ret
+}
+
+// ScopedField returns the Scoped field.
+func (x *EmbeddingType) ScopedField() Target { return x.Scoped }
+
+// WithScoped returns a shallow copy of the receiver, in which
+// the Scoped field has been replaced with v. The receiver is
+// not modified.
+func (x *EmbeddingType) WithScoped(v Target) *EmbeddingType {
+	ret := *x
+	ret.Scoped = v
+	return &ret
+}
+
+// OwnField returns the Own field.
+func (x *EmbeddingType) OwnField() Target { return x.Own }
+
+// WithOwn returns a shallow copy of the receiver, in which
+// the Own field has been replaced with v. The receiver is
+// not modified.
+func (x *EmbeddingType) WithOwn(v Target) *EmbeddingType {
+	ret := *x
+	ret.Own = v
+	return &ret
+}
+
+// TargetAt implements TargetAbstract.
+func (x *EncapsulatedType) TargetAt(index int) TargetAbstract {
+	self := targetAbstract{targetEngine.Abstract(e.TypeID(TargetTypeEncapsulatedType), e.Ptr(x))}
+	return self.TargetAt(index)
+}
+
+// TargetNamed implements TargetAbstract.
+func (x *EncapsulatedType) TargetNamed(name string) TargetAbstract {
+	self := targetAbstract{targetEngine.Abstract(e.TypeID(TargetTypeEncapsulatedType), e.Ptr(x))}
+	return self.TargetNamed(name)
+}
+
+// TargetEach implements TargetAbstract.
+func (x *EncapsulatedType) TargetEach(fn func(index int, child TargetAbstract) bool) {
+	if x.TargetCount() == 0 {
+		return
+	}
+	self := targetAbstract{targetEngine.Abstract(e.TypeID(TargetTypeEncapsulatedType), e.Ptr(x))}
+	self.TargetEach(fn)
+}
+
+// TargetFieldNameAt implements TargetAbstract.
+func (x *EncapsulatedType) TargetFieldNameAt(index int) string {
+	self := targetAbstract{targetEngine.Abstract(e.TypeID(TargetTypeEncapsulatedType), e.Ptr(x))}
+	return self.TargetFieldNameAt(index)
+}
+
+// TargetCount returns 1.
+func (x *EncapsulatedType) TargetCount() int { return 1 }
+
+// TargetTypeID returns TargetTypeEncapsulatedType.
+func (*EncapsulatedType) TargetTypeID() TargetTypeID { return TargetTypeEncapsulatedType }
+
+// TargetWalk implements TargetAbstract by delegating to
+// WalkTarget. A nil receiver is a no-op.
+func (x *EncapsulatedType) TargetWalk(fn TargetWalkerFn) (_ TargetAbstract, changed bool, err error) {
+	if x == nil {
+		return nil, false, nil
+	}
+	y, changed, err := x.WalkTarget(fn)
+	if err != nil {
+		return nil, false, err
+	}
+	return y, changed, nil
+}
+
+// WalkTarget visits the receiver with the provided callback.
+// A nil receiver is a no-op.
+func (x *EncapsulatedType) WalkTarget(fn TargetWalkerFn) (_ *EncapsulatedType, changed bool, err error) {
+	if x == nil {
+		return nil, false, nil
+	}
+	var y e.Ptr
+	_, y, changed, err = targetEngine.Execute(fn, e.TypeID(TargetTypeEncapsulatedType), e.Ptr(x), e.TypeID(TargetTypeEncapsulatedType))
+	if err != nil {
+		return nil, false, err
+	}
+	return (*EncapsulatedType)(y), changed, nil
+}
+
+// WalkTargetMorph visits the receiver with the provided callback.
+// Unlike WalkTarget, the receiver may be replaced by a value of any
+// type which implements Target. A nil receiver is a no-op.
+func (x *EncapsulatedType) WalkTargetMorph(fn TargetWalkerFn) (_ Target, changed bool, err error) {
+	if x == nil {
+		return nil, false, nil
+	}
+	id, y, changed, err := targetEngine.Execute(fn, e.TypeID(TargetTypeEncapsulatedType), e.Ptr(x), e.TypeID(TargetTypeTarget))
+	if err != nil {
+		return nil, false, err
+	}
+	if changed {
+		return targetWrap(id, y), true, nil
+	}
+	return x, false, nil
+}
+
+// InspectTarget visits the receiver with a callback which cannot
+// influence the visitation. A nil receiver is a no-op.
+func (x *EncapsulatedType) InspectTarget(fn func(x Target)) {
+	if x == nil {
+		return
+	}
+	_, _, _, _ = targetEngine.Execute(inspectTarget(fn), e.TypeID(TargetTypeEncapsulatedType), e.Ptr(x), e.TypeID(TargetTypeEncapsulatedType))
+}
+
+// TargetAt implements TargetAbstract.
+func (x *LooseType) TargetAt(index int) TargetAbstract {
+	self := targetAbstract{targetEngine.Abstract(e.TypeID(TargetTypeLooseType), e.Ptr(x))}
+	return self.TargetAt(index)
+}
+
+// TargetNamed implements TargetAbstract.
+func (x *LooseType) TargetNamed(name string) TargetAbstract {
+	self := targetAbstract{targetEngine.Abstract(e.TypeID(TargetTypeLooseType), e.Ptr(x))}
+	return self.TargetNamed(name)
+}
+
+// TargetEach implements TargetAbstract.
+func (x *LooseType) TargetEach(fn func(index int, child TargetAbstract) bool) {
+	if x.TargetCount() == 0 {
+		return
+	}
+	self := targetAbstract{targetEngine.Abstract(e.TypeID(TargetTypeLooseType), e.Ptr(x))}
+	self.TargetEach(fn)
+}
+
+// TargetFieldNameAt implements TargetAbstract.
+func (x *LooseType) TargetFieldNameAt(index int) string {
+	self := targetAbstract{targetEngine.Abstract(e.TypeID(TargetTypeLooseType), e.Ptr(x))}
+	return self.TargetFieldNameAt(index)
+}
+
+// TargetCount returns 1.
+func (x *LooseType) TargetCount() int { return 1 }
+
+// TargetTypeID returns TargetTypeLooseType.
+func (*LooseType) TargetTypeID() TargetTypeID { return TargetTypeLooseType }
+
+// TargetWalk implements TargetAbstract by delegating to
+// WalkTarget. A nil receiver is a no-op.
+func (x *LooseType) TargetWalk(fn TargetWalkerFn) (_ TargetAbstract, changed bool, err error) {
+	if x == nil {
+		return nil, false, nil
+	}
+	y, changed, err := x.WalkTarget(fn)
+	if err != nil {
+		return nil, false, err
+	}
+	return y, changed, nil
+}
+
+// WalkTarget visits the receiver with the provided callback.
+// A nil receiver is a no-op.
+func (x *LooseType) WalkTarget(fn TargetWalkerFn) (_ *LooseType, changed bool, err error) {
+	if x == nil {
+		return nil, false, nil
+	}
+	var y e.Ptr
+	_, y, changed, err = targetEngine.Execute(fn, e.TypeID(TargetTypeLooseType), e.Ptr(x), e.TypeID(TargetTypeLooseType))
+	if err != nil {
+		return nil, false, err
+	}
+	return (*LooseType)(y), changed, nil
+}
+
+// WalkTargetMorph visits the receiver with the provided callback.
+// Unlike WalkTarget, the receiver may be replaced by a value of any
+// type which implements Target. A nil receiver is a no-op.
+func (x *LooseType) WalkTargetMorph(fn TargetWalkerFn) (_ Target, changed bool, err error) {
+	if x == nil {
+		return nil, false, nil
+	}
+	id, y, changed, err := targetEngine.Execute(fn, e.TypeID(TargetTypeLooseType), e.Ptr(x), e.TypeID(TargetTypeTarget))
+	if err != nil {
+		return nil, false, err
+	}
+	if changed {
+		return targetWrap(id, y), true, nil
+	}
+	return x, false, nil
+}
+
+// InspectTarget visits the receiver with a callback which cannot
+// influence the visitation. A nil receiver is a no-op.
+func (x *LooseType) InspectTarget(fn func(x Target)) {
+	if x == nil {
+		return
+	}
+	_, _, _, _ = targetEngine.Execute(inspectTarget(fn), e.TypeID(TargetTypeLooseType), e.Ptr(x), e.TypeID(TargetTypeLooseType))
+}
+
+// ChildField returns the Child field.
+func (x *LooseType) ChildField() interface{} { return x.Child }
+
+// WithChild returns a shallow copy of the receiver, in which
+// the Child field has been replaced with v. The receiver is
+// not modified.
+func (x *LooseType) WithChild(v interface{}) *LooseType {
+	ret := *x
+	ret.Child = v
+	return &ret
+}
+
+// TargetAt implements TargetAbstract.
+func (x *PairType) TargetAt(index int) TargetAbstract {
+	self := targetAbstract{targetEngine.Abstract(e.TypeID(TargetTypePairType), e.Ptr(x))}
+	return self.TargetAt(index)
+}
+
+// TargetNamed implements TargetAbstract.
+func (x *PairType) TargetNamed(name string) TargetAbstract {
+	self := targetAbstract{targetEngine.Abstract(e.TypeID(TargetTypePairType), e.Ptr(x))}
+	return self.TargetNamed(name)
+}
+
+// TargetEach implements TargetAbstract.
+func (x *PairType) TargetEach(fn func(index int, child TargetAbstract) bool) {
+	if x.TargetCount() == 0 {
+		return
+	}
+	self := targetAbstract{targetEngine.Abstract(e.TypeID(TargetTypePairType), e.Ptr(x))}
+	self.TargetEach(fn)
+}
+
+// TargetFieldNameAt implements TargetAbstract.
+func (x *PairType) TargetFieldNameAt(index int) string {
+	self := targetAbstract{targetEngine.Abstract(e.TypeID(TargetTypePairType), e.Ptr(x))}
+	return self.TargetFieldNameAt(index)
+}
+
+// TargetCount returns 1.
+func (x *PairType) TargetCount() int { return 1 }
+
+// TargetTypeID returns TargetTypePairType.
+func (*PairType) TargetTypeID() TargetTypeID { return TargetTypePairType }
+
+// TargetWalk implements TargetAbstract by delegating to
+// WalkTarget. A nil receiver is a no-op.
+func (x *PairType) TargetWalk(fn TargetWalkerFn) (_ TargetAbstract, changed bool, err error) {
+	if x == nil {
+		return nil, false, nil
+	}
+	y, changed, err := x.WalkTarget(fn)
+	if err != nil {
+		return nil, false, err
+	}
+	return y, changed, nil
+}
+
+// WalkTarget visits the receiver with the provided callback.
+// A nil receiver is a no-op.
+func (x *PairType) WalkTarget(fn TargetWalkerFn) (_ *PairType, changed bool, err error) {
+	if x == nil {
+		return nil, false, nil
+	}
+	var y e.Ptr
+	_, y, changed, err = targetEngine.Execute(fn, e.TypeID(TargetTypePairType), e.Ptr(x), e.TypeID(TargetTypePairType))
+	if err != nil {
+		return nil, false, err
+	}
+	return (*PairType)(y), changed, nil
+}
+
+// WalkTargetMorph visits the receiver with the provided callback.
+// Unlike WalkTarget, the receiver may be replaced by a value of any
+// type which implements Target. A nil receiver is a no-op.
+func (x *PairType) WalkTargetMorph(fn TargetWalkerFn) (_ Target, changed bool, err error) {
+	if x == nil {
+		return nil, false, nil
+	}
+	id, y, changed, err := targetEngine.Execute(fn, e.TypeID(TargetTypePairType), e.Ptr(x), e.TypeID(TargetTypeTarget))
+	if err != nil {
+		return nil, false, err
+	}
+	if changed {
+		return targetWrap(id, y), true, nil
+	}
+	return x, false, nil
+}
+
+// InspectTarget visits the receiver with a callback which cannot
+// influence the visitation. A nil receiver is a no-op.
+func (x *PairType) InspectTarget(fn func(x Target)) {
+	if x == nil {
+		return
+	}
+	_, _, _, _ = targetEngine.Execute(inspectTarget(fn), e.TypeID(TargetTypePairType), e.Ptr(x), e.TypeID(TargetTypePairType))
+}
+
+// PairField returns a pointer to the Pair field.
+func (x *PairType) PairField() *[2]ByRefType { return &x.Pair }
+
+// WithPair returns a shallow copy of the receiver, in which
+// the Pair field has been replaced with v. The receiver is
+// not modified.
+func (x *PairType) WithPair(v [2]ByRefType) *PairType {
+	ret := *x
+	ret.Pair = v
+	return &ret
+}
+
+// TargetAt implements TargetAbstract.
+func (x *ScopeType) TargetAt(index int) TargetAbstract {
+	self := targetAbstract{targetEngine.Abstract(e.TypeID(TargetTypeScopeType), e.Ptr(x))}
+	return self.TargetAt(index)
+}
+
+// TargetNamed implements TargetAbstract.
+func (x *ScopeType) TargetNamed(name string) TargetAbstract {
+	self := targetAbstract{targetEngine.Abstract(e.TypeID(TargetTypeScopeType), e.Ptr(x))}
+	return self.TargetNamed(name)
+}
+
+// TargetEach implements TargetAbstract.
+func (x *ScopeType) TargetEach(fn func(index int, child TargetAbstract) bool) {
+	if x.TargetCount() == 0 {
+		return
+	}
+	self := targetAbstract{targetEngine.Abstract(e.TypeID(TargetTypeScopeType), e.Ptr(x))}
+	self.TargetEach(fn)
+}
+
+// TargetFieldNameAt implements TargetAbstract.
+func (x *ScopeType) TargetFieldNameAt(index int) string {
+	self := targetAbstract{targetEngine.Abstract(e.TypeID(TargetTypeScopeType), e.Ptr(x))}
+	return self.TargetFieldNameAt(index)
+}
+
+// TargetCount returns 1.
+func (x *ScopeType) TargetCount() int { return 1 }
+
+// TargetTypeID returns TargetTypeScopeType.
+func (*ScopeType) TargetTypeID() TargetTypeID { return TargetTypeScopeType }
+
+// TargetWalk implements TargetAbstract by delegating to
+// WalkTarget. A nil receiver is a no-op.
+func (x *ScopeType) TargetWalk(fn TargetWalkerFn) (_ TargetAbstract, changed bool, err error) {
+	if x == nil {
+		return nil, false, nil
+	}
+	y, changed, err := x.WalkTarget(fn)
+	if err != nil {
+		return nil, false, err
+	}
+	return y, changed, nil
+}
+
+// WalkTarget visits the receiver with the provided callback.
+// A nil receiver is a no-op.
+func (x *ScopeType) WalkTarget(fn TargetWalkerFn) (_ *ScopeType, changed bool, err error) {
+	if x == nil {
+		return nil, false, nil
+	}
+	var y e.Ptr
+	_, y, changed, err = targetEngine.Execute(fn, e.TypeID(TargetTypeScopeType), e.Ptr(x), e.TypeID(TargetTypeScopeType))
+	if err != nil {
+		return nil, false, err
+	}
+	return (*ScopeType)(y), changed, nil
+}
+
+// WalkTargetMorph visits the receiver with the provided callback.
+// Unlike WalkTarget, the receiver may be replaced by a value of any
+// type which implements Target. A nil receiver is a no-op.
+func (x *ScopeType) WalkTargetMorph(fn TargetWalkerFn) (_ Target, changed bool, err error) {
+	if x == nil {
+		return nil, false, nil
+	}
+	id, y, changed, err := targetEngine.Execute(fn, e.TypeID(TargetTypeScopeType), e.Ptr(x), e.TypeID(TargetTypeTarget))
+	if err != nil {
+		return nil, false, err
+	}
+	if changed {
+		return targetWrap(id, y), true, nil
+	}
+	return x, false, nil
+}
+
+// InspectTarget visits the receiver with a callback which cannot
+// influence the visitation. A nil receiver is a no-op.
+func (x *ScopeType) InspectTarget(fn func(x Target)) {
+	if x == nil {
+		return
+	}
+	_, _, _, _ = targetEngine.Execute(inspectTarget(fn), e.TypeID(TargetTypeScopeType), e.Ptr(x), e.TypeID(TargetTypeScopeType))
+}
+
+// EnvField returns the Env field.
+func (x *ScopeType) EnvField() map[string]Target { return x.Env }
+
+// WithEnv returns a shallow copy of the receiver, in which
+// the Env field has been replaced with v. The receiver is
+// not modified.
+func (x *ScopeType) WithEnv(v map[string]Target) *ScopeType {
+	ret := *x
+	ret.Env = v
+	return &ret
+}
+
+// EnvValue returns the entry of the Env field with the
+// given key, and whether that entry is present.
+func (x *ScopeType) EnvValue(key string) (Target, bool) {
+	v, ok := x.Env[key]
+	return v, ok
+}
+
+// WithEnvValue returns a shallow copy of the receiver, in which
+// the entry of the Env field with the given key has been
+// replaced with v. The map is copied, so the receiver is not modified.
+// Returning the result from a callback, via a Decision's Replace
+// method, replaces a single entry of the map.
+func (x *ScopeType) WithEnvValue(key string, v Target) *ScopeType {
+	ret := *x
+	ret.Env = make(map[string]Target, len(x.Env)+1)
+	for k, elem := range x.Env {
+		ret.Env[k] = elem
+	}
+	ret.Env[key] = v
+	return &ret
+}
+
+// TargetAt implements TargetAbstract.
+func (x *WrapperType) TargetAt(index int) TargetAbstract {
+	self := targetAbstract{targetEngine.Abstract(e.TypeID(TargetTypeWrapperType), e.Ptr(x))}
+	return self.TargetAt(index)
+}
+
+// TargetNamed implements TargetAbstract.
+func (x *WrapperType) TargetNamed(name string) TargetAbstract {
+	self := targetAbstract{targetEngine.Abstract(e.TypeID(TargetTypeWrapperType), e.Ptr(x))}
+	return self.TargetNamed(name)
+}
+
+// TargetEach implements TargetAbstract.
+func (x *WrapperType) TargetEach(fn func(index int, child TargetAbstract) bool) {
+	if x.TargetCount() == 0 {
+		return
+	}
+	self := targetAbstract{targetEngine.Abstract(e.TypeID(TargetTypeWrapperType), e.Ptr(x))}
+	self.TargetEach(fn)
+}
+
+// TargetFieldNameAt implements TargetAbstract.
+func (x *WrapperType) TargetFieldNameAt(index int) string {
+	self := targetAbstract{targetEngine.Abstract(e.TypeID(TargetTypeWrapperType), e.Ptr(x))}
+	return self.TargetFieldNameAt(index)
+}
+
+// TargetCount returns 1.
+func (x *WrapperType) TargetCount() int { return 1 }
+
+// TargetTypeID returns TargetTypeWrapperType.
+func (*WrapperType) TargetTypeID() TargetTypeID { return TargetTypeWrapperType }
+
+// TargetWalk implements TargetAbstract by delegating to
+// WalkTarget. A nil receiver is a no-op.
+func (x *WrapperType) TargetWalk(fn TargetWalkerFn) (_ TargetAbstract, changed bool, err error) {
+	if x == nil {
+		return nil, false, nil
+	}
+	y, changed, err := x.WalkTarget(fn)
+	if err != nil {
+		return nil, false, err
+	}
+	return y, changed, nil
+}
+
+// WalkTarget visits the receiver with the provided callback.
+// A nil receiver is a no-op.
+func (x *WrapperType) WalkTarget(fn TargetWalkerFn) (_ *WrapperType, changed bool, err error) {
+	if x == nil {
+		return nil, false, nil
+	}
+	var y e.Ptr
+	_, y, changed, err = targetEngine.Execute(fn, e.TypeID(TargetTypeWrapperType), e.Ptr(x), e.TypeID(TargetTypeWrapperType))
+	if err != nil {
+		return nil, false, err
+	}
+	return (*WrapperType)(y), changed, nil
+}
+
+// WalkTargetMorph visits the receiver with the provided callback.
+// Unlike WalkTarget, the receiver may be replaced by a value of any
+// type which implements Target. A nil receiver is a no-op.
+func (x *WrapperType) WalkTargetMorph(fn TargetWalkerFn) (_ Target, changed bool, err error) {
+	if x == nil {
+		return nil, false, nil
+	}
+	id, y, changed, err := targetEngine.Execute(fn, e.TypeID(TargetTypeWrapperType), e.Ptr(x), e.TypeID(TargetTypeTarget))
+	if err != nil {
+		return nil, false, err
+	}
+	if changed {
+		return targetWrap(id, y), true, nil
+	}
+	return x, false, nil
+}
+
+// InspectTarget visits the receiver with a callback which cannot
+// influence the visitation. A nil receiver is a no-op.
+func (x *WrapperType) InspectTarget(fn func(x Target)) {
+	if x == nil {
+		return
+	}
+	_, _, _, _ = targetEngine.Execute(inspectTarget(fn), e.TypeID(TargetTypeWrapperType), e.Ptr(x), e.TypeID(TargetTypeWrapperType))
+}
+
+// TargetField returns the Target field.
+func (x *WrapperType) TargetField() Target { return x.Target }
+
+// WithTarget returns a shallow copy of the receiver, in which
+// the Target field has been replaced with v. The receiver is
+// not modified.
+func (x *WrapperType) WithTarget(v Target) *WrapperType {
+	ret := *x
+	ret.Target = v
+	return &ret
+}
+
+// WalkTarget visits the receiver with the provided callback.
+// A nil value, or a typed-nil pointer, is returned as-is without
+// invoking the callback.
+func WalkTarget(x Target, fn TargetWalkerFn) (_ Target, changed bool, err error) {
+	return walkTarget(x, fn)
+}
+
+// walkTarget implements WalkTarget and those of its variations
+// which differ only in the options that they pass to the engine.
+func walkTarget(x Target, fn TargetWalkerFn, opts ...e.Option) (_ Target, changed bool, err error) {
+	if x == nil {
+		return nil, false, nil
+	}
+	id, ptr := targetIdentify(x)
+	if ptr == nil {
+		return x, false, nil
+	}
+	id, ptr, changed, err = targetEngine.Execute(fn, id, ptr, e.TypeID(TargetTypeTarget), opts...)
+	if err != nil {
+		return nil, false, err
+	}
+	if changed {
+		return targetWrap(id, ptr), true, nil
+	}
+	return x, false, nil
+}
+
+// InspectTarget visits x with a callback which cannot influence
+// the visitation, so that every value will be visited. A nil value,
+// or a typed-nil pointer, is ignored.
+func InspectTarget(x Target, fn func(x Target)) {
+	if x == nil {
+		return
+	}
+	id, ptr := targetIdentify(x)
+	if ptr == nil {
+		return
+	}
+	_, _, _, _ = targetEngine.Execute(inspectTarget(fn), id, ptr, id)
+}
+
+// inspectTarget adapts a callback for InspectTarget into a
+// TargetWalkerFn which always continues.
+func inspectTarget(fn func(x Target)) TargetWalkerFn {
+	return func(_ TargetContext, x Target) (d TargetDecision) {
+		fn(x)
+		return
+	}
+}
+
+// WalkTargetCtx visits x with the provided callback, stopping with
+// the context's error once the context has been cancelled. Replacements
+// made before the cancellation are discarded, although values which were
+// replaced in place will remain changed.
+func WalkTargetCtx(ctx context.Context, x Target, fn TargetWalkerFn) (_ Target, changed bool, err error) {
+	if err := ctx.Err(); err != nil {
+		return nil, false, err
+	}
+	// A context which can never be cancelled need not be checked, which
+	// keeps the walk allocation-free.
+	if ctx.Done() == nil {
+		return walkTarget(x, fn)
+	}
+	return walkTarget(x, fn, e.WithContext(ctx))
+}
+
+// WalkTargetLifecycle visits x with the provided callback, in the
+// same manner as WalkTarget. The onStart function is invoked before
+// the callback is first invoked, and onEnd is invoked once the walk
+// has completed, with the error which will be returned. Either
+// function may be nil. Both functions are invoked even if x is nil.
+func WalkTargetLifecycle(x Target, onStart func(), onEnd func(err error), fn TargetWalkerFn) (_ Target, changed bool, err error) {
+	if onStart != nil {
+		onStart()
+	}
+	x, changed, err = WalkTarget(x, fn)
+	if onEnd != nil {
+		onEnd(err)
+	}
+	return x, changed, err
+}
+
+// ------ Finding ------
+
+// FindAllByRefTypeInTarget returns every ByRefType within root,
+// including root itself, in the order that they would be visited.
+func FindAllByRefTypeInTarget(root Target) []*ByRefType {
+	if root == nil {
+		return nil
+	}
+	id, ptr := targetIdentify(root)
+	if ptr == nil {
+		return nil
+	}
+	var ret []*ByRefType
+	fn := TargetWalkerFn(func(ctx TargetContext, x Target) TargetDecision {
+		ret = append(ret, x.(*ByRefType))
+		return ctx.Continue()
+	})
+	if _, _, _, err := targetEngine.Execute(fn, id, ptr, id, e.WithTypes(e.TypeID(TargetTypeByRefType))); err != nil {
+		// The callback makes no changes, so this indicates a problem
+		// with code-generation.
+		panic(err)
+	}
+	return ret
+}
+
+// FindAllByValTypeInTarget returns every ByValType within root,
+// including root itself, in the order that they would be visited.
+func FindAllByValTypeInTarget(root Target) []*ByValType {
+	if root == nil {
+		return nil
+	}
+	id, ptr := targetIdentify(root)
+	if ptr == nil {
+		return nil
+	}
+	var ret []*ByValType
+	fn := TargetWalkerFn(func(ctx TargetContext, x Target) TargetDecision {
+		ret = append(ret, x.(*ByValType))
+		return ctx.Continue()
+	})
+	if _, _, _, err := targetEngine.Execute(fn, id, ptr, id, e.WithTypes(e.TypeID(TargetTypeByValType))); err != nil {
+		// The callback makes no changes, so this indicates a problem
+		// with code-generation.
+		panic(err)
+	}
+	return ret
+}
+
+// FindAllContainerTypeInTarget returns every ContainerType within root,
+// including root itself, in the order that they would be visited.
+func FindAllContainerTypeInTarget(root Target) []*ContainerType {
+	if root == nil {
+		return nil
+	}
+	id, ptr := targetIdentify(root)
+	if ptr == nil {
+		return nil
+	}
+	var ret []*ContainerType
+	fn := TargetWalkerFn(func(ctx TargetContext, x Target) TargetDecision {
+		ret = append(ret, x.(*ContainerType))
+		return ctx.Continue()
+	})
+	if _, _, _, err := targetEngine.Execute(fn, id, ptr, id, e.WithTypes(e.TypeID(TargetTypeContainerType))); err != nil {
+		// The callback makes no changes, so this indicates a problem
+		// with code-generation.
+		panic(err)
+	}
+	return ret
+}
+
+// FindAllEmbeddingTypeInTarget returns every EmbeddingType within root,
+// including root itself, in the order that they would be visited.
+func FindAllEmbeddingTypeInTarget(root Target) []*EmbeddingType {
+	if root == nil {
+		return nil
+	}
+	id, ptr := targetIdentify(root)
+	if ptr == nil {
+		return nil
+	}
+	var ret []*EmbeddingType
+	fn := TargetWalkerFn(func(ctx TargetContext, x Target) TargetDecision {
+		ret = append(ret, x.(*EmbeddingType))
+		return ctx.Continue()
+	})
+	if _, _, _, err := targetEngine.Execute(fn, id, ptr, id, e.WithTypes(e.TypeID(TargetTypeEmbeddingType))); err != nil {
+		// The callback makes no changes, so this indicates a problem
+		// with code-generation.
+		panic(err)
+	}
+	return ret
+}
+
+// FindAllEncapsulatedTypeInTarget returns every EncapsulatedType within root,
+// including root itself, in the order that they would be visited.
+func FindAllEncapsulatedTypeInTarget(root Target) []*EncapsulatedType {
+	if root == nil {
+		return nil
+	}
+	id, ptr := targetIdentify(root)
+	if ptr == nil {
+		return nil
+	}
+	var ret []*EncapsulatedType
+	fn := TargetWalkerFn(func(ctx TargetContext, x Target) TargetDecision {
+		ret = append(ret, x.(*EncapsulatedType))
+		return ctx.Continue()
+	})
+	if _, _, _, err := targetEngine.Execute(fn, id, ptr, id, e.WithTypes(e.TypeID(TargetTypeEncapsulatedType))); err != nil {
+		// The callback makes no changes, so this indicates a problem
+		// with code-generation.
+		panic(err)
+	}
+	return ret
+}
+
+// FindAllLooseTypeInTarget returns every LooseType within root,
+// including root itself, in the order that they would be visited.
+func FindAllLooseTypeInTarget(root Target) []*LooseType {
+	if root == nil {
+		return nil
+	}
+	id, ptr := targetIdentify(root)
+	if ptr == nil {
+		return nil
+	}
+	var ret []*LooseType
+	fn := TargetWalkerFn(func(ctx TargetContext, x Target) TargetDecision {
+		ret = append(ret, x.(*LooseType))
+		return ctx.Continue()
+	})
+	if _, _, _, err := targetEngine.Execute(fn, id, ptr, id, e.WithTypes(e.TypeID(TargetTypeLooseType))); err != nil {
+		// The callback makes no changes, so this indicates a problem
+		// with code-generation.
+		panic(err)
+	}
+	return ret
+}
+
+// FindAllPairTypeInTarget returns every PairType within root,
+// including root itself, in the order that they would be visited.
+func FindAllPairTypeInTarget(root Target) []*PairType {
+	if root == nil {
+		return nil
+	}
+	id, ptr := targetIdentify(root)
+	if ptr == nil {
+		return nil
+	}
+	var ret []*PairType
+	fn := TargetWalkerFn(func(ctx TargetContext, x Target) TargetDecision {
+		ret = append(ret, x.(*PairType))
+		return ctx.Continue()
+	})
+	if _, _, _, err := targetEngine.Execute(fn, id, ptr, id, e.WithTypes(e.TypeID(TargetTypePairType))); err != nil {
+		// The callback makes no changes, so this indicates a problem
+		// with code-generation.
+		panic(err)
+	}
+	return ret
+}
+
+// FindAllScopeTypeInTarget returns every ScopeType within root,
+// including root itself, in the order that they would be visited.
+func FindAllScopeTypeInTarget(root Target) []*ScopeType {
+	if root == nil {
+		return nil
+	}
+	id, ptr := targetIdentify(root)
+	if ptr == nil {
+		return nil
+	}
+	var ret []*ScopeType
+	fn := TargetWalkerFn(func(ctx TargetContext, x Target) TargetDecision {
+		ret = append(ret, x.(*ScopeType))
+		return ctx.Continue()
+	})
+	if _, _, _, err := targetEngine.Execute(fn, id, ptr, id, e.WithTypes(e.TypeID(TargetTypeScopeType))); err != nil {
+		// The callback makes no changes, so this indicates a problem
+		// with code-generation.
+		panic(err)
+	}
+	return ret
+}
+
+// FindAllWrapperTypeInTarget returns every WrapperType within root,
+// including root itself, in the order that they would be visited.
+func FindAllWrapperTypeInTarget(root Target) []*WrapperType {
+	if root == nil {
+		return nil
+	}
+	id, ptr := targetIdentify(root)
+	if ptr == nil {
+		return nil
+	}
+	var ret []*WrapperType
+	fn := TargetWalkerFn(func(ctx TargetContext, x Target) TargetDecision {
+		ret = append(ret, x.(*WrapperType))
+		return ctx.Continue()
+	})
+	if _, _, _, err := targetEngine.Execute(fn, id, ptr, id, e.WithTypes(e.TypeID(TargetTypeWrapperType))); err != nil {
+		// The callback makes no changes, so this indicates a problem
+		// with code-generation.
+		panic(err)
+	}
+	return ret
+}
+
+// ------ Allocation ------
+
+// TargetAllocator returns a value of the given struct type, whose
+// contents will be overwritten. It may return nil to allocate a new
+// struct as usual.
+type TargetAllocator func(id TargetTypeID) Target
+
+// WalkTargetAlloc visits x with the provided callback. Whenever a
+// struct must be copied in order to replace one of its children, the
+// memory for the copy is obtained from alloc. This allows callers
+// which perform many rewrites to reuse structs which they know to be
+// unreferenced, e.g. from a previous result which has been discarded.
+// The allocator will be called with a lock held if the callback
+// requests parallel visitation, and it will panic if alloc returns a
+// value of a different type.
+func WalkTargetAlloc(x Target, alloc TargetAllocator, fn TargetWalkerFn) (_ Target, changed bool, err error) {
+	return walkTarget(x, fn, e.WithAllocator(func(want e.TypeID) e.Ptr {
+		y := alloc(TargetTypeID(want))
+		if y == nil {
+			return nil
+		}
+		got, ptr := targetIdentify(y)
+		if got != want {
+			panic(fmt.Sprintf("allocator returned %T for %s", y, TargetTypeID(want)))
+		}
+		return ptr
+	}))
+}
+
+// ------ Binary Encoding ------
+
+// targetEncoder writes visitable values by delegating to the engine.
+type targetEncoder struct {
+	*e.Encoder
+}
+
+// targetDecoder reads visitable values by delegating to the engine.
+type targetDecoder struct {
+	*e.Decoder
+}
+
+// EncodeTarget writes root, and all of the visitable values which
+// are reachable from it, to w in a compact binary format. The exported
+// boolean, numeric, and string fields of each struct are also written;
+// any other non-visitable fields are not. Values which are referred to
+// by multiple pointers, including cyclical references, are only written
+// once. The encoding depends upon the generated type tokens, so it
+// should only be decoded by the same generated code.
+func EncodeTarget(w io.Writer, root Target) error {
+	enc := targetEncoder{e.NewEncoder(w)}
+	enc.encodeTargetTypeTarget(e.Ptr(&root))
+	return enc.Flush()
+}
+
+// DecodeTarget reads a value written by EncodeTarget.
+func DecodeTarget(r io.Reader) (Target, error) {
+	dec := targetDecoder{e.NewDecoder(r)}
+	var ret Target
+	dec.decodeTargetTypeTarget(e.Ptr(&ret))
+	if err := dec.Err(); err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+func (enc targetEncoder) encodeTargetTypeByRefType(x e.Ptr) {
+	s := (*ByRefType)(x)
+	enc.WriteString(string(s.Val))
+}
+
+func (dec targetDecoder) decodeTargetTypeByRefType(x e.Ptr) {
+	s := (*ByRefType)(x)
+	s.Val = string(dec.ReadString())
+}
+
+func (enc targetEncoder) encodeTargetTypeByValType(x e.Ptr) {
+	s := (*ByValType)(x)
+	enc.WriteString(string(s.Val))
+}
+
+func (dec targetDecoder) decodeTargetTypeByValType(x e.Ptr) {
+	s := (*ByValType)(x)
+	s.Val = string(dec.ReadString())
+}
+
+func (enc targetEncoder) encodeTargetTypeContainerType(x e.Ptr) {
+	s := (*ContainerType)(x)
+	enc.encodeTargetTypeByRefType(e.Ptr(&s.ByRef))
+	enc.encodeTargetTypeByRefTypePtr(e.Ptr(&s.ByRefPtr))
+	enc.encodeTargetTypeByRefTypeSlice(e.Ptr(&s.ByRefSlice))
+	enc.encodeTargetTypeByRefTypePtrSlice(e.Ptr(&s.ByRefPtrSlice))
+	enc.encodeTargetTypeByValType(e.Ptr(&s.ByVal))
+	enc.encodeTargetTypeByValTypePtr(e.Ptr(&s.ByValPtr))
+	enc.encodeTargetTypeByValTypeSlice(e.Ptr(&s.ByValSlice))
+	enc.encodeTargetTypeByValTypePtrSlice(e.Ptr(&s.ByValPtrSlice))
+	enc.encodeTargetTypeContainerTypePtr(e.Ptr(&s.Container))
+	enc.encodeTargetTypeTarget(e.Ptr(&s.AnotherTarget))
+	enc.encodeTargetTypeTargetPtr(e.Ptr(&s.AnotherTargetPtr))
+	enc.encodeTargetTypeEmbedsTarget(e.Ptr(&s.EmbedsTarget))
+	enc.encodeTargetTypeEmbedsTargetPtr(e.Ptr(&s.EmbedsTargetPtr))
+	enc.encodeTargetTypeTargetSlice(e.Ptr(&s.TargetSlice))
+	enc.encodeTargetTypeTargetPtrSlice(e.Ptr(&s.InterfacePtrSlice))
+	enc.encodeTargetTypeTargetSlice(e.Ptr(&s.NamedTargets))
+	enc.encodeTargetTypeTargetArray4(e.Ptr(&s.Quad))
+	enc.encodeTargetTypeByRefTypePtr(e.Ptr(&s.OptTarget))
+	enc.encodeTargetTypeAnnotated(e.Ptr(&s.Annotated))
+}
+
+func (dec targetDecoder) decodeTargetTypeContainerType(x e.Ptr) {
+	s := (*ContainerType)(x)
+	dec.decodeTargetTypeByRefType(e.Ptr(&s.ByRef))
+	dec.decodeTargetTypeByRefTypePtr(e.Ptr(&s.ByRefPtr))
+	dec.decodeTargetTypeByRefTypeSlice(e.Ptr(&s.ByRefSlice))
+	dec.decodeTargetTypeByRefTypePtrSlice(e.Ptr(&s.ByRefPtrSlice))
+	dec.decodeTargetTypeByValType(e.Ptr(&s.ByVal))
+	dec.decodeTargetTypeByValTypePtr(e.Ptr(&s.ByValPtr))
+	dec.decodeTargetTypeByValTypeSlice(e.Ptr(&s.ByValSlice))
+	dec.decodeTargetTypeByValTypePtrSlice(e.Ptr(&s.ByValPtrSlice))
+	dec.decodeTargetTypeContainerTypePtr(e.Ptr(&s.Container))
+	dec.decodeTargetTypeTarget(e.Ptr(&s.AnotherTarget))
+	dec.decodeTargetTypeTargetPtr(e.Ptr(&s.AnotherTargetPtr))
+	dec.decodeTargetTypeEmbedsTarget(e.Ptr(&s.EmbedsTarget))
+	dec.decodeTargetTypeEmbedsTargetPtr(e.Ptr(&s.EmbedsTargetPtr))
+	dec.decodeTargetTypeTargetSlice(e.Ptr(&s.TargetSlice))
+	dec.decodeTargetTypeTargetPtrSlice(e.Ptr(&s.InterfacePtrSlice))
+	dec.decodeTargetTypeTargetSlice(e.Ptr(&s.NamedTargets))
+	dec.decodeTargetTypeTargetArray4(e.Ptr(&s.Quad))
+	dec.decodeTargetTypeByRefTypePtr(e.Ptr(&s.OptTarget))
+	dec.decodeTargetTypeAnnotated(e.Ptr(&s.Annotated))
+}
+
+func (enc targetEncoder) encodeTargetTypeEmbeddingType(x e.Ptr) {
+	s := (*EmbeddingType)(x)
+	enc.encodeTargetTypeTarget(e.Ptr(&s.Promoted))
+	enc.encodeTargetTypeTargetSlice(e.Ptr(&s.Promoteds))
+	enc.encodeTargetTypeTarget(e.Ptr(&s.Scoped))
+	enc.encodeTargetTypeTarget(e.Ptr(&s.Own))
+}
+
+func (dec targetDecoder) decodeTargetTypeEmbeddingType(x e.Ptr) {
+	s := (*EmbeddingType)(x)
+	dec.decodeTargetTypeTarget(e.Ptr(&s.Promoted))
+	dec.decodeTargetTypeTargetSlice(e.Ptr(&s.Promoteds))
+	dec.decodeTargetTypeTarget(e.Ptr(&s.Scoped))
+	dec.decodeTargetTypeTarget(e.Ptr(&s.Own))
+}
+
+func (enc targetEncoder) encodeTargetTypeEncapsulatedType(x e.Ptr) {
+}
+
+func (dec targetDecoder) decodeTargetTypeEncapsulatedType(x e.Ptr) {
+}
+
+func (enc targetEncoder) encodeTargetTypeLooseType(x e.Ptr) {
+	s := (*LooseType)(x)
+	enc.encodeTargetTypeAnyOfByRefTypeOrByValType(e.Ptr(&s.Child))
+}
+
+func (dec targetDecoder) decodeTargetTypeLooseType(x e.Ptr) {
+	s := (*LooseType)(x)
+	dec.decodeTargetTypeAnyOfByRefTypeOrByValType(e.Ptr(&s.Child))
+}
+
+func (enc targetEncoder) encodeTargetTypePairType(x e.Ptr) {
+	s := (*PairType)(x)
+	enc.encodeTargetTypeByRefTypeArray2(e.Ptr(&s.Pair))
+}
+
+func (dec targetDecoder) decodeTargetTypePairType(x e.Ptr) {
+	s := (*PairType)(x)
+	dec.decodeTargetTypeByRefTypeArray2(e.Ptr(&s.Pair))
+}
+
+func (enc targetEncoder) encodeTargetTypeScopeType(x e.Ptr) {
+	s := (*ScopeType)(x)
+	enc.encodeTargetTypeTargetMapByString(e.Ptr(&s.Env))
+}
+
+func (dec targetDecoder) decodeTargetTypeScopeType(x e.Ptr) {
+	s := (*ScopeType)(x)
+	dec.decodeTargetTypeTargetMapByString(e.Ptr(&s.Env))
+}
+
+func (enc targetEncoder) encodeTargetTypeWrapperType(x e.Ptr) {
+	s := (*WrapperType)(x)
+	enc.WriteString(string(s.Name))
+	enc.encodeTargetTypeTarget(e.Ptr(&s.Target))
+}
+
+func (dec targetDecoder) decodeTargetTypeWrapperType(x e.Ptr) {
+	s := (*WrapperType)(x)
+	s.Name = string(dec.ReadString())
+	dec.decodeTargetTypeTarget(e.Ptr(&s.Target))
+}
+
+func (enc targetEncoder) encodeTargetTypeAnnotated(x e.Ptr) {
+	switch t := (*(*Annotated)(x)).(type) {
+	case nil:
+		enc.WriteUint(0)
+	case *ByRefType:
+		enc.WriteUint(uint64(TargetTypeByRefTypePtr))
+		enc.encodeTargetTypeByRefTypePtr(e.Ptr(&t))
+	default:
+		panic(fmt.Sprintf("unhandled value of type: %T", t))
+	}
+}
+
+func (dec targetDecoder) decodeTargetTypeAnnotated(x e.Ptr) {
+	switch id := TargetTypeID(dec.ReadUint()); id {
+	case 0:
+		*(*Annotated)(x) = nil
+	case TargetTypeByRefTypePtr:
+		var t *ByRefType
+		dec.decodeTargetTypeByRefTypePtr(e.Ptr(&t))
+		*(*Annotated)(x) = t
+	default:
+		dec.Fail(fmt.Errorf("unexpected type token %d for Annotated", id))
+	}
+}
+
+func (enc targetEncoder) encodeTargetTypeAnyOfByRefTypeOrByValType(x e.Ptr) {
+	switch t := (*(*interface{})(x)).(type) {
+	case nil:
+		enc.WriteUint(0)
+	case ByRefType:
+		enc.WriteUint(uint64(TargetTypeByRefType))
+		enc.encodeTargetTypeByRefType(e.Ptr(&t))
+	case *ByRefType:
+		enc.WriteUint(uint64(TargetTypeByRefTypePtr))
+		enc.encodeTargetTypeByRefTypePtr(e.Ptr(&t))
+	case ByValType:
+		enc.WriteUint(uint64(TargetTypeByValType))
+		enc.encodeTargetTypeByValType(e.Ptr(&t))
+	case *ByValType:
+		enc.WriteUint(uint64(TargetTypeByValTypePtr))
+		enc.encodeTargetTypeByValTypePtr(e.Ptr(&t))
+	default:
+		panic(fmt.Sprintf("unhandled value of type: %T", t))
+	}
+}
+
+func (dec targetDecoder) decodeTargetTypeAnyOfByRefTypeOrByValType(x e.Ptr) {
+	switch id := TargetTypeID(dec.ReadUint()); id {
+	case 0:
+		*(*interface{})(x) = nil
+	case TargetTypeByRefType:
+		var t ByRefType
+		dec.decodeTargetTypeByRefType(e.Ptr(&t))
+		*(*interface{})(x) = t
+	case TargetTypeByRefTypePtr:
+		var t *ByRefType
+		dec.decodeTargetTypeByRefTypePtr(e.Ptr(&t))
+		*(*interface{})(x) = t
+	case TargetTypeByValType:
+		var t ByValType
+		dec.decodeTargetTypeByValType(e.Ptr(&t))
+		*(*interface{})(x) = t
+	case TargetTypeByValTypePtr:
+		var t *ByValType
+		dec.decodeTargetTypeByValTypePtr(e.Ptr(&t))
+		*(*interface{})(x) = t
+	default:
+		dec.Fail(fmt.Errorf("unexpected type token %d for interface{}", id))
+	}
+}
+
+func (enc targetEncoder) encodeTargetTypeEmbedsTarget(x e.Ptr) {
+	switch t := (*(*EmbedsTarget)(x)).(type) {
+	case nil:
+		enc.WriteUint(0)
+	case ByValType:
+		enc.WriteUint(uint64(TargetTypeByValType))
+		enc.encodeTargetTypeByValType(e.Ptr(&t))
+	case *ByValType:
+		enc.WriteUint(uint64(TargetTypeByValTypePtr))
+		enc.encodeTargetTypeByValTypePtr(e.Ptr(&t))
+	default:
+		panic(fmt.Sprintf("unhandled value of type: %T", t))
+	}
+}
+
+func (dec targetDecoder) decodeTargetTypeEmbedsTarget(x e.Ptr) {
+	switch id := TargetTypeID(dec.ReadUint()); id {
+	case 0:
+		*(*EmbedsTarget)(x) = nil
+	case TargetTypeByValType:
+		var t ByValType
+		dec.decodeTargetTypeByValType(e.Ptr(&t))
+		*(*EmbedsTarget)(x) = t
+	case TargetTypeByValTypePtr:
+		var t *ByValType
+		dec.decodeTargetTypeByValTypePtr(e.Ptr(&t))
+		*(*EmbedsTarget)(x) = t
+	default:
+		dec.Fail(fmt.Errorf("unexpected type token %d for EmbedsTarget", id))
+	}
+}
+
+func (enc targetEncoder) encodeTargetTypeTarget(x e.Ptr) {
+	switch t := (*(*Target)(x)).(type) {
+	case nil:
+		enc.WriteUint(0)
+	case *ByRefType:
+		enc.WriteUint(uint64(TargetTypeByRefTypePtr))
+		enc.encodeTargetTypeByRefTypePtr(e.Ptr(&t))
+	case ByValType:
+		enc.WriteUint(uint64(TargetTypeByValType))
+		enc.encodeTargetTypeByValType(e.Ptr(&t))
+	case *ByValType:
+		enc.WriteUint(uint64(TargetTypeByValTypePtr))
+		enc.encodeTargetTypeByValTypePtr(e.Ptr(&t))
+	case *ContainerType:
+		enc.WriteUint(uint64(TargetTypeContainerTypePtr))
+		enc.encodeTargetTypeContainerTypePtr(e.Ptr(&t))
+	case *EmbeddingType:
+		enc.WriteUint(uint64(TargetTypeEmbeddingTypePtr))
+		enc.encodeTargetTypeEmbeddingTypePtr(e.Ptr(&t))
+	case *EncapsulatedType:
+		enc.WriteUint(uint64(TargetTypeEncapsulatedTypePtr))
+		enc.encodeTargetTypeEncapsulatedTypePtr(e.Ptr(&t))
+	case *LooseType:
+		enc.WriteUint(uint64(TargetTypeLooseTypePtr))
+		enc.encodeTargetTypeLooseTypePtr(e.Ptr(&t))
+	case *PairType:
+		enc.WriteUint(uint64(TargetTypePairTypePtr))
+		enc.encodeTargetTypePairTypePtr(e.Ptr(&t))
+	case *ScopeType:
+		enc.WriteUint(uint64(TargetTypeScopeTypePtr))
+		enc.encodeTargetTypeScopeTypePtr(e.Ptr(&t))
+	case *WrapperType:
+		enc.WriteUint(uint64(TargetTypeWrapperTypePtr))
+		enc.encodeTargetTypeWrapperTypePtr(e.Ptr(&t))
+	default:
+		panic(fmt.Sprintf("unhandled value of type: %T", t))
+	}
+}
+
+func (dec targetDecoder) decodeTargetTypeTarget(x e.Ptr) {
+	switch id := TargetTypeID(dec.ReadUint()); id {
+	case 0:
+		*(*Target)(x) = nil
+	case TargetTypeByRefTypePtr:
+		var t *ByRefType
+		dec.decodeTargetTypeByRefTypePtr(e.Ptr(&t))
+		*(*Target)(x) = t
+	case TargetTypeByValType:
+		var t ByValType
+		dec.decodeTargetTypeByValType(e.Ptr(&t))
+		*(*Target)(x) = t
+	case TargetTypeByValTypePtr:
+		var t *ByValType
+		dec.decodeTargetTypeByValTypePtr(e.Ptr(&t))
+		*(*Target)(x) = t
+	case TargetTypeContainerTypePtr:
+		var t *ContainerType
+		dec.decodeTargetTypeContainerTypePtr(e.Ptr(&t))
+		*(*Target)(x) = t
+	case TargetTypeEmbeddingTypePtr:
+		var t *EmbeddingType
+		dec.decodeTargetTypeEmbeddingTypePtr(e.Ptr(&t))
+		*(*Target)(x) = t
+	case TargetTypeEncapsulatedTypePtr:
+		var t *EncapsulatedType
+		dec.decodeTargetTypeEncapsulatedTypePtr(e.Ptr(&t))
+		*(*Target)(x) = t
+	case TargetTypeLooseTypePtr:
+		var t *LooseType
+		dec.decodeTargetTypeLooseTypePtr(e.Ptr(&t))
+		*(*Target)(x) = t
+	case TargetTypePairTypePtr:
+		var t *PairType
+		dec.decodeTargetTypePairTypePtr(e.Ptr(&t))
+		*(*Target)(x) = t
+	case TargetTypeScopeTypePtr:
+		var t *ScopeType
+		dec.decodeTargetTypeScopeTypePtr(e.Ptr(&t))
+		*(*Target)(x) = t
+	case TargetTypeWrapperTypePtr:
+		var t *WrapperType
+		dec.decodeTargetTypeWrapperTypePtr(e.Ptr(&t))
+		*(*Target)(x) = t
+	default:
+		dec.Fail(fmt.Errorf("unexpected type token %d for Target", id))
+	}
+}
+
+func (enc targetEncoder) encodeTargetTypeByRefTypePtr(x e.Ptr) {
+	p := *(**ByRefType)(x)
+	if enc.WriteRef(e.TypeID(TargetTypeByRefTypePtr), e.Ptr(p)) {
+		enc.encodeTargetTypeByRefType(e.Ptr(p))
+	}
+}
+
+func (dec targetDecoder) decodeTargetTypeByRefTypePtr(x e.Ptr) {
+	p, isNew := dec.ReadRef()
+	if isNew {
+		p = e.Ptr(new(ByRefType))
+		dec.AddRef(p)
+		dec.decodeTargetTypeByRefType(p)
+	}
+	*(**ByRefType)(x) = (*ByRefType)(p)
+}
+
+func (enc targetEncoder) encodeTargetTypeByValTypePtr(x e.Ptr) {
+	p := *(**ByValType)(x)
+	if enc.WriteRef(e.TypeID(TargetTypeByValTypePtr), e.Ptr(p)) {
+		enc.encodeTargetTypeByValType(e.Ptr(p))
+	}
+}
+
+func (dec targetDecoder) decodeTargetTypeByValTypePtr(x e.Ptr) {
+	p, isNew := dec.ReadRef()
+	if isNew {
+		p = e.Ptr(new(ByValType))
+		dec.AddRef(p)
+		dec.decodeTargetTypeByValType(p)
+	}
+	*(**ByValType)(x) = (*ByValType)(p)
+}
+
+func (enc targetEncoder) encodeTargetTypeContainerTypePtr(x e.Ptr) {
+	p := *(**ContainerType)(x)
+	if enc.WriteRef(e.TypeID(TargetTypeContainerTypePtr), e.Ptr(p)) {
+		enc.encodeTargetTypeContainerType(e.Ptr(p))
+	}
+}
+
+func (dec targetDecoder) decodeTargetTypeContainerTypePtr(x e.Ptr) {
+	p, isNew := dec.ReadRef()
+	if isNew {
+		p = e.Ptr(new(ContainerType))
+		dec.AddRef(p)
+		dec.decodeTargetTypeContainerType(p)
+	}
+	*(**ContainerType)(x) = (*ContainerType)(p)
+}
+
+func (enc targetEncoder) encodeTargetTypeEmbeddingTypePtr(x e.Ptr) {
+	p := *(**EmbeddingType)(x)
+	if enc.WriteRef(e.TypeID(TargetTypeEmbeddingTypePtr), e.Ptr(p)) {
+		enc.encodeTargetTypeEmbeddingType(e.Ptr(p))
+	}
+}
+
+func (dec targetDecoder) decodeTargetTypeEmbeddingTypePtr(x e.Ptr) {
+	p, isNew := dec.ReadRef()
+	if isNew {
+		p = e.Ptr(new(EmbeddingType))
+		dec.AddRef(p)
+		dec.decodeTargetTypeEmbeddingType(p)
+	}
+	*(**EmbeddingType)(x) = (*EmbeddingType)(p)
+}
+
+func (enc targetEncoder) encodeTargetTypeEmbedsTargetPtr(x e.Ptr) {
+	p := *(**EmbedsTarget)(x)
+	if enc.WriteRef(e.TypeID(TargetTypeEmbedsTargetPtr), e.Ptr(p)) {
+		enc.encodeTargetTypeEmbedsTarget(e.Ptr(p))
+	}
+}
+
+func (dec targetDecoder) decodeTargetTypeEmbedsTargetPtr(x e.Ptr) {
+	p, isNew := dec.ReadRef()
+	if isNew {
+		p = e.Ptr(new(EmbedsTarget))
+		dec.AddRef(p)
+		dec.decodeTargetTypeEmbedsTarget(p)
+	}
+	*(**EmbedsTarget)(x) = (*EmbedsTarget)(p)
+}
+
+func (enc targetEncoder) encodeTargetTypeEncapsulatedTypePtr(x e.Ptr) {
+	p := *(**EncapsulatedType)(x)
+	if enc.WriteRef(e.TypeID(TargetTypeEncapsulatedTypePtr), e.Ptr(p)) {
+		enc.encodeTargetTypeEncapsulatedType(e.Ptr(p))
+	}
+}
+
+func (dec targetDecoder) decodeTargetTypeEncapsulatedTypePtr(x e.Ptr) {
+	p, isNew := dec.ReadRef()
+	if isNew {
+		p = e.Ptr(new(EncapsulatedType))
+		dec.AddRef(p)
+		dec.decodeTargetTypeEncapsulatedType(p)
+	}
+	*(**EncapsulatedType)(x) = (*EncapsulatedType)(p)
+}
+
+func (enc targetEncoder) encodeTargetTypeLooseTypePtr(x e.Ptr) {
+	p := *(**LooseType)(x)
+	if enc.WriteRef(e.TypeID(TargetTypeLooseTypePtr), e.Ptr(p)) {
+		enc.encodeTargetTypeLooseType(e.Ptr(p))
+	}
+}
+
+func (dec targetDecoder) decodeTargetTypeLooseTypePtr(x e.Ptr) {
+	p, isNew := dec.ReadRef()
+	if isNew {
+		p = e.Ptr(new(LooseType))
+		dec.AddRef(p)
+		dec.decodeTargetTypeLooseType(p)
+	}
+	*(**LooseType)(x) = (*LooseType)(p)
+}
+
+func (enc targetEncoder) encodeTargetTypePairTypePtr(x e.Ptr) {
+	p := *(**PairType)(x)
+	if enc.WriteRef(e.TypeID(TargetTypePairTypePtr), e.Ptr(p)) {
+		enc.encodeTargetTypePairType(e.Ptr(p))
+	}
+}
+
+func (dec targetDecoder) decodeTargetTypePairTypePtr(x e.Ptr) {
+	p, isNew := dec.ReadRef()
+	if isNew {
+		p = e.Ptr(new(PairType))
+		dec.AddRef(p)
+		dec.decodeTargetTypePairType(p)
+	}
+	*(**PairType)(x) = (*PairType)(p)
+}
+
+func (enc targetEncoder) encodeTargetTypeScopeTypePtr(x e.Ptr) {
+	p := *(**ScopeType)(x)
+	if enc.WriteRef(e.TypeID(TargetTypeScopeTypePtr), e.Ptr(p)) {
+		enc.encodeTargetTypeScopeType(e.Ptr(p))
+	}
+}
+
+func (dec targetDecoder) decodeTargetTypeScopeTypePtr(x e.Ptr) {
+	p, isNew := dec.ReadRef()
+	if isNew {
+		p = e.Ptr(new(ScopeType))
+		dec.AddRef(p)
+		dec.decodeTargetTypeScopeType(p)
+	}
+	*(**ScopeType)(x) = (*ScopeType)(p)
+}
+
+func (enc targetEncoder) encodeTargetTypeTargetPtr(x e.Ptr) {
+	p := *(**Target)(x)
+	if enc.WriteRef(e.TypeID(TargetTypeTargetPtr), e.Ptr(p)) {
+		enc.encodeTargetTypeTarget(e.Ptr(p))
+	}
+}
+
+func (dec targetDecoder) decodeTargetTypeTargetPtr(x e.Ptr) {
+	p, isNew := dec.ReadRef()
+	if isNew {
+		p = e.Ptr(new(Target))
+		dec.AddRef(p)
+		dec.decodeTargetTypeTarget(p)
+	}
+	*(**Target)(x) = (*Target)(p)
+}
+
+func (enc targetEncoder) encodeTargetTypeWrapperTypePtr(x e.Ptr) {
+	p := *(**WrapperType)(x)
+	if enc.WriteRef(e.TypeID(TargetTypeWrapperTypePtr), e.Ptr(p)) {
+		enc.encodeTargetTypeWrapperType(e.Ptr(p))
+	}
+}
+
+func (dec targetDecoder) decodeTargetTypeWrapperTypePtr(x e.Ptr) {
+	p, isNew := dec.ReadRef()
+	if isNew {
+		p = e.Ptr(new(WrapperType))
+		dec.AddRef(p)
+		dec.decodeTargetTypeWrapperType(p)
+	}
+	*(**WrapperType)(x) = (*WrapperType)(p)
+}
+
+func (enc targetEncoder) encodeTargetTypeByRefTypeArray2(x e.Ptr) {
+	a := (*[2]ByRefType)(x)
+	for i := range a {
+		enc.encodeTargetTypeByRefType(e.Ptr(&a[i]))
+	}
+}
+
+func (dec targetDecoder) decodeTargetTypeByRefTypeArray2(x e.Ptr) {
+	a := (*[2]ByRefType)(x)
+	for i := range a {
+		dec.decodeTargetTypeByRefType(e.Ptr(&a[i]))
+	}
+}
+
+func (enc targetEncoder) encodeTargetTypeTargetArray4(x e.Ptr) {
+	a := (*[4]Target)(x)
+	for i := range a {
+		enc.encodeTargetTypeTarget(e.Ptr(&a[i]))
+	}
+}
+
+func (dec targetDecoder) decodeTargetTypeTargetArray4(x e.Ptr) {
+	a := (*[4]Target)(x)
+	for i := range a {
+		dec.decodeTargetTypeTarget(e.Ptr(&a[i]))
+	}
+}
+
+func (enc targetEncoder) encodeTargetTypeTargetMapByString(x e.Ptr) {
+	m := *(*map[string]Target)(x)
+	// A nil map is written as zero, to distinguish it from an empty one.
+	if m == nil {
+		enc.WriteUint(0)
+		return
+	}
+	enc.WriteUint(uint64(len(m)) + 1)
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i] < keys[j] })
+	for _, k := range keys {
+		enc.WriteString(string(k))
+		v := m[k]
+		enc.encodeTargetTypeTarget(e.Ptr(&v))
+	}
+}
+
+func (dec targetDecoder) decodeTargetTypeTargetMapByString(x e.Ptr) {
+	n := dec.ReadLen()
+	if n == 0 {
+		*(*map[string]Target)(x) = nil
+		return
+	}
+	m := make(map[string]Target, dec.Prealloc(n-1))
+	for i := 0; i < n-1 && dec.Err() == nil; i++ {
+		k := string(dec.ReadString())
+		var v Target
+		dec.decodeTargetTypeTarget(e.Ptr(&v))
+		m[k] = v
+	}
+	*(*map[string]Target)(x) = m
+}
+
+func (enc targetEncoder) encodeTargetTypeByRefTypePtrSlice(x e.Ptr) {
+	s := *(*[]*ByRefType)(x)
+	// A nil slice is written as zero, to distinguish it from an empty one.
+	if s == nil {
+		enc.WriteUint(0)
+		return
+	}
+	enc.WriteUint(uint64(len(s)) + 1)
+	for i := range s {
+		enc.encodeTargetTypeByRefTypePtr(e.Ptr(&s[i]))
+	}
+}
+
+func (dec targetDecoder) decodeTargetTypeByRefTypePtrSlice(x e.Ptr) {
+	n := dec.ReadLen()
+	if n == 0 {
+		*(*[]*ByRefType)(x) = nil
+		return
+	}
+	s := make([]*ByRefType, 0, dec.Prealloc(n-1))
+	for i := 0; i < n-1 && dec.Err() == nil; i++ {
+		var elt *ByRefType
+		s = append(s, elt)
+		dec.decodeTargetTypeByRefTypePtr(e.Ptr(&s[i]))
+	}
+	*(*[]*ByRefType)(x) = s
+}
+
+func (enc targetEncoder) encodeTargetTypeByValTypePtrSlice(x e.Ptr) {
+	s := *(*[]*ByValType)(x)
+	// A nil slice is written as zero, to distinguish it from an empty one.
+	if s == nil {
+		enc.WriteUint(0)
+		return
+	}
+	enc.WriteUint(uint64(len(s)) + 1)
+	for i := range s {
+		enc.encodeTargetTypeByValTypePtr(e.Ptr(&s[i]))
+	}
+}
+
+func (dec targetDecoder) decodeTargetTypeByValTypePtrSlice(x e.Ptr) {
+	n := dec.ReadLen()
+	if n == 0 {
+		*(*[]*ByValType)(x) = nil
+		return
+	}
+	s := make([]*ByValType, 0, dec.Prealloc(n-1))
+	for i := 0; i < n-1 && dec.Err() == nil; i++ {
+		var elt *ByValType
+		s = append(s, elt)
+		dec.decodeTargetTypeByValTypePtr(e.Ptr(&s[i]))
+	}
+	*(*[]*ByValType)(x) = s
+}
+
+func (enc targetEncoder) encodeTargetTypeTargetPtrSlice(x e.Ptr) {
+	s := *(*[]*Target)(x)
+	// A nil slice is written as zero, to distinguish it from an empty one.
+	if s == nil {
+		enc.WriteUint(0)
+		return
+	}
+	enc.WriteUint(uint64(len(s)) + 1)
+	for i := range s {
+		enc.encodeTargetTypeTargetPtr(e.Ptr(&s[i]))
+	}
+}
+
+func (dec targetDecoder) decodeTargetTypeTargetPtrSlice(x e.Ptr) {
+	n := dec.ReadLen()
+	if n == 0 {
+		*(*[]*Target)(x) = nil
+		return
+	}
+	s := make([]*Target, 0, dec.Prealloc(n-1))
+	for i := 0; i < n-1 && dec.Err() == nil; i++ {
+		var elt *Target
+		s = append(s, elt)
+		dec.decodeTargetTypeTargetPtr(e.Ptr(&s[i]))
+	}
+	*(*[]*Target)(x) = s
+}
+
+func (enc targetEncoder) encodeTargetTypeByRefTypeSlice(x e.Ptr) {
+	s := *(*[]ByRefType)(x)
+	// A nil slice is written as zero, to distinguish it from an empty one.
+	if s == nil {
+		enc.WriteUint(0)
+		return
+	}
+	enc.WriteUint(uint64(len(s)) + 1)
+	for i := range s {
+		enc.encodeTargetTypeByRefType(e.Ptr(&s[i]))
+	}
+}
+
+func (dec targetDecoder) decodeTargetTypeByRefTypeSlice(x e.Ptr) {
+	n := dec.ReadLen()
+	if n == 0 {
+		*(*[]ByRefType)(x) = nil
+		return
+	}
+	s := make([]ByRefType, 0, dec.Prealloc(n-1))
+	for i := 0; i < n-1 && dec.Err() == nil; i++ {
+		var elt ByRefType
+		s = append(s, elt)
+		dec.decodeTargetTypeByRefType(e.Ptr(&s[i]))
+	}
+	*(*[]ByRefType)(x) = s
+}
+
+func (enc targetEncoder) encodeTargetTypeByValTypeSlice(x e.Ptr) {
+	s := *(*[]ByValType)(x)
+	// A nil slice is written as zero, to distinguish it from an empty one.
+	if s == nil {
+		enc.WriteUint(0)
+		return
+	}
+	enc.WriteUint(uint64(len(s)) + 1)
+	for i := range s {
+		enc.encodeTargetTypeByValType(e.Ptr(&s[i]))
+	}
+}
+
+func (dec targetDecoder) decodeTargetTypeByValTypeSlice(x e.Ptr) {
+	n := dec.ReadLen()
+	if n == 0 {
+		*(*[]ByValType)(x) = nil
+		return
+	}
+	s := make([]ByValType, 0, dec.Prealloc(n-1))
+	for i := 0; i < n-1 && dec.Err() == nil; i++ {
+		var elt ByValType
+		s = append(s, elt)
+		dec.decodeTargetTypeByValType(e.Ptr(&s[i]))
+	}
+	*(*[]ByValType)(x) = s
+}
+
+func (enc targetEncoder) encodeTargetTypeTargetSlice(x e.Ptr) {
+	s := *(*[]Target)(x)
+	// A nil slice is written as zero, to distinguish it from an empty one.
+	if s == nil {
+		enc.WriteUint(0)
+		return
+	}
+	enc.WriteUint(uint64(len(s)) + 1)
+	for i := range s {
+		enc.encodeTargetTypeTarget(e.Ptr(&s[i]))
+	}
+}
+
+func (dec targetDecoder) decodeTargetTypeTargetSlice(x e.Ptr) {
+	n := dec.ReadLen()
+	if n == 0 {
+		*(*[]Target)(x) = nil
+		return
+	}
+	s := make([]Target, 0, dec.Prealloc(n-1))
+	for i := 0; i < n-1 && dec.Err() == nil; i++ {
+		var elt Target
+		s = append(s, elt)
+		dec.decodeTargetTypeTarget(e.Ptr(&s[i]))
+	}
+	*(*[]Target)(x) = s
+}
+
+// ------ Counting ------
+
+// WalkTargetCounted visits x with the provided callback and also
+// returns the number of times that the callback was invoked, i.e. the
+// number of values which were visited. Post-visit functions and
+// interceptors are not counted, and values which are visited again
+// after a restart are counted again.
+func WalkTargetCounted(x Target, fn TargetWalkerFn) (_ Target, changed bool, count int, err error) {
+	var visits int64
+	x, changed, err = walkTarget(x, fn, e.WithCount(&visits))
+	return x, changed, int(visits), err
+}
+
+// ------ Cycle Detection ------
+
+// TargetCycle describes a value which was not visited because it was
+// already being visited, i.e. a back-reference which would otherwise
+// form a cycle.
+type TargetCycle struct {
+	// Path is the location of the back-reference which closes the
+	// cycle, relative to the value passed to WalkTargetDetectCycles.
+	Path TargetPath
+	// TypeID is the type of the value.
+	TypeID TargetTypeID
+	// Value is populated if the value is a struct.
+	Value Target
+}
+
+// WalkTargetDetectCycles visits x with the provided callback and
+// returns the cycles which were broken during the visitation. Any
+// replacements made by the callback are discarded.
+func WalkTargetDetectCycles(x Target, fn TargetWalkerFn) ([]TargetCycle, error) {
+	if x == nil {
+		return nil, nil
+	}
+	id, ptr := targetIdentify(x)
+	if ptr == nil {
+		return nil, nil
+	}
+	var ret []TargetCycle
+	_, _, _, err := targetEngine.Execute(fn, id, ptr, e.TypeID(TargetTypeTarget),
+		e.WithCycleHook(func(path e.Path, id e.TypeID, x e.Ptr) {
+			c := TargetCycle{Path: path, TypeID: TargetTypeID(id)}
+			switch c.TypeID {
+			case TargetTypeByRefType:
+				c.Value = (*ByRefType)(x)
+			case TargetTypeByValType:
+				c.Value = (*ByValType)(x)
+			case TargetTypeContainerType:
+				c.Value = (*ContainerType)(x)
+			case TargetTypeEmbeddingType:
+				c.Value = (*EmbeddingType)(x)
+			case TargetTypeEncapsulatedType:
+				c.Value = (*EncapsulatedType)(x)
+			case TargetTypeLooseType:
+				c.Value = (*LooseType)(x)
+			case TargetTypePairType:
+				c.Value = (*PairType)(x)
+			case TargetTypeScopeType:
+				c.Value = (*ScopeType)(x)
+			case TargetTypeWrapperType:
+				c.Value = (*WrapperType)(x)
+			}
+			ret = append(ret, c)
+		}))
+	if err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+// ------ Depth Helpers ------
+
+// DepthOfTarget returns the deepest nesting level of the visitable
+// structs within x, as reported by TargetContext.Depth(). The value
+// passed in has a depth of zero, as does a nil value. Branches which
+// are not visited because they would form a cycle do not contribute
+// to the result.
+func DepthOfTarget(x Target) int {
+	max := 0
+	_, _, _ = WalkTarget(x, func(ctx TargetContext, _ Target) (d TargetDecision) {
+		if depth := ctx.Depth(); depth > max {
+			max = depth
+		}
+		return
+	})
+	return max
+}
+
+// TargetByDepth returns the visitable structs within root, grouped
+// by the depth reported by TargetContext.Depth(). Within each group,
+// values appear in the order in which they were visited. The root is
+// the only value at depth zero. Values which are not visited because
+// they would form a cycle are omitted.
+func TargetByDepth(root Target) [][]Target {
+	var ret [][]Target
+	_, _, _ = WalkTarget(root, func(ctx TargetContext, x Target) (d TargetDecision) {
+		depth := ctx.Depth()
+		for len(ret) <= depth {
+			ret = append(ret, nil)
+		}
+		ret[depth] = append(ret[depth], x)
+		return
+	})
+	return ret
+}
+
+// ------ Change Tracking ------
+
+// TargetChange describes a value which was replaced during a call to
+// DiffWalkTarget.
+type TargetChange struct {
+	// Path is the location of the value, relative to the root of the
+	// visitation, e.g. "TargetSlice[2]". The root itself has an empty
+	// path.
+	Path string
+	// Before is the value that was replaced.
+	Before Target
+	// After is the replacement value.
+	After Target
+}
+
+// DiffWalkTarget visits x with the provided callback and returns a
+// log of the replacements that the callback made, in the order that
+// they were made.
+func DiffWalkTarget(x Target, fn TargetWalkerFn) (_ Target, changes []TargetChange, err error) {
+	x, _, err = walkTarget(x, fn,
+		e.WithChangeHook(func(path e.Path, beforeType e.TypeID, before e.Ptr, afterType e.TypeID, after e.Ptr) {
+			changes = append(changes, TargetChange{
+				Path:   path.String(),
+				Before: targetWrap(beforeType, before),
+				After:  targetWrap(afterType, after),
+			})
+		}))
+	if err != nil {
+		return nil, nil, err
+	}
+	return x, changes, nil
+}
+
+// WalkTargetChanged visits the values within after which differ
+// from the values at the same location within before, such as the
+// input and output of a previous call to WalkTarget. Since
+// replaced values only cause their ancestors to be copied, any subtree
+// which is shared between before and after will be skipped without
+// invoking the callback. A nil before value will cause all of after to
+// be visited.
+func WalkTargetChanged(before, after Target, fn TargetWalkerFn) (_ Target, changed bool, err error) {
+	if after == nil {
+		return nil, false, nil
+	}
+	id, ptr := targetIdentify(after)
+	if ptr == nil {
+		return after, false, nil
+	}
+	var beforePtr e.Ptr
+	if before != nil {
+		if beforeID, p := targetIdentify(before); beforeID == id {
+			beforePtr = p
+		}
+	}
+	return walkTarget(after, fn, e.WithChanges(targetEngine.Changes(id, beforePtr, ptr)))
+}
+
+// TargetEditOp describes the kind of change made by a TargetEdit.
+type TargetEditOp e.EditOp
+
+// The kinds of edits that may appear in a script.
+const (
+	TargetEditOpDelete  = TargetEditOp(e.EditDelete)
+	TargetEditOpInsert  = TargetEditOp(e.EditInsert)
+	TargetEditOpReplace = TargetEditOp(e.EditReplace)
+)
+
+// String is for debugging use only.
+func (o TargetEditOp) String() string {
+	return e.EditOp(o).String()
+}
+
+// TargetEdit is one step of a script produced by
+// DiffTargetScript.
+type TargetEdit struct {
+	Op TargetEditOp
+	// Path is the location of the value, e.g. "TargetSlice[2]". The
+	// paths of deleted values are relative to the original tree, while
+	// the paths of inserted and replaced values are relative to the new
+	// tree.
+	Path string
+	// Before is the value which was deleted or replaced. It will be nil
+	// for insertions.
+	Before TargetAbstract
+	// After is the value which was inserted or which replaced Before.
+	// It will be nil for deletions.
+	After TargetAbstract
+}
+
+// DiffTargetScript returns a minimal sequence of edits which
+// transforms a into b. Struct fields and array elements are compared
+// by position, while slice elements may be inserted or deleted. A
+// value is replaced if its type or its scalar fields differ; if only
+// its scalar fields differ, its children are compared in turn. Subtrees
+// which are shared between a and b are not examined.
+func DiffTargetScript(a, b Target) []TargetEdit {
+	var aID, bID e.TypeID
+	var aPtr, bPtr e.Ptr
+	if a != nil {
+		aID, aPtr = targetIdentify(a)
+	}
+	if b != nil {
+		bID, bPtr = targetIdentify(b)
+	}
+	edits := targetEngine.Script(aID, aPtr, bID, bPtr, targetSameLabel)
+	if len(edits) == 0 {
+		return nil
+	}
+	ret := make([]TargetEdit, len(edits))
+	for i, edit := range edits {
+		ret[i] = TargetEdit{
+			Op:     TargetEditOp(edit.Op),
+			Path:   edit.Path.String(),
+			Before: targetAbstractOf(edit.Before),
+			After:  targetAbstractOf(edit.After),
+		}
+	}
+	return ret
+}
+
+// targetSameLabel compares the scalar fields of two structs of the
+// same type.
+func targetSameLabel(id e.TypeID, a, b e.Ptr) bool {
+	switch TargetTypeID(id) {
+	case TargetTypeByRefType:
+		x, y := (*ByRefType)(a), (*ByRefType)(b)
+		return x.Val == y.Val
+	case TargetTypeByValType:
+		x, y := (*ByValType)(a), (*ByValType)(b)
+		return x.Val == y.Val
+	case TargetTypeWrapperType:
+		x, y := (*WrapperType)(a), (*WrapperType)(b)
+		return x.Name == y.Name
+	default:
+		return true
+	}
+}
+
+// ------ Dumping ------
+
+// DumpTarget returns an indented representation of x, for use
+// when debugging. Each line describes one value, giving its field name
+// or index within the enclosing value and its type. Pointers and
+// interfaces are followed transparently, and a value which encloses
+// itself is marked as a back-reference, rather than being followed.
+func DumpTarget(x Target) string {
+	if x == nil {
+		return "<nil>\n"
+	}
+	id, ptr := targetIdentify(x)
+	return targetEngine.Dump(id, ptr)
+}
+
+// ------ Equality ------
+
+// EqualTarget reports whether a and b are structurally equal.
+// Visitable fields and elements are compared recursively, while the
+// exported boolean, numeric, and string fields of each struct are
+// compared with ==; all other fields are ignored. Pointers and
+// interfaces are compared by the values they refer to. Nested nil
+// values, typed-nil interfaces, and empty slices or maps are considered
+// to be equal to one another. Slices of differing lengths are never
+// equal.
+func EqualTarget(a, b Target) bool {
+	var aID, bID e.TypeID
+	var aPtr, bPtr e.Ptr
+	if a != nil {
+		aID, aPtr = targetIdentify(a)
+	}
+	if b != nil {
+		bID, bPtr = targetIdentify(b)
+	}
+	return targetEngine.Equal(aID, aPtr, bID, bPtr, targetSameLabel)
+}
+
+// EqualTarget reports whether the receiver and other are
+// structurally equal, as defined by EqualTarget.
+func (x *ByRefType) EqualTarget(other *ByRefType) bool {
+	return targetEngine.Equal(e.TypeID(TargetTypeByRefType), e.Ptr(x), e.TypeID(TargetTypeByRefType), e.Ptr(other), targetSameLabel)
+}
+
+// EqualTarget reports whether the receiver and other are
+// structurally equal, as defined by EqualTarget.
+func (x *ByValType) EqualTarget(other *ByValType) bool {
+	return targetEngine.Equal(e.TypeID(TargetTypeByValType), e.Ptr(x), e.TypeID(TargetTypeByValType), e.Ptr(other), targetSameLabel)
+}
+
+// EqualTarget reports whether the receiver and other are
+// structurally equal, as defined by EqualTarget.
+func (x *ContainerType) EqualTarget(other *ContainerType) bool {
+	return targetEngine.Equal(e.TypeID(TargetTypeContainerType), e.Ptr(x), e.TypeID(TargetTypeContainerType), e.Ptr(other), targetSameLabel)
+}
+
+// EqualTarget reports whether the receiver and other are
+// structurally equal, as defined by EqualTarget.
+func (x *EmbeddingType) EqualTarget(other *EmbeddingType) bool {
+	return targetEngine.Equal(e.TypeID(TargetTypeEmbeddingType), e.Ptr(x), e.TypeID(TargetTypeEmbeddingType), e.Ptr(other), targetSameLabel)
+}
+
+// EqualTarget reports whether the receiver and other are
+// structurally equal, as defined by EqualTarget.
+func (x *EncapsulatedType) EqualTarget(other *EncapsulatedType) bool {
+	return targetEngine.Equal(e.TypeID(TargetTypeEncapsulatedType), e.Ptr(x), e.TypeID(TargetTypeEncapsulatedType), e.Ptr(other), targetSameLabel)
+}
+
+// EqualTarget reports whether the receiver and other are
+// structurally equal, as defined by EqualTarget.
+func (x *LooseType) EqualTarget(other *LooseType) bool {
+	return targetEngine.Equal(e.TypeID(TargetTypeLooseType), e.Ptr(x), e.TypeID(TargetTypeLooseType), e.Ptr(other), targetSameLabel)
+}
+
+// EqualTarget reports whether the receiver and other are
+// structurally equal, as defined by EqualTarget.
+func (x *PairType) EqualTarget(other *PairType) bool {
+	return targetEngine.Equal(e.TypeID(TargetTypePairType), e.Ptr(x), e.TypeID(TargetTypePairType), e.Ptr(other), targetSameLabel)
+}
+
+// EqualTarget reports whether the receiver and other are
+// structurally equal, as defined by EqualTarget.
+func (x *ScopeType) EqualTarget(other *ScopeType) bool {
+	return targetEngine.Equal(e.TypeID(TargetTypeScopeType), e.Ptr(x), e.TypeID(TargetTypeScopeType), e.Ptr(other), targetSameLabel)
+}
+
+// EqualTarget reports whether the receiver and other are
+// structurally equal, as defined by EqualTarget.
+func (x *WrapperType) EqualTarget(other *WrapperType) bool {
+	return targetEngine.Equal(e.TypeID(TargetTypeWrapperType), e.Ptr(x), e.TypeID(TargetTypeWrapperType), e.Ptr(other), targetSameLabel)
+}
+
+// ------ Event Streaming ------
+
+// TargetEvent is sent by WalkTargetChan when a value is visited.
+type TargetEvent struct {
+	// Value is the visited value. It will be nil for an event which
+	// carries an error.
+	Value Target
+	// TypeID is the type token of Value.
+	TypeID TargetTypeID
+	// Post is false for the event which is sent before the children of
+	// Value are visited, and true for the event which is sent after.
+	Post bool
+	// Err is only set on the final event, if the walk failed.
+	Err error
+}
+
+// WalkTargetChan visits x on a new goroutine and returns a channel
+// which receives a pre-visit and a post-visit event for each value.
+// Values cannot be replaced. The goroutine blocks whenever the channel's
+// buffer is full, and the channel is closed once the walk has finished.
+// If the walk fails, a final event carrying the error will be sent. A
+// consumer which stops reading before the channel has been closed must
+// cancel ctx, or else the goroutine will leak; once ctx has been
+// cancelled, any remaining events, including the final error, may be
+// dropped.
+func WalkTargetChan(ctx context.Context, x Target) <-chan TargetEvent {
+	ch := make(chan TargetEvent, 16)
+	send := func(ev TargetEvent) bool {
+		select {
+		case ch <- ev:
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	}
+	event := func(x Target, post bool) TargetEvent {
+		id, _ := targetIdentify(x)
+		return TargetEvent{Value: x, TypeID: TargetTypeID(id), Post: post}
+	}
+	post := TargetWalkerFn(func(c TargetContext, x Target) TargetDecision {
+		if !send(event(x, true)) {
+			return c.Error(ctx.Err())
+		}
+		return c.Continue()
+	})
+
+	go func() {
+		defer close(ch)
+		_, _, err := WalkTargetCtx(ctx, x, func(c TargetContext, x Target) TargetDecision {
+			if !send(event(x, false)) {
+				return c.Error(ctx.Err())
+			}
+			return c.Continue().Post(post)
+		})
+		if err != nil {
+			send(TargetEvent{Err: err})
+		}
+	}()
+	return ch
+}
+
+// ------ Fixed-Point Application ------
+
+// ApplyTargetToFixedPoint repeatedly visits root with the provided
+// callback until a visitation makes no changes. The callback will not
+// be applied more than maxIters times; an error will be returned if the
+// value is still changing once the limit has been reached. The number
+// of visitations which were performed is returned.
+func ApplyTargetToFixedPoint(root Target, fn TargetWalkerFn, maxIters int) (Target, int, error) {
+	for i := 1; i <= maxIters; i++ {
+		next, changed, err := WalkTarget(root, fn)
+		if err != nil {
+			return nil, i, err
+		}
+		if !changed {
+			return root, i, nil
+		}
+		root = next
+	}
+	return root, maxIters, fmt.Errorf("no fixed point reached after %d iterations", maxIters)
+}
+
+// ------ Focusing ------
+
+// FocusTarget returns the values within root for which pred
+// returns true, in the order that they are visited, along with a
+// function which puts replacements for them back into a copy of root.
+// The values which enclose a replaced value are cloned, while all other
+// values are shared with root. The values within a focused value are
+// not examined. The put function must be given exactly one non-nil
+// replacement for each focused value, and it will return an error if
+// a replacement cannot be stored, e.g. because the value was returned
+// by a getter. The put function may be called any number of times.
+func FocusTarget(root Target, pred func(Target) bool) (focused []Target, put func([]Target) (Target, error)) {
+	// Traversal is deterministic, so we record the ordinals of the
+	// focused values in order to find them again.
+	var ordinals []int
+	count := 0
+	_, _, _ = WalkTarget(root, func(ctx TargetContext, x Target) TargetDecision {
+		count++
+		if pred(x) {
+			focused = append(focused, x)
+			ordinals = append(ordinals, count)
+			return ctx.Skip()
+		}
+		return ctx.Continue()
+	})
+
+	put = func(replacements []Target) (Target, error) {
+		if len(replacements) != len(ordinals) {
+			return nil, fmt.Errorf("expecting %d replacements, got %d", len(ordinals), len(replacements))
+		}
+		count, next := 0, 0
+		ret, _, err := WalkTarget(root, func(ctx TargetContext, x Target) TargetDecision {
+			count++
+			if next == len(ordinals) {
+				return ctx.Halt()
+			}
+			if count != ordinals[next] {
+				return ctx.Continue()
+			}
+			replacement := replacements[next]
+			if replacement == nil {
+				return ctx.Error(fmt.Errorf("replacement %d is nil", next))
+			}
+			next++
+			return ctx.Skip().Replace(replacement)
+		})
+		return ret, err
+	}
+	return focused, put
+}
+
+// ------ Forests ------
+
+// WalkTargetForest visits each of the roots with the provided
+// callback, distributing the roots across the given number of
+// goroutines. If workers is not positive, GOMAXPROCS goroutines will be
+// used. The callback must be safe for concurrent use. Once any
+// visitation returns an error, visitations which are in progress will
+// be halted and the remaining roots will not be visited. The first
+// error will be returned. Any replacements made by the callback are
+// discarded.
+func WalkTargetForest(roots []Target, workers int, fn TargetWalkerFn) error {
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+	if workers > len(roots) {
+		workers = len(roots)
+	}
+	// The cause of the cancellation is the first error.
+	ctx, cancel := context.WithCancelCause(context.Background())
+	defer cancel(nil)
+	// Halt any visitations in progress once an error has occurred.
+	var guarded TargetWalkerFn = func(c TargetContext, x Target) TargetDecision {
+		if ctx.Err() != nil {
+			return c.Halt()
+		}
+		return fn(c, x)
+	}
+
+	work := make(chan Target)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Go(func() {
+			for root := range work {
+				if _, _, err := WalkTarget(root, guarded); err != nil {
+					cancel(err)
+				}
+			}
+		})
+	}
+
+feed:
+	for _, root := range roots {
+		select {
+		case work <- root:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(work)
+	wg.Wait()
+	return context.Cause(ctx)
+}
+
+// ------ Formatting ------
+
+// TargetFormatter renders a value, given the rendered output of the
+// visitable structs that it encloses.
+type TargetFormatter func(x Target, children []string) string
+
+// FormatTarget renders root by visiting it and composing the
+// output of the formatter registered for the type of each struct. A
+// struct without a formatter is rendered as the name of its type,
+// followed by its children in parentheses, if it has any. The children
+// of a struct are rendered in the order in which they are visited. Nil
+// values, and values which would form a cycle, are omitted.
+func FormatTarget(root Target, formatters map[TargetTypeID]TargetFormatter) string {
+	// Each struct being visited has an entry on the stack, which
+	// accumulates the output of its children. The bottom entry collects
+	// the output of the root.
+	stack := [][]string{nil}
+	var post TargetWalkerFn = func(ctx TargetContext, x Target) (d TargetDecision) {
+		children := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		id, _ := targetIdentify(x)
+		var out string
+		if fn, ok := formatters[TargetTypeID(id)]; ok {
+			out = fn(x, children)
+		} else if len(children) == 0 {
+			out = TargetTypeID(id).String()
+		} else {
+			out = fmt.Sprintf("%s(%s)", TargetTypeID(id), strings.Join(children, ", "))
+		}
+		stack[len(stack)-1] = append(stack[len(stack)-1], out)
+		return
+	}
+	_, _, _ = WalkTarget(root, func(ctx TargetContext, x Target) TargetDecision {
+		stack = append(stack, nil)
+		return ctx.Continue().Post(post)
+	})
+	return strings.Join(stack[0], "")
+}
+
+// ------ Histograms ------
+
+// TargetHistogram returns the number of values of each struct type
+// which are visited by InspectTarget, including x itself. A nil
+// value returns an empty map.
+func TargetHistogram(x Target) map[TargetTypeID]int {
+	ret := make(map[TargetTypeID]int)
+	InspectTarget(x, func(x Target) {
+		id, _ := targetIdentify(x)
+		ret[TargetTypeID(id)]++
+	})
+	return ret
+}
+
+// ------ Invariants ------
+
+// TargetViolation describes a field whose value does not satisfy an
+// invariant declared by its walkabout struct tag.
+type TargetViolation struct {
+	// Path is the location of the field, relative to the root of the
+	// visitation, e.g. "TargetSlice[2].ByRefPtr".
+	Path string
+	// Invariant is the name of the invariant, e.g. "nonnil".
+	Invariant string
+}
+
+// TargetViolations is the error returned by CheckTargetInvariants.
+type TargetViolations []TargetViolation
+
+// Error implements error.
+func (v TargetViolations) Error() string {
+	msgs := make([]string, len(v))
+	for i, x := range v {
+		msgs[i] = fmt.Sprintf("%s (%s)", x.Path, x.Invariant)
+	}
+	return "invariants violated: " + strings.Join(msgs, ", ")
+}
+
+// CheckTargetInvariants visits root and returns TargetViolations
+// if any field does not satisfy the invariants declared by its walkabout
+// struct tag. The supported invariants are "nonnil", for pointer and
+// interface fields, and "nonempty", for slice and map fields. Multiple
+// invariants may be separated by commas.
+func CheckTargetInvariants(root Target) error {
+	if root == nil {
+		return nil
+	}
+	id, ptr := targetIdentify(root)
+	if ptr == nil {
+		return nil
+	}
+	var ret TargetViolations
+	violated := func(ctx TargetContext, field, invariant string) {
+		path := append(ctx.impl.Path(), e.PathSegment{Field: field})
+		ret = append(ret, TargetViolation{Path: path.String(), Invariant: invariant})
+	}
+	fn := func(ctx TargetContext, x Target) TargetDecision {
+		switch t := x.(type) {
+		case *ContainerType:
+			if t.ByRefPtr == nil {
+				violated(ctx, "ByRefPtr", "nonnil")
+			}
+			if len(t.TargetSlice) == 0 {
+				violated(ctx, "TargetSlice", "nonempty")
+			}
+		case *ScopeType:
+			if len(t.Env) == 0 {
+				violated(ctx, "Env", "nonempty")
+			}
+		}
+		return ctx.Continue()
+	}
+	if _, _, _, err := targetEngine.Execute(TargetWalkerFn(fn), id, ptr, e.TypeID(TargetTypeTarget)); err != nil {
+		return err
+	}
+	if len(ret) > 0 {
+		return ret
+	}
+	return nil
+}
+
+// ------ Marshaling ------
+
+// MarshalTarget returns a JSON representation of x, for use in
+// logging. Each struct is encoded as an object whose "__type" key holds
+// the name of its concrete type, followed by its exported,
+// non-visitable fields and then its visitable fields. Slices and
+// arrays are encoded as lists and maps as objects, while nil values
+// and empty slices or maps are encoded as null. The output is stable,
+// although it cannot currently be unmarshaled. An error will be
+// returned if a value encloses itself, or if a non-visitable field
+// cannot be encoded by encoding/json.
+func MarshalTarget(x Target) ([]byte, error) {
+	if x == nil {
+		return []byte("null"), nil
+	}
+	id, ptr := targetIdentify(x)
+	return targetEngine.Marshal(id, ptr, targetMarshalLeaves)
+}
+
+// targetMarshalLeaves implements e.LeafFn.
+func targetMarshalLeaves(id e.TypeID, x e.Ptr, fn func(name string, value interface{}) error) error {
+	switch TargetTypeID(id) {
+	case TargetTypeByRefType:
+		s := (*ByRefType)(x)
+		if err := fn("Val", s.Val); err != nil {
+			return err
+		}
+	case TargetTypeByValType:
+		s := (*ByValType)(x)
+		if err := fn("Val", s.Val); err != nil {
+			return err
+		}
+	case TargetTypeContainerType:
+		s := (*ContainerType)(x)
+		if err := fn("Ignored", s.Ignored); err != nil {
+			return err
+		}
+		if err := fn("Cached", s.Cached); err != nil {
+			return err
+		}
+		if err := fn("UnionableType", s.UnionableType); err != nil {
+			return err
+		}
+		if err := fn("ReachableType", s.ReachableType); err != nil {
+			return err
+		}
+		if err := fn("OtherReachable", s.OtherReachable); err != nil {
+			return err
+		}
+		if err := fn("OtherImplementor", s.OtherImplementor); err != nil {
+			return err
+		}
+	case TargetTypeWrapperType:
+		s := (*WrapperType)(x)
+		if err := fn("Name", s.Name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ------ Depth Limits ------
+
+// WalkTargetMaxDepth visits x with the provided callback, but
+// returns an error instead of descending into a value which would
+// require more than maxDepth levels of the engine's stack. The value x
+// occupies one level, and every struct, pointer, interface, slice,
+// array, or map which encloses a value adds another, so that deeply
+// nested slices are limited as well as deeply nested structs. This
+// guards against malformed or adversarial inputs. A maxDepth of zero
+// or less is unlimited.
+func WalkTargetMaxDepth(x Target, maxDepth int, fn TargetWalkerFn) (_ Target, changed bool, err error) {
+	return walkTarget(x, fn, e.WithMaxDepth(maxDepth))
+}
+
+// ------ Memoization ------
+
+// TargetMemo records the outcome of visiting struct values in
+// WalkTargetMemo. Values are identified by their structure, so that
+// identical subtrees will only be visited once, whether or not they are
+// shared by reference. Values are first bucketed by a hash of their
+// visitable children and scalar fields, and are then compared with
+// EqualTarget. A TargetMemo is not safe for concurrent use.
+type TargetMemo e.Memo
+
+// NewTargetMemo constructs an empty TargetMemo.
+func NewTargetMemo() *TargetMemo {
+	return (*TargetMemo)(e.NewMemo(targetHashLabel, targetSameLabel))
+}
+
+// Len returns the number of outcomes that have been recorded.
+func (m *TargetMemo) Len() int {
+	return (*e.Memo)(m).Len()
+}
+
+// WalkTargetMemo visits x with the provided callback, which must
+// behave as a pure function of the value being visited and its children.
+// Once a struct value has been visited, the outcome is recorded and
+// will be reused whenever an equal value is encountered again. Reused
+// values are neither passed to the callback, nor are their children
+// visited.
+//
+// If memo is nil, the outcomes will only be retained for the duration
+// of the call. Otherwise, the caller-provided memo will be consulted
+// and updated, allowing outcomes to be reused across calls.
+func WalkTargetMemo(x Target, memo *TargetMemo, fn TargetWalkerFn) (_ Target, changed bool, err error) {
+	if memo == nil {
+		memo = NewTargetMemo()
+	}
+	return walkTarget(x, fn, e.WithMemo((*e.Memo)(memo)))
+}
+
+// targetHashLabel hashes the scalar fields of a struct, consistently
+// with targetSameLabel.
+func targetHashLabel(id e.TypeID, x e.Ptr, h *e.Hasher) {
+	switch TargetTypeID(id) {
+	case TargetTypeByRefType:
+		s := (*ByRefType)(x)
+		h.WriteString(string(s.Val))
+	case TargetTypeByValType:
+		s := (*ByValType)(x)
+		h.WriteString(string(s.Val))
+	case TargetTypeWrapperType:
+		s := (*WrapperType)(x)
+		h.WriteString(string(s.Name))
+	}
+}
+
+// ------ Nil Values ------
+
+// WalkTargetVisitNils visits x with the provided callback, which
+// will also be invoked with a nil Target for each nil interface
+// value that an ordinary walk would skip, such as a nil element of a
+// slice or a field which holds a typed-nil pointer. This allows nil
+// values to be reported or replaced. Since there is nothing to descend
+// into, returning a replacement is the only meaningful action for a
+// nil value, and any post-visit function will not be called.
+func WalkTargetVisitNils(x Target, fn TargetWalkerFn) (_ Target, changed bool, err error) {
+	return walkTarget(x, fn, e.WithNils(func(impl e.Context, fn e.FacadeFn) e.Decision {
+		return e.Decision(fn.(TargetWalkerFn)(TargetContext{impl}, nil))
+	}))
+}
+
+// ------ Type Filtering ------
+
+// WalkTargetOfTypes visits x with the provided callback, which
+// will only be invoked for struct values whose type token is one of
+// the given types. All other values are descended into as though the
+// callback had returned a zero TargetDecision.
+func WalkTargetOfTypes(x Target, fn TargetWalkerFn, types ...TargetTypeID) (_ Target, changed bool, err error) {
+	ids := make([]e.TypeID, len(types))
+	for i, t := range types {
+		ids[i] = e.TypeID(t)
+	}
+	return walkTarget(x, fn, e.WithTypes(ids...))
+}
+
+// WalkTargetExcept visits x with the provided callback, skipping
+// any value whose type token is one of skipTypes, along with all of the
+// values that it encloses, as though the callback had returned
+// TargetContext.Skip for it. Since skipped values are never
+// passed to the callback, they cannot be replaced.
+func WalkTargetExcept(x Target, skipTypes []TargetTypeID, fn TargetWalkerFn) (_ Target, changed bool, err error) {
+	ids := make([]e.TypeID, len(skipTypes))
+	for i, t := range skipTypes {
+		ids[i] = e.TypeID(t)
+	}
+	return walkTarget(x, fn, e.WithSkipTypes(ids...))
+}
+
+// ------ Exactly-once Visitation ------
+
+// WalkTargetOnce visits x with the provided callback, but will
+// visit each value at most once, even if it is reachable through
+// multiple pointers. A value which has already been visited is skipped,
+// along with all of the values that it encloses. A skipped value is
+// left as-is, even if it was replaced where it was first visited.
+func WalkTargetOnce(x Target, fn TargetWalkerFn) (_ Target, changed bool, err error) {
+	return walkTarget(x, fn, e.WithOnce())
+}
+
+// ------ Post-Order Visitation ------
+
+// WalkTargetPostOrder visits x with the provided callback, which
+// is only invoked once all of the children of a value have been
+// visited, as though it had been registered as a post-visit function
+// for every value. The callback will therefore see any replacements
+// made to the children. Skip has no effect, since the children have
+// already been visited. Halt prevents any further children from being
+// visited, but the callback will still be invoked for the values which
+// enclose the halting value.
+func WalkTargetPostOrder(x Target, fn TargetWalkerFn) (_ Target, changed bool, err error) {
+	return walkTarget(x, func(ctx TargetContext, _ Target) TargetDecision {
+		return ctx.Continue().Post(fn)
+	})
+}
+
+// ------ Rebuilding ------
+
+// WalkTargetRebuild visits x with the provided callback. Unlike
+// WalkTarget, every visitable value will be copied, even if the
+// callback makes no changes. The result will not share any visitable
+// memory with x, except for back-references which form cycles and
+// values registered with SetTargetInterned.
+func WalkTargetRebuild(x Target, fn TargetWalkerFn) (Target, error) {
+	if x == nil {
+		return nil, nil
+	}
+	id, ptr := targetIdentify(x)
+	if ptr == nil {
+		return x, nil
+	}
+	id, ptr, _, err := targetEngine.Execute(fn, id, ptr, e.TypeID(TargetTypeTarget), e.WithRebuild())
+	if err != nil {
+		return nil, err
+	}
+	return targetWrap(id, ptr), nil
+}
+
+// SetTargetInterned registers a predicate which identifies
+// interned values of the given struct type, such as shared constants.
+// WalkTargetRebuild will retain an interned value as-is, instead
+// of copying it, unless the callback replaces a value that it
+// encloses. A nil predicate removes any existing registration. This
+// function must not be called concurrently with any visitation, so it
+// is best called from an init function.
+func SetTargetInterned(id TargetTypeID, fn func(x Target) bool) {
+	if fn == nil {
+		targetEngine.Intern(e.TypeID(id), nil)
+		return
+	}
+	targetEngine.Intern(e.TypeID(id), func(x e.Ptr) bool {
+		return fn(targetWrap(e.TypeID(id), x))
+	})
+}
+
+// ------ String Redaction ------
+
+// RedactTargetStrings applies fn to every exported string field of
+// the structs within root, whether or not the fields are visitable.
+// Structs whose strings are changed will be replaced by updated copies,
+// so root itself is not modified.
+func RedactTargetStrings(root Target, fn func(string) string) (Target, bool, error) {
+	return WalkTarget(root, func(ctx TargetContext, x Target) TargetDecision {
+		switch t := x.(type) {
+		case *ByRefType:
+			cp := *t
+			changed := false
+			if next := fn(t.Val); next != t.Val {
+				cp.Val = next
+				changed = true
+			}
+			if changed {
+				return ctx.Continue().Replace(&cp)
+			}
+		case *ByValType:
+			cp := *t
+			changed := false
+			if next := fn(t.Val); next != t.Val {
+				cp.Val = next
+				changed = true
+			}
+			if changed {
+				return ctx.Continue().Replace(&cp)
+			}
+		case *WrapperType:
+			cp := *t
+			changed := false
+			if next := fn(t.Name); next != t.Name {
+				cp.Name = next
+				changed = true
+			}
+			if changed {
+				return ctx.Continue().Replace(&cp)
+			}
+		}
+		return ctx.Continue()
+	})
+}
+
+// ------ Shape Fingerprints ------
+
+// ShapeOfTarget returns the type tokens of the visitable structs
+// within root, in the order in which they are visited. The values of
+// any scalar fields are ignored, so trees which differ only in those
+// values will have the same shape. A back-reference which is not
+// visited because it would form a cycle is represented by a zero
+// token. A nil value has an empty shape.
+func ShapeOfTarget(root Target) []TargetTypeID {
+	if root == nil {
+		return nil
+	}
+	id, ptr := targetIdentify(root)
+	if ptr == nil {
+		return nil
+	}
+	var ret []TargetTypeID
+	var fn TargetWalkerFn = func(ctx TargetContext, x Target) (d TargetDecision) {
+		id, _ := targetIdentify(x)
+		ret = append(ret, TargetTypeID(id))
+		return
+	}
+	_, _, _, _ = targetEngine.Execute(fn, id, ptr, e.TypeID(TargetTypeTarget),
+		e.WithCycleHook(func(e.Path, e.TypeID, e.Ptr) {
+			ret = append(ret, 0)
+		}))
+	return ret
+}
+
+// ------ Per-Walk State ------
+
+// TargetStateFn is a variation on TargetWalkerFn which also receives
+// the state value passed to WalkTargetState.
+type TargetStateFn func(ctx TargetContext, state interface{}, x Target) TargetDecision
+
+// WalkTargetState visits x with the provided callback. The state
+// will be passed to each invocation of the callback and is also
+// available to post-visit functions via TargetContext.State().
+func WalkTargetState(x Target, state interface{}, fn TargetStateFn) (_ Target, changed bool, err error) {
+	walker := TargetWalkerFn(func(ctx TargetContext, x Target) TargetDecision {
+		return fn(ctx, ctx.State(), x)
+	})
+	return walkTarget(x, walker, e.WithState(state))
+}
+
+// WalkTargetWith visits x with the provided callback. The user
+// data is available to the callback, and to post-visit functions, via
+// TargetContext.Value(). This allows a single TargetWalkerFn to be
+// reused across walks without capturing any variables.
+func WalkTargetWith(x Target, userData interface{}, fn TargetWalkerFn) (_ Target, changed bool, err error) {
+	return walkTarget(x, fn, e.WithState(userData))
+}
+
+// ------ Topological Visitation ------
+
+// TargetTopoFn is used by WalkTargetTopo.
+type TargetTopoFn func(x Target) error
+
+// WalkTargetTopo treats the named field as the dependencies of a
+// value and invokes the callback on root and its transitive
+// dependencies, such that each value is visited only once and only
+// after all of its dependencies have been visited. The field may be
+// of any visitable type; a struct, or the structs contained within a
+// slice or array, will be treated as dependencies. Values which do not
+// have a field of the given name have no dependencies. An error will
+// be returned if the dependencies form a cycle.
+func WalkTargetTopo(root Target, depField string, fn TargetTopoFn) error {
+	type key struct {
+		id  e.TypeID
+		ptr e.Ptr
+	}
+	// Values that are present, but false, are being visited.
+	done := make(map[key]bool)
+	var stack []key
+
+	var visit func(x Target) error
+	var visitDeps func(a TargetAbstract) error
+
+	visit = func(x Target) error {
+		id, ptr := targetIdentify(x)
+		if ptr == nil {
+			return nil
+		}
+		k := key{id, ptr}
+		if finished, seen := done[k]; seen {
+			if finished {
+				return nil
+			}
+			msg := ""
+			for i := len(stack) - 1; i >= 0; i-- {
+				if stack[i] == k {
+					for _, s := range stack[i:] {
+						msg += fmt.Sprintf("%s -> ", TargetTypeID(s.id))
+					}
+					break
+				}
+			}
+			return fmt.Errorf("dependency cycle: %s%s", msg, TargetTypeID(id))
+		}
+		done[k] = false
+		stack = append(stack, k)
+
+		self := &targetAbstract{targetEngine.Abstract(id, ptr)}
+		if idx := self.delegate.FieldIndex(depField); idx >= 0 {
+			if err := visitDeps(self.TargetAt(idx)); err != nil {
+				return err
+			}
+		}
+
+		stack = stack[:len(stack)-1]
+		done[k] = true
+		return fn(x)
 	}
-	return
+
+	visitDeps = func(a TargetAbstract) error {
+		if a == nil {
+			return nil
+		}
+		if x, ok := a.(Target); ok {
+			return visit(x)
+		}
+		for i, j := 0, a.TargetCount(); i < j; i++ {
+			if err := visitDeps(a.TargetAt(i)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	if root == nil {
+		return nil
+	}
+	return visit(root)
 }
 
-// TargetCount implements TargetAbstract.
-func (a *targetAbstract) TargetCount() int {
-	return a.delegate.NumChildren()
+// ------ Transformers ------
+
+// TargetTransformer applies type-specific rewrites to a Target,
+// without the need to write a type switch in a TargetWalkerFn.
+// A TargetTransformer should be constructed with
+// NewTargetTransformer and configured by registering a function for
+// each type of interest.
+type TargetTransformer struct {
+	onByRefType        func(*ByRefType) *ByRefType
+	onByValType        func(*ByValType) *ByValType
+	onContainerType    func(*ContainerType) *ContainerType
+	onEmbeddingType    func(*EmbeddingType) *EmbeddingType
+	onEncapsulatedType func(*EncapsulatedType) *EncapsulatedType
+	onLooseType        func(*LooseType) *LooseType
+	onPairType         func(*PairType) *PairType
+	onScopeType        func(*ScopeType) *ScopeType
+	onWrapperType      func(*WrapperType) *WrapperType
 }
 
-// TargetTypeID implements TargetAbstract.
-func (a *targetAbstract) TargetTypeID() TargetTypeID {
-	return TargetTypeID(a.delegate.TypeID())
+// NewTargetTransformer returns a TargetTransformer which has no
+// registered functions.
+func NewTargetTransformer() *TargetTransformer {
+	return &TargetTransformer{}
 }
 
-// TargetAt implements TargetAbstract.
-func (x *ByRefType) TargetAt(index int) TargetAbstract {
-	self := targetAbstract{targetEngine.Abstract(e.TypeID(TargetTypeByRefType), e.Ptr(x))}
-	return self.TargetAt(index)
+// OnByRefType registers a function which will be invoked with
+// each ByRefType, replacing any previously-registered function. If fn
+// returns the pointer that it was given, the value is unchanged.
+// Otherwise, the value is replaced by the returned value.
+func (t *TargetTransformer) OnByRefType(fn func(*ByRefType) *ByRefType) *TargetTransformer {
+	t.onByRefType = fn
+	return t
 }
 
-// TargetCount returns 0.
-func (x *ByRefType) TargetCount() int { return 0 }
+// OnByValType registers a function which will be invoked with
+// each ByValType, replacing any previously-registered function. If fn
+// returns the pointer that it was given, the value is unchanged.
+// Otherwise, the value is replaced by the returned value.
+func (t *TargetTransformer) OnByValType(fn func(*ByValType) *ByValType) *TargetTransformer {
+	t.onByValType = fn
+	return t
+}
 
-// TargetTypeID returns TargetTypeByRefType.
-func (*ByRefType) TargetTypeID() TargetTypeID { return TargetTypeByRefType }
+// OnContainerType registers a function which will be invoked with
+// each ContainerType, replacing any previously-registered function. If fn
+// returns the pointer that it was given, the value is unchanged.
+// Otherwise, the value is replaced by the returned value.
+func (t *TargetTransformer) OnContainerType(fn func(*ContainerType) *ContainerType) *TargetTransformer {
+	t.onContainerType = fn
+	return t
+}
 
-// WalkTarget visits the receiver with the provided callback.
-func (x *ByRefType) WalkTarget(fn TargetWalkerFn) (_ *ByRefType, changed bool, err error) {
-	var y e.Ptr
-	_, y, changed, err = targetEngine.Execute(fn, e.TypeID(TargetTypeByRefType), e.Ptr(x), e.TypeID(TargetTypeByRefType))
-	if err != nil {
-		return nil, false, err
-	}
-	return (*ByRefType)(y), changed, nil
+// OnEmbeddingType registers a function which will be invoked with
+// each EmbeddingType, replacing any previously-registered function. If fn
+// returns the pointer that it was given, the value is unchanged.
+// Otherwise, the value is replaced by the returned value.
+func (t *TargetTransformer) OnEmbeddingType(fn func(*EmbeddingType) *EmbeddingType) *TargetTransformer {
+	t.onEmbeddingType = fn
+	return t
 }
 
-// TargetAt implements TargetAbstract.
-func (x *ByValType) TargetAt(index int) TargetAbstract {
-	self := targetAbstract{targetEngine.Abstract(e.TypeID(TargetTypeByValType), e.Ptr(x))}
-	return self.TargetAt(index)
+// OnEncapsulatedType registers a function which will be invoked with
+// each EncapsulatedType, replacing any previously-registered function. If fn
+// returns the pointer that it was given, the value is unchanged.
+// Otherwise, the value is replaced by the returned value.
+func (t *TargetTransformer) OnEncapsulatedType(fn func(*EncapsulatedType) *EncapsulatedType) *TargetTransformer {
+	t.onEncapsulatedType = fn
+	return t
 }
 
-// TargetCount returns 0.
-func (x *ByValType) TargetCount() int { return 0 }
+// OnLooseType registers a function which will be invoked with
+// each LooseType, replacing any previously-registered function. If fn
+// returns the pointer that it was given, the value is unchanged.
+// Otherwise, the value is replaced by the returned value.
+func (t *TargetTransformer) OnLooseType(fn func(*LooseType) *LooseType) *TargetTransformer {
+	t.onLooseType = fn
+	return t
+}
 
-// TargetTypeID returns TargetTypeByValType.
-func (*ByValType) TargetTypeID() TargetTypeID { return TargetTypeByValType }
+// OnPairType registers a function which will be invoked with
+// each PairType, replacing any previously-registered function. If fn
+// returns the pointer that it was given, the value is unchanged.
+// Otherwise, the value is replaced by the returned value.
+func (t *TargetTransformer) OnPairType(fn func(*PairType) *PairType) *TargetTransformer {
+	t.onPairType = fn
+	return t
+}
 
-// WalkTarget visits the receiver with the provided callback.
-func (x *ByValType) WalkTarget(fn TargetWalkerFn) (_ *ByValType, changed bool, err error) {
-	var y e.Ptr
-	_, y, changed, err = targetEngine.Execute(fn, e.TypeID(TargetTypeByValType), e.Ptr(x), e.TypeID(TargetTypeByValType))
-	if err != nil {
-		return nil, false, err
-	}
-	return (*ByValType)(y), changed, nil
+// OnScopeType registers a function which will be invoked with
+// each ScopeType, replacing any previously-registered function. If fn
+// returns the pointer that it was given, the value is unchanged.
+// Otherwise, the value is replaced by the returned value.
+func (t *TargetTransformer) OnScopeType(fn func(*ScopeType) *ScopeType) *TargetTransformer {
+	t.onScopeType = fn
+	return t
 }
 
-// TargetAt implements TargetAbstract.
-func (x *ContainerType) TargetAt(index int) TargetAbstract {
-	self := targetAbstract{targetEngine.Abstract(e.TypeID(TargetTypeContainerType), e.Ptr(x))}
-	return self.TargetAt(index)
+// OnWrapperType registers a function which will be invoked with
+// each WrapperType, replacing any previously-registered function. If fn
+// returns the pointer that it was given, the value is unchanged.
+// Otherwise, the value is replaced by the returned value.
+func (t *TargetTransformer) OnWrapperType(fn func(*WrapperType) *WrapperType) *TargetTransformer {
+	t.onWrapperType = fn
+	return t
+}
+
+// Walk applies the registered functions to x and to the values within
+// it, returning an updated copy of x if any value was replaced. The
+// values within a replacement are visited in turn. An error will be
+// returned if a registered function returns nil.
+func (t *TargetTransformer) Walk(x Target) (_ Target, changed bool, err error) {
+	var types []TargetTypeID
+	if t.onByRefType != nil {
+		types = append(types, TargetTypeByRefType)
+	}
+	if t.onByValType != nil {
+		types = append(types, TargetTypeByValType)
+	}
+	if t.onContainerType != nil {
+		types = append(types, TargetTypeContainerType)
+	}
+	if t.onEmbeddingType != nil {
+		types = append(types, TargetTypeEmbeddingType)
+	}
+	if t.onEncapsulatedType != nil {
+		types = append(types, TargetTypeEncapsulatedType)
+	}
+	if t.onLooseType != nil {
+		types = append(types, TargetTypeLooseType)
+	}
+	if t.onPairType != nil {
+		types = append(types, TargetTypePairType)
+	}
+	if t.onScopeType != nil {
+		types = append(types, TargetTypeScopeType)
+	}
+	if t.onWrapperType != nil {
+		types = append(types, TargetTypeWrapperType)
+	}
+	if len(types) == 0 {
+		return x, false, nil
+	}
+	return WalkTargetOfTypes(x, func(ctx TargetContext, x Target) TargetDecision {
+		switch x := x.(type) {
+		case *ByRefType:
+			next := t.onByRefType(x)
+			return targetTransformed(ctx, next, next == nil, next == x, "ByRefType")
+		case *ByValType:
+			next := t.onByValType(x)
+			return targetTransformed(ctx, next, next == nil, next == x, "ByValType")
+		case *ContainerType:
+			next := t.onContainerType(x)
+			return targetTransformed(ctx, next, next == nil, next == x, "ContainerType")
+		case *EmbeddingType:
+			next := t.onEmbeddingType(x)
+			return targetTransformed(ctx, next, next == nil, next == x, "EmbeddingType")
+		case *EncapsulatedType:
+			next := t.onEncapsulatedType(x)
+			return targetTransformed(ctx, next, next == nil, next == x, "EncapsulatedType")
+		case *LooseType:
+			next := t.onLooseType(x)
+			return targetTransformed(ctx, next, next == nil, next == x, "LooseType")
+		case *PairType:
+			next := t.onPairType(x)
+			return targetTransformed(ctx, next, next == nil, next == x, "PairType")
+		case *ScopeType:
+			next := t.onScopeType(x)
+			return targetTransformed(ctx, next, next == nil, next == x, "ScopeType")
+		case *WrapperType:
+			next := t.onWrapperType(x)
+			return targetTransformed(ctx, next, next == nil, next == x, "WrapperType")
+		}
+		return ctx.Continue()
+	}, types...)
 }
 
-// TargetCount returns 16.
-func (x *ContainerType) TargetCount() int { return 16 }
+// targetTransformed returns the decision for the value returned by a
+// function registered with a TargetTransformer, which is shared by
+// values visited by reference and by value.
+func targetTransformed(ctx TargetContext, next Target, isNil, unchanged bool, name string) TargetDecision {
+	switch {
+	case isNil:
+		return ctx.Error(fmt.Errorf("the function for %s returned nil", name))
+	case unchanged:
+		return ctx.Continue()
+	default:
+		return ctx.Continue().Replace(next)
+	}
+}
 
-// TargetTypeID returns TargetTypeContainerType.
-func (*ContainerType) TargetTypeID() TargetTypeID { return TargetTypeContainerType }
+// ------ Tree Assertions ------
 
-// WalkTarget visits the receiver with the provided callback.
-func (x *ContainerType) WalkTarget(fn TargetWalkerFn) (_ *ContainerType, changed bool, err error) {
-	var y e.Ptr
-	_, y, changed, err = targetEngine.Execute(fn, e.TypeID(TargetTypeContainerType), e.Ptr(x), e.TypeID(TargetTypeContainerType))
-	if err != nil {
-		return nil, false, err
+// AssertTargetIsTree visits root and returns an error if any
+// struct value is reachable from more than one location, i.e. if the
+// values form a DAG instead of a tree. The error describes the paths
+// to both locations. Back-references which form cycles are not visited
+// and so are not reported; use WalkTargetDetectCycles to find
+// them. Structs which are passed to callbacks by value cannot be
+// identified, so they are not checked.
+func AssertTargetIsTree(root Target) error {
+	if root == nil {
+		return nil
 	}
-	return (*ContainerType)(y), changed, nil
+	id, ptr := targetIdentify(root)
+	if ptr == nil {
+		return nil
+	}
+	type key struct {
+		id  e.TypeID
+		ptr e.Ptr
+	}
+	seen := make(map[key]e.Path)
+	fn := func(ctx TargetContext, x Target) TargetDecision {
+		id, ptr := targetIdentify(x)
+		k := key{id, ptr}
+		path := ctx.impl.Path()
+		if prior, found := seen[k]; found {
+			return ctx.Error(fmt.Errorf("%s is reachable from both %q and %q",
+				TargetTypeID(id), prior, path))
+		}
+		seen[k] = path
+		return ctx.Continue()
+	}
+	_, _, _, err := targetEngine.Execute(TargetWalkerFn(fn), id, ptr, e.TypeID(TargetTypeTarget))
+	return err
 }
 
-// WalkTarget visits the receiver with the provided callback.
-func WalkTarget(x Target, fn TargetWalkerFn) (_ Target, changed bool, err error) {
-	id, ptr := targetIdentify(x)
-	id, ptr, changed, err = targetEngine.Execute(fn, id, ptr, e.TypeID(TargetTypeTarget))
-	if err != nil {
-		return nil, false, err
+// ------ Fan-out ------
+
+// WidestSliceTarget returns the location and length of the longest
+// visitable slice within root, e.g. "TargetSlice[2].NamedTargets". If
+// several slices share the longest length, the first one to be visited
+// is returned. An empty path and a zero length are returned if root
+// does not contain any non-empty slices.
+func WidestSliceTarget(root Target) (path string, length int) {
+	if root == nil {
+		return "", 0
 	}
-	if changed {
-		return targetWrap(id, ptr), true, nil
+	id, ptr := targetIdentify(root)
+	if ptr == nil {
+		return "", 0
 	}
-	return x, false, nil
+	var fn TargetWalkerFn = func(ctx TargetContext, _ Target) (d TargetDecision) { return }
+	_, _, _, _ = targetEngine.Execute(fn, id, ptr, e.TypeID(TargetTypeTarget),
+		e.WithSliceHook(func(p e.Path, _ e.TypeID, n int) {
+			if n > length {
+				path, length = p.String(), n
+			}
+		}))
+	return path, length
 }
 
 // ------ Type Mapping ------
@@ -357,6 +4361,9 @@ var targetEngine = e.New(e.TypeMap{
 			{Name: "TargetSlice", Offset: unsafe.Offsetof(ContainerType{}.TargetSlice), Target: e.TypeID(TargetTypeTargetSlice)},
 			{Name: "InterfacePtrSlice", Offset: unsafe.Offsetof(ContainerType{}.InterfacePtrSlice), Target: e.TypeID(TargetTypeTargetPtrSlice)},
 			{Name: "NamedTargets", Offset: unsafe.Offsetof(ContainerType{}.NamedTargets), Target: e.TypeID(TargetTypeTargetSlice)},
+			{Name: "Quad", Offset: unsafe.Offsetof(ContainerType{}.Quad), Target: e.TypeID(TargetTypeTargetArray4)},
+			{Name: "OptTarget", Offset: unsafe.Offsetof(ContainerType{}.OptTarget), Target: e.TypeID(TargetTypeByRefTypePtr)},
+			{Name: "Annotated", Offset: unsafe.Offsetof(ContainerType{}.Annotated), Target: e.TypeID(TargetTypeAnnotated)},
 		},
 		Name:      "ContainerType",
 		NewStruct: func() e.Ptr { return e.Ptr(&ContainerType{}) },
@@ -364,8 +4371,173 @@ var targetEngine = e.New(e.TypeMap{
 		Kind:      e.KindStruct,
 		TypeID:    e.TypeID(TargetTypeContainerType),
 	},
+	TargetTypeEmbeddingType: {
+		Copy: func(dest, from e.Ptr) { *(*EmbeddingType)(dest) = *(*EmbeddingType)(from) },
+		Facade: func(impl e.Context, fn e.FacadeFn, x e.Ptr) e.Decision {
+			return e.Decision(fn.(TargetWalkerFn)(TargetContext{impl}, (*EmbeddingType)(x)))
+		},
+		Fields: []e.FieldInfo{
+			{Name: "Promoted", Offset: unsafe.Offsetof(EmbeddingType{}.Promoted), Target: e.TypeID(TargetTypeTarget)},
+			{Name: "Promoteds", Offset: unsafe.Offsetof(EmbeddingType{}.Promoteds), Target: e.TypeID(TargetTypeTargetSlice)},
+			{Name: "Scoped", Offset: unsafe.Offsetof(EmbeddingType{}.Scoped), Target: e.TypeID(TargetTypeTarget)},
+			{Name: "Own", Offset: unsafe.Offsetof(EmbeddingType{}.Own), Target: e.TypeID(TargetTypeTarget)},
+		},
+		Name:      "EmbeddingType",
+		NewStruct: func() e.Ptr { return e.Ptr(&EmbeddingType{}) },
+		SizeOf:    unsafe.Sizeof(EmbeddingType{}),
+		Kind:      e.KindStruct,
+		TypeID:    e.TypeID(TargetTypeEmbeddingType),
+	},
+	TargetTypeEncapsulatedType: {
+		Copy: func(dest, from e.Ptr) { *(*EncapsulatedType)(dest) = *(*EncapsulatedType)(from) },
+		Facade: func(impl e.Context, fn e.FacadeFn, x e.Ptr) e.Decision {
+			return e.Decision(fn.(TargetWalkerFn)(TargetContext{impl}, (*EncapsulatedType)(x)))
+		},
+		Fields: []e.FieldInfo{},
+		Getters: []e.GetterInfo{
+			{
+				Get: func(x e.Ptr) e.Ptr {
+					ret := (*EncapsulatedType)(x).Children()
+					return e.Ptr(&ret)
+				},
+				Name:   "Children",
+				Target: e.TypeID(TargetTypeTargetSlice),
+			},
+		},
+		Name:      "EncapsulatedType",
+		NewStruct: func() e.Ptr { return e.Ptr(&EncapsulatedType{}) },
+		SizeOf:    unsafe.Sizeof(EncapsulatedType{}),
+		Kind:      e.KindStruct,
+		TypeID:    e.TypeID(TargetTypeEncapsulatedType),
+	},
+	TargetTypeLooseType: {
+		Copy: func(dest, from e.Ptr) { *(*LooseType)(dest) = *(*LooseType)(from) },
+		Facade: func(impl e.Context, fn e.FacadeFn, x e.Ptr) e.Decision {
+			return e.Decision(fn.(TargetWalkerFn)(TargetContext{impl}, (*LooseType)(x)))
+		},
+		Fields: []e.FieldInfo{
+			{Name: "Child", Offset: unsafe.Offsetof(LooseType{}.Child), Target: e.TypeID(TargetTypeAnyOfByRefTypeOrByValType)},
+		},
+		Name:      "LooseType",
+		NewStruct: func() e.Ptr { return e.Ptr(&LooseType{}) },
+		SizeOf:    unsafe.Sizeof(LooseType{}),
+		Kind:      e.KindStruct,
+		TypeID:    e.TypeID(TargetTypeLooseType),
+	},
+	TargetTypePairType: {
+		Copy: func(dest, from e.Ptr) { *(*PairType)(dest) = *(*PairType)(from) },
+		Facade: func(impl e.Context, fn e.FacadeFn, x e.Ptr) e.Decision {
+			return e.Decision(fn.(TargetWalkerFn)(TargetContext{impl}, (*PairType)(x)))
+		},
+		Fields: []e.FieldInfo{
+			{Name: "Pair", Offset: unsafe.Offsetof(PairType{}.Pair), Target: e.TypeID(TargetTypeByRefTypeArray2)},
+		},
+		Name:      "PairType",
+		NewStruct: func() e.Ptr { return e.Ptr(&PairType{}) },
+		SizeOf:    unsafe.Sizeof(PairType{}),
+		Kind:      e.KindStruct,
+		TypeID:    e.TypeID(TargetTypePairType),
+	},
+	TargetTypeScopeType: {
+		Copy: func(dest, from e.Ptr) { *(*ScopeType)(dest) = *(*ScopeType)(from) },
+		Facade: func(impl e.Context, fn e.FacadeFn, x e.Ptr) e.Decision {
+			return e.Decision(fn.(TargetWalkerFn)(TargetContext{impl}, (*ScopeType)(x)))
+		},
+		Fields: []e.FieldInfo{
+			{Name: "Env", Offset: unsafe.Offsetof(ScopeType{}.Env), Target: e.TypeID(TargetTypeTargetMapByString)},
+		},
+		Name:      "ScopeType",
+		NewStruct: func() e.Ptr { return e.Ptr(&ScopeType{}) },
+		SizeOf:    unsafe.Sizeof(ScopeType{}),
+		Kind:      e.KindStruct,
+		TypeID:    e.TypeID(TargetTypeScopeType),
+	},
+	TargetTypeWrapperType: {
+		Copy: func(dest, from e.Ptr) { *(*WrapperType)(dest) = *(*WrapperType)(from) },
+		Facade: func(impl e.Context, fn e.FacadeFn, x e.Ptr) e.Decision {
+			return e.Decision(fn.(TargetWalkerFn)(TargetContext{impl}, (*WrapperType)(x)))
+		},
+		Fields: []e.FieldInfo{
+			{Name: "Target", Offset: unsafe.Offsetof(WrapperType{}.Target), Target: e.TypeID(TargetTypeTarget)},
+		},
+		Name:      "WrapperType",
+		NewStruct: func() e.Ptr { return e.Ptr(&WrapperType{}) },
+		SizeOf:    unsafe.Sizeof(WrapperType{}),
+		Kind:      e.KindStruct,
+		TypeID:    e.TypeID(TargetTypeWrapperType),
+	},
 
 	// ------ Interfaces ------
+	TargetTypeAnnotated: {
+		Copy: func(dest, from e.Ptr) {
+			*(*Annotated)(dest) = *(*Annotated)(from)
+		},
+		IntfType: func(x e.Ptr) e.TypeID {
+			d := *(*Annotated)(x)
+			switch d.(type) {
+			case *ByRefType:
+				return e.TypeID(TargetTypeByRefType)
+			default:
+				return 0
+			}
+		},
+		IntfWrap: func(id e.TypeID, x e.Ptr) e.Ptr {
+			var d Annotated
+			switch TargetTypeID(id) {
+			case TargetTypeByRefType:
+				d = (*ByRefType)(x)
+			case TargetTypeByRefTypePtr:
+				d = *(**ByRefType)(x)
+			default:
+				return nil
+			}
+			return e.Ptr(&d)
+		},
+		Kind:   e.KindInterface,
+		Name:   "Annotated",
+		SizeOf: unsafe.Sizeof(Annotated(nil)),
+		TypeID: e.TypeID(TargetTypeAnnotated),
+	},
+	TargetTypeAnyOfByRefTypeOrByValType: {
+		Copy: func(dest, from e.Ptr) {
+			*(*interface{})(dest) = *(*interface{})(from)
+		},
+		IntfType: func(x e.Ptr) e.TypeID {
+			d := *(*interface{})(x)
+			switch d.(type) {
+			case ByRefType:
+				return e.TypeID(TargetTypeByRefType)
+			case *ByRefType:
+				return e.TypeID(TargetTypeByRefType)
+			case ByValType:
+				return e.TypeID(TargetTypeByValType)
+			case *ByValType:
+				return e.TypeID(TargetTypeByValType)
+			default:
+				return 0
+			}
+		},
+		IntfWrap: func(id e.TypeID, x e.Ptr) e.Ptr {
+			var d interface{}
+			switch TargetTypeID(id) {
+			case TargetTypeByRefType:
+				d = (*ByRefType)(x)
+			case TargetTypeByRefTypePtr:
+				d = *(**ByRefType)(x)
+			case TargetTypeByValType:
+				d = (*ByValType)(x)
+			case TargetTypeByValTypePtr:
+				d = *(**ByValType)(x)
+			default:
+				return nil
+			}
+			return e.Ptr(&d)
+		},
+		Kind:   e.KindInterface,
+		Name:   "interface{}",
+		SizeOf: unsafe.Sizeof(interface{}(nil)),
+		TypeID: e.TypeID(TargetTypeAnyOfByRefTypeOrByValType),
+	},
 	TargetTypeEmbedsTarget: {
 		Copy: func(dest, from e.Ptr) {
 			*(*EmbedsTarget)(dest) = *(*EmbedsTarget)(from)
@@ -413,6 +4585,18 @@ var targetEngine = e.New(e.TypeMap{
 				return e.TypeID(TargetTypeByValType)
 			case *ContainerType:
 				return e.TypeID(TargetTypeContainerType)
+			case *EmbeddingType:
+				return e.TypeID(TargetTypeEmbeddingType)
+			case *EncapsulatedType:
+				return e.TypeID(TargetTypeEncapsulatedType)
+			case *LooseType:
+				return e.TypeID(TargetTypeLooseType)
+			case *PairType:
+				return e.TypeID(TargetTypePairType)
+			case *ScopeType:
+				return e.TypeID(TargetTypeScopeType)
+			case *WrapperType:
+				return e.TypeID(TargetTypeWrapperType)
 			default:
 				return 0
 			}
@@ -432,6 +4616,30 @@ var targetEngine = e.New(e.TypeMap{
 				d = (*ContainerType)(x)
 			case TargetTypeContainerTypePtr:
 				d = *(**ContainerType)(x)
+			case TargetTypeEmbeddingType:
+				d = (*EmbeddingType)(x)
+			case TargetTypeEmbeddingTypePtr:
+				d = *(**EmbeddingType)(x)
+			case TargetTypeEncapsulatedType:
+				d = (*EncapsulatedType)(x)
+			case TargetTypeEncapsulatedTypePtr:
+				d = *(**EncapsulatedType)(x)
+			case TargetTypeLooseType:
+				d = (*LooseType)(x)
+			case TargetTypeLooseTypePtr:
+				d = *(**LooseType)(x)
+			case TargetTypePairType:
+				d = (*PairType)(x)
+			case TargetTypePairTypePtr:
+				d = *(**PairType)(x)
+			case TargetTypeScopeType:
+				d = (*ScopeType)(x)
+			case TargetTypeScopeTypePtr:
+				d = *(**ScopeType)(x)
+			case TargetTypeWrapperType:
+				d = (*WrapperType)(x)
+			case TargetTypeWrapperTypePtr:
+				d = *(**WrapperType)(x)
 			default:
 				return nil
 			}
@@ -471,6 +4679,15 @@ var targetEngine = e.New(e.TypeMap{
 		Kind:   e.KindPointer,
 		TypeID: e.TypeID(TargetTypeContainerTypePtr),
 	},
+	TargetTypeEmbeddingTypePtr: {
+		Copy: func(dest, from e.Ptr) {
+			*(**EmbeddingType)(dest) = *(**EmbeddingType)(from)
+		},
+		Elem:   e.TypeID(TargetTypeEmbeddingType),
+		SizeOf: unsafe.Sizeof((*EmbeddingType)(nil)),
+		Kind:   e.KindPointer,
+		TypeID: e.TypeID(TargetTypeEmbeddingTypePtr),
+	},
 	TargetTypeEmbedsTargetPtr: {
 		Copy: func(dest, from e.Ptr) {
 			*(**EmbedsTarget)(dest) = *(**EmbedsTarget)(from)
@@ -480,6 +4697,42 @@ var targetEngine = e.New(e.TypeMap{
 		Kind:   e.KindPointer,
 		TypeID: e.TypeID(TargetTypeEmbedsTargetPtr),
 	},
+	TargetTypeEncapsulatedTypePtr: {
+		Copy: func(dest, from e.Ptr) {
+			*(**EncapsulatedType)(dest) = *(**EncapsulatedType)(from)
+		},
+		Elem:   e.TypeID(TargetTypeEncapsulatedType),
+		SizeOf: unsafe.Sizeof((*EncapsulatedType)(nil)),
+		Kind:   e.KindPointer,
+		TypeID: e.TypeID(TargetTypeEncapsulatedTypePtr),
+	},
+	TargetTypeLooseTypePtr: {
+		Copy: func(dest, from e.Ptr) {
+			*(**LooseType)(dest) = *(**LooseType)(from)
+		},
+		Elem:   e.TypeID(TargetTypeLooseType),
+		SizeOf: unsafe.Sizeof((*LooseType)(nil)),
+		Kind:   e.KindPointer,
+		TypeID: e.TypeID(TargetTypeLooseTypePtr),
+	},
+	TargetTypePairTypePtr: {
+		Copy: func(dest, from e.Ptr) {
+			*(**PairType)(dest) = *(**PairType)(from)
+		},
+		Elem:   e.TypeID(TargetTypePairType),
+		SizeOf: unsafe.Sizeof((*PairType)(nil)),
+		Kind:   e.KindPointer,
+		TypeID: e.TypeID(TargetTypePairTypePtr),
+	},
+	TargetTypeScopeTypePtr: {
+		Copy: func(dest, from e.Ptr) {
+			*(**ScopeType)(dest) = *(**ScopeType)(from)
+		},
+		Elem:   e.TypeID(TargetTypeScopeType),
+		SizeOf: unsafe.Sizeof((*ScopeType)(nil)),
+		Kind:   e.KindPointer,
+		TypeID: e.TypeID(TargetTypeScopeTypePtr),
+	},
 	TargetTypeTargetPtr: {
 		Copy: func(dest, from e.Ptr) {
 			*(**Target)(dest) = *(**Target)(from)
@@ -489,6 +4742,76 @@ var targetEngine = e.New(e.TypeMap{
 		Kind:   e.KindPointer,
 		TypeID: e.TypeID(TargetTypeTargetPtr),
 	},
+	TargetTypeWrapperTypePtr: {
+		Copy: func(dest, from e.Ptr) {
+			*(**WrapperType)(dest) = *(**WrapperType)(from)
+		},
+		Elem:   e.TypeID(TargetTypeWrapperType),
+		SizeOf: unsafe.Sizeof((*WrapperType)(nil)),
+		Kind:   e.KindPointer,
+		TypeID: e.TypeID(TargetTypeWrapperTypePtr),
+	},
+
+	// ------ Arrays ------
+	TargetTypeByRefTypeArray2: {
+		Copy: func(dest, from e.Ptr) {
+			*(*[2]ByRefType)(dest) = *(*[2]ByRefType)(from)
+		},
+		Elem:     e.TypeID(TargetTypeByRefType),
+		Kind:     e.KindArray,
+		Len:      2,
+		NewArray: func() e.Ptr { return e.Ptr(&[2]ByRefType{}) },
+		SizeOf:   unsafe.Sizeof([2]ByRefType{}),
+		TypeID:   e.TypeID(TargetTypeByRefTypeArray2),
+	},
+	TargetTypeTargetArray4: {
+		Copy: func(dest, from e.Ptr) {
+			*(*[4]Target)(dest) = *(*[4]Target)(from)
+		},
+		Elem:     e.TypeID(TargetTypeTarget),
+		Kind:     e.KindArray,
+		Len:      4,
+		NewArray: func() e.Ptr { return e.Ptr(&[4]Target{}) },
+		SizeOf:   unsafe.Sizeof([4]Target{}),
+		TypeID:   e.TypeID(TargetTypeTargetArray4),
+	},
+
+	// ------ Maps ------
+	TargetTypeTargetMapByString: {
+		Copy: func(dest, from e.Ptr) {
+			*(*map[string]Target)(dest) = *(*map[string]Target)(from)
+		},
+		Elem: e.TypeID(TargetTypeTarget),
+		Kind: e.KindMap,
+		MapEntries: func(x e.Ptr) (keys, values []e.Ptr) {
+			m := *(*map[string]Target)(x)
+			ks := make([]string, 0, len(m))
+			for k := range m {
+				ks = append(ks, k)
+			}
+			sort.Slice(ks, func(i, j int) bool { return ks[i] < ks[j] })
+			vs := make([]Target, len(ks))
+			keys, values = make([]e.Ptr, len(ks)), make([]e.Ptr, len(ks))
+			for i, k := range ks {
+				vs[i] = m[k]
+				keys[i], values[i] = e.Ptr(&ks[i]), e.Ptr(&vs[i])
+			}
+			return keys, values
+		},
+		MapKey: func(key e.Ptr) interface{} {
+			return *(*string)(key)
+		},
+		Name: "map[string]Target",
+		NewMap: func(size int) e.Ptr {
+			x := make(map[string]Target, size)
+			return e.Ptr(&x)
+		},
+		SetMapIndex: func(m, key, value e.Ptr) {
+			(*(*map[string]Target)(m))[*(*string)(key)] = *(*Target)(value)
+		},
+		SizeOf: unsafe.Sizeof((map[string]Target)(nil)),
+		TypeID: e.TypeID(TargetTypeTargetMapByString),
+	},
 
 	// ------ Slices ------
 	TargetTypeByRefTypePtrSlice: {
@@ -571,28 +4894,141 @@ var targetEngine = e.New(e.TypeMap{
 	},
 })
 
-// These are lightweight type tokens.
+// These are lightweight type tokens. A token retains its value when
+// the code is regenerated, so that tokens may be persisted.
 const (
-	_ TargetTypeID = iota
-	TargetTypeByRefType
-	TargetTypeByRefTypePtr
-	TargetTypeByRefTypePtrSlice
-	TargetTypeByRefTypeSlice
-	TargetTypeByValType
-	TargetTypeByValTypePtr
-	TargetTypeByValTypePtrSlice
-	TargetTypeByValTypeSlice
-	TargetTypeContainerType
-	TargetTypeContainerTypePtr
-	TargetTypeEmbedsTarget
-	TargetTypeEmbedsTargetPtr
-	TargetTypeTarget
-	TargetTypeTargetPtr
-	TargetTypeTargetPtrSlice
-	TargetTypeTargetSlice
+	TargetTypeAnnotated                 TargetTypeID = 1
+	TargetTypeByRefType                 TargetTypeID = 2
+	TargetTypeByRefTypePtr              TargetTypeID = 3
+	TargetTypeByRefTypePtrSlice         TargetTypeID = 4
+	TargetTypeByRefTypeSlice            TargetTypeID = 5
+	TargetTypeByValType                 TargetTypeID = 6
+	TargetTypeByValTypePtr              TargetTypeID = 7
+	TargetTypeByValTypePtrSlice         TargetTypeID = 8
+	TargetTypeByValTypeSlice            TargetTypeID = 9
+	TargetTypeContainerType             TargetTypeID = 10
+	TargetTypeContainerTypePtr          TargetTypeID = 11
+	TargetTypeEmbedsTarget              TargetTypeID = 12
+	TargetTypeEmbedsTargetPtr           TargetTypeID = 13
+	TargetTypeEncapsulatedType          TargetTypeID = 14
+	TargetTypeEncapsulatedTypePtr       TargetTypeID = 15
+	TargetTypeTarget                    TargetTypeID = 16
+	TargetTypeTargetArray4              TargetTypeID = 17
+	TargetTypeTargetPtr                 TargetTypeID = 18
+	TargetTypeTargetPtrSlice            TargetTypeID = 19
+	TargetTypeTargetSlice               TargetTypeID = 20
+	TargetTypeWrapperType               TargetTypeID = 21
+	TargetTypeWrapperTypePtr            TargetTypeID = 22
+	TargetTypeScopeType                 TargetTypeID = 23
+	TargetTypeScopeTypePtr              TargetTypeID = 24
+	TargetTypeTargetMapByString         TargetTypeID = 25
+	TargetTypeByRefTypeArray2           TargetTypeID = 26
+	TargetTypePairType                  TargetTypeID = 27
+	TargetTypePairTypePtr               TargetTypeID = 28
+	TargetTypeEmbeddingType             TargetTypeID = 29
+	TargetTypeEmbeddingTypePtr          TargetTypeID = 30
+	TargetTypeAnyOfByRefTypeOrByValType TargetTypeID = 31
+	TargetTypeLooseType                 TargetTypeID = 32
+	TargetTypeLooseTypePtr              TargetTypeID = 33
 )
 
+// targetTypeIDLimit is one greater than the largest type token
+// that has ever been assigned. It is used by the code generator to
+// ensure that the tokens of removed types are not reused.
+const targetTypeIDLimit = 34
+
 // String is for debugging use only.
 func (t TargetTypeID) String() string {
 	return targetEngine.Stringify(e.TypeID(t))
 }
+
+// Implements returns true if the struct type denoted by the token
+// implements the interface type denoted by intf.
+func (t TargetTypeID) Implements(intf TargetTypeID) bool {
+	_, ok := targetImplements[t][intf]
+	return ok
+}
+
+// targetImplements maps struct type tokens onto the interface
+// type tokens that they implement.
+var targetImplements = map[TargetTypeID]map[TargetTypeID]struct{}{
+	TargetTypeByRefType: {
+		TargetTypeAnnotated: {},
+		TargetTypeTarget:    {},
+	},
+	TargetTypeByValType: {
+		TargetTypeEmbedsTarget: {},
+		TargetTypeTarget:       {},
+	},
+	TargetTypeContainerType: {
+		TargetTypeTarget: {},
+	},
+	TargetTypeEmbeddingType: {
+		TargetTypeTarget: {},
+	},
+	TargetTypeEncapsulatedType: {
+		TargetTypeTarget: {},
+	},
+	TargetTypeLooseType: {
+		TargetTypeTarget: {},
+	},
+	TargetTypePairType: {
+		TargetTypeTarget: {},
+	},
+	TargetTypeScopeType: {
+		TargetTypeTarget: {},
+	},
+	TargetTypeWrapperType: {
+		TargetTypeTarget: {},
+	},
+}
+
+// ------ Layout Verification ------
+
+// init checks that each visitable field still exists and has the type
+// which was recorded in the type map. A field whose type has been
+// changed without re-running the generator would otherwise cause the
+// engine to misinterpret memory. The offset of each field is computed
+// by the compiler, so it needn't be checked.
+func init() {
+	targetVerifyLayout(ContainerType{}, "ContainerType", "ByRef", reflect.TypeOf((*ByRefType)(nil)).Elem())
+	targetVerifyLayout(ContainerType{}, "ContainerType", "ByRefPtr", reflect.TypeOf((**ByRefType)(nil)).Elem())
+	targetVerifyLayout(ContainerType{}, "ContainerType", "ByRefSlice", reflect.TypeOf((*[]ByRefType)(nil)).Elem())
+	targetVerifyLayout(ContainerType{}, "ContainerType", "ByRefPtrSlice", reflect.TypeOf((*[]*ByRefType)(nil)).Elem())
+	targetVerifyLayout(ContainerType{}, "ContainerType", "ByVal", reflect.TypeOf((*ByValType)(nil)).Elem())
+	targetVerifyLayout(ContainerType{}, "ContainerType", "ByValPtr", reflect.TypeOf((**ByValType)(nil)).Elem())
+	targetVerifyLayout(ContainerType{}, "ContainerType", "ByValSlice", reflect.TypeOf((*[]ByValType)(nil)).Elem())
+	targetVerifyLayout(ContainerType{}, "ContainerType", "ByValPtrSlice", reflect.TypeOf((*[]*ByValType)(nil)).Elem())
+	targetVerifyLayout(ContainerType{}, "ContainerType", "Container", reflect.TypeOf((**ContainerType)(nil)).Elem())
+	targetVerifyLayout(ContainerType{}, "ContainerType", "AnotherTarget", reflect.TypeOf((*Target)(nil)).Elem())
+	targetVerifyLayout(ContainerType{}, "ContainerType", "AnotherTargetPtr", reflect.TypeOf((**Target)(nil)).Elem())
+	targetVerifyLayout(ContainerType{}, "ContainerType", "EmbedsTarget", reflect.TypeOf((*EmbedsTarget)(nil)).Elem())
+	targetVerifyLayout(ContainerType{}, "ContainerType", "EmbedsTargetPtr", reflect.TypeOf((**EmbedsTarget)(nil)).Elem())
+	targetVerifyLayout(ContainerType{}, "ContainerType", "TargetSlice", reflect.TypeOf((*[]Target)(nil)).Elem())
+	targetVerifyLayout(ContainerType{}, "ContainerType", "InterfacePtrSlice", reflect.TypeOf((*[]*Target)(nil)).Elem())
+	targetVerifyLayout(ContainerType{}, "ContainerType", "NamedTargets", reflect.TypeOf((*Targets)(nil)).Elem())
+	targetVerifyLayout(ContainerType{}, "ContainerType", "Quad", reflect.TypeOf((*Quad)(nil)).Elem())
+	targetVerifyLayout(ContainerType{}, "ContainerType", "OptTarget", reflect.TypeOf((*OptTarget)(nil)).Elem())
+	targetVerifyLayout(ContainerType{}, "ContainerType", "Annotated", reflect.TypeOf((*Annotated)(nil)).Elem())
+	targetVerifyLayout(EmbeddingType{}, "EmbeddingType", "Promoted", reflect.TypeOf((*Target)(nil)).Elem())
+	targetVerifyLayout(EmbeddingType{}, "EmbeddingType", "Promoteds", reflect.TypeOf((*[]Target)(nil)).Elem())
+	targetVerifyLayout(EmbeddingType{}, "EmbeddingType", "Scoped", reflect.TypeOf((*Target)(nil)).Elem())
+	targetVerifyLayout(EmbeddingType{}, "EmbeddingType", "Own", reflect.TypeOf((*Target)(nil)).Elem())
+	targetVerifyLayout(LooseType{}, "LooseType", "Child", reflect.TypeOf((*interface{})(nil)).Elem())
+	targetVerifyLayout(PairType{}, "PairType", "Pair", reflect.TypeOf((*[2]ByRefType)(nil)).Elem())
+	targetVerifyLayout(ScopeType{}, "ScopeType", "Env", reflect.TypeOf((*map[string]Target)(nil)).Elem())
+	targetVerifyLayout(WrapperType{}, "WrapperType", "Target", reflect.TypeOf((*Target)(nil)).Elem())
+}
+
+// targetVerifyLayout panics if the named field of x does not have the
+// given type. The field may be promoted from an embedded struct.
+func targetVerifyLayout(x interface{}, structName, field string, typ reflect.Type) {
+	f, ok := reflect.TypeOf(x).FieldByName(field)
+	switch {
+	case !ok:
+		panic(fmt.Sprintf("%s.%s no longer exists; the generated code is out of date", structName, field))
+	case f.Type != typ:
+		panic(fmt.Sprintf("%s.%s has type %s, not %s; the generated code is out of date",
+			structName, field, f.Type, typ))
+	}
+}
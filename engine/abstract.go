@@ -25,63 +25,146 @@ import (
 
 // Abstract allows a visitable object to be manipulated as an abstract
 // tree of nodes. This should be enclosed in a type-safe wrapper.
-// An Abstract should only ever represent a struct or a slice;
-// pointers and interfaces should be resolved to their respective
-// targets before being wrapped in an Abstract.
+// An Abstract should only ever represent a struct, an array, a map, or
+// a slice; pointers and interfaces should be resolved to their
+// respective targets before being wrapped in an Abstract.
 type Abstract struct {
-	engine   *Engine
+	engine *Engine
+	// index is the position of the value within its parent.
+	index int
+	// parent is the Abstract whose ChildAt method returned this one. It
+	// will be nil for an Abstract constructed by Engine.Abstract.
+	parent   *Abstract
 	typeData *TypeData
 	value    Ptr
 }
 
-// ChildAt returns the nth field or slice element. If that value is
-// a pointer or an interface, it is dereferenced before returning.
-// Nil pointers, interfaces, and empty slices will return nil here.
+// ChildAt returns the nth field or element. If that value is a
+// pointer or an interface, it is dereferenced before returning.
+// Nil pointers, interfaces, and empty arrays, maps, or slices will
+// return nil here. The entries of a map are copies, which are ordered
+// as they would be visited.
 func (a *Abstract) ChildAt(index int) *Abstract {
-	var chaseType *TypeData
-	var chaseValue Ptr
+	var values []Ptr
+	if a.typeData.Kind == KindMap {
+		_, values = a.typeData.MapEntries(a.value)
+	}
+	return a.child(index, values)
+}
+
+// child implements ChildAt, given the values of a map as returned by
+// MapEntries, so that a caller may look up several entries of a map.
+func (a *Abstract) child(index int, values []Ptr) *Abstract {
+	chaseType, chaseValue := a.resolve(a.selectChild(index, values))
+	if chaseType == nil {
+		return nil
+	}
+	return &Abstract{
+		engine:   a.engine,
+		index:    index,
+		parent:   a,
+		typeData: chaseType,
+		value:    chaseValue,
+	}
+}
+
+// Children invokes fn with each field or element, following the same
+// rules as ChildAt, until fn returns false. A single Abstract is reused
+// to present every non-nil child, so that a read-only traversal does
+// not allocate for each child. The child passed to fn, and any Abstract
+// derived from it, must not be retained after fn returns.
+func (a *Abstract) Children(fn func(index int, child *Abstract) bool) {
+	count := a.NumChildren()
+	if count == 0 {
+		return
+	}
+	var values []Ptr
+	if a.typeData.Kind == KindMap {
+		_, values = a.typeData.MapEntries(a.value)
+	}
+	scratch := Abstract{engine: a.engine, parent: a}
+	for index := 0; index < count; index++ {
+		chaseType, chaseValue := a.resolve(a.selectChild(index, values))
+		if chaseType == nil {
+			if !fn(index, nil) {
+				return
+			}
+			continue
+		}
+		scratch.index = index
+		scratch.typeData = chaseType
+		scratch.value = chaseValue
+		if !fn(index, &scratch) {
+			return
+		}
+	}
+}
 
-	// First, we select the child value.
+// selectChild returns the type and location of the nth field or
+// element. The values must be the map entries of a map.
+func (a *Abstract) selectChild(index int, values []Ptr) (*TypeData, Ptr) {
 	switch a.typeData.Kind {
+	case KindArray:
+		if index < 0 || index >= a.typeData.Len {
+			panic(fmt.Errorf("index out of range: %d", index))
+		}
+		return a.typeData.elemData, Ptr(uintptr(a.value) + uintptr(index)*a.typeData.elemData.SizeOf)
 	case KindStruct:
+		if fieldCount := len(a.typeData.Fields); index >= fieldCount {
+			g := a.typeData.Getters[index-fieldCount]
+			return g.targetData, g.Get(a.value)
+		}
 		f := a.typeData.Fields[index]
-		chaseType = f.targetData
-		chaseValue = Ptr(uintptr(a.value) + f.Offset)
+		return f.targetData, Ptr(uintptr(a.value) + f.Offset)
+	case KindMap:
+		if index < 0 || index >= len(values) {
+			panic(fmt.Errorf("index out of range: %d", index))
+		}
+		return a.typeData.elemData, values[index]
 	case KindSlice:
 		header := (*reflect.SliceHeader)(a.value)
 		if index < 0 || index >= header.Len {
 			panic(fmt.Errorf("index out of range: %d", index))
 		}
-		chaseType = a.typeData.elemData
-		chaseValue = Ptr(header.Data + uintptr(index)*chaseType.SizeOf)
+		return a.typeData.elemData, Ptr(header.Data + uintptr(index)*a.typeData.elemData.SizeOf)
 	default:
 		// We should never have returned an Abstract wrapping anything other
-		// than a struct or a slice. Getting here indicates a problem
-		// with code-generation.
+		// than a struct, an array, a map, or a slice. Getting here indicates
+		// a problem with code-generation.
 		panic(fmt.Errorf("unimplemented: %d", a.typeData.Kind))
 	}
+}
 
-	// Now, we traverse pointers and interfaces until we arrive at
-	// a struct or a slice.
+// resolve traverses pointers and interfaces until it arrives at a
+// struct, an array, a map, or a slice. It returns a nil TypeData if
+// the value is nil or is an empty array, map, or slice.
+func (a *Abstract) resolve(chaseType *TypeData, chaseValue Ptr) (*TypeData, Ptr) {
 	for {
 		if chaseValue == nil {
-			return nil
+			return nil, nil
 		}
 		switch chaseType.Kind {
+		case KindArray:
+			// Special-case: If the array is empty, return nil.
+			if chaseType.Len == 0 {
+				return nil, nil
+			}
+			return chaseType, chaseValue
+		case KindMap:
+			// Special-case: If the map is empty, return nil.
+			if keys, _ := chaseType.MapEntries(chaseValue); len(keys) == 0 {
+				return nil, nil
+			}
+			return chaseType, chaseValue
 		case KindSlice:
 			// Special-case: If the slice is empty, return nil
 			header := (*reflect.SliceHeader)(chaseValue)
 			if header.Len == 0 {
-				return nil
+				return nil, nil
 			}
-			fallthrough
+			return chaseType, chaseValue
 		case KindStruct:
-			// We wrap structs and slices in a new Abstract.
-			return &Abstract{
-				engine:   a.engine,
-				typeData: chaseType,
-				value:    chaseValue,
-			}
+			return chaseType, chaseValue
 		case KindPointer:
 			// We try to dereference pointers and loop around.
 			chaseValue = *(*Ptr)(chaseValue)
@@ -90,7 +173,7 @@ func (a *Abstract) ChildAt(index int) *Abstract {
 			// Interfaces return a more specialized type.
 			elemType := chaseType.IntfType(chaseValue)
 			if elemType == 0 {
-				return nil
+				return nil, nil
 			}
 			chaseType = a.engine.typeData(elemType)
 			chaseValue = ((*[2]Ptr)(chaseValue))[1]
@@ -100,14 +183,67 @@ func (a *Abstract) ChildAt(index int) *Abstract {
 	}
 }
 
-// NumChildren returns the number of fields or slice elements.
+// ChildNamed returns the named field of a struct, following the same
+// rules as ChildAt. The boolean will be false if the value is not a
+// struct, e.g. a slice, or if it has no visitable field of that name.
+// The fields are scanned linearly, so this is intended for occasional
+// lookups, such as resolving a user-provided path, rather than for use
+// in hot loops.
+func (a *Abstract) ChildNamed(name string) (*Abstract, bool) {
+	idx := a.FieldIndex(name)
+	if idx < 0 {
+		return nil, false
+	}
+	return a.ChildAt(idx), true
+}
+
+// FieldIndex returns the index of the named field, or -1 if the value
+// is not a struct with a visitable field of that name.
+func (a *Abstract) FieldIndex(name string) int {
+	if a.typeData.Kind != KindStruct {
+		return -1
+	}
+	for i, f := range a.typeData.Fields {
+		if f.Name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// FieldNameAt returns a label for the nth field or element, as would
+// be returned by ChildAt. Struct fields are labeled by name and the
+// values returned by getters by the method name, followed by "()".
+// The elements of arrays and slices are labeled by their index, and
+// the entries of maps by their key, in brackets.
+func (a *Abstract) FieldNameAt(index int) string {
+	if index < 0 || index >= a.NumChildren() {
+		panic(fmt.Errorf("index out of range: %d", index))
+	}
+	switch a.typeData.Kind {
+	case KindStruct:
+		return a.typeData.childName(index)
+	case KindMap:
+		keys, _ := a.typeData.MapEntries(a.value)
+		return Path{{Key: a.typeData.MapKey(keys[index])}}.String()
+	default:
+		return fmt.Sprintf("[%d]", index)
+	}
+}
+
+// NumChildren returns the number of fields or elements.
 func (a *Abstract) NumChildren() int {
 	if a.value == nil {
 		return 0
 	}
 	switch a.typeData.Kind {
+	case KindArray:
+		return a.typeData.Len
+	case KindMap:
+		keys, _ := a.typeData.MapEntries(a.value)
+		return len(keys)
 	case KindStruct:
-		return len(a.typeData.Fields)
+		return len(a.typeData.Fields) + len(a.typeData.Getters)
 	case KindSlice:
 		return (*reflect.SliceHeader)(a.value).Len
 	default:
@@ -117,12 +253,43 @@ func (a *Abstract) NumChildren() int {
 	}
 }
 
+// Parent returns the Abstract whose ChildAt method returned this one,
+// or nil if the Abstract was constructed by Engine.Abstract.
+func (a *Abstract) Parent() *Abstract {
+	return a.parent
+}
+
+// ParentAt returns the index at which the value was found within its
+// parent, such that Parent().ChildAt(ParentAt()) returns an equivalent
+// Abstract. It returns -1 if there is no parent.
+func (a *Abstract) ParentAt() int {
+	if a.parent == nil {
+		return -1
+	}
+	return a.index
+}
+
 // Ptr returns the embedded pointer. This should not be exposed to
 // user code, but should instead be provided via a type-safe facade.
 func (a *Abstract) Ptr() Ptr {
 	return a.value
 }
 
+// Sibling returns the child of the parent which is offset positions
+// away from this one, following the same rules as ChildAt. It returns
+// nil if there is no parent, or if the position is not within the
+// parent's fields or elements.
+func (a *Abstract) Sibling(offset int) *Abstract {
+	if a.parent == nil {
+		return nil
+	}
+	index := a.index + offset
+	if index < 0 || index >= a.parent.NumChildren() {
+		return nil
+	}
+	return a.parent.ChildAt(index)
+}
+
 // TypeID returns the type token of the embedded value.
 func (a *Abstract) TypeID() TypeID {
 	return a.typeData.TypeID
@@ -0,0 +1,169 @@
+// Copyright 2019 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+package engine
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// A ChangeSet identifies the values within a visitable graph which
+// differ from the values at the same location within another graph.
+// It is constructed by Engine.Changes.
+type ChangeSet struct {
+	changed map[memoKey]struct{}
+}
+
+// Contains returns true if the value of the given type and address was
+// found to have changed.
+func (c *ChangeSet) Contains(id TypeID, x Ptr) bool {
+	_, ok := c.changed[memoKey{id, x}]
+	return ok
+}
+
+// Len returns the number of changed values.
+func (c *ChangeSet) Len() int {
+	return len(c.changed)
+}
+
+// Changes compares the values before and after, which must both be of
+// the given type, and returns the set of values within after that
+// differ from the values at the same location within before. Values
+// are compared by their memory contents, so subtrees which are shared
+// between the two graphs, as happens when Execute replaces a value,
+// are not considered to have changed. Any value which encloses a
+// changed value is also changed. A nil before value will cause every
+// value within after to be considered changed.
+func (e *Engine) Changes(id TypeID, before, after Ptr) *ChangeSet {
+	c := &changeFinder{
+		e:    e,
+		ret:  &ChangeSet{changed: make(map[memoKey]struct{})},
+		seen: make(map[[2]memoKey]bool),
+	}
+	c.find(e.typeData(id), before, after)
+	return c.ret
+}
+
+// changeFinder holds the state used by Engine.Changes.
+type changeFinder struct {
+	e   *Engine
+	ret *ChangeSet
+	// seen memoizes the outcome of comparing a pair of values. It also
+	// prevents cycles from being followed indefinitely, since a pair
+	// which is still being compared will be reported as unchanged.
+	seen map[[2]memoKey]bool
+}
+
+// find reports whether after differs from before, which may be nil,
+// and records the values within after which have changed.
+func (c *changeFinder) find(td *TypeData, before, after Ptr) bool {
+	key := [2]memoKey{{td.TypeID, before}, {td.TypeID, after}}
+	if found, ok := c.seen[key]; ok {
+		return found
+	}
+	c.seen[key] = false
+
+	if before != nil && memEqual(td.SizeOf, before, after) {
+		return false
+	}
+	c.seen[key] = true
+	c.ret.changed[key[1]] = struct{}{}
+
+	// Now, we'll look for changes among the children.
+	switch td.Kind {
+	case KindArray:
+		elemTd := td.elemData
+		for i := 0; i < td.Len; i++ {
+			off := uintptr(i) * elemTd.SizeOf
+			c.find(elemTd, offset(before, off), Ptr(uintptr(after)+off))
+		}
+
+	case KindInterface:
+		elem := td.IntfType(after)
+		ptr := (*[2]Ptr)(after)[1]
+		if elem == 0 || ptr == nil {
+			return true
+		}
+		var beforePtr Ptr
+		if before != nil && td.IntfType(before) == elem {
+			beforePtr = (*[2]Ptr)(before)[1]
+		}
+		c.find(c.e.typeData(elem), beforePtr, ptr)
+
+	case KindMap:
+		// The entries of a map are copies, so there is nothing within the
+		// map to record. Execute will visit every value within a map that
+		// has changed.
+
+	case KindPointer:
+		ptr := *(*Ptr)(after)
+		if ptr == nil {
+			return true
+		}
+		var beforePtr Ptr
+		if before != nil {
+			beforePtr = *(*Ptr)(before)
+		}
+		c.find(td.elemData, beforePtr, ptr)
+
+	case KindSlice:
+		header := (*reflect.SliceHeader)(after)
+		var beforeHeader reflect.SliceHeader
+		if before != nil {
+			beforeHeader = *(*reflect.SliceHeader)(before)
+		}
+		elemTd := td.elemData
+		for i := 0; i < header.Len; i++ {
+			off := uintptr(i) * elemTd.SizeOf
+			var beforeElem Ptr
+			if i < beforeHeader.Len {
+				beforeElem = Ptr(beforeHeader.Data + off)
+			}
+			c.find(elemTd, beforeElem, Ptr(header.Data+off))
+		}
+
+	case KindStruct:
+		for _, f := range td.Fields {
+			c.find(f.targetData, offset(before, f.Offset), Ptr(uintptr(after)+f.Offset))
+		}
+
+	default:
+		panic(fmt.Errorf("unimplemented: %d", td.Kind))
+	}
+	return true
+}
+
+// memEqual compares the n bytes at a and b.
+func memEqual(n uintptr, a, b Ptr) bool {
+	if a == b {
+		return true
+	}
+	for i := uintptr(0); i < n; i++ {
+		if *(*byte)(Ptr(uintptr(a) + i)) != *(*byte)(Ptr(uintptr(b) + i)) {
+			return false
+		}
+	}
+	return true
+}
+
+// offset returns a pointer to the given offset within x, preserving
+// nil values.
+func offset(x Ptr, off uintptr) Ptr {
+	if x == nil {
+		return nil
+	}
+	return Ptr(uintptr(x) + off)
+}
@@ -0,0 +1,262 @@
+// Copyright 2019 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+package engine
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+)
+
+// codecVersion is written at the start of every encoded stream.
+const codecVersion = 1
+
+// maxPrealloc bounds the number of bytes or elements which will be
+// allocated up-front for a length read from the input. Larger values
+// are accommodated by growing the buffer as data arrives, so that a
+// corrupt length cannot force a large allocation.
+const maxPrealloc = 1024
+
+// Reference tags are written before pointer values. Values greater
+// than refNew refer to a previously-encoded pointer.
+const (
+	refNil uint64 = iota
+	refNew
+)
+
+// An Encoder writes values in a compact binary format for use by
+// generated code. Errors are sticky; once a write fails, subsequent
+// writes are no-ops and the error is reported by Err.
+type Encoder struct {
+	buf  [binary.MaxVarintLen64]byte
+	err  error
+	refs map[memoKey]uint64
+	w    *bufio.Writer
+}
+
+// NewEncoder constructs an Encoder which writes to w. Flush must be
+// called once all values have been written.
+func NewEncoder(w io.Writer) *Encoder {
+	enc := &Encoder{refs: make(map[memoKey]uint64), w: bufio.NewWriter(w)}
+	enc.WriteUint(codecVersion)
+	return enc
+}
+
+// Err returns the first error encountered.
+func (enc *Encoder) Err() error {
+	return enc.err
+}
+
+// Flush writes any buffered data and returns the first error
+// encountered.
+func (enc *Encoder) Flush() error {
+	if enc.err == nil {
+		enc.err = enc.w.Flush()
+	}
+	return enc.err
+}
+
+// WriteRef writes the tag for a pointer value. It returns true if the
+// pointer has not been seen before, in which case the value that it
+// refers to must be written next.
+func (enc *Encoder) WriteRef(id TypeID, x Ptr) bool {
+	if x == nil {
+		enc.WriteUint(refNil)
+		return false
+	}
+	key := memoKey{id, x}
+	if idx, seen := enc.refs[key]; seen {
+		enc.WriteUint(refNew + 1 + idx)
+		return false
+	}
+	enc.refs[key] = uint64(len(enc.refs))
+	enc.WriteUint(refNew)
+	return true
+}
+
+// WriteBool writes a bool.
+func (enc *Encoder) WriteBool(b bool) {
+	if b {
+		enc.WriteUint(1)
+	} else {
+		enc.WriteUint(0)
+	}
+}
+
+// WriteFloat writes a floating-point number.
+func (enc *Encoder) WriteFloat(f float64) {
+	enc.WriteUint(math.Float64bits(f))
+}
+
+// WriteInt writes a signed integer.
+func (enc *Encoder) WriteInt(i int64) {
+	enc.write(enc.buf[:binary.PutVarint(enc.buf[:], i)])
+}
+
+// WriteString writes a length-prefixed string.
+func (enc *Encoder) WriteString(s string) {
+	enc.WriteUint(uint64(len(s)))
+	if enc.err == nil {
+		_, enc.err = enc.w.WriteString(s)
+	}
+}
+
+// WriteUint writes an unsigned integer.
+func (enc *Encoder) WriteUint(u uint64) {
+	enc.write(enc.buf[:binary.PutUvarint(enc.buf[:], u)])
+}
+
+func (enc *Encoder) write(data []byte) {
+	if enc.err == nil {
+		_, enc.err = enc.w.Write(data)
+	}
+}
+
+// A Decoder reads values written by an Encoder. Errors are sticky;
+// once a read fails, subsequent reads return zero values and the error
+// is reported by Err.
+type Decoder struct {
+	err  error
+	r    *bufio.Reader
+	refs []Ptr
+}
+
+// NewDecoder constructs a Decoder which reads from r. The Decoder may
+// buffer data from r beyond the end of the encoded values.
+func NewDecoder(r io.Reader) *Decoder {
+	dec := &Decoder{r: bufio.NewReader(r)}
+	if v := dec.ReadUint(); dec.err == nil && v != codecVersion {
+		dec.Fail(errors.New("unsupported encoding version"))
+	}
+	return dec
+}
+
+// Err returns the first error encountered.
+func (dec *Decoder) Err() error {
+	return dec.err
+}
+
+// Fail records an error, unless one has already been recorded.
+func (dec *Decoder) Fail(err error) {
+	if dec.err == nil {
+		dec.err = err
+	}
+}
+
+// ReadRef reads the tag for a pointer value. If the tag refers to a
+// previously-decoded pointer, it is returned. Otherwise, isNew will be
+// true if a new value must be allocated, registered with AddRef, and
+// then read.
+func (dec *Decoder) ReadRef() (_ Ptr, isNew bool) {
+	switch tag := dec.ReadUint(); tag {
+	case refNil:
+		return nil, false
+	case refNew:
+		return nil, dec.err == nil
+	default:
+		idx := tag - refNew - 1
+		if idx >= uint64(len(dec.refs)) {
+			dec.Fail(errors.New("invalid reference"))
+			return nil, false
+		}
+		return dec.refs[idx], false
+	}
+}
+
+// AddRef registers a newly-allocated pointer value, so that it may be
+// referred to by subsequent values.
+func (dec *Decoder) AddRef(x Ptr) {
+	dec.refs = append(dec.refs, x)
+}
+
+// ReadBool reads a bool.
+func (dec *Decoder) ReadBool() bool {
+	return dec.ReadUint() != 0
+}
+
+// ReadFloat reads a floating-point number.
+func (dec *Decoder) ReadFloat() float64 {
+	return math.Float64frombits(dec.ReadUint())
+}
+
+// ReadInt reads a signed integer.
+func (dec *Decoder) ReadInt() int64 {
+	if dec.err != nil {
+		return 0
+	}
+	ret, err := binary.ReadVarint(dec.r)
+	dec.Fail(err)
+	return ret
+}
+
+// ReadLen reads a length or count, which was written as an unsigned
+// integer. Implausibly-large values, which are likely the result of
+// corrupt input, are rejected.
+func (dec *Decoder) ReadLen() int {
+	n := dec.ReadUint()
+	if n > math.MaxInt32 {
+		dec.Fail(errors.New("length out of range"))
+		return 0
+	}
+	return int(n)
+}
+
+// Prealloc returns the capacity to allocate for n bytes or elements,
+// given a length returned by ReadLen. The result is bounded, so the
+// caller must be prepared to grow its buffer.
+func (dec *Decoder) Prealloc(n int) int {
+	if n > maxPrealloc {
+		return maxPrealloc
+	}
+	return n
+}
+
+// ReadString reads a length-prefixed string.
+func (dec *Decoder) ReadString() string {
+	n := dec.ReadLen()
+	if dec.err != nil {
+		return ""
+	}
+	buf := make([]byte, 0, dec.Prealloc(n))
+	for len(buf) < n {
+		if len(buf) == cap(buf) {
+			buf = append(buf, 0)[:len(buf)]
+		}
+		end := cap(buf)
+		if end > n {
+			end = n
+		}
+		read, err := io.ReadFull(dec.r, buf[len(buf):end])
+		buf = buf[:len(buf)+read]
+		if err != nil {
+			dec.Fail(err)
+			return ""
+		}
+	}
+	return string(buf)
+}
+
+// ReadUint reads an unsigned integer.
+func (dec *Decoder) ReadUint() uint64 {
+	if dec.err != nil {
+		return 0
+	}
+	ret, err := binary.ReadUvarint(dec.r)
+	dec.Fail(err)
+	return ret
+}
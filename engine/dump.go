@@ -0,0 +1,82 @@
+// Copyright 2019 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+package engine
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Dump returns an indented representation of the tree rooted at the
+// value, for use when debugging. Each line describes one value, giving
+// its location within its parent and its type. A value which encloses
+// itself is marked as a back-reference, rather than being followed.
+func (e *Engine) Dump(id TypeID, x Ptr) string {
+	d := &dumper{}
+	d.dump(e.Abstract(id, x), "", 0)
+	return d.sb.String()
+}
+
+// dumper holds the state used by Engine.Dump.
+type dumper struct {
+	sb strings.Builder
+	// stack holds the values which enclose the value being dumped. As
+	// with Execute, a linear search is sufficient, since we expect the
+	// stack to be fairly shallow.
+	stack []memoKey
+}
+
+// dump writes a line for the value, followed by its children.
+func (d *dumper) dump(a *Abstract, label string, depth int) {
+	d.sb.WriteString(strings.Repeat("  ", depth))
+	if label != "" {
+		d.sb.WriteString(label)
+		d.sb.WriteString(": ")
+	}
+	if a == nil {
+		d.sb.WriteString("<nil>\n")
+		return
+	}
+	d.sb.WriteString(a.engine.Stringify(a.TypeID()))
+
+	key := memoKey{a.TypeID(), a.value}
+	for _, k := range d.stack {
+		if k == key {
+			d.sb.WriteString(" <back-reference>\n")
+			return
+		}
+	}
+	d.sb.WriteRune('\n')
+
+	var keys []Ptr
+	if a.typeData.Kind == KindMap {
+		keys, _ = a.typeData.MapEntries(a.value)
+	}
+	d.stack = append(d.stack, key)
+	for i, n := 0, a.NumChildren(); i < n; i++ {
+		var label string
+		switch a.typeData.Kind {
+		case KindMap:
+			label = Path{{Key: a.typeData.MapKey(keys[i])}}.String()
+		case KindStruct:
+			label = a.typeData.childName(i)
+		default:
+			label = fmt.Sprintf("[%d]", i)
+		}
+		d.dump(a.ChildAt(i), label, depth+1)
+	}
+	d.stack = d.stack[:len(d.stack)-1]
+}
@@ -19,31 +19,53 @@
 package engine
 
 import (
+	"context"
 	"fmt"
 	"reflect"
 	"strings"
+	"sync"
+	"sync/atomic"
 )
 
-// Allows us to pre-allocate working space on the call stack.
+// The initial capacity of a stack, which is grown on demand.
 const defaultStackDepth = 8
 
 // See discussion on frame.Slots.
 const fixedSlotCount = 16
 
+// The number of times that Decision.Restart may restart a single call
+// to Execute before an error is returned. This prevents replacements
+// which never converge from looping forever.
+const maxRestarts = 1000
+
 // A frame represents the visitation of a single struct,
-// interface, or slice.
+// interface, map, or slice.
 type frame struct {
 	// Count holds the number of slots to be visited.
 	Count int
+	// Depth holds the number of struct values which enclose the
+	// slots in the frame.
+	Depth int
 	// Idx is the current slot being visited.
 	Idx       int
 	Intercept FacadeFn
+	// InterceptBase is the index of the frame which holds the children
+	// of the value which registered the interceptor. It will be zero if
+	// the interceptor was inherited by a forked child.
+	InterceptBase int
+	// InterceptNamed is set if the interceptor should be told the path
+	// of the value that it intercepts.
+	InterceptNamed bool
+	// Keys holds the keys of a map's entries, which correspond to the
+	// slots of the frame.
+	Keys []Ptr
 	// We keep a fixed-size array of slots per frame so that most
 	// visitable objects won't need a heap allocation to store
 	// the intermediate state.
 	Slots [fixedSlotCount]Action
 	// Large targets (such as slices) will use additional, heap-allocated
-	// memory to store the intermediate state.
+	// memory to store the intermediate state. This is kept when the frame
+	// is released, for reuse by the next large target.
 	Overflow []Action
 }
 
@@ -60,6 +82,28 @@ func (f *frame) Slot(idx int) *Action {
 	return &f.Overflow[idx-fixedSlotCount]
 }
 
+// release clears the slots of a frame which has been popped, once
+// their values have been consumed. Otherwise, the slots would retain
+// any intermediate values until the frame is reused, preventing them
+// from being reclaimed during a long unwind.
+func (f *frame) release() {
+	n := f.Count
+	if n > fixedSlotCount {
+		n = fixedSlotCount
+	}
+	slots := f.Slots[:n]
+	for i := range slots {
+		slots[i] = Action{}
+	}
+	for i := range f.Overflow {
+		f.Overflow[i] = Action{}
+	}
+	f.Count = 0
+	f.Intercept = nil
+	f.Keys = nil
+	f.Overflow = f.Overflow[:0]
+}
+
 // SetSlot is a helper function to configure a slot.
 func (f *frame) SetSlot(e *Engine, idx int, action Action) *Action {
 	ret := f.Slot(idx)
@@ -78,6 +122,9 @@ func (f *frame) Zero() *Action {
 // An Engine holds the necessary information to pass a visitor over
 // a field.
 type Engine struct {
+	// stacks holds working space which may be reused by subsequent
+	// calls to Execute, to avoid allocating a stack for each call.
+	stacks  sync.Pool
 	typeMap TypeMap
 }
 
@@ -103,10 +150,32 @@ func New(m TypeMap) *Engine {
 			}
 			e.typeMap[idx].Fields[fIdx].targetData = found
 		}
+
+		for gIdx, getter := range td.Getters {
+			found := e.typeData(getter.Target)
+			if found.TypeID == 0 {
+				panic(fmt.Errorf("bad codegen: missing %d.%s().Target %d",
+					td.TypeID, getter.Name, getter.Target))
+			}
+			e.typeMap[idx].Getters[gIdx].targetData = found
+		}
 	}
 	return e
 }
 
+// Intern registers a predicate which identifies interned values of the
+// given struct type. When rebuilding, an interned value will not be
+// copied unless a callback has replaced a value that it encloses. A nil
+// predicate removes any existing registration. This method must not be
+// called concurrently with Execute.
+func (e *Engine) Intern(id TypeID, fn InternFn) {
+	td := e.typeData(id)
+	if td.Kind != KindStruct {
+		panic(fmt.Errorf("%s is not a struct type", e.Stringify(id)))
+	}
+	td.interned = fn
+}
+
 // Abstract constructs an abstract accessor around a struct's field.
 func (e *Engine) Abstract(typeID TypeID, x Ptr) *Abstract {
 	if x == nil {
@@ -125,20 +194,204 @@ func (e *Engine) Abstract(typeID TypeID, x Ptr) *Abstract {
 // fairly low cost. Any replacement of the top-level value must be
 // assignable to the given TypeID.
 func (e *Engine) Execute(
-	fn FacadeFn, t TypeID, x Ptr, assignableTo TypeID,
+	fn FacadeFn, t TypeID, x Ptr, assignableTo TypeID, opts ...Option,
 ) (retType TypeID, ret Ptr, changed bool, err error) {
-	ctx := Context{}
-	stack := newStack()
+	// Only allocate space for options if we actually have any.
+	var cfg *options
+	if len(opts) > 0 {
+		cfg = &options{}
+		for _, opt := range opts {
+			opt(cfg)
+		}
+	}
+
+	root := Context{}.ActionVisitReplace(e.typeData(t), x, e.typeData(assignableTo))
+	z, _, err := e.execute(fn, cfg, root, fork{})
+	if err != nil {
+		return 0, nil, false, err
+	}
+	// Values which have been replaced in place don't make the
+	// top-level value dirty, but they do change it.
+	return z.typeData.TypeID, z.value, z.dirty || z.mutated, nil
+}
+
+// replaceChildren returns a copy of the struct in the slot, whose
+// slice field holds the values visited by the returning frame, which
+// will be nil if no values were visited. Any actions which invoked a
+// callback do not contribute an element.
+func (e *Engine) replaceChildren(alloc AllocFn, a *Action, returning *frame) (Ptr, error) {
+	field := a.typeData.Fields[0]
+	sliceTd := field.targetData
+	elemTd := sliceTd.elemData
+
+	count := 0
+	for i := 0; returning != nil && i < returning.Count; i++ {
+		if returning.Slot(i).call == nil {
+			count++
+		}
+	}
+	slice := sliceTd.NewSlice(count)
+	header := (*reflect.SliceHeader)(slice)
+	off := uintptr(0)
+	for i := 0; returning != nil && i < returning.Count; i++ {
+		child := returning.Slot(i)
+		if child.call != nil {
+			continue
+		}
+		elem, ok := elemTd.assign(child.typeData, child.value)
+		if !ok {
+			return nil, fmt.Errorf("type %s is not assignable to %s.%s",
+				e.Stringify(child.typeData.TypeID), a.typeData.Name, field.Name)
+		}
+		elemTd.Copy(Ptr(header.Data+off), elem)
+		off += elemTd.SizeOf
+	}
+
+	next := newStruct(alloc, a.typeData)
+	a.typeData.Copy(next, a.value)
+	sliceTd.Copy(Ptr(uintptr(next)+field.Offset), slice)
+	return next, nil
+}
+
+// newStruct returns memory for a struct of the given type, which is
+// obtained from the allocator if one has been provided.
+func newStruct(alloc AllocFn, td *TypeData) Ptr {
+	if alloc != nil {
+		if ret := alloc(td.TypeID); ret != nil {
+			return ret
+		}
+	}
+	return td.NewStruct()
+}
+
+// parentOf returns the nearest struct which encloses the active slot
+// of the stack, looking through the ancestors of a forked child if
+// necessary. It returns a zero memoKey if there is no such struct.
+func (e *Engine) parentOf(stack *stack, ancestors []memoKey) memoKey {
+	for l := stack.Depth() - 2; l >= 0; l-- {
+		if onStack := stack.Peek(l).Active(); onStack.typeData.Kind == KindStruct {
+			return memoKey{onStack.typeData.TypeID, onStack.value}
+		}
+	}
+	for l := len(ancestors) - 1; l >= 0; l-- {
+		if e.typeData(ancestors[l].typeID).Kind == KindStruct {
+			return ancestors[l]
+		}
+	}
+	return memoKey{}
+}
+
+// A fork describes a child value which is visited by a separate call
+// to execute, on behalf of a Parallel decision.
+type fork struct {
+	// ancestors holds the values which enclose the child, so that
+	// cycles can still be detected.
+	ancestors []memoKey
+	// depth holds the number of struct values which enclose the child.
+	depth int
+	// frames holds the number of frames beneath the child on the stack
+	// of the visitation which forked it, so that WithMaxDepth applies
+	// to the combined stack.
+	frames int
+	// immutable is set when the child is not stored in mutable memory.
+	immutable bool
+	intercept FacadeFn
+	// interceptNamed and interceptPath describe an interceptor which is
+	// inherited by the child and the location of the child, relative to
+	// the value which registered the interceptor.
+	interceptNamed bool
+	interceptPath  Path
+	// path holds the location of the child.
+	path Path
+	// sliceElement is set when the child is an element of a slice.
+	sliceElement bool
+	// spread is set when the child is a field of a struct. If the child
+	// is a slice or array, its elements will also be visited
+	// concurrently.
+	spread bool
+	// unfiltered is set when the child is a copy that was taken from a
+	// map, and so cannot be found in a ChangeSet.
+	unfiltered bool
+}
+
+// execute visits the value in the given action and returns the final
+// state of the action, along with whether or not the visitation was
+// halted. The action is either the top-level value passed to Execute
+// or a child which has been forked.
+func (e *Engine) execute(
+	fn FacadeFn, cfg *options, root Action, f fork,
+) (z Action, halted bool, err error) {
+	ctx := Context{prefix: f.path}
+	stack := e.getStack()
+	defer e.putStack(stack)
+
+	var alloc AllocFn
+	var cancel context.Context
+	var changes *ChangeSet
+	var count *int64
+	maxDepth := 0
+	var memo *Memo
+	var onChange ChangeFn
+	var onCycle CycleFn
+	var onNil NilFn
+	var onSlice SliceFn
+	var only map[TypeID]bool
+	var skip map[TypeID]bool
+	// Visited records every value that has been visited when each value
+	// should be visited at most once. It is only allocated if requested.
+	var visited map[memoKey]struct{}
+	rebuild := false
+	if cfg != nil {
+		alloc = cfg.alloc
+		cancel = cfg.cancel
+		changes = cfg.changes
+		count = cfg.count
+		maxDepth = cfg.maxDepth
+		memo = cfg.memo
+		onChange = cfg.onChange
+		onCycle = cfg.onCycle
+		onNil = cfg.onNil
+		onSlice = cfg.onSlice
+		only = cfg.only
+		rebuild = cfg.rebuild
+		skip = cfg.skip
+		ctx.state = cfg.state
+		if cfg.once {
+			visited = make(map[memoKey]struct{})
+		}
+	}
+	ctx.stack = stack
+	stack.immutable = f.immutable
+	stack.sliceElement = f.sliceElement
 
 	// Bootstrap the stack.
-	curFrame := stack.Enter(nil, 1)
-	curSlot := curFrame.SetSlot(e, 0, ctx.ActionVisitReplace(e.typeData(t), x, e.typeData(assignableTo)))
+	curFrame := stack.Enter(f.intercept, 1)
+	curFrame.Depth = f.depth
+	curFrame.InterceptNamed = f.interceptNamed
+	curSlot := curFrame.SetSlot(e, 0, root)
 
 	// Entering is a temporary pointer to the frame that we might be
 	// entering into next, if the current value is a struct with fields, a
 	// slice, etc.
 	var entering *frame
 	halting := false
+	// Restarting is set when a callback has requested that the
+	// visitation be restarted from the top-level value. It implies
+	// halting.
+	restarting := false
+	restarts := 0
+	// Records whether any visitation, before a restart, made changes.
+	restartedDirty := false
+	// Records the depth of the stack at which a map, whose entries are
+	// copies that cannot be found in the ChangeSet, was entered. Every
+	// value within the map will be visited.
+	unfiltered := 0
+	if f.unfiltered {
+		unfiltered = 1
+	}
+	// Parallel is set when the children of the current value should be
+	// visited concurrently.
+	parallel := false
 	// This variable holds a pointer to a frame that we've just completed.
 	// When we have a returning frame that's dirty, we'll want to unpack
 	// its values into the current slot.
@@ -147,7 +400,7 @@ func (e *Engine) Execute(
 enter:
 	if curSlot.call != nil {
 		if err := curSlot.call(); err != nil {
-			return 0, nil, false, err
+			return Action{}, false, err
 		}
 		goto unwind
 	}
@@ -163,10 +416,41 @@ enter:
 	for l := 0; l < stack.Depth()-1; l++ {
 		onStack := stack.Peek(l).Active()
 		if onStack.value == curSlot.value && onStack.typeData.TypeID == curSlot.typeData.TypeID {
+			if onCycle != nil {
+				onCycle(f.path.join(stack.Path()), curSlot.typeData.TypeID, curSlot.value)
+			}
+			goto nextSlot
+		}
+	}
+	// A forked child must also avoid the values which enclose it.
+	for _, k := range f.ancestors {
+		if k.value == curSlot.value && k.typeID == curSlot.typeData.TypeID {
+			if onCycle != nil {
+				onCycle(f.path.join(stack.Path()), curSlot.typeData.TypeID, curSlot.value)
+			}
 			goto nextSlot
 		}
 	}
 
+	// Skip over any values which have been visited elsewhere.
+	if visited != nil {
+		key := memoKey{curSlot.typeData.TypeID, curSlot.value}
+		if _, found := visited[key]; found {
+			goto nextSlot
+		}
+		visited[key] = struct{}{}
+	}
+
+	// Skip over any values which haven't changed.
+	if changes != nil && unfiltered == 0 && !changes.Contains(curSlot.typeData.TypeID, curSlot.value) {
+		goto nextSlot
+	}
+
+	// Skip over any values whose types have been excluded.
+	if skip[curSlot.typeData.TypeID] {
+		goto nextSlot
+	}
+
 	// In this switch statement, we're going to set up the next frame. If
 	// the current value doesn't need a new frame to be pushed, we'll jump
 	// into the unwind block.
@@ -178,78 +462,203 @@ enter:
 		if ptr == nil {
 			goto unwind
 		}
-		entering = stack.Enter(curFrame.Intercept, 1)
+		entering = stack.Inherit(curFrame, 1)
 		entering.SetSlot(e, 0, ctx.ActionVisitReplace(curSlot.typeData.elemData, ptr, curSlot.typeData.elemData))
 
 	case KindStruct:
+		// If we've already visited this value, we'll reuse the outcome
+		// instead of visiting it again.
+		if memo != nil {
+			curSlot.original = memoKey{curSlot.typeData.TypeID, curSlot.value}
+			if found, ok := memo.lookup(e, curSlot.original); ok {
+				if found != curSlot.original {
+					d := Decision{replacement: found.value, replacementType: found.typeID}
+					if err := curSlot.apply(e, stack, d); err != nil {
+						return Action{}, false, err
+					}
+				}
+				goto unwind
+			}
+		}
+
+		ctx.depth = curFrame.Depth
+		ctx.parent = e.parentOf(stack, f.ancestors)
+
 		// Allow parent frames to intercept child values.
 		if curFrame.Intercept != nil {
-			d := curSlot.typeData.Facade(ctx, curFrame.Intercept, curSlot.value)
-			if err := curSlot.apply(e, d); err != nil {
-				return 0, nil, false, err
+			beforeType, before := curSlot.typeData.TypeID, curSlot.value
+			ictx := ctx
+			if curFrame.InterceptNamed {
+				ictx.intercepted = stack.interceptedPath(f.interceptPath)
+			}
+			d := curSlot.typeData.Facade(ictx, curFrame.Intercept, curSlot.value)
+			if err := curSlot.apply(e, stack, d); err != nil {
+				return Action{}, false, err
+			}
+			if onChange != nil && d.replacement != nil {
+				onChange(f.path.join(stack.Path()), beforeType, before, curSlot.typeData.TypeID, curSlot.value)
 			}
 			if d.halt {
 				halting = true
 			}
+			if d.restarts() {
+				halting, restarting = true, true
+			}
 			// Allow interceptors to replace themselves.
 			if d.intercept != nil {
 				curFrame.Intercept = d.intercept
+				curFrame.InterceptNamed = d.interceptNamed
 			}
 		}
 
 		// Structs are where we call out to user logic via a generated,
 		// type-safe facade. The user code can trigger various flow-control
 		// to happen.
-		d := curSlot.typeData.Facade(ctx, fn, curSlot.value)
+		beforeType, before := curSlot.typeData.TypeID, curSlot.value
+		var d Decision
+		if only == nil || only[curSlot.typeData.TypeID] {
+			d = curSlot.typeData.Facade(ctx, fn, curSlot.value)
+			if count != nil {
+				atomic.AddInt64(count, 1)
+			}
+		}
 		// Incorporate replacements, bail on error, etc.
-		if err := curSlot.apply(e, d); err != nil {
-			return 0, nil, false, err
+		if err := curSlot.apply(e, stack, d); err != nil {
+			return Action{}, false, err
+		}
+		if onChange != nil && d.replacement != nil {
+			onChange(f.path.join(stack.Path()), beforeType, before, curSlot.typeData.TypeID, curSlot.value)
 		}
 		// If the user wants to stop, we'll set the flag and just let the
 		// unwind loop run to completion.
 		if d.halt {
 			halting = true
 		}
+		if d.restarts() {
+			halting, restarting = true, true
+		}
 		// Slices and structs have very similar approaches, we create a new
 		// frame, add slots for each field or slice element, and then jump
 		// back to the top.
 		fieldCount := len(curSlot.typeData.Fields)
+		childCount := fieldCount + len(curSlot.typeData.Getters)
 		switch {
 		case halting, d.skip:
 			goto unwind
 
+		case d.children:
+			if !curSlot.typeData.sliceOnly() {
+				return Action{}, false, fmt.Errorf(
+					"cannot replace the children of %s, since it does not have exactly one slice field",
+					curSlot.typeData.Name)
+			}
+			// The slice field will be rebuilt from the actions, even if
+			// there are none.
+			curSlot.children = true
+			curSlot.dirty, curSlot.modified = true, true
+			if len(d.actions) == 0 {
+				goto unwind
+			}
+			entering = stack.Enter(d.intercept, len(d.actions))
+			entering.InterceptNamed = d.interceptNamed
+			for i, a := range d.actions {
+				entering.SetSlot(e, i, a)
+			}
+
 		case d.actions != nil:
 			if len(d.actions) == 0 {
 				goto unwind
 			}
 			entering = stack.Enter(d.intercept, len(d.actions))
+			entering.InterceptNamed = d.interceptNamed
 			for i, a := range d.actions {
 				entering.SetSlot(e, i, a)
 			}
 
 		default:
-			if fieldCount == 0 {
+			if childCount == 0 {
+				// Force the struct itself to be copied, unless it's interned.
+				if rebuild && !curSlot.interned() {
+					curSlot.dirty = true
+				}
 				goto unwind
 			}
-			entering = stack.Enter(d.intercept, fieldCount)
+			entering = stack.Enter(d.intercept, childCount)
+			entering.InterceptNamed = d.interceptNamed
 			for i, f := range curSlot.typeData.Fields {
 				fPtr := Ptr(uintptr(curSlot.value) + f.Offset)
 				entering.SetSlot(e, i, ctx.ActionVisitReplace(f.targetData, fPtr, f.targetData))
 			}
+			// The values returned by getters are copies, so they can't be
+			// replaced.
+			for i, g := range curSlot.typeData.Getters {
+				entering.SetSlot(e, fieldCount+i, ctx.ActionVisit(g.targetData, g.Get(curSlot.value)))
+			}
+			// The memo and visited set are not safe for concurrent use, so
+			// we'll fall back to visiting the children serially.
+			parallel = d.parallel && memo == nil && visited == nil
 		}
 
+	case KindArray:
+		// Arrays are handled in the same fashion as slices, except that
+		// the length is fixed and the elements are stored inline.
+		if curSlot.typeData.Len == 0 {
+			goto unwind
+		}
+		entering = stack.Inherit(curFrame, curSlot.typeData.Len)
+		eltTd := curSlot.typeData.elemData
+		for i, off := 0, uintptr(0); i < curSlot.typeData.Len; i, off = i+1, off+eltTd.SizeOf {
+			entering.SetSlot(e, i, ctx.ActionVisitReplace(eltTd, Ptr(uintptr(curSlot.value)+off), eltTd))
+		}
+		parallel = f.spread && stack.Depth() == 2 && memo == nil
+
 	case KindSlice:
 		// Slices have the same general flow as a struct; they're just
 		// a sequence of visitable values.
 		header := (*reflect.SliceHeader)(curSlot.value)
+		if onSlice != nil {
+			onSlice(f.path.join(stack.Path()), curSlot.typeData.TypeID, header.Len)
+		}
 		if header.Len == 0 {
+			// An empty, non-nil slice has no elements to copy out, but
+			// it must not share a backing array with its rebuilt copy.
+			if rebuild && header.Data != 0 {
+				curSlot.value = curSlot.typeData.NewSlice(0)
+				curSlot.dirty, curSlot.replaced = true, true
+			}
 			goto unwind
 		}
-		entering = stack.Enter(curFrame.Intercept, header.Len)
+		entering = stack.Inherit(curFrame, header.Len)
+		// Each element may be replaced by any value which is assignable
+		// to the element type. A concrete element type will reject a
+		// replacement of any other type in Action.apply.
 		eltTd := curSlot.typeData.elemData
 		for i, off := 0, uintptr(0); i < header.Len; i, off = i+1, off+eltTd.SizeOf {
 			entering.SetSlot(e, i, ctx.ActionVisitReplace(eltTd, Ptr(header.Data+off), eltTd))
 		}
+		parallel = f.spread && stack.Depth() == 2 && memo == nil
+
+	case KindMap:
+		// The values in a map aren't addressable, so we visit copies of
+		// them. If any are replaced, a new map will be constructed.
+		keys, values := curSlot.typeData.MapEntries(curSlot.value)
+		if len(keys) == 0 {
+			// Likewise, an empty, non-nil map is replaced by a new one.
+			if rebuild && *(*Ptr)(curSlot.value) != nil {
+				curSlot.value = curSlot.typeData.NewMap(0)
+				curSlot.dirty, curSlot.replaced = true, true
+			}
+			goto unwind
+		}
+		if changes != nil && unfiltered == 0 {
+			unfiltered = stack.Depth()
+		}
+		entering = stack.Inherit(curFrame, len(keys))
+		entering.Keys = keys
+		eltTd := curSlot.typeData.elemData
+		for i, value := range values {
+			entering.SetSlot(e, i, ctx.ActionVisitReplace(eltTd, value, eltTd))
+		}
 
 	case KindInterface:
 		// An interface is a type-tag and a pointer.
@@ -259,15 +668,88 @@ enter:
 		elem := curSlot.typeData.IntfType(curSlot.value)
 		// Need to check elem==0 in the case of a "typed nil" value.
 		if elem == 0 || ptr == nil {
+			if onNil == nil {
+				goto unwind
+			}
+			ctx.depth = curFrame.Depth
+			ctx.parent = e.parentOf(stack, f.ancestors)
+			intf, before := curSlot.typeData, curSlot.value
+			d := onNil(ctx, fn)
+			if count != nil {
+				atomic.AddInt64(count, 1)
+			}
+			if err := curSlot.apply(e, stack, d); err != nil {
+				return Action{}, false, err
+			}
+			// There is no value to pass to a post-visit function.
+			curSlot.post = nil
+			if d.replacement != nil && !d.inPlace {
+				// There's no child frame to re-wrap the replacement, so
+				// we'll store it in the interface directly.
+				curSlot.value = intf.IntfWrap(curSlot.typeData.TypeID, curSlot.value)
+				curSlot.typeData = intf
+				if onChange != nil {
+					onChange(f.path.join(stack.Path()), intf.TypeID, before, intf.TypeID, curSlot.value)
+				}
+			}
+			if d.halt {
+				halting = true
+			}
+			if d.restarts() {
+				halting, restarting = true, true
+			}
 			goto unwind
 		}
-		entering = stack.Enter(curFrame.Intercept, 1)
+		entering = stack.Inherit(curFrame, 1)
 		entering.SetSlot(e, 0, ctx.ActionVisitReplace(e.typeData(elem), ptr, curSlot.typeData))
 
 	default:
 		panic(fmt.Errorf("unexpected kind: %d", curSlot.typeData.Kind))
 	}
 
+	entering.Depth = curFrame.Depth
+	if curSlot.typeData.Kind == KindStruct {
+		entering.Depth++
+	}
+	if maxDepth > 0 && f.frames+stack.Depth() > maxDepth {
+		return Action{}, false, fmt.Errorf("exceeded the maximum depth of %d within %s",
+			maxDepth, e.Stringify(curSlot.typeData.TypeID))
+	}
+
+	// Checking for cancellation once per frame, rather than once per
+	// slot, keeps the overhead low.
+	if cancel != nil {
+		if err := cancel.Err(); err != nil {
+			return Action{}, false, err
+		}
+	}
+
+	if parallel {
+		parallel = false
+		childHalted, err := e.visitParallel(fn, cfg, stack, f, unfiltered != 0)
+		if err != nil {
+			return Action{}, false, err
+		}
+		if childHalted {
+			halting = true
+		}
+		// The children have already been unwound, so we'll fold them
+		// into the current slot in the same way as the unwind block.
+		returning = stack.Pop()
+		for i := 0; i < returning.Count; i++ {
+			child := returning.Slot(i)
+			if child.dirty {
+				curSlot.dirty = true
+				curSlot.modified = curSlot.modified || child.modified
+			}
+			curSlot.mutated = curSlot.mutated || child.mutated
+		}
+		if rebuild {
+			curSlot.dirty = curSlot.modified || !curSlot.interned()
+		}
+		goto unwind
+	}
+
 	curFrame = entering
 	curSlot = curFrame.Zero()
 
@@ -275,71 +757,162 @@ enter:
 	goto enter
 
 unwind:
+	if unfiltered == stack.Depth() && curSlot.typeData.Kind == KindMap {
+		unfiltered = 0
+	}
+
+	// If the slot reports that it's dirty, we'll fold the returning
+	// frame into a replacement value for the current slot, so that any
+	// post-visit function will see the updated children. If we were
+	// given a replacement value, there's no need to copy out any data.
+	if curSlot.dirty && !curSlot.replaced {
+		// This switch statement is the inverse of the above.
+		switch curSlot.typeData.Kind {
+		case KindArray:
+			// Arrays are values, so we follow the same approach as for
+			// structs and allocate a replacement array.
+			next := curSlot.typeData.NewArray()
+			elemTd := curSlot.typeData.elemData
+
+			// Copy the elements across.
+			for i := 0; i < returning.Count; i++ {
+				toElem := Ptr(uintptr(next) + uintptr(i)*elemTd.SizeOf)
+				elemTd.Copy(toElem, returning.Slot(i).value)
+			}
+			curSlot.value = next
+
+		case KindStruct:
+			if curSlot.children {
+				next, err := e.replaceChildren(alloc, curSlot, returning)
+				if err != nil {
+					return Action{}, false, err
+				}
+				curSlot.value = next
+				break
+			}
+
+			// We have no way to write back changes to the values
+			// returned by getters.
+			if len(curSlot.typeData.Getters) > 0 {
+				for i := len(curSlot.typeData.Fields); i < returning.Count; i++ {
+					if returning.Slot(i).modified {
+						return Action{}, false, fmt.Errorf("cannot replace values within %s.%s",
+							curSlot.typeData.Name, curSlot.typeData.childName(i))
+					}
+				}
+			}
+
+			// Allocate a replacement instance of the struct.
+			next := newStruct(alloc, curSlot.typeData)
+			// Perform a shallow copy to catch non-visitable fields.
+			curSlot.typeData.Copy(next, curSlot.value)
+
+			// Copy the visitable fields into the new struct.
+			for i, f := range curSlot.typeData.Fields {
+				fPtr := Ptr(uintptr(next) + f.Offset)
+				f.targetData.Copy(fPtr, returning.Slot(i).value)
+			}
+			curSlot.value = next
+
+		case KindPointer:
+			// Copy out the pointer to a local var so we don't stomp on it.
+			next := returning.Zero().value
+			curSlot.value = Ptr(&next)
+
+		case KindSlice:
+			// Elements may have been replaced with any number of values.
+			count := returning.Count
+			for i := 0; i < returning.Count; i++ {
+				if x := returning.Slot(i).expansion; x != nil {
+					count += len(x) - 1
+				}
+			}
+
+			// Create a new slice instance and populate the elements.
+			next := curSlot.typeData.NewSlice(count)
+			toHeader := (*reflect.SliceHeader)(next)
+			elemTd := curSlot.typeData.elemData
+
+			// Copy the elements across.
+			off := uintptr(0)
+			for i := 0; i < returning.Count; i++ {
+				child := returning.Slot(i)
+				if child.expansion == nil {
+					elemTd.Copy(Ptr(toHeader.Data+off), child.value)
+					off += elemTd.SizeOf
+					continue
+				}
+				for _, x := range child.expansion {
+					elemTd.Copy(Ptr(toHeader.Data+off), x)
+					off += elemTd.SizeOf
+				}
+			}
+			curSlot.value = next
+
+		case KindMap:
+			// Construct a new map, since the original may be shared.
+			next := curSlot.typeData.NewMap(returning.Count)
+			for i := 0; i < returning.Count; i++ {
+				curSlot.typeData.SetMapIndex(next, returning.Keys[i], returning.Slot(i).value)
+			}
+			curSlot.value = next
+
+		case KindInterface:
+			// Swap out the iface pointer just like the pointer case above.
+			next := returning.Zero()
+			curSlot.value = curSlot.typeData.IntfWrap(next.typeData.TypeID, next.value)
+
+		default:
+			panic(fmt.Errorf("unimplemented: %d", curSlot.typeData.Kind))
+		}
+	}
+
 	// Execute any user-provided callback. This logic is pretty much
 	// the same as above, although we don't respect all decision options.
-	if curSlot.post != nil {
+	// Post-visit functions are skipped when restarting, since the values
+	// will be visited again.
+	if curSlot.post != nil && !restarting {
+		ctx.depth = curFrame.Depth
+		ctx.parent = e.parentOf(stack, f.ancestors)
+		beforeType, before := curSlot.typeData.TypeID, curSlot.value
 		d := curSlot.typeData.Facade(ctx, curSlot.post, curSlot.value)
-		if err := curSlot.apply(e, d); err != nil {
-			return 0, nil, false, err
+		if err := curSlot.apply(e, stack, d); err != nil {
+			return Action{}, false, err
+		}
+		if onChange != nil && d.replacement != nil {
+			onChange(f.path.join(stack.Path()), beforeType, before, curSlot.typeData.TypeID, curSlot.value)
 		}
 		if d.halt {
 			halting = true
 		}
+		if d.restarts() {
+			halting, restarting = true, true
+		}
+	}
+
+	if curSlot.mutated && stack.Depth() > 1 {
+		stack.Top(1).Active().mutated = true
 	}
 
 	// If the slot reports that it's dirty, we want to propagate
 	// the changes upwards in the stack.
-	if curSlot.dirty {
-		if stack.Depth() > 1 {
-			stack.Top(1).Active().dirty = true
-		}
-
-		// If we were given a replacement value, there's no need to
-		// copy out any data.
-		if !curSlot.replaced {
-			// This switch statement is the inverse of the above. We'll fold the
-			// returning frame into a replacement value for the current slot.
-			switch curSlot.typeData.Kind {
-			case KindStruct:
-				// Allocate a replacement instance of the struct.
-				next := curSlot.typeData.NewStruct()
-				// Perform a shallow copy to catch non-visitable fields.
-				curSlot.typeData.Copy(next, curSlot.value)
-
-				// Copy the visitable fields into the new struct.
-				for i, f := range curSlot.typeData.Fields {
-					fPtr := Ptr(uintptr(next) + f.Offset)
-					f.targetData.Copy(fPtr, returning.Slot(i).value)
-				}
-				curSlot.value = next
-
-			case KindPointer:
-				// Copy out the pointer to a local var so we don't stomp on it.
-				next := returning.Zero().value
-				curSlot.value = Ptr(&next)
-
-			case KindSlice:
-				// Create a new slice instance and populate the elements.
-				next := curSlot.typeData.NewSlice(returning.Count)
-				toHeader := (*reflect.SliceHeader)(next)
-				elemTd := curSlot.typeData.elemData
-
-				// Copy the elements across.
-				for i := 0; i < returning.Count; i++ {
-					toElem := Ptr(toHeader.Data + uintptr(i)*elemTd.SizeOf)
-					elemTd.Copy(toElem, returning.Slot(i).value)
-				}
-				curSlot.value = next
+	if curSlot.dirty && stack.Depth() > 1 {
+		parent := stack.Top(1).Active()
+		parent.dirty = true
+		parent.modified = parent.modified || curSlot.modified
+	}
 
-			case KindInterface:
-				// Swap out the iface pointer just like the pointer case above.
-				next := returning.Zero()
-				curSlot.value = curSlot.typeData.IntfWrap(next.typeData.TypeID, next.value)
+	// The children of the slot have been folded into it, so we no longer
+	// need to hold onto them.
+	if returning != nil {
+		returning.release()
+		returning = nil
+	}
 
-			default:
-				panic(fmt.Errorf("unimplemented: %d", curSlot.typeData.Kind))
-			}
-		}
+	// Record the outcome of visiting a struct, unless we've stopped
+	// part-way through visiting it.
+	if memo != nil && curSlot.original.value != nil && !halting {
+		memo.record(e, curSlot.original, memoKey{curSlot.typeData.TypeID, curSlot.value})
 	}
 
 nextSlot:
@@ -354,12 +927,40 @@ nextSlot:
 			// pprof says that this is measurably faster than repeatedly
 			// dereferencing the pointer.
 			z := *curFrame.Zero()
-			return z.typeData.TypeID, z.value, z.dirty, nil
+			if restarting {
+				if restarts == maxRestarts {
+					return Action{}, false, fmt.Errorf("visitation restarted more than %d times", maxRestarts)
+				}
+				restarts++
+				restartedDirty = restartedDirty || z.dirty || z.mutated
+				halting, restarting = false, false
+				// The values will be visited again.
+				if visited != nil {
+					visited = make(map[memoKey]struct{})
+				}
+
+				// Re-bootstrap the stack with the updated value.
+				stack.Pop()
+				curFrame = stack.Enter(f.intercept, 1)
+				curFrame.InterceptNamed = f.interceptNamed
+				curFrame.Depth = f.depth
+				curSlot = curFrame.SetSlot(e, 0, ctx.ActionVisitReplace(z.typeData, z.value, root.assignableTo))
+				goto enter
+			}
+			if restartedDirty {
+				z.dirty, z.modified = true, true
+			}
+			return z, halting, nil
 		}
 		// Save off the current frame so we can copy the data out.
 		returning = stack.Pop()
 		curFrame = stack.Top(0)
 		curSlot = curFrame.Active()
+		// When rebuilding, the returning frame is always copied out,
+		// unless it holds the fields of an unmodified, interned struct.
+		if rebuild {
+			curSlot.dirty = curSlot.modified || !curSlot.interned()
+		}
 		// We'll jump back to the unwinding code to finish the slot of the
 		// frame which is now on top.
 		goto unwind
@@ -371,6 +972,105 @@ nextSlot:
 	}
 }
 
+// visitParallel visits each slot of the frame on top of the stack on
+// its own goroutine, while workers are available, by forking a
+// separate call to execute for each slot, and stores
+// the outcomes in the slots. The value which encloses the slots is the
+// active slot of the frame beneath. It returns true if the visitation
+// of any slot was halted.
+func (e *Engine) visitParallel(
+	fn FacadeFn, cfg *options, stack *stack, parent fork, unfiltered bool,
+) (halted bool, err error) {
+	entering := stack.Top(0)
+	spread := stack.Top(1).Active().typeData.Kind == KindStruct
+	sliceElement := stack.Top(1).Active().typeData.Kind == KindSlice
+
+	ancestors := make([]memoKey, 0, len(parent.ancestors)+stack.Depth()-1)
+	ancestors = append(ancestors, parent.ancestors...)
+	for l := 0; l < stack.Depth()-1; l++ {
+		onStack := stack.Peek(l).Active()
+		ancestors = append(ancestors, memoKey{onStack.typeData.TypeID, onStack.value})
+	}
+
+	forks := make([]fork, entering.Count)
+	for i := range forks {
+		// The path and mutability are computed relative to the active slot.
+		entering.Idx = i
+		forks[i] = fork{
+			ancestors:      ancestors,
+			depth:          entering.Depth,
+			frames:         parent.frames + stack.Depth() - 1,
+			immutable:      !stack.mutable(),
+			intercept:      entering.Intercept,
+			interceptNamed: entering.InterceptNamed,
+			path:           parent.path.join(stack.Path()),
+			sliceElement:   sliceElement,
+			spread:         spread,
+			unfiltered:     unfiltered,
+		}
+		if entering.InterceptNamed {
+			forks[i].interceptPath = stack.interceptedPath(parent.interceptPath)
+		}
+	}
+	entering.Idx = 0
+
+	// The goroutines work on copies of the slots, since each of them
+	// has a stack of its own.
+	slots := make([]Action, entering.Count)
+	for i := range slots {
+		slots[i] = *entering.Slot(i)
+	}
+	halts := make([]bool, entering.Count)
+	errs := make([]error, entering.Count)
+	cfg = cfg.synchronized()
+	var wg sync.WaitGroup
+	for i := range forks {
+		// Once every worker is busy, the remaining slots are visited on
+		// the current goroutine. This bounds the number of goroutines
+		// without nested Parallel decisions waiting on one another.
+		select {
+		case cfg.workers <- struct{}{}:
+			wg.Add(1)
+			go func(i int) {
+				defer func() {
+					<-cfg.workers
+					wg.Done()
+				}()
+				slots[i], halts[i], errs[i] = e.execute(fn, cfg, slots[i], forks[i])
+			}(i)
+		default:
+			slots[i], halts[i], errs[i] = e.execute(fn, cfg, slots[i], forks[i])
+		}
+	}
+	wg.Wait()
+
+	// Report the first error, in visitation order, for consistency.
+	for i := range errs {
+		if errs[i] != nil {
+			return false, errs[i]
+		}
+		*entering.Slot(i) = slots[i]
+		halted = halted || halts[i]
+	}
+	return halted, nil
+}
+
+// getStack returns a stack from the pool, or a new stack if the pool
+// is empty.
+func (e *Engine) getStack() *stack {
+	if s, ok := e.stacks.Get().(*stack); ok {
+		return s
+	}
+	return newStack()
+}
+
+// putStack returns a stack to the pool once a call to execute is done
+// with it.
+func (e *Engine) putStack(s *stack) {
+	s.reset()
+	e.stacks.Put(s)
+}
+
 // Stringify returns a string representation of the given type that
 // is suitable for debugging purposes.
 func (e *Engine) Stringify(id TypeID) string {
@@ -387,6 +1087,13 @@ func (e *Engine) Stringify(id TypeID) string {
 			}
 			ret.WriteString(td.Name)
 			return ret.String()
+		case KindArray:
+			ret.WriteString(fmt.Sprintf("[%d]", td.Len))
+			td = td.elemData
+		case KindMap:
+			// The name of a map type includes its value type.
+			ret.WriteString(td.Name)
+			return ret.String()
 		case KindPointer:
 			ret.WriteRune('*')
 			td = td.elemData
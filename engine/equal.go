@@ -0,0 +1,92 @@
+// Copyright 2019 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+package engine
+
+// Equal reports whether the trees rooted at a and b are structurally
+// equal. Both trees are traversed in lockstep, and at each position the
+// values must have the same type, the same number of children, and
+// labels which are equal according to same. The entries of maps are
+// matched by comparing their keys with ==. A nil pointer or interface, a typed-nil interface,
+// and an empty array, map, or slice are all considered to be equal to
+// one another, since none of them have any children to compare.
+func (e *Engine) Equal(aType TypeID, a Ptr, bType TypeID, b Ptr, same LabelFn) bool {
+	c := &comparer{
+		same: same,
+		seen: make(map[[2]memoKey]struct{}),
+	}
+	return c.equal(e.Abstract(aType, a), e.Abstract(bType, b))
+}
+
+// comparer holds the state used by Engine.Equal.
+type comparer struct {
+	same LabelFn
+	// seen contains the pairs of values which have been, or are being,
+	// compared. A pair which is encountered again is assumed to be
+	// equal, which prevents cycles from being followed indefinitely;
+	// any difference will be found by the first comparison.
+	seen map[[2]memoKey]struct{}
+}
+
+// equal reports whether a and b are structurally equal.
+func (c *comparer) equal(a, b *Abstract) bool {
+	switch {
+	case a == nil || b == nil:
+		return a == b
+	case a.typeData != b.typeData:
+		return false
+	case a.value == b.value:
+		return true
+	}
+
+	key := [2]memoKey{{a.TypeID(), a.value}, {b.TypeID(), b.value}}
+	if _, ok := c.seen[key]; ok {
+		return true
+	}
+	c.seen[key] = struct{}{}
+
+	n := a.NumChildren()
+	if n != b.NumChildren() {
+		return false
+	}
+	switch a.typeData.Kind {
+	case KindStruct:
+		if !c.same(a.TypeID(), a.value, b.value) {
+			return false
+		}
+	case KindMap:
+		// The entries of a map are in no particular order if the key type
+		// is not ordered, so each key of a is looked up in b.
+		aKeys, aValues := a.typeData.MapEntries(a.value)
+		bKeys, bValues := b.typeData.MapEntries(b.value)
+		index := make(map[interface{}]int, len(bKeys))
+		for i := range bKeys {
+			index[b.typeData.MapKey(bKeys[i])] = i
+		}
+		for i := range aKeys {
+			j, ok := index[a.typeData.MapKey(aKeys[i])]
+			if !ok || !c.equal(a.child(i, aValues), b.child(j, bValues)) {
+				return false
+			}
+		}
+		return true
+	}
+	for i := 0; i < n; i++ {
+		if !c.equal(a.ChildAt(i), b.ChildAt(i)) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,173 @@
+// Copyright 2019 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+package engine
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+)
+
+// LeafFn is provided to Engine.Marshal to report the exported fields
+// of a struct which are not visitable. It should call fn with the name
+// and value of each such field, in a stable order, and return the
+// first error that fn returns.
+type LeafFn func(id TypeID, x Ptr, fn func(name string, value interface{}) error) error
+
+// Marshal returns a JSON representation of the tree rooted at the
+// value. Each struct is encoded as an object whose "__type" key holds
+// the name of the struct, followed by the fields reported by leaves,
+// which are encoded with encoding/json, and then by its visitable
+// children. Slices and arrays are encoded as lists and maps as
+// objects. Pointers and interfaces are followed, while nil values and
+// empty slices or maps are encoded as null. An error will be returned
+// if a value encloses itself.
+func (e *Engine) Marshal(id TypeID, x Ptr, leaves LeafFn) ([]byte, error) {
+	m := &marshaler{leaves: leaves}
+	if err := m.marshal(e.Abstract(id, x)); err != nil {
+		return nil, err
+	}
+	return m.buf.Bytes(), nil
+}
+
+// marshaler holds the state used by Engine.Marshal.
+type marshaler struct {
+	buf    bytes.Buffer
+	leaves LeafFn
+	// stack holds the values which enclose the value being marshaled.
+	stack []memoKey
+}
+
+// marshal writes the value, followed by its children.
+func (m *marshaler) marshal(a *Abstract) (err error) {
+	if a == nil {
+		m.buf.WriteString("null")
+		return nil
+	}
+
+	key := memoKey{a.TypeID(), a.value}
+	for _, k := range m.stack {
+		if k == key {
+			return fmt.Errorf("%s encloses itself, so it cannot be marshaled",
+				a.engine.Stringify(a.TypeID()))
+		}
+	}
+	m.stack = append(m.stack, key)
+	defer func() { m.stack = m.stack[:len(m.stack)-1] }()
+
+	switch a.typeData.Kind {
+	case KindStruct:
+		m.buf.WriteString(`{"__type":`)
+		if err := m.value(a.typeData.Name); err != nil {
+			return err
+		}
+		if m.leaves != nil {
+			if err := m.leaves(a.TypeID(), a.value, func(name string, value interface{}) error {
+				if err := m.entry(name, value); err != nil {
+					return fmt.Errorf("%s.%s: %v", a.typeData.Name, name, err)
+				}
+				return nil
+			}); err != nil {
+				return err
+			}
+		}
+		a.Children(func(idx int, child *Abstract) bool {
+			err = m.child(a.typeData.childName(idx), child)
+			return err == nil
+		})
+		m.buf.WriteRune('}')
+
+	case KindMap:
+		// The entries are sorted by their encoded keys, so that the
+		// output is stable even if the key type is not ordered.
+		keys, values := a.typeData.MapEntries(a.value)
+		names := make([]string, len(keys))
+		order := make([]int, len(keys))
+		for i := range keys {
+			names[i] = fmt.Sprint(a.typeData.MapKey(keys[i]))
+			order[i] = i
+		}
+		sort.SliceStable(order, func(i, j int) bool { return names[order[i]] < names[order[j]] })
+
+		m.buf.WriteRune('{')
+		for i, idx := range order {
+			if i > 0 {
+				m.buf.WriteRune(',')
+			}
+			if err := m.key(names[idx]); err != nil {
+				return err
+			}
+			var child *Abstract
+			if typeData, value := a.resolve(a.typeData.elemData, values[idx]); typeData != nil {
+				child = &Abstract{engine: a.engine, index: idx, parent: a, typeData: typeData, value: value}
+			}
+			if err := m.marshal(child); err != nil {
+				return err
+			}
+		}
+		m.buf.WriteRune('}')
+
+	default:
+		m.buf.WriteRune('[')
+		a.Children(func(idx int, child *Abstract) bool {
+			if idx > 0 {
+				m.buf.WriteRune(',')
+			}
+			err = m.marshal(child)
+			return err == nil
+		})
+		m.buf.WriteRune(']')
+	}
+	return err
+}
+
+// child writes a comma-prefixed key and the child value.
+func (m *marshaler) child(name string, child *Abstract) error {
+	m.buf.WriteRune(',')
+	if err := m.key(name); err != nil {
+		return err
+	}
+	return m.marshal(child)
+}
+
+// entry writes a comma-prefixed key and the leaf value.
+func (m *marshaler) entry(name string, value interface{}) error {
+	m.buf.WriteRune(',')
+	if err := m.key(name); err != nil {
+		return err
+	}
+	return m.value(value)
+}
+
+// key writes an object key and its trailing colon.
+func (m *marshaler) key(name string) error {
+	if err := m.value(name); err != nil {
+		return err
+	}
+	m.buf.WriteRune(':')
+	return nil
+}
+
+// value writes the value using encoding/json.
+func (m *marshaler) value(value interface{}) error {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	m.buf.Write(data)
+	return nil
+}
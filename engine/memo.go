@@ -0,0 +1,197 @@
+// Copyright 2019 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+package engine
+
+import "math"
+
+// memoKey identifies a visited value by its type and address. Using
+// both the type and the pointer as a key distinguishes a struct from
+// the first field of that struct.
+type memoKey struct {
+	typeID TypeID
+	value  Ptr
+}
+
+// A Memo records the outcome of visiting struct values, so that a
+// value which is encountered more than once will only be visited once.
+// Values are identified by a structural hash of the subtree that they
+// root, and any values which share a hash are then compared with
+// Engine.Equal. Thus, a subtree which is identical to one that has
+// already been visited will reuse its outcome, even if it is stored at
+// a different address. A Memo may be shared across multiple calls to
+// Execute as long as the values that were visited are not mutated
+// in-place. A Memo is not safe for concurrent use.
+type Memo struct {
+	hashLabel LabelHashFn
+	// hashes caches the structural hash of each value which has been
+	// hashed, since the hash of a value incorporates those of all of
+	// its children.
+	hashes   map[memoKey]uint64
+	outcomes map[uint64][]memoOutcome
+	same     LabelFn
+}
+
+// memoOutcome records the value that was visited and the value which
+// should replace it.
+type memoOutcome struct {
+	original memoKey
+	outcome  memoKey
+}
+
+// LabelHashFn adds the scalar fields of a value to h, disregarding its
+// visitable children. Values which are equal according to the LabelFn
+// that accompanies it must produce the same hash.
+type LabelHashFn func(id TypeID, x Ptr, h *Hasher)
+
+// NewMemo constructs an empty Memo. The hashLabel and same functions
+// are used to hash and to compare the scalar fields of struct values.
+func NewMemo(hashLabel LabelHashFn, same LabelFn) *Memo {
+	return &Memo{
+		hashLabel: hashLabel,
+		hashes:    make(map[memoKey]uint64),
+		outcomes:  make(map[uint64][]memoOutcome),
+		same:      same,
+	}
+}
+
+// Len returns the number of outcomes that have been recorded.
+func (m *Memo) Len() int {
+	ret := 0
+	for _, outcomes := range m.outcomes {
+		ret += len(outcomes)
+	}
+	return ret
+}
+
+// lookup returns the outcome recorded for a value which is structurally
+// equal to the given value. If that outcome left the value unchanged,
+// the value itself is returned.
+func (m *Memo) lookup(e *Engine, key memoKey) (memoKey, bool) {
+	for _, o := range m.outcomes[m.hash(e.Abstract(key.typeID, key.value))] {
+		if o.original == key {
+			return o.outcome, true
+		}
+		if e.Equal(o.original.typeID, o.original.value, key.typeID, key.value, m.same) {
+			if o.outcome == o.original {
+				return key, true
+			}
+			return o.outcome, true
+		}
+	}
+	return memoKey{}, false
+}
+
+// record saves the outcome of visiting a value.
+func (m *Memo) record(e *Engine, original, outcome memoKey) {
+	h := m.hash(e.Abstract(original.typeID, original.value))
+	m.outcomes[h] = append(m.outcomes[h], memoOutcome{original, outcome})
+}
+
+// hash returns the structural hash of the tree rooted at a. A value
+// which is reached again while its own hash is being computed
+// contributes only its type, so that cycles are not followed
+// indefinitely. The entries of a map are combined without regard to
+// their order, since it may vary between maps.
+func (m *Memo) hash(a *Abstract) uint64 {
+	if a == nil {
+		return 0
+	}
+	key := memoKey{a.TypeID(), a.value}
+	if ret, ok := m.hashes[key]; ok {
+		return ret
+	}
+	h := NewHasher()
+	h.WriteUint(uint64(key.typeID))
+	// Mark the value as in-progress.
+	m.hashes[key] = h.Sum()
+
+	n := a.NumChildren()
+	h.WriteUint(uint64(n))
+	if a.typeData.Kind == KindStruct && m.hashLabel != nil {
+		m.hashLabel(key.typeID, key.value, h)
+	}
+	var entries uint64
+	for i := 0; i < n; i++ {
+		if a.typeData.Kind == KindMap {
+			entries += m.hash(a.ChildAt(i))
+		} else {
+			h.WriteUint(m.hash(a.ChildAt(i)))
+		}
+	}
+	h.WriteUint(entries)
+
+	ret := h.Sum()
+	m.hashes[key] = ret
+	return ret
+}
+
+// A Hasher computes a 64-bit FNV-1a hash of the values written to it,
+// for use by generated code.
+type Hasher struct {
+	sum uint64
+}
+
+// NewHasher constructs a Hasher.
+func NewHasher() *Hasher {
+	return &Hasher{sum: fnvOffset}
+}
+
+const (
+	fnvOffset = 14695981039346656037
+	fnvPrime  = 1099511628211
+)
+
+// Sum returns the hash of the values written so far.
+func (h *Hasher) Sum() uint64 {
+	return h.sum
+}
+
+// WriteBool adds a bool to the hash.
+func (h *Hasher) WriteBool(b bool) {
+	if b {
+		h.WriteUint(1)
+	} else {
+		h.WriteUint(0)
+	}
+}
+
+// WriteFloat adds a floating-point number to the hash.
+func (h *Hasher) WriteFloat(f float64) {
+	h.WriteUint(math.Float64bits(f))
+}
+
+// WriteInt adds a signed integer to the hash.
+func (h *Hasher) WriteInt(i int64) {
+	h.WriteUint(uint64(i))
+}
+
+// WriteString adds a string to the hash.
+func (h *Hasher) WriteString(s string) {
+	h.WriteUint(uint64(len(s)))
+	for i := 0; i < len(s); i++ {
+		h.sum ^= uint64(s[i])
+		h.sum *= fnvPrime
+	}
+}
+
+// WriteUint adds an unsigned integer to the hash.
+func (h *Hasher) WriteUint(u uint64) {
+	for i := 0; i < 8; i++ {
+		h.sum ^= u & 0xff
+		h.sum *= fnvPrime
+		u >>= 8
+	}
+}
@@ -0,0 +1,268 @@
+// Copyright 2019 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+package engine
+
+import (
+	"context"
+	"runtime"
+	"sync"
+)
+
+// An Option customizes the behavior of a single call to Execute.
+type Option func(*options)
+
+// options holds the configuration assembled from a collection of
+// Option values. Execute will not construct an options unless at
+// least one Option is provided, in order to keep the default path
+// allocation-free.
+type options struct {
+	alloc    AllocFn
+	cancel   context.Context
+	changes  *ChangeSet
+	count    *int64
+	maxDepth int
+	memo     *Memo
+	// mu is set once the hooks have been synchronized.
+	mu       *sync.Mutex
+	onChange ChangeFn
+	onCycle  CycleFn
+	onNil    NilFn
+	once     bool
+	only     map[TypeID]bool
+	onSlice  SliceFn
+	rebuild  bool
+	skip     map[TypeID]bool
+	state    interface{}
+	// workers bounds the number of goroutines forked by Parallel
+	// decisions. It is set once the hooks have been synchronized.
+	workers chan struct{}
+}
+
+// synchronized returns a copy of the options whose hooks may be
+// invoked from the goroutines forked by a Parallel decision. The copy
+// is shared by any nested Parallel decisions, so that they draw from
+// the same pool of GOMAXPROCS workers.
+func (o *options) synchronized() *options {
+	if o == nil {
+		o = &options{}
+	} else if o.mu != nil {
+		return o
+	}
+	ret := *o
+	mu := &sync.Mutex{}
+	ret.mu = mu
+	ret.workers = make(chan struct{}, runtime.GOMAXPROCS(0))
+	if fn := o.alloc; fn != nil {
+		ret.alloc = func(id TypeID) Ptr {
+			mu.Lock()
+			defer mu.Unlock()
+			return fn(id)
+		}
+	}
+	if fn := o.onChange; fn != nil {
+		ret.onChange = func(path Path, beforeType TypeID, before Ptr, afterType TypeID, after Ptr) {
+			mu.Lock()
+			defer mu.Unlock()
+			fn(path, beforeType, before, afterType, after)
+		}
+	}
+	if fn := o.onCycle; fn != nil {
+		ret.onCycle = func(path Path, id TypeID, x Ptr) {
+			mu.Lock()
+			defer mu.Unlock()
+			fn(path, id, x)
+		}
+	}
+	if fn := o.onSlice; fn != nil {
+		ret.onSlice = func(path Path, id TypeID, length int) {
+			mu.Lock()
+			defer mu.Unlock()
+			fn(path, id, length)
+		}
+	}
+	return &ret
+}
+
+// AllocFn is a callback which returns memory for a struct of the given
+// type, or nil to allocate it as usual. The contents of the memory
+// will be overwritten.
+type AllocFn func(id TypeID) Ptr
+
+// WithAllocator registers a callback which will be consulted whenever
+// Execute must copy a struct in order to replace one of its children.
+// This allows callers which perform many rewrites to reuse memory
+// which they know to be unreferenced.
+func WithAllocator(fn AllocFn) Option {
+	return func(o *options) {
+		o.alloc = fn
+	}
+}
+
+// ChangeFn is a callback which receives a value which has been
+// replaced by a callback, along with its replacement.
+type ChangeFn func(path Path, beforeType TypeID, before Ptr, afterType TypeID, after Ptr)
+
+// WithChangeHook registers a callback which will be invoked whenever a
+// callback passed to Execute replaces a value.
+func WithChangeHook(fn ChangeFn) Option {
+	return func(o *options) {
+		o.onChange = fn
+	}
+}
+
+// WithChanges restricts Execute to visiting the values within the
+// ChangeSet. Any other value, along with all of the values that it
+// encloses, will be skipped without invoking any callbacks.
+func WithChanges(c *ChangeSet) Option {
+	return func(o *options) {
+		o.changes = c
+	}
+}
+
+// CycleFn is a callback which receives a value which will not be
+// visited because it is already being visited, i.e. it would
+// otherwise form a cycle. The path is the location of the
+// back-reference which closes the cycle.
+type CycleFn func(path Path, id TypeID, x Ptr)
+
+// WithContext causes Execute to stop and return the context's error
+// once the context has been cancelled. The context is checked whenever
+// a value's children are about to be visited.
+func WithContext(ctx context.Context) Option {
+	return func(o *options) {
+		o.cancel = ctx
+	}
+}
+
+// WithCount causes Execute to add the number of times that it invokes
+// the callback for a value to count. Post-visit functions and
+// interceptors are not counted. The count is updated atomically, so
+// that it may be shared by parallel visitations.
+func WithCount(count *int64) Option {
+	return func(o *options) {
+		o.count = count
+	}
+}
+
+// WithCycleHook registers a callback which will be invoked whenever
+// Execute breaks a cycle.
+func WithCycleHook(fn CycleFn) Option {
+	return func(o *options) {
+		o.onCycle = fn
+	}
+}
+
+// WithMaxDepth causes Execute to return an error, rather than
+// descending further, once its stack would hold more than n frames.
+// The top-level value occupies one frame, and every struct, pointer,
+// interface, slice, array, or map which encloses a value adds another.
+// This guards against malformed or adversarial inputs which would
+// otherwise grow the stack without bound. A limit of zero or less is
+// unlimited.
+func WithMaxDepth(n int) Option {
+	return func(o *options) {
+		o.maxDepth = n
+	}
+}
+
+// WithMemo causes Execute to record the outcome of visiting each struct
+// in the given Memo and to reuse any previously-recorded outcomes.
+func WithMemo(m *Memo) Option {
+	return func(o *options) {
+		o.memo = m
+	}
+}
+
+// NilFn is a facade which invokes the user's callback for a nil
+// interface value, since there is no struct type to dispatch on.
+type NilFn func(ctx Context, fn FacadeFn) Decision
+
+// WithNils causes Execute to invoke the callback, via the given
+// facade, for each nil interface value that it would otherwise skip.
+// Since there is nothing to descend into, replacing the nil value, or
+// stopping the visitation, are the only meaningful decisions. Any
+// post-visit function is ignored.
+func WithNils(fn NilFn) Option {
+	return func(o *options) {
+		o.onNil = fn
+	}
+}
+
+// WithOnce causes Execute to visit each value at most once, even if
+// it is reachable through multiple pointers. A value which has already
+// been visited anywhere in the visitation, rather than only by an
+// enclosing value, will be skipped along with all of the values that
+// it encloses.
+func WithOnce() Option {
+	return func(o *options) {
+		o.once = true
+	}
+}
+
+// WithRebuild causes every value visited by Execute to be treated as
+// though it had been changed. The result will be a copy of the input
+// which does not share any visitable memory with it.
+func WithRebuild() Option {
+	return func(o *options) {
+		o.rebuild = true
+	}
+}
+
+// SliceFn is a callback which receives the location, type, and length
+// of a slice which is about to be visited.
+type SliceFn func(path Path, id TypeID, length int)
+
+// WithSliceHook registers a callback which will be invoked whenever
+// Execute visits a slice, before any of its elements are visited.
+func WithSliceHook(fn SliceFn) Option {
+	return func(o *options) {
+		o.onSlice = fn
+	}
+}
+
+// WithSkipTypes causes Execute to skip values of the given types,
+// along with all of the values that they enclose, without invoking any
+// callbacks. Since they are not visited, skipped values cannot be
+// replaced.
+func WithSkipTypes(ids ...TypeID) Option {
+	return func(o *options) {
+		o.skip = make(map[TypeID]bool, len(ids))
+		for _, id := range ids {
+			o.skip[id] = true
+		}
+	}
+}
+
+// WithState provides a value which will be made available to the
+// callbacks through Context.State.
+func WithState(state interface{}) Option {
+	return func(o *options) {
+		o.state = state
+	}
+}
+
+// WithTypes restricts the callback passed to Execute to struct values
+// of the given types. Values of any other type are visited as though
+// the callback had returned a zero Decision, so their children will
+// still be visited.
+func WithTypes(ids ...TypeID) Option {
+	return func(o *options) {
+		o.only = make(map[TypeID]bool, len(ids))
+		for _, id := range ids {
+			o.only[id] = true
+		}
+	}
+}
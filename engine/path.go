@@ -0,0 +1,115 @@
+// Copyright 2019 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+package engine
+
+import (
+	"fmt"
+	"strings"
+)
+
+// A PathSegment describes a step from a value to one of its children.
+type PathSegment struct {
+	// Field is the name of a struct field. It will be empty if the
+	// segment refers to an element of a slice or array, or to an entry
+	// of a map.
+	Field string
+	// Index is the index of a slice or array element, the position of
+	// a map entry in visitation order, or the index of the struct
+	// field.
+	Index int
+	// Key is the key of a map entry. It will be nil if the segment
+	// refers to any other kind of child.
+	Key interface{}
+}
+
+// A Path describes the location of a value, relative to the value
+// passed to Execute. Pointers and interfaces are dereferenced
+// transparently, so they do not contribute segments to the path.
+type Path []PathSegment
+
+// String returns a representation of the path such as
+// "TargetSlice[2].Val" or "Env[\"key\"]".
+func (p Path) String() string {
+	var sb strings.Builder
+	for _, seg := range p {
+		if seg.Key != nil {
+			if s, ok := seg.Key.(string); ok {
+				fmt.Fprintf(&sb, "[%q]", s)
+			} else {
+				fmt.Fprintf(&sb, "[%v]", seg.Key)
+			}
+			continue
+		}
+		if seg.Field == "" {
+			fmt.Fprintf(&sb, "[%d]", seg.Index)
+			continue
+		}
+		if sb.Len() > 0 {
+			sb.WriteRune('.')
+		}
+		sb.WriteString(seg.Field)
+	}
+	return sb.String()
+}
+
+// join returns the concatenation of the two paths, without modifying
+// either of them.
+func (p Path) join(q Path) Path {
+	if len(p) == 0 {
+		return q
+	}
+	return append(p[:len(p):len(p)], q...)
+}
+
+// Path constructs the path to the active slot of the top frame.
+func (s *stack) Path() Path {
+	return s.pathFrom(1)
+}
+
+// interceptedPath constructs the path to the active slot of the top
+// frame, relative to the value which registered the frame's
+// interceptor. The prefix is the location of a forked child, relative
+// to the value which registered an interceptor that it inherited.
+func (s *stack) interceptedPath(prefix Path) Path {
+	if base := s.Top(0).InterceptBase; base > 0 {
+		return s.pathFrom(base)
+	}
+	return prefix.join(s.pathFrom(1))
+}
+
+// pathFrom constructs the path to the active slot of the top frame,
+// relative to the active slot of the frame below the given one.
+func (s *stack) pathFrom(start int) Path {
+	var ret Path
+	for i := start; i < s.depth; i++ {
+		parent := s.Peek(i - 1).Active()
+		f := s.Peek(i)
+		switch parent.typeData.Kind {
+		case KindArray, KindSlice:
+			ret = append(ret, PathSegment{Index: f.Idx})
+		case KindMap:
+			ret = append(ret, PathSegment{Index: f.Idx, Key: parent.typeData.MapKey(f.Keys[f.Idx])})
+		case KindStruct:
+			seg := PathSegment{Index: f.Idx}
+			// Callbacks may have provided their own actions to visit.
+			if f.Count == len(parent.typeData.Fields)+len(parent.typeData.Getters) {
+				seg.Field = parent.typeData.childName(f.Idx)
+			}
+			ret = append(ret, seg)
+		}
+	}
+	return ret
+}
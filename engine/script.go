@@ -0,0 +1,214 @@
+// Copyright 2019 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+package engine
+
+import "fmt"
+
+// An EditOp describes the kind of change made by an Edit.
+type EditOp int
+
+// The kinds of edits that may appear in a script.
+const (
+	_ EditOp = iota
+	EditDelete
+	EditInsert
+	EditReplace
+)
+
+// String is for debugging use only.
+func (o EditOp) String() string {
+	switch o {
+	case EditDelete:
+		return "Delete"
+	case EditInsert:
+		return "Insert"
+	case EditReplace:
+		return "Replace"
+	default:
+		return fmt.Sprintf("EditOp(%d)", int(o))
+	}
+}
+
+// An Edit is one step of a script produced by Engine.Script.
+type Edit struct {
+	Op EditOp
+	// Path is the location of the value. The paths of deleted values
+	// are relative to the original tree, while the paths of inserted and
+	// replaced values are relative to the new tree.
+	Path Path
+	// Before is the value which was deleted or replaced. It will be nil
+	// for insertions, or if a nil slice element was deleted.
+	Before *Abstract
+	// After is the value which was inserted or which replaced Before.
+	// It will be nil for deletions, or if a nil slice element was
+	// inserted.
+	After *Abstract
+}
+
+// LabelFn reports whether two values of the same type are equal,
+// disregarding their visitable children.
+type LabelFn func(id TypeID, a, b Ptr) bool
+
+// Script computes a minimal sequence of edits which transforms the
+// tree rooted at a into the tree rooted at b. Struct fields and array
+// elements are compared by position, while the elements of slices and
+// the entries of maps are aligned to minimize the number of edits,
+// allowing elements to be inserted or deleted. A value whose type
+// differs from the value that it replaces is replaced wholesale.
+// Otherwise, a value whose label differs is replaced and its children
+// are compared in turn. Values which are shared between the two trees
+// are not examined.
+func (e *Engine) Script(aType TypeID, a Ptr, bType TypeID, b Ptr, same LabelFn) []Edit {
+	s := &scripter{
+		same: same,
+		seen: make(map[[2]memoKey][]Edit),
+	}
+	return s.diff(e.Abstract(aType, a), e.Abstract(bType, b))
+}
+
+// scripter holds the state used by Engine.Script.
+type scripter struct {
+	same LabelFn
+	// seen memoizes the edits between a pair of values, relative to the
+	// pair. It also prevents cycles from being followed indefinitely,
+	// since a pair which is still being compared will have no edits.
+	seen map[[2]memoKey][]Edit
+}
+
+// diff returns the edits which transform a into b, relative to a and b.
+func (s *scripter) diff(a, b *Abstract) []Edit {
+	switch {
+	case a == nil && b == nil:
+		return nil
+	case a == nil:
+		return []Edit{{Op: EditInsert, After: b}}
+	case b == nil:
+		return []Edit{{Op: EditDelete, Before: a}}
+	case a.typeData != b.typeData:
+		return []Edit{{Op: EditReplace, Before: a, After: b}}
+	case a.value == b.value:
+		return nil
+	}
+
+	key := [2]memoKey{{a.TypeID(), a.value}, {b.TypeID(), b.value}}
+	if ret, ok := s.seen[key]; ok {
+		return ret
+	}
+	s.seen[key] = nil
+
+	var ret []Edit
+	if a.typeData.Kind == KindStruct && !s.same(a.TypeID(), a.value, b.value) {
+		ret = append(ret, Edit{Op: EditReplace, Before: a, After: b})
+	}
+
+	if a.typeData.Kind == KindMap || a.typeData.Kind == KindSlice {
+		ret = append(ret, s.align(a, b)...)
+	} else {
+		// Structs and arrays of the same type have the same number of
+		// children.
+		for i, n := 0, a.NumChildren(); i < n; i++ {
+			seg := PathSegment{Index: i}
+			if a.typeData.Kind == KindStruct {
+				seg.Field = a.typeData.childName(i)
+			}
+			ret = append(ret, prefix(seg, seg, s.diff(a.ChildAt(i), b.ChildAt(i)))...)
+		}
+	}
+
+	s.seen[key] = ret
+	return ret
+}
+
+// align returns the edits which transform the elements of slice or map
+// a into the elements of b. This is the classic edit-distance problem,
+// where the cost of substituting one element for another is the number
+// of edits between them.
+func (s *scripter) align(a, b *Abstract) []Edit {
+	n, m := a.NumChildren(), b.NumChildren()
+	as := make([]*Abstract, n)
+	for i := range as {
+		as[i] = a.ChildAt(i)
+	}
+	bs := make([]*Abstract, m)
+	for j := range bs {
+		bs[j] = b.ChildAt(j)
+	}
+
+	// cost[i][j] is the number of edits to transform as[i:] into bs[j:].
+	cost := make([][]int, n+1)
+	for i := range cost {
+		cost[i] = make([]int, m+1)
+	}
+	for i := n; i >= 0; i-- {
+		for j := m; j >= 0; j-- {
+			switch {
+			case i == n:
+				cost[i][j] = m - j
+			case j == m:
+				cost[i][j] = n - i
+			default:
+				best := cost[i+1][j+1] + len(s.diff(as[i], bs[j]))
+				if c := cost[i+1][j] + 1; c < best {
+					best = c
+				}
+				if c := cost[i][j+1] + 1; c < best {
+					best = c
+				}
+				cost[i][j] = best
+			}
+		}
+	}
+
+	// Now, walk the cheapest path, preferring to pair up elements.
+	var ret []Edit
+	for i, j := 0, 0; i < n || j < m; {
+		if i < n && j < m {
+			if edits := s.diff(as[i], bs[j]); cost[i][j] == cost[i+1][j+1]+len(edits) {
+				ret = append(ret, prefix(PathSegment{Index: i}, PathSegment{Index: j}, edits)...)
+				i++
+				j++
+				continue
+			}
+		}
+		if i < n && cost[i][j] == cost[i+1][j]+1 {
+			ret = append(ret, Edit{Op: EditDelete, Path: Path{{Index: i}}, Before: as[i]})
+			i++
+			continue
+		}
+		ret = append(ret, Edit{Op: EditInsert, Path: Path{{Index: j}}, After: bs[j]})
+		j++
+	}
+	return ret
+}
+
+// prefix returns a copy of the edits whose paths begin with the given
+// segments. Deletions are relative to the original tree, so they use a
+// distinct segment.
+func prefix(deleted, other PathSegment, edits []Edit) []Edit {
+	if len(edits) == 0 {
+		return nil
+	}
+	ret := make([]Edit, len(edits))
+	for i, edit := range edits {
+		seg := other
+		if edit.Op == EditDelete {
+			seg = deleted
+		}
+		edit.Path = append(Path{seg}, edit.Path...)
+		ret[i] = edit
+	}
+	return ret
+}
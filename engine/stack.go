@@ -15,9 +15,17 @@
 
 package engine
 
+import "fmt"
+
 type stack struct {
 	data  []frame
 	depth int
+	// immutable is set when the top-level value is not stored in
+	// mutable memory, e.g. a forked child which is a copy.
+	immutable bool
+	// sliceElement is set when the top-level value is an element of a
+	// slice, i.e. a forked child.
+	sliceElement bool
 }
 
 func newStack() *stack {
@@ -40,14 +48,47 @@ func (s *stack) Enter(intercept FacadeFn, slotCount int) *frame {
 	s.depth++
 
 	entering.Count = slotCount
+	entering.Depth = 0
 	entering.Intercept = intercept
+	entering.InterceptBase = s.depth - 1
+	entering.InterceptNamed = false
 	entering.Idx = 0
-	if slotCount > fixedSlotCount {
-		entering.Overflow = make([]Action, slotCount-fixedSlotCount)
+	entering.Keys = nil
+	// The overflow slots of a frame are retained when it is released, so
+	// that a pooled stack may visit large targets without allocating.
+	if n := slotCount - fixedSlotCount; n <= 0 {
+		entering.Overflow = entering.Overflow[:0]
+	} else if cap(entering.Overflow) >= n {
+		entering.Overflow = entering.Overflow[:n]
+	} else {
+		entering.Overflow = make([]Action, n)
 	}
 	return entering
 }
 
+// Inherit pushes a new frame onto the stack, which inherits the
+// interceptor of the given frame.
+func (s *stack) Inherit(parent *frame, slotCount int) *frame {
+	// Entering the frame may reallocate the stack.
+	intercept, base, named := parent.Intercept, parent.InterceptBase, parent.InterceptNamed
+	entering := s.Enter(intercept, slotCount)
+	entering.InterceptBase = base
+	entering.InterceptNamed = named
+	return entering
+}
+
+// reset prepares the stack to be reused. Any frames which were left
+// on the stack, or popped without being released, are cleared so that
+// a pooled stack doesn't retain the values that it last visited.
+func (s *stack) reset() {
+	for i := range s.data {
+		s.data[i].release()
+	}
+	s.depth = 0
+	s.immutable = false
+	s.sliceElement = false
+}
+
 // Peek retrieves the frame at the given depth.
 func (s *stack) Peek(depth int) *frame {
 	return &s.data[depth]
@@ -63,3 +104,71 @@ func (s *stack) Pop() *frame {
 func (s *stack) Top(offset int) *frame {
 	return &s.data[s.depth-1-offset]
 }
+
+// mutable returns true if the value in the active slot of the top
+// frame may be overwritten without cloning its parents. This is the
+// case if the value is reachable from a pointer or a slice, without
+// passing through a value which is a copy, such as a map entry. The
+// top-level value is considered to be mutable, since the engine is
+// given a pointer to it, unless it is a forked child which is a copy.
+func (s *stack) mutable() bool {
+	for i := 1; i < s.depth; i++ {
+		child := s.Top(i - 1).Active()
+		parent := s.Top(i).Active()
+		switch parent.typeData.Kind {
+		case KindPointer, KindSlice:
+			return true
+		case KindMap:
+			// The values in a map are copies.
+			return false
+		case KindInterface:
+			// An interface holds a pointer to a copy of any value which is
+			// not itself a pointer. We can tell the two cases apart by
+			// re-wrapping the value as a pointer and comparing the types.
+			wrapped := parent.typeData.IntfWrap(child.typeData.TypeID, child.value)
+			return wrapped != nil && (*[2]Ptr)(wrapped)[0] == (*[2]Ptr)(parent.value)[0]
+		}
+		// Values returned by getters are copies.
+		if parent.assignableTo == nil {
+			return false
+		}
+	}
+	return !s.immutable
+}
+
+// expand records the values which should replace the slice element
+// which holds the active slot of the top frame. Pointers and interfaces
+// between the element and the active slot are looked through.
+func (s *stack) expand(e *Engine, values []memoKey) error {
+	for i := 0; i < s.depth; i++ {
+		elem := s.Top(i).Active()
+		var kind Kind
+		if i+1 < s.depth {
+			kind = s.Top(i + 1).Active().typeData.Kind
+		} else if s.sliceElement {
+			kind = KindSlice
+		}
+		if kind == KindInterface || kind == KindPointer {
+			continue
+		}
+		if kind != KindSlice {
+			break
+		}
+
+		elemTd := elem.assignableTo
+		expansion := make([]Ptr, len(values))
+		for j, v := range values {
+			x, ok := elemTd.assign(e.typeData(v.typeID), v.value)
+			if !ok {
+				return fmt.Errorf("type %s is not assignable to %s",
+					e.Stringify(v.typeID), e.Stringify(elemTd.TypeID))
+			}
+			expansion[j] = x
+		}
+		elem.expansion = expansion
+		elem.dirty, elem.modified, elem.replaced = true, true, true
+		return nil
+	}
+	return fmt.Errorf("%s is not an element of a slice, so it cannot be replaced with multiple values",
+		e.Stringify(s.Top(0).Active().typeData.TypeID))
+}
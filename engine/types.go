@@ -24,8 +24,8 @@ import (
 )
 
 // A TypeID is an opaque reference to a visitable type. These are
-// assigned by the code-generator and their specific values and order
-// are arbitrary.
+// assigned by the code-generator, which will retain the value of each
+// TypeID across runs. Values are otherwise arbitrary.
 type TypeID int
 
 // A TypeMap holds the necessary metadata to visit a collection of types.
@@ -38,7 +38,9 @@ type Kind int
 // its access pattern.
 const (
 	_ Kind = iota
+	KindArray
 	KindInterface
+	KindMap
 	KindPointer
 	KindSlice
 	KindStruct
@@ -59,13 +61,18 @@ type Ptr unsafe.Pointer
 type TypeData struct {
 	// Copy will effect a type aware copy of the data at from to dest.
 	Copy func(dest, from Ptr)
-	// Elem is the element type of a slice or of a pointer.
+	// Elem is the element type of an array, a slice, or a pointer, or
+	// the value type of a map.
 	Elem TypeID
 	// Facade will call a user-provided facade function in a
 	// type-safe fashion.
 	Facade func(Context, FacadeFn, Ptr) Decision
 	// Fields holds information about the fields of a struct.
 	Fields []FieldInfo
+	// Getters holds information about the methods of a struct which
+	// provide additional, read-only children. These are visited after
+	// the fields.
+	Getters []GetterInfo
 	// IntfType accepts a pointer to an interface type and returns a
 	// TypeID for the enclosed datatype.
 	//
@@ -80,15 +87,30 @@ type TypeData struct {
 	IntfWrap func(TypeID, Ptr) Ptr
 	// Kind selects various strategies for handling the given type.
 	Kind Kind
+	// Len is the number of elements in an array type.
+	Len int
+	// MapEntries accepts a pointer to a map and returns pointers to
+	// copies of its keys and values. The entries are sorted by key if
+	// the key type is ordered.
+	MapEntries func(Ptr) (keys, values []Ptr)
+	// MapKey accepts a pointer to a key of a map and returns the key.
+	MapKey func(Ptr) interface{}
 	// Name is the source name of the type.
 	Name string
+	// NewArray returns a pointer to a newly-allocated array.
+	NewArray func() Ptr
+	// NewMap constructs a map with space for the given number of
+	// entries and returns a pointer to it.
+	NewMap func(size int) Ptr
 	// NewSlice constructs a slice of the given length and returns a
 	// pointer to the slice's header.
 	NewSlice func(size int) Ptr
 	// NewStruct returns a pointer to a newly-allocated struct.
 	NewStruct func() Ptr
+	// SetMapIndex stores a copy of the value in the map under the key.
+	SetMapIndex func(m, key, value Ptr)
 	// SizeOf is the size of the data type. This is used for traversing
-	// slices. It could be expanded in the future to generalizing the
+	// arrays and slices. It could be expanded in the future to generalizing the
 	// Copy() function.
 	SizeOf uintptr
 	// TypeID is a generated id.
@@ -96,8 +118,14 @@ type TypeData struct {
 
 	// This field is populated when an Engine is constructed.
 	elemData *TypeData
+	// This field is populated by Engine.Intern.
+	interned InternFn
 }
 
+// InternFn reports whether the struct at the given address is an
+// interned value.
+type InternFn func(x Ptr) bool
+
 // FieldInfo describes a field within a struct.
 type FieldInfo struct {
 	Name   string
@@ -108,8 +136,43 @@ type FieldInfo struct {
 	targetData *TypeData
 }
 
+// GetterInfo describes a method which returns a child of a struct.
+type GetterInfo struct {
+	// Get calls the method on the struct at the given address and
+	// returns a pointer to a copy of the result.
+	Get    func(Ptr) Ptr
+	Name   string
+	Target TypeID
+
+	// This field is populated when an Engine is constructed.
+	targetData *TypeData
+}
+
+// childName returns the name of a child of a struct, which is either
+// the name of a field or the name of a getter followed by parentheses.
+func (td *TypeData) childName(idx int) string {
+	if idx < len(td.Fields) {
+		return td.Fields[idx].Name
+	}
+	return td.Getters[idx-len(td.Fields)].Name + "()"
+}
+
 // Context is provided to generated, type-safe facades.
-type Context struct{}
+type Context struct {
+	depth int
+	// intercepted holds the location of the value being passed to an
+	// interceptor registered by InterceptNamed, relative to the value
+	// which registered it.
+	intercepted Path
+	// parent holds the nearest struct which encloses the value being
+	// visited.
+	parent memoKey
+	// prefix holds the location of a forked child, relative to the
+	// value passed to Execute.
+	prefix Path
+	stack  *stack
+	state  interface{}
+}
 
 // ActionCall constructs an action which will invoke the function.
 func (Context) ActionCall(fn ActionFn) Action {
@@ -142,6 +205,51 @@ func (Context) Continue() Decision {
 	return Decision{}
 }
 
+// ReplaceChildren is for use by generated code only.
+func (Context) ReplaceChildren(actions []Action) Decision {
+	if actions == nil {
+		actions = []Action{}
+	}
+	return Decision{actions: actions, children: true}
+}
+
+// Depth returns the number of struct values which enclose the value
+// currently being visited.
+func (c Context) Depth() int {
+	return c.depth
+}
+
+// Intercepted returns the location of the value being intercepted,
+// relative to the value whose Decision registered the interceptor. It
+// will be nil unless the interceptor was registered by InterceptNamed.
+func (c Context) Intercepted() Path {
+	return c.intercepted
+}
+
+// Parent returns the nearest struct value which encloses the value
+// currently being visited, or a zero TypeID if there is none. Since the
+// enclosing values are only rebuilt once all of their children have
+// been visited, this is always the original value of the parent,
+// without any replacements made by this visitation.
+func (c Context) Parent() (TypeID, Ptr) {
+	return c.parent.typeID, c.parent.value
+}
+
+// Path returns the location of the value currently being visited,
+// relative to the value passed to Execute. The path is constructed on
+// demand.
+func (c Context) Path() Path {
+	if c.stack == nil {
+		return nil
+	}
+	return c.prefix.join(c.stack.Path())
+}
+
+// State returns the value provided to WithState, if any.
+func (c Context) State() interface{} {
+	return c.state
+}
+
 // Error is for use by generated code only.
 func (Context) Error(err error) Decision {
 	return Decision{error: err}
@@ -152,6 +260,11 @@ func (Context) Halt() Decision {
 	return Decision{halt: true}
 }
 
+// Parallel is for use by generated code only.
+func (Context) Parallel() Decision {
+	return Decision{parallel: true}
+}
+
 // Skip is for use by generated code only.
 func (Context) Skip() Decision {
 	return Decision{skip: true}
@@ -159,19 +272,37 @@ func (Context) Skip() Decision {
 
 // Decision is wrapped by generated, type-safe facades.
 type Decision struct {
-	actions         []Action
-	error           error
+	actions []Action
+	// children is set when the values visited by the actions should
+	// replace the elements of the value's slice field.
+	children bool
+	error    error
+	// expansion holds the values which should replace the slice
+	// element that holds the value.
+	expansion       []memoKey
 	halt            bool
+	inPlace         bool
 	intercept       FacadeFn
+	interceptNamed  bool
+	parallel        bool
 	post            FacadeFn
 	replacement     Ptr
 	replacementType TypeID
+	restart         bool
 	skip            bool
 }
 
 // Intercept is for use by generated code only.
 func (d Decision) Intercept(fn FacadeFn) Decision {
 	d.intercept = fn
+	d.interceptNamed = false
+	return d
+}
+
+// InterceptNamed is for use by generated code only.
+func (d Decision) InterceptNamed(fn FacadeFn) Decision {
+	d.intercept = fn
+	d.interceptNamed = true
 	return d
 }
 
@@ -183,36 +314,135 @@ func (d Decision) Post(fn FacadeFn) Decision {
 
 // Replace is for use by generated code only.
 func (d Decision) Replace(id TypeID, x Ptr) Decision {
+	d.expansion = nil
+	d.inPlace = false
 	d.replacement = x
 	d.replacementType = id
 	return d
 }
 
+// ReplaceWith is for use by generated code only.
+func (d Decision) ReplaceWith(ids []TypeID, xs []Ptr) Decision {
+	d.inPlace = false
+	d.replacement = nil
+	d.replacementType = 0
+	d.skip = true
+	d.expansion = make([]memoKey, len(xs))
+	for i := range xs {
+		d.expansion[i] = memoKey{ids[i], xs[i]}
+	}
+	return d
+}
+
+// ReplaceInPlace is for use by generated code only.
+func (d Decision) ReplaceInPlace(id TypeID, x Ptr) Decision {
+	d = d.Replace(id, x)
+	d.inPlace = true
+	return d
+}
+
+// Restart is for use by generated code only.
+func (d Decision) Restart() Decision {
+	d.restart = true
+	return d
+}
+
+// restarts returns true if the decision should cause the visitation
+// to be restarted. A restart must be accompanied by a replacement, and
+// halting takes precedence.
+func (d Decision) restarts() bool {
+	return d.restart && d.replacement != nil && !d.halt
+}
+
 // Action allows user-defined actions to be inserted into the
 // visitation flow.
 type Action struct {
 	assignableTo *TypeData
 	call         ActionFn
-	dirty        bool
-	post         FacadeFn
-	replaced     bool
-	typeData     *TypeData
-	value        Ptr
-	valueType    TypeID
+	// children is set when the slice field of a struct should be
+	// rebuilt from the values in the returning frame.
+	children bool
+	dirty    bool
+	// expansion holds the values which should replace a slice element
+	// when the slice is rebuilt. It will be non-nil, but possibly
+	// empty, if the element has been replaced with multiple values.
+	expansion []Ptr
+	// modified is set when a callback has replaced the value, or any
+	// value that it encloses. Unlike dirty, it is not forced by
+	// WithRebuild.
+	modified bool
+	// mutated is set when the value, or any value that it encloses,
+	// has been replaced in place.
+	mutated bool
+	// original is populated when memoizing and holds the value which
+	// was visited, before any replacement occurred.
+	original  memoKey
+	post      FacadeFn
+	replaced  bool
+	typeData  *TypeData
+	value     Ptr
+	valueType TypeID
+}
+
+// sliceOnly returns true if the type is a struct whose only child is
+// a slice field.
+func (td *TypeData) sliceOnly() bool {
+	return td.Kind == KindStruct && len(td.Fields) == 1 && len(td.Getters) == 0 &&
+		td.Fields[0].targetData.Kind == KindSlice
+}
+
+// assign returns a pointer to a value of the type which holds the
+// given value, or false if the value is not assignable to the type.
+func (td *TypeData) assign(from *TypeData, x Ptr) (Ptr, bool) {
+	switch {
+	case from.TypeID == td.TypeID:
+		return x, true
+	case td.Kind == KindPointer && td.elemData.TypeID == from.TypeID:
+		return Ptr(&x), true
+	case td.Kind == KindInterface:
+		ret := td.IntfWrap(from.TypeID, x)
+		return ret, ret != nil
+	default:
+		return nil, false
+	}
 }
 
-// apply updates the action with information from a decision.
-func (a *Action) apply(e *Engine, d Decision) error {
+// interned returns true if the action's value is an interned struct.
+func (a *Action) interned() bool {
+	return a.typeData.interned != nil && a.typeData.interned(a.value)
+}
+
+// apply updates the action with information from a decision. The
+// action must be the active slot of the top frame of the stack.
+func (a *Action) apply(e *Engine, s *stack, d Decision) error {
 	if d.error != nil {
 		return d.error
 	}
 	if d.post != nil {
 		a.post = d.post
 	}
+	if d.expansion != nil {
+		// A memoized outcome would not include the expansion.
+		a.original = memoKey{}
+		return s.expand(e, d.expansion)
+	}
 	if d.replacement != nil {
 		if a.assignableTo == nil {
 			return errors.New("this value cannot be replaced")
 		}
+		if d.inPlace {
+			if a.typeData.TypeID != d.replacementType {
+				return fmt.Errorf("cannot replace %s in place with %s",
+					e.Stringify(a.typeData.TypeID), e.Stringify(d.replacementType))
+			}
+			if !s.mutable() {
+				return fmt.Errorf("cannot replace %s in place, since it is not stored in mutable memory",
+					e.Stringify(a.typeData.TypeID))
+			}
+			a.typeData.Copy(a.value, d.replacement)
+			a.mutated = true
+			return nil
+		}
 		if a.typeData.TypeID != d.replacementType {
 			// The user can only change the type of the object if it's being
 			// assigned to an interface slot. Even then, we'll want to
@@ -231,6 +461,7 @@ func (a *Action) apply(e *Engine, d Decision) error {
 			}
 		}
 		a.dirty = true
+		a.modified = true
 		a.replaced = true
 		a.value = d.replacement
 	}
@@ -39,6 +39,10 @@ walkabout InterfaceName
   Generates support code to make all struct types that implement
   the given interface walkable.
 
+walkabout InterfaceName InterfaceName ...
+  Generates independent support code for each of the named interfaces,
+  into a separate file for each interface.
+
 walkabout --union UnionInterface ( InterfaceName | StructName ) ...
   Generates an interface called "UnionInterface" which will be
   implemented by the named struct types, or those structs that implement
@@ -49,6 +53,10 @@ walkabout --union UnionInterface --reachable ( InterfaceName | StructName ) ...
   transitively reachable from the named types.  This is useful for
   refitting an entire package where the existing types may not all
   share a common interface.
+
+walkabout --union UnionInterface --union-only ( InterfaceName | StructName ) ...
+  Generates only the "UnionInterface" declaration and the marker methods
+  which make the named types implement it. No traversal code is emitted.
 `,
 		Args: cobra.MinimumNArgs(1),
 		RunE: func(cmd *cobra.Command, args []string) error {
@@ -61,9 +69,45 @@ walkabout --union UnionInterface --reachable ( InterfaceName | StructName ) ...
 		},
 	}
 
+	rootCmd.Flags().StringSliceVar(&config.buildFlags, "build-flags", nil,
+		`additional flags to pass to the build system when loading the
+package, e.g. -tags=foo. The generated code will be subject to the
+build constraints of the files which declare the input types.`)
+
 	rootCmd.Flags().StringVarP(&config.dir, "dir", "d", ".",
 		"the directory to operate in")
 
+	rootCmd.Flags().StringVar(&config.engineVar, "engine-var", "",
+		`overrides the name of the package-level variable which holds the
+traversal engine, e.g. to avoid colliding with an existing name.`)
+
+	rootCmd.Flags().StringSliceVar(&config.exclude, "exclude", nil,
+		`the names of types in the package which should not be visited,
+even if they are reachable. Fields of these types are ignored.`)
+
+	rootCmd.Flags().StringVar(&config.goarch, "goarch", "",
+		`load the package as though building for the given architecture.
+The generated code will be constrained to that architecture.`)
+
+	rootCmd.Flags().StringVar(&config.goos, "goos", "",
+		`load the package as though building for the given operating
+system. The generated code will be constrained to that operating
+system.`)
+
+	rootCmd.Flags().StringVar(&config.goVersion, "go", "",
+		`the version of Go that the generated code must be compatible with,
+e.g. 1.21. Defaults to the version of the running toolchain.`)
+
+	rootCmd.Flags().BoolVar(&config.includeUnexported, "include-unexported", false,
+		`also visit the un-exported fields of visitable structs. Since the
+generated code lives in the package which defines the types, it may
+access these fields, but the values within them will then be exposed
+to any caller of the generated functions.`)
+
+	rootCmd.Flags().BoolVar(&config.lazyEngine, "lazy-engine", false,
+		`construct the traversal engine on first use, instead of when the
+package is initialized.`)
+
 	rootCmd.Flags().StringVarP(&config.outFile, "out", "o", "",
 		"overrides the output file name")
 
@@ -71,10 +115,49 @@ walkabout --union UnionInterface --reachable ( InterfaceName | StructName ) ...
 		`make all transitively reachable types in the same package also
 implement the --union interface. Only valid when using --union.`)
 
+	rootCmd.Flags().BoolVar(&config.valueMethods, "value-methods", false,
+		`generate the read-only abstract accessor methods (e.g. count, at,
+and type id) with value receivers, so that they may be called on
+structs which are not addressable.`)
+
+	rootCmd.Flags().BoolVar(&config.split, "split", false,
+		`write each concern of the generated code (e.g. api, typemap)
+into its own file. Not valid when using --out or --suffix.`)
+
+	rootCmd.Flags().StringVar(&config.suffix, "suffix", "",
+		`replaces the "_walkabout.g.go" suffix of the output file name, e.g.
+"_gen.go". A "_test" element will still be inserted for types which are
+declared in test files. Not valid when using --out or --split.`)
+
+	rootCmd.Flags().BoolVar(&config.typemapOnly, "typemap-only", false,
+		`generate only the engine's type map, the type tokens, and exported
+functions to convert between visitable values and engine pointers,
+so that the engine may be driven directly.`)
+
 	rootCmd.Flags().StringVarP(&config.union, "union", "u", "",
 		`generate a new interface with the given name to be used as the
 visitable interface.`)
 
+	rootCmd.Flags().BoolVar(&config.unionOnly, "union-only", false,
+		`generate only the --union interface and its marker methods,
+without any traversal support. Only valid when using --union.`)
+
+	rootCmd.Flags().BoolVar(&config.valueFacades, "value-facades", false,
+		`pass structs which implement the visitable interface with value
+receivers to callbacks by value, instead of by reference. Not valid
+when using --union.`)
+
+	rootCmd.Flags().BoolVar(&config.verifyLayout, "verify-layout", false,
+		`generate an init function which panics if the layout of a visitable
+struct no longer matches the generated type map, e.g. because a field's
+type was changed without re-running the generator. Not valid when using
+--union-only.`)
+
+	rootCmd.Flags().StringVar(&config.visitor, "visitor", "",
+		`the name of an interface with a go/ast-style Visit method, such
+as "Visit(x InterfaceName) Visitor", for which an adapter will be
+generated.`)
+
 	rootCmd.AddCommand(
 		&cobra.Command{
 			Use:   "version",
@@ -16,27 +16,175 @@
 package gen
 
 import (
+	"fmt"
+	"go/build/constraint"
+	"go/parser"
 	"go/token"
 	"go/types"
 	"io"
+	"io/ioutil"
+	"math"
 	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
 
 	"github.com/pkg/errors"
 	"golang.org/x/tools/go/packages"
 )
 
 type config struct {
-	dir string
+	// Additional flags to pass to the build system when loading the
+	// package, e.g. "-tags=foo".
+	buildFlags []string
+	dir        string
+	// If present, overrides the name of the variable which holds the
+	// engine.
+	engineVar string
+	// The names of types which will not be visited, even if they are
+	// reachable. Fields of these types are ignored.
+	exclude []string
+	// If present, the package will be loaded as though it were being
+	// built for the given architecture and the generated code will be
+	// constrained to it.
+	goarch string
+	// If present, the package will be loaded as though it were being
+	// built for the given operating system and the generated code will
+	// be constrained to it.
+	goos string
+	// The version of Go that the generated code must be compatible
+	// with, e.g. "1.21". Defaults to the version of the running
+	// toolchain.
+	goVersion string
+	// If true, the un-exported fields of visitable structs will also be
+	// visited. The generated code must only be used by the package
+	// which defines the types.
+	includeUnexported bool
+	// If true, the engine will be constructed on first use, instead of
+	// when the package is initialized.
+	lazyEngine bool
 	// If present, overrides the output file name.
 	outFile string
 	// Include all types reachable from visitable types that implement
 	// the root visitable interface.
 	reachable bool
+	// If true, each template will be written to its own file.
+	split bool
+	// If present, replaces the "_walkabout.g.go" suffix of the output
+	// file name.
+	suffix string
+	// If true, only the type map and the functions which convert
+	// between visitable values and engine pointers will be generated.
+	// These are exported, so that callers may drive the engine directly.
+	typemapOnly bool
 	// The requested type names.
 	typeNames []string
 	// If present, unifies all specified interfaces under a single
 	// visitable interface with this name.
 	union string
+	// If true, only the union interface and its marker methods will be
+	// generated.
+	unionOnly bool
+	// If true, callbacks will receive structs which implement the
+	// visitable interface with value receivers by value.
+	valueFacades bool
+	// If true, the read-only abstract accessor methods will be
+	// generated with value receivers.
+	valueMethods bool
+	// If true, the generated code will check the layout of each
+	// visitable struct against the type map when the package is
+	// initialized.
+	verifyLayout bool
+	// If present, names an interface with a go/ast-style Visit method,
+	// for which an adapter will be generated.
+	visitor string
+}
+
+// Config allows the code generator to be driven by other tools. The
+// TypeNames field holds the positional arguments, while the remaining
+// fields correspond to the command-line flags of the same name.
+type Config struct {
+	BuildFlags        []string
+	Dir               string
+	EngineVar         string
+	Exclude           []string
+	GOARCH            string
+	GOOS              string
+	GoVersion         string
+	IncludeUnexported bool
+	LazyEngine        bool
+	OutFile           string
+	Reachable         bool
+	Split             bool
+	Suffix            string
+	TypemapOnly       bool
+	TypeNames         []string
+	Union             string
+	UnionOnly         bool
+	ValueFacades      bool
+	ValueMethods      bool
+	VerifyLayout      bool
+	Visitor           string
+
+	// If non-nil, Output will be called to open each generated file,
+	// instead of writing to the filesystem.
+	Output func(name string) (io.WriteCloser, error)
+}
+
+// RunWithOverlay runs the code generator, as though the given
+// overlay sources were present on disk. This allows build tools to
+// generate code for sources which have been synthesized in memory.
+// Relative file names in the overlay are resolved against cfg.Dir.
+func RunWithOverlay(cfg Config, overlay map[string][]byte) error {
+	dir := cfg.Dir
+	if dir == "" {
+		dir = "."
+	}
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return err
+	}
+
+	g, err := newGeneration(config{
+		buildFlags:        cfg.BuildFlags,
+		dir:               dir,
+		engineVar:         cfg.EngineVar,
+		exclude:           cfg.Exclude,
+		goarch:            cfg.GOARCH,
+		goos:              cfg.GOOS,
+		goVersion:         cfg.GoVersion,
+		includeUnexported: cfg.IncludeUnexported,
+		lazyEngine:        cfg.LazyEngine,
+		outFile:           cfg.OutFile,
+		reachable:         cfg.Reachable,
+		split:             cfg.Split,
+		suffix:            cfg.Suffix,
+		typemapOnly:       cfg.TypemapOnly,
+		typeNames:         cfg.TypeNames,
+		union:             cfg.Union,
+		unionOnly:         cfg.UnionOnly,
+		valueFacades:      cfg.ValueFacades,
+		valueMethods:      cfg.ValueMethods,
+		verifyLayout:      cfg.VerifyLayout,
+		visitor:           cfg.Visitor,
+	})
+	if err != nil {
+		return err
+	}
+	if cfg.Output != nil {
+		g.writeCloser = cfg.Output
+	}
+
+	// The package loader requires absolute file names.
+	g.overlay = make(map[string][]byte, len(overlay))
+	for name, src := range overlay {
+		if !filepath.IsAbs(name) {
+			name = filepath.Join(dir, name)
+		}
+		g.overlay[name] = src
+	}
+	return g.Execute()
 }
 
 // generation represents an entire run of the code generator. The
@@ -45,10 +193,19 @@ type config struct {
 type generation struct {
 	config
 
-	// Allows additional files to be added to the parse phase for testing.
-	extraTestSource map[string][]byte
-	fileSet         token.FileSet
-	// Stores the executed visitation for testing.
+	// The accessor methods which were generated for each struct by a
+	// previous visitation in this run, keyed by the name of the struct.
+	accessors map[string]map[string]bool
+	fileSet   token.FileSet
+	// The minor version of Go to generate code for, derived from
+	// config.goVersion.
+	goMinor int
+	// Allows additional files to be added to the parse phase, keyed by
+	// absolute file name.
+	overlay map[string][]byte
+	// Receives non-fatal diagnostic messages.
+	stderr io.Writer
+	// Stores the most recently executed visitation for testing.
 	visitation  *visitation
 	writeCloser func(name string) (io.WriteCloser, error)
 }
@@ -57,13 +214,76 @@ type generation struct {
 // named interface types in the given directory.
 func newGeneration(cfg config) (*generation, error) {
 	if len(cfg.typeNames) > 1 && cfg.union == "" {
-		return nil, errors.New("multiple input types can only be used with --union")
+		// Each interface receives its own API, so any option which
+		// names a single output must be rejected.
+		if cfg.engineVar != "" {
+			return nil, errors.New("--engine-var cannot be used with multiple input types, unless using --union")
+		}
+		if cfg.outFile != "" {
+			return nil, errors.New("--out cannot be used with multiple input types, unless using --union")
+		}
+		if cfg.visitor != "" {
+			return nil, errors.New("--visitor cannot be used with multiple input types, unless using --union")
+		}
 	}
 	if cfg.reachable && cfg.union == "" {
 		return nil, errors.New("--reachable can only be used with --union")
 	}
+	if cfg.unionOnly && cfg.union == "" {
+		return nil, errors.New("--union-only can only be used with --union")
+	}
+	if cfg.valueFacades && cfg.union != "" {
+		return nil, errors.New("--value-facades cannot be used with --union")
+	}
+	if cfg.visitor != "" && cfg.unionOnly {
+		return nil, errors.New("--visitor cannot be used with --union-only")
+	}
+	if cfg.verifyLayout && cfg.unionOnly {
+		return nil, errors.New("--verify-layout cannot be used with --union-only")
+	}
+	if cfg.typemapOnly && cfg.unionOnly {
+		return nil, errors.New("--typemap-only cannot be used with --union-only")
+	}
+	if cfg.typemapOnly && cfg.lazyEngine {
+		return nil, errors.New("--typemap-only cannot be used with --lazy-engine")
+	}
+	if cfg.typemapOnly && cfg.visitor != "" {
+		return nil, errors.New("--typemap-only cannot be used with --visitor")
+	}
+	if cfg.split && cfg.outFile != "" {
+		return nil, errors.New("--split cannot be used with --out")
+	}
+	if cfg.suffix != "" {
+		if cfg.split {
+			return nil, errors.New("--split cannot be used with --suffix")
+		}
+		if cfg.outFile != "" {
+			return nil, errors.New("--suffix cannot be used with --out")
+		}
+		if err := checkSuffix(cfg.suffix); err != nil {
+			return nil, err
+		}
+	}
+	if cfg.engineVar != "" && !token.IsIdentifier(cfg.engineVar) {
+		return nil, errors.Errorf("--engine-var %q is not a valid identifier", cfg.engineVar)
+	}
+	version := cfg.goVersion
+	if version == "" {
+		version = runtime.Version()
+	}
+	goMinor, err := goMinorVersion(version)
+	if err != nil {
+		if cfg.goVersion != "" {
+			return nil, err
+		}
+		// Development toolchains don't report a release number, so we'll
+		// assume that they support everything.
+		goMinor = math.MaxInt32
+	}
 	return &generation{
-		config: cfg,
+		config:  cfg,
+		goMinor: goMinor,
+		stderr:  os.Stderr,
 		writeCloser: func(name string) (io.WriteCloser, error) {
 			if name == "-" {
 				return os.Stdout, nil
@@ -86,12 +306,28 @@ func (g *generation) Execute() error {
 		return err
 	}
 
+	// Without --union, each named interface receives its own API.
+	if g.union == "" && len(g.typeNames) > 1 {
+		for _, name := range g.typeNames {
+			if err := g.executeRoot(pkgs, []string{name}); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return g.executeRoot(pkgs, g.typeNames)
+}
+
+// executeRoot generates the API for a single visitable interface,
+// which is either the named interface or the --union interface.
+func (g *generation) executeRoot(pkgs []*packages.Package, typeNames []string) error {
 	v := &visitation{
 		gen:              g,
 		includeReachable: g.config.reachable,
 		packagePath:      pkgs[0].PkgPath,
 		Types:            make(map[TypeID]visitableType),
 		SourceTypes:      make(map[SourceName]visitableType),
+		typeNames:        typeNames,
 	}
 	g.visitation = v
 
@@ -111,16 +347,167 @@ func (g *generation) Execute() error {
 	if err := v.findSeedTypes(scopes); err != nil {
 		return err
 	}
+	if err := v.findExcludedTypes(scopes); err != nil {
+		return err
+	}
+	if err := v.checkCollisions(scopes); err != nil {
+		return err
+	}
+	if err := v.findVisitor(scopes); err != nil {
+		return err
+	}
+	v.findPriorTypeIDs(scopes)
 	v.populateGeneratedTypes(scopes)
-	return v.generateAPI()
+	if err := v.checkConcreteFields(); err != nil {
+		return err
+	}
+	for _, warning := range v.emptySeedWarnings() {
+		fmt.Fprintf(g.stderr, "warning: %s\n", warning)
+	}
+	for _, warning := range v.recursiveValueWarnings() {
+		fmt.Fprintf(g.stderr, "warning: %s\n", warning)
+	}
+	if err := v.generateAPI(); err != nil {
+		return err
+	}
+	v.claimAccessors()
+	return nil
+}
+
+// goMinorVersion extracts the minor version number from a Go version
+// string such as "1.21", "go1.21", or "go1.21.3".
+func goMinorVersion(version string) (int, error) {
+	parts := strings.Split(strings.TrimPrefix(version, "go"), ".")
+	if len(parts) < 2 || parts[0] != "1" {
+		return 0, errors.Errorf("unsupported Go version %q", version)
+	}
+	// Trim any pre-release suffix, e.g. 1.22rc1.
+	minor := parts[1]
+	if idx := strings.IndexFunc(minor, func(r rune) bool { return r < '0' || r > '9' }); idx >= 0 {
+		minor = minor[:idx]
+	}
+	ret, err := strconv.Atoi(minor)
+	if err != nil {
+		return 0, errors.Errorf("unsupported Go version %q", version)
+	}
+	return ret, nil
+}
+
+// checkSuffix ensures that the value of --suffix will produce the name
+// of a non-test Go source file when appended to a type name. The
+// "_test" element is inserted automatically for types which are
+// declared in test files. The suffix must have a stem before its first
+// dot, otherwise the output for a type Foo could be foo.go, which may
+// well be a hand-written file.
+func checkSuffix(suffix string) error {
+	if !strings.HasSuffix(suffix, ".go") {
+		return errors.Errorf("--suffix %q must end with .go", suffix)
+	}
+	if strings.IndexByte(suffix, '.') == 0 {
+		return errors.Errorf("--suffix %q must have at least one character before the first .", suffix)
+	}
+	if strings.HasSuffix(suffix, "_test.go") {
+		return errors.Errorf("--suffix %q must not end with _test.go", suffix)
+	}
+	for _, r := range suffix {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+		case r == '_', r == '-', r == '.':
+		default:
+			return errors.Errorf("--suffix %q contains an invalid character %q", suffix, r)
+		}
+	}
+	return nil
 }
 
 func (g *generation) packageConfig() *packages.Config {
-	return &packages.Config{
-		Dir:     g.dir,
-		Fset:    &g.fileSet,
-		Mode:    packages.LoadTypes,
-		Overlay: g.extraTestSource,
-		Tests:   true,
+	ret := &packages.Config{
+		BuildFlags: g.buildFlags,
+		Dir:        g.dir,
+		Fset:       &g.fileSet,
+		Mode:       packages.LoadTypes,
+		Overlay:    g.overlay,
+		Tests:      true,
+	}
+	// A nil Env would inherit the current environment, so we only need
+	// to provide one if we're overriding the target platform.
+	if g.goos != "" || g.goarch != "" {
+		ret.Env = os.Environ()
+		if g.goos != "" {
+			ret.Env = append(ret.Env, "GOOS="+g.goos)
+		}
+		if g.goarch != "" {
+			ret.Env = append(ret.Env, "GOARCH="+g.goarch)
+		}
+	}
+	return ret
+}
+
+// platformConstraint returns an expression which restricts the
+// generated code to the configured platform. It returns nil if no
+// platform was specified.
+func (g *generation) platformConstraint() constraint.Expr {
+	var ret constraint.Expr
+	for _, tag := range []string{g.goos, g.goarch} {
+		if tag != "" {
+			ret = andConstraints(ret, &constraint.TagExpr{Tag: tag})
+		}
+	}
+	return ret
+}
+
+// fileConstraint returns the build constraint declared by the named
+// source file, or nil if the file is unconstrained. A //go:build line
+// takes precedence over any // +build lines, as it does for the go tool.
+func (g *generation) fileConstraint(filename string) (constraint.Expr, error) {
+	src, ok := g.overlay[filename]
+	if !ok {
+		var err error
+		if src, err = ioutil.ReadFile(filename); err != nil {
+			return nil, err
+		}
+	}
+	file, err := parser.ParseFile(token.NewFileSet(), filename, src, parser.PackageClauseOnly|parser.ParseComments)
+	if err != nil {
+		return nil, err
+	}
+
+	var goBuild, plusBuild constraint.Expr
+	for _, group := range file.Comments {
+		// Constraints must appear before the package clause.
+		if group.Pos() > file.Package {
+			break
+		}
+		for _, comment := range group.List {
+			if !constraint.IsGoBuild(comment.Text) && !constraint.IsPlusBuild(comment.Text) {
+				continue
+			}
+			expr, err := constraint.Parse(comment.Text)
+			if err != nil {
+				return nil, errors.Wrapf(err, "%s: invalid build constraint", filename)
+			}
+			if constraint.IsGoBuild(comment.Text) {
+				goBuild = expr
+			} else {
+				plusBuild = andConstraints(plusBuild, expr)
+			}
+		}
+	}
+	if goBuild != nil {
+		return goBuild, nil
+	}
+	return plusBuild, nil
+}
+
+// andConstraints returns the conjunction of the two expressions,
+// either of which may be nil.
+func andConstraints(x, y constraint.Expr) constraint.Expr {
+	switch {
+	case x == nil:
+		return y
+	case y == nil:
+		return x
+	default:
+		return &constraint.AndExpr{X: x, Y: y}
 	}
 }
@@ -17,8 +17,13 @@ package gen
 
 import (
 	"bytes"
+	"fmt"
 	"io"
+	"io/ioutil"
+	"os"
 	"path/filepath"
+	"sort"
+	"strings"
 	"sync"
 	"testing"
 
@@ -31,11 +36,64 @@ var configs = map[string]config{
 		dir:       "../demo",
 		typeNames: []string{"Target"},
 	},
+	"split": {
+		dir:       "../demo",
+		typeNames: []string{"Target"},
+		split:     true,
+	},
 	"union": {
 		dir:       "../demo",
 		typeNames: []string{"Target", "Unionable"},
 		union:     "Union",
 	},
+	"unionOnly": {
+		dir:       "../demo",
+		typeNames: []string{"Target", "Unionable"},
+		union:     "Union",
+		unionOnly: true,
+	},
+	"typemapOnly": {
+		dir:         "../demo",
+		typeNames:   []string{"Target", "Unionable"},
+		union:       "Union",
+		typemapOnly: true,
+	},
+	"valueFacades": {
+		dir:          "../demo",
+		typeNames:    []string{"Target"},
+		valueFacades: true,
+	},
+	"lazyEngine": {
+		dir:        "../demo",
+		typeNames:  []string{"Target"},
+		lazyEngine: true,
+	},
+	"engineVar": {
+		dir:        "../demo",
+		typeNames:  []string{"Target"},
+		engineVar:  "demoEngine",
+		lazyEngine: true,
+	},
+	"valueMethods": {
+		dir:          "../demo",
+		typeNames:    []string{"Target"},
+		valueMethods: true,
+	},
+	"legacyGo": {
+		dir:       "../demo",
+		typeNames: []string{"Target"},
+		goVersion: "1.19",
+	},
+	"modernGo": {
+		dir:       "../demo",
+		typeNames: []string{"Target"},
+		goVersion: "1.25",
+	},
+	"verifyLayout": {
+		dir:          "../demo",
+		typeNames:    []string{"Target"},
+		verifyLayout: true,
+	},
 	"unionReachable": {
 		dir:       "../demo",
 		typeNames: []string{"Target", "Unionable"},
@@ -93,43 +151,163 @@ func TestExampleData(t *testing.T) {
 
 			switch name {
 			case "single":
-				a.Len(v.Types, 16)
+				a.Len(v.Types, 33)
 				v.checkStructInfo(a, "ContainerType", "ByRef", "ByRefPtr", "ByRefSlice", "ByRefPtrSlice",
 					"ByVal", "ByValPtr", "ByValSlice", "ByValPtrSlice", "Container", "AnotherTarget",
 					"AnotherTargetPtr", "EmbedsTarget", "EmbedsTargetPtr", "TargetSlice",
-					"InterfacePtrSlice", "NamedTargets")
+					"InterfacePtrSlice", "NamedTargets", "Quad", "OptTarget", "Annotated")
+				v.checkStructInfo(a, "ScopeType", "Env")
+				// Arrays of struct values are given their own type ids.
+				v.checkTypes(a, "ByRefTypeArray2")
+
+			case "split":
+				a.Len(v.Types, 33)
+				// Expect one file per template, except for the header, the
+				// union support, which is empty in non-union mode, and the
+				// visitor adapter, typemap-only helpers, and layout checks,
+				// which haven't been requested.
+				var expected []string
+				for key := range allTemplates {
+					switch key {
+					case headerTemplate, "10typemaponly", "50union", "60visitor", "76layout":
+					default:
+						expected = append(expected, "target_"+strings.TrimLeft(key, "0123456789")+".g.go")
+					}
+				}
+				sort.Strings(expected)
+				names := make([]string, 0, len(outputs))
+				for k := range outputs {
+					names = append(names, filepath.Base(k))
+				}
+				sort.Strings(names)
+				a.Equal(expected, names)
+
+				// Hide the checked-in, non-split file from the type-checker.
+				existing, err := filepath.Abs(filepath.Join(cfg.dir, "target_walkabout.g.go"))
+				if a.NoError(err) {
+					outputs[existing] = []byte("package demo\n")
+				}
+
+			case "valueFacades":
+				a.Len(v.Types, 33)
+				for _, out := range outputs {
+					a.Contains(string(out), "(TargetContext{impl}, *(*ByValType)(x))")
+					a.Contains(string(out), "(TargetContext{impl}, (*ByRefType)(x))")
+					// Replacements are stored in interfaces in the same form.
+					a.Contains(string(out), "case TargetTypeByValType:\n\t\t\t\td = *(*ByValType)(x)")
+					a.Contains(string(out), "case TargetTypeByRefType:\n\t\t\t\td = (*ByRefType)(x)")
+					a.Contains(string(out), `return targetTransformed(ctx, next, next == nil, next == &cp && EqualTarget(x, cp), "ByValType")`)
+					a.Contains(string(out), `return targetTransformed(ctx, next, next == nil, next == x, "ByRefType")`)
+				}
+
+			case "lazyEngine":
+				a.Len(v.Types, 33)
+				for _, out := range outputs {
+					a.Contains(string(out), "func getTargetEngine() *e.Engine {")
+					a.Contains(string(out), "getTargetEngine().Execute(")
+					a.NotContains(string(out), "var targetEngine = ")
+				}
+
+			case "engineVar":
+				a.Len(v.Types, 33)
+				for _, out := range outputs {
+					a.Contains(string(out), "func getDemoEngine() *e.Engine {")
+					a.Contains(string(out), "demoEngineOnce.Do(")
+					a.NotContains(string(out), "targetEngine")
+				}
+
+			case "valueMethods":
+				a.Len(v.Types, 33)
+				for _, out := range outputs {
+					a.Contains(string(out), "func (x ContainerType) TargetAt(index int) TargetAbstract")
+					a.Contains(string(out), "func (ContainerType) TargetTypeID() TargetTypeID")
+					a.Contains(string(out), "func (x *ContainerType) WalkTarget(fn TargetWalkerFn)")
+				}
+
+			case "legacyGo":
+				for _, out := range outputs {
+					a.Contains(string(out), "ctx, cancel := context.WithCancel(context.Background())")
+					a.Contains(string(out), "errOnce.Do(func() {")
+					a.Contains(string(out), "wg.Add(workers)")
+					a.NotContains(string(out), "wg.Go(")
+				}
+
+			case "modernGo":
+				for _, out := range outputs {
+					a.Contains(string(out), "ctx, cancel := context.WithCancelCause(context.Background())")
+					a.Contains(string(out), "return context.Cause(ctx)")
+					a.Contains(string(out), "wg.Go(func() {")
+					a.NotContains(string(out), "errOnce")
+				}
+
+			case "verifyLayout":
+				a.Len(v.Types, 33)
+				for _, out := range outputs {
+					a.Contains(string(out), "func targetVerifyLayout(")
+					a.Contains(string(out), `targetVerifyLayout(ContainerType{}, "ContainerType", "ByRefPtr", `+
+						`reflect.TypeOf((**ByRefType)(nil)).Elem())`)
+					a.Contains(string(out), `targetVerifyLayout(EmbeddingType{}, "EmbeddingType", "Scoped", `+
+						`reflect.TypeOf((*Target)(nil)).Elem())`)
+				}
 
 			case "unionReachable":
-				a.Len(v.Types, 22)
+				a.Len(v.Types, 41)
 				v.checkStructInfo(a, "ContainerType", "ByRef", "ByRefPtr", "ByRefSlice", "ByRefPtrSlice",
 					"ByVal", "ByValPtr", "ByValSlice", "ByValPtrSlice", "Container", "AnotherTarget",
 					"AnotherTargetPtr", "EmbedsTarget", "EmbedsTargetPtr", "TargetSlice",
-					"InterfacePtrSlice", "NamedTargets", "UnionableType", "ReachableType")
+					"InterfacePtrSlice", "NamedTargets", "Quad", "OptTarget", "Annotated", "UnionableType", "ReachableType")
 				v.checkStructInfo(a, "ReachableType")
 				a.Equal(cfg.union, v.Root.Union)
 
 			case "union":
-				a.Len(v.Types, 20)
+				a.Len(v.Types, 37)
 				v.checkStructInfo(a, "ContainerType", "ByRef", "ByRefPtr", "ByRefSlice", "ByRefPtrSlice",
 					"ByVal", "ByValPtr", "ByValSlice", "ByValPtrSlice", "Container", "AnotherTarget",
 					"AnotherTargetPtr", "EmbedsTarget", "EmbedsTargetPtr", "TargetSlice", "InterfacePtrSlice",
-					"NamedTargets", "UnionableType")
+					"NamedTargets", "Quad", "OptTarget", "Annotated", "UnionableType")
+				v.checkStructInfo(a, "UnionableType")
+				a.Equal(cfg.union, v.Root.Union)
+
+			case "unionOnly":
+				// Type tokens for slices and pointers are only created by the
+				// templates that aren't executed.
+				a.Len(v.Types, 14)
 				v.checkStructInfo(a, "UnionableType")
 				a.Equal(cfg.union, v.Root.Union)
+				for _, out := range outputs {
+					a.NotContains(string(out), "UnionAbstract")
+					a.NotContains(string(out), "engine")
+				}
+
+			case "typemapOnly":
+				// Some type tokens are only created by the templates that
+				// aren't executed.
+				a.Len(v.Types, 36)
+				a.Equal(cfg.union, v.Root.Union)
+				for _, out := range outputs {
+					a.Contains(string(out), "var UnionEngine = e.New(")
+					a.Contains(string(out), "func UnionIdentify(x Union) (typeId e.TypeID, data e.Ptr)")
+					a.Contains(string(out), "func UnionWrap(typeId e.TypeID, x e.Ptr) Union")
+					a.Contains(string(out), "fn.(UnionWalkerFn)(impl, (*ByRefType)(x))")
+					a.NotContains(string(out), "UnionAbstract")
+					a.NotContains(string(out), "UnionContext")
+				}
 
 			case "structUnion":
 				a.Len(v.Types, 11)
 				v.checkStructInfo(a, "ContainerType", "ByRef", "ByRefPtr", "ByRefSlice", "ByRefPtrSlice",
-					"ByVal", "ByValPtr", "ByValSlice", "ByValPtrSlice", "Container")
+					"ByVal", "ByValPtr", "ByValSlice", "ByValPtrSlice", "Container", "OptTarget")
 				a.Equal(cfg.union, v.Root.Union)
+				a.Len(v.emptySeedWarnings(), 1)
+				a.Contains(v.emptySeedWarnings()[0], "ByValType")
 				expectTarget = false
 
 			case "structUnionReachable":
-				a.Len(v.Types, 21)
+				a.Len(v.Types, 40)
 				v.checkStructInfo(a, "ContainerType", "ByRef", "ByRefPtr", "ByRefSlice", "ByRefPtrSlice",
 					"ByVal", "ByValPtr", "ByValSlice", "ByValPtrSlice", "Container", "AnotherTarget",
 					"AnotherTargetPtr", "EmbedsTarget", "EmbedsTargetPtr", "TargetSlice",
-					"InterfacePtrSlice", "NamedTargets", "UnionableType", "ReachableType")
+					"InterfacePtrSlice", "NamedTargets", "Quad", "OptTarget", "Annotated", "UnionableType", "ReachableType")
 				v.checkStructInfo(a, "ReachableType")
 				a.Equal(cfg.union, v.Root.Union)
 				expectTarget = false
@@ -137,12 +315,43 @@ func TestExampleData(t *testing.T) {
 			default:
 				a.Fail("unknown test configuration", name)
 			}
+			if name != "structUnion" {
+				a.Empty(v.emptySeedWarnings())
+			}
+			a.Empty(v.recursiveValueWarnings())
 			v.checkStructInfo(a, "ByValType")
 			v.checkStructInfo(a, "ByRefType")
 
 			if expectTarget {
+				v.checkTypes(a, "WrapperType")
+				v.checkStructInfo(a, "WrapperType", "Target")
+				// The unexported field is only visited through its getter.
+				v.checkTypes(a, "EncapsulatedType")
+				v.checkStructInfo(a, "EncapsulatedType")
+				if s, ok := v.SourceTypes["EncapsulatedType"].(namedStruct); a.True(ok) {
+					getters, err := s.Getters()
+					if a.NoError(err) && a.Len(getters, 1) {
+						a.Equal("Children", getters[0].Name)
+					}
+				}
+				v.checkTypes(a, "PairType")
+				v.checkStructInfo(a, "PairType", "Pair")
+				// The loosely-typed field is visited because of its tag.
+				v.checkTypes(a, "LooseType")
+				v.checkStructInfo(a, "LooseType", "Child")
 				v.checkVisitableInterface(a, "Target")
 				v.checkVisitableInterface(a, "EmbedsTarget")
+				v.checkVisitableInterface(a, "Annotated")
+				// The embedded struct is only visitable in its own right
+				// when reachable types are included.
+				v.checkTypes(a, "EmbeddingType")
+				if cfg.reachable {
+					v.checkTypes(a, "EmbeddedFields")
+					v.checkStructInfo(a, "EmbeddingType", "EmbeddedFields", "Scoped", "Own")
+				} else {
+					v.checkNoTypes(a, "EmbeddedFields")
+					v.checkStructInfo(a, "EmbeddingType", "Promoted", "Promoteds", "Scoped", "Own")
+				}
 			}
 
 			cfg := g.packageConfig()
@@ -186,6 +395,176 @@ func TestOutputIsStable(t *testing.T) {
 	}
 }
 
+func TestGoVersion(t *testing.T) {
+	tcs := map[string]int{
+		"1.11":      11,
+		"go1.21":    21,
+		"go1.21.3":  21,
+		"go1.22rc1": 22,
+		"2.0":       -1,
+		"1":         -1,
+		"devel":     -1,
+	}
+	for version, expected := range tcs {
+		t.Run(version, func(t *testing.T) {
+			a := assert.New(t)
+			minor, err := goMinorVersion(version)
+			if expected < 0 {
+				a.Error(err)
+			} else if a.NoError(err) {
+				a.Equal(expected, minor)
+			}
+		})
+	}
+
+	t.Run("flag", func(t *testing.T) {
+		a := assert.New(t)
+		g, err := newGeneration(config{goVersion: "1.18", typeNames: []string{"Target"}})
+		if a.NoError(err) {
+			v := &visitation{gen: g}
+			a.True(funcMap["GoAtLeast"].(func(*visitation, int) bool)(v, 18))
+			a.False(funcMap["GoAtLeast"].(func(*visitation, int) bool)(v, 19))
+		}
+		_, err = newGeneration(config{goVersion: "bogus", typeNames: []string{"Target"}})
+		a.Error(err)
+	})
+
+	t.Run("default", func(t *testing.T) {
+		a := assert.New(t)
+		g, err := newGeneration(config{typeNames: []string{"Target"}})
+		if a.NoError(err) {
+			a.True(g.goMinor >= 11)
+		}
+	})
+}
+
+// aliasSource is overlaid into the demo package to verify that types
+// which refer to the visitable interface through an alias are detected.
+const aliasSource = `package demo
+
+// Node is an alias of the visitable interface.
+type Node = Target
+
+// Label is a named string type.
+type Label string
+
+// AliasedType implements Target through an embedded alias.
+type AliasedType struct {
+	Node
+	Nodes []Node
+	Label Label
+}
+`
+
+func TestAliases(t *testing.T) {
+	for _, typeName := range []string{"Target", "Node"} {
+		t.Run(typeName, func(t *testing.T) {
+			a := assert.New(t)
+			dir, err := filepath.Abs("../demo")
+			if !a.NoError(err) {
+				return
+			}
+
+			outputs := make(map[string][]byte)
+			g, err := newGenerationForTesting(config{dir: dir, typeNames: []string{typeName}}, outputs)
+			if !a.NoError(err) {
+				return
+			}
+			g.overlay = map[string][]byte{
+				filepath.Join(dir, "alias.go"): []byte(aliasSource),
+			}
+			if !a.NoError(g.Execute()) {
+				return
+			}
+
+			v := g.visitation
+			a.Equal("Target", v.Root.String())
+			v.checkStructInfo(a, "AliasedType", "Node", "Nodes")
+			for _, out := range outputs {
+				a.Contains(string(out), "TargetTypeAliasedType")
+				a.Contains(string(out), "Label(fn(string(t.Label)))")
+			}
+		})
+	}
+}
+
+// genericSource is overlaid into the demo package to verify that
+// instantiations of generic structs are visitable.
+const genericSource = `package demo
+
+// Optional is a generic struct which implements Target.
+type Optional[T any] struct {
+	Val T
+}
+
+func (*Optional[T]) Value() string { return "Optional" }
+
+// Pair has more than one type parameter.
+type Pair[A, B any] struct {
+	First  A
+	Second B
+}
+
+func (*Pair[A, B]) Value() string { return "Pair" }
+
+// GenericType contains instantiations of the generic types.
+type GenericType struct {
+	Ref  Optional[*ByRefType]
+	Refs *Optional[[]ByRefType]
+	Name Optional[string]
+	Both Pair[ByValType, Target]
+}
+
+func (*GenericType) Value() string { return "Generic" }
+`
+
+func TestGenerics(t *testing.T) {
+	a := assert.New(t)
+	dir, err := filepath.Abs("../demo")
+	if !a.NoError(err) {
+		return
+	}
+
+	outputs := make(map[string][]byte)
+	g, err := newGenerationForTesting(config{dir: dir, typeNames: []string{"Target"}}, outputs)
+	if !a.NoError(err) {
+		return
+	}
+	genericFile := filepath.Join(dir, "generic.go")
+	g.overlay = map[string][]byte{genericFile: []byte(genericSource)}
+	if !a.NoError(g.Execute()) {
+		return
+	}
+
+	v := g.visitation
+	v.checkStructInfo(a, "GenericType", "Ref", "Refs", "Name", "Both")
+	v.checkStructInfo(a, "Optional[*ByRefType]", "Val")
+	v.checkStructInfo(a, "Optional[[]ByRefType]", "Val")
+	v.checkStructInfo(a, "Optional[string]")
+	v.checkStructInfo(a, "Pair[ByValType, Target]", "First", "Second")
+	a.NotContains(v.SourceTypes, SourceName("Optional"))
+
+	for _, out := range outputs {
+		a.Contains(string(out), "TargetTypeOptionalOfByRefTypePtr")
+		a.Contains(string(out), "TargetTypePairOfByValTypeAndTarget")
+		a.Contains(string(out), "func (x *Optional[T]) TargetCount() int")
+		a.Contains(string(out), "case *Optional[[]ByRefType]:")
+	}
+
+	// Ensure that the generated code type-checks.
+	cfg := g.packageConfig()
+	cfg.Mode = packages.LoadAllSyntax
+	cfg.Overlay = outputs
+	outputs[genericFile] = []byte(genericSource)
+
+	pkgs, err := packages.Load(cfg, ".")
+	if a.NoError(err) {
+		for _, pkg := range pkgs {
+			a.Nil(pkg.Errors)
+		}
+	}
+}
+
 func (v *visitation) checkVisitableInterface(a *assert.Assertions, name SourceName) {
 	found := v.SourceTypes[name]
 	if a.NotNilf(found, "%s", name) {
@@ -193,6 +572,26 @@ func (v *visitation) checkVisitableInterface(a *assert.Assertions, name SourceNa
 	}
 }
 
+// checkTypes verifies that type ids were assigned to the named types,
+// which are given without the root's prefix, e.g. "ByValTypePtr".
+func (v *visitation) checkTypes(a *assert.Assertions, names ...string) {
+	for _, name := range names {
+		id := TypeID(fmt.Sprintf("%sType%s", v.Root, name))
+		_, ok := v.Types[id]
+		a.Truef(ok, "did not find %s", id)
+	}
+}
+
+// checkNoTypes verifies that no type ids were assigned to the named
+// types.
+func (v *visitation) checkNoTypes(a *assert.Assertions, names ...string) {
+	for _, name := range names {
+		id := TypeID(fmt.Sprintf("%sType%s", v.Root, name))
+		_, ok := v.Types[id]
+		a.Falsef(ok, "unexpected %s", id)
+	}
+}
+
 func (v *visitation) checkStructInfo(a *assert.Assertions, name SourceName, hasFields ...string) {
 	t, ok := v.SourceTypes[name]
 	if !a.Truef(ok, "did not find %s", name) || !a.IsTypef(namedStruct{}, t, "%s not a struct", name) {
@@ -212,6 +611,1084 @@ func (v *visitation) checkStructInfo(a *assert.Assertions, name SourceName, hasF
 	}
 }
 
+// taggedSource is overlaid into the demo package to verify that
+// invalid invariant tags are rejected.
+const taggedSource = `package demo
+
+type TaggedType struct {
+	%s
+}
+
+func (*TaggedType) Value() string { return "" }
+`
+
+func TestInvariantTags(t *testing.T) {
+	tcs := []struct {
+		field    string
+		expected string
+	}{
+		{"Next Target `walkabout:\"positive\"`", `TaggedType.Next: unsupported invariant "positive"`},
+		{"Next Target `walkabout:\"nonempty\"`", `TaggedType.Next: unsupported invariant "nonempty"`},
+		{"Nexts []Target `walkabout:\"nonnil\"`", `TaggedType.Nexts: unsupported invariant "nonnil"`},
+		{"Nexts []Target `walkabout:\"nonempty,nonnil\"`", `TaggedType.Nexts: unsupported invariant "nonnil"`},
+	}
+	for _, tc := range tcs {
+		t.Run(tc.field, func(t *testing.T) {
+			a := assert.New(t)
+			dir, err := filepath.Abs("../demo")
+			if !a.NoError(err) {
+				return
+			}
+
+			g, err := newGenerationForTesting(config{dir: dir, typeNames: []string{"Target"}}, make(map[string][]byte))
+			if !a.NoError(err) {
+				return
+			}
+			g.overlay = map[string][]byte{
+				filepath.Join(dir, "tagged.go"): []byte(fmt.Sprintf(taggedSource, tc.field)),
+			}
+			if err := g.Execute(); a.Error(err) {
+				a.Contains(err.Error(), tc.expected)
+			}
+		})
+	}
+}
+
+// getterSource is overlaid into the demo package with a getter method.
+const getterSource = `package demo
+
+type GetterType struct {
+	next Target ` + "`walkabout:\"getter=%s\"`" + `
+}
+
+func (*GetterType) Value() string { return "" }
+
+func (x *GetterType) %s { return x.next }
+`
+
+func TestGetterTags(t *testing.T) {
+	tcs := []struct {
+		getter   string
+		method   string
+		expected string
+	}{
+		{"Next", "Next() Target", ""},
+		{"Missing", "Next() Target", `GetterType.next: no exported method named "Missing"`},
+		{"next", "next() Target", `GetterType.next: no exported method named "next"`},
+		{"Next", "Next(int) Target", `GetterType.Next: must accept no arguments and return a single value`},
+		{"Next", "Next() interface{}", `GetterType.Next: does not return a visitable type`},
+	}
+	for _, tc := range tcs {
+		t.Run(tc.method, func(t *testing.T) {
+			a := assert.New(t)
+			dir, err := filepath.Abs("../demo")
+			if !a.NoError(err) {
+				return
+			}
+
+			outputs := make(map[string][]byte)
+			g, err := newGenerationForTesting(config{dir: dir, typeNames: []string{"Target"}}, outputs)
+			if !a.NoError(err) {
+				return
+			}
+			g.overlay = map[string][]byte{
+				filepath.Join(dir, "getter.go"): []byte(fmt.Sprintf(getterSource, tc.getter, tc.method)),
+			}
+			err = g.Execute()
+			if tc.expected != "" {
+				if a.Error(err) {
+					a.Contains(err.Error(), tc.expected)
+				}
+				return
+			}
+			if a.NoError(err) {
+				for _, out := range outputs {
+					a.Contains(string(out), "ret := (*GetterType)(x).Next()")
+				}
+			}
+		})
+	}
+}
+
+func TestConcreteTags(t *testing.T) {
+	tcs := []struct {
+		field    string
+		expected string
+	}{
+		{"Any interface{} `walkabout:\"nonnil,concrete=ByRefType,ByValType\"`", ""},
+		{"Any interface{} `walkabout:\"concrete=Missing\"`", `TaggedType.Any: "Missing" is not a visitable struct`},
+		{"Any interface{} `walkabout:\"concrete=UnionableType\"`", `TaggedType.Any: "UnionableType" is not a visitable struct`},
+		{"Any interface{ Value() string } `walkabout:\"concrete=ByRefType\"`",
+			`TaggedType.Any: concrete types may only be declared on an empty interface or an interface from the same package`},
+		{"Any Unionable `walkabout:\"concrete=ByRefType\"`", `TaggedType.Any: ByRefType does not implement Unionable`},
+		{"Next Target `walkabout:\"concrete=ByRefType\"`", `TaggedType.Next: concrete types cannot be declared on a visitable field`},
+	}
+	for _, tc := range tcs {
+		t.Run(tc.field, func(t *testing.T) {
+			a := assert.New(t)
+			dir, err := filepath.Abs("../demo")
+			if !a.NoError(err) {
+				return
+			}
+
+			outputs := make(map[string][]byte)
+			g, err := newGenerationForTesting(config{dir: dir, typeNames: []string{"Target"}}, outputs)
+			if !a.NoError(err) {
+				return
+			}
+			g.overlay = map[string][]byte{
+				filepath.Join(dir, "tagged.go"): []byte(fmt.Sprintf(taggedSource, tc.field)),
+			}
+			err = g.Execute()
+			if tc.expected != "" {
+				if a.Error(err) {
+					a.Contains(err.Error(), tc.expected)
+				}
+				return
+			}
+			if !a.NoError(err) {
+				return
+			}
+			g.visitation.checkStructInfo(a, "TaggedType", "Any")
+
+			// The generated code must compile.
+			for name, src := range g.overlay {
+				outputs[name] = src
+			}
+			pkgCfg := g.packageConfig()
+			pkgCfg.Mode = packages.LoadAllSyntax
+			pkgCfg.Overlay = outputs
+			pkgs, err := packages.Load(pkgCfg, ".")
+			if a.NoError(err) {
+				for _, pkg := range pkgs {
+					a.Nil(pkg.Errors)
+				}
+			}
+		})
+	}
+}
+
+// overlaidSource is overlaid into the demo package to verify that
+// RunWithOverlay can generate code for sources which are not on disk.
+const overlaidSource = `package demo
+
+// Overlaid is a visitable interface which exists only in memory.
+type Overlaid interface {
+	isOverlaid()
+}
+
+// OverlaidType implements Overlaid.
+type OverlaidType struct {
+	Next Overlaid
+}
+
+func (*OverlaidType) isOverlaid() {}
+`
+
+func TestRunWithOverlay(t *testing.T) {
+	a := assert.New(t)
+	var mu sync.Mutex
+	outputs := make(map[string][]byte)
+
+	err := RunWithOverlay(Config{
+		Dir:       "../demo",
+		TypeNames: []string{"Overlaid"},
+		Output: func(name string) (io.WriteCloser, error) {
+			return newMapWriter(name, &mu, outputs), nil
+		},
+	}, map[string][]byte{
+		"overlaid.go": []byte(overlaidSource),
+	})
+	if !a.NoError(err) {
+		return
+	}
+
+	dir, err := filepath.Abs("../demo")
+	if !a.NoError(err) {
+		return
+	}
+	out, ok := outputs[filepath.Join(dir, "overlaid_walkabout.g.go")]
+	if a.True(ok, "missing output: %v", outputs) {
+		a.Contains(string(out), "OverlaidTypeOverlaidType")
+	}
+
+	// Configuration errors should be reported.
+	a.Error(RunWithOverlay(Config{OutFile: "out.go", TypeNames: []string{"A", "B"}}, nil))
+}
+
+// multiRootSource declares two interfaces which share an implementation.
+const multiRootSource = `package demo
+
+type Left interface {
+	isLeft()
+}
+
+type Right interface {
+	isRight()
+}
+
+type Both struct {
+	L    Left
+	R    Right
+	Next *Both
+}
+
+func (*Both) isLeft()  {}
+func (*Both) isRight() {}
+`
+
+func TestMultipleRoots(t *testing.T) {
+	a := assert.New(t)
+	var mu sync.Mutex
+	outputs := make(map[string][]byte)
+
+	err := RunWithOverlay(Config{
+		Dir:       "../demo",
+		TypeNames: []string{"Left", "Right"},
+		Output: func(name string) (io.WriteCloser, error) {
+			return newMapWriter(name, &mu, outputs), nil
+		},
+	}, map[string][]byte{
+		"multiroot.go": []byte(multiRootSource),
+	})
+	if !a.NoError(err) {
+		return
+	}
+
+	dir, err := filepath.Abs("../demo")
+	if !a.NoError(err) {
+		return
+	}
+	a.Len(outputs, 2)
+	if out, ok := outputs[filepath.Join(dir, "left_walkabout.g.go")]; a.True(ok) {
+		a.Contains(string(out), "func WalkLeft(")
+		a.Contains(string(out), "func (x *Both) NextField() *Both { return x.Next }")
+	}
+	if out, ok := outputs[filepath.Join(dir, "right_walkabout.g.go")]; a.True(ok) {
+		a.Contains(string(out), "func WalkRight(")
+		a.Contains(string(out), "func (x *Both) NextField2() *Both { return x.Next }")
+		a.Contains(string(out), "func (x *Both) WithNext2(v *Both) *Both {")
+	}
+
+	// Options which name a single output require --union.
+	for _, cfg := range []Config{
+		{EngineVar: "engine"},
+		{OutFile: "out.go"},
+		{Visitor: "Visitor"},
+	} {
+		cfg.TypeNames = []string{"Left", "Right"}
+		a.Error(RunWithOverlay(cfg, nil))
+	}
+}
+
+// accessorSource declares fields whose accessors would collide with
+// other members of the struct.
+const accessorSource = `package demo
+
+type AccessorType struct {
+	Next      Overlaid
+	NextField Overlaid
+	Other     *OverlaidType
+	Pair      [2]OverlaidType
+}
+
+func (*AccessorType) isOverlaid() {}
+
+func (*AccessorType) OtherField() {}
+
+func (*AccessorType) WithPair() {}
+`
+
+func TestFieldAccessors(t *testing.T) {
+	a := assert.New(t)
+	var mu sync.Mutex
+	outputs := make(map[string][]byte)
+
+	err := RunWithOverlay(Config{
+		Dir:       "../demo",
+		TypeNames: []string{"Overlaid"},
+		Output: func(name string) (io.WriteCloser, error) {
+			return newMapWriter(name, &mu, outputs), nil
+		},
+	}, map[string][]byte{
+		"overlaid.go": []byte(overlaidSource),
+		"accessor.go": []byte(accessorSource),
+	})
+	if !a.NoError(err) {
+		return
+	}
+
+	a.Len(outputs, 1)
+	for _, out := range outputs {
+		a.Contains(string(out), "func (x *OverlaidType) NextField() Overlaid { return x.Next }")
+		a.Contains(string(out), "func (x *AccessorType) NextField2() Overlaid { return x.Next }")
+		a.Contains(string(out), "func (x *AccessorType) NextFieldField() Overlaid { return x.NextField }")
+		a.Contains(string(out), "func (x *AccessorType) OtherField2() *OverlaidType { return x.Other }")
+		a.Contains(string(out), "func (x *AccessorType) PairField() *[2]OverlaidType { return &x.Pair }")
+		a.Contains(string(out), "func (x *AccessorType) WithNext(v Overlaid) *AccessorType {")
+		a.Contains(string(out), "func (x *AccessorType) WithPair2(v [2]OverlaidType) *AccessorType {")
+	}
+}
+
+// mapSource declares map-valued fields for the Overlaid interface in
+// overlaidSource.
+const mapSource = `package demo
+
+type Name string
+
+type pair struct{ A, B int }
+
+type Overlaids map[int]Overlaid
+
+type MapType struct {
+	ByFlag map[bool]*OverlaidType
+	ByName map[Name]Overlaid
+	ByPair map[pair]Overlaid
+	Named  Overlaids
+}
+
+func (*MapType) isOverlaid() {}
+`
+
+func TestMaps(t *testing.T) {
+	a := assert.New(t)
+	var mu sync.Mutex
+	outputs := make(map[string][]byte)
+
+	err := RunWithOverlay(Config{
+		Dir:       "../demo",
+		TypeNames: []string{"Overlaid"},
+		Output: func(name string) (io.WriteCloser, error) {
+			return newMapWriter(name, &mu, outputs), nil
+		},
+	}, map[string][]byte{
+		"overlaid.go": []byte(overlaidSource),
+		"maps.go":     []byte(mapSource),
+	})
+	if !a.NoError(err) {
+		return
+	}
+
+	for _, out := range outputs {
+		a.Contains(string(out), "OverlaidTypeOverlaidTypePtrMapByBool")
+		a.Contains(string(out), "OverlaidTypeOverlaidMapByName")
+		a.Contains(string(out), "OverlaidTypeOverlaidMapByInt")
+		a.Contains(string(out), "(*(*map[Name]Overlaid)(m))[*(*Name)(key)] = *(*Overlaid)(value)")
+		a.Contains(string(out), "func (x *MapType) ByNameValue(key Name) (Overlaid, bool) {")
+		a.Contains(string(out), "func (x *MapType) WithNamedValue(key int, v Overlaid) *MapType {")
+		a.Contains(string(out), "ret.Named = make(Overlaids, len(x.Named)+1)")
+		// Keys must be booleans, numbers, or strings.
+		a.NotContains(string(out), `Name: "ByPair"`)
+	}
+}
+
+// recursiveSource declares structs for the Overlaid interface in
+// overlaidSource which enclose themselves by value.
+const recursiveSource = `package demo
+
+type NodeType struct {
+	Children []NodeType
+	Parent   *NodeType
+}
+
+func (*NodeType) isOverlaid() {}
+
+type LeftType struct {
+	Rights map[string]RightType
+}
+
+func (*LeftType) isOverlaid() {}
+
+type RightType struct {
+	Left LeftType
+}
+
+func (*RightType) isOverlaid() {}
+`
+
+func TestRecursiveValues(t *testing.T) {
+	a := assert.New(t)
+	dir, err := filepath.Abs("../demo")
+	if !a.NoError(err) {
+		return
+	}
+
+	g, err := newGenerationForTesting(config{dir: dir, typeNames: []string{"Overlaid"}}, make(map[string][]byte))
+	if !a.NoError(err) {
+		return
+	}
+	var stderr bytes.Buffer
+	g.stderr = &stderr
+	g.overlay = map[string][]byte{
+		filepath.Join(dir, "overlaid.go"):  []byte(overlaidSource),
+		filepath.Join(dir, "recursive.go"): []byte(recursiveSource),
+	}
+	if !a.NoError(g.Execute()) {
+		return
+	}
+
+	a.Equal([]string{
+		"LeftType encloses itself by value through LeftType.Rights -> RightType.Left; " +
+			"consider using a pointer to break the cycle",
+		"NodeType encloses itself by value through NodeType.Children; " +
+			"consider using a pointer to break the cycle",
+		"RightType encloses itself by value through RightType.Left -> LeftType.Rights; " +
+			"consider using a pointer to break the cycle",
+	}, g.visitation.recursiveValueWarnings())
+	a.Contains(stderr.String(), "warning: NodeType encloses itself by value")
+	a.NotContains(stderr.String(), "OverlaidType")
+}
+
+// promotedSource declares a struct for the Overlaid interface in
+// overlaidSource which embeds other structs.
+const promotedSource = `package demo
+
+type innerFields struct {
+	Next   Overlaid
+	Shadow Overlaid
+}
+
+type PtrFields struct {
+	Ptr Overlaid
+}
+
+type PromotingType struct {
+	innerFields
+	*PtrFields
+	Shadow *OverlaidType
+}
+
+func (*PromotingType) isOverlaid() {}
+`
+
+func TestPromotedFields(t *testing.T) {
+	a := assert.New(t)
+	dir, err := filepath.Abs("../demo")
+	if !a.NoError(err) {
+		return
+	}
+
+	g, err := newGenerationForTesting(config{dir: dir, typeNames: []string{"Overlaid"}}, make(map[string][]byte))
+	if !a.NoError(err) {
+		return
+	}
+	g.overlay = map[string][]byte{
+		filepath.Join(dir, "overlaid.go"): []byte(overlaidSource),
+		filepath.Join(dir, "promoted.go"): []byte(promotedSource),
+	}
+	if !a.NoError(g.Execute()) {
+		return
+	}
+
+	// Fields are not promoted through pointers, and the shadowed field
+	// is replaced by the outer one.
+	g.visitation.checkStructInfo(a, "PromotingType", "Next", "Shadow")
+	s := g.visitation.SourceTypes["PromotingType"].(namedStruct)
+	a.Equal("*OverlaidType", s.Fields()[1].Target.String())
+}
+
+// ignoredSource declares a struct for the Overlaid interface in
+// overlaidSource whose fields opt out of visitation.
+const ignoredSource = `package demo
+
+type IgnoredFields struct {
+	Dropped Overlaid
+}
+
+type IgnoringType struct {
+	IgnoredFields ` + "`walkabout:\"-\"`" + `
+	Cached        Overlaid ` + "`walkabout:\"-\"`" + `
+	Kept          Overlaid
+}
+
+func (*IgnoringType) isOverlaid() {}
+`
+
+func TestIgnoredFields(t *testing.T) {
+	a := assert.New(t)
+	dir, err := filepath.Abs("../demo")
+	if !a.NoError(err) {
+		return
+	}
+
+	outputs := make(map[string][]byte)
+	g, err := newGenerationForTesting(config{dir: dir, typeNames: []string{"Overlaid"}}, outputs)
+	if !a.NoError(err) {
+		return
+	}
+	g.overlay = map[string][]byte{
+		filepath.Join(dir, "overlaid.go"): []byte(overlaidSource),
+		filepath.Join(dir, "ignored.go"):  []byte(ignoredSource),
+	}
+	if !a.NoError(g.Execute()) {
+		return
+	}
+
+	// Neither the tagged field nor the fields of the tagged, embedded
+	// struct are visitable.
+	g.visitation.checkStructInfo(a, "IgnoringType", "Kept")
+	s := g.visitation.SourceTypes["IgnoringType"].(namedStruct)
+	a.Equal(1, s.NumChildren())
+	for _, out := range outputs {
+		a.NotContains(string(out), "CachedField")
+		a.NotContains(string(out), "DroppedField")
+	}
+}
+
+// unexportedSource declares a struct with un-exported visitable fields.
+const unexportedSource = `package demo
+
+type HidingType struct {
+	Shown    Overlaid
+	hidden   Overlaid
+	hiddens  []*OverlaidType
+	children []Overlaid ` + "`walkabout:\"getter=Children\"`" + `
+}
+
+func (*HidingType) isOverlaid() {}
+
+func (x *HidingType) Children() []Overlaid { return x.children }
+`
+
+func TestIncludeUnexported(t *testing.T) {
+	dir, err := filepath.Abs("../demo")
+	if !assert.NoError(t, err) {
+		return
+	}
+	overlay := map[string][]byte{
+		filepath.Join(dir, "overlaid.go"):   []byte(overlaidSource),
+		filepath.Join(dir, "unexported.go"): []byte(unexportedSource),
+	}
+
+	for _, include := range []bool{false, true} {
+		t.Run(fmt.Sprintf("include=%t", include), func(t *testing.T) {
+			a := assert.New(t)
+			cfg := config{dir: dir, includeUnexported: include, typeNames: []string{"Overlaid"}}
+			outputs := make(map[string][]byte)
+			g, err := newGenerationForTesting(cfg, outputs)
+			if !a.NoError(err) {
+				return
+			}
+			g.overlay = overlay
+			if !a.NoError(g.Execute()) {
+				return
+			}
+
+			// The field which declares a getter is left to the getter.
+			if include {
+				g.visitation.checkStructInfo(a, "HidingType", "Shown", "hidden", "hiddens")
+			} else {
+				g.visitation.checkStructInfo(a, "HidingType", "Shown")
+			}
+
+			// The generated code must compile.
+			for name, src := range overlay {
+				outputs[name] = src
+			}
+			pkgCfg := g.packageConfig()
+			pkgCfg.Mode = packages.LoadAllSyntax
+			pkgCfg.Overlay = outputs
+			pkgs, err := packages.Load(pkgCfg, ".")
+			if a.NoError(err) {
+				for _, pkg := range pkgs {
+					a.Nil(pkg.Errors)
+				}
+			}
+		})
+	}
+}
+
+// visitorSource declares visitor interfaces for the Overlaid
+// interface in overlaidSource.
+const visitorSource = `package demo
+
+type Visitor interface {
+	Visit(x Overlaid) Visitor
+}
+
+type AnyVisitor interface {
+	Visit(x interface{}) AnyVisitor
+}
+
+type WrongResult interface {
+	Visit(x Overlaid) Visitor
+}
+
+type WrongParam interface {
+	Visit(x int) WrongParam
+}
+
+type NoVisit interface {
+	Walk(x Overlaid) NoVisit
+}
+`
+
+func TestVisitor(t *testing.T) {
+	dir, err := filepath.Abs("../demo")
+	if !assert.NoError(t, err) {
+		return
+	}
+	overlay := map[string][]byte{
+		"overlaid.go": []byte(overlaidSource),
+		"visitor.go":  []byte(visitorSource),
+	}
+
+	tcs := []struct {
+		visitor string
+		err     string
+	}{
+		{visitor: "Visitor"},
+		{visitor: "AnyVisitor"},
+		{visitor: "WrongResult", err: "--visitor WrongResult must have a method Visit(Overlaid) WrongResult"},
+		{visitor: "WrongParam", err: "--visitor WrongParam must have a method Visit(Overlaid) WrongParam"},
+		{visitor: "NoVisit", err: "--visitor NoVisit must have a method Visit(Overlaid) NoVisit"},
+		{visitor: "OverlaidType", err: "--visitor OverlaidType must name an interface"},
+		{visitor: "Missing", err: "--visitor Missing must name an interface"},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.visitor, func(t *testing.T) {
+			a := assert.New(t)
+			var mu sync.Mutex
+			outputs := make(map[string][]byte)
+
+			err := RunWithOverlay(Config{
+				Dir:       dir,
+				TypeNames: []string{"Overlaid"},
+				Visitor:   tc.visitor,
+				Output: func(name string) (io.WriteCloser, error) {
+					return newMapWriter(name, &mu, outputs), nil
+				},
+			}, overlay)
+			if tc.err != "" {
+				if a.Error(err) {
+					a.Equal(tc.err, err.Error())
+				}
+				return
+			}
+			if !a.NoError(err) {
+				return
+			}
+			out := string(outputs[filepath.Join(dir, "overlaid_walkabout.g.go")])
+			a.Contains(out, fmt.Sprintf("func WalkOverlaid%s(x Overlaid, v %s) error {", tc.visitor, tc.visitor))
+		})
+	}
+
+	// The adapter isn't useful without any traversal code.
+	a := assert.New(t)
+	a.Error(RunWithOverlay(Config{
+		TypeNames: []string{"Overlaid"},
+		Union:     "Union",
+		UnionOnly: true,
+		Visitor:   "Visitor",
+	}, nil))
+}
+
+func TestEngineVarCollision(t *testing.T) {
+	a := assert.New(t)
+	overlay := map[string][]byte{
+		"overlaid.go":  []byte(overlaidSource),
+		"collision.go": []byte("package demo\n\nvar overlaidEngine int\n"),
+	}
+	run := func(engineVar, outFile string) error {
+		return RunWithOverlay(Config{
+			Dir:       "../demo",
+			EngineVar: engineVar,
+			OutFile:   outFile,
+			TypeNames: []string{"Overlaid"},
+			Output: func(name string) (io.WriteCloser, error) {
+				return newMapWriter(name, &sync.Mutex{}, nil), nil
+			},
+		}, overlay)
+	}
+
+	if err := run("", ""); a.Error(err) {
+		a.Contains(err.Error(), "overlaidEngine is already declared at")
+		a.Contains(err.Error(), "collision.go")
+	}
+	a.NoError(run("overlaidWalkEngine", ""))
+	a.Error(run("not-an-identifier", ""))
+	// The file named by a relative --out will be overwritten, even
+	// though it is not in the working directory.
+	a.NoError(run("", "collision.go"))
+}
+
+func TestExclude(t *testing.T) {
+	a := assert.New(t)
+	dir, err := filepath.Abs("../demo")
+	if !a.NoError(err) {
+		return
+	}
+	cfg := config{
+		dir:       dir,
+		exclude:   []string{"ByValType", "EmbeddedFields", "ReachableType"},
+		reachable: true,
+		typeNames: []string{"Target"},
+		union:     "Union",
+	}
+
+	outputs := make(map[string][]byte)
+	g, err := newGenerationForTesting(cfg, outputs)
+	if !a.NoError(err) || !a.NoError(g.Execute()) {
+		return
+	}
+	v := g.visitation
+	for _, typ := range v.Types {
+		a.NotContains(typ.String(), "ByValType")
+		a.NotContains(typ.String(), "EmbeddedFields")
+		a.NotContains(typ.String(), "ReachableType")
+	}
+	v.checkStructInfo(a, "ContainerType", "ByRef", "ByRefPtr", "ByRefSlice", "ByRefPtrSlice",
+		"Container", "AnotherTarget", "AnotherTargetPtr", "EmbedsTarget", "EmbedsTargetPtr", "TargetSlice",
+		"InterfacePtrSlice", "NamedTargets", "Quad", "OptTarget", "Annotated", "UnionableType")
+	// The fields of an excluded, embedded struct are not promoted.
+	v.checkStructInfo(a, "EmbeddingType", "Scoped", "Own")
+
+	pkgCfg := g.packageConfig()
+	pkgCfg.Mode = packages.LoadAllSyntax
+	pkgCfg.Overlay = outputs
+	pkgs, err := packages.Load(pkgCfg, ".")
+	if a.NoError(err) {
+		for _, pkg := range pkgs {
+			a.Nil(pkg.Errors)
+		}
+	}
+
+	for _, exclude := range [][]string{{"NoSuchType"}, {"Target"}} {
+		cfg.exclude = exclude
+		g, err := newGenerationForTesting(cfg, make(map[string][]byte))
+		if a.NoError(err) {
+			a.Error(g.Execute(), "%v", exclude)
+		}
+	}
+}
+
+func TestSuffix(t *testing.T) {
+
+	tcs := []struct {
+		file     string
+		suffix   string
+		split    bool
+		expected string
+		err      string
+	}{
+		{file: "overlaid.go", suffix: "_gen.go", expected: "overlaid_gen.go"},
+		{file: "overlaid_test.go", suffix: "_gen.go", expected: "overlaid_gen_test.go"},
+		{file: "overlaid_test.go", suffix: "_walk.v2.go", expected: "overlaid_walk.v2_test.go"},
+		{file: "overlaid.go", suffix: "_gen.txt", err: "must end with .go"},
+		{file: "overlaid.go", suffix: ".go", err: "at least one character before the first ."},
+		{file: "overlaid.go", suffix: ".walk.go", err: "at least one character before the first ."},
+		{file: "overlaid.go", suffix: "_gen_test.go", err: "must not end with _test.go"},
+		{file: "overlaid.go", suffix: "/gen.go", err: "invalid character"},
+		{file: "overlaid.go", suffix: "_gen.go", split: true, err: "--split cannot be used with --suffix"},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.file+" "+tc.suffix, func(t *testing.T) {
+			a := assert.New(t)
+			var mu sync.Mutex
+			outputs := make(map[string][]byte)
+
+			// The package loader won't discover test files which are only
+			// present in an overlay, so we write the sources to disk.
+			dir, err := ioutil.TempDir("", "walkabout")
+			if !a.NoError(err) {
+				return
+			}
+			defer os.RemoveAll(dir)
+			src := strings.Replace(overlaidSource, "package demo", "package suffix", 1)
+			for name, src := range map[string]string{"go.mod": "module suffix\n", tc.file: src} {
+				if !a.NoError(ioutil.WriteFile(filepath.Join(dir, name), []byte(src), 0644)) {
+					return
+				}
+			}
+
+			err = RunWithOverlay(Config{
+				Dir:       dir,
+				Split:     tc.split,
+				Suffix:    tc.suffix,
+				TypeNames: []string{"Overlaid"},
+				Output: func(name string) (io.WriteCloser, error) {
+					return newMapWriter(name, &mu, outputs), nil
+				},
+			}, nil)
+			if tc.err != "" {
+				if a.Error(err) {
+					a.Contains(err.Error(), tc.err)
+				}
+				return
+			}
+			if a.NoError(err) {
+				_, ok := outputs[filepath.Join(dir, tc.expected)]
+				a.True(ok, "missing output: %v", outputs)
+			}
+		})
+	}
+}
+
+// priorSource stands in for the output of a previous run of the code
+// generator over overlaidSource, before OverlaidType was added.
+const priorSource = `package demo
+
+type OverlaidTypeID int
+
+const (
+	OverlaidTypeOverlaid OverlaidTypeID = 5
+	OverlaidTypeRemoved  OverlaidTypeID = 7
+)
+
+const overlaidTypeIDLimit = 9
+`
+
+func TestStableTypeIDs(t *testing.T) {
+	a := assert.New(t)
+	var mu sync.Mutex
+	outputs := make(map[string][]byte)
+
+	err := RunWithOverlay(Config{
+		Dir:       "../demo",
+		TypeNames: []string{"Overlaid"},
+		Output: func(name string) (io.WriteCloser, error) {
+			return newMapWriter(name, &mu, outputs), nil
+		},
+	}, map[string][]byte{
+		"overlaid.go":             []byte(overlaidSource),
+		"overlaid_walkabout.g.go": []byte(priorSource),
+	})
+	if !a.NoError(err) {
+		return
+	}
+
+	dir, err := filepath.Abs("../demo")
+	if !a.NoError(err) {
+		return
+	}
+	out, ok := outputs[filepath.Join(dir, "overlaid_walkabout.g.go")]
+	if !a.True(ok, "missing output: %v", outputs) {
+		return
+	}
+	src := string(out)
+
+	// Existing tokens retain their values, while new tokens are assigned
+	// values beyond those of any removed token.
+	a.Regexp(`OverlaidTypeOverlaid +OverlaidTypeID = 5\n`, src)
+	a.Regexp(`OverlaidTypeOverlaidType +OverlaidTypeID = 9\n`, src)
+	a.Regexp(`OverlaidTypeOverlaidTypePtr +OverlaidTypeID = 10\n`, src)
+	a.NotContains(src, "OverlaidTypeRemoved")
+	a.Contains(src, "const overlaidTypeIDLimit = 11\n")
+}
+
+// platformSources are written into a scratch module to verify that
+// the package can be loaded for a specific platform. Files in an
+// overlay aren't subject to build constraints, so they must be on disk.
+var platformSources = map[string]string{
+	"go.mod": "module platform\n",
+	"platform.go": `package platform
+
+// Platform is a visitable interface whose implementations vary.
+type Platform interface {
+	isPlatform()
+}
+`,
+	"platform_linux.go": `package platform
+
+// LinuxType is only visible when building for linux.
+type LinuxType struct {
+	Next Platform
+}
+
+func (*LinuxType) isPlatform() {}
+`,
+	"platform_tagged.go": `//go:build walkabout
+
+package platform
+
+// TaggedType is only visible when building with the walkabout tag.
+type TaggedType struct {
+	Next Platform
+}
+
+func (*TaggedType) isPlatform() {}
+`,
+	"platform_windows.go": `package platform
+
+// WindowsType is only visible when building for windows.
+type WindowsType struct {
+	Next Platform
+}
+
+func (*WindowsType) isPlatform() {}
+`,
+}
+
+func TestPlatform(t *testing.T) {
+	dir, err := ioutil.TempDir("", "walkabout")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer os.RemoveAll(dir)
+	for name, src := range platformSources {
+		if !assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, name), []byte(src), 0644)) {
+			return
+		}
+	}
+
+	tcs := []struct {
+		goos, goarch string
+		expected     string
+		unexpected   string
+		constraint   string
+		outName      string
+	}{
+		{
+			goos:       "linux",
+			expected:   "PlatformTypeLinuxType",
+			unexpected: "PlatformTypeWindowsType",
+			constraint: "//go:build linux\n",
+			outName:    "platform_walkabout_linux.g.go",
+		},
+		{
+			goos:       "windows",
+			goarch:     "amd64",
+			expected:   "PlatformTypeWindowsType",
+			unexpected: "PlatformTypeLinuxType",
+			constraint: "//go:build windows && amd64\n",
+			outName:    "platform_walkabout_windows_amd64.g.go",
+		},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.outName, func(t *testing.T) {
+			a := assert.New(t)
+			var mu sync.Mutex
+			outputs := make(map[string][]byte)
+
+			err := RunWithOverlay(Config{
+				BuildFlags: []string{"-tags=walkabout"},
+				Dir:        dir,
+				GOARCH:     tc.goarch,
+				GOOS:       tc.goos,
+				TypeNames:  []string{"Platform"},
+				Output: func(name string) (io.WriteCloser, error) {
+					return newMapWriter(name, &mu, outputs), nil
+				},
+			}, nil)
+			if !a.NoError(err) {
+				return
+			}
+
+			out, ok := outputs[filepath.Join(dir, tc.outName)]
+			if !a.True(ok, "missing output: %v", outputs) {
+				return
+			}
+			a.Contains(string(out), tc.expected)
+			a.Contains(string(out), "PlatformTypeTaggedType")
+			a.NotContains(string(out), tc.unexpected)
+			a.Contains(string(out), tc.constraint)
+		})
+	}
+}
+
+// constrainedSources are written into a scratch module to verify that
+// the build constraints of the input files are propagated.
+var constrainedSources = map[string]string{
+	"go.mod": "module constrained\n",
+	"legacy.go": `// +build walkabout
+// +build !windows
+
+package constrained
+
+// Legacy uses the older build constraint syntax.
+type Legacy interface {
+	isLegacy()
+}
+
+type LegacyType struct {
+	Next Legacy
+}
+
+func (*LegacyType) isLegacy() {}
+`,
+	"tagged.go": `//go:build walkabout || debug
+
+package constrained
+
+// Tagged is only visible when building with a tag.
+type Tagged interface {
+	isTagged()
+}
+
+type TaggedType struct {
+	Next Tagged
+}
+
+func (*TaggedType) isTagged() {}
+`,
+}
+
+func TestBuildConstraints(t *testing.T) {
+	dir, err := ioutil.TempDir("", "walkabout")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer os.RemoveAll(dir)
+	for name, src := range constrainedSources {
+		if !assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, name), []byte(src), 0644)) {
+			return
+		}
+	}
+
+	tcs := []struct {
+		goos       string
+		typeNames  []string
+		constraint string
+		outName    string
+	}{
+		{
+			typeNames:  []string{"Tagged"},
+			constraint: "//go:build walkabout || debug\n",
+			outName:    "tagged_walkabout.g.go",
+		},
+		{
+			goos:       "linux",
+			typeNames:  []string{"Tagged"},
+			constraint: "//go:build linux && (walkabout || debug)\n",
+			outName:    "tagged_walkabout_linux.g.go",
+		},
+		{
+			typeNames:  []string{"Legacy"},
+			constraint: "//go:build walkabout && !windows\n",
+			outName:    "legacy_walkabout.g.go",
+		},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.outName, func(t *testing.T) {
+			a := assert.New(t)
+			var mu sync.Mutex
+			outputs := make(map[string][]byte)
+
+			err := RunWithOverlay(Config{
+				BuildFlags: []string{"-tags=walkabout"},
+				Dir:        dir,
+				GOOS:       tc.goos,
+				TypeNames:  tc.typeNames,
+				Output: func(name string) (io.WriteCloser, error) {
+					return newMapWriter(name, &mu, outputs), nil
+				},
+			}, nil)
+			if !a.NoError(err) {
+				return
+			}
+
+			out, ok := outputs[filepath.Join(dir, tc.outName)]
+			if a.True(ok, "missing output: %v", outputs) {
+				a.Contains(string(out), tc.constraint)
+			}
+		})
+	}
+}
+
 // newGenerationForTesting creates a generator that captures
 // its output in the provided map.
 func newGenerationForTesting(cfg config, outputs map[string][]byte) (*generation, error) {
@@ -220,6 +1697,7 @@ func newGenerationForTesting(cfg config, outputs map[string][]byte) (*generation
 		return nil, err
 	}
 	var mu sync.Mutex
+	g.stderr = ioutil.Discard
 	g.writeCloser = func(name string) (io.WriteCloser, error) {
 		// Use absolute filenames for compatibility with package overlay.
 		name, err := filepath.Abs(name)
@@ -15,17 +15,28 @@
 
 package gen
 
-import "go/types"
+import (
+	"fmt"
+	"go/token"
+	"go/types"
+	"reflect"
+	"strings"
+
+	"github.com/pkg/errors"
+)
 
 // visitableType represents a type that we can generate visitation logic
 // around:
 //	* a named struct which implements the visitable interface,
 //		either by-reference or by-value
 //	* a named interface which implements the visitable interface
+//	* an interface field which declares the concrete types it may hold
 //	* a pointer to a visitable type
 //	* a slice of a visitable type
+//	* an array of a visitable type
+//	* a map from a scalar type to a visitable type
 //	* a named visitable type; e.g. "type Foos []Foo"
-//	* TODO: a map of visitable types?
+//	* an instantiated generic struct; e.g. "Optional[*Foo]"
 type visitableType interface {
 	// Implementation returns the underlying type that we actually
 	// need to be able to traverse.
@@ -36,8 +47,11 @@ type visitableType interface {
 }
 
 var (
+	_ visitableType = concreteInterface{}
+	_ visitableType = namedArrayType{}
 	_ visitableType = namedStruct{}
 	_ visitableType = namedInterfaceType{}
+	_ visitableType = namedMapType{}
 	_ visitableType = namedVisitableType{}
 	_ visitableType = pointerType{}
 	_ visitableType = namedSliceType{}
@@ -47,6 +61,7 @@ var (
 // namedVisitableType represents a named type definition like:
 //   type Foos []Foo
 //   type OptFoo *Foo
+//   type Quad [4]Foo
 type namedVisitableType struct {
 	*types.Named
 	Underlying visitableType
@@ -59,7 +74,7 @@ func (t namedVisitableType) Implementation() visitableType {
 
 // String is codegen-safe.
 func (t namedVisitableType) String() string {
-	return t.Obj().Name()
+	return namedString(t.Named)
 }
 
 func (t namedVisitableType) Visitation() *visitation {
@@ -93,6 +108,48 @@ func (t namedInterfaceType) Visitation() *visitation {
 	return t.v
 }
 
+// concreteInterface represents the type of an interface field which
+// is not otherwise visitable, but whose walkabout tag declares the
+// visitable structs that it may hold, e.g. "concrete=Foo,Bar".
+type concreteInterface struct {
+	// Concrete holds the declared structs, in the order given.
+	Concrete []namedStruct
+	// Decl is the codegen-safe type of the field, e.g. "interface{}".
+	Decl string
+	// Interface is the underlying type of the field.
+	Interface *types.Interface
+	v         *visitation
+}
+
+// Ident returns a description of the type which can be used in an
+// identifier, e.g. "AnyOfFooOrBar".
+func (t concreteInterface) Ident() string {
+	decl := "Any"
+	if t.Decl != "interface{}" {
+		decl = strings.ToUpper(t.Decl[:1]) + t.Decl[1:]
+	}
+	names := make([]string, len(t.Concrete))
+	for i, s := range t.Concrete {
+		names[i] = s.Ident()
+	}
+	return decl + "Of" + strings.Join(names, "Or")
+}
+
+// Implementation returns the receiver.
+func (t concreteInterface) Implementation() visitableType {
+	return t
+}
+
+// String is codegen-safe.
+func (t concreteInterface) String() string {
+	return t.Decl
+}
+
+// Visitation implements visitableType.
+func (t concreteInterface) Visitation() *visitation {
+	return t.v
+}
+
 // pointerType is a pointer to a visitableType.
 type pointerType struct {
 	Elem visitableType
@@ -133,6 +190,54 @@ func (t namedSliceType) Visitation() *visitation {
 	return t.Elem.Visitation()
 }
 
+// namedArrayType is a fixed-length array of a visitableType.
+type namedArrayType struct {
+	Elem visitableType
+	Len  int64
+}
+
+// Implementation returns the receiver.
+func (t namedArrayType) Implementation() visitableType {
+	return t
+}
+
+// String is codegen-safe.
+func (t namedArrayType) String() string {
+	return fmt.Sprintf("[%d]%s", t.Len, t.Elem)
+}
+
+// Visitation implements visitableType.
+func (t namedArrayType) Visitation() *visitation {
+	return t.Elem.Visitation()
+}
+
+// namedMapType is a map from a scalar type to a visitableType. The
+// keys of the map are not visited.
+type namedMapType struct {
+	Elem visitableType
+	Key  scalarField
+}
+
+// Implementation returns the receiver.
+func (t namedMapType) Implementation() visitableType {
+	return t
+}
+
+// Ordered returns true if the keys of the map may be sorted.
+func (t namedMapType) Ordered() bool {
+	return t.Key.Kind != "Bool"
+}
+
+// String is codegen-safe.
+func (t namedMapType) String() string {
+	return fmt.Sprintf("map[%s]%s", t.Key.GoType, t.Elem)
+}
+
+// Visitation implements visitableType.
+func (t namedMapType) Visitation() *visitation {
+	return t.Elem.Visitation()
+}
+
 // namedStruct represents a user-defined, named struct.
 type namedStruct struct {
 	*types.Named
@@ -145,32 +250,435 @@ func (t namedStruct) Implementation() visitableType {
 	return t
 }
 
-// String is codegen-safe.
+// String is codegen-safe. Instantiated generic types will include
+// their type arguments, e.g. "Optional[*Node]".
 func (t namedStruct) String() string {
-	return t.Obj().Name()
+	return namedString(t.Named)
+}
+
+// Generic returns true if the struct is an instantiated generic type.
+func (t namedStruct) Generic() bool {
+	return t.TypeArgs().Len() > 0
+}
+
+// Ident returns an identifier-safe representation of the type, e.g.
+// "OptionalOfNodePtr".
+func (t namedStruct) Ident() string {
+	ret, _ := t.v.typeIdent(t.Named)
+	return ret
+}
+
+// Receiver returns the type expression to be used as the receiver of
+// generated methods. Methods cannot be declared on instantiated
+// generic types, so this will name the generic type's parameters,
+// e.g. "Optional[T]".
+func (t namedStruct) Receiver() string {
+	params := t.Origin().TypeParams()
+	if params.Len() == 0 {
+		return t.Obj().Name()
+	}
+	names := make([]string, params.Len())
+	for i := range names {
+		names[i] = params.At(i).Obj().Name()
+	}
+	return fmt.Sprintf("%s[%s]", t.Obj().Name(), strings.Join(names, ", "))
 }
 
-// Fields returns the visitable fields of the struct.
+// Fields returns the visitable fields of the struct. The visitable
+// fields of a struct which is embedded by value, but which is not
+// itself visitable, are promoted into the list in the same fashion as
+// Go's field promotion. Promoted fields which are shadowed by, or are
+// ambiguous with, another field are ignored.
 func (t namedStruct) Fields() []fieldInfo {
-	ret := make([]fieldInfo, 0, t.NumFields())
+	return t.appendFields(make([]fieldInfo, 0, t.NumFields()), t.Struct)
+}
+
+// appendFields appends the visitable fields of s, which is either the
+// struct itself or a struct which it embeds, to ret.
+func (t namedStruct) appendFields(ret []fieldInfo, s *types.Struct) []fieldInfo {
+	for a, j := 0, s.NumFields(); a < j; a++ {
+		f := s.Field(a)
+
+		// Fields which opt out of visitation are ignored entirely, as
+		// are the fields of an embedded struct which opts out.
+		if isIgnored(s.Tag(a)) {
+			continue
+		}
+
+		// Ignore un-exported fields, unless requested, although the
+		// fields of an un-exported, embedded struct may be promoted.
+		// Un-exported types are never visitable. Fields which declare a
+		// getter are always left to the getter.
+		if !f.Exported() && (!t.v.gen.includeUnexported || hasGetter(s.Tag(a))) {
+			if embedded, ok := t.embeddedStruct(f); ok {
+				ret = t.appendFields(ret, embedded)
+			}
+			continue
+		}
 
+		// Look up `field Something` to visitableType. An interface
+		// field may instead declare the visitable types it holds.
+		found, ok := t.v.visitableType(f.Type(), true)
+		if !ok {
+			if c, declared, err := t.concreteField(f, s.Tag(a)); declared && err == nil {
+				found, ok = c, true
+			}
+		}
+		if !ok {
+			if embedded, ok := t.embeddedStruct(f); ok {
+				ret = t.appendFields(ret, embedded)
+			}
+			continue
+		}
+		if s != t.Struct {
+			// Promoted fields must be accessible by name from the
+			// struct, without any pointer indirection, so that the
+			// generated code can refer to them.
+			obj, _, indirect := types.LookupFieldOrMethod(t.Named, false, t.Obj().Pkg(), f.Name())
+			if obj != f || indirect {
+				continue
+			}
+		}
+
+		info := fieldInfo{
+			Name:   f.Name(),
+			Parent: &t,
+			Target: found,
+		}
+		if tag, ok := reflect.StructTag(s.Tag(a)).Lookup("walkabout"); ok {
+			info.Invariants, _ = tagOptions(tag)
+		}
+		ret = append(ret, info)
+	}
+
+	return ret
+}
+
+// tagOptions splits a walkabout tag into its options and the type
+// names listed by a "concrete=" option. Since the type names are also
+// separated by commas, the concrete option must be the last one.
+func tagOptions(tag string) (opts, concrete []string) {
+	parts := strings.Split(tag, ",")
+	for i, part := range parts {
+		if strings.HasPrefix(part, "concrete=") {
+			concrete = append([]string{strings.TrimPrefix(part, "concrete=")}, parts[i+1:]...)
+			return parts[:i], concrete
+		}
+	}
+	return parts, nil
+}
+
+// concreteField returns the type of an interface field whose walkabout
+// tag declares the concrete types that it may hold. Excluded types are
+// ignored. The declared value will be false if the tag does not include
+// a concrete option, or if all of the listed types are excluded. An
+// error will be returned if the field is not of a suitable interface
+// type, or if a listed type is not a visitable struct which can be
+// stored in the field.
+func (t namedStruct) concreteField(f *types.Var, tag string) (concreteInterface, bool, error) {
+	opts, _ := reflect.StructTag(tag).Lookup("walkabout")
+	_, names := tagOptions(opts)
+	if names == nil {
+		return concreteInterface{}, false, nil
+	}
+	if _, ok := t.v.visitableType(f.Type(), true); ok {
+		return concreteInterface{}, true, errors.Errorf(
+			"%s.%s: concrete types cannot be declared on a visitable field", t, f.Name())
+	}
+
+	ret := concreteInterface{v: t.v}
+	switch typ := types.Unalias(f.Type()).(type) {
+	case *types.Interface:
+		if typ.NumMethods() == 0 {
+			ret.Decl, ret.Interface = "interface{}", typ
+		}
+	case *types.Named:
+		intf, ok := typ.Underlying().(*types.Interface)
+		if ok && typ.TypeArgs().Len() == 0 && typ.Obj().Pkg() != nil && typ.Obj().Pkg().Path() == t.v.packagePath {
+			ret.Decl, ret.Interface = typ.Obj().Name(), intf
+		}
+	}
+	if ret.Interface == nil {
+		return concreteInterface{}, true, errors.Errorf(
+			"%s.%s: concrete types may only be declared on an empty interface or an interface from the same package",
+			t, f.Name())
+	}
+
+	for _, name := range names {
+		if t.v.excluded[name] {
+			continue
+		}
+		var s namedStruct
+		if obj, ok := t.Obj().Pkg().Scope().Lookup(name).(*types.TypeName); ok {
+			found, _ := t.v.visitableType(obj.Type(), true)
+			s, _ = found.(namedStruct)
+		}
+		if s.Named == nil {
+			return concreteInterface{}, true, errors.Errorf("%s.%s: %q is not a visitable struct", t, f.Name(), name)
+		}
+		if !types.Implements(s.Named, ret.Interface) && !types.Implements(types.NewPointer(s.Named), ret.Interface) {
+			return concreteInterface{}, true, errors.Errorf("%s.%s: %s does not implement %s", t, f.Name(), name, ret.Decl)
+		}
+		ret.Concrete = append(ret.Concrete, s)
+	}
+	if len(ret.Concrete) == 0 {
+		return concreteInterface{}, false, nil
+	}
+	return ret, true, nil
+}
+
+// checkConcreteFields returns an error if the concrete types declared
+// by a field of the struct, including any promoted fields, are
+// invalid. Such fields are otherwise ignored.
+func (t namedStruct) checkConcreteFields(s *types.Struct) error {
+	for a, j := 0, s.NumFields(); a < j; a++ {
+		f := s.Field(a)
+		if isIgnored(s.Tag(a)) {
+			continue
+		}
+		if _, _, err := t.concreteField(f, s.Tag(a)); err != nil {
+			return err
+		}
+		if embedded, ok := t.embeddedStruct(f); ok {
+			if err := t.checkConcreteFields(embedded); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// embeddedStruct returns the struct type of an embedded field whose
+// fields may be promoted. Fields are not promoted through pointers,
+// since their offsets are not fixed, or through excluded types.
+func (t namedStruct) embeddedStruct(f *types.Var) (*types.Struct, bool) {
+	if !f.Embedded() {
+		return nil, false
+	}
+	named, ok := types.Unalias(f.Type()).(*types.Named)
+	if !ok || named.Obj().Pkg() == nil || named.Obj().Pkg().Path() != t.v.packagePath {
+		return nil, false
+	}
+	// Excluded types are opaque.
+	if t.v.excluded[named.Obj().Name()] {
+		return nil, false
+	}
+	s, ok := named.Underlying().(*types.Struct)
+	return s, ok
+}
+
+// LeafFields returns the names of the exported, non-embedded fields
+// of the struct which are not visitable, in declaration order.
+func (t namedStruct) LeafFields() []string {
+	visitable := make(map[string]bool)
+	for _, f := range t.Fields() {
+		visitable[f.Name] = true
+	}
+	var ret []string
 	for a, j := 0, t.NumFields(); a < j; a++ {
 		f := t.Field(a)
-		// Ignore un-exported fields.
-		if !f.Exported() {
+		if f.Exported() && !f.Embedded() && !visitable[f.Name()] {
+			ret = append(ret, f.Name())
+		}
+	}
+	return ret
+}
+
+// hasGetter returns true if the struct tag declares a getter.
+func hasGetter(tag string) bool {
+	opts, _ := reflect.StructTag(tag).Lookup("walkabout")
+	for _, opt := range strings.Split(opts, ",") {
+		if strings.HasPrefix(opt, "getter=") {
+			return true
+		}
+	}
+	return false
+}
+
+// isIgnored returns true if the struct tag opts the field out of
+// visitation, i.e. `walkabout:"-"`, as with encoding/json.
+func isIgnored(tag string) bool {
+	opts, _ := reflect.StructTag(tag).Lookup("walkabout")
+	return opts == "-"
+}
+
+// Getters returns the methods which have been declared as the source of
+// additional children by a "getter=Method" walkabout tag on an
+// unexported field. This allows the children of encapsulated types to
+// be visited, although they cannot be replaced. An error will be
+// returned if the method does not exist, or if it does not accept zero
+// arguments and return a single visitable value.
+func (t namedStruct) Getters() ([]getterInfo, error) {
+	var ret []getterInfo
+	for a, j := 0, t.NumFields(); a < j; a++ {
+		if t.Field(a).Exported() {
+			continue
+		}
+		tag, ok := reflect.StructTag(t.Tag(a)).Lookup("walkabout")
+		if !ok {
 			continue
 		}
+		for _, opt := range strings.Split(tag, ",") {
+			if !strings.HasPrefix(opt, "getter=") {
+				continue
+			}
+			name := strings.TrimPrefix(opt, "getter=")
+			obj, _, _ := types.LookupFieldOrMethod(types.NewPointer(t.Named), true, t.Obj().Pkg(), name)
+			fn, ok := obj.(*types.Func)
+			if !ok || !fn.Exported() {
+				return nil, errors.Errorf("%s.%s: no exported method named %q", t, t.Field(a).Name(), name)
+			}
+			sig := fn.Type().(*types.Signature)
+			if sig.Params().Len() != 0 || sig.Results().Len() != 1 {
+				return nil, errors.Errorf("%s.%s: must accept no arguments and return a single value", t, name)
+			}
+			target, ok := t.v.visitableType(sig.Results().At(0).Type(), true)
+			if !ok {
+				return nil, errors.Errorf("%s.%s: does not return a visitable type", t, name)
+			}
+			ret = append(ret, getterInfo{Name: name, Target: target})
+		}
+	}
+	return ret, nil
+}
+
+// Accessors returns a fieldAccessor for each visitable field. The name
+// of each method is the name of the field with a "Field" suffix, and
+// the name of each builder is the name of the field with a "With"
+// prefix. Map-valued fields also have a "Value" suffix added to both
+// names for the methods which operate on a single entry. A number is appended to either name if it would otherwise
+// collide with another field or method of the struct, or with another
+// generated method. Methods declared by a previous run of the code generator
+// will be overwritten, so they aren't collisions, although those
+// generated for another interface in the same run are.
+func (t namedStruct) Accessors() []fieldAccessor {
+	// These must be kept in sync with the methods generated for structs.
+	root := t.v.Root.String()
+	taken := map[string]bool{
+		root + "At":             true,
+		root + "Count":          true,
+		root + "Each":           true,
+		root + "FieldNameAt":    true,
+		root + "Named":          true,
+		root + "TypeID":         true,
+		root + "Walk":           true,
+		"Clone" + root:          true,
+		"Equal" + root:          true,
+		"Inspect" + root:        true,
+		"Walk" + root:           true,
+		"Walk" + root + "Morph": true,
+	}
+	own, _ := t.v.ownOutputs()
+	claimed := t.v.gen.accessors[t.String()]
+	collides := func(name string) bool {
+		if taken[name] || claimed[name] {
+			return true
+		}
+		obj, _, _ := types.LookupFieldOrMethod(types.NewPointer(t.Named), true, t.Obj().Pkg(), name)
+		return obj != nil && !t.v.declaredIn(own, obj)
+	}
+
+	fields := t.Fields()
+	ret := make([]fieldAccessor, len(fields))
+	for i, f := range fields {
+		name := f.Name + "Field"
+		for n := 2; collides(name); n++ {
+			name = fmt.Sprintf("%sField%d", f.Name, n)
+		}
+		taken[name] = true
+
+		prefix := "With"
+		if !token.IsExported(f.Name) {
+			prefix = "with"
+		}
+		base := prefix + strings.ToUpper(f.Name[:1]) + f.Name[1:]
+		builder := base
+		for n := 2; collides(builder); n++ {
+			builder = fmt.Sprintf("%s%d", base, n)
+		}
+		taken[builder] = true
+
+		ret[i] = fieldAccessor{fieldInfo: f, Builder: builder, Method: name}
+		switch impl := f.Target.Implementation().(type) {
+		case namedArrayType, namedStruct:
+			ret[i].Ref = true
+		case namedMapType:
+			value := f.Name + "Value"
+			for n := 2; collides(value); n++ {
+				value = fmt.Sprintf("%sValue%d", f.Name, n)
+			}
+			taken[value] = true
+
+			valueBuilder := base + "Value"
+			for n := 2; collides(valueBuilder); n++ {
+				valueBuilder = fmt.Sprintf("%sValue%d", base, n)
+			}
+			taken[valueBuilder] = true
+
+			ret[i].Map = &impl
+			ret[i].Value, ret[i].ValueBuilder = value, valueBuilder
+		}
+	}
+	return ret
+}
+
+// NumChildren returns the number of visitable fields and getters.
+func (t namedStruct) NumChildren() int {
+	getters, _ := t.Getters()
+	return len(t.Fields()) + len(getters)
+}
+
+// Invariants returns the invariants declared by the walkabout tags of
+// the struct's visitable fields. An error will be returned if an
+// invariant is unknown or cannot be applied to the type of its field.
+func (t namedStruct) Invariants() ([]fieldInvariant, error) {
+	var ret []fieldInvariant
+	for _, f := range t.Fields() {
+		for _, name := range f.Invariants {
+			var ok bool
+			switch f.Target.Implementation().(type) {
+			case concreteInterface, namedInterfaceType, pointerType, unionInterface:
+				ok = name == "nonnil"
+			case namedMapType, namedSliceType:
+				ok = name == "nonempty"
+			}
+			if !ok {
+				return nil, errors.Errorf("%s.%s: unsupported invariant %q", t, f, name)
+			}
+			ret = append(ret, fieldInvariant{Field: f, Name: name})
+		}
+	}
+	return ret, nil
+}
 
-		// Look up `field Something` to visitableType.
-		if found, ok := t.v.visitableType(f.Type(), true); ok {
-			ret = append(ret, fieldInfo{
-				Name:   f.Name(),
-				Parent: &t,
-				Target: found,
-			})
+// ScalarFields returns the exported fields of the struct whose types
+// are booleans, numbers, or strings, or named types from the same
+// package with such an underlying type. These fields are never
+// visitable.
+func (t namedStruct) ScalarFields() []scalarField {
+	var ret []scalarField
+	for a, j := 0, t.NumFields(); a < j; a++ {
+		f := t.Field(a)
+		if !f.Exported() {
+			continue
+		}
+		if field, ok := t.v.scalarType(f.Type()); ok {
+			field.Name = f.Name()
+			ret = append(ret, field)
 		}
 	}
+	return ret
+}
 
+// StringFields returns the subset of ScalarFields whose types are
+// strings.
+func (t namedStruct) StringFields() []scalarField {
+	var ret []scalarField
+	for _, f := range t.ScalarFields() {
+		if f.Kind == "String" {
+			ret = append(ret, f)
+		}
+	}
 	return ret
 }
 
@@ -199,6 +707,21 @@ func (t unionInterface) Visitation() *visitation {
 	return t.v
 }
 
+// scalarField describes a field containing a boolean, number, or
+// string.
+type scalarField struct {
+	Name string
+	// GoType is the name of the field's type.
+	GoType string
+	// Kind describes how the field is encoded, e.g. "Int".
+	Kind string
+	// Named is populated with the name of the field's type if it is not
+	// a predeclared type.
+	Named string
+	// WireType is the predeclared type used to encode the field.
+	WireType string
+}
+
 // fieldInfo describes a field containing a visitable type.
 type fieldInfo struct {
 	Name string
@@ -206,9 +729,145 @@ type fieldInfo struct {
 	Parent *namedStruct
 	// The contents of the field.
 	Target visitableType
+	// The invariants declared by the field's walkabout tag.
+	Invariants []string
+}
+
+// fieldAccessor describes a generated method which returns a visitable
+// field by name.
+type fieldAccessor struct {
+	fieldInfo
+	// The name of the generated method which returns a shallow copy of
+	// the struct, in which the field has been replaced.
+	Builder string
+	// The name of the generated method.
+	Method string
+	// If true, the method returns a pointer to a struct- or array-typed
+	// field, so that the result refers to the field in place.
+	Ref bool
+	// Map is populated if the field is a map, in which case Value and
+	// ValueBuilder are the names of the generated methods which look up
+	// and replace a single entry of the map.
+	Map          *namedMapType
+	Value        string
+	ValueBuilder string
+}
+
+// getterInfo describes a method which returns a visitable type.
+type getterInfo struct {
+	Name string
+	// The value returned by the method.
+	Target visitableType
+}
+
+// String is codegen-safe.
+func (g getterInfo) String() string {
+	return g.Name
+}
+
+// fieldInvariant describes an invariant declared on a field.
+type fieldInvariant struct {
+	Field fieldInfo
+	// Name is the name of the invariant, e.g. "nonnil".
+	Name string
 }
 
 // String is codegen-safe.
 func (f fieldInfo) String() string {
 	return f.Name
 }
+
+// scalarType describes typ if it is a boolean, number, or string, or a
+// named type from the package being generated with such an underlying
+// type. The Name of the returned scalarField will be empty.
+func (v *visitation) scalarType(typ types.Type) (scalarField, bool) {
+	var basic *types.Basic
+	var ret scalarField
+	switch t := types.Unalias(typ).(type) {
+	case *types.Basic:
+		basic = t
+		ret.GoType = t.Name()
+	case *types.Named:
+		if t.Obj().Pkg() == nil || t.Obj().Pkg().Path() != v.packagePath {
+			return ret, false
+		}
+		basic, _ = t.Underlying().(*types.Basic)
+		ret.GoType = t.Obj().Name()
+		ret.Named = t.Obj().Name()
+	}
+	if basic == nil {
+		return ret, false
+	}
+	switch info := basic.Info(); {
+	case info&types.IsBoolean != 0:
+		ret.Kind, ret.WireType = "Bool", "bool"
+	case info&types.IsString != 0:
+		ret.Kind, ret.WireType = "String", "string"
+	case info&types.IsUnsigned != 0:
+		ret.Kind, ret.WireType = "Uint", "uint64"
+	case info&types.IsInteger != 0:
+		ret.Kind, ret.WireType = "Int", "int64"
+	case info&types.IsFloat != 0:
+		ret.Kind, ret.WireType = "Float", "float64"
+	default:
+		return ret, false
+	}
+	return ret, true
+}
+
+// namedString returns a codegen-safe representation of a named type
+// from the package being generated, including any type arguments.
+func namedString(n *types.Named) string {
+	args := n.TypeArgs()
+	if args.Len() == 0 {
+		return n.Obj().Name()
+	}
+	local := func(*types.Package) string { return "" }
+	parts := make([]string, args.Len())
+	for i := range parts {
+		parts[i] = types.TypeString(args.At(i), local)
+	}
+	return fmt.Sprintf("%s[%s]", n.Obj().Name(), strings.Join(parts, ", "))
+}
+
+// typeIdent returns an identifier-safe representation of a type which
+// is used as a type argument, following the same naming scheme as
+// type tokens:
+//   Optional[*Node] -> OptionalOfNodePtr
+//   Pair[Node, []Node] -> PairOfNodeAndNodeSlice
+// Only predeclared types, and types declared in the package being
+// generated, may be represented, since the generated code can refer to
+// no other packages. The boolean will be false for any other type.
+func (v *visitation) typeIdent(typ types.Type) (string, bool) {
+	switch t := types.Unalias(typ).(type) {
+	case *types.Array:
+		elem, ok := v.typeIdent(t.Elem())
+		return fmt.Sprintf("%sArray%d", elem, t.Len()), ok
+	case *types.Basic:
+		name := t.Name()
+		return strings.ToUpper(name[:1]) + name[1:], t.Kind() != types.UnsafePointer
+	case *types.Named:
+		ret := t.Obj().Name()
+		// Predeclared types, such as error, have no package.
+		ok := t.Obj().Pkg() == nil || t.Obj().Pkg().Path() == v.packagePath
+		for i := 0; i < t.TypeArgs().Len(); i++ {
+			if i == 0 {
+				ret += "Of"
+			} else {
+				ret += "And"
+			}
+			arg, argOK := v.typeIdent(t.TypeArgs().At(i))
+			ret += arg
+			ok = ok && argOK
+		}
+		return ret, ok
+	case *types.Pointer:
+		elem, ok := v.typeIdent(t.Elem())
+		return elem + "Ptr", ok
+	case *types.Slice:
+		elem, ok := v.typeIdent(t.Elem())
+		return elem + "Slice", ok
+	default:
+		return "", false
+	}
+}
@@ -18,20 +18,45 @@ package gen
 import (
 	"bytes"
 	"fmt"
+	"go/ast"
 	"go/format"
+	"go/parser"
+	"go/token"
 	"go/types"
 	"path"
 	"path/filepath"
 	"sort"
+	"strconv"
 	"strings"
 	"text/template"
 
 	"github.com/cockroachdb/walkabout/gen/templates"
 	"github.com/pkg/errors"
+	"golang.org/x/tools/go/ast/astutil"
 )
 
 var allTemplates = make(map[string]*template.Template)
 
+// headerTemplate is prepended to every output file.
+const headerTemplate = "00header"
+
+// unionOnlyTemplates are the only templates which will be executed
+// when --union-only is specified.
+var unionOnlyTemplates = map[string]bool{
+	headerTemplate: true,
+	"50union":      true,
+}
+
+// typemapOnlyTemplates are the only templates which will be executed
+// when --typemap-only is specified.
+var typemapOnlyTemplates = map[string]bool{
+	headerTemplate:  true,
+	"10typemaponly": true,
+	"50union":       true,
+	"75typemap":     true,
+	"76layout":      true,
+}
+
 // Register all templates to be generated.
 func init() {
 	for name, src := range templates.TemplateSources {
@@ -41,37 +66,132 @@ func init() {
 
 // implementor is returned by the Implementors function.
 type implementor struct {
-	Intf       namedInterfaceType
+	Intf       visitableType
 	Actual     visitableType
 	Underlying namedStruct
 }
 
+// implementors returns a sortable map of types which implement the
+// interface, or which have been declared as the concrete types of an
+// interface field.
+func implementors(t visitableType) map[string]implementor {
+	ret := make(map[string]implementor)
+	if c, ok := t.(concreteInterface); ok {
+		for _, s := range c.Concrete {
+			if types.Implements(s.Named, c.Interface) {
+				ret[s.String()] = implementor{c, s, s}
+			}
+			if types.Implements(types.NewPointer(s.Named), c.Interface) {
+				ret[s.String()+"*"] = implementor{c, pointerType{s}, s}
+			}
+		}
+		return ret
+	}
+	return interfaceImplementors(t.(namedInterfaceType))
+}
+
+// interfaceImplementors returns a sortable map of types which
+// implement the interface.
+func interfaceImplementors(t namedInterfaceType) map[string]implementor {
+	ret := make(map[string]implementor)
+	isUnion := t.Union != "" && t.Union == t.Visitation().Root.Union
+	for _, typ := range t.Visitation().Types {
+		if s, ok := typ.(namedStruct); ok {
+			if !isUnion && types.Implements(s.Named, t.Interface) {
+				ret[s.String()] = implementor{t, s, s}
+			}
+			if isUnion || types.Implements(types.NewPointer(s.Named), t.Interface) {
+				p := pointerType{s}
+				ret[s.String()+"*"] = implementor{t, p, s}
+			}
+		}
+	}
+	return ret
+}
+
+// receiver groups the struct types which share the receiver of their
+// generated methods. A generic type has a single receiver, which is
+// shared by each of its instantiations.
+type receiver struct {
+	// Decl is the type expression used as the receiver.
+	Decl    string
+	Structs []namedStruct
+}
+
+// Generic returns true if the receiver is a generic type.
+func (r receiver) Generic() bool {
+	return r.Structs[0].Generic()
+}
+
+// Single returns the struct type of a non-generic receiver.
+func (r receiver) Single() namedStruct {
+	return r.Structs[0]
+}
+
+// String is codegen-safe.
+func (r receiver) String() string {
+	return r.Decl
+}
+
 // funcMap contains a map of functions that can be called from within
 // the templates.
 var funcMap = template.FuncMap{
-	// Implementors returns a sortable map of types which implement
-	// the interface.
-	"Implementors": func(t namedInterfaceType) map[string]implementor {
-		ret := make(map[string]implementor)
-		isUnion := t.Union != "" && t.Union == t.Visitation().Root.Union
-		for _, typ := range t.Visitation().Types {
-			if s, ok := typ.(namedStruct); ok {
-				if !isUnion && types.Implements(s.Named, t.Interface) {
-					ret[s.String()] = implementor{t, s, s}
-				}
-				if isUnion || types.Implements(types.NewPointer(s.Named), t.Interface) {
-					p := pointerType{s}
-					ret[s.String()+"*"] = implementor{t, p, s}
-				}
+	// Arrays returns a sortable map of all array types used.
+	"Arrays": func(v *visitation) map[string]namedArrayType {
+		ret := make(map[string]namedArrayType)
+		for _, t := range v.Types {
+			if s, ok := t.Implementation().(namedArrayType); ok {
+				ret[s.String()] = s
 			}
 		}
 		return ret
 	},
-	// Intfs returns a sortable map of all interface types used.
-	"Intfs": func(v *visitation) map[string]namedInterfaceType {
+	// BuildConstraint returns the expression to use in a //go:build line,
+	// or an empty string if the generated code is unconstrained.
+	"BuildConstraint": func(v *visitation) string { return v.buildConstraint() },
+	// Engine returns an expression which evaluates to the engine. When
+	// the engine is constructed lazily, this is a call to its accessor.
+	"Engine": func(v *visitation) string {
+		if v.gen.lazyEngine {
+			return v.engineAccessor() + "()"
+		}
+		return v.engineVar()
+	},
+	// EngineAccessor returns the name of the function which constructs
+	// the engine on first use.
+	"EngineAccessor": func(v *visitation) string { return v.engineAccessor() },
+	// EngineVar returns the name of the variable which holds the engine.
+	"EngineVar": func(v *visitation) string { return v.engineVar() },
+	// GoAtLeast returns true if the generated code may use features
+	// introduced in Go 1.minor.
+	"GoAtLeast": func(v *visitation, minor int) bool { return v.gen.goMinor >= minor },
+	// Implemented returns a sortable map of the visitable interfaces
+	// which a struct type implements, either by value or by reference.
+	"Implemented": func(s namedStruct) map[string]namedInterfaceType {
 		ret := make(map[string]namedInterfaceType)
+		for _, t := range s.Visitation().Types {
+			if intf, ok := t.Implementation().(namedInterfaceType); ok {
+				for _, imp := range implementors(intf) {
+					if imp.Underlying.String() == s.String() {
+						ret[intf.String()] = intf
+					}
+				}
+			}
+		}
+		return ret
+	},
+	// Implementors returns a sortable map of types which implement
+	// the interface.
+	"Implementors": implementors,
+	// Intfs returns a sortable map of all interface types used,
+	// including interface fields with declared concrete types.
+	"Intfs": func(v *visitation) map[string]visitableType {
+		ret := make(map[string]visitableType)
 		for _, t := range v.Types {
-			if s, ok := t.Implementation().(namedInterfaceType); ok {
+			switch s := t.Implementation().(type) {
+			case concreteInterface:
+				ret[s.Ident()] = s
+			case namedInterfaceType:
 				ret[s.String()] = s
 			}
 		}
@@ -91,6 +211,19 @@ var funcMap = template.FuncMap{
 			}
 		}
 	},
+	// LazyEngine returns true if the engine should be constructed on
+	// first use, rather than when the package is initialized.
+	"LazyEngine": func(v *visitation) bool { return v.gen.lazyEngine },
+	// Maps returns a sortable map of all map types used.
+	"Maps": func(v *visitation) map[string]namedMapType {
+		ret := make(map[string]namedMapType)
+		for _, t := range v.Types {
+			if m, ok := t.Implementation().(namedMapType); ok {
+				ret[m.String()] = m
+			}
+		}
+		return ret
+	},
 	// Package returns the name of the package we're working in.
 	"Package": func(v *visitation) string { return path.Base(v.packagePath) },
 	// Pointers returns a sortable map of all pointer types used.
@@ -103,6 +236,25 @@ var funcMap = template.FuncMap{
 		}
 		return ret
 	},
+	// Receivers returns a sortable map of the receivers of the methods
+	// generated for struct types.
+	"Receivers": func(v *visitation) map[string]receiver {
+		ret := make(map[string]receiver)
+		for _, t := range v.Types {
+			if s, ok := t.Implementation().(namedStruct); ok {
+				r := ret[s.Receiver()]
+				r.Decl = s.Receiver()
+				r.Structs = append(r.Structs, s)
+				ret[r.Decl] = r
+			}
+		}
+		for _, r := range ret {
+			sort.Slice(r.Structs, func(i, j int) bool {
+				return r.Structs[i].String() < r.Structs[j].String()
+			})
+		}
+		return ret
+	},
 	// Slices returns a sortable map of all slice types used.
 	"Slices": func(v *visitation) map[string]namedSliceType {
 		ret := make(map[string]namedSliceType)
@@ -130,6 +282,23 @@ var funcMap = template.FuncMap{
 		}
 		return ret
 	},
+	// ValueFacade returns true if callbacks should receive the struct
+	// by value, rather than by reference. This requires that the struct
+	// implement the visitable interface with value receivers. Such
+	// structs are also stored in interfaces by value when they are
+	// replaced, so that a replacement has the same form as the value
+	// passed to the callback.
+	"ValueFacade": func(t visitableType) bool {
+		s, ok := t.(namedStruct)
+		if !ok {
+			return false
+		}
+		v := s.Visitation()
+		if !v.gen.valueFacades || v.Root.Union != "" {
+			return false
+		}
+		return types.Implements(s.Named, v.Root.Interface)
+	},
 	// t returns an un-exported named based on the visitable interface name.
 	"t": func(v *visitation, name string) string {
 		intfName := v.Root.String()
@@ -143,43 +312,144 @@ var funcMap = template.FuncMap{
 	"TypeID": func(t visitableType) TypeID {
 		return t.Visitation().ensureTypeID(t)
 	},
+	// TypeIDLimit returns a value which is one greater than the largest
+	// type token which has been assigned.
+	"TypeIDLimit": func(v *visitation) int {
+		_, ret := v.assignTypeIDs()
+		return ret
+	},
+	// TypeIDs returns the values of the type tokens, ordered by value.
+	"TypeIDs": func(v *visitation) []typeIDValue {
+		ret, _ := v.assignTypeIDs()
+		return ret
+	},
+	// TypemapOnly returns true if only the type map and its exported
+	// helpers should be generated.
+	"TypemapOnly": func(v *visitation) bool { return v.gen.typemapOnly },
+	// UnionOnly returns true if only the union interface should be
+	// generated.
+	"UnionOnly": func(v *visitation) bool { return v.gen.unionOnly },
+	// ValueMethods returns true if the read-only abstract accessor
+	// methods should be generated with value receivers.
+	"ValueMethods": func(v *visitation) bool { return v.gen.valueMethods },
+	// VerifyLayout returns true if the layout of each visitable struct
+	// should be checked when the package is initialized.
+	"VerifyLayout": func(v *visitation) bool { return v.gen.verifyLayout },
+	// Visitor returns the name of the go/ast-style visitor interface to
+	// generate an adapter for, or an empty string.
+	"Visitor": func(v *visitation) string { return v.Visitor },
 }
 
 // generateAPI is the main code-generation function. It evaluates
-// the embedded template and then calls go/format on the resulting
-// code.
+// the embedded templates and then calls go/format on the resulting
+// code. If --split is specified, each template will be written into
+// its own file.
 func (v *visitation) generateAPI() error {
 
 	// Parse each template and sort the keys.
 	sorted := make([]string, 0, len(allTemplates))
-	var err error
 	for key := range allTemplates {
+		if key == headerTemplate {
+			continue
+		}
+		if v.gen.unionOnly && !unionOnlyTemplates[key] {
+			continue
+		}
+		if v.gen.typemapOnly && !typemapOnlyTemplates[key] {
+			continue
+		}
 		sorted = append(sorted, key)
 	}
 	sort.Strings(sorted)
 
-	// Execute each template in sorted order.
-	var buf bytes.Buffer
+	var header bytes.Buffer
+	if err := allTemplates[headerTemplate].ExecuteTemplate(&header, headerTemplate, v); err != nil {
+		return errors.Wrap(err, headerTemplate)
+	}
+
+	if !v.gen.split {
+		// Execute each template in sorted order.
+		buf := bytes.NewBuffer(header.Bytes())
+		for _, key := range sorted {
+			if err := allTemplates[key].ExecuteTemplate(buf, key, v); err != nil {
+				return errors.Wrap(err, key)
+			}
+		}
+		return v.writeFile(v.outName(""), buf.Bytes())
+	}
+
+	// Execute each template in sorted order, since later templates
+	// depend upon TypeIDs being registered by earlier ones.
 	for _, key := range sorted {
+		var buf bytes.Buffer
 		if err := allTemplates[key].ExecuteTemplate(&buf, key, v); err != nil {
 			return errors.Wrap(err, key)
 		}
+		// Don't emit files for templates which aren't applicable.
+		if len(bytes.TrimSpace(buf.Bytes())) == 0 {
+			continue
+		}
+		src := append(append([]byte{}, header.Bytes()...), buf.Bytes()...)
+		if err := v.writeFile(v.outName(strings.TrimLeft(key, "0123456789")), src); err != nil {
+			return errors.Wrap(err, key)
+		}
 	}
+	return nil
+}
 
-	formatted, err := format.Source(buf.Bytes())
-	if err != nil {
-		println(buf.String())
-		return err
+// outName returns the name of the file to write. The concern will be
+// non-empty when --split is used. A relative --out is resolved against
+// the package directory, as are the names in an overlay.
+func (v *visitation) outName(concern string) string {
+	if v.gen.outFile != "" {
+		if filepath.IsAbs(v.gen.outFile) {
+			return v.gen.outFile
+		}
+		return filepath.Join(v.gen.dir, v.gen.outFile)
 	}
+	return v.defaultOutName(concern)
+}
+
+// defaultOutName returns the name of the file to write, if --out has
+// not been specified.
+func (v *visitation) defaultOutName(concern string) string {
+	// The suffix is split at its first dot, so that the platform and
+	// test elements may be inserted around the extension.
+	suffix := v.gen.suffix
+	if suffix == "" {
+		suffix = "_walkabout.g.go"
+	}
+	dot := strings.IndexByte(suffix, '.')
+	stem, ext := suffix[:dot], strings.TrimSuffix(suffix[dot:], ".go")
 
-	outName := v.gen.outFile
-	if outName == "" {
-		outName = strings.ToLower(v.Root.String()) + "_walkabout.g"
-		if v.inTest {
-			outName += "_test"
+	outName := strings.ToLower(v.Root.String())
+	if concern == "" {
+		outName += stem
+	} else {
+		outName += "_" + concern
+	}
+	// Keep the outputs for different platforms from colliding. The
+	// ".g" suffix prevents the go tool from interpreting the platform
+	// as an implicit build constraint, so the header provides one.
+	for _, platform := range []string{v.gen.goos, v.gen.goarch} {
+		if platform != "" {
+			outName += "_" + platform
 		}
-		outName += ".go"
-		outName = filepath.Join(v.gen.dir, outName)
+	}
+	outName += ext
+	if v.inTest {
+		outName += "_test"
+	}
+	outName += ".go"
+	return filepath.Join(v.gen.dir, outName)
+}
+
+// writeFile formats the source and writes it to the named file.
+func (v *visitation) writeFile(outName string, src []byte) error {
+	formatted, err := formatSource(src)
+	if err != nil {
+		println(string(src))
+		return err
 	}
 
 	out, err := v.gen.writeCloser(outName)
@@ -193,3 +463,47 @@ func (v *visitation) generateAPI() error {
 	}
 	return err
 }
+
+// formatSource calls go/format on the source, after removing any
+// imports which aren't referenced. This allows the header template to
+// import all packages that any of the other templates may require.
+func formatSource(src []byte) ([]byte, error) {
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, "", src, parser.ParseComments)
+	if err != nil {
+		return nil, err
+	}
+
+	// Unresolved identifiers used as selectors are package references.
+	used := make(map[string]bool)
+	ast.Inspect(file, func(n ast.Node) bool {
+		if sel, ok := n.(*ast.SelectorExpr); ok {
+			if id, ok := sel.X.(*ast.Ident); ok && id.Obj == nil {
+				used[id.Name] = true
+			}
+		}
+		return true
+	})
+
+	// Copy the imports, since deleting an import mutates the slice.
+	imports := append([]*ast.ImportSpec(nil), file.Imports...)
+	for _, imp := range imports {
+		importPath, err := strconv.Unquote(imp.Path.Value)
+		if err != nil {
+			return nil, err
+		}
+		if imp.Name == nil {
+			if !used[path.Base(importPath)] {
+				astutil.DeleteImport(fset, file, importPath)
+			}
+		} else if !used[imp.Name.Name] {
+			astutil.DeleteNamedImport(fset, file, imp.Name.Name, importPath)
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := format.Node(&buf, fset, file); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
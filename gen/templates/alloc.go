@@ -0,0 +1,56 @@
+// Copyright 2019 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+package templates
+
+func init() {
+	TemplateSources["60alloc"] = `
+{{- $v := . -}}
+{{- $Allocator := T $v "Allocator" -}}
+{{- $identify := t $v "Identify" -}}
+{{- $Root := $v.Root -}}
+{{- $TypeID := T $v "TypeID" -}}
+{{- $WalkerFn := T $v "WalkerFn" -}}
+
+// ------ Allocation ------
+
+// {{ $Allocator }} returns a value of the given struct type, whose
+// contents will be overwritten. It may return nil to allocate a new
+// struct as usual.
+type {{ $Allocator }} func(id {{ $TypeID }}) {{ $Root }}
+
+// Walk{{ $Root }}Alloc visits x with the provided callback. Whenever a
+// struct must be copied in order to replace one of its children, the
+// memory for the copy is obtained from alloc. This allows callers
+// which perform many rewrites to reuse structs which they know to be
+// unreferenced, e.g. from a previous result which has been discarded.
+// The allocator will be called with a lock held if the callback
+// requests parallel visitation, and it will panic if alloc returns a
+// value of a different type.
+func Walk{{ $Root }}Alloc(x {{ $Root }}, alloc {{ $Allocator }}, fn {{ $WalkerFn }}) (_ {{ $Root }}, changed bool, err error) {
+	return walk{{ $Root }}(x, fn, e.WithAllocator(func(want e.TypeID) e.Ptr {
+		y := alloc({{ $TypeID }}(want))
+		if y == nil {
+			return nil
+		}
+		got, ptr := {{ $identify }}(y)
+		if got != want {
+			panic(fmt.Sprintf("allocator returned %T for %s", y, {{ $TypeID }}(want)))
+		}
+		return ptr
+	}))
+}
+`
+}
@@ -21,19 +21,38 @@ func init() {
 {{- $Abstract := T $v "Abstract" -}}
 {{- $Action := T $v "Action" -}}
 {{- $ChildAt := T $v "At" -}}
+{{- $ChildNamed := T $v "Named" -}}
 {{- $Context := T $v "Context" -}}
 {{- $Decision := T $v "Decision" -}}
+{{- $EachChild := T $v "Each" -}}
+{{- $FieldNameAt := T $v "FieldNameAt" -}}
 {{- $identify := t $v "Identify" -}}
+{{- $identifiers := t $v "Identifiers" -}}
 {{- $NumChildren := T $v "Count" -}}
+{{- $Path := T $v "Path" -}}
+{{- $PathSegment := T $v "PathSegment" -}}
 {{- $Root := $v.Root -}}
 {{- $TypeID := T $v "TypeID" -}}
+{{- $Walk := T $v "Walk" -}}
 {{- $WalkerFn := T $v "WalkerFn" -}}
 {{- $wrap := t $v "Wrap" -}}
+{{- $wrappers := t $v "Wrappers" -}}
 // ------ API and public types ------
 
 // {{ $TypeID }} is a lightweight type token.
 type {{ $TypeID }} e.TypeID
 
+// {{ $Root }}Implementors returns the type tokens of all struct types
+// which implement {{ $Root }}. The returned slice may be modified by
+// the caller.
+func {{ $Root }}Implementors() []{{ $TypeID }} {
+	return []{{ $TypeID }}{
+		{{- range $s := Structs $v }}
+		{{ TypeID $s }},
+		{{- end }}
+	}
+}
+
 // {{ $Abstract }} allows users to treat a {{ $Root }} as an abstract
 // tree of nodes. All visitable struct types will have generated methods
 // which implement this interface. 
@@ -45,11 +64,30 @@ type {{ $Abstract }} interface {
 	// is non-nil. If the child is a slice type, a {{ $Abstract }} wrapper
 	// around the slice will be returned.
 	{{ $ChildAt }}(index int) {{ $Abstract }}
+	// {{ $ChildNamed }} returns the named field of a struct, following
+	// the same rules as {{ $ChildAt }}. It returns nil if the value is
+	// not a struct or has no visitable field of that name. Since the
+	// fields are scanned linearly, it should not be used in hot loops.
+	{{ $ChildNamed }}(name string) {{ $Abstract }}
+	// {{ $EachChild }} invokes fn with each child, following the same
+	// rules as {{ $ChildAt }}, until fn returns false. The children of
+	// slices and arrays are presented through a reused wrapper, so
+	// the child must not be retained after fn returns.
+	{{ $EachChild }}(fn func(index int, child {{ $Abstract }}) bool)
+	// {{ $FieldNameAt }} returns a label for the child at the given
+	// index: the name of a struct field, the name of a getter followed
+	// by "()", the bracketed index of a slice element, or the bracketed
+	// key of a map entry. It panics if the index is out of range.
+	{{ $FieldNameAt }}(index int) string
 	// {{ $NumChildren }} returns the number of visitable fields in a struct,
 	// or the length of a slice.
 	{{ $NumChildren }}() int
 	// {{ $TypeID }} returns a type token.
 	{{ $TypeID }}() {{ $TypeID }}
+	// {{ $Walk }} visits the value with the provided callback and
+	// returns a {{ $Abstract }} around the updated value. The updated
+	// value will always be of the same type as the original value.
+	{{ $Walk }}(fn {{ $WalkerFn }}) (_ {{ $Abstract }}, changed bool, err error)
 }
 
 var (
@@ -99,6 +137,13 @@ func (c *{{ $Context }}) Continue() {{ $Decision }} {
 	return {{ $Decision }}(c.impl.Continue())
 }
 
+// Depth returns the number of visitable structs which enclose the
+// value being visited. The value passed to a Walk function has a
+// depth of zero.
+func (c *{{ $Context }}) Depth() int {
+	return c.impl.Depth()
+}
+
 // Error returns a {{ $Decision }} which will cause the given error
 // to be returned from the Walk() function. Post-visit functions
 // will not be called.
@@ -112,12 +157,83 @@ func (c *{{ $Context }}) Halt() {{ $Decision }} {
 	return {{ $Decision }}(c.impl.Halt())
 }
 
+// Parallel will visit the fields of the current object concurrently,
+// each on its own goroutine. A field which is a slice or array will
+// have its elements visited concurrently as well. The visitor may
+// therefore be called from multiple goroutines at once, and the order
+// of any side-effects is not guaranteed. No more than GOMAXPROCS
+// goroutines are forked by a single walk; once they are all busy, the
+// remaining values are visited without forking. Halting or restarting while
+// visiting one field will not affect the visitation of the others.
+// Replacements are applied once all of the fields have been visited.
+// Parallel is ignored by Walk{{ $Root }}Memo and Walk{{ $Root }}Once.
+func (c *{{ $Context }}) Parallel() {{ $Decision }} {
+	return {{ $Decision }}(c.impl.Parallel())
+}
+
+// Parent returns the nearest visitable struct which encloses the value
+// being visited. It returns false for the value passed to a Walk
+// function. Since the enclosing values are only rebuilt once all of
+// their children have been visited, this is always the original
+// parent, without any replacements made by the current visitation.
+func (c *{{ $Context }}) Parent() ({{ $Root }}, bool) {
+	id, ptr := c.impl.Parent()
+	if id == 0 {
+		return nil, false
+	}
+	return {{ $wrap }}(id, ptr), true
+}
+
+// Path returns the location of the value being visited, relative to
+// the value passed to the function which started the visitation. The
+// path is constructed on demand.
+func (c *{{ $Context }}) Path() {{ $Path }} {
+	return c.impl.Path()
+}
+
+// ReplaceChildren will perform the given actions in place of visiting
+// the elements of a slice and will then replace the elements with the
+// values visited by the actions. This allows elements to be inserted
+// or removed. It may only be returned for a struct whose only
+// visitable field is a slice; any other struct will cause the walk
+// to return an error. Actions which invoke a callback do not
+// contribute an element, and an empty list of actions will leave the
+// slice empty.
+func (c *{{ $Context }}) ReplaceChildren(actions ...{{ $Action }}) {{ $Decision }} {
+	ret := make([]e.Action, len(actions))
+	for i, a := range actions {
+		ret[i] = e.Action(a)
+	}
+
+	return {{ $Decision }}(c.impl.ReplaceChildren(ret))
+}
 
 // Skip will not traverse the fields of the current object.
 func (c *{{ $Context }}) Skip() {{ $Decision }} {
 	return {{ $Decision }}(c.impl.Skip())
 }
 
+// State returns the value passed to Walk{{ $Root }}State or
+// Walk{{ $Root }}With, or nil if the visitation was started by another
+// function.
+func (c *{{ $Context }}) State() interface{} {
+	return c.impl.State()
+}
+
+// Value returns the value passed to Walk{{ $Root }}With. It is an
+// alias for State.
+func (c *{{ $Context }}) Value() interface{} {
+	return c.impl.State()
+}
+
+// {{ $Path }} describes the location of a value, relative to the root
+// of a visitation. Pointers and interfaces do not contribute segments.
+type {{ $Path }} = e.Path
+
+// {{ $PathSegment }} describes a step from a value to a struct field,
+// a slice or array element, or a map entry.
+type {{ $PathSegment }} = e.PathSegment
+
 // {{ $Decision }} is used by {{ $WalkerFn }} to control visitation.
 // The {{ $Context }} provided to a {{ $WalkerFn }} acts as a factory
 // for {{ $Decision }} instances. In general, the factory methods
@@ -131,9 +247,19 @@ func (d {{ $Decision }}) Intercept(fn {{ $WalkerFn }}) {{ $Decision }} {
 	return {{ $Decision }}((e.Decision)(d).Intercept(fn))
 }
 
+// InterceptNamed is like Intercept, except that the function also
+// receives the location of each value relative to the current value,
+// e.g. "Args[1]" for an element of a slice-valued field.
+func (d {{ $Decision }}) InterceptNamed(fn func(ctx {{ $Context }}, name string, x {{ $Root }}) {{ $Decision }}) {{ $Decision }} {
+	return {{ $Decision }}((e.Decision)(d).InterceptNamed({{ $WalkerFn }}(func(ctx {{ $Context }}, x {{ $Root }}) {{ $Decision }} {
+		return fn(ctx, ctx.impl.Intercepted().String(), x)
+	})))
+}
+
 // Post registers a post-visit function, which will be called after the
-// fields of the current object. The function can make another decision
-// about the current value.
+// fields of the current object. The function will see any replacements
+// made to the fields, and it can make another decision about the
+// current value.
 func (d {{ $Decision }}) Post(fn {{ $WalkerFn }}) {{ $Decision }} {
 	return {{ $Decision }}((e.Decision)(d).Post(fn))
 }
@@ -144,40 +270,90 @@ func (d {{ $Decision }}) Replace(x {{ $Root }}) {{ $Decision }} {
 	return {{ $Decision }}((e.Decision)(d).Replace({{ $identify }}(x)))
 }
 
+// ReplaceInPlace overwrites the currently-visited value with x, which
+// must be of the same type. Unlike Replace, parent nodes are not cloned,
+// so the change will be visible to every holder of the value. An error
+// will be returned if the value is not stored in mutable memory, such
+// as when it is held by value in an interface or returned by a getter.
+func (d {{ $Decision }}) ReplaceInPlace(x {{ $Root }}) {{ $Decision }} {
+	return {{ $Decision }}((e.Decision)(d).ReplaceInPlace({{ $identify }}(x)))
+}
+
+// ReplaceWith replaces the slice element which holds the current value
+// with any number of values, which must not be nil. Providing no values
+// removes the element. The fields of the current value, and of the
+// replacements, will not be visited. The walk will return an error
+// unless the current value is an element of a slice, or is referred to
+// by one through pointers or interfaces.
+func (d {{ $Decision }}) ReplaceWith(xs ...{{ $Root }}) {{ $Decision }} {
+	ids := make([]e.TypeID, len(xs))
+	ptrs := make([]e.Ptr, len(xs))
+	for i, x := range xs {
+		ids[i], ptrs[i] = {{ $identify }}(x)
+	}
+	return {{ $Decision }}((e.Decision)(d).ReplaceWith(ids, ptrs))
+}
+
+// Restart may be combined with Replace to abandon the visitation once
+// the value has been replaced and to visit the updated top-level value
+// again from the beginning. This is useful when a replacement
+// invalidates decisions which were made about enclosing values. Pending
+// post-visit functions will not be called. Restart has no effect unless
+// the value is replaced, or if the visitation is halted. An error will
+// be returned if a visitation is restarted more than 1000 times.
+func (d {{ $Decision }}) Restart() {{ $Decision }} {
+	return {{ $Decision }}((e.Decision)(d).Restart())
+}
+
 // {{ $identify }} is a utility function to map a {{ $Root }} into
-// its generated type id and a pointer to the data. 
+// its generated type id and a pointer to the data. The type is found
+// in a table, rather than by a type switch, so the cost does not grow
+// with the number of implementations.
 func {{ $identify }}(x {{ $Root }}) (typeId e.TypeID, data e.Ptr) {
-	switch t := x.(type) {
-		{{ range $imp := Implementors $Root -}}
-		case {{ $imp.Actual }}:
-			typeId = e.TypeID({{ TypeID $imp.Underlying }});
-			{{ if IsPointer $imp.Actual }}data = e.Ptr(t);
-			{{ else }}data = e.Ptr(&t);
-			{{ end }}
-		{{- end -}}
-		default:
-			// The most probable reason for this is that the generated code
-			// is out of date, or that an implementation of the {{ $Root }}
-			// interface from another package is being passed in.
-			panic(fmt.Sprintf("unhandled value of type: %T", x))
+	fn, ok := {{ $identifiers }}[reflect.TypeOf(x)]
+	if !ok {
+		// The most probable reason for this is that the generated code
+		// is out of date, or that an implementation of the {{ $Root }}
+		// interface from another package is being passed in.
+		panic(fmt.Sprintf("unhandled value of type: %T", x))
 	}
-	return
+	return fn(x)
+}
+
+// {{ $identifiers }} maps the dynamic type of a {{ $Root }} to a
+// function which returns its type token and a pointer to its data.
+var {{ $identifiers }} = map[reflect.Type]func(x {{ $Root }}) (e.TypeID, e.Ptr){
+	{{ range $imp := Implementors $Root -}}
+	reflect.TypeOf({{ if IsPointer $imp.Actual }}({{ $imp.Actual }})(nil){{ else }}(*{{ $imp.Actual }})(nil)).Elem({{ end }}): func(x {{ $Root }}) (e.TypeID, e.Ptr) {
+		{{ if IsPointer $imp.Actual }}return e.TypeID({{ TypeID $imp.Underlying }}), e.Ptr(x.({{ $imp.Actual }}))
+		{{ else }}t := x.({{ $imp.Actual }})
+		return e.TypeID({{ TypeID $imp.Underlying }}), e.Ptr(&t)
+		{{ end -}}
+	},
+	{{ end -}}
 }
 
 // {{ $wrap }} is a utility function to reconstitute a {{ $Root }}
 // from an internal type token and a pointer to the value.
 func {{ $wrap }}(typeId e.TypeID, x e.Ptr) {{ $Root }} {
-	switch {{ $TypeID }}(typeId) {
-	{{ range $imp := Implementors $Root -}}
-		{{- if IsPointer $imp.Actual -}}
-			case {{ TypeID $imp.Actual.Elem }}: return (*{{ $imp.Actual.Elem }})(x);
-			case {{ TypeID $imp.Actual }}: return *(*{{ $imp.Actual }})(x);
-		{{- end -}}
-	{{- end }}
-	default:
-		// This is likely a code-generation problem.
-		panic(fmt.Sprintf("unhandled TypeID %d", typeId))
+	if typeId >= 0 && int(typeId) < len({{ $wrappers }}) {
+		if fn := {{ $wrappers }}[typeId]; fn != nil {
+			return fn(x)
+		}
 	}
+	// This is likely a code-generation problem.
+	panic(fmt.Sprintf("unhandled TypeID %d", typeId))
+}
+
+// {{ $wrappers }} holds a function for each type token which
+// reconstitutes a {{ $Root }} from a pointer to the value.
+var {{ $wrappers }} = []func(x e.Ptr) {{ $Root }}{
+	{{- range $imp := Implementors $Root }}
+		{{- if IsPointer $imp.Actual }}
+	{{ TypeID $imp.Actual.Elem }}: func(x e.Ptr) {{ $Root }} { return (*{{ $imp.Actual.Elem }})(x) },
+	{{ TypeID $imp.Actual }}: func(x e.Ptr) {{ $Root }} { return *(*{{ $imp.Actual }})(x) },
+		{{- end }}
+	{{- end }}
 }
 
 // {{ $Action }} is used by {{ $Context }}.Actions() and allows users
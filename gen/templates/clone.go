@@ -0,0 +1,53 @@
+// Copyright 2019 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+package templates
+
+func init() {
+	TemplateSources["50clone"] = `
+{{- $v := . -}}
+{{- $Context := T $v "Context" -}}
+{{- $Decision := T $v "Decision" -}}
+{{- $Engine := Engine $v -}}
+{{- $Root := $v.Root -}}
+{{- $TypeID := T $v "TypeID" -}}
+{{- $WalkerFn := T $v "WalkerFn" -}}
+
+// ------ Cloning ------
+{{ range $r := Receivers $v }}
+{{- $id := printf "x.%s()" $TypeID }}
+{{- if not $r.Generic }}{{ $id = TypeID $r.Single }}{{ end }}
+// Clone{{ $Root }} returns a deep copy of the receiver, which will not
+// share any visitable memory with it, except for back-references which
+// form cycles and values registered with Set{{ $Root }}Interned.
+// Non-visitable fields are copied shallowly. A nil receiver returns nil.
+func (x *{{ $r }}) Clone{{ $Root }}() *{{ $r }} {
+	if x == nil {
+		return nil
+	}
+	fn := {{ $WalkerFn }}(func(ctx {{ $Context }}, _ {{ $Root }}) {{ $Decision }} {
+		return ctx.Continue()
+	})
+	_, y, _, err := {{ $Engine }}.Execute(fn, e.TypeID({{ $id }}), e.Ptr(x), e.TypeID({{ $id }}), e.WithRebuild())
+	if err != nil {
+		// The callback makes no changes, so this indicates a problem
+		// with code-generation.
+		panic(err)
+	}
+	return (*{{ $r }})(y)
+}
+{{ end }}
+`
+}
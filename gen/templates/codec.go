@@ -0,0 +1,227 @@
+// Copyright 2019 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+package templates
+
+func init() {
+	TemplateSources["60codec"] = `
+{{- $v := . -}}
+{{- $decoder := t $v "Decoder" -}}
+{{- $encoder := t $v "Encoder" -}}
+{{- $identify := t $v "Identify" -}}
+{{- $Root := $v.Root -}}
+{{- $TypeID := T $v "TypeID" -}}
+
+// ------ Binary Encoding ------
+
+// {{ $encoder }} writes visitable values by delegating to the engine.
+type {{ $encoder }} struct {
+	*e.Encoder
+}
+
+// {{ $decoder }} reads visitable values by delegating to the engine.
+type {{ $decoder }} struct {
+	*e.Decoder
+}
+
+// Encode{{ $Root }} writes root, and all of the visitable values which
+// are reachable from it, to w in a compact binary format. The exported
+// boolean, numeric, and string fields of each struct are also written;
+// any other non-visitable fields are not. Values which are referred to
+// by multiple pointers, including cyclical references, are only written
+// once. The encoding depends upon the generated type tokens, so it
+// should only be decoded by the same generated code.
+func Encode{{ $Root }}(w io.Writer, root {{ $Root }}) error {
+	enc := {{ $encoder }}{e.NewEncoder(w)}
+	enc.encode{{ TypeID $Root }}(e.Ptr(&root))
+	return enc.Flush()
+}
+
+// Decode{{ $Root }} reads a value written by Encode{{ $Root }}.
+func Decode{{ $Root }}(r io.Reader) ({{ $Root }}, error) {
+	dec := {{ $decoder }}{e.NewDecoder(r)}
+	var ret {{ $Root }}
+	dec.decode{{ TypeID $Root }}(e.Ptr(&ret))
+	if err := dec.Err(); err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+{{- /* Structs must come first, since they register field types. */ -}}
+{{ range $s := Structs $v }}
+func (enc {{ $encoder }}) encode{{ TypeID $s }}(x e.Ptr) {
+	{{- if or $s.ScalarFields $s.Fields }}
+	s := (*{{ $s }})(x)
+	{{- end }}
+	{{- range $f := $s.ScalarFields }}
+	enc.Write{{ $f.Kind }}({{ $f.WireType }}(s.{{ $f.Name }}))
+	{{- end }}
+	{{- range $f := $s.Fields }}
+	enc.encode{{ TypeID $f.Target }}(e.Ptr(&s.{{ $f }}))
+	{{- end }}
+}
+
+func (dec {{ $decoder }}) decode{{ TypeID $s }}(x e.Ptr) {
+	{{- if or $s.ScalarFields $s.Fields }}
+	s := (*{{ $s }})(x)
+	{{- end }}
+	{{- range $f := $s.ScalarFields }}
+	s.{{ $f.Name }} = {{ $f.GoType }}(dec.Read{{ $f.Kind }}())
+	{{- end }}
+	{{- range $f := $s.Fields }}
+	dec.decode{{ TypeID $f.Target }}(e.Ptr(&s.{{ $f }}))
+	{{- end }}
+}
+{{ end }}
+
+{{ range $s := Intfs $v }}
+func (enc {{ $encoder }}) encode{{ TypeID $s }}(x e.Ptr) {
+	switch t := (*(*{{ $s }})(x)).(type) {
+	case nil:
+		enc.WriteUint(0)
+	{{- range $imp := Implementors $s }}
+	case {{ $imp.Actual }}:
+		enc.WriteUint(uint64({{ TypeID $imp.Actual }}))
+		enc.encode{{ TypeID $imp.Actual }}(e.Ptr(&t))
+	{{- end }}
+	default:
+		panic(fmt.Sprintf("unhandled value of type: %T", t))
+	}
+}
+
+func (dec {{ $decoder }}) decode{{ TypeID $s }}(x e.Ptr) {
+	switch id := {{ $TypeID }}(dec.ReadUint()); id {
+	case 0:
+		*(*{{ $s }})(x) = nil
+	{{- range $imp := Implementors $s }}
+	case {{ TypeID $imp.Actual }}:
+		var t {{ $imp.Actual }}
+		dec.decode{{ TypeID $imp.Actual }}(e.Ptr(&t))
+		*(*{{ $s }})(x) = t
+	{{- end }}
+	default:
+		dec.Fail(fmt.Errorf("unexpected type token %d for {{ $s }}", id))
+	}
+}
+{{ end }}
+
+{{ range $s := Pointers $v }}
+func (enc {{ $encoder }}) encode{{ TypeID $s }}(x e.Ptr) {
+	p := *(*{{ $s }})(x)
+	if enc.WriteRef(e.TypeID({{ TypeID $s }}), e.Ptr(p)) {
+		enc.encode{{ TypeID $s.Elem }}(e.Ptr(p))
+	}
+}
+
+func (dec {{ $decoder }}) decode{{ TypeID $s }}(x e.Ptr) {
+	p, isNew := dec.ReadRef()
+	if isNew {
+		p = e.Ptr(new({{ $s.Elem }}))
+		dec.AddRef(p)
+		dec.decode{{ TypeID $s.Elem }}(p)
+	}
+	*(*{{ $s }})(x) = ({{ $s }})(p)
+}
+{{ end }}
+
+{{ range $s := Arrays $v }}
+func (enc {{ $encoder }}) encode{{ TypeID $s }}(x e.Ptr) {
+	a := (*{{ $s }})(x)
+	for i := range a {
+		enc.encode{{ TypeID $s.Elem }}(e.Ptr(&a[i]))
+	}
+}
+
+func (dec {{ $decoder }}) decode{{ TypeID $s }}(x e.Ptr) {
+	a := (*{{ $s }})(x)
+	for i := range a {
+		dec.decode{{ TypeID $s.Elem }}(e.Ptr(&a[i]))
+	}
+}
+{{ end }}
+
+{{ range $s := Maps $v }}
+func (enc {{ $encoder }}) encode{{ TypeID $s }}(x e.Ptr) {
+	m := *(*{{ $s }})(x)
+	// A nil map is written as zero, to distinguish it from an empty one.
+	if m == nil {
+		enc.WriteUint(0)
+		return
+	}
+	enc.WriteUint(uint64(len(m)) + 1)
+	keys := make([]{{ $s.Key.GoType }}, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	{{- if $s.Ordered }}
+	sort.Slice(keys, func(i, j int) bool { return keys[i] < keys[j] })
+	{{- end }}
+	for _, k := range keys {
+		enc.Write{{ $s.Key.Kind }}({{ $s.Key.WireType }}(k))
+		v := m[k]
+		enc.encode{{ TypeID $s.Elem }}(e.Ptr(&v))
+	}
+}
+
+func (dec {{ $decoder }}) decode{{ TypeID $s }}(x e.Ptr) {
+	n := dec.ReadLen()
+	if n == 0 {
+		*(*{{ $s }})(x) = nil
+		return
+	}
+	m := make({{ $s }}, dec.Prealloc(n-1))
+	for i := 0; i < n-1 && dec.Err() == nil; i++ {
+		k := {{ $s.Key.GoType }}(dec.Read{{ $s.Key.Kind }}())
+		var v {{ $s.Elem }}
+		dec.decode{{ TypeID $s.Elem }}(e.Ptr(&v))
+		m[k] = v
+	}
+	*(*{{ $s }})(x) = m
+}
+{{ end }}
+
+{{ range $s := Slices $v }}
+func (enc {{ $encoder }}) encode{{ TypeID $s }}(x e.Ptr) {
+	s := *(*{{ $s }})(x)
+	// A nil slice is written as zero, to distinguish it from an empty one.
+	if s == nil {
+		enc.WriteUint(0)
+		return
+	}
+	enc.WriteUint(uint64(len(s)) + 1)
+	for i := range s {
+		enc.encode{{ TypeID $s.Elem }}(e.Ptr(&s[i]))
+	}
+}
+
+func (dec {{ $decoder }}) decode{{ TypeID $s }}(x e.Ptr) {
+	n := dec.ReadLen()
+	if n == 0 {
+		*(*{{ $s }})(x) = nil
+		return
+	}
+	s := make({{ $s }}, 0, dec.Prealloc(n-1))
+	for i := 0; i < n-1 && dec.Err() == nil; i++ {
+		var elt {{ $s.Elem }}
+		s = append(s, elt)
+		dec.decode{{ TypeID $s.Elem }}(e.Ptr(&s[i]))
+	}
+	*(*{{ $s }})(x) = s
+}
+{{ end }}
+
+`
+}
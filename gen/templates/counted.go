@@ -0,0 +1,37 @@
+// Copyright 2019 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+package templates
+
+func init() {
+	TemplateSources["60counted"] = `
+{{- $v := . -}}
+{{- $Root := $v.Root -}}
+{{- $WalkerFn := T $v "WalkerFn" -}}
+
+// ------ Counting ------
+
+// Walk{{ $Root }}Counted visits x with the provided callback and also
+// returns the number of times that the callback was invoked, i.e. the
+// number of values which were visited. Post-visit functions and
+// interceptors are not counted, and values which are visited again
+// after a restart are counted again.
+func Walk{{ $Root }}Counted(x {{ $Root }}, fn {{ $WalkerFn }}) (_ {{ $Root }}, changed bool, count int, err error) {
+	var visits int64
+	x, changed, err = walk{{ $Root }}(x, fn, e.WithCount(&visits))
+	return x, changed, int(visits), err
+}
+`
+}
@@ -0,0 +1,72 @@
+// Copyright 2019 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+package templates
+
+func init() {
+	TemplateSources["60cycles"] = `
+{{- $v := . -}}
+{{- $Cycle := T $v "Cycle" -}}
+{{- $Engine := Engine $v -}}
+{{- $identify := t $v "Identify" -}}
+{{- $Path := T $v "Path" -}}
+{{- $Root := $v.Root -}}
+{{- $TypeID := T $v "TypeID" -}}
+{{- $WalkerFn := T $v "WalkerFn" -}}
+
+// ------ Cycle Detection ------
+
+// {{ $Cycle }} describes a value which was not visited because it was
+// already being visited, i.e. a back-reference which would otherwise
+// form a cycle.
+type {{ $Cycle }} struct {
+	// Path is the location of the back-reference which closes the
+	// cycle, relative to the value passed to Walk{{ $Root }}DetectCycles.
+	Path {{ $Path }}
+	// TypeID is the type of the value.
+	TypeID {{ $TypeID }}
+	// Value is populated if the value is a struct.
+	Value {{ $Root }}
+}
+
+// Walk{{ $Root }}DetectCycles visits x with the provided callback and
+// returns the cycles which were broken during the visitation. Any
+// replacements made by the callback are discarded.
+func Walk{{ $Root }}DetectCycles(x {{ $Root }}, fn {{ $WalkerFn }}) ([]{{ $Cycle }}, error) {
+	if x == nil {
+		return nil, nil
+	}
+	id, ptr := {{ $identify }}(x)
+	if ptr == nil {
+		return nil, nil
+	}
+	var ret []{{ $Cycle }}
+	_, _, _, err := {{ $Engine }}.Execute(fn, id, ptr, e.TypeID({{ TypeID $Root }}),
+		e.WithCycleHook(func(path e.Path, id e.TypeID, x e.Ptr) {
+			c := {{ $Cycle }}{Path: path, TypeID: {{ $TypeID }}(id)}
+			switch c.TypeID {
+			{{ range $s := Structs $v -}}
+			case {{ TypeID $s }}: c.Value = (*{{ $s }})(x)
+			{{ end -}}
+			}
+			ret = append(ret, c)
+		}))
+	if err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+`
+}
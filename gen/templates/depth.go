@@ -0,0 +1,61 @@
+// Copyright 2019 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+package templates
+
+func init() {
+	TemplateSources["60depth"] = `
+{{- $v := . -}}
+{{- $Context := T $v "Context" -}}
+{{- $Decision := T $v "Decision" -}}
+{{- $Root := $v.Root -}}
+
+// ------ Depth Helpers ------
+
+// DepthOf{{ $Root }} returns the deepest nesting level of the visitable
+// structs within x, as reported by {{ $Context }}.Depth(). The value
+// passed in has a depth of zero, as does a nil value. Branches which
+// are not visited because they would form a cycle do not contribute
+// to the result.
+func DepthOf{{ $Root }}(x {{ $Root }}) int {
+	max := 0
+	_, _, _ = Walk{{ $Root }}(x, func(ctx {{ $Context }}, _ {{ $Root }}) (d {{ $Decision }}) {
+		if depth := ctx.Depth(); depth > max {
+			max = depth
+		}
+		return
+	})
+	return max
+}
+
+// {{ $Root }}ByDepth returns the visitable structs within root, grouped
+// by the depth reported by {{ $Context }}.Depth(). Within each group,
+// values appear in the order in which they were visited. The root is
+// the only value at depth zero. Values which are not visited because
+// they would form a cycle are omitted.
+func {{ $Root }}ByDepth(root {{ $Root }}) [][]{{ $Root }} {
+	var ret [][]{{ $Root }}
+	_, _, _ = Walk{{ $Root }}(root, func(ctx {{ $Context }}, x {{ $Root }}) (d {{ $Decision }}) {
+		depth := ctx.Depth()
+		for len(ret) <= depth {
+			ret = append(ret, nil)
+		}
+		ret[depth] = append(ret[depth], x)
+		return
+	})
+	return ret
+}
+`
+}
@@ -0,0 +1,169 @@
+// Copyright 2019 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+package templates
+
+func init() {
+	TemplateSources["60diff"] = `
+{{- $v := . -}}
+{{- $Abstract := T $v "Abstract" -}}
+{{- $abstractOf := t $v "AbstractOf" -}}
+{{- $Change := T $v "Change" -}}
+{{- $Edit := T $v "Edit" -}}
+{{- $EditOp := T $v "EditOp" -}}
+{{- $Engine := Engine $v -}}
+{{- $identify := t $v "Identify" -}}
+{{- $Root := $v.Root -}}
+{{- $sameLabel := t $v "SameLabel" -}}
+{{- $WalkerFn := T $v "WalkerFn" -}}
+{{- $wrap := t $v "Wrap" -}}
+
+// ------ Change Tracking ------
+
+// {{ $Change }} describes a value which was replaced during a call to
+// DiffWalk{{ $Root }}.
+type {{ $Change }} struct {
+	// Path is the location of the value, relative to the root of the
+	// visitation, e.g. "TargetSlice[2]". The root itself has an empty
+	// path.
+	Path string
+	// Before is the value that was replaced.
+	Before {{ $Root }}
+	// After is the replacement value.
+	After {{ $Root }}
+}
+
+// DiffWalk{{ $Root }} visits x with the provided callback and returns a
+// log of the replacements that the callback made, in the order that
+// they were made.
+func DiffWalk{{ $Root }}(x {{ $Root }}, fn {{ $WalkerFn }}) (_ {{ $Root }}, changes []{{ $Change }}, err error) {
+	x, _, err = walk{{ $Root }}(x, fn,
+		e.WithChangeHook(func(path e.Path, beforeType e.TypeID, before e.Ptr, afterType e.TypeID, after e.Ptr) {
+			changes = append(changes, {{ $Change }}{
+				Path:   path.String(),
+				Before: {{ $wrap }}(beforeType, before),
+				After:  {{ $wrap }}(afterType, after),
+			})
+		}))
+	if err != nil {
+		return nil, nil, err
+	}
+	return x, changes, nil
+}
+
+// Walk{{ $Root }}Changed visits the values within after which differ
+// from the values at the same location within before, such as the
+// input and output of a previous call to Walk{{ $Root }}. Since
+// replaced values only cause their ancestors to be copied, any subtree
+// which is shared between before and after will be skipped without
+// invoking the callback. A nil before value will cause all of after to
+// be visited.
+func Walk{{ $Root }}Changed(before, after {{ $Root }}, fn {{ $WalkerFn }}) (_ {{ $Root }}, changed bool, err error) {
+	if after == nil {
+		return nil, false, nil
+	}
+	id, ptr := {{ $identify }}(after)
+	if ptr == nil {
+		return after, false, nil
+	}
+	var beforePtr e.Ptr
+	if before != nil {
+		if beforeID, p := {{ $identify }}(before); beforeID == id {
+			beforePtr = p
+		}
+	}
+	return walk{{ $Root }}(after, fn, e.WithChanges({{ $Engine }}.Changes(id, beforePtr, ptr)))
+}
+
+// {{ $EditOp }} describes the kind of change made by a {{ $Edit }}.
+type {{ $EditOp }} e.EditOp
+
+// The kinds of edits that may appear in a script.
+const (
+	{{ $EditOp }}Delete  = {{ $EditOp }}(e.EditDelete)
+	{{ $EditOp }}Insert  = {{ $EditOp }}(e.EditInsert)
+	{{ $EditOp }}Replace = {{ $EditOp }}(e.EditReplace)
+)
+
+// String is for debugging use only.
+func (o {{ $EditOp }}) String() string {
+	return e.EditOp(o).String()
+}
+
+// {{ $Edit }} is one step of a script produced by
+// Diff{{ $Root }}Script.
+type {{ $Edit }} struct {
+	Op {{ $EditOp }}
+	// Path is the location of the value, e.g. "TargetSlice[2]". The
+	// paths of deleted values are relative to the original tree, while
+	// the paths of inserted and replaced values are relative to the new
+	// tree.
+	Path string
+	// Before is the value which was deleted or replaced. It will be nil
+	// for insertions.
+	Before {{ $Abstract }}
+	// After is the value which was inserted or which replaced Before.
+	// It will be nil for deletions.
+	After {{ $Abstract }}
+}
+
+// Diff{{ $Root }}Script returns a minimal sequence of edits which
+// transforms a into b. Struct fields and array elements are compared
+// by position, while slice elements may be inserted or deleted. A
+// value is replaced if its type or its scalar fields differ; if only
+// its scalar fields differ, its children are compared in turn. Subtrees
+// which are shared between a and b are not examined.
+func Diff{{ $Root }}Script(a, b {{ $Root }}) []{{ $Edit }} {
+	var aID, bID e.TypeID
+	var aPtr, bPtr e.Ptr
+	if a != nil {
+		aID, aPtr = {{ $identify }}(a)
+	}
+	if b != nil {
+		bID, bPtr = {{ $identify }}(b)
+	}
+	edits := {{ $Engine }}.Script(aID, aPtr, bID, bPtr, {{ $sameLabel }})
+	if len(edits) == 0 {
+		return nil
+	}
+	ret := make([]{{ $Edit }}, len(edits))
+	for i, edit := range edits {
+		ret[i] = {{ $Edit }}{
+			Op:     {{ $EditOp }}(edit.Op),
+			Path:   edit.Path.String(),
+			Before: {{ $abstractOf }}(edit.Before),
+			After:  {{ $abstractOf }}(edit.After),
+		}
+	}
+	return ret
+}
+
+// {{ $sameLabel }} compares the scalar fields of two structs of the
+// same type.
+func {{ $sameLabel }}(id e.TypeID, a, b e.Ptr) bool {
+	switch {{ T $v "TypeID" }}(id) {
+	{{- range $s := Structs $v }}
+	{{- if $s.ScalarFields }}
+	case {{ TypeID $s }}:
+		x, y := (*{{ $s }})(a), (*{{ $s }})(b)
+		return {{ range $i, $f := $s.ScalarFields }}{{ if $i }} && {{ end }}x.{{ $f.Name }} == y.{{ $f.Name }}{{ end }}
+	{{- end }}
+	{{- end }}
+	default:
+		return true
+	}
+}
+`
+}
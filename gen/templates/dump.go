@@ -0,0 +1,40 @@
+// Copyright 2019 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+package templates
+
+func init() {
+	TemplateSources["60dump"] = `
+{{- $v := . -}}
+{{- $Engine := Engine $v -}}
+{{- $identify := t $v "Identify" -}}
+{{- $Root := $v.Root -}}
+
+// ------ Dumping ------
+
+// Dump{{ $Root }} returns an indented representation of x, for use
+// when debugging. Each line describes one value, giving its field name
+// or index within the enclosing value and its type. Pointers and
+// interfaces are followed transparently, and a value which encloses
+// itself is marked as a back-reference, rather than being followed.
+func Dump{{ $Root }}(x {{ $Root }}) string {
+	if x == nil {
+		return "<nil>\n"
+	}
+	id, ptr := {{ $identify }}(x)
+	return {{ $Engine }}.Dump(id, ptr)
+}
+`
+}
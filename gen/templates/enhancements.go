@@ -19,13 +19,21 @@ func init() {
 	TemplateSources["50enhancements"] = `
 {{- $v := . -}}
 {{- $abstract := t $v "Abstract" -}}
+{{- $abstractOf := t $v "AbstractOf" -}}
 {{- $Abstract := T $v "Abstract" -}}
 {{- $ChildAt := T $v "At" -}}
-{{- $Engine := t $v "Engine" -}}
+{{- $ChildNamed := T $v "Named" -}}
+{{- $Context := T $v "Context" -}}
+{{- $Decision := T $v "Decision" -}}
+{{- $EachChild := T $v "Each" -}}
+{{- $FieldNameAt := T $v "FieldNameAt" -}}
+{{- $Engine := Engine $v -}}
+{{- $Node := T $v "Node" -}}
 {{- $NumChildren := T $v "Count" -}}
 {{- $identify := t $v "Identify" -}}
 {{- $Root := $v.Root -}}
 {{- $TypeID := T $v "TypeID" -}}
+{{- $Walk := T $v "Walk" -}}
 {{- $WalkerFn := T $v "WalkerFn" -}}
 {{- $wrap := t $v "Wrap" -}}
 
@@ -38,8 +46,66 @@ type {{ $abstract }} struct {
 var _ {{ $Abstract }} = &{{ $abstract }}{}
 
 // {{ $ChildAt }} implements {{ $Abstract }}.
-func (a *{{ $abstract }}) {{ $ChildAt }}(index int) (ret {{ $Abstract }}) {
-	impl := a.delegate.ChildAt(index)
+func (a *{{ $abstract }}) {{ $ChildAt }}(index int) {{ $Abstract }} {
+	return {{ $abstractOf }}(a.delegate.ChildAt(index))
+}
+
+// {{ $ChildNamed }} implements {{ $Abstract }}.
+func (a *{{ $abstract }}) {{ $ChildNamed }}(name string) {{ $Abstract }} {
+	impl, _ := a.delegate.ChildNamed(name)
+	return {{ $abstractOf }}(impl)
+}
+
+// {{ $EachChild }} implements {{ $Abstract }}.
+func (a *{{ $abstract }}) {{ $EachChild }}(fn func(index int, child {{ $Abstract }}) bool) {
+	if a.delegate.NumChildren() == 0 {
+		return
+	}
+	var scratch {{ $abstract }}
+	a.delegate.Children(func(index int, child *e.Abstract) bool {
+		return fn(index, {{ $abstractOf }}Reusing(child, &scratch))
+	})
+}
+
+// {{ $FieldNameAt }} implements {{ $Abstract }}.
+func (a *{{ $abstract }}) {{ $FieldNameAt }}(index int) string {
+	return a.delegate.FieldNameAt(index)
+}
+
+// {{ $NumChildren }} implements {{ $Abstract }}.
+func (a *{{ $abstract }}) {{ $NumChildren }} () int {
+	return a.delegate.NumChildren()
+}
+
+// {{ $TypeID }} implements {{ $Abstract }}.
+func (a *{{ $abstract }}) {{ $TypeID }}() {{ $TypeID }} {
+	return {{ $TypeID }}(a.delegate.TypeID())
+}
+
+// {{ $Walk }} implements {{ $Abstract }}.
+func (a *{{ $abstract }}) {{ $Walk }}(fn {{ $WalkerFn }}) (_ {{ $Abstract }}, changed bool, err error) {
+	id := a.delegate.TypeID()
+	id, ptr, changed, err := {{ $Engine }}.Execute(fn, id, a.delegate.Ptr(), id)
+	if err != nil {
+		return nil, false, err
+	}
+	if changed {
+		return {{ $abstractOf }}({{ $Engine }}.Abstract(id, ptr)), true, nil
+	}
+	return a, false, nil
+}
+
+// {{ $abstractOf }} returns the most specific {{ $Abstract }} around
+// the given value. Structs are returned as-is, while slices and arrays
+// are wrapped in a type-safe facade.
+func {{ $abstractOf }}(impl *e.Abstract) (ret {{ $Abstract }}) {
+	return {{ $abstractOf }}Reusing(impl, nil)
+}
+
+// {{ $abstractOf }}Reusing is like {{ $abstractOf }}, but will store
+// slices and arrays in the scratch facade if it is non-nil, instead of
+// allocating a new one.
+func {{ $abstractOf }}Reusing(impl *e.Abstract, scratch *{{ $abstract }}) (ret {{ $Abstract }}) {
 	if impl == nil {
 		return nil
 	}
@@ -49,49 +115,312 @@ func (a *{{ $abstract }}) {{ $ChildAt }}(index int) (ret {{ $Abstract }}) {
 	case {{ TypeID $s }}Ptr: ret = *(**{{ $s }})(impl.Ptr());
 	{{- end }}
 	default:
-		ret = &{{ $abstract}}{impl}
+		if scratch == nil {
+			scratch = &{{ $abstract }}{}
+		}
+		scratch.delegate = impl
+		ret = scratch
 	}
 	return
 }
 
-// {{ $NumChildren }} implements {{ $Abstract }}.
-func (a *{{ $abstract }}) {{ $NumChildren }} () int {
-	return a.delegate.NumChildren()
+// {{ $Node }} is a position within the tree of values exposed by
+// {{ $Abstract }}. Unlike the values returned by {{ $ChildAt }}, a
+// {{ $Node }} retains its parent, so that the tree may be navigated
+// in either direction.
+type {{ $Node }} struct {
+	delegate *e.Abstract
 }
 
-// {{ $TypeID }} implements {{ $Abstract }}.
-func (a *{{ $abstract }}) {{ $TypeID }}() {{ $TypeID }} {
-	return {{ $TypeID }}(a.delegate.TypeID())
+// New{{ $Node }} returns a {{ $Node }} around x, which will have no
+// parent. It returns nil if x is nil.
+func New{{ $Node }}(x {{ $Root }}) *{{ $Node }} {
+	if x == nil {
+		return nil
+	}
+	id, ptr := {{ $identify }}(x)
+	if impl := {{ $Engine }}.Abstract(id, ptr); impl != nil {
+		return &{{ $Node }}{impl}
+	}
+	return nil
+}
+
+// Abstract returns the value at the node's position.
+func (n *{{ $Node }}) Abstract() {{ $Abstract }} {
+	return {{ $abstractOf }}(n.delegate)
+}
+
+// {{ $ChildAt }} returns the node of the nth child, following the same
+// rules as {{ $Abstract }}.{{ $ChildAt }}.
+func (n *{{ $Node }}) {{ $ChildAt }}(index int) *{{ $Node }} {
+	if impl := n.delegate.ChildAt(index); impl != nil {
+		return &{{ $Node }}{impl}
+	}
+	return nil
+}
+
+// {{ $ChildNamed }} returns the node of the named field, following
+// the same rules as {{ $Abstract }}.{{ $ChildNamed }}.
+func (n *{{ $Node }}) {{ $ChildNamed }}(name string) *{{ $Node }} {
+	if impl, _ := n.delegate.ChildNamed(name); impl != nil {
+		return &{{ $Node }}{impl}
+	}
+	return nil
+}
+
+// {{ $NumChildren }} returns the number of children.
+func (n *{{ $Node }}) {{ $NumChildren }}() int {
+	return n.delegate.NumChildren()
+}
+
+// Parent returns the node whose {{ $ChildAt }} method returned this
+// one, or nil if the node was returned by New{{ $Node }}.
+func (n *{{ $Node }}) Parent() *{{ $Node }} {
+	if impl := n.delegate.Parent(); impl != nil {
+		return &{{ $Node }}{impl}
+	}
+	return nil
+}
+
+// ParentAt returns the index of the node within its parent, or -1 if
+// the node has no parent.
+func (n *{{ $Node }}) ParentAt() int {
+	return n.delegate.ParentAt()
+}
+
+// NextSibling returns the node which follows this one within its
+// parent, following the same rules as {{ $ChildAt }}. It returns nil if
+// this is the last child, or if the node has no parent.
+func (n *{{ $Node }}) NextSibling() *{{ $Node }} {
+	if impl := n.delegate.Sibling(1); impl != nil {
+		return &{{ $Node }}{impl}
+	}
+	return nil
+}
+
+// PrevSibling returns the node which precedes this one within its
+// parent, following the same rules as {{ $ChildAt }}. It returns nil if
+// this is the first child, or if the node has no parent.
+func (n *{{ $Node }}) PrevSibling() *{{ $Node }} {
+	if impl := n.delegate.Sibling(-1); impl != nil {
+		return &{{ $Node }}{impl}
+	}
+	return nil
+}
+
+// {{ $TypeID }} returns the type token of the node's value.
+func (n *{{ $Node }}) {{ $TypeID }}() {{ $TypeID }} {
+	return {{ $TypeID }}(n.delegate.TypeID())
+}
+
+{{ range $r := Receivers $v }}
+{{- /* Generic receivers must look up the type of their instantiation. */ -}}
+{{- $id := printf "x.%s()" $TypeID }}
+{{- if not $r.Generic }}{{ $id = TypeID $r.Single }}{{ end }}
+{{- $deref := "*" }}
+{{- if ValueMethods $v }}{{ $deref = "" }}
+// {{ $ChildAt }} implements {{ $Abstract }}. Since the receiver is a
+// copy, any struct or array children will refer to the copy.
+func (x {{ $r }}) {{ $ChildAt }}(index int) {{ $Abstract }} {
+	self := {{ $abstract }}{ {{ $Engine }}.Abstract(e.TypeID({{ $id }}), e.Ptr(&x)) }
+	return self.{{ $ChildAt }}(index)
+}
+
+// {{ $ChildNamed }} implements {{ $Abstract }}. Since the receiver is
+// a copy, any struct or array children will refer to the copy.
+func (x {{ $r }}) {{ $ChildNamed }}(name string) {{ $Abstract }} {
+	self := {{ $abstract }}{ {{ $Engine }}.Abstract(e.TypeID({{ $id }}), e.Ptr(&x)) }
+	return self.{{ $ChildNamed }}(name)
+}
+
+// {{ $EachChild }} implements {{ $Abstract }}. Since the receiver is a
+// copy, any struct or array children will refer to the copy.
+func (x {{ $r }}) {{ $EachChild }}(fn func(index int, child {{ $Abstract }}) bool) {
+	if x.{{ $NumChildren }}() == 0 {
+		return
+	}
+	self := {{ $abstract }}{ {{ $Engine }}.Abstract(e.TypeID({{ $id }}), e.Ptr(&x)) }
+	self.{{ $EachChild }}(fn)
 }
 
-{{ range $s := Structs $v }}
+// {{ $FieldNameAt }} implements {{ $Abstract }}.
+func (x {{ $r }}) {{ $FieldNameAt }}(index int) string {
+	self := {{ $abstract }}{ {{ $Engine }}.Abstract(e.TypeID({{ $id }}), e.Ptr(&x)) }
+	return self.{{ $FieldNameAt }}(index)
+}
+{{- else }}
 // {{ $ChildAt }} implements {{ $Abstract }}.
-func (x *{{ $s }}) {{ $ChildAt }}(index int) {{ $Abstract }} {
-	self := {{ $abstract }}{ {{ $Engine }}.Abstract(e.TypeID({{ TypeID $s }}), e.Ptr(x)) }
+func (x *{{ $r }}) {{ $ChildAt }}(index int) {{ $Abstract }} {
+	self := {{ $abstract }}{ {{ $Engine }}.Abstract(e.TypeID({{ $id }}), e.Ptr(x)) }
 	return self.{{ $ChildAt }}(index)
 }
 
-// {{ $NumChildren }} returns {{ len $s.Fields }}.
-func (x *{{ $s }}) {{ $NumChildren }}() int { return {{ len $s.Fields }} }
+// {{ $ChildNamed }} implements {{ $Abstract }}.
+func (x *{{ $r }}) {{ $ChildNamed }}(name string) {{ $Abstract }} {
+	self := {{ $abstract }}{ {{ $Engine }}.Abstract(e.TypeID({{ $id }}), e.Ptr(x)) }
+	return self.{{ $ChildNamed }}(name)
+}
+
+// {{ $EachChild }} implements {{ $Abstract }}.
+func (x *{{ $r }}) {{ $EachChild }}(fn func(index int, child {{ $Abstract }}) bool) {
+	if x.{{ $NumChildren }}() == 0 {
+		return
+	}
+	self := {{ $abstract }}{ {{ $Engine }}.Abstract(e.TypeID({{ $id }}), e.Ptr(x)) }
+	self.{{ $EachChild }}(fn)
+}
+
+// {{ $FieldNameAt }} implements {{ $Abstract }}.
+func (x *{{ $r }}) {{ $FieldNameAt }}(index int) string {
+	self := {{ $abstract }}{ {{ $Engine }}.Abstract(e.TypeID({{ $id }}), e.Ptr(x)) }
+	return self.{{ $FieldNameAt }}(index)
+}
+{{- end }}
+{{ if $r.Generic }}
+// {{ $NumChildren }} implements {{ $Abstract }}.
+func (x {{ $deref }}{{ $r }}) {{ $NumChildren }}() int {
+	switch interface{}(x).(type) {
+	{{- range $s := $r.Structs }}
+	case {{ $deref }}{{ $s }}:
+		return {{ $s.NumChildren }}
+	{{- end }}
+	default:
+		return 0
+	}
+}
+
+// {{ $TypeID }} implements {{ $Abstract }}.
+func (x {{ $deref }}{{ $r }}) {{ $TypeID }}() {{ $TypeID }} {
+	switch interface{}(x).(type) {
+	{{- range $s := $r.Structs }}
+	case {{ $deref }}{{ $s }}:
+		return {{ TypeID $s }}
+	{{- end }}
+	default:
+		// This is likely a code-generation problem.
+		panic(fmt.Sprintf("unhandled instantiation: %T", x))
+	}
+}
+{{- else }}
+// {{ $NumChildren }} returns {{ $r.Single.NumChildren }}.
+func (x {{ $deref }}{{ $r }}) {{ $NumChildren }}() int { return {{ $r.Single.NumChildren }} }
+
+// {{ $TypeID }} returns {{ $id }}.
+func ({{ $deref }}{{ $r }}) {{ $TypeID }}() {{ $TypeID }} { return {{ $id }} }
+{{- end }}
 
-// {{ $TypeID }} returns {{ TypeID $s }}.
-func (*{{ $s }}) {{ $TypeID }}() {{ $TypeID }} { return {{ TypeID $s }} }
+// {{ $Walk }} implements {{ $Abstract }} by delegating to
+// Walk{{ $Root }}. A nil receiver is a no-op.
+func (x *{{ $r }}) {{ $Walk }}(fn {{ $WalkerFn }}) (_ {{ $Abstract }}, changed bool, err error) {
+	if x == nil {
+		return nil, false, nil
+	}
+	y, changed, err := x.Walk{{ $Root }}(fn)
+	if err != nil {
+		return nil, false, err
+	}
+	return y, changed, nil
+}
 
-// Walk{{ $Root }} visits the receiver with the provided callback. 
-func (x *{{ $s }}) Walk{{ $Root }}(fn {{ $WalkerFn }}) (_ *{{ $s }}, changed bool, err error) {
+// Walk{{ $Root }} visits the receiver with the provided callback.
+// A nil receiver is a no-op.
+func (x *{{ $r }}) Walk{{ $Root }}(fn {{ $WalkerFn }}) (_ *{{ $r }}, changed bool, err error) {
+	if x == nil {
+		return nil, false, nil
+	}
 	var y e.Ptr
-	_, y, changed, err = {{ $Engine }}.Execute(fn, e.TypeID({{ TypeID $s }}), e.Ptr(x), e.TypeID({{ TypeID $s }}))
+	_, y, changed, err = {{ $Engine }}.Execute(fn, e.TypeID({{ $id }}), e.Ptr(x), e.TypeID({{ $id }}))
 	if err != nil {
 		return nil, false, err
 	}
-	return (*{{ $s }})(y), changed, nil
+	return (*{{ $r }})(y), changed, nil
 }
+
+// Walk{{ $Root }}Morph visits the receiver with the provided callback.
+// Unlike Walk{{ $Root }}, the receiver may be replaced by a value of any
+// type which implements {{ $Root }}. A nil receiver is a no-op.
+func (x *{{ $r }}) Walk{{ $Root }}Morph(fn {{ $WalkerFn }}) (_ {{ $Root }}, changed bool, err error) {
+	if x == nil {
+		return nil, false, nil
+	}
+	id, y, changed, err := {{ $Engine }}.Execute(fn, e.TypeID({{ $id }}), e.Ptr(x), e.TypeID({{ TypeID $Root }}))
+	if err != nil {
+		return nil, false, err
+	}
+	if changed {
+		return {{ $wrap }}(id, y), true, nil
+	}
+	return x, false, nil
+}
+
+// Inspect{{ $Root }} visits the receiver with a callback which cannot
+// influence the visitation. A nil receiver is a no-op.
+func (x *{{ $r }}) Inspect{{ $Root }}(fn func(x {{ $Root }})) {
+	if x == nil {
+		return
+	}
+	_, _, _, _ = {{ $Engine }}.Execute(inspect{{ $Root }}(fn), e.TypeID({{ $id }}), e.Ptr(x), e.TypeID({{ $id }}))
+}
+{{- if not $r.Generic }}
+{{- range $f := $r.Single.Accessors }}
+
+// {{ $f.Method }} returns {{ if $f.Ref }}a pointer to {{ end }}the {{ $f.Name }} field.
+func (x *{{ $r }}) {{ $f.Method }}() {{ if $f.Ref }}*{{ end }}{{ $f.Target }} { return {{ if $f.Ref }}&{{ end }}x.{{ $f.Name }} }
+
+// {{ $f.Builder }} returns a shallow copy of the receiver, in which
+// the {{ $f.Name }} field has been replaced with v. The receiver is
+// not modified.
+func (x *{{ $r }}) {{ $f.Builder }}(v {{ $f.Target }}) *{{ $r }} {
+	ret := *x
+	ret.{{ $f.Name }} = v
+	return &ret
+}
+{{- if $f.Map }}
+
+// {{ $f.Value }} returns the entry of the {{ $f.Name }} field with the
+// given key, and whether that entry is present.
+func (x *{{ $r }}) {{ $f.Value }}(key {{ $f.Map.Key.GoType }}) ({{ $f.Map.Elem }}, bool) {
+	v, ok := x.{{ $f.Name }}[key]
+	return v, ok
+}
+
+// {{ $f.ValueBuilder }} returns a shallow copy of the receiver, in which
+// the entry of the {{ $f.Name }} field with the given key has been
+// replaced with v. The map is copied, so the receiver is not modified.
+// Returning the result from a callback, via a Decision's Replace
+// method, replaces a single entry of the map.
+func (x *{{ $r }}) {{ $f.ValueBuilder }}(key {{ $f.Map.Key.GoType }}, v {{ $f.Map.Elem }}) *{{ $r }} {
+	ret := *x
+	ret.{{ $f.Name }} = make({{ $f.Target }}, len(x.{{ $f.Name }})+1)
+	for k, elem := range x.{{ $f.Name }} {
+		ret.{{ $f.Name }}[k] = elem
+	}
+	ret.{{ $f.Name }}[key] = v
+	return &ret
+}
+{{- end }}
+{{- end }}
+{{- end }}
 {{ end }}
 
-// Walk{{ $Root }} visits the receiver with the provided callback. 
+// Walk{{ $Root }} visits the receiver with the provided callback.
+// A nil value, or a typed-nil pointer, is returned as-is without
+// invoking the callback.
 func Walk{{ $Root }}(x {{ $Root }}, fn {{ $WalkerFn }}) (_ {{ $Root }}, changed bool, err error) {
-  id, ptr := {{ $identify }}(x)
-	id, ptr, changed, err = {{ $Engine }}.Execute(fn, id, ptr, e.TypeID({{ TypeID $Root }}))
+	return walk{{ $Root }}(x, fn)
+}
+
+// walk{{ $Root }} implements Walk{{ $Root }} and those of its variations
+// which differ only in the options that they pass to the engine.
+func walk{{ $Root }}(x {{ $Root }}, fn {{ $WalkerFn }}, opts ...e.Option) (_ {{ $Root }}, changed bool, err error) {
+	if x == nil {
+		return nil, false, nil
+	}
+	id, ptr := {{ $identify }}(x)
+	if ptr == nil {
+		return x, false, nil
+	}
+	id, ptr, changed, err = {{ $Engine }}.Execute(fn, id, ptr, e.TypeID({{ TypeID $Root }}), opts...)
 	if err != nil {
 		return nil, false, err
 	}
@@ -100,5 +429,60 @@ func Walk{{ $Root }}(x {{ $Root }}, fn {{ $WalkerFn }}) (_ {{ $Root }}, changed
 	}
 	return x, false, nil
 }
+
+// Inspect{{ $Root }} visits x with a callback which cannot influence
+// the visitation, so that every value will be visited. A nil value,
+// or a typed-nil pointer, is ignored.
+func Inspect{{ $Root }}(x {{ $Root }}, fn func(x {{ $Root }})) {
+	if x == nil {
+		return
+	}
+	id, ptr := {{ $identify }}(x)
+	if ptr == nil {
+		return
+	}
+	_, _, _, _ = {{ $Engine }}.Execute(inspect{{ $Root }}(fn), id, ptr, id)
+}
+
+// inspect{{ $Root }} adapts a callback for Inspect{{ $Root }} into a
+// {{ $WalkerFn }} which always continues.
+func inspect{{ $Root }}(fn func(x {{ $Root }})) {{ $WalkerFn }} {
+	return func(_ {{ $Context }}, x {{ $Root }}) (d {{ $Decision }}) {
+		fn(x)
+		return
+	}
+}
+
+// Walk{{ $Root }}Ctx visits x with the provided callback, stopping with
+// the context's error once the context has been cancelled. Replacements
+// made before the cancellation are discarded, although values which were
+// replaced in place will remain changed.
+func Walk{{ $Root }}Ctx(ctx context.Context, x {{ $Root }}, fn {{ $WalkerFn }}) (_ {{ $Root }}, changed bool, err error) {
+	if err := ctx.Err(); err != nil {
+		return nil, false, err
+	}
+	// A context which can never be cancelled need not be checked, which
+	// keeps the walk allocation-free.
+	if ctx.Done() == nil {
+		return walk{{ $Root }}(x, fn)
+	}
+	return walk{{ $Root }}(x, fn, e.WithContext(ctx))
+}
+
+// Walk{{ $Root }}Lifecycle visits x with the provided callback, in the
+// same manner as Walk{{ $Root }}. The onStart function is invoked before
+// the callback is first invoked, and onEnd is invoked once the walk
+// has completed, with the error which will be returned. Either
+// function may be nil. Both functions are invoked even if x is nil.
+func Walk{{ $Root }}Lifecycle(x {{ $Root }}, onStart func(), onEnd func(err error), fn {{ $WalkerFn }}) (_ {{ $Root }}, changed bool, err error) {
+	if onStart != nil {
+		onStart()
+	}
+	x, changed, err = Walk{{ $Root }}(x, fn)
+	if onEnd != nil {
+		onEnd(err)
+	}
+	return x, changed, err
+}
 `
 }
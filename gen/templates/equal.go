@@ -0,0 +1,58 @@
+// Copyright 2019 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+package templates
+
+func init() {
+	TemplateSources["60equal"] = `
+{{- $v := . -}}
+{{- $Engine := Engine $v -}}
+{{- $identify := t $v "Identify" -}}
+{{- $Root := $v.Root -}}
+{{- $sameLabel := t $v "SameLabel" -}}
+{{- $TypeID := T $v "TypeID" -}}
+
+// ------ Equality ------
+
+// Equal{{ $Root }} reports whether a and b are structurally equal.
+// Visitable fields and elements are compared recursively, while the
+// exported boolean, numeric, and string fields of each struct are
+// compared with ==; all other fields are ignored. Pointers and
+// interfaces are compared by the values they refer to. Nested nil
+// values, typed-nil interfaces, and empty slices or maps are considered
+// to be equal to one another. Slices of differing lengths are never
+// equal.
+func Equal{{ $Root }}(a, b {{ $Root }}) bool {
+	var aID, bID e.TypeID
+	var aPtr, bPtr e.Ptr
+	if a != nil {
+		aID, aPtr = {{ $identify }}(a)
+	}
+	if b != nil {
+		bID, bPtr = {{ $identify }}(b)
+	}
+	return {{ $Engine }}.Equal(aID, aPtr, bID, bPtr, {{ $sameLabel }})
+}
+{{ range $r := Receivers $v }}
+{{- $id := printf "x.%s()" $TypeID }}
+{{- if not $r.Generic }}{{ $id = TypeID $r.Single }}{{ end }}
+// Equal{{ $Root }} reports whether the receiver and other are
+// structurally equal, as defined by Equal{{ $Root }}.
+func (x *{{ $r }}) Equal{{ $Root }}(other *{{ $r }}) bool {
+	return {{ $Engine }}.Equal(e.TypeID({{ $id }}), e.Ptr(x), e.TypeID({{ $id }}), e.Ptr(other), {{ $sameLabel }})
+}
+{{ end }}
+`
+}
@@ -0,0 +1,90 @@
+// Copyright 2019 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+package templates
+
+func init() {
+	TemplateSources["60events"] = `
+{{- $v := . -}}
+{{- $Context := T $v "Context" -}}
+{{- $Decision := T $v "Decision" -}}
+{{- $Event := T $v "Event" -}}
+{{- $identify := t $v "Identify" -}}
+{{- $Root := $v.Root -}}
+{{- $TypeID := T $v "TypeID" -}}
+{{- $WalkerFn := T $v "WalkerFn" -}}
+
+// ------ Event Streaming ------
+
+// {{ $Event }} is sent by Walk{{ $Root }}Chan when a value is visited.
+type {{ $Event }} struct {
+	// Value is the visited value. It will be nil for an event which
+	// carries an error.
+	Value {{ $Root }}
+	// TypeID is the type token of Value.
+	TypeID {{ $TypeID }}
+	// Post is false for the event which is sent before the children of
+	// Value are visited, and true for the event which is sent after.
+	Post bool
+	// Err is only set on the final event, if the walk failed.
+	Err error
+}
+
+// Walk{{ $Root }}Chan visits x on a new goroutine and returns a channel
+// which receives a pre-visit and a post-visit event for each value.
+// Values cannot be replaced. The goroutine blocks whenever the channel's
+// buffer is full, and the channel is closed once the walk has finished.
+// If the walk fails, a final event carrying the error will be sent. A
+// consumer which stops reading before the channel has been closed must
+// cancel ctx, or else the goroutine will leak; once ctx has been
+// cancelled, any remaining events, including the final error, may be
+// dropped.
+func Walk{{ $Root }}Chan(ctx context.Context, x {{ $Root }}) <-chan {{ $Event }} {
+	ch := make(chan {{ $Event }}, 16)
+	send := func(ev {{ $Event }}) bool {
+		select {
+		case ch <- ev:
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	}
+	event := func(x {{ $Root }}, post bool) {{ $Event }} {
+		id, _ := {{ $identify }}(x)
+		return {{ $Event }}{Value: x, TypeID: {{ $TypeID }}(id), Post: post}
+	}
+	post := {{ $WalkerFn }}(func(c {{ $Context }}, x {{ $Root }}) {{ $Decision }} {
+		if !send(event(x, true)) {
+			return c.Error(ctx.Err())
+		}
+		return c.Continue()
+	})
+
+	go func() {
+		defer close(ch)
+		_, _, err := Walk{{ $Root }}Ctx(ctx, x, func(c {{ $Context }}, x {{ $Root }}) {{ $Decision }} {
+			if !send(event(x, false)) {
+				return c.Error(ctx.Err())
+			}
+			return c.Continue().Post(post)
+		})
+		if err != nil {
+			send({{ $Event }}{Err: err})
+		}
+	}()
+	return ch
+}
+`
+}
@@ -0,0 +1,63 @@
+// Copyright 2019 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+package templates
+
+func init() {
+	TemplateSources["55find"] = `
+{{- $v := . -}}
+{{- $Context := T $v "Context" -}}
+{{- $Decision := T $v "Decision" -}}
+{{- $Engine := Engine $v -}}
+{{- $identify := t $v "Identify" -}}
+{{- $Root := $v.Root -}}
+{{- $WalkerFn := T $v "WalkerFn" -}}
+
+// ------ Finding ------
+{{ range $s := Structs $v }}
+// FindAll{{ $s.Ident }}In{{ $Root }} returns every {{ $s }} within root,
+// including root itself, in the order that they would be visited.
+{{- if ValueFacade $s }} Since
+// the values are passed by value, the returned pointers refer to
+// copies.
+{{- end }}
+func FindAll{{ $s.Ident }}In{{ $Root }}(root {{ $Root }}) []*{{ $s }} {
+	if root == nil {
+		return nil
+	}
+	id, ptr := {{ $identify }}(root)
+	if ptr == nil {
+		return nil
+	}
+	var ret []*{{ $s }}
+	fn := {{ $WalkerFn }}(func(ctx {{ $Context }}, x {{ $Root }}) {{ $Decision }} {
+		{{- if ValueFacade $s }}
+		found := x.({{ $s }})
+		ret = append(ret, &found)
+		{{- else }}
+		ret = append(ret, x.(*{{ $s }}))
+		{{- end }}
+		return ctx.Continue()
+	})
+	if _, _, _, err := {{ $Engine }}.Execute(fn, id, ptr, id, e.WithTypes(e.TypeID({{ TypeID $s }}))); err != nil {
+		// The callback makes no changes, so this indicates a problem
+		// with code-generation.
+		panic(err)
+	}
+	return ret
+}
+{{ end }}
+`
+}
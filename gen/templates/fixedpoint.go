@@ -0,0 +1,45 @@
+// Copyright 2019 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+package templates
+
+func init() {
+	TemplateSources["60fixedpoint"] = `
+{{- $v := . -}}
+{{- $Root := $v.Root -}}
+{{- $WalkerFn := T $v "WalkerFn" -}}
+
+// ------ Fixed-Point Application ------
+
+// Apply{{ $Root }}ToFixedPoint repeatedly visits root with the provided
+// callback until a visitation makes no changes. The callback will not
+// be applied more than maxIters times; an error will be returned if the
+// value is still changing once the limit has been reached. The number
+// of visitations which were performed is returned.
+func Apply{{ $Root }}ToFixedPoint(root {{ $Root }}, fn {{ $WalkerFn }}, maxIters int) ({{ $Root }}, int, error) {
+	for i := 1; i <= maxIters; i++ {
+		next, changed, err := Walk{{ $Root }}(root, fn)
+		if err != nil {
+			return nil, i, err
+		}
+		if !changed {
+			return root, i, nil
+		}
+		root = next
+	}
+	return root, maxIters, fmt.Errorf("no fixed point reached after %d iterations", maxIters)
+}
+`
+}
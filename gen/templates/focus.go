@@ -0,0 +1,76 @@
+// Copyright 2019 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+package templates
+
+func init() {
+	TemplateSources["60focus"] = `
+{{- $v := . -}}
+{{- $Context := T $v "Context" -}}
+{{- $Decision := T $v "Decision" -}}
+{{- $Root := $v.Root -}}
+
+// ------ Focusing ------
+
+// Focus{{ $Root }} returns the values within root for which pred
+// returns true, in the order that they are visited, along with a
+// function which puts replacements for them back into a copy of root.
+// The values which enclose a replaced value are cloned, while all other
+// values are shared with root. The values within a focused value are
+// not examined. The put function must be given exactly one non-nil
+// replacement for each focused value, and it will return an error if
+// a replacement cannot be stored, e.g. because the value was returned
+// by a getter. The put function may be called any number of times.
+func Focus{{ $Root }}(root {{ $Root }}, pred func({{ $Root }}) bool) (focused []{{ $Root }}, put func([]{{ $Root }}) ({{ $Root }}, error)) {
+	// Traversal is deterministic, so we record the ordinals of the
+	// focused values in order to find them again.
+	var ordinals []int
+	count := 0
+	_, _, _ = Walk{{ $Root }}(root, func(ctx {{ $Context }}, x {{ $Root }}) {{ $Decision }} {
+		count++
+		if pred(x) {
+			focused = append(focused, x)
+			ordinals = append(ordinals, count)
+			return ctx.Skip()
+		}
+		return ctx.Continue()
+	})
+
+	put = func(replacements []{{ $Root }}) ({{ $Root }}, error) {
+		if len(replacements) != len(ordinals) {
+			return nil, fmt.Errorf("expecting %d replacements, got %d", len(ordinals), len(replacements))
+		}
+		count, next := 0, 0
+		ret, _, err := Walk{{ $Root }}(root, func(ctx {{ $Context }}, x {{ $Root }}) {{ $Decision }} {
+			count++
+			if next == len(ordinals) {
+				return ctx.Halt()
+			}
+			if count != ordinals[next] {
+				return ctx.Continue()
+			}
+			replacement := replacements[next]
+			if replacement == nil {
+				return ctx.Error(fmt.Errorf("replacement %d is nil", next))
+			}
+			next++
+			return ctx.Skip().Replace(replacement)
+		})
+		return ret, err
+	}
+	return focused, put
+}
+`
+}
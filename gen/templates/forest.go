@@ -0,0 +1,105 @@
+// Copyright 2019 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+package templates
+
+func init() {
+	TemplateSources["60forest"] = `
+{{- $v := . -}}
+{{- $Context := T $v "Context" -}}
+{{- $Decision := T $v "Decision" -}}
+{{- $Root := $v.Root -}}
+{{- $WalkerFn := T $v "WalkerFn" -}}
+
+// ------ Forests ------
+
+// Walk{{ $Root }}Forest visits each of the roots with the provided
+// callback, distributing the roots across the given number of
+// goroutines. If workers is not positive, GOMAXPROCS goroutines will be
+// used. The callback must be safe for concurrent use. Once any
+// visitation returns an error, visitations which are in progress will
+// be halted and the remaining roots will not be visited. The first
+// error will be returned. Any replacements made by the callback are
+// discarded.
+func Walk{{ $Root }}Forest(roots []{{ $Root }}, workers int, fn {{ $WalkerFn }}) error {
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+	if workers > len(roots) {
+		workers = len(roots)
+	}
+	{{- if GoAtLeast $v 20 }}
+	// The cause of the cancellation is the first error.
+	ctx, cancel := context.WithCancelCause(context.Background())
+	defer cancel(nil)
+	{{- else }}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var errOnce sync.Once
+	var firstErr error
+	{{- end }}
+	// Halt any visitations in progress once an error has occurred.
+	var guarded {{ $WalkerFn }} = func(c {{ $Context }}, x {{ $Root }}) {{ $Decision }} {
+		if ctx.Err() != nil {
+			return c.Halt()
+		}
+		return fn(c, x)
+	}
+
+	work := make(chan {{ $Root }})
+	var wg sync.WaitGroup
+	{{- if GoAtLeast $v 25 }}
+	for i := 0; i < workers; i++ {
+		wg.Go(func() {
+	{{- else }}
+	wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go func() {
+			defer wg.Done()
+	{{- end }}
+			for root := range work {
+				if _, _, err := Walk{{ $Root }}(root, guarded); err != nil {
+					{{- if GoAtLeast $v 20 }}
+					cancel(err)
+					{{- else }}
+					errOnce.Do(func() {
+						firstErr = err
+						cancel()
+					})
+					{{- end }}
+				}
+			}
+		{{ if GoAtLeast $v 25 }}}){{ else }}}(){{ end }}
+	}
+
+feed:
+	for _, root := range roots {
+		select {
+		case work <- root:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(work)
+	wg.Wait()
+	{{- if GoAtLeast $v 20 }}
+	return context.Cause(ctx)
+	{{- else }}
+	return firstErr
+	{{- end }}
+}
+`
+}
@@ -0,0 +1,68 @@
+// Copyright 2019 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+package templates
+
+func init() {
+	TemplateSources["60format"] = `
+{{- $v := . -}}
+{{- $Context := T $v "Context" -}}
+{{- $Decision := T $v "Decision" -}}
+{{- $Formatter := T $v "Formatter" -}}
+{{- $identify := t $v "Identify" -}}
+{{- $Root := $v.Root -}}
+{{- $TypeID := T $v "TypeID" -}}
+{{- $WalkerFn := T $v "WalkerFn" -}}
+
+// ------ Formatting ------
+
+// {{ $Formatter }} renders a value, given the rendered output of the
+// visitable structs that it encloses.
+type {{ $Formatter }} func(x {{ $Root }}, children []string) string
+
+// Format{{ $Root }} renders root by visiting it and composing the
+// output of the formatter registered for the type of each struct. A
+// struct without a formatter is rendered as the name of its type,
+// followed by its children in parentheses, if it has any. The children
+// of a struct are rendered in the order in which they are visited. Nil
+// values, and values which would form a cycle, are omitted.
+func Format{{ $Root }}(root {{ $Root }}, formatters map[{{ $TypeID }}]{{ $Formatter }}) string {
+	// Each struct being visited has an entry on the stack, which
+	// accumulates the output of its children. The bottom entry collects
+	// the output of the root.
+	stack := [][]string{nil}
+	var post {{ $WalkerFn }} = func(ctx {{ $Context }}, x {{ $Root }}) (d {{ $Decision }}) {
+		children := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		id, _ := {{ $identify }}(x)
+		var out string
+		if fn, ok := formatters[{{ $TypeID }}(id)]; ok {
+			out = fn(x, children)
+		} else if len(children) == 0 {
+			out = {{ $TypeID }}(id).String()
+		} else {
+			out = fmt.Sprintf("%s(%s)", {{ $TypeID }}(id), strings.Join(children, ", "))
+		}
+		stack[len(stack)-1] = append(stack[len(stack)-1], out)
+		return
+	}
+	_, _, _ = Walk{{ $Root }}(root, func(ctx {{ $Context }}, x {{ $Root }}) {{ $Decision }} {
+		stack = append(stack, nil)
+		return ctx.Continue().Post(post)
+	})
+	return strings.Join(stack[0], "")
+}
+`
+}
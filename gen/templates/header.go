@@ -23,14 +23,26 @@ func init() {
 	TemplateSources["00header"] = `
 // Code generated by github.com/cockroachdb/walkabout. DO NOT EDIT.
 // source: {{ SourceFile . }}
+{{- with BuildConstraint . }}
 
-package {{ Package . }}
+//go:build {{ . }}
+{{- end }}
 
+package {{ Package . }}
+{{ if not (UnionOnly .) }}
 import (
+	"context"
 	"fmt"
+	"io"
+	"reflect"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
 	"unsafe"
 
 	e "github.com/cockroachdb/walkabout/engine"
 )
+{{ end }}
 `
 }
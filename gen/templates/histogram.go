@@ -0,0 +1,39 @@
+// Copyright 2019 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+package templates
+
+func init() {
+	TemplateSources["60histogram"] = `
+{{- $v := . -}}
+{{- $identify := t $v "Identify" -}}
+{{- $Root := $v.Root -}}
+{{- $TypeID := T $v "TypeID" -}}
+
+// ------ Histograms ------
+
+// {{ $Root }}Histogram returns the number of values of each struct type
+// which are visited by Inspect{{ $Root }}, including x itself. A nil
+// value returns an empty map.
+func {{ $Root }}Histogram(x {{ $Root }}) map[{{ $TypeID }}]int {
+	ret := make(map[{{ $TypeID }}]int)
+	Inspect{{ $Root }}(x, func(x {{ $Root }}) {
+		id, _ := {{ $identify }}(x)
+		ret[{{ $TypeID }}(id)]++
+	})
+	return ret
+}
+`
+}
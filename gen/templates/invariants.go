@@ -0,0 +1,110 @@
+// Copyright 2019 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+package templates
+
+func init() {
+	TemplateSources["60invariants"] = `
+{{- $v := . -}}
+{{- $Context := T $v "Context" -}}
+{{- $Decision := T $v "Decision" -}}
+{{- $Engine := Engine $v -}}
+{{- $identify := t $v "Identify" -}}
+{{- $Root := $v.Root -}}
+{{- $Violation := T $v "Violation" -}}
+{{- $Violations := T $v "Violations" -}}
+{{- $WalkerFn := T $v "WalkerFn" -}}
+{{- $checked := false -}}
+{{- range $s := Structs $v }}{{ if $s.Invariants }}{{ $checked = true }}{{ end }}{{ end }}
+
+// ------ Invariants ------
+
+// {{ $Violation }} describes a field whose value does not satisfy an
+// invariant declared by its walkabout struct tag.
+type {{ $Violation }} struct {
+	// Path is the location of the field, relative to the root of the
+	// visitation, e.g. "TargetSlice[2].ByRefPtr".
+	Path string
+	// Invariant is the name of the invariant, e.g. "nonnil".
+	Invariant string
+}
+
+// {{ $Violations }} is the error returned by Check{{ $Root }}Invariants.
+type {{ $Violations }} []{{ $Violation }}
+
+// Error implements error.
+func (v {{ $Violations }}) Error() string {
+	msgs := make([]string, len(v))
+	for i, x := range v {
+		msgs[i] = fmt.Sprintf("%s (%s)", x.Path, x.Invariant)
+	}
+	return "invariants violated: " + strings.Join(msgs, ", ")
+}
+
+// Check{{ $Root }}Invariants visits root and returns {{ $Violations }}
+// if any field does not satisfy the invariants declared by its walkabout
+// struct tag. The supported invariants are "nonnil", for pointer and
+// interface fields, and "nonempty", for slice and map fields. Multiple
+// invariants may be separated by commas.
+func Check{{ $Root }}Invariants(root {{ $Root }}) error {
+	if root == nil {
+		return nil
+	}
+	id, ptr := {{ $identify }}(root)
+	if ptr == nil {
+		return nil
+	}
+	var ret {{ $Violations }}
+	{{- if $checked }}
+	violated := func(ctx {{ $Context }}, field, invariant string) {
+		path := append(ctx.impl.Path(), e.PathSegment{Field: field})
+		ret = append(ret, {{ $Violation }}{Path: path.String(), Invariant: invariant})
+	}
+	{{- end }}
+	fn := func(ctx {{ $Context }}, x {{ $Root }}) {{ $Decision }} {
+		{{- if $checked }}
+		switch t := x.(type) {
+		{{- range $s := Structs $v }}
+		{{- if $s.Invariants }}
+		{{- if ValueFacade $s }}
+		case {{ $s }}:
+		{{- else }}
+		case *{{ $s }}:
+		{{- end }}
+			{{- range $i := $s.Invariants }}
+			{{- if eq $i.Name "nonnil" }}
+			if t.{{ $i.Field }} == nil {
+			{{- else }}
+			if len(t.{{ $i.Field }}) == 0 {
+			{{- end }}
+				violated(ctx, "{{ $i.Field }}", "{{ $i.Name }}")
+			}
+			{{- end }}
+		{{- end }}
+		{{- end }}
+		}
+		{{- end }}
+		return ctx.Continue()
+	}
+	if _, _, _, err := {{ $Engine }}.Execute({{ $WalkerFn }}(fn), id, ptr, e.TypeID({{ TypeID $Root }})); err != nil {
+		return err
+	}
+	if len(ret) > 0 {
+		return ret
+	}
+	return nil
+}
+`
+}
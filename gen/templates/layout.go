@@ -0,0 +1,52 @@
+// Copyright 2019 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+package templates
+
+func init() {
+	TemplateSources["76layout"] = `
+{{- $v := . -}}
+{{- if VerifyLayout $v -}}
+{{- $verifyLayout := t $v "VerifyLayout" -}}
+// ------ Layout Verification ------
+
+// init checks that each visitable field still exists and has the type
+// which was recorded in the type map. A field whose type has been
+// changed without re-running the generator would otherwise cause the
+// engine to misinterpret memory. The offset of each field is computed
+// by the compiler, so it needn't be checked.
+func init() {
+	{{- range $s := Structs $v }}
+	{{- range $f := $s.Fields }}
+	{{ $verifyLayout }}({{ $s }}{}, "{{ $s }}", "{{ $f }}", reflect.TypeOf((*{{ $f.Target }})(nil)).Elem())
+	{{- end }}
+	{{- end }}
+}
+
+// {{ $verifyLayout }} panics if the named field of x does not have the
+// given type. The field may be promoted from an embedded struct.
+func {{ $verifyLayout }}(x interface{}, structName, field string, typ reflect.Type) {
+	f, ok := reflect.TypeOf(x).FieldByName(field)
+	switch {
+	case !ok:
+		panic(fmt.Sprintf("%s.%s no longer exists; the generated code is out of date", structName, field))
+	case f.Type != typ:
+		panic(fmt.Sprintf("%s.%s has type %s, not %s; the generated code is out of date",
+			structName, field, f.Type, typ))
+	}
+}
+{{- end -}}
+`
+}
@@ -0,0 +1,64 @@
+// Copyright 2019 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+package templates
+
+func init() {
+	TemplateSources["60marshal"] = `
+{{- $v := . -}}
+{{- $Engine := Engine $v -}}
+{{- $identify := t $v "Identify" -}}
+{{- $marshalLeaves := t $v "MarshalLeaves" -}}
+{{- $Root := $v.Root -}}
+{{- $TypeID := T $v "TypeID" -}}
+
+// ------ Marshaling ------
+
+// Marshal{{ $Root }} returns a JSON representation of x, for use in
+// logging. Each struct is encoded as an object whose "__type" key holds
+// the name of its concrete type, followed by its exported,
+// non-visitable fields and then its visitable fields. Slices and
+// arrays are encoded as lists and maps as objects, while nil values
+// and empty slices or maps are encoded as null. The output is stable,
+// although it cannot currently be unmarshaled. An error will be
+// returned if a value encloses itself, or if a non-visitable field
+// cannot be encoded by encoding/json.
+func Marshal{{ $Root }}(x {{ $Root }}) ([]byte, error) {
+	if x == nil {
+		return []byte("null"), nil
+	}
+	id, ptr := {{ $identify }}(x)
+	return {{ $Engine }}.Marshal(id, ptr, {{ $marshalLeaves }})
+}
+
+// {{ $marshalLeaves }} implements e.LeafFn.
+func {{ $marshalLeaves }}(id e.TypeID, x e.Ptr, fn func(name string, value interface{}) error) error {
+	switch {{ $TypeID }}(id) {
+	{{- range $s := Structs $v }}
+	{{- with $s.LeafFields }}
+	case {{ TypeID $s }}:
+		s := (*{{ $s }})(x)
+		{{- range $name := . }}
+		if err := fn("{{ $name }}", s.{{ $name }}); err != nil {
+			return err
+		}
+		{{- end }}
+	{{- end }}
+	{{- end }}
+	}
+	return nil
+}
+`
+}
@@ -0,0 +1,38 @@
+// Copyright 2019 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+package templates
+
+func init() {
+	TemplateSources["60maxdepth"] = `
+{{- $v := . -}}
+{{- $Root := $v.Root -}}
+{{- $WalkerFn := T $v "WalkerFn" -}}
+
+// ------ Depth Limits ------
+
+// Walk{{ $Root }}MaxDepth visits x with the provided callback, but
+// returns an error instead of descending into a value which would
+// require more than maxDepth levels of the engine's stack. The value x
+// occupies one level, and every struct, pointer, interface, slice,
+// array, or map which encloses a value adds another, so that deeply
+// nested slices are limited as well as deeply nested structs. This
+// guards against malformed or adversarial inputs. A maxDepth of zero
+// or less is unlimited.
+func Walk{{ $Root }}MaxDepth(x {{ $Root }}, maxDepth int, fn {{ $WalkerFn }}) (_ {{ $Root }}, changed bool, err error) {
+	return walk{{ $Root }}(x, fn, e.WithMaxDepth(maxDepth))
+}
+`
+}
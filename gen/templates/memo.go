@@ -0,0 +1,81 @@
+// Copyright 2019 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+package templates
+
+func init() {
+	TemplateSources["60memo"] = `
+{{- $v := . -}}
+{{- $hashLabel := t $v "HashLabel" -}}
+{{- $Memo := T $v "Memo" -}}
+{{- $Root := $v.Root -}}
+{{- $sameLabel := t $v "SameLabel" -}}
+{{- $TypeID := T $v "TypeID" -}}
+{{- $WalkerFn := T $v "WalkerFn" -}}
+
+// ------ Memoization ------
+
+// {{ $Memo }} records the outcome of visiting struct values in
+// Walk{{ $Root }}Memo. Values are identified by their structure, so that
+// identical subtrees will only be visited once, whether or not they are
+// shared by reference. Values are first bucketed by a hash of their
+// visitable children and scalar fields, and are then compared with
+// Equal{{ $Root }}. A {{ $Memo }} is not safe for concurrent use.
+type {{ $Memo }} e.Memo
+
+// New{{ $Memo }} constructs an empty {{ $Memo }}.
+func New{{ $Memo }}() *{{ $Memo }} {
+	return (*{{ $Memo }})(e.NewMemo({{ $hashLabel }}, {{ $sameLabel }}))
+}
+
+// Len returns the number of outcomes that have been recorded.
+func (m *{{ $Memo }}) Len() int {
+	return (*e.Memo)(m).Len()
+}
+
+// Walk{{ $Root }}Memo visits x with the provided callback, which must
+// behave as a pure function of the value being visited and its children.
+// Once a struct value has been visited, the outcome is recorded and
+// will be reused whenever an equal value is encountered again. Reused
+// values are neither passed to the callback, nor are their children
+// visited.
+//
+// If memo is nil, the outcomes will only be retained for the duration
+// of the call. Otherwise, the caller-provided memo will be consulted
+// and updated, allowing outcomes to be reused across calls.
+func Walk{{ $Root }}Memo(x {{ $Root }}, memo *{{ $Memo }}, fn {{ $WalkerFn }}) (_ {{ $Root }}, changed bool, err error) {
+	if memo == nil {
+		memo = New{{ $Memo }}()
+	}
+	return walk{{ $Root }}(x, fn, e.WithMemo((*e.Memo)(memo)))
+}
+
+// {{ $hashLabel }} hashes the scalar fields of a struct, consistently
+// with {{ $sameLabel }}.
+func {{ $hashLabel }}(id e.TypeID, x e.Ptr, h *e.Hasher) {
+	switch {{ $TypeID }}(id) {
+	{{- range $s := Structs $v }}
+	{{- if $s.ScalarFields }}
+	case {{ TypeID $s }}:
+		s := (*{{ $s }})(x)
+		{{- range $f := $s.ScalarFields }}
+		h.Write{{ $f.Kind }}({{ $f.WireType }}(s.{{ $f.Name }}))
+		{{- end }}
+	{{- end }}
+	{{- end }}
+	}
+}
+`
+}
@@ -0,0 +1,40 @@
+// Copyright 2019 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+package templates
+
+func init() {
+	TemplateSources["60nils"] = `
+{{- $v := . -}}
+{{- $Context := T $v "Context" -}}
+{{- $Root := $v.Root -}}
+{{- $WalkerFn := T $v "WalkerFn" -}}
+
+// ------ Nil Values ------
+
+// Walk{{ $Root }}VisitNils visits x with the provided callback, which
+// will also be invoked with a nil {{ $Root }} for each nil interface
+// value that an ordinary walk would skip, such as a nil element of a
+// slice or a field which holds a typed-nil pointer. This allows nil
+// values to be reported or replaced. Since there is nothing to descend
+// into, returning a replacement is the only meaningful action for a
+// nil value, and any post-visit function will not be called.
+func Walk{{ $Root }}VisitNils(x {{ $Root }}, fn {{ $WalkerFn }}) (_ {{ $Root }}, changed bool, err error) {
+	return walk{{ $Root }}(x, fn, e.WithNils(func(impl e.Context, fn e.FacadeFn) e.Decision {
+		return e.Decision(fn.({{ $WalkerFn }})({{ $Context }}{impl}, nil))
+	}))
+}
+`
+}
@@ -0,0 +1,52 @@
+// Copyright 2019 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+package templates
+
+func init() {
+	TemplateSources["60oftypes"] = `
+{{- $v := . -}}
+{{- $Root := $v.Root -}}
+{{- $TypeID := T $v "TypeID" -}}
+{{- $WalkerFn := T $v "WalkerFn" -}}
+
+// ------ Type Filtering ------
+
+// Walk{{ $Root }}OfTypes visits x with the provided callback, which
+// will only be invoked for struct values whose type token is one of
+// the given types. All other values are descended into as though the
+// callback had returned a zero {{ T $v "Decision" }}.
+func Walk{{ $Root }}OfTypes(x {{ $Root }}, fn {{ $WalkerFn }}, types ...{{ $TypeID }}) (_ {{ $Root }}, changed bool, err error) {
+	ids := make([]e.TypeID, len(types))
+	for i, t := range types {
+		ids[i] = e.TypeID(t)
+	}
+	return walk{{ $Root }}(x, fn, e.WithTypes(ids...))
+}
+
+// Walk{{ $Root }}Except visits x with the provided callback, skipping
+// any value whose type token is one of skipTypes, along with all of the
+// values that it encloses, as though the callback had returned
+// {{ T $v "Context" }}.Skip for it. Since skipped values are never
+// passed to the callback, they cannot be replaced.
+func Walk{{ $Root }}Except(x {{ $Root }}, skipTypes []{{ $TypeID }}, fn {{ $WalkerFn }}) (_ {{ $Root }}, changed bool, err error) {
+	ids := make([]e.TypeID, len(skipTypes))
+	for i, t := range skipTypes {
+		ids[i] = e.TypeID(t)
+	}
+	return walk{{ $Root }}(x, fn, e.WithSkipTypes(ids...))
+}
+`
+}
@@ -0,0 +1,35 @@
+// Copyright 2019 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+package templates
+
+func init() {
+	TemplateSources["60once"] = `
+{{- $v := . -}}
+{{- $Root := $v.Root -}}
+{{- $WalkerFn := T $v "WalkerFn" -}}
+
+// ------ Exactly-once Visitation ------
+
+// Walk{{ $Root }}Once visits x with the provided callback, but will
+// visit each value at most once, even if it is reachable through
+// multiple pointers. A value which has already been visited is skipped,
+// along with all of the values that it encloses. A skipped value is
+// left as-is, even if it was replaced where it was first visited.
+func Walk{{ $Root }}Once(x {{ $Root }}, fn {{ $WalkerFn }}) (_ {{ $Root }}, changed bool, err error) {
+	return walk{{ $Root }}(x, fn, e.WithOnce())
+}
+`
+}
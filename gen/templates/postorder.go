@@ -0,0 +1,42 @@
+// Copyright 2019 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+package templates
+
+func init() {
+	TemplateSources["60postorder"] = `
+{{- $v := . -}}
+{{- $Context := T $v "Context" -}}
+{{- $Decision := T $v "Decision" -}}
+{{- $Root := $v.Root -}}
+{{- $WalkerFn := T $v "WalkerFn" -}}
+
+// ------ Post-Order Visitation ------
+
+// Walk{{ $Root }}PostOrder visits x with the provided callback, which
+// is only invoked once all of the children of a value have been
+// visited, as though it had been registered as a post-visit function
+// for every value. The callback will therefore see any replacements
+// made to the children. Skip has no effect, since the children have
+// already been visited. Halt prevents any further children from being
+// visited, but the callback will still be invoked for the values which
+// enclose the halting value.
+func Walk{{ $Root }}PostOrder(x {{ $Root }}, fn {{ $WalkerFn }}) (_ {{ $Root }}, changed bool, err error) {
+	return walk{{ $Root }}(x, func(ctx {{ $Context }}, _ {{ $Root }}) {{ $Decision }} {
+		return ctx.Continue().Post(fn)
+	})
+}
+`
+}
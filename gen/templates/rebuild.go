@@ -0,0 +1,67 @@
+// Copyright 2019 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+package templates
+
+func init() {
+	TemplateSources["60rebuild"] = `
+{{- $v := . -}}
+{{- $Engine := Engine $v -}}
+{{- $identify := t $v "Identify" -}}
+{{- $Root := $v.Root -}}
+{{- $TypeID := T $v "TypeID" -}}
+{{- $WalkerFn := T $v "WalkerFn" -}}
+{{- $wrap := t $v "Wrap" -}}
+
+// ------ Rebuilding ------
+
+// Walk{{ $Root }}Rebuild visits x with the provided callback. Unlike
+// Walk{{ $Root }}, every visitable value will be copied, even if the
+// callback makes no changes. The result will not share any visitable
+// memory with x, except for back-references which form cycles and
+// values registered with Set{{ $Root }}Interned.
+func Walk{{ $Root }}Rebuild(x {{ $Root }}, fn {{ $WalkerFn }}) ({{ $Root }}, error) {
+	if x == nil {
+		return nil, nil
+	}
+	id, ptr := {{ $identify }}(x)
+	if ptr == nil {
+		return x, nil
+	}
+	id, ptr, _, err := {{ $Engine }}.Execute(fn, id, ptr, e.TypeID({{ TypeID $Root }}), e.WithRebuild())
+	if err != nil {
+		return nil, err
+	}
+	return {{ $wrap }}(id, ptr), nil
+}
+
+// Set{{ $Root }}Interned registers a predicate which identifies
+// interned values of the given struct type, such as shared constants.
+// Walk{{ $Root }}Rebuild will retain an interned value as-is, instead
+// of copying it, unless the callback replaces a value that it
+// encloses. A nil predicate removes any existing registration. This
+// function must not be called concurrently with any visitation, so it
+// is best called from an init function.
+func Set{{ $Root }}Interned(id {{ $TypeID }}, fn func(x {{ $Root }}) bool) {
+	if fn == nil {
+		{{ $Engine }}.Intern(e.TypeID(id), nil)
+		return
+	}
+	{{ $Engine }}.Intern(e.TypeID(id), func(x e.Ptr) bool {
+		return fn({{ $wrap }}(e.TypeID(id), x))
+	})
+}
+`
+}
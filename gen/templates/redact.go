@@ -0,0 +1,67 @@
+// Copyright 2019 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+package templates
+
+func init() {
+	TemplateSources["60redact"] = `
+{{- $v := . -}}
+{{- $Context := T $v "Context" -}}
+{{- $Decision := T $v "Decision" -}}
+{{- $Root := $v.Root -}}
+{{- $redacted := false -}}
+{{- range $s := Structs $v }}{{ if $s.StringFields }}{{ $redacted = true }}{{ end }}{{ end }}
+
+// ------ String Redaction ------
+
+// Redact{{ $Root }}Strings applies fn to every exported string field of
+// the structs within root, whether or not the fields are visitable.
+// Structs whose strings are changed will be replaced by updated copies,
+// so root itself is not modified.
+func Redact{{ $Root }}Strings(root {{ $Root }}, fn func(string) string) ({{ $Root }}, bool, error) {
+	return Walk{{ $Root }}(root, func(ctx {{ $Context }}, x {{ $Root }}) {{ $Decision }} {
+		{{- if $redacted }}
+		switch t := x.(type) {
+		{{- range $s := Structs $v }}
+		{{- if $s.StringFields }}
+		{{- if ValueFacade $s }}
+		case {{ $s }}:
+		{{- else }}
+		case *{{ $s }}:
+		{{- end }}
+			cp := {{ if not (ValueFacade $s) }}*{{ end }}t
+			changed := false
+			{{- range $f := $s.StringFields }}
+			{{- if $f.Named }}
+			if next := {{ $f.Named }}(fn(string(t.{{ $f.Name }}))); next != t.{{ $f.Name }} {
+			{{- else }}
+			if next := fn(t.{{ $f.Name }}); next != t.{{ $f.Name }} {
+			{{- end }}
+				cp.{{ $f.Name }} = next
+				changed = true
+			}
+			{{- end }}
+			if changed {
+				return ctx.Continue().Replace(&cp)
+			}
+		{{- end }}
+		{{- end }}
+		}
+		{{- end }}
+		return ctx.Continue()
+	})
+}
+`
+}
@@ -0,0 +1,58 @@
+// Copyright 2019 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+package templates
+
+func init() {
+	TemplateSources["60shape"] = `
+{{- $v := . -}}
+{{- $Context := T $v "Context" -}}
+{{- $Decision := T $v "Decision" -}}
+{{- $Engine := Engine $v -}}
+{{- $identify := t $v "Identify" -}}
+{{- $Root := $v.Root -}}
+{{- $TypeID := T $v "TypeID" -}}
+{{- $WalkerFn := T $v "WalkerFn" -}}
+
+// ------ Shape Fingerprints ------
+
+// ShapeOf{{ $Root }} returns the type tokens of the visitable structs
+// within root, in the order in which they are visited. The values of
+// any scalar fields are ignored, so trees which differ only in those
+// values will have the same shape. A back-reference which is not
+// visited because it would form a cycle is represented by a zero
+// token. A nil value has an empty shape.
+func ShapeOf{{ $Root }}(root {{ $Root }}) []{{ $TypeID }} {
+	if root == nil {
+		return nil
+	}
+	id, ptr := {{ $identify }}(root)
+	if ptr == nil {
+		return nil
+	}
+	var ret []{{ $TypeID }}
+	var fn {{ $WalkerFn }} = func(ctx {{ $Context }}, x {{ $Root }}) (d {{ $Decision }}) {
+		id, _ := {{ $identify }}(x)
+		ret = append(ret, {{ $TypeID }}(id))
+		return
+	}
+	_, _, _, _ = {{ $Engine }}.Execute(fn, id, ptr, e.TypeID({{ TypeID $Root }}),
+		e.WithCycleHook(func(e.Path, e.TypeID, e.Ptr) {
+			ret = append(ret, 0)
+		}))
+	return ret
+}
+`
+}
@@ -0,0 +1,51 @@
+// Copyright 2019 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+package templates
+
+func init() {
+	TemplateSources["60state"] = `
+{{- $v := . -}}
+{{- $Context := T $v "Context" -}}
+{{- $Decision := T $v "Decision" -}}
+{{- $Root := $v.Root -}}
+{{- $StateFn := T $v "StateFn" -}}
+{{- $WalkerFn := T $v "WalkerFn" -}}
+
+// ------ Per-Walk State ------
+
+// {{ $StateFn }} is a variation on {{ $WalkerFn }} which also receives
+// the state value passed to Walk{{ $Root }}State.
+type {{ $StateFn }} func(ctx {{ $Context }}, state interface{}, x {{ $Root }}) {{ $Decision }}
+
+// Walk{{ $Root }}State visits x with the provided callback. The state
+// will be passed to each invocation of the callback and is also
+// available to post-visit functions via {{ $Context }}.State().
+func Walk{{ $Root }}State(x {{ $Root }}, state interface{}, fn {{ $StateFn }}) (_ {{ $Root }}, changed bool, err error) {
+	walker := {{ $WalkerFn }}(func(ctx {{ $Context }}, x {{ $Root }}) {{ $Decision }} {
+		return fn(ctx, ctx.State(), x)
+	})
+	return walk{{ $Root }}(x, walker, e.WithState(state))
+}
+
+// Walk{{ $Root }}With visits x with the provided callback. The user
+// data is available to the callback, and to post-visit functions, via
+// {{ $Context }}.Value(). This allows a single {{ $WalkerFn }} to be
+// reused across walks without capturing any variables.
+func Walk{{ $Root }}With(x {{ $Root }}, userData interface{}, fn {{ $WalkerFn }}) (_ {{ $Root }}, changed bool, err error) {
+	return walk{{ $Root }}(x, fn, e.WithState(userData))
+}
+`
+}
@@ -0,0 +1,113 @@
+// Copyright 2019 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+package templates
+
+func init() {
+	TemplateSources["60topo"] = `
+{{- $v := . -}}
+{{- $abstract := t $v "Abstract" -}}
+{{- $Abstract := T $v "Abstract" -}}
+{{- $ChildAt := T $v "At" -}}
+{{- $Engine := Engine $v -}}
+{{- $identify := t $v "Identify" -}}
+{{- $NumChildren := T $v "Count" -}}
+{{- $Root := $v.Root -}}
+{{- $TopoFn := T $v "TopoFn" -}}
+{{- $TypeID := T $v "TypeID" -}}
+
+// ------ Topological Visitation ------
+
+// {{ $TopoFn }} is used by Walk{{ $Root }}Topo.
+type {{ $TopoFn }} func(x {{ $Root }}) error
+
+// Walk{{ $Root }}Topo treats the named field as the dependencies of a
+// value and invokes the callback on root and its transitive
+// dependencies, such that each value is visited only once and only
+// after all of its dependencies have been visited. The field may be
+// of any visitable type; a struct, or the structs contained within a
+// slice or array, will be treated as dependencies. Values which do not
+// have a field of the given name have no dependencies. An error will
+// be returned if the dependencies form a cycle.
+func Walk{{ $Root }}Topo(root {{ $Root }}, depField string, fn {{ $TopoFn }}) error {
+	type key struct {
+		id  e.TypeID
+		ptr e.Ptr
+	}
+	// Values that are present, but false, are being visited.
+	done := make(map[key]bool)
+	var stack []key
+
+	var visit func(x {{ $Root }}) error
+	var visitDeps func(a {{ $Abstract }}) error
+
+	visit = func(x {{ $Root }}) error {
+		id, ptr := {{ $identify }}(x)
+		if ptr == nil {
+			return nil
+		}
+		k := key{id, ptr}
+		if finished, seen := done[k]; seen {
+			if finished {
+				return nil
+			}
+			msg := ""
+			for i := len(stack) - 1; i >= 0; i-- {
+				if stack[i] == k {
+					for _, s := range stack[i:] {
+						msg += fmt.Sprintf("%s -> ", {{ $TypeID }}(s.id))
+					}
+					break
+				}
+			}
+			return fmt.Errorf("dependency cycle: %s%s", msg, {{ $TypeID }}(id))
+		}
+		done[k] = false
+		stack = append(stack, k)
+
+		self := &{{ $abstract }}{ {{ $Engine }}.Abstract(id, ptr) }
+		if idx := self.delegate.FieldIndex(depField); idx >= 0 {
+			if err := visitDeps(self.{{ $ChildAt }}(idx)); err != nil {
+				return err
+			}
+		}
+
+		stack = stack[:len(stack)-1]
+		done[k] = true
+		return fn(x)
+	}
+
+	visitDeps = func(a {{ $Abstract }}) error {
+		if a == nil {
+			return nil
+		}
+		if x, ok := a.({{ $Root }}); ok {
+			return visit(x)
+		}
+		for i, j := 0, a.{{ $NumChildren }}(); i < j; i++ {
+			if err := visitDeps(a.{{ $ChildAt }}(i)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	if root == nil {
+		return nil
+	}
+	return visit(root)
+}
+`
+}
@@ -0,0 +1,109 @@
+// Copyright 2019 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+package templates
+
+func init() {
+	TemplateSources["60transformer"] = `
+{{- $v := . -}}
+{{- $Context := T $v "Context" -}}
+{{- $Decision := T $v "Decision" -}}
+{{- $Root := $v.Root -}}
+{{- $transformed := t $v "Transformed" -}}
+{{- $Transformer := T $v "Transformer" -}}
+{{- $TypeID := T $v "TypeID" -}}
+
+// ------ Transformers ------
+
+// {{ $Transformer }} applies type-specific rewrites to a {{ $Root }},
+// without the need to write a type switch in a {{ T $v "WalkerFn" }}.
+// A {{ $Transformer }} should be constructed with
+// New{{ $Transformer }} and configured by registering a function for
+// each type of interest.
+type {{ $Transformer }} struct {
+	{{- range $s := Structs $v }}
+	on{{ $s.Ident }} func(*{{ $s }}) *{{ $s }}
+	{{- end }}
+}
+
+// New{{ $Transformer }} returns a {{ $Transformer }} which has no
+// registered functions.
+func New{{ $Transformer }}() *{{ $Transformer }} {
+	return &{{ $Transformer }}{}
+}
+{{ range $s := Structs $v }}
+// On{{ $s.Ident }} registers a function which will be invoked with
+// each {{ $s }}, replacing any previously-registered function. If fn
+// returns the pointer that it was given, the value is unchanged.
+// Otherwise, the value is replaced by the returned value.
+{{- if ValueFacade $s }} Since the
+// values are visited by value, fn receives a pointer to a copy. If fn
+// modifies the copy and returns it, the value is replaced by the copy,
+// in the same way that a value visited by reference would have been
+// modified in place.
+{{- end }}
+func (t *{{ $Transformer }}) On{{ $s.Ident }}(fn func(*{{ $s }}) *{{ $s }}) *{{ $Transformer }} {
+	t.on{{ $s.Ident }} = fn
+	return t
+}
+{{ end }}
+// Walk applies the registered functions to x and to the values within
+// it, returning an updated copy of x if any value was replaced. The
+// values within a replacement are visited in turn. An error will be
+// returned if a registered function returns nil.
+func (t *{{ $Transformer }}) Walk(x {{ $Root }}) (_ {{ $Root }}, changed bool, err error) {
+	var types []{{ $TypeID }}
+	{{- range $s := Structs $v }}
+	if t.on{{ $s.Ident }} != nil {
+		types = append(types, {{ TypeID $s }})
+	}
+	{{- end }}
+	if len(types) == 0 {
+		return x, false, nil
+	}
+	return Walk{{ $Root }}OfTypes(x, func(ctx {{ $Context }}, x {{ $Root }}) {{ $Decision }} {
+		switch x := x.(type) {
+		{{- range $s := Structs $v }}
+		{{- if ValueFacade $s }}
+		case {{ $s }}:
+			cp := x
+			next := t.on{{ $s.Ident }}(&cp)
+			return {{ $transformed }}(ctx, next, next == nil, next == &cp && Equal{{ $Root }}(x, cp), "{{ $s }}")
+		{{- else }}
+		case *{{ $s }}:
+			next := t.on{{ $s.Ident }}(x)
+			return {{ $transformed }}(ctx, next, next == nil, next == x, "{{ $s }}")
+		{{- end }}
+		{{- end }}
+		}
+		return ctx.Continue()
+	}, types...)
+}
+
+// {{ $transformed }} returns the decision for the value returned by a
+// function registered with a {{ $Transformer }}, which is shared by
+// values visited by reference and by value.
+func {{ $transformed }}(ctx {{ $Context }}, next {{ $Root }}, isNil, unchanged bool, name string) {{ $Decision }} {
+	switch {
+	case isNil:
+		return ctx.Error(fmt.Errorf("the function for %s returned nil", name))
+	case unchanged:
+		return ctx.Continue()
+	default:
+		return ctx.Continue().Replace(next)
+	}
+}
+`
+}
@@ -0,0 +1,66 @@
+// Copyright 2019 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+package templates
+
+func init() {
+	TemplateSources["60tree"] = `
+{{- $v := . -}}
+{{- $Context := T $v "Context" -}}
+{{- $Decision := T $v "Decision" -}}
+{{- $Engine := Engine $v -}}
+{{- $identify := t $v "Identify" -}}
+{{- $Root := $v.Root -}}
+{{- $TypeID := T $v "TypeID" -}}
+{{- $WalkerFn := T $v "WalkerFn" -}}
+
+// ------ Tree Assertions ------
+
+// Assert{{ $Root }}IsTree visits root and returns an error if any
+// struct value is reachable from more than one location, i.e. if the
+// values form a DAG instead of a tree. The error describes the paths
+// to both locations. Back-references which form cycles are not visited
+// and so are not reported; use Walk{{ $Root }}DetectCycles to find
+// them. Structs which are passed to callbacks by value cannot be
+// identified, so they are not checked.
+func Assert{{ $Root }}IsTree(root {{ $Root }}) error {
+	if root == nil {
+		return nil
+	}
+	id, ptr := {{ $identify }}(root)
+	if ptr == nil {
+		return nil
+	}
+	type key struct {
+		id  e.TypeID
+		ptr e.Ptr
+	}
+	seen := make(map[key]e.Path)
+	fn := func(ctx {{ $Context }}, x {{ $Root }}) {{ $Decision }} {
+		id, ptr := {{ $identify }}(x)
+		k := key{id, ptr}
+		path := ctx.impl.Path()
+		if prior, found := seen[k]; found {
+			return ctx.Error(fmt.Errorf("%s is reachable from both %q and %q",
+				{{ $TypeID }}(id), prior, path))
+		}
+		seen[k] = path
+		return ctx.Continue()
+	}
+	_, _, _, err := {{ $Engine }}.Execute({{ $WalkerFn }}(fn), id, ptr, e.TypeID({{ TypeID $Root }}))
+	return err
+}
+`
+}
@@ -19,22 +19,56 @@ func init() {
 	TemplateSources["75typemap"] = `
 {{- $v := . -}}
 {{- $Context := T $v "Context" -}}
-{{- $Engine := t $v "Engine" -}}
+{{- $Engine := Engine $v -}}
+{{- $engine := EngineVar $v -}}
 {{- $TypeID := T $v "TypeID" -}}
 {{- $WalkerFn := T $v "WalkerFn" -}}
+{{- $ctx := printf "%s{impl}" $Context -}}
+{{- if TypemapOnly $v }}{{ $ctx = "impl" }}{{ end -}}
 // ------ Type Mapping ------
-var {{ $Engine }} = e.New(e.TypeMap {
+{{- if LazyEngine $v }}
+var (
+	{{ $engine }}     *e.Engine
+	{{ $engine }}Once sync.Once
+)
+
+// {{ EngineAccessor $v }} constructs the engine on first use, which
+// avoids building the type map when the package is initialized.
+func {{ EngineAccessor $v }}() *e.Engine {
+	{{ $engine }}Once.Do(func() {
+		{{ $engine }} = e.New(e.TypeMap {
+{{- else }}
+var {{ $engine }} = e.New(e.TypeMap {
+{{- end }}
 // ------ Structs ------
 {{ range $s := Structs $v }}{{ TypeID $s }}: {
 	Copy: func(dest, from e.Ptr) { *(*{{ $s }})(dest) = *(*{{ $s }})(from) },
 	Facade: func(impl e.Context, fn e.FacadeFn, x e.Ptr) e.Decision {
-		return e.Decision(fn.({{ $WalkerFn }})({{ $Context }}{impl}, (*{{ $s }})(x)))
+		{{- if ValueFacade $s }}
+		return e.Decision(fn.({{ $WalkerFn }})({{ $ctx }}, *(*{{ $s }})(x)))
+		{{- else }}
+		return e.Decision(fn.({{ $WalkerFn }})({{ $ctx }}, (*{{ $s }})(x)))
+		{{- end }}
 	},
 	Fields: []e.FieldInfo {
 		{{ range $f := $s.Fields -}}
 		{ Name: "{{ $f }}", Offset: unsafe.Offsetof({{ $s }}{}.{{ $f }}), Target: e.TypeID({{ TypeID $f.Target }})},
 		{{ end }}
 	},
+	{{- if $s.Getters }}
+	Getters: []e.GetterInfo {
+		{{ range $g := $s.Getters -}}
+		{
+			Get: func(x e.Ptr) e.Ptr {
+				ret := (*{{ $s }})(x).{{ $g }}()
+				return e.Ptr(&ret)
+			},
+			Name: "{{ $g }}",
+			Target: e.TypeID({{ TypeID $g.Target }}),
+		},
+		{{ end }}
+	},
+	{{- end }}
 	Name: "{{ $s }}",
 	NewStruct: func() e.Ptr { return e.Ptr(&{{ $s }}{}) },
 	SizeOf: unsafe.Sizeof({{ $s }}{}),
@@ -62,7 +96,7 @@ var {{ $Engine }} = e.New(e.TypeMap {
 		switch {{ $TypeID }}(id) {
 		{{ range $imp := Implementors $s -}}
 			{{- if IsPointer $imp.Actual -}}
-				case {{ TypeID $imp.Actual.Elem }}: d = (*{{ $imp.Actual.Elem }})(x);
+				case {{ TypeID $imp.Actual.Elem }}: d = {{ if ValueFacade $imp.Actual.Elem }}*{{ end }}(*{{ $imp.Actual.Elem }})(x);
 				case {{ TypeID $imp.Actual }}: d = *(*{{ $imp.Actual }})(x);
 			{{- end -}}
 		{{- end }}
@@ -88,6 +122,58 @@ var {{ $Engine }} = e.New(e.TypeMap {
 	TypeID: e.TypeID({{ TypeID $s }}),
 },
 {{ end }}
+// ------ Arrays ------
+{{ range $s := Arrays $v }}{{ TypeID $s }}: {
+	Copy: func(dest, from e.Ptr) {
+		*(*{{ $s }})(dest) = *(*{{ $s }})(from)
+	},
+	Elem: e.TypeID({{ TypeID $s.Elem }}),
+	Kind: e.KindArray,
+	Len: {{ $s.Len }},
+	NewArray: func() e.Ptr { return e.Ptr(&{{ $s }}{}) },
+	SizeOf: unsafe.Sizeof({{ $s }}{}),
+	TypeID: e.TypeID({{ TypeID $s }}),
+},
+{{ end }}
+// ------ Maps ------
+{{ range $s := Maps $v }}{{ TypeID $s }}: {
+	Copy: func(dest, from e.Ptr) {
+		*(*{{ $s }})(dest) = *(*{{ $s }})(from)
+	},
+	Elem: e.TypeID({{ TypeID $s.Elem }}),
+	Kind: e.KindMap,
+	MapEntries: func(x e.Ptr) (keys, values []e.Ptr) {
+		m := *(*{{ $s }})(x)
+		ks := make([]{{ $s.Key.GoType }}, 0, len(m))
+		for k := range m {
+			ks = append(ks, k)
+		}
+		{{- if $s.Ordered }}
+		sort.Slice(ks, func(i, j int) bool { return ks[i] < ks[j] })
+		{{- end }}
+		vs := make([]{{ $s.Elem }}, len(ks))
+		keys, values = make([]e.Ptr, len(ks)), make([]e.Ptr, len(ks))
+		for i, k := range ks {
+			vs[i] = m[k]
+			keys[i], values[i] = e.Ptr(&ks[i]), e.Ptr(&vs[i])
+		}
+		return keys, values
+	},
+	MapKey: func(key e.Ptr) interface{} {
+		return *(*{{ $s.Key.GoType }})(key)
+	},
+	Name: "{{ $s }}",
+	NewMap: func(size int) e.Ptr {
+		x := make({{ $s }}, size)
+		return e.Ptr(&x)
+	},
+	SetMapIndex: func(m, key, value e.Ptr) {
+		(*(*{{ $s }})(m))[*(*{{ $s.Key.GoType }})(key)] = *(*{{ $s.Elem }})(value)
+	},
+	SizeOf: unsafe.Sizeof(({{ $s }})(nil)),
+	TypeID: e.TypeID({{ TypeID $s }}),
+},
+{{ end }}
 // ------ Slices ------
 {{ range $s := Slices $v }}{{ TypeID $s }}: {
 	Copy: func(dest, from e.Ptr) {
@@ -104,16 +190,49 @@ var {{ $Engine }} = e.New(e.TypeMap {
 },
 {{ end }}
 })
+{{- if LazyEngine $v }}
+	})
+	return {{ $engine }}
+}
+{{- end }}
 
-// These are lightweight type tokens. 
+// These are lightweight type tokens. A token retains its value when
+// the code is regenerated, so that tokens may be persisted.
 const (
-	_ {{ T $v "TypeID" }} = iota
-{{ range $t := $v.Types }}{{ TypeID $t }};{{ end }}
+{{- range $t := TypeIDs $v }}
+	{{ $t.ID }} {{ $TypeID }} = {{ $t.Value }}
+{{- end }}
 )
 
+// {{ t $v "TypeIDLimit" }} is one greater than the largest type token
+// that has ever been assigned. It is used by the code generator to
+// ensure that the tokens of removed types are not reused.
+const {{ t $v "TypeIDLimit" }} = {{ TypeIDLimit $v }}
+
 // String is for debugging use only.
 func (t {{ $TypeID }}) String() string {
 	return {{ $Engine }}.Stringify(e.TypeID(t))
 }
+
+// Implements returns true if the struct type denoted by the token
+// implements the interface type denoted by intf.
+func (t {{ $TypeID }}) Implements(intf {{ $TypeID }}) bool {
+	_, ok := {{ t $v "Implements" }}[t][intf]
+	return ok
+}
+
+// {{ t $v "Implements" }} maps struct type tokens onto the interface
+// type tokens that they implement.
+var {{ t $v "Implements" }} = map[{{ $TypeID }}]map[{{ $TypeID }}]struct{}{
+{{- range $s := Structs $v }}
+	{{- with Implemented $s }}
+	{{ TypeID $s }}: {
+		{{- range $intf := . }}
+		{{ TypeID $intf }}: {},
+		{{- end }}
+	},
+	{{- end }}
+{{- end }}
+}
 `
 }
@@ -0,0 +1,90 @@
+// Copyright 2019 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+package templates
+
+func init() {
+	TemplateSources["10typemaponly"] = `
+{{- $v := . -}}
+{{- if TypemapOnly $v -}}
+{{- $Engine := Engine $v -}}
+{{- $Identify := T $v "Identify" -}}
+{{- $identifiers := t $v "Identifiers" -}}
+{{- $Root := $v.Root -}}
+{{- $TypeID := T $v "TypeID" -}}
+{{- $WalkerFn := T $v "WalkerFn" -}}
+{{- $Wrap := T $v "Wrap" -}}
+{{- $wrappers := t $v "Wrappers" -}}
+// ------ Engine support ------
+
+// {{ $TypeID }} is a lightweight type token.
+type {{ $TypeID }} e.TypeID
+
+// {{ $WalkerFn }} is the type of the callback which must be passed to
+// {{ $Engine }}.Execute. The engine will not accept a func literal
+// unless it has been converted to this type.
+type {{ $WalkerFn }} func(ctx e.Context, x {{ $Root }}) e.Decision
+
+// {{ $Identify }} maps a {{ $Root }} into its type token and a
+// pointer to the data, as accepted by {{ $Engine }}.
+func {{ $Identify }}(x {{ $Root }}) (typeId e.TypeID, data e.Ptr) {
+	fn, ok := {{ $identifiers }}[reflect.TypeOf(x)]
+	if !ok {
+		// The most probable reason for this is that the generated code
+		// is out of date, or that an implementation of the {{ $Root }}
+		// interface from another package is being passed in.
+		panic(fmt.Sprintf("unhandled value of type: %T", x))
+	}
+	return fn(x)
+}
+
+// {{ $identifiers }} maps the dynamic type of a {{ $Root }} to a
+// function which returns its type token and a pointer to its data.
+var {{ $identifiers }} = map[reflect.Type]func(x {{ $Root }}) (e.TypeID, e.Ptr){
+	{{ range $imp := Implementors $Root -}}
+	reflect.TypeOf({{ if IsPointer $imp.Actual }}({{ $imp.Actual }})(nil){{ else }}(*{{ $imp.Actual }})(nil)).Elem({{ end }}): func(x {{ $Root }}) (e.TypeID, e.Ptr) {
+		{{ if IsPointer $imp.Actual }}return e.TypeID({{ TypeID $imp.Underlying }}), e.Ptr(x.({{ $imp.Actual }}))
+		{{ else }}t := x.({{ $imp.Actual }})
+		return e.TypeID({{ TypeID $imp.Underlying }}), e.Ptr(&t)
+		{{ end -}}
+	},
+	{{ end -}}
+}
+
+// {{ $Wrap }} reconstitutes a {{ $Root }} from a type token and a
+// pointer to the value, as returned by {{ $Engine }}.
+func {{ $Wrap }}(typeId e.TypeID, x e.Ptr) {{ $Root }} {
+	if typeId >= 0 && int(typeId) < len({{ $wrappers }}) {
+		if fn := {{ $wrappers }}[typeId]; fn != nil {
+			return fn(x)
+		}
+	}
+	// This is likely a code-generation problem.
+	panic(fmt.Sprintf("unhandled TypeID %d", typeId))
+}
+
+// {{ $wrappers }} holds a function for each type token which
+// reconstitutes a {{ $Root }} from a pointer to the value.
+var {{ $wrappers }} = []func(x e.Ptr) {{ $Root }}{
+	{{- range $imp := Implementors $Root }}
+		{{- if IsPointer $imp.Actual }}
+	{{ TypeID $imp.Actual.Elem }}: func(x e.Ptr) {{ $Root }} { return (*{{ $imp.Actual.Elem }})(x) },
+	{{ TypeID $imp.Actual }}: func(x e.Ptr) {{ $Root }} { return *(*{{ $imp.Actual }})(x) },
+		{{- end }}
+	{{- end }}
+}
+{{- end -}}
+`
+}
@@ -22,7 +22,9 @@ func init() {
 {{- if $Union -}}
 // ------ Union Support -----
 type {{ $Union }} interface {
+	{{- if not (or (UnionOnly $v) (TypemapOnly $v)) }}
 	{{ $Union }}Abstract
+	{{- end }}
 	is{{ $Union }}Type()
 }
 
@@ -32,8 +34,8 @@ var (
 {{- end -}}
 )
 
-{{- range $s := Structs $v }}
-func (*{{ $s }}) is{{ $Union }}Type() {}
+{{- range $r := Receivers $v }}
+func (*{{ $r }}) is{{ $Union }}Type() {}
 {{- end -}}
 {{- end -}}
 `
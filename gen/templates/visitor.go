@@ -0,0 +1,56 @@
+// Copyright 2019 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+package templates
+
+func init() {
+	TemplateSources["60visitor"] = `
+{{- $v := . -}}
+{{- $Context := T $v "Context" -}}
+{{- $Decision := T $v "Decision" -}}
+{{- $Root := $v.Root -}}
+{{- $WalkerFn := T $v "WalkerFn" -}}
+{{- with Visitor $v }}
+
+// ------ Visitor Adapter ------
+
+// Walk{{ $Root }}{{ . }} drives a {{ . }} over x, following the protocol
+// of go/ast.Walk. The Visit method is called for each visitable struct.
+// If it returns a nil visitor, the children of the struct are skipped.
+// Otherwise, the children are visited with the returned visitor w,
+// followed by a call to w.Visit(nil).
+func Walk{{ $Root }}{{ . }}(x {{ $Root }}, v {{ . }}) error {
+	if v == nil {
+		return nil
+	}
+	stack := []{{ . }}{v}
+	var fn {{ $WalkerFn }} = func(ctx {{ $Context }}, x {{ $Root }}) {{ $Decision }} {
+		w := stack[len(stack)-1].Visit(x)
+		if w == nil {
+			return ctx.Skip()
+		}
+		stack = append(stack, w)
+		return ctx.Continue().Post(func(ctx {{ $Context }}, _ {{ $Root }}) {{ $Decision }} {
+			stack = stack[:len(stack)-1]
+			w.Visit(nil)
+			return ctx.Continue()
+		})
+	}
+	_, _, err := Walk{{ $Root }}(x, fn)
+	return err
+}
+{{- end }}
+`
+}
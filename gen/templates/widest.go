@@ -0,0 +1,53 @@
+// Copyright 2019 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+package templates
+
+func init() {
+	TemplateSources["60widest"] = `
+{{- $v := . -}}
+{{- $Context := T $v "Context" -}}
+{{- $Decision := T $v "Decision" -}}
+{{- $Engine := Engine $v -}}
+{{- $identify := t $v "Identify" -}}
+{{- $Root := $v.Root -}}
+{{- $WalkerFn := T $v "WalkerFn" -}}
+
+// ------ Fan-out ------
+
+// WidestSlice{{ $Root }} returns the location and length of the longest
+// visitable slice within root, e.g. "TargetSlice[2].NamedTargets". If
+// several slices share the longest length, the first one to be visited
+// is returned. An empty path and a zero length are returned if root
+// does not contain any non-empty slices.
+func WidestSlice{{ $Root }}(root {{ $Root }}) (path string, length int) {
+	if root == nil {
+		return "", 0
+	}
+	id, ptr := {{ $identify }}(root)
+	if ptr == nil {
+		return "", 0
+	}
+	var fn {{ $WalkerFn }} = func(ctx {{ $Context }}, _ {{ $Root }}) (d {{ $Decision }}) { return }
+	_, _, _, _ = {{ $Engine }}.Execute(fn, id, ptr, e.TypeID({{ TypeID $Root }}),
+		e.WithSliceHook(func(p e.Path, _ e.TypeID, n int) {
+			if n > length {
+				path, length = p.String(), n
+			}
+		}))
+	return path, length
+}
+`
+}
@@ -17,7 +17,11 @@ package gen
 
 import (
 	"fmt"
+	"go/build/constraint"
+	"go/constant"
 	"go/types"
+	"path/filepath"
+	"sort"
 	"strings"
 
 	"github.com/pkg/errors"
@@ -38,6 +42,8 @@ func (s SourceName) String() string { return string(s) }
 // API template and exposes many convenience functions to keep
 // the template simple.
 type visitation struct {
+	// The names of types which must not be visited.
+	excluded map[string]bool
 	// The interfaces that are used to select structs to be included
 	// in the visitation.
 	filters []visitableType
@@ -47,11 +53,24 @@ type visitation struct {
 	includeReachable bool
 	inTest           bool
 	packagePath      string
+	// The values of the type tokens emitted by a previous run of the
+	// code generator, which will be retained.
+	priorTypeIDs map[TypeID]int
+	// One greater than the largest type token emitted by a previous run
+	// of the code generator.
+	priorTypeIDLimit int
 	// The root visitable interface.
 	Root namedInterfaceType
+	// The name of the go/ast-style visitor interface to adapt, if any.
+	Visitor string
 	// types collects all referenced types, indexed by their type id.
 	Types       map[TypeID]visitableType
 	SourceTypes map[SourceName]visitableType
+	// The names of the types which were given as inputs for this
+	// visitation.
+	typeNames []string
+	// The build constraints of the files which declare the input types.
+	constraints []constraint.Expr
 }
 
 func (v *visitation) findSeedTypes(scopes []*types.Scope) error {
@@ -59,13 +78,14 @@ func (v *visitation) findSeedTypes(scopes []*types.Scope) error {
 
 	// Resolve all of the specified type names to an interface or struct.
 name:
-	for _, name := range g.typeNames {
+	for _, name := range v.typeNames {
 		for _, scope := range scopes {
 			obj := scope.Lookup(name)
 			if obj == nil {
 				continue
 			}
-			if named, ok := obj.Type().(*types.Named); ok {
+			// The name may be an alias of the type that we're looking for.
+			if named, ok := types.Unalias(obj.Type()).(*types.Named); ok {
 				var filter visitableType
 				switch u := named.Underlying().(type) {
 				case *types.Interface:
@@ -75,7 +95,7 @@ name:
 						Interface: u,
 						v:         v,
 					}
-					if g.union == "" && len(g.typeNames) == 1 {
+					if g.union == "" && len(v.typeNames) == 1 {
 						v.Root = intf
 					}
 					filter = intf
@@ -97,12 +117,20 @@ name:
 				v.filters = append(v.filters, filter)
 
 				// If the type refers to anything defined in a test file, generate
-				// into a _test.go file as well.
+				// into a _test.go file as well. Similarly, the generated code
+				// is subject to the build constraints of the file.
 				if obj.Pos().IsValid() {
 					position := g.fileSet.Position(obj.Pos())
 					if strings.HasSuffix(position.Filename, "_test.go") {
 						v.inTest = true
 					}
+					expr, err := g.fileConstraint(position.Filename)
+					if err != nil {
+						return err
+					}
+					if expr != nil {
+						v.constraints = append(v.constraints, expr)
+					}
 				}
 				continue name
 			}
@@ -112,6 +140,166 @@ name:
 	return nil
 }
 
+// findExcludedTypes resolves the type names which were passed to
+// --exclude. An error is returned if a name does not refer to a type in
+// the package, to catch typos, or if it was also named as an input.
+func (v *visitation) findExcludedTypes(scopes []*types.Scope) error {
+	g := v.gen
+	if len(g.exclude) == 0 {
+		return nil
+	}
+	v.excluded = make(map[string]bool, len(g.exclude))
+
+name:
+	for _, name := range g.exclude {
+		for _, typeName := range g.typeNames {
+			if name == typeName {
+				return errors.Errorf("%q cannot be both visited and excluded", name)
+			}
+		}
+		for _, scope := range scopes {
+			if _, ok := scope.Lookup(name).(*types.TypeName); ok {
+				v.excluded[name] = true
+				continue name
+			}
+		}
+		return errors.Errorf("unknown excluded type %q", name)
+	}
+	return nil
+}
+
+// findPriorTypeIDs records the values of any type tokens which were
+// emitted into the package by a previous run of the code generator.
+// Retaining these values allows the tokens to be persisted, since
+// adding a type won't change the value of any existing token.
+func (v *visitation) findPriorTypeIDs(scopes []*types.Scope) {
+	rootName := v.Root.String()
+	typeIDName := rootName + "TypeID"
+	limitName := strings.ToLower(rootName[:1]) + rootName[1:] + "TypeIDLimit"
+
+	v.priorTypeIDs = make(map[TypeID]int)
+	for _, scope := range scopes {
+		for _, name := range scope.Names() {
+			c, ok := scope.Lookup(name).(*types.Const)
+			if !ok {
+				continue
+			}
+			val, ok := constant.Int64Val(constant.ToInt(c.Val()))
+			if !ok || val <= 0 {
+				continue
+			}
+			if name == limitName {
+				if int(val) > v.priorTypeIDLimit {
+					v.priorTypeIDLimit = int(val)
+				}
+				continue
+			}
+			if named, ok := c.Type().(*types.Named); ok && named.Obj().Name() == typeIDName {
+				v.priorTypeIDs[TypeID(name)] = int(val)
+				if int(val) >= v.priorTypeIDLimit {
+					v.priorTypeIDLimit = int(val) + 1
+				}
+			}
+		}
+	}
+}
+
+// typeIDValue associates a type token with its value.
+type typeIDValue struct {
+	ID    TypeID
+	Value int
+}
+
+// assignTypeIDs returns the values of the type tokens, ordered by
+// value, along with a limit that is one greater than the largest
+// value. Tokens which were emitted by a previous run retain their
+// values. New tokens are assigned, in sorted order, values which are
+// greater than any previously-assigned value. The values of tokens
+// for types which have been removed are therefore not reused.
+func (v *visitation) assignTypeIDs() (values []typeIDValue, limit int) {
+	limit = v.priorTypeIDLimit
+	if limit == 0 {
+		limit = 1
+	}
+
+	ids := make([]TypeID, 0, len(v.Types))
+	for id := range v.Types {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+	for _, id := range ids {
+		value, ok := v.priorTypeIDs[id]
+		if !ok {
+			value = limit
+			limit++
+		}
+		values = append(values, typeIDValue{id, value})
+	}
+	sort.Slice(values, func(i, j int) bool { return values[i].Value < values[j].Value })
+	return values, limit
+}
+
+// findVisitor resolves the interface named by --visitor and ensures
+// that it follows the go/ast.Visitor protocol, i.e. that it has a
+// method Visit(x Root) V, where V is the interface itself.
+func (v *visitation) findVisitor(scopes []*types.Scope) error {
+	name := v.gen.visitor
+	if name == "" {
+		return nil
+	}
+	for _, scope := range scopes {
+		obj := scope.Lookup(name)
+		if obj == nil {
+			continue
+		}
+		named, ok := types.Unalias(obj.Type()).(*types.Named)
+		if !ok {
+			break
+		}
+		intf, ok := named.Underlying().(*types.Interface)
+		if !ok {
+			break
+		}
+		for i := 0; i < intf.NumMethods(); i++ {
+			m := intf.Method(i)
+			if m.Name() != "Visit" {
+				continue
+			}
+			sig := m.Type().(*types.Signature)
+			if sig.Params().Len() != 1 || sig.Results().Len() != 1 ||
+				!types.Identical(sig.Results().At(0).Type(), named) ||
+				!v.acceptsRoot(sig.Params().At(0).Type()) {
+				break
+			}
+			v.Visitor = name
+			return nil
+		}
+		return errors.Errorf("--visitor %s must have a method Visit(%s) %s", name, v.Root, name)
+	}
+	return errors.Errorf("--visitor %s must name an interface", name)
+}
+
+// acceptsRoot returns true if values of the root visitable interface
+// may be assigned to the given type.
+func (v *visitation) acceptsRoot(typ types.Type) bool {
+	if v.Root.Named != nil {
+		return types.AssignableTo(v.Root.Named, typ)
+	}
+	// The union interface may not have been generated yet, in which
+	// case references to it will be invalid.
+	if basic, ok := typ.(*types.Basic); ok && basic.Kind() == types.Invalid {
+		return true
+	}
+	if named, ok := typ.(*types.Named); ok && named.Obj().Name() == v.Root.Union {
+		return true
+	}
+	if intf, ok := typ.Underlying().(*types.Interface); ok && intf.Empty() {
+		return true
+	}
+	return false
+}
+
 // populateGeneratedTypes finds top-level types that we will generate
 // additional methods for.
 func (v *visitation) populateGeneratedTypes(scopes []*types.Scope) {
@@ -130,12 +318,182 @@ func (v *visitation) populateGeneratedTypes(scopes []*types.Scope) {
 	}
 }
 
+// buildConstraint returns the expression to use in a //go:build line,
+// which restricts the generated code to the configured platform and
+// to the configurations in which the input types are declared. It
+// returns an empty string if the generated code is unconstrained.
+func (v *visitation) buildConstraint() string {
+	ret := v.gen.platformConstraint()
+	seen := make(map[string]bool, len(v.constraints))
+	for _, expr := range v.constraints {
+		// Input types are often declared in the same file.
+		if key := expr.String(); !seen[key] {
+			seen[key] = true
+			ret = andConstraints(ret, expr)
+		}
+	}
+	if ret == nil {
+		return ""
+	}
+	return ret.String()
+}
+
+// claimAccessors records the accessor methods which have been generated
+// for each struct, so that a subsequent visitation in the same run will
+// not generate methods with the same names.
+func (v *visitation) claimAccessors() {
+	if v.gen.accessors == nil {
+		v.gen.accessors = make(map[string]map[string]bool)
+	}
+	for _, t := range v.Types {
+		s, ok := t.Implementation().(namedStruct)
+		if !ok || s.Generic() {
+			continue
+		}
+		claimed := v.gen.accessors[s.String()]
+		if claimed == nil {
+			claimed = make(map[string]bool)
+			v.gen.accessors[s.String()] = claimed
+		}
+		for _, f := range s.Accessors() {
+			claimed[f.Builder] = true
+			claimed[f.Method] = true
+			if f.Map != nil {
+				claimed[f.Value] = true
+				claimed[f.ValueBuilder] = true
+			}
+		}
+	}
+}
+
+// checkConcreteFields returns an error if any visitable struct has a
+// field which declares invalid concrete types. The structs are checked
+// in order of their names, so that the error is deterministic.
+func (v *visitation) checkConcreteFields() error {
+	structs := make(map[string]namedStruct)
+	names := make([]string, 0, len(v.Types))
+	for _, t := range v.Types {
+		if s, ok := t.Implementation().(namedStruct); ok {
+			if _, found := structs[s.String()]; !found {
+				structs[s.String()] = s
+				names = append(names, s.String())
+			}
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		s := structs[name]
+		if err := s.checkConcreteFields(s.Struct); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// emptySeedWarnings returns a diagnostic message for each struct that
+// was explicitly named as a seed type, but which has no visitable
+// fields. This is usually a mistake, such as forgetting to export a
+// field.
+func (v *visitation) emptySeedWarnings() []string {
+	var ret []string
+	for _, filter := range v.filters {
+		if s, ok := filter.(namedStruct); ok && s.NumChildren() == 0 {
+			ret = append(ret, fmt.Sprintf(
+				"%s was named explicitly, but has no visitable fields; "+
+					"check that its fields are exported and of visitable types", s))
+		}
+	}
+	return ret
+}
+
+// recursiveValueWarnings returns a diagnostic message for each struct
+// which encloses a value of its own type without an intervening
+// pointer or interface, e.g. "type Node struct { Children []Node }".
+// Such types are supported, but replacing a value within them will
+// copy every enclosing value, and they may be nested arbitrarily
+// deeply.
+func (v *visitation) recursiveValueWarnings() []string {
+	structs := make(map[string]namedStruct)
+	names := make([]string, 0, len(v.Types))
+	for _, t := range v.Types {
+		if s, ok := t.Implementation().(namedStruct); ok {
+			if _, found := structs[s.String()]; !found {
+				structs[s.String()] = s
+				names = append(names, s.String())
+			}
+		}
+	}
+	sort.Strings(names)
+
+	var ret []string
+	for _, name := range names {
+		s := structs[name]
+		if path := valueCycle(s, s.Named, make(map[string]bool)); path != nil {
+			ret = append(ret, fmt.Sprintf(
+				"%s encloses itself by value through %s; "+
+					"consider using a pointer to break the cycle", s, strings.Join(path, " -> ")))
+		}
+	}
+	return ret
+}
+
+// valueCycle returns the fields, starting from the struct, through
+// which a value of the target type is enclosed by value. It returns
+// nil if there are no such fields.
+func valueCycle(s namedStruct, target *types.Named, seen map[string]bool) []string {
+	if seen[s.String()] {
+		return nil
+	}
+	seen[s.String()] = true
+	for _, f := range s.Fields() {
+		next, ok := valueStruct(f.Target)
+		if !ok {
+			continue
+		}
+		field := fmt.Sprintf("%s.%s", s, f.Name)
+		if types.Identical(next.Named, target) {
+			return []string{field}
+		}
+		if path := valueCycle(next, target, seen); path != nil {
+			return append([]string{field}, path...)
+		}
+	}
+	return nil
+}
+
+// valueStruct returns the struct which is stored by value in a slot of
+// the given type, looking through slices, arrays, and maps.
+func valueStruct(t visitableType) (namedStruct, bool) {
+	switch t := t.Implementation().(type) {
+	case namedStruct:
+		return t, true
+	case namedArrayType:
+		return valueStruct(t.Elem)
+	case namedMapType:
+		return valueStruct(t.Elem)
+	case namedSliceType:
+		return valueStruct(t.Elem)
+	default:
+		return namedStruct{}, false
+	}
+}
+
 // ensureTypeID ensures that the types map contains an entry
-// for the given type.
+// for the given type, as well as for any element types.
 func (v *visitation) ensureTypeID(i visitableType) TypeID {
 	ret := v.typeID(i)
 	if _, found := v.Types[ret]; !found {
 		v.Types[ret] = i
+		switch t := i.Implementation().(type) {
+		case namedArrayType:
+			v.ensureTypeID(t.Elem)
+		case namedMapType:
+			v.ensureTypeID(t.Elem)
+		case namedSliceType:
+			v.ensureTypeID(t.Elem)
+		case pointerType:
+			v.ensureTypeID(t.Elem)
+		}
 	}
 	return ret
 }
@@ -147,10 +505,20 @@ func (v *visitation) ensureTypeID(i visitableType) TypeID {
 //   []Foo -> FooSlice
 //   []*Foo -> FooPtrSlice
 //   *[]Foo -> FooSlicePtr
+//   [4]Foo -> FooArray4
+//   map[string]Foo -> FooMapByString
+//   Optional[*Foo] -> OptionalOfFooPtr
 func (v *visitation) typeID(i visitableType) TypeID {
 	suffix := ""
 	for {
 		switch t := i.(type) {
+		case namedArrayType:
+			suffix = fmt.Sprintf("Array%d", t.Len) + suffix
+			i = t.Elem
+		case namedMapType:
+			key := t.Key.GoType
+			suffix = "MapBy" + strings.ToUpper(key[:1]) + key[1:] + suffix
+			i = t.Elem
 		case pointerType:
 			suffix = "Ptr" + suffix
 			i = t.Elem
@@ -159,6 +527,10 @@ func (v *visitation) typeID(i visitableType) TypeID {
 			i = t.Elem
 		case namedVisitableType:
 			i = t.Underlying
+		case namedStruct:
+			return TypeID(fmt.Sprintf("%sType%s%s", v.Root, t.Ident(), suffix))
+		case concreteInterface:
+			return TypeID(fmt.Sprintf("%sType%s%s", v.Root, t.Ident(), suffix))
 		default:
 			return TypeID(fmt.Sprintf("%sType%s%s", v.Root, t, suffix))
 		}
@@ -168,14 +540,36 @@ func (v *visitation) typeID(i visitableType) TypeID {
 // visitableType extracts the type information that we care about
 // from typ. This handles named and anonymous types that are visitable.
 func (v *visitation) visitableType(typ types.Type, isReachable bool) (visitableType, bool) {
-	switch t := typ.(type) {
+	// Aliases are transparent, so we want to look at the aliased type.
+	switch t := types.Unalias(typ).(type) {
 	case *types.Named:
 		// Ignore un-exported types or those from other packages.
 		if !t.Obj().Exported() || t.Obj().Pkg().Path() != v.packagePath {
 			return nil, false
 		}
 
-		sourceName := SourceName(t.Obj().Name())
+		// Excluded types are opaque, even if they would otherwise be
+		// reachable.
+		if v.excluded[t.Obj().Name()] {
+			return nil, false
+		}
+
+		// Generic types can only be visited once they have been
+		// instantiated, e.g. by a field of type Optional[*Node]. Each
+		// instantiation is treated as a distinct type.
+		if t.TypeParams().Len() > 0 && t.TypeArgs().Len() == 0 {
+			return nil, false
+		}
+		if t.TypeArgs().Len() > 0 {
+			if _, ok := v.typeIdent(t); !ok {
+				return nil, false
+			}
+			if _, isIntf := t.Underlying().(*types.Interface); isIntf {
+				return nil, false
+			}
+		}
+
+		sourceName := SourceName(namedString(t))
 		if ret, ok := v.SourceTypes[sourceName]; ok {
 			return ret, true
 		}
@@ -212,6 +606,7 @@ func (v *visitation) visitableType(typ types.Type, isReachable bool) (visitableT
 				v.SourceTypes[sourceName] = ret
 				v.ensureTypeID(ret)
 				ret.Fields()
+				_, _ = ret.Getters()
 				return ret, true
 			}
 
@@ -265,10 +660,105 @@ func (v *visitation) visitableType(typ types.Type, isReachable bool) (visitableT
 		if elem, ok := v.visitableType(t.Elem(), isReachable); ok {
 			return namedSliceType{Elem: elem}, true
 		}
+
+	case *types.Array:
+		if elem, ok := v.visitableType(t.Elem(), isReachable); ok {
+			return namedArrayType{Elem: elem, Len: t.Len()}, true
+		}
+
+	case *types.Map:
+		key, ok := v.scalarType(t.Key())
+		if !ok {
+			return nil, false
+		}
+		if elem, ok := v.visitableType(t.Elem(), isReachable); ok {
+			return namedMapType{Elem: elem, Key: key}, true
+		}
 	}
 	return nil, false
 }
 
+// engineVar returns the name of the variable which holds the engine.
+// The variable is exported when --typemap-only is used.
+func (v *visitation) engineVar() string {
+	if v.gen.engineVar != "" {
+		return v.gen.engineVar
+	}
+	intfName := v.Root.String()
+	if v.gen.typemapOnly {
+		return intfName + "Engine"
+	}
+	return strings.ToLower(intfName[:1]) + intfName[1:] + "Engine"
+}
+
+// engineAccessor returns the name of the function which constructs the
+// engine when --lazy-engine is used.
+func (v *visitation) engineAccessor() string {
+	name := v.engineVar()
+	return "get" + strings.ToUpper(name[:1]) + name[1:]
+}
+
+// checkCollisions returns an error if the identifiers that we use to
+// hold the engine have already been declared in the package, other
+// than by a previous run of the code generator. Otherwise, we would
+// produce code that does not compile.
+func (v *visitation) checkCollisions(scopes []*types.Scope) error {
+	names := []string{v.engineVar()}
+	if v.gen.lazyEngine {
+		names = append(names, v.engineVar()+"Once", v.engineAccessor())
+	}
+
+	// Our own outputs will be overwritten, so declarations within them
+	// aren't collisions.
+	own, err := v.ownOutputs()
+	if err != nil {
+		return err
+	}
+
+	for _, name := range names {
+		for _, scope := range scopes {
+			obj := scope.Lookup(name)
+			if obj == nil || !obj.Pos().IsValid() || v.declaredIn(own, obj) {
+				continue
+			}
+			position := v.gen.fileSet.Position(obj.Pos())
+			return errors.Errorf("%s is already declared at %s; use --engine-var to choose a different name",
+				name, position)
+		}
+	}
+	return nil
+}
+
+// ownOutputs returns the absolute names of the files which the code
+// generator will overwrite. This includes the outputs of a previous
+// run which used a different --split setting.
+func (v *visitation) ownOutputs() (map[string]bool, error) {
+	outputs := map[string]bool{v.outName(""): true, v.defaultOutName(""): true}
+	for key := range allTemplates {
+		outputs[v.defaultOutName(strings.TrimLeft(key, "0123456789"))] = true
+	}
+	ret := make(map[string]bool, len(outputs))
+	for name := range outputs {
+		abs, err := filepath.Abs(name)
+		if err != nil {
+			return nil, err
+		}
+		ret[abs] = true
+	}
+	return ret, nil
+}
+
+// declaredIn returns true if the object was declared in one of the
+// named files, as returned by ownOutputs.
+func (v *visitation) declaredIn(files map[string]bool, obj types.Object) bool {
+	if !obj.Pos().IsValid() {
+		return false
+	}
+	position := v.gen.fileSet.Position(obj.Pos())
+	abs, err := filepath.Abs(position.Filename)
+	return err == nil && files[abs]
+}
+
 // String is for debugging use only.
 func (v *visitation) String() string {
 	return v.Root.String()